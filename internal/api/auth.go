@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// viewerMethods are the RPCs a viewer token may call. Everything else
+// needs admin.
+var viewerMethods = map[string]bool{
+	"GetStatus":      true,
+	"GetRelayStatus": true,
+	"GetRelayStats":  true,
+	"ListProviders":  true,
+	"ListUsers":      true,
+	"GetUser":        true,
+	"ListPublished":  true,
+	"StreamStatus":   true,
+	"StreamLogs":     true,
+}
+
+// authorize checks the token in the call's "authorization" metadata
+// ("Bearer <token>") once access control is on.
+func authorize(ctx context.Context, fullMethod string) error {
+	if !auth.Enabled() {
+		return nil
+	}
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			secret, _ = strings.CutPrefix(v[0], "Bearer ")
+		}
+	}
+	tok, err := auth.Authenticate(secret)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	need := auth.RoleAdmin
+	if viewerMethods[fullMethod[strings.LastIndex(fullMethod, "/")+1:]] {
+		need = auth.RoleViewer
+	}
+	if !tok.Role.Allows(need) {
+		return status.Errorf(codes.PermissionDenied, "%s requires the %s role", fullMethod, need)
+	}
+	return nil
+}
+
+func unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// tokenCredentials sends an API token with every call.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false: the API is reached over localhost.
+func (t tokenCredentials) RequireTransportSecurity() bool { return false }
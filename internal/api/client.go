@@ -2,8 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/logging"
+	"github.com/tunnelwhisperer/tw/internal/ops"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -15,15 +20,20 @@ type Client struct {
 
 // Dial connects to the gRPC API server at the given address.
 // Returns an error if the server is not reachable within 2 seconds.
+// Calls carry the token from TW_API_TOKEN or api.token, if there is one.
 func Dial(addr string) (*Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, addr,
+	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
 		grpc.WithBlock(),
-	)
+	}
+	if token := auth.ClientToken(); token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(token)))
+	}
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +63,15 @@ func (c *Client) TestRelay(ctx context.Context) (*TestRelayResponse, error) {
 	return resp, err
 }
 
+// GetRelayStats calls the GetRelayStats RPC.
+func (c *Client) GetRelayStats(ctx context.Context, pattern string, sys bool) (*ops.RelayStats, error) {
+	resp := &RelayStatsResponse{}
+	if err := c.invoke(ctx, "GetRelayStats", &GetRelayStatsRequest{Pattern: pattern, Sys: sys}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Stats, nil
+}
+
 // ListUsers calls the ListUsers RPC.
 func (c *Client) ListUsers(ctx context.Context) (*ListUsersResponse, error) {
 	resp := &ListUsersResponse{}
@@ -60,6 +79,42 @@ func (c *Client) ListUsers(ctx context.Context) (*ListUsersResponse, error) {
 	return resp, err
 }
 
+// GetUser calls the GetUser RPC.
+func (c *Client) GetUser(ctx context.Context, name string) (*ops.UserDetail, error) {
+	resp := &GetUserResponse{}
+	if err := c.invoke(ctx, "GetUser", &GetUserRequest{Name: name}, resp); err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
+
+// CreateUsers calls the CreateUsers RPC.
+func (c *Client) CreateUsers(ctx context.Context, users []ops.CreateUserRequest) (*CreateUsersResponse, error) {
+	resp := &CreateUsersResponse{}
+	err := c.invoke(ctx, "CreateUsers", &CreateUsersRequest{Users: users}, resp)
+	return resp, err
+}
+
+// UpdateUser calls the UpdateUser RPC.
+func (c *Client) UpdateUser(ctx context.Context, req *UpdateUserRequest) error {
+	return c.invoke(ctx, "UpdateUser", req, &Empty{})
+}
+
+// SetUserDisabled calls the SetUserDisabled RPC.
+func (c *Client) SetUserDisabled(ctx context.Context, name string, disabled bool) error {
+	return c.invoke(ctx, "SetUserDisabled", &SetUserDisabledRequest{Name: name, Disabled: disabled}, &Empty{})
+}
+
+// SetTunnelEnabled calls the SetTunnelEnabled RPC.
+func (c *Client) SetTunnelEnabled(ctx context.Context, tunnel string, enabled bool) error {
+	return c.invoke(ctx, "SetTunnelEnabled", &SetTunnelEnabledRequest{Tunnel: tunnel, Enabled: enabled}, &Empty{})
+}
+
+// SetLogLevel calls the SetLogLevel RPC.
+func (c *Client) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) error {
+	return c.invoke(ctx, "SetLogLevel", req, &Empty{})
+}
+
 // DeleteUser calls the DeleteUser RPC.
 func (c *Client) DeleteUser(ctx context.Context, name string) error {
 	return c.invoke(ctx, "DeleteUser", &DeleteUserRequest{Name: name}, &Empty{})
@@ -79,3 +134,188 @@ func (c *Client) GetUserConfig(ctx context.Context, name string) ([]byte, error)
 	}
 	return resp.Data, nil
 }
+
+// GetUserInvite calls the GetUserInvite RPC and returns the user's claim
+// code and when it expires.
+func (c *Client) GetUserInvite(ctx context.Context, name string, renew bool) (*UserInviteResponse, error) {
+	resp := new(UserInviteResponse)
+	err := c.invoke(ctx, "GetUserInvite", &GetUserInviteRequest{Name: name, Renew: renew}, resp)
+	return resp, err
+}
+
+// UploadClientConfig calls the UploadClientConfig RPC.
+func (c *Client) UploadClientConfig(ctx context.Context, data []byte) error {
+	return c.invoke(ctx, "UploadClientConfig", &UploadClientConfigRequest{Data: data}, &Empty{})
+}
+
+// SetBundleLinks calls the SetBundleLinks RPC, turning bundle links on or
+// off.
+func (c *Client) SetBundleLinks(ctx context.Context, enabled bool) error {
+	return c.invoke(ctx, "SetBundleLinks", &SetBundleLinksRequest{Enabled: enabled}, &Empty{})
+}
+
+// CreateBundleLink calls the CreateBundleLink RPC.
+func (c *Client) CreateBundleLink(ctx context.Context, req *CreateBundleLinkRequest) (*BundleLinkResponse, error) {
+	resp := new(BundleLinkResponse)
+	if err := c.invoke(ctx, "CreateBundleLink", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListBundleLinks calls the ListBundleLinks RPC.
+func (c *Client) ListBundleLinks(ctx context.Context, name string) (*ListBundleLinksResponse, error) {
+	resp := new(ListBundleLinksResponse)
+	if err := c.invoke(ctx, "ListBundleLinks", &ListBundleLinksRequest{Name: name}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RevokeBundleLink calls the RevokeBundleLink RPC.
+func (c *Client) RevokeBundleLink(ctx context.Context, id string) error {
+	return c.invoke(ctx, "RevokeBundleLink", &RevokeBundleLinkRequest{ID: id}, &Empty{})
+}
+
+// ListPublished calls the ListPublished RPC.
+func (c *Client) ListPublished(ctx context.Context) ([]ops.Publication, error) {
+	resp := &ListPublishedResponse{}
+	if err := c.invoke(ctx, "ListPublished", &Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Publications, nil
+}
+
+// Publish calls the Publish RPC.
+func (c *Client) Publish(ctx context.Context, req *PublishRequest) error {
+	return c.invoke(ctx, "Publish", req, &Empty{})
+}
+
+// Unpublish calls the Unpublish RPC.
+func (c *Client) Unpublish(ctx context.Context, req *UnpublishRequest) error {
+	return c.invoke(ctx, "Unpublish", req, &Empty{})
+}
+
+// StartClient calls the StartClient RPC.
+func (c *Client) StartClient(ctx context.Context) error {
+	return c.invoke(ctx, "StartClient", &Empty{}, &Empty{})
+}
+
+// StopClient calls the StopClient RPC.
+func (c *Client) StopClient(ctx context.Context) error {
+	return c.invoke(ctx, "StopClient", &Empty{}, &Empty{})
+}
+
+// StatusStream receives the events of a StreamStatus call.
+type StatusStream struct {
+	cs grpc.ClientStream
+}
+
+// Recv returns the next status event. It returns an error once ctx of the
+// call is done or the daemon goes away.
+func (s *StatusStream) Recv() (*ops.StatusEvent, error) {
+	e := &ops.StatusEvent{}
+	if err := s.cs.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// StreamStatus calls the StreamStatus RPC, which sends the server's and
+// client's status changes until ctx is done.
+func (c *Client) StreamStatus(ctx context.Context) (*StatusStream, error) {
+	desc := &grpc.StreamDesc{StreamName: "StreamStatus", ServerStreams: true}
+	cs, err := c.conn.NewStream(ctx, desc, "/api.v1.TunnelWhisperer/StreamStatus")
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.SendMsg(&Empty{}); err != nil {
+		return nil, err
+	}
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &StatusStream{cs: cs}, nil
+}
+
+// LogStream receives the entries of a StreamLogs call.
+type LogStream struct {
+	cs grpc.ClientStream
+}
+
+// Recv returns the next log entry. It returns io.EOF once a call without
+// Follow has sent the buffered entries, and an error once ctx of the call
+// is done or the daemon goes away.
+func (s *LogStream) Recv() (*logging.Entry, error) {
+	e := &logging.Entry{}
+	if err := s.cs.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// StreamLogs calls the StreamLogs RPC, which sends the daemon's buffered
+// log entries matching req and, with req.Follow, new ones until ctx is
+// done.
+func (c *Client) StreamLogs(ctx context.Context, req *StreamLogsRequest) (*LogStream, error) {
+	desc := &grpc.StreamDesc{StreamName: "StreamLogs", ServerStreams: true}
+	cs, err := c.conn.NewStream(ctx, desc, "/api.v1.TunnelWhisperer/StreamLogs")
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &LogStream{cs: cs}, nil
+}
+
+// streamProgress calls the progress-streaming RPC method with req,
+// passing each ProgressEvent it sends to progress, and returns the
+// operation's error once the stream ends.
+func (c *Client) streamProgress(ctx context.Context, method string, req interface{}, progress ops.ProgressFunc) error {
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true}
+	cs, err := c.conn.NewStream(ctx, desc, "/api.v1.TunnelWhisperer/"+method)
+	if err != nil {
+		return err
+	}
+	if err := cs.SendMsg(req); err != nil {
+		return err
+	}
+	if err := cs.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		e := &ops.ProgressEvent{}
+		err := cs.RecvMsg(e)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(*e)
+		}
+	}
+}
+
+// ProvisionRelayStream calls the ProvisionRelayStream RPC, passing the
+// daemon's progress to progress. Cancelling ctx cancels the provisioning.
+func (c *Client) ProvisionRelayStream(ctx context.Context, req *ProvisionRelayRequest, progress ops.ProgressFunc) error {
+	return c.streamProgress(ctx, "ProvisionRelayStream", req, progress)
+}
+
+// CreateUserStream calls the CreateUserStream RPC, passing the daemon's
+// progress to progress.
+func (c *Client) CreateUserStream(ctx context.Context, req ops.CreateUserRequest, progress ops.ProgressFunc) error {
+	return c.streamProgress(ctx, "CreateUserStream", &req, progress)
+}
+
+// ClaimInvitationStream calls the ClaimInvitationStream RPC, passing the
+// daemon's progress to progress.
+func (c *Client) ClaimInvitationStream(ctx context.Context, code string, progress ops.ProgressFunc) error {
+	return c.streamProgress(ctx, "ClaimInvitationStream", &ClaimInvitationRequest{Code: code}, progress)
+}
@@ -2,9 +2,14 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/tunnelwhisperer/tw/internal/logging"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/version"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -30,9 +35,13 @@ func (h *handler) GetStatus(ctx context.Context, req *Empty) (*StatusResponse, e
 
 	resp := &StatusResponse{
 		Mode:      mode,
-		Version:   "0.1.0-dev",
+		Version:   version.Version,
+		Commit:    version.Commit,
+		BuildDate: version.Date,
 		Relay:     relay,
 		UserCount: len(users),
+
+		KeyFingerprint: ops.KeyFingerprint(),
 	}
 
 	if mode == "server" {
@@ -66,6 +75,14 @@ func (h *handler) GetRelayStatus(ctx context.Context, req *Empty) (*RelayStatusR
 	return &RelayStatusResponse{Relay: h.ops.GetRelayStatus()}, nil
 }
 
+func (h *handler) GetRelayStats(ctx context.Context, req *GetRelayStatsRequest) (*RelayStatsResponse, error) {
+	stats, err := h.ops.GetRelayStats(ctx, req.Pattern, req.Sys)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &RelayStatsResponse{Stats: stats}, nil
+}
+
 func (h *handler) TestCredentials(ctx context.Context, req *TestCredentialsRequest) (*Empty, error) {
 	if err := h.ops.TestCloudCredentials(req.ProviderName, req.Token, req.AWSSecretKey); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
@@ -73,20 +90,50 @@ func (h *handler) TestCredentials(ctx context.Context, req *TestCredentialsReque
 	return &Empty{}, nil
 }
 
-func (h *handler) ProvisionRelay(ctx context.Context, req *ProvisionRelayRequest) (*ProvisionRelayResponse, error) {
-	opsReq := ops.RelayProvisionRequest{
+// streamProgress returns a ProgressFunc that logs events, as
+// slogProgress, and sends them on stream.
+func streamProgress(stream TunnelWhisperer_ProgressServer) ops.ProgressFunc {
+	var mu sync.Mutex
+	return func(e ops.ProgressEvent) {
+		slogProgress(e)
+		mu.Lock()
+		defer mu.Unlock()
+		if err := stream.Send(&e); err != nil {
+			slog.Debug("could not send progress", "error", err)
+		}
+	}
+}
+
+func (req *ProvisionRelayRequest) opsRequest() ops.RelayProvisionRequest {
+	return ops.RelayProvisionRequest{
 		Domain:       req.Domain,
 		ProviderKey:  req.ProviderKey,
 		ProviderName: req.ProviderName,
 		Token:        req.Token,
 		AWSSecretKey: req.AWSSecretKey,
+		Region:       req.Region,
+		InstanceType: req.InstanceType,
+		ACMEDNS:      req.ACMEDNS,
+		CDN:          req.CDN,
 	}
-	if err := h.ops.ProvisionRelay(ctx, opsReq, slogProgress); err != nil {
+}
+
+func (h *handler) ProvisionRelay(ctx context.Context, req *ProvisionRelayRequest) (*ProvisionRelayResponse, error) {
+	if err := h.ops.ProvisionRelay(ctx, req.opsRequest(), slogProgress); err != nil {
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 	return &ProvisionRelayResponse{Message: "relay provisioned"}, nil
 }
 
+// ProvisionRelayStream provisions the relay like ProvisionRelay, sending
+// each step's progress as it happens. The caller going away cancels it.
+func (h *handler) ProvisionRelayStream(req *ProvisionRelayRequest, stream TunnelWhisperer_ProgressServer) error {
+	if err := h.ops.ProvisionRelay(stream.Context(), req.opsRequest(), streamProgress(stream)); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
 func (h *handler) DestroyRelay(ctx context.Context, req *DestroyRelayRequest) (*Empty, error) {
 	if err := h.ops.DestroyRelay(ctx, req.Creds, slogProgress); err != nil {
 		return nil, status.Errorf(codes.Internal, "%v", err)
@@ -138,6 +185,71 @@ func (h *handler) StopClient(ctx context.Context, req *Empty) (*Empty, error) {
 	return &Empty{}, nil
 }
 
+// StreamStatus sends the server's and client's status changes as they
+// happen, until the caller goes away.
+func (h *handler) StreamStatus(req *Empty, stream TunnelWhisperer_StreamStatusServer) error {
+	events, unsubscribe := h.ops.SubscribeStatus()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-events:
+			if err := stream.Send(&e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StreamLogs sends the daemon's buffered log entries that match req, then,
+// if req.Follow is set, new ones as they are logged until the caller goes
+// away.
+func (h *handler) StreamLogs(req *StreamLogsRequest, stream TunnelWhisperer_StreamLogsServer) error {
+	filter, err := logging.NewFilter(req.Level, req.Component, req.Filter)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	filter.Since = req.Since
+	buf := logging.Buffered()
+	var entries <-chan logging.Entry
+	if req.Follow {
+		// Subscribe before the snapshot so no entry falls between them.
+		ch, unsubscribe := buf.Subscribe()
+		defer unsubscribe()
+		entries = ch
+	}
+	var last logging.Entry
+	for _, e := range buf.Snapshot() {
+		last = e
+		if !filter.Match(e) {
+			continue
+		}
+		if err := stream.Send(&e); err != nil {
+			return err
+		}
+	}
+	if !req.Follow {
+		return nil
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			if !e.After(last) || !filter.Match(e) {
+				continue
+			}
+			if err := stream.Send(&e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (h *handler) UploadClientConfig(ctx context.Context, req *UploadClientConfigRequest) (*Empty, error) {
 	if err := h.ops.UploadClientConfig(req.Data); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
@@ -153,14 +265,25 @@ func (h *handler) ListUsers(ctx context.Context, req *Empty) (*ListUsersResponse
 	return &ListUsersResponse{Users: users}, nil
 }
 
+func (h *handler) GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
+	u, err := h.ops.GetUserDetail(ctx, req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	return &GetUserResponse{User: u}, nil
+}
+
 func (h *handler) CreateUser(ctx context.Context, req *CreateUserRequest) (*Empty, error) {
 	mappings := make([]ops.PortMapping, len(req.Mappings))
 	for i, m := range req.Mappings {
-		mappings[i] = ops.PortMapping{ClientPort: m.ClientPort, ServerPort: m.ServerPort}
+		mappings[i] = ops.PortMapping{ClientPort: m.ClientPort, ServerHost: m.ServerHost, ServerPort: m.ServerPort}
 	}
 	opsReq := ops.CreateUserRequest{
-		Name:     req.Name,
-		Mappings: mappings,
+		Name:      req.Name,
+		Mappings:  mappings,
+		Permit:    req.Permit,
+		PublicKey: req.PublicKey,
+		Invite:    req.Invite,
 	}
 	if err := h.ops.CreateUser(ctx, opsReq, slogProgress); err != nil {
 		return nil, status.Errorf(codes.Internal, "%v", err)
@@ -168,6 +291,83 @@ func (h *handler) CreateUser(ctx context.Context, req *CreateUserRequest) (*Empt
 	return &Empty{}, nil
 }
 
+// CreateUserStream creates a user, with all of CreateUserRequest's
+// options, sending each step's progress as it happens.
+func (h *handler) CreateUserStream(req *ops.CreateUserRequest, stream TunnelWhisperer_ProgressServer) error {
+	if req.SecurityKey {
+		return status.Errorf(codes.InvalidArgument, "a security key user must be created where the key is plugged in")
+	}
+	if err := h.ops.CreateUser(stream.Context(), *req, streamProgress(stream)); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
+func (h *handler) CreateUsers(ctx context.Context, req *CreateUsersRequest) (*CreateUsersResponse, error) {
+	var results []CreateUserResult
+	progress := func(e ops.ProgressEvent) {
+		slogProgress(e)
+		if e.Step < 2 || e.Status == "running" {
+			return
+		}
+		results = append(results, CreateUserResult{Name: e.Label, Status: e.Status, Message: e.Message, Error: e.Error})
+	}
+	err := h.ops.CreateUsers(ctx, req.Users, progress)
+	if err != nil && len(results) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	msg := fmt.Sprintf("created %d users", len(req.Users))
+	if err != nil {
+		msg = err.Error()
+	}
+	return &CreateUsersResponse{Message: msg, Results: results}, nil
+}
+
+func (h *handler) UpdateUser(ctx context.Context, req *UpdateUserRequest) (*Empty, error) {
+	opsReq := ops.UpdateUserRequest{
+		Name:     req.Name,
+		NewName:  req.NewName,
+		Mappings: req.Mappings,
+		Permit:   req.Permit,
+	}
+	if err := h.ops.UpdateUser(opsReq); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+func (h *handler) SetUserDisabled(ctx context.Context, req *SetUserDisabledRequest) (*Empty, error) {
+	var err error
+	if req.Disabled {
+		err = h.ops.DisableUser(ctx, req.Name, slogProgress)
+	} else {
+		err = h.ops.EnableUser(ctx, req.Name, slogProgress)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+func (h *handler) SetTunnelEnabled(ctx context.Context, req *SetTunnelEnabledRequest) (*Empty, error) {
+	if err := h.ops.SetTunnelEnabled(req.Tunnel, req.Enabled); err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+func (h *handler) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*Empty, error) {
+	if err := h.ops.SetLogLevel(req.LogLevel); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if req.XrayLogLevel != nil {
+		if err := h.ops.SetXrayLogLevel(*req.XrayLogLevel); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+	return &Empty{}, nil
+}
+
 func (h *handler) DeleteUser(ctx context.Context, req *DeleteUserRequest) (*Empty, error) {
 	if err := h.ops.DeleteUser(req.Name); err != nil {
 		return nil, status.Errorf(codes.Internal, "%v", err)
@@ -182,3 +382,86 @@ func (h *handler) GetUserConfig(ctx context.Context, req *GetUserConfigRequest)
 	}
 	return &UserConfigResponse{Data: data}, nil
 }
+
+func (h *handler) GetUserInvite(ctx context.Context, req *GetUserInviteRequest) (*UserInviteResponse, error) {
+	code, expires, err := h.ops.InviteCode(req.Name, req.Renew)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	return &UserInviteResponse{Code: code, Expires: expires}, nil
+}
+
+func (h *handler) SetBundleLinks(ctx context.Context, req *SetBundleLinksRequest) (*Empty, error) {
+	var err error
+	if req.Enabled {
+		err = h.ops.EnableBundleLinks(ctx, slogProgress)
+	} else {
+		err = h.ops.DisableBundleLinks(ctx, slogProgress)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+func (h *handler) CreateBundleLink(ctx context.Context, req *CreateBundleLinkRequest) (*BundleLinkResponse, error) {
+	link, url, err := h.ops.CreateBundleLink(req.Name, time.Duration(req.ExpiresHours)*time.Hour, req.MaxUses)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return &BundleLinkResponse{Link: *link, URL: url}, nil
+}
+
+func (h *handler) ListBundleLinks(ctx context.Context, req *ListBundleLinksRequest) (*ListBundleLinksResponse, error) {
+	links, err := h.ops.ListBundleLinks(req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &ListBundleLinksResponse{Enabled: h.ops.BundleLinksEnabled(), Links: links}, nil
+}
+
+func (h *handler) RevokeBundleLink(ctx context.Context, req *RevokeBundleLinkRequest) (*Empty, error) {
+	if err := h.ops.RevokeBundleLink(req.ID); err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+// ClaimInvitationStream claims an invitation as this client, sending each
+// step's progress as it happens.
+func (h *handler) ClaimInvitationStream(req *ClaimInvitationRequest, stream TunnelWhisperer_ProgressServer) error {
+	if err := h.ops.ClaimInvitation(req.Code, streamProgress(stream)); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return nil
+}
+
+func (h *handler) ListPublished(ctx context.Context, req *Empty) (*ListPublishedResponse, error) {
+	return &ListPublishedResponse{Publications: h.ops.ListPublished()}, nil
+}
+
+func (h *handler) Publish(ctx context.Context, req *PublishRequest) (*Empty, error) {
+	var err error
+	if req.Host != "" {
+		err = h.ops.PublishHost(ctx, req.Name, req.Host, req.Target, req.UpstreamTLS, slogProgress)
+	} else {
+		err = h.ops.Publish(ctx, req.Name, req.PublicPort, req.Target, slogProgress)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &Empty{}, nil
+}
+
+func (h *handler) Unpublish(ctx context.Context, req *UnpublishRequest) (*Empty, error) {
+	var err error
+	if req.Host != "" {
+		err = h.ops.UnpublishHost(ctx, req.Host, slogProgress)
+	} else {
+		err = h.ops.Unpublish(ctx, req.PublicPort, slogProgress)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &Empty{}, nil
+}
@@ -4,6 +4,8 @@ import (
 	"log/slog"
 	"net"
 
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/logging"
 	"github.com/tunnelwhisperer/tw/internal/ops"
 	"google.golang.org/grpc"
 )
@@ -15,8 +17,18 @@ type Server struct {
 	gs   *grpc.Server
 }
 
+// NewServer creates the gRPC server. Once API tokens exist, every call
+// needs one: viewer tokens may call the read-only RPCs, admin tokens all of
+// them.
 func NewServer(o *ops.Ops, addr string) *Server {
-	gs := grpc.NewServer()
+	if err := auth.EnsureLocalToken(); err != nil {
+		slog.Warn("could not write the local API token", "error", err)
+	}
+	logging.Buffered() // for StreamLogs
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryAuth),
+		grpc.StreamInterceptor(streamAuth),
+	)
 	s := &Server{
 		ops:  o,
 		addr: addr,
@@ -7,7 +7,9 @@ package api
 
 import (
 	"context"
+	"time"
 
+	"github.com/tunnelwhisperer/tw/internal/logging"
 	"github.com/tunnelwhisperer/tw/internal/ops"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -19,12 +21,16 @@ import (
 type Empty struct{}
 
 type StatusResponse struct {
-	Mode      string             `json:"mode"`
-	Version   string             `json:"version"`
-	Relay     ops.RelayStatus    `json:"relay"`
-	UserCount int                `json:"user_count"`
-	Server    *ops.ServerStatus  `json:"server,omitempty"`
-	Client    *ops.ClientStatus  `json:"client,omitempty"`
+	Mode      string            `json:"mode"`
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit,omitempty"`
+	BuildDate string            `json:"build_date,omitempty"`
+	Relay     ops.RelayStatus   `json:"relay"`
+	UserCount int               `json:"user_count"`
+	Server    *ops.ServerStatus `json:"server,omitempty"`
+	Client    *ops.ClientStatus `json:"client,omitempty"`
+	// KeyFingerprint is the SHA256 fingerprint of this machine's SSH key.
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
 }
 
 type ConfigResponse struct {
@@ -43,6 +49,23 @@ type RelayStatusResponse struct {
 	Relay interface{} `json:"relay"`
 }
 
+type StreamLogsRequest struct {
+	Level     string    `json:"level,omitempty"`     // debug, info, warn or error
+	Component string    `json:"component,omitempty"` // comma-separated
+	Filter    string    `json:"filter,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Follow    bool      `json:"follow,omitempty"`
+}
+
+type GetRelayStatsRequest struct {
+	Pattern string `json:"pattern,omitempty"`
+	Sys     bool   `json:"sys,omitempty"`
+}
+
+type RelayStatsResponse struct {
+	Stats *ops.RelayStats `json:"stats"`
+}
+
 type TestCredentialsRequest struct {
 	ProviderName string `json:"provider_name"`
 	Token        string `json:"token"`
@@ -50,11 +73,15 @@ type TestCredentialsRequest struct {
 }
 
 type ProvisionRelayRequest struct {
-	Domain       string `json:"domain"`
-	ProviderKey  string `json:"provider_key"`
-	ProviderName string `json:"provider_name"`
-	Token        string `json:"token"`
-	AWSSecretKey string `json:"aws_secret_key"`
+	Domain       string      `json:"domain"`
+	ProviderKey  string      `json:"provider_key"`
+	ProviderName string      `json:"provider_name"`
+	Token        string      `json:"token"`
+	AWSSecretKey string      `json:"aws_secret_key"`
+	Region       string      `json:"region"`
+	InstanceType string      `json:"instance_type"`
+	ACMEDNS      ops.ACMEDNS `json:"acme_dns"`
+	CDN          bool        `json:"cdn"`
 }
 
 type ProvisionRelayResponse struct {
@@ -81,12 +108,62 @@ type ListUsersResponse struct {
 	Users []ops.UserInfo `json:"users"`
 }
 
+type GetUserRequest struct {
+	Name string `json:"name"`
+}
+
+type GetUserResponse struct {
+	User *ops.UserDetail `json:"user"`
+}
+
 type CreateUserRequest struct {
 	Name     string `json:"name"`
 	Mappings []struct {
-		ClientPort int `json:"client_port"`
-		ServerPort int `json:"server_port"`
+		ClientPort int    `json:"client_port"`
+		ServerHost string `json:"server_host,omitempty"`
+		ServerPort int    `json:"server_port"`
 	} `json:"mappings"`
+	Permit    []string `json:"permit,omitempty"`
+	PublicKey string   `json:"public_key,omitempty"` // the user's own key, instead of a generated one
+	Invite    bool     `json:"invite,omitempty"`     // no key: the user claims the account with a claim code
+}
+
+type CreateUsersRequest struct {
+	Users []ops.CreateUserRequest `json:"users"`
+}
+
+type CreateUsersResponse struct {
+	Message string             `json:"message"`
+	Results []CreateUserResult `json:"results,omitempty"`
+}
+
+type CreateUserResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // "completed" or "failed"
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	Name     string            `json:"name"`
+	NewName  string            `json:"new_name,omitempty"`
+	Mappings []ops.PortMapping `json:"mappings,omitempty"`
+	Permit   []string          `json:"permit"` // nil keeps the current patterns
+}
+
+type SetUserDisabledRequest struct {
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+}
+
+type SetTunnelEnabledRequest struct {
+	Tunnel  string `json:"tunnel"` // name or local port
+	Enabled bool   `json:"enabled"`
+}
+
+type SetLogLevelRequest struct {
+	LogLevel     string  `json:"log_level"`
+	XrayLogLevel *string `json:"xray_log_level,omitempty"` // "" follows log_level; nil leaves it
 }
 
 type DeleteUserRequest struct {
@@ -105,6 +182,65 @@ type UploadClientConfigRequest struct {
 	Data []byte `json:"data"`
 }
 
+type GetUserInviteRequest struct {
+	Name  string `json:"name"`
+	Renew bool   `json:"renew,omitempty"` // replace the claim code with a new one
+}
+
+type UserInviteResponse struct {
+	Code    string    `json:"code"`
+	Expires time.Time `json:"expires"`
+}
+
+type ClaimInvitationRequest struct {
+	Code string `json:"code"`
+}
+
+type SetBundleLinksRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type CreateBundleLinkRequest struct {
+	Name         string `json:"name"`
+	ExpiresHours int    `json:"expires_hours,omitempty"` // 0: 24 hours
+	MaxUses      int    `json:"max_uses,omitempty"`      // 0: any number until it expires
+}
+
+type BundleLinkResponse struct {
+	Link ops.BundleLink `json:"link"`
+	URL  string         `json:"url"` // shown once; only its hash is kept
+}
+
+type ListBundleLinksRequest struct {
+	Name string `json:"name,omitempty"` // empty: every user's
+}
+
+type ListBundleLinksResponse struct {
+	Enabled bool             `json:"enabled"`
+	Links   []ops.BundleLink `json:"links"`
+}
+
+type RevokeBundleLinkRequest struct {
+	ID string `json:"id"`
+}
+
+type ListPublishedResponse struct {
+	Publications []ops.Publication `json:"publications"`
+}
+
+type PublishRequest struct {
+	Name        string `json:"name,omitempty"`
+	PublicPort  int    `json:"public_port,omitempty"`
+	Host        string `json:"host,omitempty"` // publish as https://Host instead of on PublicPort
+	Target      string `json:"target"`
+	UpstreamTLS bool   `json:"upstream_tls,omitempty"`
+}
+
+type UnpublishRequest struct {
+	PublicPort int    `json:"public_port,omitempty"`
+	Host       string `json:"host,omitempty"`
+}
+
 // ── Service interface ───────────────────────────────────────────────────────
 
 type TunnelWhispererServer interface {
@@ -113,6 +249,7 @@ type TunnelWhispererServer interface {
 	SetMode(ctx context.Context, req *SetModeRequest) (*Empty, error)
 	ListProviders(ctx context.Context, req *Empty) (*ListProvidersResponse, error)
 	GetRelayStatus(ctx context.Context, req *Empty) (*RelayStatusResponse, error)
+	GetRelayStats(ctx context.Context, req *GetRelayStatsRequest) (*RelayStatsResponse, error)
 	TestCredentials(ctx context.Context, req *TestCredentialsRequest) (*Empty, error)
 	ProvisionRelay(ctx context.Context, req *ProvisionRelayRequest) (*ProvisionRelayResponse, error)
 	DestroyRelay(ctx context.Context, req *DestroyRelayRequest) (*Empty, error)
@@ -123,9 +260,72 @@ type TunnelWhispererServer interface {
 	StopClient(ctx context.Context, req *Empty) (*Empty, error)
 	UploadClientConfig(ctx context.Context, req *UploadClientConfigRequest) (*Empty, error)
 	ListUsers(ctx context.Context, req *Empty) (*ListUsersResponse, error)
+	GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error)
 	CreateUser(ctx context.Context, req *CreateUserRequest) (*Empty, error)
+	CreateUsers(ctx context.Context, req *CreateUsersRequest) (*CreateUsersResponse, error)
+	UpdateUser(ctx context.Context, req *UpdateUserRequest) (*Empty, error)
+	SetUserDisabled(ctx context.Context, req *SetUserDisabledRequest) (*Empty, error)
+	SetTunnelEnabled(ctx context.Context, req *SetTunnelEnabledRequest) (*Empty, error)
+	SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*Empty, error)
 	DeleteUser(ctx context.Context, req *DeleteUserRequest) (*Empty, error)
 	GetUserConfig(ctx context.Context, req *GetUserConfigRequest) (*UserConfigResponse, error)
+	GetUserInvite(ctx context.Context, req *GetUserInviteRequest) (*UserInviteResponse, error)
+	SetBundleLinks(ctx context.Context, req *SetBundleLinksRequest) (*Empty, error)
+	CreateBundleLink(ctx context.Context, req *CreateBundleLinkRequest) (*BundleLinkResponse, error)
+	ListBundleLinks(ctx context.Context, req *ListBundleLinksRequest) (*ListBundleLinksResponse, error)
+	RevokeBundleLink(ctx context.Context, req *RevokeBundleLinkRequest) (*Empty, error)
+	ListPublished(ctx context.Context, req *Empty) (*ListPublishedResponse, error)
+	Publish(ctx context.Context, req *PublishRequest) (*Empty, error)
+	Unpublish(ctx context.Context, req *UnpublishRequest) (*Empty, error)
+	StreamStatus(req *Empty, stream TunnelWhisperer_StreamStatusServer) error
+	StreamLogs(req *StreamLogsRequest, stream TunnelWhisperer_StreamLogsServer) error
+	ProvisionRelayStream(req *ProvisionRelayRequest, stream TunnelWhisperer_ProgressServer) error
+	CreateUserStream(req *ops.CreateUserRequest, stream TunnelWhisperer_ProgressServer) error
+	ClaimInvitationStream(req *ClaimInvitationRequest, stream TunnelWhisperer_ProgressServer) error
+}
+
+// TunnelWhisperer_StreamStatusServer is the server side of StreamStatus.
+type TunnelWhisperer_StreamStatusServer interface {
+	Send(*ops.StatusEvent) error
+	grpc.ServerStream
+}
+
+type streamStatusServer struct {
+	grpc.ServerStream
+}
+
+func (s *streamStatusServer) Send(e *ops.StatusEvent) error {
+	return s.ServerStream.SendMsg(e)
+}
+
+// TunnelWhisperer_StreamLogsServer is the server side of StreamLogs.
+type TunnelWhisperer_StreamLogsServer interface {
+	Send(*logging.Entry) error
+	grpc.ServerStream
+}
+
+type streamLogsServer struct {
+	grpc.ServerStream
+}
+
+func (s *streamLogsServer) Send(e *logging.Entry) error {
+	return s.ServerStream.SendMsg(e)
+}
+
+// TunnelWhisperer_ProgressServer is the server side of the RPCs that
+// stream an operation's progress: ProvisionRelayStream and
+// CreateUserStream.
+type TunnelWhisperer_ProgressServer interface {
+	Send(*ops.ProgressEvent) error
+	grpc.ServerStream
+}
+
+type progressServer struct {
+	grpc.ServerStream
+}
+
+func (s *progressServer) Send(e *ops.ProgressEvent) error {
+	return s.ServerStream.SendMsg(e)
 }
 
 // ── Registration ────────────────────────────────────────────────────────────
@@ -167,6 +367,13 @@ func RegisterTunnelWhispererServer(s *grpc.Server, srv TunnelWhispererServer) {
 			}
 			return srv.(TunnelWhispererServer).GetRelayStatus(ctx, req)
 		}),
+		unaryMethod("GetRelayStats", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(GetRelayStatsRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).GetRelayStats(ctx, req)
+		}),
 		unaryMethod("TestCredentials", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 			req := new(TestCredentialsRequest)
 			if err := dec(req); err != nil {
@@ -237,6 +444,13 @@ func RegisterTunnelWhispererServer(s *grpc.Server, srv TunnelWhispererServer) {
 			}
 			return srv.(TunnelWhispererServer).ListUsers(ctx, req)
 		}),
+		unaryMethod("GetUser", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(GetUserRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).GetUser(ctx, req)
+		}),
 		unaryMethod("CreateUser", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 			req := new(CreateUserRequest)
 			if err := dec(req); err != nil {
@@ -244,6 +458,41 @@ func RegisterTunnelWhispererServer(s *grpc.Server, srv TunnelWhispererServer) {
 			}
 			return srv.(TunnelWhispererServer).CreateUser(ctx, req)
 		}),
+		unaryMethod("CreateUsers", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(CreateUsersRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).CreateUsers(ctx, req)
+		}),
+		unaryMethod("UpdateUser", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(UpdateUserRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).UpdateUser(ctx, req)
+		}),
+		unaryMethod("SetUserDisabled", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(SetUserDisabledRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).SetUserDisabled(ctx, req)
+		}),
+		unaryMethod("SetTunnelEnabled", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(SetTunnelEnabledRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).SetTunnelEnabled(ctx, req)
+		}),
+		unaryMethod("SetLogLevel", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(SetLogLevelRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).SetLogLevel(ctx, req)
+		}),
 		unaryMethod("DeleteUser", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 			req := new(DeleteUserRequest)
 			if err := dec(req); err != nil {
@@ -258,13 +507,125 @@ func RegisterTunnelWhispererServer(s *grpc.Server, srv TunnelWhispererServer) {
 			}
 			return srv.(TunnelWhispererServer).GetUserConfig(ctx, req)
 		}),
+		unaryMethod("GetUserInvite", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(GetUserInviteRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).GetUserInvite(ctx, req)
+		}),
+		unaryMethod("SetBundleLinks", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(SetBundleLinksRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).SetBundleLinks(ctx, req)
+		}),
+		unaryMethod("CreateBundleLink", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(CreateBundleLinkRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).CreateBundleLink(ctx, req)
+		}),
+		unaryMethod("ListBundleLinks", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(ListBundleLinksRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).ListBundleLinks(ctx, req)
+		}),
+		unaryMethod("RevokeBundleLink", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(RevokeBundleLinkRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).RevokeBundleLink(ctx, req)
+		}),
+		unaryMethod("ListPublished", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(Empty)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).ListPublished(ctx, req)
+		}),
+		unaryMethod("Publish", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(PublishRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).Publish(ctx, req)
+		}),
+		unaryMethod("Unpublish", func(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			req := new(UnpublishRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(TunnelWhispererServer).Unpublish(ctx, req)
+		}),
 	}
 
 	sd := grpc.ServiceDesc{
 		ServiceName: "api.v1.TunnelWhisperer",
 		HandlerType: (*TunnelWhispererServer)(nil),
 		Methods:     methods,
-		Streams:     []grpc.StreamDesc{},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "StreamStatus",
+				ServerStreams: true,
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					req := new(Empty)
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return srv.(TunnelWhispererServer).StreamStatus(req, &streamStatusServer{stream})
+				},
+			},
+			{
+				StreamName:    "StreamLogs",
+				ServerStreams: true,
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					req := new(StreamLogsRequest)
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return srv.(TunnelWhispererServer).StreamLogs(req, &streamLogsServer{stream})
+				},
+			},
+			{
+				StreamName:    "ProvisionRelayStream",
+				ServerStreams: true,
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					req := new(ProvisionRelayRequest)
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return srv.(TunnelWhispererServer).ProvisionRelayStream(req, &progressServer{stream})
+				},
+			},
+			{
+				StreamName:    "CreateUserStream",
+				ServerStreams: true,
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					req := new(ops.CreateUserRequest)
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return srv.(TunnelWhispererServer).CreateUserStream(req, &progressServer{stream})
+				},
+			},
+			{
+				StreamName:    "ClaimInvitationStream",
+				ServerStreams: true,
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					req := new(ClaimInvitationRequest)
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return srv.(TunnelWhispererServer).ClaimInvitationStream(req, &progressServer{stream})
+				},
+			},
+		},
 	}
 	s.RegisterService(&sd, srv)
 }
@@ -304,6 +665,9 @@ func (UnimplementedTunnelWhispererServer) ListProviders(context.Context, *Empty)
 func (UnimplementedTunnelWhispererServer) GetRelayStatus(context.Context, *Empty) (*RelayStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
+func (UnimplementedTunnelWhispererServer) GetRelayStats(context.Context, *GetRelayStatsRequest) (*RelayStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
 func (UnimplementedTunnelWhispererServer) TestCredentials(context.Context, *TestCredentialsRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
@@ -334,12 +698,69 @@ func (UnimplementedTunnelWhispererServer) UploadClientConfig(context.Context, *U
 func (UnimplementedTunnelWhispererServer) ListUsers(context.Context, *Empty) (*ListUsersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
+func (UnimplementedTunnelWhispererServer) GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
 func (UnimplementedTunnelWhispererServer) CreateUser(context.Context, *CreateUserRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
+func (UnimplementedTunnelWhispererServer) CreateUsers(context.Context, *CreateUsersRequest) (*CreateUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) UpdateUser(context.Context, *UpdateUserRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) SetUserDisabled(context.Context, *SetUserDisabledRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) SetTunnelEnabled(context.Context, *SetTunnelEnabledRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) SetLogLevel(context.Context, *SetLogLevelRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
 func (UnimplementedTunnelWhispererServer) DeleteUser(context.Context, *DeleteUserRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
 func (UnimplementedTunnelWhispererServer) GetUserConfig(context.Context, *GetUserConfigRequest) (*UserConfigResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "not implemented")
 }
+func (UnimplementedTunnelWhispererServer) GetUserInvite(context.Context, *GetUserInviteRequest) (*UserInviteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) SetBundleLinks(context.Context, *SetBundleLinksRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) CreateBundleLink(context.Context, *CreateBundleLinkRequest) (*BundleLinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) ListBundleLinks(context.Context, *ListBundleLinksRequest) (*ListBundleLinksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) RevokeBundleLink(context.Context, *RevokeBundleLinkRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) ListPublished(context.Context, *Empty) (*ListPublishedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) Publish(context.Context, *PublishRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) Unpublish(context.Context, *UnpublishRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) StreamStatus(*Empty, TunnelWhisperer_StreamStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) StreamLogs(*StreamLogsRequest, TunnelWhisperer_StreamLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) ProvisionRelayStream(*ProvisionRelayRequest, TunnelWhisperer_ProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) CreateUserStream(*ops.CreateUserRequest, TunnelWhisperer_ProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "not implemented")
+}
+func (UnimplementedTunnelWhispererServer) ClaimInvitationStream(*ClaimInvitationRequest, TunnelWhisperer_ProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "not implemented")
+}
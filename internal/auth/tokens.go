@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// Role is what an API token may do.
+type Role string
+
+const (
+	// RoleAdmin has full control.
+	RoleAdmin Role = "admin"
+	// RoleViewer can read status, users, and logs but change nothing.
+	RoleViewer Role = "viewer"
+)
+
+// Roles lists the known roles, most privileged first.
+var Roles = []Role{RoleAdmin, RoleViewer}
+
+// ParseRole checks a role name.
+func ParseRole(s string) (Role, error) {
+	for _, r := range Roles {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown role %q (use admin or viewer)", s)
+}
+
+// Allows reports whether a token with role r may do what need requires.
+func (r Role) Allows(need Role) bool {
+	return r == RoleAdmin || r == need
+}
+
+// localTokenName names the admin token the daemon keeps in api.token for
+// the CLI on the same machine.
+const localTokenName = "local"
+
+var (
+	// ErrUnauthorized is returned for a missing, unknown, or expired token.
+	ErrUnauthorized = errors.New("invalid or expired API token")
+
+	mu        sync.Mutex
+	validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+)
+
+// Token describes an API token. The secret itself is shown once, when the
+// token is created; only its hash is kept.
+type Token struct {
+	Name      string     `json:"name"`
+	Role      Role       `json:"role"`
+	Prefix    string     `json:"prefix"` // first characters of the secret, to tell tokens apart
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the token has passed its expiry.
+func (t Token) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// storedToken is a Token as kept in tokens.json.
+type storedToken struct {
+	Token
+	Hash string `json:"hash"` // hex SHA-256 of the secret
+}
+
+// TokensPath returns the path to the API token store.
+func TokensPath() string {
+	return filepath.Join(config.Dir(), "tokens.json")
+}
+
+// LocalTokenPath returns the path to the local admin token.
+func LocalTokenPath() string {
+	return filepath.Join(config.Dir(), "api.token")
+}
+
+// Enabled reports whether access control is on: it is once any token
+// exists. Until then the dashboard and API are open, as before.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	return err != nil || len(tokens) > 0
+}
+
+// ListTokens returns the tokens, sorted by name.
+func ListTokens() ([]Token, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		list = append(list, t.Token)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// CreateToken creates a token and returns its secret, which is not stored
+// and can't be shown again. A ttl of zero means the token never expires.
+func CreateToken(name string, role Role, ttl time.Duration) (string, Token, error) {
+	if !validName.MatchString(name) {
+		return "", Token{}, fmt.Errorf("invalid token name %q", name)
+	}
+	if name == localTokenName {
+		return "", Token{}, fmt.Errorf("token name %q is reserved for the CLI", name)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return "", Token{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	if err != nil {
+		return "", Token{}, err
+	}
+	for _, t := range tokens {
+		if t.Name == name {
+			return "", Token{}, fmt.Errorf("token %q already exists", name)
+		}
+	}
+	secret, st, err := newToken(name, role, ttl)
+	if err != nil {
+		return "", Token{}, err
+	}
+	tokens, local, err := withLocalToken(append(tokens, st))
+	if err != nil {
+		return "", Token{}, err
+	}
+	if err := save(tokens); err != nil {
+		return "", Token{}, err
+	}
+	if err := writeLocalToken(local); err != nil {
+		return "", Token{}, err
+	}
+	return secret, st.Token, nil
+}
+
+// RevokeToken deletes a token.
+func RevokeToken(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	if err != nil {
+		return err
+	}
+	kept := tokens[:0]
+	for _, t := range tokens {
+		if t.Name != name {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(tokens) {
+		return fmt.Errorf("token %q not found", name)
+	}
+	if err := save(kept); err != nil {
+		return err
+	}
+	if name == localTokenName {
+		os.Remove(LocalTokenPath())
+	}
+	return nil
+}
+
+// Authenticate returns the token a secret belongs to.
+func Authenticate(secret string) (Token, error) {
+	if secret == "" {
+		return Token{}, ErrUnauthorized
+	}
+	sum := sha256.Sum256([]byte(secret))
+	hash := hex.EncodeToString(sum[:])
+
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	if err != nil {
+		return Token{}, err
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			if t.Expired() {
+				return Token{}, ErrUnauthorized
+			}
+			return t.Token, nil
+		}
+	}
+	return Token{}, ErrUnauthorized
+}
+
+// EnsureLocalToken keeps an admin token in api.token, readable by its
+// owner only, so the CLI can reach the daemon once access control is on.
+// It does nothing while no tokens exist.
+func EnsureLocalToken() error {
+	mu.Lock()
+	defer mu.Unlock()
+	tokens, err := load()
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+	tokens, local, err := withLocalToken(tokens)
+	if err != nil || local == "" {
+		return err
+	}
+	if err := save(tokens); err != nil {
+		return err
+	}
+	return writeLocalToken(local)
+}
+
+// withLocalToken replaces the local token when api.token is missing or
+// doesn't match it. It returns the new secret, or "" when api.token is
+// still good.
+func withLocalToken(tokens []storedToken) ([]storedToken, string, error) {
+	if data, err := os.ReadFile(LocalTokenPath()); err == nil {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(string(data))))
+		for _, t := range tokens {
+			if t.Name == localTokenName && t.Hash == hex.EncodeToString(sum[:]) {
+				return tokens, "", nil
+			}
+		}
+	}
+
+	kept := make([]storedToken, 0, len(tokens)+1)
+	for _, t := range tokens {
+		if t.Name != localTokenName {
+			kept = append(kept, t)
+		}
+	}
+	secret, st, err := newToken(localTokenName, RoleAdmin, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(kept, st), secret, nil
+}
+
+// writeLocalToken writes the local token's secret to api.token, unless it
+// is "".
+func writeLocalToken(secret string) error {
+	if secret == "" {
+		return nil
+	}
+	if err := os.WriteFile(LocalTokenPath(), []byte(secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", LocalTokenPath(), err)
+	}
+	return nil
+}
+
+// ClientToken returns the token the CLI presents to the daemon: TW_API_TOKEN
+// if set, otherwise the local admin token, or "" when there is neither.
+func ClientToken() string {
+	if v := os.Getenv("TW_API_TOKEN"); v != "" {
+		return v
+	}
+	data, err := os.ReadFile(LocalTokenPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// newToken generates a secret ("tw_" and 32 random bytes) and its stored
+// form.
+func newToken(name string, role Role, ttl time.Duration) (string, storedToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", storedToken{}, fmt.Errorf("generating token: %w", err)
+	}
+	secret := "tw_" + base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(secret))
+
+	st := storedToken{
+		Token: Token{
+			Name:      name,
+			Role:      role,
+			Prefix:    secret[:9],
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		},
+		Hash: hex.EncodeToString(sum[:]),
+	}
+	if ttl > 0 {
+		exp := st.CreatedAt.Add(ttl)
+		st.ExpiresAt = &exp
+	}
+	return secret, st, nil
+}
+
+func load() ([]storedToken, error) {
+	data, err := os.ReadFile(TokensPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", TokensPath(), err)
+	}
+	var tokens []storedToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", TokensPath(), err)
+	}
+	return tokens, nil
+}
+
+func save(tokens []storedToken) error {
+	// Only the local token left means nobody else can sign in: turn access
+	// control off again rather than lock everyone out.
+	if len(tokens) == 0 || (len(tokens) == 1 && tokens[0].Name == localTokenName) {
+		os.Remove(LocalTokenPath())
+		if err := os.Remove(TokensPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing %s: %w", TokensPath(), err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding tokens: %w", err)
+	}
+	if err := os.MkdirAll(config.Dir(), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := fileutil.WriteFile(TokensPath(), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", TokensPath(), err)
+	}
+	return nil
+}
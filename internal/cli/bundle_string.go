@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// bundlePassphraseEnv holds the passphrase of an encrypted compact bundle
+// string, for tw export user --compact --encrypt and tw connect --import
+// without a terminal.
+const bundlePassphraseEnv = "TW_BUNDLE_PASSPHRASE"
+
+// readBundlePassphrase returns the passphrase of a compact bundle string,
+// from bundlePassphraseEnv or asked for on the terminal, twice when
+// confirm is set.
+func readBundlePassphrase(confirm bool) (string, error) {
+	if p := os.Getenv(bundlePassphraseEnv); p != "" {
+		return p, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("no terminal to ask for the passphrase on; set %s", bundlePassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "  Passphrase: ")
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	if len(p) == 0 {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "  Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading passphrase: %w", err)
+		}
+		if string(again) != string(p) {
+			return "", fmt.Errorf("the passphrases differ")
+		}
+	}
+	return string(p), nil
+}
+
+// readBundleString returns the compact string arg, a bundle string or a
+// claim code as what says, read from stdin when it is "-". Pasted into a
+// terminal, it ends at an empty line.
+func readBundleString(arg, what string) (string, error) {
+	if arg != "-" {
+		return arg, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", what, err)
+		}
+		return string(data), nil
+	}
+	fmt.Fprintf(os.Stderr, "  Paste the %s, then press Enter on an empty line:\n", what)
+	var b strings.Builder
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" && b.Len() > 0 {
+			break
+		}
+		b.WriteString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading %s: %w", what, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+)
+
+// Shell completion of resource names. Names come from the running daemon
+// when there is one, and otherwise from the config directory, like the
+// commands themselves; a completion that fails completes nothing.
+
+// completeTimeout bounds how long a completion waits for the daemon.
+const completeTimeout = 2 * time.Second
+
+// completeArgs returns a ValidArgsFunction completing a command's first
+// argument with names and its second with then.
+func completeArgs(names func() []string, then ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return names(), cobra.ShellCompDirectiveNoFileComp
+		case 1:
+			return then, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeFlag returns a flag completion function completing with names.
+func completeFlag(names func() []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return names(), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completionClient returns a client of the running daemon, or nil. Unlike
+// daemonClient it doesn't wait for a daemon that isn't running.
+func completionClient() *api.Client {
+	if _, ok := ops.RunningInstance(); !ok {
+		return nil
+	}
+	cfg, _ := config.Load()
+	return daemonClient(cfg)
+}
+
+// userNames returns the names of the server's users.
+func userNames() []string {
+	var users []ops.UserInfo
+	if client := completionClient(); client != nil {
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+		defer cancel()
+		resp, err := client.ListUsers(ctx)
+		if err != nil {
+			return nil
+		}
+		users = resp.Users
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return nil
+		}
+		users, _ = o.ListUsers()
+	}
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	return names
+}
+
+// tunnelNames returns the client's tunnels, by name or else local port.
+func tunnelNames() []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, t := range cfg.Client.Tunnels {
+		if t.Name != "" {
+			names = append(names, t.Name)
+		} else {
+			names = append(names, strconv.Itoa(t.LocalPort))
+		}
+	}
+	return names
+}
+
+// templateNames returns the mapping templates' names.
+func templateNames() []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.Server.Templates))
+	for _, t := range cfg.Server.Templates {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// publishedNames returns the published ports and hostnames, as tw publish
+// remove takes them.
+func publishedNames() []string {
+	var pubs []ops.Publication
+	if client := completionClient(); client != nil {
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+		defer cancel()
+		var err error
+		if pubs, err = client.ListPublished(ctx); err != nil {
+			return nil
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return nil
+		}
+		pubs = o.ListPublished()
+	}
+	var names []string
+	for _, p := range pubs {
+		ref := p.Host
+		if ref == "" {
+			ref = strconv.Itoa(p.PublicPort)
+		}
+		names = append(names, ref+"\t"+p.Target)
+	}
+	return names
+}
+
+// tokenNames returns the API tokens' names.
+func tokenNames() []string {
+	tokens, err := auth.ListTokens()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		names = append(names, fmt.Sprintf("%s\t%s", t.Name, t.Role))
+	}
+	return names
+}
+
+// secretNames returns the names of the stored secrets.
+func secretNames() []string {
+	names, _ := secrets.Names()
+	return names
+}
+
+// providerNames returns the cloud providers a relay can be provisioned on.
+func providerNames() []string {
+	var names []string
+	for _, p := range ops.CloudProviders() {
+		names = append(names, p.Key+"\t"+p.Name)
+	}
+	return names
+}
+
+// selectedProvider returns the provider named by cmd's --provider flag.
+func selectedProvider(cmd *cobra.Command) (ops.CloudProvider, bool) {
+	key, _ := cmd.Flags().GetString("provider")
+	for _, p := range ops.CloudProviders() {
+		if strings.EqualFold(p.Key, key) || strings.EqualFold(p.Name, key) {
+			return p, true
+		}
+	}
+	return ops.CloudProvider{}, false
+}
+
+// completeRegion completes --region with the regions of the provider
+// given with --provider.
+func completeRegion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	p, ok := selectedProvider(cmd)
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, r := range p.Regions {
+		names = append(names, r.Key+"\t"+r.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeInstanceType completes --instance-type with the instance types
+// of the provider given with --provider.
+func completeInstanceType(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	p, ok := selectedProvider(cmd)
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, t := range p.InstanceTypes {
+		names = append(names, t.Key+"\t"+t.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
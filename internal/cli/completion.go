@@ -1,31 +1,61 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
 var completionCmd = &cobra.Command{
-	Use:   "completion",
-	Short: "Generate zsh completion script",
-	Long: `Generate zsh completion script for tw.
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generate a shell completion script",
+	Long: `Generate a completion script for tw, for zsh when no shell is named.
 
-To load completions in your current shell session:
+Besides commands and flags, it completes the names of users, tunnels,
+templates, published services, API tokens, secrets and cloud providers,
+regions and instance types. Names come from the running tw daemon when
+there is one, and otherwise from the config directory.
 
-  source <(tw completion)
+bash (needs the bash-completion package):
 
-To load completions for every new session, add to your ~/.zshrc:
+  source <(tw completion bash)
+  tw completion bash > /etc/bash_completion.d/tw
 
-  source <(tw completion)
+zsh:
 
-Or write to the zsh completions directory:
+  source <(tw completion zsh)
+  tw completion zsh > "${fpath[1]}/_tw"
 
-  tw completion > "${fpath[1]}/_tw"`,
-	Args:      cobra.NoArgs,
+fish:
+
+  tw completion fish > ~/.config/fish/completions/tw.fish
+
+PowerShell:
+
+  tw completion powershell | Out-String | Invoke-Expression
+
+Add the source or Invoke-Expression line to your shell's startup file
+(~/.bashrc, ~/.zshrc, $PROFILE) to load completions in every session.`,
+	Args:         cobra.MaximumNArgs(1),
+	ValidArgs:    []string{"bash", "zsh", "fish", "powershell"},
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return rootCmd.GenZshCompletion(os.Stdout)
+		shell := "zsh"
+		if len(args) == 1 {
+			shell = args[0]
+		}
+		switch shell {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		}
+		return fmt.Errorf("unknown shell %q (use bash, zsh, fish or powershell)", shell)
 	},
 }
 
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check config.yaml for mistakes",
+	Long: `Check a config file for unknown keys (usually typos), values out of
+range, ports that collide (server.ssh_port + 1 is used by Xray), and
+settings that only work together, such as xray.uuid and xray.relay_host.
+
+Without an argument the active config.yaml is checked. The same checks run
+whenever tw loads its config, and problems are logged as warnings.
+
+Exits non-zero when problems are found.
+
+Examples:
+  tw config validate
+  tw config validate ./config.yaml.new
+  tw config validate -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigValidate,
+}
+
+var configLogLevelCmd = &cobra.Command{
+	Use:   "log-level [debug|info|warn|error]",
+	Short: "Show or change the log level",
+	Long: `Show the log level and Xray's, or change them. The change is saved to
+config.yaml and, when tw serve or tw connect is running, applied to it at
+once: the SSH server, tunnels, Terraform output, the dashboard console and
+Xray follow without a restart.
+
+--xray sets Xray's own level (debug, info, warning, error or none); give
+it "" to make Xray follow the log level again.
+
+Examples:
+  tw config log-level
+  tw config log-level debug
+  tw config log-level info --xray debug`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigLogLevel,
+}
+
+var configLogLevelXray string
+
+func init() {
+	configLogLevelCmd.Flags().StringVar(&configLogLevelXray, "xray", "", "Xray's log level (debug, info, warning, error, none; \"\" follows the log level)")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configLogLevelCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfigLogLevel(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	setXray := cmd.Flags().Changed("xray")
+	if len(args) == 0 && !setXray {
+		level, xrayLevel := cfg.LogLevel, cfg.Xray.LogLevel
+		if level == "" {
+			level = "info"
+		}
+		if xrayLevel == "" {
+			xrayLevel = "follows log level"
+		}
+		fmt.Printf("  Log level:  %s\n", level)
+		fmt.Printf("  Xray:       %s\n", xrayLevel)
+		return nil
+	}
+
+	level := cfg.LogLevel
+	if len(args) == 1 {
+		level = args[0]
+	}
+	if level == "" {
+		level = "info"
+	}
+	req := &api.SetLogLevelRequest{LogLevel: level}
+	if setXray {
+		req.XrayLogLevel = &configLogLevelXray
+	}
+
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		// No daemon running: save it for the next start.
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if err := o.SetLogLevel(req.LogLevel); err != nil {
+			return err
+		}
+		if setXray {
+			if err := o.SetXrayLogLevel(configLogLevelXray); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("  Log level set to %s.\n", level)
+		return nil
+	}
+	defer client.Close()
+	if err := client.SetLogLevel(context.Background(), req); err != nil {
+		return fmt.Errorf("setting log level: %w", err)
+	}
+	fmt.Printf("  Log level set to %s; applied to the running daemon.\n", level)
+	return nil
+}
+
+// configValidation is the structured output of `tw config validate`.
+type configValidation struct {
+	File     string           `json:"file"`
+	Valid    bool             `json:"valid"`
+	Problems []config.Problem `json:"problems"`
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	file := config.FilePath()
+	if len(args) == 1 {
+		file = args[0]
+	}
+	data, err := os.ReadFile(file)
+	if err != nil && !(len(args) == 0 && os.IsNotExist(err)) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	problems := ops.ValidateConfigYAML(data)
+	result := configValidation{File: file, Valid: len(problems) == 0, Problems: problems}
+	if result.Problems == nil {
+		result.Problems = []config.Problem{}
+	}
+
+	if structuredOutput() {
+		if err := printStructured(result); err != nil {
+			return err
+		}
+	} else if result.Valid {
+		fmt.Printf("  %s is valid\n", file)
+	} else {
+		fmt.Printf("  %s:\n", file)
+		for _, p := range problems {
+			fmt.Printf("    %s\n", p)
+		}
+	}
+
+	if !result.Valid {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	return nil
+}
@@ -1,23 +1,71 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/tray"
 )
 
 var connectCmd = &cobra.Command{
 	Use:   "connect",
 	Short: "Connect to a relay as a client",
-	RunE:  runConnect,
+	Long: `Connect to a relay as a client.
+
+With --tray the client runs with a system tray icon instead of waiting on the
+terminal. The icon shows whether the tunnel is connected, the last error, and
+offers Connect, Disconnect, and Quit. A desktop notification is shown when the
+connection drops and when it is restored.
+
+With --plain-ssh (or client.plain_ssh in config.yaml) only the Xray tunnel
+is started, and tw prints an ssh command that forwards the tunnels through
+it with a stock OpenSSH client instead.
+
+When tw dashboard is already running in client mode, tw connect asks it to connect instead of starting a second client, and prints its
+status changes until Ctrl-C. Ctrl-C disconnects only a client tw connect
+started; one the daemon already had keeps running.
+
+With --import the config bundle is first taken from a string made by
+tw export user --compact, given as the argument or, with -, pasted on
+stdin. Its checksum is verified first; for an encrypted string the
+passphrase is asked for, or taken from $TW_BUNDLE_PASSPHRASE. A bundle
+link from tw link create is fetched from the relay instead.
+
+  tw connect --import -
+  tw connect --import https://relay.example.com/claim/<token>
+
+With --claim the client claims the account it was invited to with the
+claim code from tw create user --invite, given as the argument or, with -,
+pasted on stdin: tw makes an SSH key here, registers its public key with
+the server through the relay, and installs the config the server sends
+back. The private key never leaves this machine.
+
+  tw connect --claim -`,
+	RunE: runConnect,
 }
 
+var (
+	connectTrayFlag     bool
+	connectPlainSSHFlag bool
+	connectImportFlag   string
+	connectClaimFlag    string
+)
+
 func init() {
+	connectCmd.Flags().BoolVar(&connectTrayFlag, "tray", false, "run with a system tray icon (Windows, macOS, Linux desktop)")
+	connectCmd.Flags().BoolVar(&connectPlainSSHFlag, "plain-ssh", false, "start only the Xray tunnel and print the ssh command that forwards the tunnels")
+	connectCmd.Flags().StringVar(&connectImportFlag, "import", "", "import the config bundle from a tw export user --compact string or bundle link (- reads stdin) before connecting")
+	connectCmd.Flags().StringVar(&connectClaimFlag, "claim", "", "claim an invitation with its claim code (- reads stdin), making this machine's key, before connecting")
+	connectCmd.MarkFlagsMutuallyExclusive("import", "claim")
 	rootCmd.AddCommand(connectCmd)
 }
 
@@ -25,20 +73,61 @@ func runConnect(cmd *cobra.Command, args []string) error {
 	if err := requireMode("client"); err != nil {
 		return err
 	}
-	fmt.Println("Connecting to relay...")
-
+	if connectImportFlag != "" {
+		if err := importBundleString(connectImportFlag); err != nil {
+			return err
+		}
+	}
+	if connectClaimFlag != "" {
+		if err := claimInvitation(connectClaimFlag); err != nil {
+			return err
+		}
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if !connectTrayFlag && !connectPlainSSHFlag {
+		if client, err := api.Dial(ops.APIAddr(cfg)); err == nil {
+			defer client.Close()
+			return runConnectRemote(client)
+		}
+	}
+	release, err := claimInstance("connect", cfg, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer release()
 	o, err := ops.New()
 	if err != nil {
 		return fmt.Errorf("initializing: %w", err)
 	}
 
+	if connectTrayFlag {
+		return tray.Run(o)
+	}
+
+	fmt.Println("Connecting to relay...")
+
 	fmt.Printf("Config: %s\n", config.FilePath())
 
-	if err := o.StartClient(cliProgress); err != nil {
+	start := o.StartClient
+	if connectPlainSSHFlag {
+		start = o.StartClientPlainSSH
+	}
+	if err := start(cliProgress); err != nil {
 		return err
 	}
 
-	fmt.Println("Client connected. Press Ctrl-C to stop.")
+	if cmd := o.ClientStatus().PlainSSH; cmd != "" {
+		fmt.Println("Xray tunnel up. Forward the tunnels with OpenSSH, in another terminal:")
+		fmt.Println()
+		fmt.Printf("  %s\n", cmd)
+		fmt.Println()
+		fmt.Println("Press Ctrl-C to stop.")
+	} else {
+		fmt.Println("Client connected. Press Ctrl-C to stop.")
+	}
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -48,3 +137,149 @@ func runConnect(cmd *cobra.Command, args []string) error {
 	o.StopClient(nil)
 	return nil
 }
+
+// importBundleString verifies the compact bundle string arg, or fetches
+// the bundle of the bundle link arg, and installs the bundle, through the
+// running daemon when there is one.
+func importBundleString(arg string) error {
+	s, err := readBundleString(arg, "bundle string")
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if s = strings.TrimSpace(s); ops.IsBundleLink(s) {
+		if data, err = ops.FetchBundleLink(context.Background(), s); err != nil {
+			return err
+		}
+	} else {
+		var passphrase string
+		if ops.CompactBundleEncrypted(s) {
+			if passphrase, err = readBundlePassphrase(false); err != nil {
+				return err
+			}
+		}
+		if data, err = ops.DecodeCompactBundle(s, passphrase); err != nil {
+			return err
+		}
+	}
+
+	cfg, _ := config.Load()
+	if client, err := api.Dial(ops.APIAddr(cfg)); err == nil {
+		defer client.Close()
+		if err := client.UploadClientConfig(context.Background(), data); err != nil {
+			return fmt.Errorf("importing bundle: %w", err)
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if err := o.UploadClientConfig(data); err != nil {
+			return fmt.Errorf("importing bundle: %w", err)
+		}
+	}
+	fmt.Printf("Imported the config bundle into %s\n", config.Dir())
+	return nil
+}
+
+// claimInvitation claims the invitation of the claim code arg, through
+// the running daemon when there is one.
+func claimInvitation(arg string) error {
+	code, err := readBundleString(arg, "claim code")
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("=== Tunnel Whisperer — Claim Invitation ===")
+	fmt.Println()
+	cfg, _ := config.Load()
+	if client := daemonClient(cfg); client != nil {
+		defer client.Close()
+		err = client.ClaimInvitationStream(context.Background(), code, cliProgress)
+	} else {
+		var o *ops.Ops
+		if o, err = ops.New(); err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		err = o.ClaimInvitation(code, cliProgress)
+	}
+	if err != nil {
+		return fmt.Errorf("claiming invitation: %w", err)
+	}
+	fmt.Println()
+	fmt.Printf("Claimed the invitation; the config is in %s\n", config.Dir())
+	return nil
+}
+
+// runConnectRemote connects the client of the running daemon and prints its
+// status changes until a signal, or until the daemon goes away.
+func runConnectRemote(client *api.Client) error {
+	st, err := client.GetStatus(context.Background())
+	if err != nil {
+		return fmt.Errorf("getting status: %w", err)
+	}
+
+	started := false
+	if st.Client != nil && (st.Client.State == ops.StateRunning || st.Client.State == ops.StateStarting) {
+		fmt.Println("The running tw daemon is already connected. Press Ctrl-C to stop watching; it stays connected.")
+	} else {
+		fmt.Println("Connecting to relay through the running tw daemon...")
+		if st.Client != nil && st.Client.State == ops.StateError {
+			client.StopClient(context.Background())
+		}
+		if err := client.StartClient(context.Background()); err != nil {
+			return fmt.Errorf("connecting: %w", err)
+		}
+		started = true
+		fmt.Println("Client connected. Press Ctrl-C to stop.")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stream, err := client.StreamStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("watching status: %w", err)
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		if e.Source == "client" {
+			printStatusEvent(e, false)
+		}
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("lost the connection to the tw daemon")
+	}
+
+	if started {
+		fmt.Println("\nDisconnecting...")
+		if err := client.StopClient(context.Background()); err != nil {
+			return fmt.Errorf("disconnecting: %w", err)
+		}
+	}
+	return nil
+}
+
+// printStatusEvent prints a StatusEvent as one line, naming its source
+// ("server" or "client") if withSource.
+func printStatusEvent(e *ops.StatusEvent, withSource bool) {
+	detail := e.Message
+	switch {
+	case e.Type == "state":
+		detail = string(e.State)
+	case e.Error != "":
+		detail = e.Error
+	case e.User != "":
+		detail = e.User
+	}
+	if e.Type == "tunnel_reconnecting" {
+		detail = fmt.Sprintf("attempt %d in %s", e.Attempt, time.Duration(e.BackoffMs)*time.Millisecond)
+	}
+	if withSource {
+		fmt.Printf("  %s  %-6s %-20s %s\n", e.Time.Local().Format("15:04:05"), e.Source, e.Type, detail)
+		return
+	}
+	fmt.Printf("  %s  %-20s %s\n", e.Time.Local().Format("15:04:05"), e.Type, detail)
+}
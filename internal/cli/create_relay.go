@@ -5,10 +5,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
 )
 
 var createCmd = &cobra.Command{
@@ -19,14 +23,84 @@ var createCmd = &cobra.Command{
 var createRelayServerCmd = &cobra.Command{
 	Use:   "relay-server",
 	Short: "Interactively provision a relay server on a cloud provider",
-	RunE:  runCreateRelayServer,
+	Long: `Provision a relay server on a cloud provider.
+
+Without flags the command runs an interactive wizard. Every prompt can be
+answered up front with a flag so the flow can be scripted:
+
+  tw create relay-server --provider hetzner --domain relay.example.com \
+      --token-env HCLOUD_TOKEN --region fsn1 --yes
+
+For AWS, --token-env names the variable holding the Access Key ID and
+--secret-env the one holding the Secret Access Key.
+
+If the relay's network blocks inbound port 80, Caddy cannot complete the
+default HTTP challenge. Use the DNS challenge instead, with an API token for
+the DNS provider hosting the relay domain:
+
+  tw create relay-server ... --acme-dns cloudflare --acme-dns-token-env CF_API_TOKEN
+
+To put the relay behind Cloudflare, add --cdn. Xray switches to the
+WebSocket transport, the relay's IP is saved as xray.origin_ip for the
+server's own connection, and the relay domain must be a proxied (orange
+cloud) record. Combine it with the Cloudflare DNS challenge:
+
+  tw create relay-server ... --cdn --acme-dns cloudflare --acme-dns-token-env CF_API_TOKEN`,
+	RunE: runCreateRelayServer,
 }
 
+var (
+	relayProviderFlag  string
+	relayDomainFlag    string
+	relayTokenEnvFlag  string
+	relaySecretEnvFlag string
+	relayRegionFlag    string
+	relayInstanceFlag  string
+	relayYesFlag       bool
+	relayCDNFlag       bool
+
+	relayACMEDNSFlag         string
+	relayACMEDNSTokenEnvFlag string
+)
+
 func init() {
+	createRelayServerCmd.Flags().StringVar(&relayProviderFlag, "provider", "", "cloud provider (hetzner, digitalocean, aws)")
+	createRelayServerCmd.Flags().StringVar(&relayDomainFlag, "domain", "", "relay domain (e.g. relay.example.com)")
+	createRelayServerCmd.Flags().StringVar(&relayTokenEnvFlag, "token-env", "", "environment variable holding the provider API token (AWS: access key ID)")
+	createRelayServerCmd.Flags().StringVar(&relaySecretEnvFlag, "secret-env", "AWS_SECRET_ACCESS_KEY", "environment variable holding the AWS secret access key")
+	createRelayServerCmd.Flags().StringVar(&relayRegionFlag, "region", "", "provider region/location (e.g. fsn1, nyc1, us-east-1)")
+	createRelayServerCmd.Flags().StringVar(&relayInstanceFlag, "instance-type", "", "instance type, e.g. cax11 or t4g.micro for ARM (default cx22, s-1vcpu-1gb or t3.micro)")
+	createRelayServerCmd.Flags().BoolVarP(&relayYesFlag, "yes", "y", false, "skip confirmation prompts")
+	createRelayServerCmd.Flags().BoolVar(&relayCDNFlag, "cdn", false, "run the relay behind Cloudflare (WebSocket transport, proxied DNS record)")
+	createRelayServerCmd.Flags().StringVar(&relayACMEDNSFlag, "acme-dns", "", "issue TLS certificates with the DNS challenge via this provider ("+strings.Join(ops.ACMEDNSProviders, ", ")+")")
+	createRelayServerCmd.Flags().StringVar(&relayACMEDNSTokenEnvFlag, "acme-dns-token-env", "", "environment variable holding the DNS provider API token (with --acme-dns)")
+	createRelayServerCmd.RegisterFlagCompletionFunc("provider", completeFlag(providerNames))
+	createRelayServerCmd.RegisterFlagCompletionFunc("region", completeRegion)
+	createRelayServerCmd.RegisterFlagCompletionFunc("instance-type", completeInstanceType)
 	createCmd.AddCommand(createRelayServerCmd)
 	rootCmd.AddCommand(createCmd)
 }
 
+// envFlag reads the value of the environment variable named by a --*-env
+// flag, failing with a clear message when it is unset.
+func envFlag(flag, name string) (string, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return "", fmt.Errorf("--%s: environment variable %s is empty or unset", flag, name)
+	}
+	return v, nil
+}
+
+// validRegion reports whether key is one of the provider's regions.
+func validRegion(p ops.CloudProvider, key string) bool {
+	for _, r := range p.Regions {
+		if r.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
 // cliProgress prints ProgressEvents to stdout.
 func cliProgress(e ops.ProgressEvent) {
 	prefix := fmt.Sprintf("[%d/%d] %s", e.Step, e.Total, e.Label)
@@ -48,6 +122,17 @@ func cliProgress(e ops.ProgressEvent) {
 	}
 }
 
+// daemonClient returns a client of the running tw daemon, or nil when none
+// is running. Commands that change the relay or users go through it when
+// there is one, so that only one process changes their state.
+func daemonClient(cfg *config.Config) *api.Client {
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
 func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 	if err := requireMode("server"); err != nil {
 		return err
@@ -58,19 +143,28 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 	fmt.Println("=== Tunnel Whisperer — Relay Server Setup ===")
 	fmt.Println()
 
-	if !ops.TerraformAvailable() {
-		return fmt.Errorf("terraform is required but not found in PATH\n  Install: https://developer.hashicorp.com/terraform/install")
-	}
-
 	o, err := ops.New()
 	if err != nil {
 		return fmt.Errorf("initializing: %w", err)
 	}
 
 	cfg := o.Config()
+	client := daemonClient(cfg)
+	if client != nil {
+		defer client.Close()
+		fmt.Println("  A tw daemon is running: the relay is provisioned through it.")
+		fmt.Println()
+	}
+	if cfg.Server.RelayBackend != ops.RelayBackendSDK && !ops.TerraformAvailable() {
+		fmt.Printf("  Terraform was not found in PATH — Terraform %s will be downloaded to %s.\n", terraform.TerraformVersion, config.ToolsDir())
+		fmt.Println()
+	}
 
 	// Check if relay was already provisioned.
 	status := o.GetRelayStatus()
+	if status.Provisioned && relayYesFlag {
+		return fmt.Errorf("relay already provisioned (provider: %s) — run `tw destroy relay-server` first", status.Provider)
+	}
 	if status.Provisioned {
 		fmt.Printf("  Relay already provisioned (provider: %s).\n", status.Provider)
 		fmt.Print("  Destroy and recreate? [y/N]: ")
@@ -79,9 +173,9 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 			fmt.Println("  Aborted.")
 			return nil
 		}
-		// Collect credentials for destroy.
+		// Collect credentials for destroy unless they were stored.
 		var creds map[string]string
-		if status.Provider == "AWS" {
+		if status.Provider == "AWS" && !status.CredentialsStored {
 			fmt.Println("  AWS credentials needed to destroy resources.")
 			fmt.Print("  AWS Access Key ID: ")
 			scanner.Scan()
@@ -97,7 +191,11 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 			}
 		}
 		fmt.Println("  Destroying existing relay resources...")
-		if err := o.DestroyRelay(context.Background(), creds, cliProgress); err != nil {
+		destroy := func() error { return o.DestroyRelay(context.Background(), creds, cliProgress) }
+		if client != nil {
+			destroy = func() error { return client.DestroyRelay(context.Background(), creds) }
+		}
+		if err := destroy(); err != nil {
 			fmt.Printf("  Warning: %v\n", err)
 			fmt.Println("  You may need to delete cloud resources manually.")
 		}
@@ -105,7 +203,9 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 
 	// ── Step 3: Relay Domain ────────────────────────────────────────────
 	fmt.Println("[3/9] Relay domain")
-	if cfg.Xray.RelayHost != "" {
+	if relayDomainFlag != "" {
+		cfg.Xray.RelayHost = relayDomainFlag
+	} else if cfg.Xray.RelayHost != "" && !relayYesFlag {
 		fmt.Printf("      Current: %s\n", cfg.Xray.RelayHost)
 		fmt.Print("      Keep? [Y/n]: ")
 		scanner.Scan()
@@ -130,33 +230,87 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 	// ── Step 4: Cloud Provider ──────────────────────────────────────────
 	fmt.Println("[4/9] Cloud provider")
 	providers := ops.CloudProviders()
-	for i, p := range providers {
-		fmt.Printf("      %d) %s\n", i+1, p.Name)
-	}
-	fmt.Print("      Select [1-3]: ")
-	scanner.Scan()
-	providerIdx := strings.TrimSpace(scanner.Text())
 	var selected ops.CloudProvider
-	switch providerIdx {
-	case "1":
-		selected = providers[0]
-	case "2":
-		selected = providers[1]
-	case "3":
-		selected = providers[2]
-	default:
-		return fmt.Errorf("invalid choice: %s", providerIdx)
+	if relayProviderFlag != "" {
+		found := false
+		for _, p := range providers {
+			if strings.EqualFold(p.Key, relayProviderFlag) || strings.EqualFold(p.Name, relayProviderFlag) {
+				selected = p
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown provider %q (use hetzner, digitalocean, or aws)", relayProviderFlag)
+		}
+	} else {
+		for i, p := range providers {
+			fmt.Printf("      %d) %s\n", i+1, p.Name)
+		}
+		fmt.Print("      Select [1-3]: ")
+		scanner.Scan()
+		providerIdx := strings.TrimSpace(scanner.Text())
+		switch providerIdx {
+		case "1":
+			selected = providers[0]
+		case "2":
+			selected = providers[1]
+		case "3":
+			selected = providers[2]
+		default:
+			return fmt.Errorf("invalid choice: %s", providerIdx)
+		}
 	}
 	fmt.Printf("      Provider: %s\n", selected.Name)
+	if relayRegionFlag != "" && !validRegion(selected, relayRegionFlag) {
+		return fmt.Errorf("unknown %s region %q", selected.Name, relayRegionFlag)
+	}
+	instance := selected.InstanceTypes[0]
+	if relayInstanceFlag != "" {
+		t, ok := selected.InstanceType(relayInstanceFlag)
+		if !ok {
+			return fmt.Errorf("unknown %s instance type %q", selected.Name, relayInstanceFlag)
+		}
+		instance = t
+	} else if !relayYesFlag && len(selected.InstanceTypes) > 1 {
+		fmt.Println("      Instance type:")
+		for i, t := range selected.InstanceTypes {
+			line := fmt.Sprintf("      %d) %s", i+1, t.Name)
+			if t.Note != "" {
+				line += " — " + t.Note
+			}
+			fmt.Println(line)
+		}
+		fmt.Printf("      Select [1-%d, default 1]: ", len(selected.InstanceTypes))
+		scanner.Scan()
+		if answer := strings.TrimSpace(scanner.Text()); answer != "" {
+			n, err := strconv.Atoi(answer)
+			if err != nil || n < 1 || n > len(selected.InstanceTypes) {
+				return fmt.Errorf("invalid choice: %s", answer)
+			}
+			instance = selected.InstanceTypes[n-1]
+		}
+	}
+	fmt.Printf("      Instance: %s\n", instance.Name)
 	fmt.Println()
 
 	// ── Step 5: Cloud Credentials ───────────────────────────────────────
 	fmt.Printf("[5/9] %s credentials\n", selected.Name)
-	fmt.Printf("      Generate here: %s\n", selected.TokenLink)
-	fmt.Println()
 
 	var token, awsSecretKey string
-	if selected.Name == "AWS" {
+	if relayTokenEnvFlag != "" {
+		if token, err = envFlag("token-env", relayTokenEnvFlag); err != nil {
+			return err
+		}
+		if selected.Name == "AWS" {
+			if awsSecretKey, err = envFlag("secret-env", relaySecretEnvFlag); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("      Read from $%s\n", relayTokenEnvFlag)
+	} else if selected.Name == "AWS" {
+		fmt.Printf("      Generate here: %s\n", selected.TokenLink)
+		fmt.Println()
 		fmt.Print("      AWS Access Key ID: ")
 		scanner.Scan()
 		token = strings.TrimSpace(scanner.Text())
@@ -167,6 +321,8 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("both AWS Access Key ID and Secret Access Key are required")
 		}
 	} else {
+		fmt.Printf("      Generate here: %s\n", selected.TokenLink)
+		fmt.Println()
 		fmt.Printf("      %s: ", selected.TokenName)
 		scanner.Scan()
 		token = strings.TrimSpace(scanner.Text())
@@ -174,23 +330,53 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("%s is required", selected.TokenName)
 		}
 	}
+	var acme ops.ACMEDNS
+	if relayACMEDNSFlag != "" {
+		if relayACMEDNSTokenEnvFlag == "" {
+			return fmt.Errorf("--acme-dns requires --acme-dns-token-env")
+		}
+		acme.Provider = strings.ToLower(relayACMEDNSFlag)
+		if acme.Token, err = envFlag("acme-dns-token-env", relayACMEDNSTokenEnvFlag); err != nil {
+			return err
+		}
+		if err := acme.Validate(); err != nil {
+			return err
+		}
+		fmt.Printf("      DNS challenge token read from $%s\n", relayACMEDNSTokenEnvFlag)
+	} else if relayACMEDNSTokenEnvFlag != "" {
+		return fmt.Errorf("--acme-dns-token-env requires --acme-dns")
+	}
+	if relayCDNFlag && acme.Provider == "" {
+		fmt.Println("      Note: behind Cloudflare, the DNS challenge (--acme-dns cloudflare) is more reliable")
+	}
 	fmt.Println()
 
 	// ── Step 7: Confirm ─────────────────────────────────────────────────
 	fmt.Println("[7/9] Provisioning relay")
 	fmt.Printf("      Provider:  %s\n", selected.Name)
 	fmt.Printf("      Domain:    %s\n", domain)
-	fmt.Printf("      Instance:  Ubuntu 24.04 (smallest tier)\n")
+	if relayRegionFlag != "" {
+		fmt.Printf("      Region:    %s\n", relayRegionFlag)
+	}
+	fmt.Printf("      Instance:  %s, Ubuntu 24.04\n", instance.Name)
 	fmt.Printf("      Firewall:  ports 80, 443 only\n")
+	if acme.Provider != "" {
+		fmt.Printf("      TLS:       DNS challenge via %s\n", acme.Provider)
+	}
+	if relayCDNFlag {
+		fmt.Printf("      CDN:       Cloudflare (WebSocket transport, proxied DNS record)\n")
+	}
 	fmt.Printf("      Software:  Caddy + Xray + SSH (localhost-only)\n")
 	fmt.Println()
-	fmt.Print("      Proceed? [Y/n]: ")
-	scanner.Scan()
-	if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer == "n" {
-		fmt.Println("      Aborted.")
-		return nil
+	if !relayYesFlag {
+		fmt.Print("      Proceed? [Y/n]: ")
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer == "n" {
+			fmt.Println("      Aborted.")
+			return nil
+		}
+		fmt.Println()
 	}
-	fmt.Println()
 
 	req := ops.RelayProvisionRequest{
 		Domain:       domain,
@@ -198,16 +384,37 @@ func runCreateRelayServer(cmd *cobra.Command, args []string) error {
 		ProviderName: selected.Name,
 		Token:        token,
 		AWSSecretKey: awsSecretKey,
+		Region:       relayRegionFlag,
+		InstanceType: instance.Key,
+		ACMEDNS:      acme,
+		CDN:          relayCDNFlag,
 	}
 
-	if err := o.ProvisionRelay(context.Background(), req, cliProgress); err != nil {
+	if client != nil {
+		err = client.ProvisionRelayStream(context.Background(), &api.ProvisionRelayRequest{
+			Domain:       req.Domain,
+			ProviderKey:  req.ProviderKey,
+			ProviderName: req.ProviderName,
+			Token:        req.Token,
+			AWSSecretKey: req.AWSSecretKey,
+			Region:       req.Region,
+			InstanceType: req.InstanceType,
+			ACMEDNS:      req.ACMEDNS,
+			CDN:          req.CDN,
+		}, cliProgress)
+	} else {
+		err = o.ProvisionRelay(context.Background(), req, cliProgress)
+	}
+	if err != nil {
 		return err
 	}
 
 	fmt.Println()
 	fmt.Println("=== Relay server setup complete ===")
 	fmt.Println()
-	fmt.Println("  Run `tw serve` to start the tunnel.")
+	if client == nil {
+		fmt.Println("  Run `tw serve` to start the tunnel.")
+	}
 	fmt.Println()
 
 	return nil
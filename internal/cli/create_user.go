@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -15,10 +16,72 @@ import (
 var createUserCmd = &cobra.Command{
 	Use:   "user",
 	Short: "Create a client user with tunnel access",
-	RunE:  runCreateUser,
+	Long: `Create a client user with tunnel access.
+
+Without flags the command prompts for a name and port mappings. Pass
+--name and one or more --map CLIENT:SERVER flags to skip the prompts:
+
+  tw create user --name alice --map 8080:80 --map 5433:5432
+
+A mapping may also name another host the server reaches, as
+CLIENT:HOST:PORT, making the server a jump point to it:
+
+  tw create user --name bob --map 5433:10.0.0.5:5432
+
+--permit lets the user forward to further destinations without a
+tunnel in their bundle, e.g. with their own tunnels or ssh -L. A pattern
+is HOST:PORT where PORT may be * and HOST a glob or a CIDR block:
+
+  tw create user --name carol --map 5433:10.0.0.5:5432 \\
+      --permit '10.0.0.0/24:5432' --permit '*.db.internal:*'
+
+Use --template to take the mappings from a named template (see
+` + "`tw template`" + `); any --map flags are added on top.
+
+With --security-key the user's SSH key is created on a FIDO2 security key
+plugged into this machine (OpenSSH 8.2 or later needed); touch it when
+it blinks. Hand the security key over with the config bundle.
+
+With --pubkey the user keeps their own private key: tw registers the
+public key read from the file (an ed25519, ecdsa or RSA .pub file, or -
+for stdin) and makes a bundle without a private key. The user copies
+theirs next to config.yaml as id_ed25519, or loads it into their SSH
+agent. A key already in authorized_keys is refused.
+
+With --invite the user makes their key themselves: tw creates them
+without one and prints a claim code. They run tw connect --claim CODE on
+their machine, which makes the key there, registers it with the server
+through the relay and installs their config. ` + "`tw user invite <name>`" + `
+prints the code again, or a new one with --renew.
+
+With --totp the user must also give a code from an authenticator app
+each time their client connects. The enrollment QR code is printed once
+the user is created; ` + "`tw user totp <name> show`" + ` prints it again.`,
+	RunE: runCreateUser,
 }
 
+var (
+	userNameFlag     string
+	userMapFlags     []string
+	userTemplateFlag string
+	userSKFlag       bool
+	userPermitFlags  []string
+	userTOTPFlag     bool
+	userPubKeyFlag   string
+	userInviteFlag   bool
+)
+
 func init() {
+	createUserCmd.Flags().StringVar(&userNameFlag, "name", "", "user name")
+	createUserCmd.Flags().StringArrayVar(&userMapFlags, "map", nil, "port mapping CLIENT:SERVER or CLIENT:HOST:PORT (repeatable)")
+	createUserCmd.Flags().StringVar(&userTemplateFlag, "template", "", "mapping template to create the user from")
+	createUserCmd.Flags().BoolVar(&userSKFlag, "security-key", false, "create the SSH key on a FIDO2 security key")
+	createUserCmd.Flags().StringArrayVar(&userPermitFlags, "permit", nil, "extra permitted destination HOST:PORT, with * ports, host globs or CIDR blocks (repeatable)")
+	createUserCmd.Flags().BoolVar(&userTOTPFlag, "totp", false, "also require a TOTP code from an authenticator app")
+	createUserCmd.Flags().StringVar(&userPubKeyFlag, "pubkey", "", "register the user's own public key from this .pub file (- for stdin) instead of generating one")
+	createUserCmd.Flags().BoolVar(&userInviteFlag, "invite", false, "create the user without a key and print a claim code for them to make their own")
+	createUserCmd.MarkFlagsMutuallyExclusive("security-key", "pubkey", "invite")
+	createUserCmd.RegisterFlagCompletionFunc("template", completeFlag(templateNames))
 	createCmd.AddCommand(createUserCmd)
 }
 
@@ -26,6 +89,14 @@ func runCreateUser(cmd *cobra.Command, args []string) error {
 	if err := requireMode("server"); err != nil {
 		return err
 	}
+	var pubKey string
+	if userPubKeyFlag != "" {
+		data, err := readPublicKeyFile(userPubKeyFlag)
+		if err != nil {
+			return err
+		}
+		pubKey = string(data)
+	}
 	scanner := bufio.NewScanner(os.Stdin)
 
 	fmt.Println()
@@ -39,23 +110,53 @@ func runCreateUser(cmd *cobra.Command, args []string) error {
 
 	// ── Step 1: User Name ──────────────────────────────────────────────
 	fmt.Println("[1/5] User name")
-	fmt.Print("      Name: ")
-	scanner.Scan()
-	userName := strings.TrimSpace(scanner.Text())
+	userName := strings.TrimSpace(userNameFlag)
 	if userName == "" {
-		return fmt.Errorf("user name is required")
+		fmt.Print("      Name: ")
+		scanner.Scan()
+		userName = strings.TrimSpace(scanner.Text())
+		if userName == "" {
+			return fmt.Errorf("user name is required")
+		}
+	} else {
+		fmt.Printf("      Name: %s\n", userName)
 	}
 	fmt.Println()
 
 	// ── Step 2: Port Mappings ──────────────────────────────────────────
 	fmt.Println("[2/5] Port mappings")
-	fmt.Println("      Map client local ports to server ports (localhost only).")
-	fmt.Println("      Enter mappings one at a time. Empty client port to finish.")
-	fmt.Println()
+
+	template := userTemplateFlag
+	if template == "" && len(userMapFlags) == 0 && len(o.Config().Server.Templates) > 0 {
+		var names []string
+		for _, t := range o.Config().Server.Templates {
+			names = append(names, t.Name)
+		}
+		fmt.Printf("      Templates: %s\n", strings.Join(names, ", "))
+		fmt.Print("      Template (empty for custom mappings): ")
+		scanner.Scan()
+		template = strings.TrimSpace(scanner.Text())
+	}
+	if template != "" {
+		fmt.Printf("      Template: %s\n", template)
+	}
 
 	var mappings []ops.PortMapping
+	for _, m := range userMapFlags {
+		pm, err := ops.ParsePortMapping(m)
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, pm)
+		fmt.Printf("      localhost:%d (client) → %s (server)\n", pm.ClientPort, pm.Dest())
+	}
+	if len(mappings) == 0 && template == "" {
+		fmt.Println("      Map client local ports to server ports, or to HOST:PORT on a host the server reaches.")
+		fmt.Println("      Enter mappings one at a time. Empty client port to finish.")
+		fmt.Println()
+	}
 
-	for i := 1; ; i++ {
+	for i := 1; len(userMapFlags) == 0 && template == ""; i++ {
 		fmt.Printf("      Mapping %d:\n", i)
 		fmt.Printf("        Client local port: ")
 		scanner.Scan()
@@ -77,32 +178,87 @@ func runCreateUser(cmd *cobra.Command, args []string) error {
 		if serverPortStr == "" {
 			return fmt.Errorf("server port is required")
 		}
-		serverPort, err := strconv.Atoi(serverPortStr)
-		if err != nil || serverPort < 1 || serverPort > 65535 {
-			return fmt.Errorf("invalid port: %s", serverPortStr)
+		pm, err := ops.ParsePortMapping(fmt.Sprintf("%d:%s", clientPort, serverPortStr))
+		if err != nil {
+			return err
 		}
 
-		mappings = append(mappings, ops.PortMapping{ClientPort: clientPort, ServerPort: serverPort})
-		fmt.Printf("        → localhost:%d (client) → 127.0.0.1:%d (server)\n", clientPort, serverPort)
+		mappings = append(mappings, pm)
+		fmt.Printf("        → localhost:%d (client) → %s (server)\n", clientPort, pm.Dest())
 		fmt.Println()
 	}
+	for _, p := range userPermitFlags {
+		fmt.Printf("      Also permitted: %s\n", p)
+	}
 	fmt.Println()
 
 	req := ops.CreateUserRequest{
-		Name:     userName,
-		Mappings: mappings,
+		Name:        userName,
+		Mappings:    mappings,
+		Template:    template,
+		SecurityKey: userSKFlag,
+		Permit:      userPermitFlags,
+		TOTP:        userTOTPFlag,
+		PublicKey:   pubKey,
+		Invite:      userInviteFlag,
 	}
 
-	if err := o.CreateUser(context.Background(), req, cliProgress); err != nil {
+	// A security key must be plugged in where its key is made, so that
+	// user is always created here.
+	if client := daemonClient(o.Config()); client != nil && !userSKFlag {
+		defer client.Close()
+		err = client.CreateUserStream(context.Background(), req, cliProgress)
+	} else {
+		err = o.CreateUser(context.Background(), req, cliProgress)
+	}
+	if err != nil {
 		return err
 	}
 
 	fmt.Println()
 	fmt.Println("=== User created ===")
-	fmt.Println()
-	fmt.Println("  Send the user's config directory to the client.")
-	fmt.Println("  The client places these files in their config directory and runs `tw connect`.")
-	fmt.Println()
+	if userInviteFlag {
+		code, expires, err := o.InviteCode(userName, false)
+		if err != nil {
+			return err
+		}
+		printClaimCode(userName, code, expires)
+	} else {
+		fmt.Println()
+		fmt.Println("  Send the user's config directory to the client.")
+		fmt.Println("  The client places these files in their config directory and runs `tw connect`.")
+		if userSKFlag {
+			fmt.Println("  Hand over the security key too; the client runs `ssh-add id_ed25519_sk` first.")
+		}
+		if pubKey != "" {
+			fmt.Println("  The bundle holds no private key: the user adds their own as id_ed25519, or uses their SSH agent.")
+		}
+		fmt.Println()
+	}
+	if userTOTPFlag {
+		e, err := o.UserTOTP(userName)
+		if err != nil {
+			return err
+		}
+		fmt.Println("  Have the user scan this code with their authenticator app. Send it apart")
+		fmt.Println("  from the config bundle.")
+		if err := printTOTPEnrollment(e); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// readPublicKeyFile reads the public key file given with --pubkey, or
+// stdin for "-".
+func readPublicKeyFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(io.LimitReader(os.Stdin, 64<<10))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+	return data, nil
+}
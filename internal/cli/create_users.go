@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var createUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Create many users at once from a CSV or YAML file",
+	Long: `Create many users at once from a CSV or YAML file.
+
+YAML files hold a top-level "users" list:
+
+  users:
+    - name: alice
+      map: ["8080:80", "5433:5432"]
+    - name: bob
+      map: ["8080:80"]
+
+CSV files hold one user per row, the name followed by CLIENT:SERVER or
+CLIENT:HOST:PORT mappings (rows with the same name are merged):
+
+  name,mapping
+  alice,8080:80,5433:5432
+  bob,8080:80
+
+All UUIDs are registered on the relay over a single connection.`,
+	RunE: runCreateUsers,
+}
+
+var (
+	usersFileFlag   string
+	usersFormatFlag string
+)
+
+func init() {
+	createUsersCmd.Flags().StringVarP(&usersFileFlag, "file", "f", "", "CSV or YAML file describing the users (required)")
+	createUsersCmd.Flags().StringVar(&usersFormatFlag, "format", "", "file format: csv or yaml (default: from file extension)")
+	createUsersCmd.MarkFlagRequired("file")
+	createCmd.AddCommand(createUsersCmd)
+}
+
+func runCreateUsers(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(usersFileFlag)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", usersFileFlag, err)
+	}
+	format := usersFormatFlag
+	if format == "" {
+		format = ops.ImportFormat(usersFileFlag)
+	}
+	reqs, err := ops.ParseUserImport(data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", usersFileFlag, err)
+	}
+
+	cfg, _ := config.Load()
+	addr := ops.APIAddr(cfg)
+
+	client, err := api.Dial(addr)
+	if err != nil {
+		return runCreateUsersLocal(reqs)
+	}
+	defer client.Close()
+
+	resp, err := client.CreateUsers(context.Background(), reqs)
+	if err != nil {
+		return fmt.Errorf("creating users: %w", err)
+	}
+	if structuredOutput() {
+		return printStructured(resp)
+	}
+
+	var failed int
+	for _, r := range resp.Results {
+		if r.Status == "failed" {
+			failed++
+			fmt.Printf("  ✗ %s: %s\n", r.Name, r.Error)
+		} else {
+			fmt.Printf("  ✓ %s\n", r.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s", resp.Message)
+	}
+	fmt.Printf("\n  Created %d users.\n", len(resp.Results))
+	return nil
+}
+
+func runCreateUsersLocal(reqs []ops.CreateUserRequest) error {
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	if structuredOutput() {
+		resp := api.CreateUsersResponse{Message: fmt.Sprintf("created %d users", len(reqs))}
+		progress := func(e ops.ProgressEvent) {
+			if e.Step < 2 || e.Status == "running" {
+				return
+			}
+			resp.Results = append(resp.Results, api.CreateUserResult{Name: e.Label, Status: e.Status, Message: e.Message, Error: e.Error})
+		}
+		if err := o.CreateUsers(context.Background(), reqs, progress); err != nil {
+			if len(resp.Results) == 0 {
+				return err
+			}
+			resp.Message = err.Error()
+		}
+		return printStructured(resp)
+	}
+
+	fmt.Println()
+	fmt.Printf("=== Tunnel Whisperer — Create %d Users ===\n", len(reqs))
+	fmt.Println()
+
+	if err := o.CreateUsers(context.Background(), reqs, cliProgress); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("=== Users created ===")
+	fmt.Println()
+	fmt.Println("  Export each user's bundle with `tw export user <name>`.")
+	fmt.Println()
+	return nil
+}
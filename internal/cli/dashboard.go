@@ -42,6 +42,16 @@ func runDashboard(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
+	port := cfg.Server.DashboardPort
+	if dashboardPort != 0 {
+		port = dashboardPort
+	}
+	release, err := claimInstance("dashboard", cfg, cfg.Server.APIPort, port)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	o, err := ops.New()
 	if err != nil {
 		return fmt.Errorf("initializing ops: %w", err)
@@ -50,21 +60,12 @@ func runDashboard(cmd *cobra.Command, args []string) error {
 	// Start gRPC API so CLI commands can talk to this daemon.
 	apiAddr := fmt.Sprintf(":%d", cfg.Server.APIPort)
 	apiSrv := api.NewServer(o, apiAddr)
-	go func() {
-		slog.Info("gRPC API listening", "addr", apiAddr)
-		if err := apiSrv.Run(); err != nil {
-			slog.Error("gRPC API error", "error", err)
-		}
-	}()
-
-	port := cfg.Server.DashboardPort
-	if dashboardPort != 0 {
-		port = dashboardPort
-	}
+	slog.Info("gRPC API listening", "addr", apiAddr)
+	o.SuperviseAPI(cfg.Server.APIPort, nil, apiSrv.Run)
 
 	addr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Starting dashboard on http://localhost%s\n", addr)
 	srv := dashboard.NewServer(addr, o)
+	fmt.Printf("Starting dashboard on %s\n", srv.URL())
 
 	// Auto-start server or client if ready.
 	mode := o.Mode()
@@ -19,13 +19,17 @@ var deleteCmd = &cobra.Command{
 }
 
 var deleteUserCmd = &cobra.Command{
-	Use:   "user <name>",
-	Short: "Delete a user",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runDeleteUser,
+	Use:               "user <name>",
+	Short:             "Delete a user",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runDeleteUser,
+	ValidArgsFunction: completeArgs(userNames),
 }
 
+var deleteUserYesFlag bool
+
 func init() {
+	deleteUserCmd.Flags().BoolVarP(&deleteUserYesFlag, "yes", "y", false, "skip the confirmation prompt")
 	deleteCmd.AddCommand(deleteUserCmd)
 	rootCmd.AddCommand(deleteCmd)
 }
@@ -36,16 +40,18 @@ func runDeleteUser(cmd *cobra.Command, args []string) error {
 	}
 	name := args[0]
 
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Printf("  Delete user %q? [y/N]: ", name)
-	scanner.Scan()
-	if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
-		fmt.Println("  Aborted.")
-		return nil
+	if !deleteUserYesFlag {
+		scanner := bufio.NewScanner(os.Stdin)
+		fmt.Printf("  Delete user %q? [y/N]: ", name)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
 	}
 
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	client, err := api.Dial(addr)
 	if err != nil {
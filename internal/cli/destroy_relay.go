@@ -24,7 +24,16 @@ var destroyRelayServerCmd = &cobra.Command{
 	RunE:  runDestroyRelayServer,
 }
 
+var (
+	destroyTokenEnvFlag  string
+	destroySecretEnvFlag string
+	destroyYesFlag       bool
+)
+
 func init() {
+	destroyRelayServerCmd.Flags().StringVar(&destroyTokenEnvFlag, "token-env", "", "environment variable holding the AWS access key ID")
+	destroyRelayServerCmd.Flags().StringVar(&destroySecretEnvFlag, "secret-env", "AWS_SECRET_ACCESS_KEY", "environment variable holding the AWS secret access key")
+	destroyRelayServerCmd.Flags().BoolVarP(&destroyYesFlag, "yes", "y", false, "skip the confirmation prompt")
 	destroyCmd.AddCommand(destroyRelayServerCmd)
 	rootCmd.AddCommand(destroyCmd)
 }
@@ -51,9 +60,22 @@ func runDestroyRelayServer(cmd *cobra.Command, args []string) error {
 
 	scanner := bufio.NewScanner(os.Stdin)
 
-	// Collect credentials if AWS.
+	// Collect credentials if AWS and they weren't stored at provisioning.
 	var creds map[string]string
-	if status.Provider == "AWS" {
+	if status.Provider == "AWS" && destroyTokenEnvFlag != "" {
+		keyID, err := envFlag("token-env", destroyTokenEnvFlag)
+		if err != nil {
+			return err
+		}
+		secret, err := envFlag("secret-env", destroySecretEnvFlag)
+		if err != nil {
+			return err
+		}
+		creds = map[string]string{
+			"AWS_ACCESS_KEY_ID":     keyID,
+			"AWS_SECRET_ACCESS_KEY": secret,
+		}
+	} else if status.Provider == "AWS" && !status.CredentialsStored {
 		fmt.Println("  AWS credentials needed to destroy resources.")
 		fmt.Print("  AWS Access Key ID: ")
 		scanner.Scan()
@@ -71,16 +93,18 @@ func runDestroyRelayServer(cmd *cobra.Command, args []string) error {
 		fmt.Println()
 	}
 
-	fmt.Print("  Destroy this relay? [y/N]: ")
-	scanner.Scan()
-	if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
-		fmt.Println("  Aborted.")
-		return nil
+	if !destroyYesFlag {
+		fmt.Print("  Destroy this relay? [y/N]: ")
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+		fmt.Println()
 	}
-	fmt.Println()
 
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	client, dialErr := api.Dial(addr)
 	if dialErr != nil {
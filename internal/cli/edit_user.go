@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Modify resources",
+}
+
+var editUserCmd = &cobra.Command{
+	Use:   "user <name>",
+	Short: "Rename a user or change their port mappings",
+	Long: `Rename a user or change their port mappings.
+
+The user's config.yaml and authorized_keys entry are rewritten; the UUID and
+SSH key stay the same. Without flags the command prompts for the changes.
+
+  tw edit user alice --name alice-laptop
+  tw edit user alice --map 8080:80 --map 5433:5432
+  tw edit user alice --permit '10.0.0.0/24:*'
+
+--map replaces all existing mappings and detaches the user from their
+mapping template. The user must re-download their config bundle to pick up
+new mappings. --permit replaces the user's extra permitted destinations;
+--permit '' removes them.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runEditUser,
+	ValidArgsFunction: completeArgs(userNames),
+}
+
+var (
+	editUserNameFlag    string
+	editUserMapFlags    []string
+	editUserPermitFlags []string
+)
+
+func init() {
+	editUserCmd.Flags().StringVar(&editUserNameFlag, "name", "", "new user name")
+	editUserCmd.Flags().StringArrayVar(&editUserMapFlags, "map", nil, "port mapping CLIENT:SERVER or CLIENT:HOST:PORT, replaces existing mappings (repeatable)")
+	editUserCmd.Flags().StringArrayVar(&editUserPermitFlags, "permit", nil, "extra permitted destination HOST:PORT, with * ports, host globs or CIDR blocks; replaces existing ones (repeatable)")
+	editCmd.AddCommand(editUserCmd)
+	rootCmd.AddCommand(editCmd)
+}
+
+func runEditUser(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	name := args[0]
+
+	req := api.UpdateUserRequest{Name: name, NewName: editUserNameFlag}
+	for _, m := range editUserMapFlags {
+		pm, err := ops.ParsePortMapping(m)
+		if err != nil {
+			return err
+		}
+		req.Mappings = append(req.Mappings, pm)
+	}
+	if cmd.Flags().Changed("permit") {
+		req.Permit = []string{}
+		for _, p := range editUserPermitFlags {
+			if p != "" {
+				req.Permit = append(req.Permit, p)
+			}
+		}
+	}
+
+	if editUserNameFlag == "" && len(editUserMapFlags) == 0 && req.Permit == nil {
+		if err := promptUserEdit(&req); err != nil {
+			return err
+		}
+	}
+
+	cfg, _ := config.Load()
+	addr := ops.APIAddr(cfg)
+
+	client, err := api.Dial(addr)
+	if err != nil {
+		// No daemon running, edit locally.
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		opsReq := ops.UpdateUserRequest{Name: req.Name, NewName: req.NewName, Mappings: req.Mappings, Permit: req.Permit}
+		if err := o.UpdateUser(opsReq); err != nil {
+			return err
+		}
+	} else {
+		defer client.Close()
+		if err := client.UpdateUser(context.Background(), &req); err != nil {
+			return fmt.Errorf("updating user: %w", err)
+		}
+	}
+
+	newName := name
+	if req.NewName != "" {
+		newName = req.NewName
+	}
+	fmt.Printf("  User %q updated.\n", newName)
+	fmt.Printf("  Re-export the config bundle with `tw export user %s`.\n", newName)
+	return nil
+}
+
+// promptUserEdit asks for a new name and mappings, showing the current
+// values. Empty answers keep the current values.
+func promptUserEdit(req *api.UpdateUserRequest) error {
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	users, err := o.ListUsers()
+	if err != nil {
+		return err
+	}
+	var current *ops.UserInfo
+	for i := range users {
+		if users[i].Name == req.Name {
+			current = &users[i]
+			break
+		}
+	}
+	if current == nil {
+		return fmt.Errorf("user %q not found", req.Name)
+	}
+
+	var mappings []string
+	for _, t := range current.Tunnels {
+		if t.RemoteHost == "127.0.0.1" {
+			mappings = append(mappings, fmt.Sprintf("%d:%d", t.LocalPort, t.RemotePort))
+		} else {
+			mappings = append(mappings, fmt.Sprintf("%d:%s", t.LocalPort, net.JoinHostPort(t.RemoteHost, strconv.Itoa(t.RemotePort))))
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Printf("  Name [%s]: ", current.Name)
+	scanner.Scan()
+	req.NewName = strings.TrimSpace(scanner.Text())
+
+	fmt.Printf("  Mappings, space-separated CLIENT:SERVER or CLIENT:HOST:PORT [%s]: ", strings.Join(mappings, " "))
+	scanner.Scan()
+	for _, field := range strings.Fields(scanner.Text()) {
+		pm, err := ops.ParsePortMapping(field)
+		if err != nil {
+			return err
+		}
+		req.Mappings = append(req.Mappings, pm)
+	}
+	return nil
+}
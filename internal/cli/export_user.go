@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/tunnelwhisperer/tw/internal/api"
 	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/installer"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
 )
 
 var exportCmd = &cobra.Command{
@@ -19,12 +25,74 @@ var exportCmd = &cobra.Command{
 
 var exportUserCmd = &cobra.Command{
 	Use:   "user <name>",
-	Short: "Export a user's config bundle as a zip file",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runExportUser,
+	Short: "Export a user's config bundle",
+	Long: `Export a user's config bundle as a zip file.
+
+With --installer the bundle is embedded, together with the tw binary, in a
+single script the user runs as root/Administrator. It installs tw, writes the
+config, and starts ` + "`tw connect`" + ` as a service (systemd on Linux, a
+startup task on Windows).
+
+  tw export user alice --installer
+  tw export user bob --installer --platform windows --binary ./bin/tw.exe
+
+By default the running tw executable is embedded, which only works when the
+client has the same OS and CPU architecture. Use --binary to embed another
+build.
+
+For clients that won't run tw, --format converts the bundle:
+
+  zip      the bundle tw connect reads (the default)
+  openssh  a zip to unpack into ~/.ssh: tw/config, a Host block with the
+           user's tunnels to Include from ~/.ssh/config, tw/known_hosts
+           and the user's key
+  putty    the private key as a PuTTY .ppk file, for PuTTY, plink or WinSCP
+  json     a descriptor of the relay, SSH login, keys and tunnels
+  xray     for proxy apps such as v2rayN, v2rayNG or Nekoray: an Xray
+           client config to import and a VLESS share link, with the
+           openssh files
+
+The SSH connection of the openssh and putty formats still goes through the
+relay: they expect an Xray client forwarding 127.0.0.1:54001 to it, as
+tw connect or the xray format's config runs.
+
+  tw export user alice --format openssh
+
+With --compact the zip bundle is printed as a single line of text instead,
+to paste through chat systems that mangle attachments; the client imports
+it with tw connect --import. It carries a checksum, so a string damaged in
+transit is refused. --encrypt encrypts it with a passphrase, asked for or
+taken from $TW_BUNDLE_PASSPHRASE, to send over another channel.
+
+  tw export user alice --compact --encrypt | pbcopy`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runExportUser,
+	ValidArgsFunction: completeArgs(userNames),
 }
 
+var (
+	exportInstallerFlag bool
+	exportPlatformFlag  string
+	exportBinaryFlag    string
+	exportFormatFlag    string
+	exportCompactFlag   bool
+	exportEncryptFlag   bool
+)
+
 func init() {
+	defaultPlatform := "linux"
+	if runtime.GOOS == "windows" {
+		defaultPlatform = "windows"
+	}
+	exportUserCmd.Flags().BoolVar(&exportInstallerFlag, "installer", false, "write a self-contained installer script instead of a zip")
+	exportUserCmd.Flags().StringVar(&exportPlatformFlag, "platform", defaultPlatform, "installer target: "+strings.Join(installer.Platforms, ", "))
+	exportUserCmd.Flags().StringVar(&exportBinaryFlag, "binary", "", "tw executable to embed in the installer (default: this executable)")
+	exportUserCmd.Flags().StringVar(&exportFormatFlag, "format", ops.ExportZip, "export format: "+strings.Join(ops.ExportFormats, ", "))
+	exportUserCmd.Flags().BoolVar(&exportCompactFlag, "compact", false, "print the bundle as a single string for tw connect --import")
+	exportUserCmd.Flags().BoolVar(&exportEncryptFlag, "encrypt", false, "encrypt the --compact string with a passphrase")
+	exportUserCmd.MarkFlagsMutuallyExclusive("compact", "installer")
+	exportUserCmd.MarkFlagsMutuallyExclusive("compact", "format")
+	exportUserCmd.RegisterFlagCompletionFunc("format", completeFlag(func() []string { return ops.ExportFormats }))
 	exportCmd.AddCommand(exportUserCmd)
 	rootCmd.AddCommand(exportCmd)
 }
@@ -34,9 +102,18 @@ func runExportUser(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	name := args[0]
+	if exportInstallerFlag && exportFormatFlag != ops.ExportZip {
+		return fmt.Errorf("--installer embeds the zip bundle and can't be combined with --format %s", exportFormatFlag)
+	}
+	if exportEncryptFlag && !exportCompactFlag {
+		return fmt.Errorf("--encrypt applies to --compact")
+	}
+	if !slices.Contains(ops.ExportFormats, exportFormatFlag) {
+		return fmt.Errorf("unknown format %q (use %s)", exportFormatFlag, strings.Join(ops.ExportFormats, ", "))
+	}
 
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	var data []byte
 	var err error
@@ -60,13 +137,117 @@ func runExportUser(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	filename := name + "-tw-config.zip"
-	outPath := filepath.Join(".", filename)
+	if exportInstallerFlag {
+		return writeInstaller(name, data)
+	}
+	if exportCompactFlag {
+		return printCompactBundle(name, data)
+	}
+
+	filename, out, err := ops.ExportBundle(name, data, exportFormatFlag)
+	if err != nil {
+		return err
+	}
+	// Unlike the zip, the converted files are used where they are written,
+	// private key included, so only their owner may read them.
+	mode := os.FileMode(0600)
+	if exportFormatFlag == ops.ExportZip {
+		mode = 0644
+	}
+	if err := os.WriteFile(filepath.Join(".", filename), out, mode); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+
+	fmt.Printf("  Exported %s (%d bytes)\n", filename, len(out))
+	switch exportFormatFlag {
+	case ops.ExportOpenSSH:
+		fmt.Printf("  On the client, unpack it into ~/.ssh, add \"Include tw/config\" to the top of ~/.ssh/config\n")
+		fmt.Printf("  and, with the relay tunnel up, run: ssh -N tw-%s\n", name)
+	case ops.ExportXray:
+		fmt.Printf("  On the client, import xray.json into the proxy app and set up ssh/tw as its README.txt says\n")
+	case ops.ExportPuTTY:
+		if b, err := ops.ParseBundle(data); err == nil {
+			fmt.Printf("  With the relay tunnel up, run: %s\n", plinkCommand(name, filename, b))
+		}
+	}
+	return nil
+}
+
+// printCompactBundle prints the bundle data as a compact string on stdout,
+// and what to do with it on stderr, so stdout can be piped to a clipboard.
+func printCompactBundle(name string, data []byte) error {
+	var passphrase string
+	if exportEncryptFlag {
+		var err error
+		if passphrase, err = readBundlePassphrase(true); err != nil {
+			return err
+		}
+	}
+	s, err := ops.EncodeCompactBundle(data, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Println(s)
+	fmt.Fprintf(os.Stderr, "  On the client, run: tw connect --import -  and paste the string above (%d characters)\n", len(s))
+	if passphrase != "" {
+		fmt.Fprintln(os.Stderr, "  Send the passphrase over another channel.")
+	} else {
+		fmt.Fprintf(os.Stderr, "  The string contains %s's private key; consider --encrypt.\n", name)
+	}
+	return nil
+}
+
+// plinkCommand returns the plink command line that opens the tunnels of
+// bundle b with the key file keyFile.
+func plinkCommand(name, keyFile string, b *ops.Bundle) string {
+	args := []string{"plink", "-N", "-i", keyFile, "-P", strconv.Itoa(twxray.ClientListenPort)}
+	for _, t := range b.Client.EnabledTunnels() {
+		args = append(args, "-L", fmt.Sprintf("%s:%s:%d", t.ListenAddr(), t.RemoteHost, t.RemotePort))
+	}
+	return strings.Join(append(args, b.Client.SSHUser+"@127.0.0.1"), " ")
+}
+
+// writeInstaller embeds the config bundle and a tw binary in an installer
+// script for the --platform target.
+func writeInstaller(name string, bundle []byte) error {
+	binPath := exportBinaryFlag
+	if binPath == "" {
+		if exportPlatformFlag != runtime.GOOS {
+			return fmt.Errorf("--binary is required for a %s installer when running on %s", exportPlatformFlag, runtime.GOOS)
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locating tw executable: %w", err)
+		}
+		binPath = exe
+	}
+	binary, err := os.ReadFile(binPath)
+	if err != nil {
+		return fmt.Errorf("reading tw binary: %w", err)
+	}
+
+	script, err := installer.Generate(installer.Config{
+		User:     name,
+		Platform: exportPlatformFlag,
+		Bundle:   bundle,
+		Binary:   binary,
+	})
+	if err != nil {
+		return err
+	}
 
-	if err := os.WriteFile(outPath, data, 0644); err != nil {
+	// The script carries the user's private key.
+	filename := installer.FileName(name, exportPlatformFlag)
+	if err := os.WriteFile(filepath.Join(".", filename), script, 0600); err != nil {
 		return fmt.Errorf("writing %s: %w", filename, err)
 	}
 
-	fmt.Printf("  Exported %s (%d bytes)\n", filename, len(data))
+	fmt.Printf("  Exported %s (%d bytes)\n", filename, len(script))
+	if exportPlatformFlag == "windows" {
+		fmt.Printf("  On the client, run as Administrator: powershell -ExecutionPolicy Bypass -File %s\n", filename)
+	} else {
+		fmt.Printf("  On the client, run: sudo sh %s\n", filename)
+	}
+	fmt.Println("  The installer contains the user's private key; send it over a trusted channel.")
 	return nil
 }
@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var linkCmd = &cobra.Command{
+	Use:   "link",
+	Short: "Let users fetch their config bundle from a link on the relay",
+	Long: `Hand out config bundles as links on the relay instead of by email or
+chat.
+
+With bundle links on, Caddy on the relay serves
+https://<relay domain>/claim/<token> and proxies it down the reverse
+tunnel to the server, which answers with the bundle of the token's user.
+A link works for 24 hours and once unless told otherwise, and can be
+revoked at any time. Only a hash of each token is kept, so the link is
+shown once, when it is made; make a new one if it is lost.
+
+The link lets whoever holds it download the user's private key: send it
+to the user only, and keep its lifetime and uses short.
+
+Examples:
+  tw link enable
+  tw link create alice
+  tw link create alice --expires 72 --uses 3
+  tw link list
+  tw link revoke 3f9a1c2e
+  tw link disable`,
+	RunE: runLinkList,
+}
+
+var linkCreateCmd = &cobra.Command{
+	Use:               "create <name>",
+	Short:             "Make a link a user can fetch their bundle from",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE:              runLinkCreate,
+}
+
+var linkListCmd = &cobra.Command{
+	Use:               "list [name]",
+	Short:             "List bundle links that still work",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE:              runLinkList,
+}
+
+var linkRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Stop a bundle link from working",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runLinkRevoke,
+}
+
+var linkEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Serve bundle links under /claim/ on the relay",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetBundleLinks(true)
+	},
+}
+
+var linkDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop serving bundle links and revoke them all",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetBundleLinks(false)
+	},
+}
+
+var (
+	linkExpiresFlag int
+	linkUsesFlag    int
+)
+
+func init() {
+	linkCreateCmd.Flags().IntVar(&linkExpiresFlag, "expires", 24, "hours the link works for (at most 720)")
+	linkCreateCmd.Flags().IntVar(&linkUsesFlag, "uses", 1, "times the link works (0 for any number until it expires)")
+	linkCmd.AddCommand(linkCreateCmd)
+	linkCmd.AddCommand(linkListCmd)
+	linkCmd.AddCommand(linkRevokeCmd)
+	linkCmd.AddCommand(linkEnableCmd)
+	linkCmd.AddCommand(linkDisableCmd)
+	rootCmd.AddCommand(linkCmd)
+}
+
+func runLinkCreate(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	if linkExpiresFlag < 1 {
+		return fmt.Errorf("--expires must be at least 1 hour")
+	}
+	req := &api.CreateBundleLinkRequest{Name: args[0], ExpiresHours: linkExpiresFlag, MaxUses: linkUsesFlag}
+
+	cfg, _ := config.Load()
+	var resp *api.BundleLinkResponse
+	if client := daemonClient(cfg); client != nil {
+		defer client.Close()
+		var err error
+		if resp, err = client.CreateBundleLink(context.Background(), req); err != nil {
+			return fmt.Errorf("creating bundle link: %w", err)
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		link, url, err := o.CreateBundleLink(req.Name, time.Duration(req.ExpiresHours)*time.Hour, req.MaxUses)
+		if err != nil {
+			return err
+		}
+		resp = &api.BundleLinkResponse{Link: *link, URL: url}
+	}
+
+	if structuredOutput() {
+		return printStructured(resp)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "  Bundle link %s for %q, valid until %s, %s:\n", resp.Link.ID, req.Name,
+		resp.Link.Expires.Local().Format("2006-01-02 15:04 MST"), linkUsesLabel(resp.Link))
+	fmt.Fprintln(os.Stderr)
+	fmt.Println(resp.URL)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "  Send it to the user only; it is not shown again. They open it in a browser,")
+	fmt.Fprintln(os.Stderr, "  or run tw connect --import URL. Append ?format=openssh, putty, json or xray")
+	fmt.Fprintln(os.Stderr, "  for those formats instead of the zip bundle.")
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+func runLinkList(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	cfg, _ := config.Load()
+	var resp *api.ListBundleLinksResponse
+	if client := daemonClient(cfg); client != nil {
+		defer client.Close()
+		var err error
+		if resp, err = client.ListBundleLinks(context.Background(), name); err != nil {
+			return fmt.Errorf("listing bundle links: %w", err)
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		resp = &api.ListBundleLinksResponse{Enabled: o.BundleLinksEnabled()}
+		if resp.Links, err = o.ListBundleLinks(name); err != nil {
+			return err
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(resp)
+	}
+	if !resp.Enabled {
+		fmt.Println("  Bundle links are off; tw link enable turns them on.")
+		return nil
+	}
+	if len(resp.Links) == 0 {
+		fmt.Println("  No bundle links.")
+		return nil
+	}
+	for _, l := range resp.Links {
+		used := "never used"
+		if l.LastUsed != nil {
+			used = "last used " + l.LastUsed.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %s  %-16s until %s, %s, %s\n", l.ID, l.User,
+			l.Expires.Local().Format("2006-01-02 15:04"), linkUsesLabel(l), used)
+	}
+	return nil
+}
+
+func runLinkRevoke(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	cfg, _ := config.Load()
+	if client := daemonClient(cfg); client != nil {
+		defer client.Close()
+		if err := client.RevokeBundleLink(context.Background(), args[0]); err != nil {
+			return fmt.Errorf("revoking bundle link: %w", err)
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if err := o.RevokeBundleLink(args[0]); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("  Bundle link %s revoked.\n", args[0])
+	return nil
+}
+
+func runSetBundleLinks(enabled bool) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	cfg, _ := config.Load()
+	if client := daemonClient(cfg); client != nil {
+		defer client.Close()
+		fmt.Println("  Updating relay through the running daemon...")
+		if err := client.SetBundleLinks(context.Background(), enabled); err != nil {
+			return fmt.Errorf("updating bundle links: %w", err)
+		}
+	} else {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if enabled {
+			err = o.EnableBundleLinks(context.Background(), cliProgress)
+		} else {
+			err = o.DisableBundleLinks(context.Background(), cliProgress)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if enabled {
+		fmt.Printf("  Bundle links on: https://%s/claim/…\n", cfg.Xray.RelayHost)
+		return nil
+	}
+	fmt.Println("  Bundle links off; all links revoked.")
+	return nil
+}
+
+// linkUsesLabel describes how often a bundle link still works.
+func linkUsesLabel(l ops.BundleLink) string {
+	switch left := l.MaxUses - l.Uses; {
+	case l.MaxUses == 0:
+		return "any number of uses"
+	case left == 1:
+		return "1 use left"
+	default:
+		return fmt.Sprintf("%d uses left", left)
+	}
+}
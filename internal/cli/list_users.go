@@ -3,6 +3,8 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/tunnelwhisperer/tw/internal/api"
@@ -31,7 +33,7 @@ func runListUsers(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	client, err := api.Dial(addr)
 	if err != nil {
@@ -44,8 +46,7 @@ func runListUsers(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("listing users: %w", err)
 	}
 
-	printUsers(resp.Users)
-	return nil
+	return printUsers(resp.Users)
 }
 
 func runListUsersLocal() error {
@@ -59,14 +60,20 @@ func runListUsersLocal() error {
 		return err
 	}
 
-	printUsers(users)
-	return nil
+	return printUsers(users)
 }
 
-func printUsers(users []ops.UserInfo) {
+func printUsers(users []ops.UserInfo) error {
+	if structuredOutput() {
+		if users == nil {
+			users = []ops.UserInfo{}
+		}
+		return printStructured(users)
+	}
+
 	if len(users) == 0 {
 		fmt.Println("  No users configured.")
-		return
+		return nil
 	}
 
 	fmt.Println()
@@ -75,9 +82,46 @@ func printUsers(users []ops.UserInfo) {
 		if u.UUID != "" {
 			fmt.Printf("    UUID: %s\n", u.UUID)
 		}
+		if u.Fingerprint != "" {
+			fmt.Printf("    Key:  %s\n", keyLabel(u))
+		} else if u.InviteExpires != nil {
+			fmt.Printf("    Key:  %s\n", inviteLabel(u))
+		}
+		if u.TOTP {
+			fmt.Println("    TOTP: on")
+		}
+		if u.BundleOutdated {
+			fmt.Printf("    Bundle: outdated — export it again with tw export user %s\n", u.Name)
+		}
 		for _, t := range u.Tunnels {
 			fmt.Printf("    Tunnel: localhost:%d → %s:%d\n", t.LocalPort, t.RemoteHost, t.RemotePort)
 		}
 	}
 	fmt.Println()
+	return nil
+}
+
+// keyLabel describes a user's key by its fingerprint and where it is held.
+func keyLabel(u ops.UserInfo) string {
+	key := orDash(u.Fingerprint)
+	var notes []string
+	if strings.HasSuffix(u.KeyType, "-sk") {
+		notes = append(notes, "security key")
+	}
+	if u.KeyImported {
+		notes = append(notes, "user's own key")
+	}
+	if len(notes) > 0 {
+		key += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return key
+}
+
+// inviteLabel describes the invitation of a user who has not claimed it
+// yet.
+func inviteLabel(u ops.UserInfo) string {
+	if u.InviteExpires.Before(time.Now()) {
+		return fmt.Sprintf("none — invitation expired on %s (renew it with tw user invite %s --renew)", u.InviteExpires.Local().Format("2006-01-02 15:04"), u.Name)
+	}
+	return fmt.Sprintf("none yet — invited, claim code valid until %s", u.InviteExpires.Local().Format("2006-01-02 15:04"))
 }
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/logging"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show or follow the running daemon's log",
+	Long: `Print the running tw daemon's recent log entries — the ones the
+dashboard console shows — and with -f keep printing new ones until Ctrl-C.
+
+The daemon keeps its last 500 entries. --level keeps entries at that level
+and above, --component those of the given components (ssh, xray, tunnel,
+terraform, comma-separated), --since those logged within a duration or
+since a time, and --grep those containing the text (ignoring case). With
+--output json or yaml, each entry is one JSON line or YAML document.
+
+Examples:
+  tw logs
+  tw logs -f --level warn
+  tw logs --component tunnel,ssh --since 15m
+  tw logs --since 2026-10-17T09:00:00Z --grep alice`,
+	Args: cobra.NoArgs,
+	RunE: runLogs,
+}
+
+var (
+	logsFollowFlag    bool
+	logsLevelFlag     string
+	logsComponentFlag string
+	logsSinceFlag     string
+	logsGrepFlag      string
+)
+
+func init() {
+	logsCmd.Flags().BoolVarP(&logsFollowFlag, "follow", "f", false, "keep printing new entries")
+	logsCmd.Flags().StringVar(&logsLevelFlag, "level", "", "only entries at this level or above (debug, info, warn, error)")
+	logsCmd.Flags().StringVar(&logsComponentFlag, "component", "", "only entries of these components (ssh, xray, tunnel, terraform)")
+	logsCmd.Flags().StringVar(&logsSinceFlag, "since", "", "only entries logged within this duration (e.g. 15m) or since this RFC 3339 time")
+	logsCmd.Flags().StringVar(&logsGrepFlag, "grep", "", "only entries containing this text")
+	rootCmd.AddCommand(logsCmd)
+}
+
+// parseSince reads --since: a duration back from now, or an RFC 3339 time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want a duration like 15m or an RFC 3339 time)", s)
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	since, err := parseSince(logsSinceFlag, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := logging.NewFilter(logsLevelFlag, logsComponentFlag, logsGrepFlag); err != nil {
+		return err
+	}
+	cfg, _ := config.Load()
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		return fmt.Errorf("no tw daemon running — the log is kept by `tw serve` or `tw dashboard`")
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stream, err := client.StreamLogs(ctx, &api.StreamLogsRequest{
+		Level:     logsLevelFlag,
+		Component: logsComponentFlag,
+		Filter:    logsGrepFlag,
+		Since:     since,
+		Follow:    logsFollowFlag,
+	})
+	if err != nil {
+		return fmt.Errorf("reading the log: %w", err)
+	}
+	for {
+		e, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("reading the log: %w", err)
+		}
+		if structuredOutput() {
+			if err := printStructuredLine(e); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Print(e.Line())
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat is set by the global --output flag. Empty means the default
+// human-readable text output.
+var outputFormat string
+
+// validateOutputFormat rejects unknown --output values before a command runs.
+func validateOutputFormat() error {
+	switch outputFormat {
+	case "", "text", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q (must be text, json, or yaml)", outputFormat)
+}
+
+// structuredOutput reports whether the user asked for machine-readable output.
+func structuredOutput() bool {
+	return outputFormat == "json" || outputFormat == "yaml"
+}
+
+// printStructured writes v to stdout in the selected --output format.
+func printStructured(v interface{}) error {
+	switch outputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		// Round-trip through JSON so YAML keys match the json struct tags
+		// (and the API field names) instead of yaml.v3's lowercased names.
+		// JSON is valid YAML, so decoding into a node keeps field order.
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return err
+		}
+		clearYAMLStyle(&node)
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(&node)
+	}
+	return fmt.Errorf("unsupported output format %q", outputFormat)
+}
+
+// printStructuredLine writes v, one of a stream of values, to stdout in the
+// selected --output format: a line of JSON, or a YAML document.
+func printStructuredLine(v interface{}) error {
+	if outputFormat == "json" {
+		return json.NewEncoder(os.Stdout).Encode(v)
+	}
+	fmt.Println("---")
+	return printStructured(v)
+}
+
+// clearYAMLStyle resets the flow/quoted styles inherited from JSON input so
+// the encoder emits regular block-style YAML.
+func clearYAMLStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		clearYAMLStyle(c)
+	}
+}
@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Expose server-side services on a public relay port",
+	Long: `Expose a service reachable from the server directly on the relay,
+without distributing client bundles.
+
+Each published service gets a reverse forward from the server to a relay
+loopback port and an Xray inbound on the public port, which is also opened
+in the relay firewall. Anyone who can reach the relay can connect, so only
+publish services that do their own authentication.
+
+Ports 80 and 443 belong to Caddy and cannot be published. HTTP services
+can instead share port 443 under their own hostname with --host: Caddy on
+the relay gets a site for the hostname, obtains a certificate for it, and
+proxies requests down the tunnel. Point the hostname's DNS at the relay
+first.
+
+Examples:
+  tw publish add 8443:app.internal:8443
+  tw publish add 2525:127.0.0.1:25 --name smtp
+  tw publish add --host wiki.example.com app.internal:8080
+  tw publish add --host git.example.com --upstream-tls 127.0.0.1:3443
+  tw publish list
+  tw publish remove 8443
+  tw publish remove wiki.example.com`,
+	RunE: runPublishList,
+}
+
+var publishAddCmd = &cobra.Command{
+	Use:   "add <public-port>:<host>:<port> | --host <hostname> <host>:<port>",
+	Short: "Publish a service on a relay port",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPublishAdd,
+}
+
+var publishListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List published services",
+	RunE:  runPublishList,
+}
+
+var publishRemoveCmd = &cobra.Command{
+	Use:               "remove <public-port|hostname>",
+	Short:             "Stop publishing the service on a relay port or hostname",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runPublishRemove,
+	ValidArgsFunction: completeArgs(publishedNames),
+}
+
+var (
+	publishNameFlag        string
+	publishHostFlag        string
+	publishUpstreamTLSFlag bool
+)
+
+func init() {
+	publishAddCmd.Flags().StringVar(&publishNameFlag, "name", "", "label shown in status output (default publish-<port> or the hostname)")
+	publishAddCmd.Flags().StringVar(&publishHostFlag, "host", "", "publish as https://<hostname> on relay port 443")
+	publishAddCmd.Flags().BoolVar(&publishUpstreamTLSFlag, "upstream-tls", false, "the service speaks HTTPS (with --host; its certificate is not verified)")
+	publishCmd.AddCommand(publishAddCmd)
+	publishCmd.AddCommand(publishListCmd)
+	publishCmd.AddCommand(publishRemoveCmd)
+	rootCmd.AddCommand(publishCmd)
+}
+
+func runPublishList(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+
+	var pubs []ops.Publication
+	cfg, _ := config.Load()
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		pubs = o.ListPublished()
+	} else {
+		defer client.Close()
+		pubs, err = client.ListPublished(context.Background())
+		if err != nil {
+			return fmt.Errorf("listing published services: %w", err)
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(pubs)
+	}
+	if len(pubs) == 0 {
+		fmt.Println("  No published services.")
+		return nil
+	}
+	for _, p := range pubs {
+		if p.Host != "" {
+			fmt.Printf("  %-20s https://%s → %s\n", p.Name, p.Host, p.Target)
+			continue
+		}
+		fmt.Printf("  %-20s relay :%d → %s\n", p.Name, p.PublicPort, p.Target)
+	}
+	return nil
+}
+
+func runPublishAdd(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	var (
+		publicPort int
+		target     string
+		err        error
+	)
+	if publishHostFlag != "" {
+		target = args[0]
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid target %q (want HOST:PORT)", target)
+		}
+	} else {
+		if publishUpstreamTLSFlag {
+			return fmt.Errorf("--upstream-tls requires --host")
+		}
+		publicPort, target, err = ops.ParsePublishSpec(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, _ := config.Load()
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if publishHostFlag != "" {
+			err = o.PublishHost(context.Background(), publishNameFlag, publishHostFlag, target, publishUpstreamTLSFlag, cliProgress)
+		} else {
+			err = o.Publish(context.Background(), publishNameFlag, publicPort, target, cliProgress)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		defer client.Close()
+		fmt.Println("  Updating relay through the running daemon...")
+		req := &api.PublishRequest{
+			Name:        publishNameFlag,
+			PublicPort:  publicPort,
+			Host:        publishHostFlag,
+			Target:      target,
+			UpstreamTLS: publishUpstreamTLSFlag,
+		}
+		if err := client.Publish(context.Background(), req); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+	}
+
+	if publishHostFlag != "" {
+		fmt.Printf("  Published %s at https://%s\n", target, publishHostFlag)
+		return nil
+	}
+	fmt.Printf("  Published %s on %s:%d\n", target, cfg.Xray.RelayHost, publicPort)
+	return nil
+}
+
+func runPublishRemove(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	// A numeric argument is a public port; anything else is a hostname.
+	req := &api.UnpublishRequest{}
+	if port, err := strconv.Atoi(args[0]); err == nil {
+		req.PublicPort = port
+	} else {
+		req.Host = args[0]
+	}
+
+	cfg, _ := config.Load()
+	client, err := api.Dial(ops.APIAddr(cfg))
+	if err != nil {
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if req.Host != "" {
+			err = o.UnpublishHost(context.Background(), req.Host, cliProgress)
+		} else {
+			err = o.Unpublish(context.Background(), req.PublicPort, cliProgress)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		defer client.Close()
+		if err := client.Unpublish(context.Background(), req); err != nil {
+			return fmt.Errorf("unpublishing: %w", err)
+		}
+	}
+
+	if req.Host != "" {
+		fmt.Printf("  %s is no longer published.\n", req.Host)
+		return nil
+	}
+	fmt.Printf("  Relay port %d is no longer published.\n", req.PublicPort)
+	return nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayRebootCmd = &cobra.Command{
+	Use:   "reboot",
+	Short: "Reboot the relay and wait until it is back",
+	Long: `Reboot the relay, wait until it answers again, and reconnect the reverse
+tunnel. Tunnels are down while it boots, usually for a minute or two.
+
+The running server also reboots the relay by itself in
+server.relay_maintenance when OS updates need it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRelayAction(ops.RelayActionReboot, "Reboot the relay?", "Relay rebooted")
+	},
+}
+
+var relayRestartCmd = &cobra.Command{
+	Use:   "restart <xray|caddy>",
+	Short: "Restart Xray or Caddy on the relay",
+	Long: `Restart the relay's Xray or Caddy service and wait until the relay can be
+reached through it again. Open tunnels drop and reconnect.
+
+Examples:
+  tw relay restart xray
+  tw relay restart caddy --yes`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"xray", "caddy"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service := args[0]
+		if service != "xray" && service != "caddy" {
+			return fmt.Errorf("unknown relay service %q (want xray or caddy)", service)
+		}
+		return runRelayAction("restart-"+service, fmt.Sprintf("Restart %s on the relay?", service), fmt.Sprintf("Relay %s restarted", service))
+	},
+}
+
+var relayPoweroffCmd = &cobra.Command{
+	Use:   "poweroff",
+	Short: "Shut the relay down",
+	Long: `Power the relay off. Tunnels stay down until it is started again from the
+provider's console; providers keep billing a stopped server. To remove it
+for good, use ` + "`tw destroy relay-server`" + `.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRelayAction(ops.RelayActionPoweroff, "Power off the relay? It must be started again from the provider's console.", "Relay powering off")
+	},
+}
+
+var relayActionYesFlag bool
+
+func init() {
+	for _, c := range []*cobra.Command{relayRebootCmd, relayRestartCmd, relayPoweroffCmd} {
+		c.Flags().BoolVarP(&relayActionYesFlag, "yes", "y", false, "skip the confirmation prompt")
+		relayCmd.AddCommand(c)
+	}
+}
+
+// runRelayAction asks for confirmation unless --yes is given, then runs
+// action on the relay.
+func runRelayAction(action, prompt, done string) error {
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if !relayActionYesFlag {
+		scanner := bufio.NewScanner(os.Stdin)
+		fmt.Printf("  %s [y/N]: ", prompt)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+		fmt.Println()
+	}
+	if err := o.RelayAction(context.Background(), action, cliProgress); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("  %s\n", done)
+	return nil
+}
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayAdoptCmd = &cobra.Command{
+	Use:   "adopt <host>",
+	Short: "Turn an existing server into the relay",
+	Long: `Install the relay on a server you already run and make it the active relay.
+
+tw connects to the server over SSH as --user (root by default; other users
+need passwordless sudo) with --ssh-key, and runs the same install script as
+a manual setup: Xray, Caddy on ports 80 and 443, ufw allowing only those,
+and sshd listening on loopback only. From then on the server is reached
+through the tunnel, like a provisioned relay. It must run Ubuntu or Debian.
+
+With --provider and --server-id the server is also imported into Terraform
+state, so the relay firewall can be managed with ` + "`tw relay firewall`" + ` and
+` + "`tw destroy relay-server`" + ` deletes it. Terraform then creates the provider's
+firewall for it; the server's image, size and region stay as they are.
+Without them, or when the import fails, the relay is recorded as a manual
+relay, along with the server's OS, hostname and the provider details given.
+
+Examples:
+  tw relay adopt 203.0.113.10 --domain relay.example.com
+  tw relay adopt vps.example.net --user ubuntu --ssh-key ~/.ssh/vps --domain relay.example.com
+  tw relay adopt 203.0.113.10 --domain relay.example.com \
+      --provider hetzner --server-id 4711 --token-env HCLOUD_TOKEN`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRelayAdopt,
+}
+
+var (
+	adoptDomainFlag    string
+	adoptUserFlag      string
+	adoptPortFlag      int
+	adoptKeyFlag       string
+	adoptCDNFlag       bool
+	adoptProviderFlag  string
+	adoptServerIDFlag  string
+	adoptTokenEnvFlag  string
+	adoptSecretEnvFlag string
+	adoptRegionFlag    string
+	adoptYesFlag       bool
+
+	adoptACMEDNSFlag         string
+	adoptACMEDNSTokenEnvFlag string
+)
+
+func init() {
+	f := relayAdoptCmd.Flags()
+	f.StringVar(&adoptDomainFlag, "domain", "", "relay domain, pointing at the server (e.g. relay.example.com)")
+	f.StringVar(&adoptUserFlag, "user", "root", "SSH user on the server")
+	f.IntVar(&adoptPortFlag, "port", 22, "SSH port of the server")
+	f.StringVar(&adoptKeyFlag, "ssh-key", "~/.ssh/id_ed25519", "private key the server accepts")
+	f.BoolVar(&adoptCDNFlag, "cdn", false, "run the relay behind Cloudflare (WebSocket transport, proxied DNS record)")
+	f.StringVar(&adoptACMEDNSFlag, "acme-dns", "", "issue TLS certificates with the DNS challenge via this provider ("+strings.Join(ops.ACMEDNSProviders, ", ")+")")
+	f.StringVar(&adoptACMEDNSTokenEnvFlag, "acme-dns-token-env", "", "environment variable holding the DNS provider API token (with --acme-dns)")
+	f.StringVar(&adoptProviderFlag, "provider", "", "cloud provider of the server, to import it into Terraform (hetzner, digitalocean, aws)")
+	f.StringVar(&adoptServerIDFlag, "server-id", "", "the provider's ID for the server (AWS: instance ID)")
+	f.StringVar(&adoptTokenEnvFlag, "token-env", "", "environment variable holding the provider API token (AWS: access key ID)")
+	f.StringVar(&adoptSecretEnvFlag, "secret-env", "AWS_SECRET_ACCESS_KEY", "environment variable holding the AWS secret access key")
+	f.StringVar(&adoptRegionFlag, "region", "", "provider region of the server (required for AWS)")
+	f.BoolVarP(&adoptYesFlag, "yes", "y", false, "skip the confirmation prompt")
+	relayCmd.AddCommand(relayAdoptCmd)
+}
+
+func runRelayAdopt(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	if adoptDomainFlag == "" {
+		return fmt.Errorf("--domain is required")
+	}
+	req := ops.AdoptRelayRequest{
+		Host:        args[0],
+		SSHPort:     adoptPortFlag,
+		SSHUser:     adoptUserFlag,
+		Domain:      adoptDomainFlag,
+		CDN:         adoptCDNFlag,
+		ProviderKey: adoptProviderFlag,
+		ServerID:    adoptServerIDFlag,
+		Region:      adoptRegionFlag,
+	}
+
+	key, err := readSSHKeyFlag(adoptKeyFlag)
+	if err != nil {
+		return err
+	}
+	req.SSHKey = key
+
+	if adoptACMEDNSFlag != "" {
+		if adoptACMEDNSTokenEnvFlag == "" {
+			return fmt.Errorf("--acme-dns requires --acme-dns-token-env")
+		}
+		req.ACMEDNS.Provider = strings.ToLower(adoptACMEDNSFlag)
+		if req.ACMEDNS.Token, err = envFlag("acme-dns-token-env", adoptACMEDNSTokenEnvFlag); err != nil {
+			return err
+		}
+	} else if adoptACMEDNSTokenEnvFlag != "" {
+		return fmt.Errorf("--acme-dns-token-env requires --acme-dns")
+	}
+	if adoptProviderFlag != "" {
+		if adoptTokenEnvFlag == "" {
+			return fmt.Errorf("--provider requires --token-env")
+		}
+		if req.Token, err = envFlag("token-env", adoptTokenEnvFlag); err != nil {
+			return err
+		}
+		if adoptProviderFlag == "aws" {
+			if req.AWSSecretKey, err = envFlag("secret-env", adoptSecretEnvFlag); err != nil {
+				return err
+			}
+		}
+	}
+
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	if !adoptYesFlag {
+		fmt.Println()
+		fmt.Printf("  Server: %s@%s\n", req.SSHUser, req.Host)
+		fmt.Printf("  Domain: %s\n", req.Domain)
+		fmt.Println()
+		fmt.Println("  The relay takes over ports 80 and 443, ufw is enabled for those only,")
+		fmt.Println("  and sshd will listen on loopback only: SSH goes through the tunnel.")
+		fmt.Print("  Adopt this server as the relay? [y/N]: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+		fmt.Println()
+	}
+
+	if err := o.AdoptRelay(context.Background(), req, cliProgress); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("  %s is the relay for %s\n", req.Host, req.Domain)
+	return nil
+}
+
+// readSSHKeyFlag reads the private key named by an --ssh-key flag, which
+// may start with ~/.
+func readSSHKeyFlag(path string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key: %w", err)
+	}
+	return key, nil
+}
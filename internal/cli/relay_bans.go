@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayBansCmd = &cobra.Command{
+	Use:   "bans",
+	Short: "Show sources fail2ban has banned on the relay",
+	Long: `List and lift the relay's fail2ban bans.
+
+The relay runs fail2ban on Caddy's access log: a source that keeps
+requesting anything but the tunnel path is blocked at the firewall on
+ports 80 and 443. Limits follow rate_limit, each further ban doubling in
+length. Relays behind a CDN don't run the jail.
+
+Examples:
+  tw relay bans
+  tw relay bans clear 203.0.113.7
+  tw relay bans clear`,
+	Args: cobra.NoArgs,
+	RunE: runRelayBansList,
+}
+
+var relayBansListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List banned sources",
+	Args:  cobra.NoArgs,
+	RunE:  runRelayBansList,
+}
+
+var relayBansClearCmd = &cobra.Command{
+	Use:   "clear [ip...]",
+	Short: "Lift bans on the given addresses, or all bans",
+	RunE:  runRelayBansClear,
+}
+
+func init() {
+	relayBansCmd.AddCommand(relayBansListCmd)
+	relayBansCmd.AddCommand(relayBansClearCmd)
+	relayCmd.AddCommand(relayBansCmd)
+}
+
+// relayOps checks that a relay is configured and returns an Ops for it.
+func relayOps() (*ops.Ops, error) {
+	if err := requireMode("server"); err != nil {
+		return nil, err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return nil, fmt.Errorf("initializing: %w", err)
+	}
+	if o.Config().Xray.RelayHost == "" {
+		return nil, fmt.Errorf("no relay configured — run `tw create relay-server` first")
+	}
+	return o, nil
+}
+
+func runRelayBansList(cmd *cobra.Command, args []string) error {
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	bans, err := o.RelayBans()
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return printStructured(bans)
+	}
+	if len(bans) == 0 {
+		fmt.Println("  No sources banned on the relay.")
+		return nil
+	}
+
+	fmt.Println()
+	for _, b := range bans {
+		until := "-"
+		if b.Until != nil {
+			until = "until " + b.Until.Format("2006-01-02 15:04:05") + " UTC"
+		}
+		fmt.Printf("  %-40s %s\n", b.IP, until)
+	}
+	fmt.Println()
+	return nil
+}
+
+func runRelayBansClear(cmd *cobra.Command, args []string) error {
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if err := o.ClearRelayBans(args); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println("  Lifted all bans on the relay")
+		return nil
+	}
+	for _, ip := range args {
+		fmt.Printf("  Lifted ban on %s\n", ip)
+	}
+	return nil
+}
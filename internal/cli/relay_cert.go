@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayCertCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Show the relay's TLS certificates and their expiry",
+	Long: `Check the TLS certificate the relay serves for its domain and for every
+hostname published with ` + "`tw publish --host`" + `.
+
+Caddy renews certificates about 30 days before they expire. One with fewer
+than 14 days left means renewal is failing; --reload reloads Caddy on the
+relay, which retries it. A running server does this on its own when
+server.cert_auto_reload is set.`,
+	RunE: runRelayCert,
+}
+
+var relayCertReloadFlag bool
+
+func init() {
+	relayCertCmd.Flags().BoolVar(&relayCertReloadFlag, "reload", false, "reload Caddy on the relay to retry certificate renewal")
+	relayCmd.AddCommand(relayCertCmd)
+}
+
+func runRelayCert(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	if o.Config().Xray.RelayHost == "" {
+		return fmt.Errorf("no relay configured — run `tw create relay-server` first")
+	}
+
+	if relayCertReloadFlag {
+		fmt.Println("  Reloading Caddy on the relay...")
+		if err := o.ReloadRelayCaddy(); err != nil {
+			return fmt.Errorf("reloading Caddy: %w", err)
+		}
+	}
+
+	certs := o.CheckRelayCerts()
+	if structuredOutput() {
+		return printStructured(certs)
+	}
+	for _, c := range certs {
+		printRelayCert(c)
+	}
+	return nil
+}
+
+// printRelayCert prints one certificate line, with a warning or error line
+// below it when needed.
+func printRelayCert(c ops.RelayCert) {
+	if c.NotAfter == nil {
+		fmt.Printf("    cert %s  unavailable\n", c.Host)
+		fmt.Printf("      Error: %s\n", c.Error)
+		return
+	}
+	fmt.Printf("    cert %s  valid until %s (%d days, %s)\n", c.Host, c.NotAfter.Format("2006-01-02"), c.DaysLeft, orDash(c.Issuer))
+	if c.Expiring {
+		fmt.Println("      Warning: expires in less than 14 days — renewal appears stuck")
+	}
+	if c.Error != "" {
+		fmt.Printf("      Error: %s\n", c.Error)
+	}
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayDeployCmd = &cobra.Command{
+	Use:   "deploy <host>",
+	Short: "Run the relay as containers on a Docker or Podman host",
+	Long: `Deploy the relay as containers on a host you already run Docker or Podman on.
+
+tw connects to the host over SSH as --user (root by default; other users
+need passwordless sudo) with --ssh-key, copies a docker-compose bundle of
+Caddy and Xray to /opt/tw-relay and starts it with docker compose,
+docker-compose or podman-compose. Both containers use the host network and
+take ports 80 and 443. The host's sshd and firewall are left as they are;
+the server's SSH key is added for server.relay_ssh_user, which is created
+if missing, so the server reaches the host through the tunnel like any
+relay.
+
+The bundle is also kept in the relay directory. ` + "`tw relay test`" + ` and
+` + "`tw destroy relay-server`" + ` work as for other relays; destroying stops the
+containers and removes the bundle and the server's access from the host.
+
+Examples:
+  tw relay deploy 203.0.113.10 --domain relay.example.com
+  tw relay deploy docker.example.net --user ubuntu --ssh-key ~/.ssh/docker --domain relay.example.com`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRelayDeploy,
+}
+
+var (
+	deployDomainFlag string
+	deployUserFlag   string
+	deployPortFlag   int
+	deployKeyFlag    string
+	deployCDNFlag    bool
+	deployYesFlag    bool
+
+	deployACMEDNSFlag         string
+	deployACMEDNSTokenEnvFlag string
+)
+
+func init() {
+	f := relayDeployCmd.Flags()
+	f.StringVar(&deployDomainFlag, "domain", "", "relay domain, pointing at the host (e.g. relay.example.com)")
+	f.StringVar(&deployUserFlag, "user", "root", "SSH user on the host")
+	f.IntVar(&deployPortFlag, "port", 22, "SSH port of the host")
+	f.StringVar(&deployKeyFlag, "ssh-key", "~/.ssh/id_ed25519", "private key the host accepts")
+	f.BoolVar(&deployCDNFlag, "cdn", false, "run the relay behind Cloudflare (WebSocket transport, proxied DNS record)")
+	f.StringVar(&deployACMEDNSFlag, "acme-dns", "", "issue TLS certificates with the DNS challenge via this provider ("+strings.Join(ops.ACMEDNSProviders, ", ")+")")
+	f.StringVar(&deployACMEDNSTokenEnvFlag, "acme-dns-token-env", "", "environment variable holding the DNS provider API token (with --acme-dns)")
+	f.BoolVarP(&deployYesFlag, "yes", "y", false, "skip the confirmation prompt")
+	relayCmd.AddCommand(relayDeployCmd)
+}
+
+func runRelayDeploy(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	if deployDomainFlag == "" {
+		return fmt.Errorf("--domain is required")
+	}
+	req := ops.ContainerRelayRequest{
+		Host:    args[0],
+		SSHPort: deployPortFlag,
+		SSHUser: deployUserFlag,
+		Domain:  deployDomainFlag,
+		CDN:     deployCDNFlag,
+	}
+
+	key, err := readSSHKeyFlag(deployKeyFlag)
+	if err != nil {
+		return err
+	}
+	req.SSHKey = key
+
+	if deployACMEDNSFlag != "" {
+		if deployACMEDNSTokenEnvFlag == "" {
+			return fmt.Errorf("--acme-dns requires --acme-dns-token-env")
+		}
+		req.ACMEDNS.Provider = strings.ToLower(deployACMEDNSFlag)
+		if req.ACMEDNS.Token, err = envFlag("acme-dns-token-env", deployACMEDNSTokenEnvFlag); err != nil {
+			return err
+		}
+	} else if deployACMEDNSTokenEnvFlag != "" {
+		return fmt.Errorf("--acme-dns-token-env requires --acme-dns")
+	}
+
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	if !deployYesFlag {
+		fmt.Println()
+		fmt.Printf("  Host:   %s@%s\n", req.SSHUser, req.Host)
+		fmt.Printf("  Domain: %s\n", req.Domain)
+		fmt.Println()
+		fmt.Println("  Caddy and Xray containers will take ports 80 and 443 on the host.")
+		fmt.Print("  Deploy the relay there? [y/N]: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+		fmt.Println()
+	}
+
+	if err := o.DeployContainerRelay(context.Background(), req, cliProgress); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("  %s runs the relay for %s\n", req.Host, req.Domain)
+	return nil
+}
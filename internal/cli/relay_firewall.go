@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var relayFirewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Open relay ports and allow direct SSH in the relay's cloud firewall",
+	Long: `Manage what the relay's cloud firewall lets in besides ports 80 and 443.
+
+Changes go through Terraform to the provider's security group or firewall,
+then to ufw on the relay, and are kept in server.relay_firewall so a
+re-provisioned relay gets them too. Ports are TCP unless given as
+<port>/udp; --from limits a port to addresses or CIDRs.
+
+By default the relay's SSH server listens on loopback only and is reached
+through the tunnel. ` + "`ssh`" + ` lets the given sources reach port 22 directly,
+which helps when the tunnel is down; ` + "`ssh --clear`" + ` closes it again.
+
+Only relays provisioned with ` + "`tw create relay-server`" + ` have a cloud firewall
+tw can manage. After editing server.relay_firewall in config.yaml, run
+` + "`tw relay firewall apply`" + `.
+
+Examples:
+  tw relay firewall
+  tw relay firewall open 8443
+  tw relay firewall open 51820/udp --from 203.0.113.0/24
+  tw relay firewall close 8443
+  tw relay firewall ssh 198.51.100.7
+  tw relay firewall ssh --clear`,
+	Args: cobra.NoArgs,
+	RunE: runRelayFirewallList,
+}
+
+var relayFirewallListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the relay's extra firewall rules",
+	Args:  cobra.NoArgs,
+	RunE:  runRelayFirewallList,
+}
+
+var relayFirewallOpenCmd = &cobra.Command{
+	Use:   "open <port>[/tcp|/udp]",
+	Short: "Open a relay port",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRelayFirewallOpen,
+}
+
+var relayFirewallCloseCmd = &cobra.Command{
+	Use:   "close <port>[/tcp|/udp]",
+	Short: "Close a relay port opened with open",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRelayFirewallClose,
+}
+
+var relayFirewallSSHCmd = &cobra.Command{
+	Use:   "ssh <address|cidr>... | --clear",
+	Short: "Let sources reach the relay's SSH port directly",
+	RunE:  runRelayFirewallSSH,
+}
+
+var relayFirewallApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply server.relay_firewall from the config to the relay",
+	Args:  cobra.NoArgs,
+	RunE:  runRelayFirewallApply,
+}
+
+var (
+	relayFirewallFromFlag  []string
+	relayFirewallClearFlag bool
+)
+
+func init() {
+	relayFirewallOpenCmd.Flags().StringSliceVar(&relayFirewallFromFlag, "from", nil, "only allow these addresses or CIDRs (repeatable; default everyone)")
+	relayFirewallSSHCmd.Flags().BoolVar(&relayFirewallClearFlag, "clear", false, "close direct SSH; reach the relay through the tunnel only")
+	relayFirewallCmd.AddCommand(relayFirewallListCmd)
+	relayFirewallCmd.AddCommand(relayFirewallOpenCmd)
+	relayFirewallCmd.AddCommand(relayFirewallCloseCmd)
+	relayFirewallCmd.AddCommand(relayFirewallSSHCmd)
+	relayFirewallCmd.AddCommand(relayFirewallApplyCmd)
+	relayCmd.AddCommand(relayFirewallCmd)
+}
+
+// parseRelayPort splits "8443" or "51820/udp" into port and protocol.
+func parseRelayPort(s string) (int, string, error) {
+	portStr, proto, _ := strings.Cut(s, "/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid port %q (want PORT or PORT/udp)", s)
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+	proto = strings.ToLower(proto)
+	if proto != "tcp" && proto != "udp" {
+		return 0, "", fmt.Errorf("invalid protocol %q (want tcp or udp)", proto)
+	}
+	return port, proto, nil
+}
+
+func runRelayFirewallList(cmd *cobra.Command, args []string) error {
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	fw := o.RelayFirewall()
+	if structuredOutput() {
+		return printStructured(fw)
+	}
+
+	fmt.Println()
+	fmt.Printf("  %-12s %s\n", "80/tcp", "everyone (Caddy)")
+	fmt.Printf("  %-12s %s\n", "443/tcp", "everyone (Caddy)")
+	for _, p := range fw.Ports {
+		from := "everyone"
+		if len(p.Sources) > 0 {
+			from = strings.Join(p.Sources, ", ")
+		}
+		fmt.Printf("  %-12s %s\n", fmt.Sprintf("%d/%s", p.Port, p.Proto()), from)
+	}
+	ssh := "through the tunnel only"
+	if len(fw.SSHSources) > 0 {
+		ssh = strings.Join(fw.SSHSources, ", ")
+	}
+	fmt.Printf("  %-12s %s\n", "22/tcp", ssh)
+	fmt.Println()
+	return nil
+}
+
+func runRelayFirewallOpen(cmd *cobra.Command, args []string) error {
+	port, proto, err := parseRelayPort(args[0])
+	if err != nil {
+		return err
+	}
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if err := o.OpenRelayPort(context.Background(), port, proto, relayFirewallFromFlag, cliProgress); err != nil {
+		return err
+	}
+	fmt.Printf("  Opened relay port %d/%s\n", port, proto)
+	return nil
+}
+
+func runRelayFirewallClose(cmd *cobra.Command, args []string) error {
+	port, proto, err := parseRelayPort(args[0])
+	if err != nil {
+		return err
+	}
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if err := o.CloseRelayPort(context.Background(), port, proto, cliProgress); err != nil {
+		return err
+	}
+	fmt.Printf("  Closed relay port %d/%s\n", port, proto)
+	return nil
+}
+
+func runRelayFirewallSSH(cmd *cobra.Command, args []string) error {
+	if relayFirewallClearFlag == (len(args) > 0) {
+		return fmt.Errorf("give the addresses allowed to SSH to the relay, or --clear")
+	}
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if err := o.SetRelaySSHSources(context.Background(), args, cliProgress); err != nil {
+		return err
+	}
+	if relayFirewallClearFlag {
+		fmt.Println("  Relay SSH is reachable through the tunnel only")
+		return nil
+	}
+	fmt.Printf("  Relay SSH is reachable from %s\n", strings.Join(args, ", "))
+	return nil
+}
+
+func runRelayFirewallApply(cmd *cobra.Command, args []string) error {
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+	if err := o.ApplyRelayFirewall(context.Background(), cliProgress); err != nil {
+		return err
+	}
+	fmt.Println("  Relay firewall applied")
+	return nil
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var relayLogsCmd = &cobra.Command{
+	Use:   "logs [xray|caddy|cloud-init]",
+	Short: "Show or follow the relay's Xray, Caddy or cloud-init log",
+	Long: `Print the last lines of a relay log over SSH, without opening a shell.
+
+xray (the default) and caddy are read from the systemd journal; cloud-init
+is the provisioning log. -f keeps printing new lines until Ctrl-C, and
+--grep keeps only lines containing the text (ignoring case).
+
+Examples:
+  tw relay logs
+  tw relay logs caddy -n 500
+  tw relay logs xray -f --grep rejected`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: ops.RelayLogNames(),
+	RunE:      runRelayLogs,
+}
+
+var (
+	relayLogsFollowFlag bool
+	relayLogsLinesFlag  int
+	relayLogsGrepFlag   string
+)
+
+func init() {
+	relayLogsCmd.Flags().BoolVarP(&relayLogsFollowFlag, "follow", "f", false, "keep printing new lines")
+	relayLogsCmd.Flags().IntVarP(&relayLogsLinesFlag, "lines", "n", 100, "number of past lines to print")
+	relayLogsCmd.Flags().StringVar(&relayLogsGrepFlag, "grep", "", "only print lines containing this text")
+	relayCmd.AddCommand(relayLogsCmd)
+}
+
+func runRelayLogs(cmd *cobra.Command, args []string) error {
+	name := "xray"
+	if len(args) == 1 {
+		name = args[0]
+	}
+	o, err := relayOps()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	opts := ops.RelayLogOptions{Lines: relayLogsLinesFlag, Follow: relayLogsFollowFlag, Filter: relayLogsGrepFlag}
+	return o.StreamRelayLog(ctx, name, opts, func(line string) {
+		fmt.Println(line)
+	})
+}
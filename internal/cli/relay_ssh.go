@@ -1,10 +1,14 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/ops"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/term"
@@ -18,11 +22,28 @@ var relayCmd = &cobra.Command{
 var relaySSHCmd = &cobra.Command{
 	Use:   "ssh",
 	Short: "Open an interactive SSH shell on the relay server",
-	RunE:  runRelaySSH,
+	Long: `Open an interactive SSH shell on the relay server.
+
+Before connecting, the command offers to record the session for audits or
+to hand troubleshooting over; --record or --record=false answers up front.
+Recordings are asciicast files in the recordings directory, listed by
+` + "`tw relay recordings`" + ` and replayed with asciinema or on the dashboard's
+Relay page.`,
+	RunE: runRelaySSH,
 }
 
+var relayRecordingsCmd = &cobra.Command{
+	Use:   "recordings",
+	Short: "List recorded relay shell sessions",
+	RunE:  runRelayRecordings,
+}
+
+var relaySSHRecordFlag bool
+
 func init() {
+	relaySSHCmd.Flags().BoolVar(&relaySSHRecordFlag, "record", false, "record the session (asks when not given)")
 	relayCmd.AddCommand(relaySSHCmd)
+	relayCmd.AddCommand(relayRecordingsCmd)
 	rootCmd.AddCommand(relayCmd)
 }
 
@@ -40,6 +61,15 @@ func runRelaySSH(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("no relay provisioned — run `tw create relay-server` first")
 	}
 
+	fd := int(os.Stdin.Fd())
+	record := relaySSHRecordFlag
+	if !cmd.Flags().Changed("record") && term.IsTerminal(fd) {
+		fmt.Print("  Record this session? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		record = answer == "y" || answer == "yes"
+	}
+
 	fmt.Printf("  Connecting to relay (%s)...\n", status.Domain)
 
 	return o.RelaySSH(func(client *gossh.Client) error {
@@ -49,7 +79,6 @@ func runRelaySSH(cmd *cobra.Command, args []string) error {
 		}
 		defer session.Close()
 
-		fd := int(os.Stdin.Fd())
 		cols, rows, err := term.GetSize(fd)
 		if err != nil {
 			cols, rows = 80, 24
@@ -63,6 +92,16 @@ func runRelaySSH(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("requesting PTY: %w", err)
 		}
 
+		var rec *ops.Recorder
+		if record {
+			rec, err = o.StartRecording("tw relay ssh "+status.Domain, cols, rows)
+			if err != nil {
+				return err
+			}
+			defer rec.Close()
+			fmt.Printf("  Recording to %s\n", rec.Name())
+		}
+
 		oldState, err := term.MakeRaw(fd)
 		if err != nil {
 			return fmt.Errorf("setting raw terminal: %w", err)
@@ -70,10 +109,10 @@ func runRelaySSH(cmd *cobra.Command, args []string) error {
 		defer term.Restore(fd, oldState)
 
 		session.Stdin = os.Stdin
-		session.Stdout = os.Stdout
-		session.Stderr = os.Stderr
+		session.Stdout = io.MultiWriter(os.Stdout, rec)
+		session.Stderr = io.MultiWriter(os.Stderr, rec)
 
-		watchTermResize(fd, session)
+		watchTermResize(fd, session, rec)
 
 		if err := session.Shell(); err != nil {
 			return fmt.Errorf("starting shell: %w", err)
@@ -82,3 +121,27 @@ func runRelaySSH(cmd *cobra.Command, args []string) error {
 		return session.Wait()
 	})
 }
+
+func runRelayRecordings(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	recs, err := o.ListRecordings()
+	if err != nil {
+		return err
+	}
+	if len(recs) == 0 {
+		fmt.Println("  No recorded sessions.")
+		return nil
+	}
+	for _, r := range recs {
+		fmt.Printf("  %-32s %s  %8s\n", r.Name, r.Started.Format("2006-01-02 15:04:05"), formatBytes(r.Size))
+	}
+	fmt.Println()
+	fmt.Printf("  In %s; replay with `asciinema play <file>` or on the dashboard.\n", config.RecordingsDir())
+	return nil
+}
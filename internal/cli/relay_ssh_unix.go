@@ -7,11 +7,12 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/tunnelwhisperer/tw/internal/ops"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
-func watchTermResize(fd int, session *gossh.Session) {
+func watchTermResize(fd int, session *gossh.Session, rec *ops.Recorder) {
 	sigWinch := make(chan os.Signal, 1)
 	signal.Notify(sigWinch, syscall.SIGWINCH)
 
@@ -19,6 +20,7 @@ func watchTermResize(fd int, session *gossh.Session) {
 		for range sigWinch {
 			if c, r, err := term.GetSize(fd); err == nil {
 				_ = session.WindowChange(r, c)
+				rec.Resize(c, r)
 			}
 		}
 	}()
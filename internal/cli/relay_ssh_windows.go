@@ -2,6 +2,9 @@
 
 package cli
 
-import gossh "golang.org/x/crypto/ssh"
+import (
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	gossh "golang.org/x/crypto/ssh"
+)
 
-func watchTermResize(fd int, session *gossh.Session) {}
+func watchTermResize(fd int, session *gossh.Session, rec *ops.Recorder) {}
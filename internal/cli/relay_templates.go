@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+)
+
+var relayTemplatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Show the relay templates and their overrides",
+	Long: `List the templates relays are set up from, and check any overrides.
+
+A file in the templates directory named like one of them is used instead
+of the built-in template for new relays: cloud-init.yaml.tmpl and
+install-script.sh.tmpl are Go templates; <provider>.tf.tmpl becomes the
+relay's main.tf as is. An override must keep the values and Terraform
+blocks tw relies on, or provisioning stops with an error saying which.
+
+Examples:
+  tw relay templates
+  tw relay templates export cloud-init.yaml.tmpl`,
+	Args: cobra.NoArgs,
+	RunE: runRelayTemplatesList,
+}
+
+var relayTemplatesExportCmd = &cobra.Command{
+	Use:       "export [template...]",
+	Short:     "Copy built-in templates to the templates directory to edit them",
+	ValidArgs: terraform.Templates(),
+	RunE:      runRelayTemplatesExport,
+}
+
+var relayTemplatesForceFlag bool
+
+func init() {
+	relayTemplatesExportCmd.Flags().BoolVar(&relayTemplatesForceFlag, "force", false, "overwrite existing overrides")
+	relayTemplatesCmd.AddCommand(relayTemplatesExportCmd)
+	relayCmd.AddCommand(relayTemplatesCmd)
+}
+
+func runRelayTemplatesList(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	templates := ops.RelayTemplates()
+	if structuredOutput() {
+		return printStructured(templates)
+	}
+
+	fmt.Println()
+	fmt.Printf("  Templates directory: %s\n", config.TemplatesDir())
+	fmt.Println()
+	for _, t := range templates {
+		switch {
+		case t.Error != "":
+			fmt.Printf("  %-24s override — error: %s\n", t.Name, t.Error)
+		case t.Override != "":
+			fmt.Printf("  %-24s override\n", t.Name)
+		default:
+			fmt.Printf("  %-24s built-in\n", t.Name)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+func runRelayTemplatesExport(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	written, err := ops.ExportRelayTemplates(args, relayTemplatesForceFlag)
+	for _, path := range written {
+		fmt.Printf("  Wrote %s\n", path)
+	}
+	if err != nil {
+		return err
+	}
+	if len(written) == 0 {
+		fmt.Println("  Overrides already exist — use --force to overwrite them.")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair [id...]",
+	Short: "Find and fix where the relay, authorized_keys and users disagree",
+	Long: `Compare the relay's Xray clients with the users' UUIDs and registered
+marks, and authorized_keys with the users' keys and disabled marks, then
+offer to fix what disagrees:
+
+  relay_orphan       a UUID on the relay belongs to no user: removed
+  unregistered       an enabled user's UUID is not on the relay: registered
+  disabled_on_relay  a disabled user's UUID is still on the relay: removed
+  applied_marker     the registered mark is wrong: corrected
+  key_missing        a user has no authorized_keys entry: added
+  key_state          an entry is active for a disabled user, or suspended
+                     for an enabled one: corrected
+  key_orphan         an authorized_keys entry belongs to no user: removed
+
+Give IDs from the list to fix only those. --check only reports, and exits
+non-zero when something disagrees.
+
+Examples:
+  tw repair
+  tw repair --check
+  tw repair unregistered:alice -y`,
+	RunE: runRepair,
+}
+
+var (
+	repairYesFlag   bool
+	repairCheckFlag bool
+)
+
+func init() {
+	repairCmd.Flags().BoolVarP(&repairYesFlag, "yes", "y", false, "skip the confirmation prompt")
+	repairCmd.Flags().BoolVar(&repairCheckFlag, "check", false, "only report, exit non-zero when something disagrees")
+	rootCmd.AddCommand(repairCmd)
+}
+
+func runRepair(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	report, err := o.CheckConsistency()
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		if err := printStructured(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println()
+		if !report.RelayChecked {
+			fmt.Printf("  Relay not compared: %s\n\n", report.RelayError)
+		}
+		if len(report.Problems) == 0 {
+			fmt.Println("  Everything agrees.")
+			fmt.Println()
+		}
+		for _, d := range report.Problems {
+			fmt.Printf("  %-40s %s\n", d.ID, d.Detail)
+			fmt.Printf("  %-40s → %s\n", "", d.Fix)
+		}
+		if len(report.Problems) > 0 {
+			fmt.Println()
+		}
+	}
+	if len(report.Problems) == 0 {
+		return nil
+	}
+	if repairCheckFlag || structuredOutput() {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d discrepancy(s) found", len(report.Problems))
+	}
+
+	n := len(args)
+	if n == 0 {
+		n = len(report.Problems)
+	}
+	if !repairYesFlag {
+		scanner := bufio.NewScanner(os.Stdin)
+		fmt.Printf("  Fix %d discrepancy(s)? [y/N]: ", n)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+	}
+
+	fmt.Println()
+	err = o.RepairConsistency(context.Background(), args, func(e ops.ProgressEvent) {
+		switch e.Status {
+		case "completed":
+			fmt.Printf("  ✓ %-40s %s\n", e.Label, e.Message)
+		case "failed":
+			fmt.Printf("  ✗ %-40s %s\n", e.Label, e.Error)
+		}
+	})
+	fmt.Println()
+	return err
+}
@@ -6,9 +6,13 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/logging"
+	"github.com/tunnelwhisperer/tw/internal/ops"
 )
 
-var logLevel string
+var (
+	logLevel     string
+	userModeFlag bool
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "tw",
@@ -16,25 +20,38 @@ var rootCmd = &cobra.Command{
 	Long: `Tunnel Whisperer creates resilient, application-layer bridges for specific
 ports across separated private networks. It encapsulates traffic in standard
 HTTPS/WebSocket to traverse strict firewalls and DPI.`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(); err != nil {
+			return err
+		}
+		if userModeFlag {
+			config.SetUserMode(true)
+		}
+		cfg, err := config.Load()
 		if cmd.Flags().Changed("log-level") {
 			// Explicit flag — persist to config so the dashboard stays in sync.
-			if cfg, err := config.Load(); err == nil {
+			if err == nil {
 				cfg.LogLevel = logLevel
 				config.Save(cfg)
 			}
 		} else {
 			// No flag — use config's log level if set.
-			if cfg, err := config.Load(); err == nil && cfg.LogLevel != "" {
+			if err == nil && cfg.LogLevel != "" {
 				logLevel = cfg.LogLevel
 			}
 		}
 		logging.Setup(logLevel)
+		if err == nil {
+			logging.SetXrayLevel(cfg.Xray.LogLevel)
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "", "output format (text, json, yaml)")
+	rootCmd.PersistentFlags().BoolVar(&userModeFlag, "user", false, "run as an ordinary user, with the config in the user's own directory (or set "+config.UserModeEnv+"=1)")
 }
 
 func Execute() error {
@@ -61,3 +78,26 @@ func requireMode(expected string) error {
 	}
 	return nil
 }
+
+// claimInstance records this process as the tw daemon for the config
+// directory, so a second tw serve, dashboard, run or connect refuses to
+// start instead of binding the same ports. apiPort and dashboardPort are 0
+// for what it doesn't serve; those it does are checked against the ports
+// Windows reserves first.
+func claimInstance(command string, cfg *config.Config, apiPort, dashboardPort int) (func(), error) {
+	if err := ops.CheckReservedPorts(
+		ops.ListenPort{Setting: "server.api_port", Port: apiPort},
+		ops.ListenPort{Setting: "server.dashboard_port", Port: dashboardPort},
+	); err != nil {
+		return nil, err
+	}
+	in := ops.Instance{Command: command, Mode: cfg.Mode, APIPort: apiPort}
+	if dashboardPort > 0 {
+		scheme := "http"
+		if cfg.Dashboard.TLS {
+			scheme = "https"
+		}
+		in.DashboardURL = fmt.Sprintf("%s://localhost:%d", scheme, dashboardPort)
+	}
+	return ops.ClaimInstance(in)
+}
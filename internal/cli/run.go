@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/dashboard"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run headless in the configured mode, for containers and services",
+	Long: `Run the server or the client without a terminal, as the entrypoint of a
+container or a service.
+
+The mode comes from the config (or TW_MODE). In server mode tw starts the
+server components, the gRPC API and, unless server.dashboard_port is 0, the
+dashboard; in client mode it connects the client. Nothing is prompted for:
+settings come from config.yaml in TW_CONFIG_DIR, typically a mounted
+volume, with TW_* environment variables overriding it (e.g.
+TW_SERVER_SSH_PORT for server.ssh_port). Progress is logged instead of
+printed.
+
+SIGTERM or SIGINT stops everything gracefully and exits 0. tw exits
+non-zero when the server or client fails to start, so the container
+restarts.`,
+	Args: cobra.NoArgs,
+	RunE: runRun,
+}
+
+// runShutdownTimeout bounds how long the dashboard waits for requests in
+// flight when stopping.
+const runShutdownTimeout = 10 * time.Second
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+}
+
+func runRun(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if cfg.Mode != "server" && cfg.Mode != "client" {
+		return fmt.Errorf("no mode configured — set mode in %s or %s to server or client", config.FilePath(), config.EnvVar("mode"))
+	}
+	var release func()
+	if cfg.Mode == "client" {
+		release, err = claimInstance("run", cfg, 0, 0)
+	} else {
+		release, err = claimInstance("run", cfg, cfg.Server.APIPort, cfg.Server.DashboardPort)
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing ops: %w", err)
+	}
+	slog.Info("starting headless", "mode", cfg.Mode, "config", config.FilePath())
+
+	// Stop on the first signal; a second one kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Mode == "client" {
+		if err := o.StartClient(slogProgress); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		stop()
+		slog.Info("stopping client")
+		return o.StopClient(slogProgress)
+	}
+
+	var dashSrv *dashboard.Server
+	if cfg.Server.DashboardPort > 0 {
+		dashSrv = dashboard.NewServer(fmt.Sprintf(":%d", cfg.Server.DashboardPort), o)
+		go func() {
+			if err := dashSrv.Run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("dashboard error", "error", err)
+			}
+		}()
+	}
+
+	if err := o.StartServer(slogProgress); err != nil {
+		return err
+	}
+
+	apiAddr := fmt.Sprintf(":%d", cfg.Server.APIPort)
+	apiSrv := api.NewServer(o, apiAddr)
+	apiStop := make(chan struct{})
+	o.SuperviseAPI(cfg.Server.APIPort, apiStop, apiSrv.Run)
+
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down")
+	if dashSrv != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), runShutdownTimeout)
+		if err := dashSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("dashboard shutdown", "error", err)
+		}
+		cancel()
+	}
+	close(apiStop)
+	apiSrv.Stop()
+	return o.StopServer(slogProgress)
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+	"golang.org/x/term"
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage the encrypted secrets store",
+	Long: `List the credentials kept in the encrypted secrets store. Values are
+never printed.
+
+Cloud tokens are stored when a relay is provisioned and removed when it is
+destroyed; a proxy URL with a password is stored by tw proxy set. Config
+values can refer to a stored secret as secret:<name>.
+
+The store is secrets.age, encrypted with secrets.key, unless
+credential_store in config.yaml selects the OS keychain (macOS Keychain,
+Windows Credential Manager, or a Secret Service on Linux). With the
+keychain, a client's SSH private key is kept there too instead of in
+id_ed25519.
+
+Plaintext credentials left by earlier versions (tokens in
+terraform.tfvars, a proxy password in config.yaml) are moved into the
+store automatically, and files holding UUIDs or tokens are made readable
+by their owner only.
+
+Examples:
+  tw secrets
+  tw secrets set proxy
+  echo "$TOKEN" | tw secrets set cloud/hetzner
+  tw secrets delete cloud/aws/access_key_id`,
+	Args: cobra.NoArgs,
+	RunE: runSecretsList,
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Store a secret, read from the terminal or stdin",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSecretsSet,
+}
+
+var secretsDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Remove a secret",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runSecretsDelete,
+	ValidArgsFunction: completeArgs(secretNames),
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsSetCmd)
+	secretsCmd.AddCommand(secretsDeleteCmd)
+	rootCmd.AddCommand(secretsCmd)
+}
+
+// secretsList is the structured output of `tw secrets`.
+type secretsList struct {
+	Backend string   `json:"backend"`         // "file" or "keychain"
+	Store   string   `json:"store,omitempty"` // secrets.age, for the file backend
+	Names   []string `json:"names"`
+}
+
+func runSecretsList(cmd *cobra.Command, args []string) error {
+	// ops.New selects the store and migrates plaintext credentials before
+	// they are listed.
+	if _, err := ops.New(); err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	names, err := secrets.Names()
+	if err != nil {
+		return err
+	}
+
+	result := secretsList{Backend: secrets.Backend(), Names: names}
+	where := "the OS keychain"
+	if result.Backend == secrets.StoreFile {
+		result.Store = secrets.Path()
+		where = result.Store
+	}
+	if structuredOutput() {
+		return printStructured(result)
+	}
+	if len(names) == 0 {
+		fmt.Printf("  No secrets stored in %s.\n", where)
+		return nil
+	}
+	fmt.Printf("  Stored in %s:\n", where)
+	for _, n := range names {
+		fmt.Printf("    %s\n", n)
+	}
+	return nil
+}
+
+func runSecretsSet(cmd *cobra.Command, args []string) error {
+	// ops.New selects the store from credential_store.
+	if _, err := ops.New(); err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	var value string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("  Value for %s: ", args[0])
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("reading value: %w", err)
+		}
+		value = string(b)
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading value: %w", err)
+		}
+		value = scanner.Text()
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("empty value")
+	}
+
+	if err := secrets.Set(args[0], value); err != nil {
+		return err
+	}
+	fmt.Printf("  Stored %s\n", args[0])
+	return nil
+}
+
+func runSecretsDelete(cmd *cobra.Command, args []string) error {
+	if _, err := ops.New(); err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	if err := secrets.Delete(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("  Deleted %s\n", args[0])
+	return nil
+}
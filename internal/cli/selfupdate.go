@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/version"
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Update tw to the latest release",
+	Long: `Download the latest tw release from GitHub for this platform and replace
+this binary with it. The release's checksums must carry a valid signature
+by tw's release key, and the download must match its checksum; otherwise
+nothing is replaced. A build without the release key — one not made by the
+release workflow — checks the checksum only.
+
+The binary is replaced in place, so run tw self-update as the user that
+owns it, root for a system install. A running tw daemon or service keeps
+the old version until it is restarted.
+
+--check only reports whether there is a newer release, and exits non-zero
+when there is. --version installs a given release instead, older ones too.
+
+Examples:
+  tw self-update
+  tw self-update --check
+  tw self-update --version v1.2.0 -y`,
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+var (
+	selfUpdateCheckFlag   bool
+	selfUpdateVersionFlag string
+	selfUpdateYesFlag     bool
+)
+
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheckFlag, "check", false, "only report whether a newer release exists, exit non-zero when one does")
+	selfUpdateCmd.Flags().StringVar(&selfUpdateVersionFlag, "version", "", "install this release instead of the latest (e.g. v1.2.0)")
+	selfUpdateCmd.Flags().BoolVarP(&selfUpdateYesFlag, "yes", "y", false, "skip the confirmation prompt")
+	rootCmd.AddCommand(selfUpdateCmd)
+}
+
+// updateCheck is tw self-update --check's structured output.
+type updateCheck struct {
+	Current   string `json:"current"`
+	Latest    string `json:"latest"`
+	URL       string `json:"url"`
+	Available bool   `json:"update_available"`
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	rel, err := ops.FindRelease(ctx, selfUpdateVersionFlag)
+	if err != nil {
+		return err
+	}
+	newer := rel.Newer()
+
+	if selfUpdateCheckFlag {
+		if structuredOutput() {
+			if err := printStructured(updateCheck{Current: version.Version, Latest: rel.Version, URL: rel.URL, Available: newer}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("  Current: %s\n", version.String())
+			fmt.Printf("  Latest:  %s (%s)\n", rel.Version, rel.Published.Format("2006-01-02"))
+			if newer {
+				fmt.Printf("\n  Update with tw self-update — release notes: %s\n", rel.URL)
+			} else {
+				fmt.Println("\n  tw is up to date.")
+			}
+		}
+		if newer {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("update available: %s", rel.Version)
+		}
+		return nil
+	}
+
+	if selfUpdateVersionFlag == "" && !newer {
+		fmt.Printf("  tw %s is up to date.\n", version.Version)
+		return nil
+	}
+	if selfUpdateVersionFlag != "" && rel.Version == version.Version {
+		fmt.Printf("  tw is already %s.\n", rel.Version)
+		return nil
+	}
+	if !selfUpdateYesFlag {
+		scanner := bufio.NewScanner(os.Stdin)
+		fmt.Printf("  Replace tw %s with %s? [y/N]: ", version.Version, rel.Version)
+		scanner.Scan()
+		if answer := strings.TrimSpace(strings.ToLower(scanner.Text())); answer != "y" {
+			fmt.Println("  Aborted.")
+			return nil
+		}
+	}
+
+	fmt.Println()
+	exe, err := ops.SelfUpdate(ctx, rel, cliProgress)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n  Updated %s to %s.\n", exe, rel.Version)
+	if in, ok := ops.RunningInstance(); ok {
+		fmt.Printf("  tw %s (pid %d) still runs %s — restart it, or the tw service, to run %s.\n",
+			in.Command, in.PID, version.Version, rel.Version)
+	}
+	return nil
+}
@@ -28,6 +28,15 @@ func runServe(cmd *cobra.Command, args []string) error {
 	if err := requireMode("server"); err != nil {
 		return err
 	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	release, err := claimInstance("serve", cfg, cfg.Server.APIPort, cfg.Server.DashboardPort)
+	if err != nil {
+		return err
+	}
+	defer release()
 	fmt.Println("Starting Tunnel Whisperer server...")
 
 	o, err := ops.New()
@@ -35,7 +44,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("initializing ops: %w", err)
 	}
 
-	cfg := o.Config()
+	cfg = o.Config()
 	fmt.Printf("Config: %s\n", config.FilePath())
 
 	// Start dashboard if configured (before server so user can see progress).
@@ -43,7 +52,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 		dashAddr := fmt.Sprintf(":%d", cfg.Server.DashboardPort)
 		dashSrv := dashboard.NewServer(dashAddr, o)
 		go func() {
-			fmt.Printf("Dashboard on http://localhost%s\n", dashAddr)
+			fmt.Printf("Dashboard on %s\n", dashSrv.URL())
 			if err := dashSrv.Run(); err != nil {
 				fmt.Printf("Dashboard error: %v\n", err)
 			}
@@ -58,12 +67,9 @@ func runServe(cmd *cobra.Command, args []string) error {
 	// Start gRPC API server.
 	apiAddr := fmt.Sprintf(":%d", cfg.Server.APIPort)
 	apiSrv := api.NewServer(o, apiAddr)
-	go func() {
-		slog.Info("gRPC API listening", "addr", apiAddr)
-		if err := apiSrv.Run(); err != nil {
-			slog.Error("gRPC API error", "error", err)
-		}
-	}()
+	apiStop := make(chan struct{})
+	slog.Info("gRPC API listening", "addr", apiAddr)
+	o.SuperviseAPI(cfg.Server.APIPort, apiStop, apiSrv.Run)
 
 	fmt.Println("Server running. Press Ctrl-C to stop.")
 
@@ -73,6 +79,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 	<-sig
 
 	fmt.Println("\nShutting down...")
+	close(apiStop)
 	apiSrv.Stop()
 	o.StopServer(nil)
 	return nil
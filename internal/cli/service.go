@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/service"
+)
+
+var serviceFirewallFlag bool
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Install tw as a system service that runs at boot",
+	Long: `Install or remove a system service that runs tw run, in the configured
+mode, at boot: a systemd unit named tw on Linux, a launch daemon named
+com.tunnelwhisperer.tw on macOS, a scheduled task named "Tunnel Whisperer"
+running as SYSTEM on Windows. Run as root, or from an elevated prompt.
+
+On Windows, install also adds a "Tunnel Whisperer" firewall rule allowing
+the ports tw listens on for other machines: in server mode server.ssh_port
+and server.dashboard_port, in client mode the local ports of tunnels with a
+listen_host other than loopback. A service gets no prompt to allow them, so
+without the rule Windows blocks them silently. --firewall=false leaves the
+firewall alone; uninstall removes the rule unless it is given.
+
+With --user, the service is the current user's instead — a user systemd
+unit, a launch agent, or a task run at your logon — running tw run --user.
+It needs no root, and never touches the firewall.
+
+Examples:
+  tw service install
+  tw service install --firewall=false
+  tw service install --user
+  tw service uninstall`,
+	Args: cobra.NoArgs,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install and start the tw service",
+	Args:  cobra.NoArgs,
+	RunE:  runServiceInstall,
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the tw service",
+	Args:  cobra.NoArgs,
+	RunE:  runServiceUninstall,
+}
+
+func init() {
+	serviceCmd.PersistentFlags().BoolVar(&serviceFirewallFlag, "firewall", true, "add or remove the Windows Firewall rule for tw's ports")
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if cfg.Mode != "server" && cfg.Mode != "client" {
+		return fmt.Errorf("no mode configured — set mode in %s to server or client first", config.FilePath())
+	}
+	exe, err := service.Executable()
+	if err != nil {
+		return err
+	}
+	opts := serviceOptions()
+	opts.Exe = exe
+	opts.Ports = exposedPorts(cfg)
+	if err := service.Install(opts); err != nil {
+		return err
+	}
+	fmt.Printf("  Installed and started the %s, running tw run in %s mode.\n", service.Describe(opts), cfg.Mode)
+	if opts.Firewall && !opts.User && len(opts.Ports) > 0 && service.FirewallManaged {
+		fmt.Printf("  Firewall rule %q allows TCP %v.\n", service.FirewallRule, opts.Ports)
+	}
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	if err := service.Uninstall(serviceOptions()); err != nil {
+		return err
+	}
+	fmt.Println("  Removed the tw service.")
+	return nil
+}
+
+// serviceOptions returns the service settings given by the flags: in user
+// mode, a service of the current user's.
+func serviceOptions() service.Options {
+	return service.Options{
+		ConfigDir: os.Getenv("TW_CONFIG_DIR"),
+		Firewall:  serviceFirewallFlag,
+		User:      config.UserMode(),
+	}
+}
+
+// exposedPorts returns the TCP ports tw listens on for other machines in
+// cfg's mode. The gRPC API is left out: only local CLI commands use it.
+func exposedPorts(cfg *config.Config) []int {
+	var ports []int
+	if cfg.Mode == "server" {
+		ports = append(ports, cfg.Server.SSHPort)
+		if cfg.Server.DashboardPort > 0 {
+			ports = append(ports, cfg.Server.DashboardPort)
+		}
+		return ports
+	}
+	for _, t := range cfg.Client.EnabledTunnels() {
+		host := t.ListenHost
+		if host == "" {
+			host = config.DefaultListenHost
+		}
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			continue
+		}
+		ports = append(ports, t.LocalPort)
+	}
+	return ports
+}
@@ -3,33 +3,83 @@ package cli
 import (
 	"context"
 	"fmt"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/tunnelwhisperer/tw/internal/api"
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/version"
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show current server/client status",
-	RunE:  runStatus,
+	Long: `Show the current server/client status.
+
+With --watch, tw keeps printing the running daemon's status changes —
+state transitions, tunnel reconnects, users connecting — until Ctrl-C.
+With --output json or yaml, each change is one JSON line or YAML document.`,
+	RunE: runStatus,
 }
 
+var statusWatch bool
+
 func init() {
+	statusCmd.Flags().BoolVarP(&statusWatch, "watch", "w", false, "keep printing status changes from the running daemon")
 	rootCmd.AddCommand(statusCmd)
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	client, err := api.Dial(addr)
 	if err != nil {
+		if statusWatch {
+			return fmt.Errorf("--watch needs a running tw daemon — start one with `tw serve` or `tw dashboard`")
+		}
 		return runStatusLocal()
 	}
 	defer client.Close()
-	return runStatusRemote(client)
+	if err := runStatusRemote(client); err != nil || !statusWatch {
+		return err
+	}
+	return watchStatus(client)
+}
+
+// watchStatus prints the daemon's status changes until Ctrl-C.
+func watchStatus(client *api.Client) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stream, err := client.StreamStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("watching status: %w", err)
+	}
+	if !structuredOutput() {
+		fmt.Println()
+		fmt.Println("  Watching for changes. Press Ctrl-C to stop.")
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		if structuredOutput() {
+			if err := printStructuredLine(e); err != nil {
+				return err
+			}
+			continue
+		}
+		printStatusEvent(e, true)
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("lost the connection to the tw daemon")
+	}
+	return nil
 }
 
 func runStatusRemote(client *api.Client) error {
@@ -38,8 +88,14 @@ func runStatusRemote(client *api.Client) error {
 		return fmt.Errorf("getting status: %w", err)
 	}
 
+	if structuredOutput() {
+		return printStructured(resp)
+	}
+
 	fmt.Printf("  Mode:   %s\n", orDash(resp.Mode))
 	fmt.Printf("  Users:  %d\n", resp.UserCount)
+	fmt.Printf("  Key:    %s\n", orDash(resp.KeyFingerprint))
+	fmt.Printf("  Build:  %s\n", buildString(resp.Version, resp.Commit))
 	fmt.Println()
 
 	fmt.Println("  Relay:")
@@ -57,9 +113,44 @@ func runStatusRemote(client *api.Client) error {
 		fmt.Printf("    SSH:     %v\n", resp.Server.SSH)
 		fmt.Printf("    Xray:    %v\n", resp.Server.Xray)
 		fmt.Printf("    Tunnel:  %v\n", resp.Server.Tunnel)
+		fmt.Printf("    API:     %v\n", resp.Server.API)
 		if resp.Server.TunnelError != "" {
 			fmt.Printf("    Error:   %s\n", resp.Server.TunnelError)
 		}
+		if resp.Server.Error != "" {
+			fmt.Printf("    Error:   %s\n", resp.Server.Error)
+		}
+		printComponents(resp.Server.Components)
+		for _, f := range resp.Server.Forwards {
+			state := "down"
+			if f.Listening {
+				state = "listening"
+			}
+			label := ""
+			if f.Name != "" {
+				label = " (" + f.Name + ")"
+			}
+			fmt.Printf("    relay :%d → %s%s  %s, %d conn(s)\n", f.RemotePort, f.LocalAddr, label, state, f.ActiveConns)
+			if f.Error != "" {
+				fmt.Printf("      Error: %s\n", f.Error)
+			}
+		}
+		for _, c := range resp.Server.Certs {
+			printRelayCert(c)
+		}
+		if r := resp.Server.RelayReboot; r != nil && r.Pending {
+			when := "auto_reboot is off"
+			if r.ScheduledAt != nil {
+				when = "scheduled " + r.ScheduledAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("    relay reboot pending for OS updates (%d package(s), %s)\n", len(r.Packages), when)
+		}
+		if r := resp.Server.RelayReboot; r != nil && r.RebootedAt != nil {
+			fmt.Printf("    relay last rebooted %s\n", r.RebootedAt.Format("2006-01-02 15:04"))
+		}
+		if r := resp.Server.RelayReboot; r != nil && r.Error != "" {
+			fmt.Printf("      Reboot check error: %s\n", r.Error)
+		}
 	}
 
 	if resp.Client != nil {
@@ -67,10 +158,41 @@ func runStatusRemote(client *api.Client) error {
 		fmt.Println("  Client:")
 		fmt.Printf("    State:   %s\n", resp.Client.State)
 		fmt.Printf("    Xray:    %v\n", resp.Client.Xray)
-		fmt.Printf("    Tunnel:  %v\n", resp.Client.Tunnel)
+		if resp.Client.PlainSSH != "" {
+			fmt.Printf("    Tunnel:  left to OpenSSH: %s\n", resp.Client.PlainSSH)
+		} else {
+			fmt.Printf("    Tunnel:  %v\n", resp.Client.Tunnel)
+		}
 		if resp.Client.TunnelError != "" {
 			fmt.Printf("    Error:   %s\n", resp.Client.TunnelError)
 		}
+		if resp.Client.Error != "" {
+			fmt.Printf("    Error:   %s\n", resp.Client.Error)
+		}
+		if c := resp.Client.Compat; c != nil {
+			fmt.Printf("    Server:  tw %s, bundle format %d\n", c.ServerVersion, c.Bundle)
+			for _, w := range c.Warnings {
+				fmt.Printf("    Warning: %s\n", w)
+			}
+		}
+		printComponents(resp.Client.Components)
+		for _, t := range resp.Client.Tunnels {
+			state := "down"
+			if t.Listening {
+				state = "listening"
+			}
+			if t.BoundPort != 0 {
+				state += fmt.Sprintf(" (port %d was taken)", t.LocalPort)
+			}
+			if host, _, err := net.SplitHostPort(t.ListenAddr); err == nil && (config.Tunnel{ListenHost: host}).Exposed() {
+				state += ", exposed to the network"
+			}
+			fmt.Printf("    %s → %s:%d  %s, %d conn(s), %s in / %s out\n",
+				t.ListenAddr, t.RemoteHost, t.RemotePort, state, t.ActiveConns, formatBytes(t.BytesIn), formatBytes(t.BytesOut))
+			if t.Error != "" {
+				fmt.Printf("      Error: %s\n", t.Error)
+			}
+		}
 	}
 
 	return nil
@@ -85,9 +207,25 @@ func runStatusLocal() error {
 	mode := o.Mode()
 	relay := o.GetRelayStatus()
 	users, _ := o.ListUsers()
+	fingerprint := ops.KeyFingerprint()
+
+	if structuredOutput() {
+		return printStructured(&api.StatusResponse{
+			Mode:      mode,
+			Version:   version.Version,
+			Commit:    version.Commit,
+			BuildDate: version.Date,
+			Relay:     relay,
+			UserCount: len(users),
+
+			KeyFingerprint: fingerprint,
+		})
+	}
 
 	fmt.Printf("  Mode:   %s\n", orDash(mode))
 	fmt.Printf("  Users:  %d\n", len(users))
+	fmt.Printf("  Key:    %s\n", orDash(fingerprint))
+	fmt.Printf("  Build:  %s\n", version.String())
 	fmt.Println()
 
 	fmt.Println("  Relay:")
@@ -106,9 +244,53 @@ func runStatusLocal() error {
 	return nil
 }
 
+// printComponents lists each component's state, uptime and restarts, and
+// the error it last reported.
+func printComponents(comps []ops.ComponentStatus) {
+	for _, c := range comps {
+		state := "down"
+		if c.Running {
+			state = "up " + (time.Duration(c.Uptime) * time.Second).String()
+		}
+		if c.Restarts > 0 {
+			state += fmt.Sprintf(", %d restart(s)", c.Restarts)
+		}
+		fmt.Printf("    %-22s %s\n", c.Name+":", state)
+		if c.Error != "" {
+			fmt.Printf("      Error: %s\n", c.Error)
+		}
+	}
+}
+
+// buildString formats the daemon's version and commit as version.String
+// does this binary's.
+func buildString(v, commit string) string {
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if commit == "" {
+		return orDash(v)
+	}
+	return fmt.Sprintf("%s (%s)", orDash(v), commit)
+}
+
 func orDash(s string) string {
 	if s == "" {
 		return "—"
 	}
 	return s
 }
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
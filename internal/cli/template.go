@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Manage port mapping templates for users",
+	Long: `Manage named port mapping templates.
+
+A template groups the ports a class of users needs, e.g. "developers"
+→ 5432, 6379, 8080. Create users from it with
+` + "`tw create user --template developers`" + `. Editing a template can
+propagate the new mappings to every user created from it.
+
+Examples:
+  tw template list
+  tw template set developers --port 5432 --port 6379 --port 8080
+  tw template set developers --port 5432 --port 15672:5672 --propagate
+  tw template delete developers`,
+	RunE: runTemplateList,
+}
+
+var templateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List mapping templates and their members",
+	RunE:  runTemplateList,
+}
+
+var templateSetCmd = &cobra.Command{
+	Use:               "set <name>",
+	Short:             "Create or update a mapping template",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runTemplateSet,
+	ValidArgsFunction: completeArgs(templateNames),
+}
+
+var templateDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Delete a mapping template",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runTemplateDelete,
+	ValidArgsFunction: completeArgs(templateNames),
+}
+
+var (
+	templatePortFlags     []string
+	templatePropagateFlag bool
+)
+
+func init() {
+	templateSetCmd.Flags().StringArrayVar(&templatePortFlags, "port", nil, "port PORT, CLIENT:SERVER or CLIENT:HOST:PORT (repeatable)")
+	templateSetCmd.Flags().BoolVar(&templatePropagateFlag, "propagate", false, "update all users created from this template (prompts when omitted)")
+	templateSetCmd.MarkFlagRequired("port")
+	templateCmd.AddCommand(templateListCmd)
+	templateCmd.AddCommand(templateSetCmd)
+	templateCmd.AddCommand(templateDeleteCmd)
+	rootCmd.AddCommand(templateCmd)
+}
+
+func runTemplateList(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	templates, err := o.ListTemplates()
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return printStructured(templates)
+	}
+
+	if len(templates) == 0 {
+		fmt.Println("  No templates configured.")
+		return nil
+	}
+	for _, t := range templates {
+		fmt.Printf("  %s\n", t.Name)
+		for _, m := range t.Mappings {
+			fmt.Printf("    localhost:%d → %s\n", m.ClientPort, m.Dest())
+		}
+		if len(t.Members) > 0 {
+			fmt.Printf("    Members: %s\n", strings.Join(t.Members, ", "))
+		}
+	}
+	return nil
+}
+
+func runTemplateSet(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	name := args[0]
+	t := config.MappingTemplate{Name: name, Ports: templatePortFlags}
+	if _, err := ops.TemplateMappings(t); err != nil {
+		return err
+	}
+
+	// Offer to propagate when the template already has members.
+	propagate := templatePropagateFlag
+	if !cmd.Flags().Changed("propagate") {
+		templates, err := o.ListTemplates()
+		if err != nil {
+			return err
+		}
+		for _, existing := range templates {
+			if existing.Name != name || len(existing.Members) == 0 {
+				continue
+			}
+			fmt.Printf("  %d user(s) were created from %q: %s\n", len(existing.Members), name, strings.Join(existing.Members, ", "))
+			fmt.Print("  Update their mappings too? [Y/n]: ")
+			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Scan()
+			answer := strings.TrimSpace(strings.ToLower(scanner.Text()))
+			propagate = answer != "n"
+		}
+	}
+
+	if err := o.SaveTemplate(context.Background(), t, propagate, cliProgress); err != nil {
+		return err
+	}
+	fmt.Printf("  Template %q saved.\n", name)
+	return nil
+}
+
+func runTemplateDelete(cmd *cobra.Command, args []string) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+	if err := o.DeleteTemplate(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("  Template %q deleted. Its users keep their current mappings.\n", args[0])
+	return nil
+}
@@ -22,14 +22,27 @@ var testRelayCmd = &cobra.Command{
 	RunE:  runTestRelay,
 }
 
+var testConnectionCmd = &cobra.Command{
+	Use:   "connection",
+	Short: "Check each layer of the client connection",
+	Long: `Check each layer of the client connection in order: config, DNS for
+relay_host, the TLS handshake with the relay, the VLESS tunnel (through a
+temporary Xray instance), SSH authentication to the server, and each mapped
+port. The first failing layer is reported with the likely cause.
+
+Runs alongside a connected client without interrupting it.`,
+	RunE: runTestConnection,
+}
+
 func init() {
 	testCmd.AddCommand(testRelayCmd)
+	testCmd.AddCommand(testConnectionCmd)
 	rootCmd.AddCommand(testCmd)
 }
 
 func runTestRelay(cmd *cobra.Command, args []string) error {
 	cfg, _ := config.Load()
-	addr := fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+	addr := ops.APIAddr(cfg)
 
 	client, err := api.Dial(addr)
 	if err != nil {
@@ -40,13 +53,21 @@ func runTestRelay(cmd *cobra.Command, args []string) error {
 }
 
 func runTestRelayRemote(client *api.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if structuredOutput() {
+		resp, err := client.TestRelay(ctx)
+		if err != nil {
+			return fmt.Errorf("test relay: %w", err)
+		}
+		return printStructured(resp)
+	}
+
 	fmt.Println()
 	fmt.Println("  Testing relay (via daemon)...")
 	fmt.Println()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
 	resp, err := client.TestRelay(ctx)
 	if err != nil {
 		return fmt.Errorf("test relay: %w", err)
@@ -79,6 +100,21 @@ func runTestRelayLocal() error {
 		return fmt.Errorf("no relay provisioned — run `tw create relay-server` first")
 	}
 
+	if structuredOutput() {
+		var steps []api.TestRelayResult
+		o.TestRelay(func(e ops.ProgressEvent) {
+			if e.Status == "completed" || e.Status == "failed" {
+				steps = append(steps, api.TestRelayResult{
+					Label:   e.Label,
+					Status:  e.Status,
+					Message: e.Message,
+					Error:   e.Error,
+				})
+			}
+		})
+		return printStructured(&api.TestRelayResponse{Message: "test complete", Steps: steps})
+	}
+
 	fmt.Println()
 	fmt.Printf("  Testing relay: %s\n", status.Domain)
 	fmt.Println()
@@ -88,3 +124,43 @@ func runTestRelayLocal() error {
 	fmt.Println()
 	return nil
 }
+
+func runTestConnection(cmd *cobra.Command, args []string) error {
+	if err := requireMode("client"); err != nil {
+		return err
+	}
+	o, err := ops.New()
+	if err != nil {
+		return fmt.Errorf("initializing: %w", err)
+	}
+
+	if structuredOutput() {
+		var steps []api.TestRelayResult
+		o.TestConnection(func(e ops.ProgressEvent) {
+			if e.Status == "completed" || e.Status == "failed" {
+				steps = append(steps, api.TestRelayResult{
+					Label:   e.Label,
+					Status:  e.Status,
+					Message: e.Message,
+					Error:   e.Error,
+				})
+			}
+		})
+		return printStructured(&api.TestRelayResponse{Message: "test complete", Steps: steps})
+	}
+
+	fmt.Println()
+	fmt.Printf("  Testing connection to: %s\n", o.Config().Xray.RelayHost)
+	fmt.Println()
+
+	o.TestConnection(func(e ops.ProgressEvent) {
+		if e.Status == "" && e.Message != "" {
+			fmt.Printf("          %s\n", e.Message)
+			return
+		}
+		cliProgress(e)
+	})
+
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/auth"
+)
+
+var (
+	tokenRoleFlag    string
+	tokenExpiresFlag int
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for the dashboard and gRPC API",
+	Long: `List, create, and revoke API tokens.
+
+While no tokens exist, the dashboard and API are open to anyone who can
+reach them. Creating the first token turns access control on: the
+dashboard asks for a token at /login, and REST and gRPC calls need one as
+"Authorization: Bearer <token>". An admin token has full control; a viewer
+token can read status, users, and logs but change nothing.
+
+The CLI on this machine uses the admin token in api.token, kept by the
+daemon, or TW_API_TOKEN when set.
+
+Examples:
+  tw token create alice
+  tw token create grafana --role viewer --expires 90
+  tw token list
+  tw token revoke grafana`,
+	Args: cobra.NoArgs,
+	RunE: runTokenList,
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	RunE:  runTokenList,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token and print it once",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenCreate,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:               "revoke <name>",
+	Short:             "Revoke an API token",
+	Args:              cobra.ExactArgs(1),
+	RunE:              runTokenRevoke,
+	ValidArgsFunction: completeArgs(tokenNames),
+}
+
+func init() {
+	tokenCreateCmd.Flags().StringVar(&tokenRoleFlag, "role", string(auth.RoleAdmin), "admin or viewer")
+	tokenCreateCmd.Flags().IntVar(&tokenExpiresFlag, "expires", 0, "days until the token expires (0 never expires)")
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func runTokenList(cmd *cobra.Command, args []string) error {
+	tokens, err := auth.ListTokens()
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return printStructured(tokens)
+	}
+	if len(tokens) == 0 {
+		fmt.Println("  No API tokens. The dashboard and API are open.")
+		return nil
+	}
+
+	fmt.Println()
+	for _, t := range tokens {
+		expires := "never expires"
+		if t.ExpiresAt != nil {
+			expires = "expires " + t.ExpiresAt.Format("2006-01-02")
+			if t.Expired() {
+				expires = "expired " + t.ExpiresAt.Format("2006-01-02")
+			}
+		}
+		fmt.Printf("  %-20s %-7s %s…  created %s, %s\n", t.Name, t.Role, t.Prefix, t.CreatedAt.Format("2006-01-02"), expires)
+	}
+	fmt.Println()
+	return nil
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) error {
+	role, err := auth.ParseRole(tokenRoleFlag)
+	if err != nil {
+		return err
+	}
+	if tokenExpiresFlag < 0 {
+		return fmt.Errorf("--expires must not be negative")
+	}
+	first := !auth.Enabled()
+	if first && role != auth.RoleAdmin {
+		return fmt.Errorf("the first token must have the admin role, so someone can still manage the dashboard")
+	}
+	secret, tok, err := auth.CreateToken(args[0], role, time.Duration(tokenExpiresFlag)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return printStructured(struct {
+			auth.Token
+			Secret string `json:"secret"`
+		}{tok, secret})
+	}
+	fmt.Printf("  Created %s token %s. Copy it now; it is not shown again:\n\n", tok.Role, tok.Name)
+	fmt.Printf("  %s\n\n", secret)
+	if first {
+		fmt.Println("  Access control is now on: the dashboard and API require a token.")
+	}
+	return nil
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) error {
+	if err := auth.RevokeToken(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("  Revoked %s\n", args[0])
+	if !auth.Enabled() {
+		fmt.Println("  No tokens left: the dashboard and API are open again.")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+// printTOTPEnrollment prints a TOTP enrollment's QR code in the terminal,
+// with its secret for apps that take it typed.
+func printTOTPEnrollment(e *ops.TOTPEnrollment) error {
+	code, err := e.QR()
+	if err != nil {
+		return fmt.Errorf("encoding QR code: %w", err)
+	}
+	fmt.Println()
+	fmt.Print(terminalQR(code.Size, code.Black))
+	fmt.Println()
+	fmt.Printf("  Secret: %s\n", e.Secret)
+	fmt.Printf("  URI:    %s\n", e.URI)
+	fmt.Println()
+	return nil
+}
+
+// terminalQR draws a QR code of size modules with half blocks, two rows
+// per line, in black on white whatever the terminal's colors, with the
+// quiet zone scanners need around it.
+func terminalQR(size int, black func(x, y int) bool) string {
+	const quiet = 2
+	dark := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < size && y < size && black(x, y)
+	}
+	color := func(d bool) int {
+		if d {
+			return 0 // black
+		}
+		return 7 // white
+	}
+	var b strings.Builder
+	for y := -quiet; y < size+quiet; y += 2 {
+		b.WriteString("  ")
+		for x := -quiet; x < size+quiet; x++ {
+			fmt.Fprintf(&b, "\x1b[3%d;4%dm▀", color(dark(x, y)), color(dark(x, y+1)))
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "List, enable, and disable client tunnels",
+}
+
+var tunnelListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the client tunnels and whether they are enabled",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMode("client"); err != nil {
+			return err
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		return printTunnels(cfg.Client.Tunnels)
+	},
+}
+
+var tunnelEnableCmd = &cobra.Command{
+	Use:   "enable <name|local-port>",
+	Short: "Enable a client tunnel",
+	Long: `Enable a client tunnel, saving enabled to config.yaml. A running client
+starts forwarding it right away.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(tunnelNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetTunnelEnabled(args[0], true)
+	},
+}
+
+var tunnelDisableCmd = &cobra.Command{
+	Use:   "disable <name|local-port>",
+	Short: "Disable a client tunnel without removing it",
+	Long: `Disable a client tunnel, saving enabled: false to config.yaml. A running
+client stops forwarding it right away, closing its connections; the other
+tunnels are untouched. ` + "`tw tunnel enable`" + ` turns it back on.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(tunnelNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetTunnelEnabled(args[0], false)
+	},
+}
+
+func init() {
+	tunnelCmd.AddCommand(tunnelListCmd)
+	tunnelCmd.AddCommand(tunnelEnableCmd)
+	tunnelCmd.AddCommand(tunnelDisableCmd)
+	rootCmd.AddCommand(tunnelCmd)
+}
+
+func runSetTunnelEnabled(ref string, enabled bool) error {
+	if err := requireMode("client"); err != nil {
+		return err
+	}
+	cfg, _ := config.Load()
+	addr := ops.APIAddr(cfg)
+
+	client, err := api.Dial(addr)
+	if err != nil {
+		// No daemon running, update the config only.
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if err := o.SetTunnelEnabled(ref, enabled); err != nil {
+			return err
+		}
+	} else {
+		defer client.Close()
+		if err := client.SetTunnelEnabled(context.Background(), ref, enabled); err != nil {
+			return fmt.Errorf("updating tunnel: %w", err)
+		}
+	}
+
+	if enabled {
+		fmt.Printf("  Tunnel %s enabled.\n", ref)
+	} else {
+		fmt.Printf("  Tunnel %s disabled.\n", ref)
+	}
+	return nil
+}
+
+// tunnelInfo is a client tunnel as `tw tunnel list` prints it.
+type tunnelInfo struct {
+	Name      string `json:"name,omitempty"`
+	LocalPort int    `json:"local_port"`
+	Listen    string `json:"listen"`
+	Remote    string `json:"remote"`
+	Enabled   bool   `json:"enabled"`
+}
+
+func printTunnels(tunnels []config.Tunnel) error {
+	infos := make([]tunnelInfo, len(tunnels))
+	for i, t := range tunnels {
+		infos[i] = tunnelInfo{
+			Name:      t.Name,
+			LocalPort: t.LocalPort,
+			Listen:    t.ListenAddr(),
+			Remote:    fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort),
+			Enabled:   t.IsEnabled(),
+		}
+	}
+	if structuredOutput() {
+		return printStructured(infos)
+	}
+
+	if len(infos) == 0 {
+		fmt.Println("  No tunnels configured.")
+		return nil
+	}
+
+	fmt.Println()
+	for _, t := range infos {
+		state := "enabled"
+		if !t.Enabled {
+			state = "disabled"
+		}
+		name := t.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Printf("  %-16s %-22s → %-22s %s\n", name, t.Listen, t.Remote, state)
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage individual users",
+}
+
+var userShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show a user's details",
+	Long: `Show everything known about a user, as the dashboard's user page does:
+their UUID, port mappings, public key fingerprint, whether they are
+registered on the relay and online, when they were last seen, where their
+bundle's files are, and their traffic through the relay.
+
+Online state and traffic come from the running tw daemon's relay tunnel;
+without a daemon they are left out.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMode("server"); err != nil {
+			return err
+		}
+		cfg, _ := config.Load()
+		var u *ops.UserDetail
+		if client := daemonClient(cfg); client != nil {
+			defer client.Close()
+			var err error
+			if u, err = client.GetUser(context.Background(), args[0]); err != nil {
+				return fmt.Errorf("getting user: %w", err)
+			}
+		} else {
+			o, err := ops.New()
+			if err != nil {
+				return fmt.Errorf("initializing: %w", err)
+			}
+			if u, err = o.GetUserDetail(context.Background(), args[0]); err != nil {
+				return err
+			}
+		}
+		if structuredOutput() {
+			return printStructured(u)
+		}
+		printUserDetail(u)
+		return nil
+	},
+}
+
+var userDisableCmd = &cobra.Command{
+	Use:   "disable <name>",
+	Short: "Suspend a user without deleting them",
+	Long: `Suspend a user without deleting them.
+
+The user's UUID is removed from the relay and their authorized_keys line is
+commented out. Keys and config are kept; ` + "`tw user enable`" + ` restores access.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetUserDisabled(args[0], true)
+	},
+}
+
+var userEnableCmd = &cobra.Command{
+	Use:               "enable <name>",
+	Short:             "Restore access for a suspended user",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSetUserDisabled(args[0], false)
+	},
+}
+
+var userSFTPCmd = &cobra.Command{
+	Use:   "sftp <name> on|off",
+	Short: "Let a user exchange files with the server over SFTP",
+	Long: `Turn SFTP on or off for a user.
+
+With SFTP on, the user can connect with an SFTP client over their tunnel's
+SSH connection and exchange files in users/<name>/files on the server,
+which they cannot leave. SFTP is off by default; turning it off keeps the
+files.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeArgs(userNames, "on", "off"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMode("server"); err != nil {
+			return err
+		}
+		var enabled bool
+		switch args[1] {
+		case "on":
+			enabled = true
+		case "off":
+		default:
+			return fmt.Errorf("expected on or off, got %q", args[1])
+		}
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if err := o.SetUserSFTP(args[0], enabled); err != nil {
+			return err
+		}
+		fmt.Printf("  SFTP %s for %q.\n", args[1], args[0])
+		return nil
+	},
+}
+
+var userTOTPCmd = &cobra.Command{
+	Use:   "totp <name> on|off|show",
+	Short: "Require a TOTP code from a user's authenticator app",
+	Long: `Turn the TOTP second factor on or off for a user, or show their
+enrollment QR code again.
+
+With TOTP on, the user's client must give a code from an authenticator
+app after their key each time it connects; no port forward starts without
+it. Turning it on makes a new secret and prints its QR code: hand it to
+the user apart from their config bundle, which doesn't hold it. Their tw
+connect asks for the code in a terminal, or makes it from the secret
+stored with tw secrets set totp to run unattended.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeArgs(userNames, "on", "off", "show"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMode("server"); err != nil {
+			return err
+		}
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		var e *ops.TOTPEnrollment
+		switch args[1] {
+		case "on":
+			e, err = o.SetUserTOTP(args[0], true)
+		case "off":
+			if _, err := o.SetUserTOTP(args[0], false); err != nil {
+				return err
+			}
+			fmt.Printf("  TOTP off for %q.\n", args[0])
+			return nil
+		case "show":
+			e, err = o.UserTOTP(args[0])
+		default:
+			return fmt.Errorf("expected on, off or show, got %q", args[1])
+		}
+		if err != nil {
+			return err
+		}
+		if structuredOutput() {
+			return printStructured(e)
+		}
+		if args[1] == "on" {
+			fmt.Printf("  TOTP on for %q.\n", args[0])
+		}
+		return printTOTPEnrollment(e)
+	},
+}
+
+var userInviteRenewFlag bool
+
+var userInviteCmd = &cobra.Command{
+	Use:   "invite <name>",
+	Short: "Show an invited user's claim code",
+	Long: `Print the claim code of a user created with tw create user --invite,
+who has not claimed their account yet.
+
+The user runs tw connect --claim CODE on their machine, which makes their
+SSH key there and registers it; the private key never leaves it. The code
+is valid for a week and only once. --renew replaces it with a new one,
+for a week from now; the old one stops working.
+
+The code lets whoever holds it claim the account: send it to the user
+only.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(userNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMode("server"); err != nil {
+			return err
+		}
+		cfg, _ := config.Load()
+		var resp *api.UserInviteResponse
+		if client := daemonClient(cfg); client != nil {
+			defer client.Close()
+			var err error
+			if resp, err = client.GetUserInvite(context.Background(), args[0], userInviteRenewFlag); err != nil {
+				return fmt.Errorf("getting invitation: %w", err)
+			}
+		} else {
+			o, err := ops.New()
+			if err != nil {
+				return fmt.Errorf("initializing: %w", err)
+			}
+			resp = new(api.UserInviteResponse)
+			if resp.Code, resp.Expires, err = o.InviteCode(args[0], userInviteRenewFlag); err != nil {
+				return err
+			}
+		}
+		if structuredOutput() {
+			return printStructured(resp)
+		}
+		printClaimCode(args[0], resp.Code, resp.Expires)
+		return nil
+	},
+}
+
+func init() {
+	userInviteCmd.Flags().BoolVar(&userInviteRenewFlag, "renew", false, "replace the claim code with a new one")
+	userCmd.AddCommand(userInviteCmd)
+	userCmd.AddCommand(userShowCmd)
+	userCmd.AddCommand(userDisableCmd)
+	userCmd.AddCommand(userEnableCmd)
+	userCmd.AddCommand(userSFTPCmd)
+	userCmd.AddCommand(userTOTPCmd)
+	rootCmd.AddCommand(userCmd)
+}
+
+func runSetUserDisabled(name string, disabled bool) error {
+	if err := requireMode("server"); err != nil {
+		return err
+	}
+	cfg, _ := config.Load()
+	addr := ops.APIAddr(cfg)
+
+	client, err := api.Dial(addr)
+	if err != nil {
+		// No daemon running, update locally.
+		o, err := ops.New()
+		if err != nil {
+			return fmt.Errorf("initializing: %w", err)
+		}
+		if disabled {
+			err = o.DisableUser(context.Background(), name, cliProgress)
+		} else {
+			err = o.EnableUser(context.Background(), name, cliProgress)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		defer client.Close()
+		if err := client.SetUserDisabled(context.Background(), name, disabled); err != nil {
+			return fmt.Errorf("updating user: %w", err)
+		}
+	}
+
+	if disabled {
+		fmt.Printf("  User %q disabled.\n", name)
+	} else {
+		fmt.Printf("  User %q enabled.\n", name)
+	}
+	return nil
+}
+
+// printClaimCode prints the claim code of name's invitation, with how to
+// use it. The code goes to stdout on its own line, the rest to stderr, so
+// it can be piped.
+func printClaimCode(name, code string, expires time.Time) {
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "  Claim code for %q, valid until %s:\n", name, expires.Local().Format("2006-01-02 15:04 MST"))
+	fmt.Fprintln(os.Stderr)
+	fmt.Println(code)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "  Send it to the user only. They run, on their machine:")
+	fmt.Fprintln(os.Stderr, "    tw connect --claim CODE")
+	fmt.Fprintln(os.Stderr, "  which makes their SSH key there and registers it with the server.")
+	fmt.Fprintln(os.Stderr)
+}
+
+func printUserDetail(u *ops.UserDetail) {
+	state := "not registered on the relay"
+	switch {
+	case u.Disabled:
+		state = "disabled"
+	case u.Active && u.Online:
+		state = "registered, online"
+	case u.Active:
+		state = "registered"
+	}
+	key := "missing"
+	if u.HasKey {
+		key = keyLabel(u.UserInfo)
+	} else if u.InviteExpires != nil {
+		key = inviteLabel(u.UserInfo)
+	}
+	lastSeen := "never"
+	if u.Presence.Connected {
+		lastSeen = "connected now"
+	} else if u.LastSeen != nil {
+		lastSeen = u.LastSeen.Local().Format("2006-01-02 15:04 MST")
+	}
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+
+	fmt.Println()
+	fmt.Printf("  %s\n", u.Name)
+	fmt.Printf("    State:     %s\n", state)
+	fmt.Printf("    UUID:      %s\n", orDash(u.UUID))
+	if u.Template != "" {
+		fmt.Printf("    Template:  %s\n", u.Template)
+	}
+	fmt.Printf("    Key:       %s\n", key)
+	fmt.Printf("    SFTP:      %s\n", onOff(u.SFTP))
+	fmt.Printf("    TOTP:      %s\n", onOff(u.TOTP))
+	for _, p := range u.Permit {
+		fmt.Printf("    Permit:    %s\n", p)
+	}
+	fmt.Printf("    Last seen: %s\n", lastSeen)
+	fmt.Printf("    Sessions:  %d", u.Presence.Sessions)
+	if u.Presence.SessionSeconds > 0 {
+		fmt.Printf(", %s connected", (time.Duration(u.Presence.SessionSeconds) * time.Second).Round(time.Minute))
+	}
+	fmt.Println()
+	if u.Traffic != nil {
+		fmt.Printf("    Traffic:   %s up, %s down\n", formatBytes(u.Traffic.Uplink), formatBytes(u.Traffic.Downlink))
+	}
+	fmt.Printf("    Bundle:    %s", u.BundlePath)
+	if u.BundleOutdated {
+		fmt.Printf(" (outdated — export it again with tw export user %s)", u.Name)
+	}
+	fmt.Println()
+	for _, t := range u.Tunnels {
+		fmt.Printf("    Tunnel:    localhost:%d → %s:%d\n", t.LocalPort, t.RemoteHost, t.RemotePort)
+	}
+	fmt.Println()
+}
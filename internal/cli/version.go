@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/version"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the tw version, commit and build date",
+	Long: `Show the version, commit and build date of this tw binary, and the
+version of the running tw daemon when it differs — as it does after tw
+self-update until the daemon is restarted.`,
+	Args: cobra.NoArgs,
+	RunE: runVersion,
+}
+
+func init() {
+	rootCmd.Version = version.String()
+	rootCmd.SetVersionTemplate("tw {{.Version}}\n")
+	rootCmd.AddCommand(versionCmd)
+}
+
+// versionOutput is tw version's structured output.
+type versionOutput struct {
+	version.Info
+	Daemon string `json:"daemon_version,omitempty"`
+}
+
+func runVersion(cmd *cobra.Command, args []string) error {
+	out := versionOutput{Info: version.Get(), Daemon: daemonVersion()}
+	if structuredOutput() {
+		return printStructured(out)
+	}
+	fmt.Printf("  tw %s\n", out.Version)
+	fmt.Printf("  Commit:  %s\n", orDash(out.Commit))
+	fmt.Printf("  Built:   %s\n", orDash(out.Date))
+	fmt.Printf("  Go:      %s %s\n", out.GoVersion, out.Platform)
+	if out.Daemon != "" && out.Daemon != out.Version {
+		fmt.Printf("\n  The running tw daemon is %s — restart it to run this version.\n", out.Daemon)
+	}
+	return nil
+}
+
+// daemonVersion returns the version of the running tw daemon, or "" if
+// none serves the API.
+func daemonVersion() string {
+	in, ok := ops.RunningInstance()
+	if !ok || in.APIPort == 0 {
+		return ""
+	}
+	client, err := api.Dial(fmt.Sprintf("localhost:%d", in.APIPort))
+	if err != nil {
+		return ""
+	}
+	defer client.Close()
+	resp, err := client.GetStatus(context.Background())
+	if err != nil {
+		return ""
+	}
+	return resp.Version
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables that override config.yaml
+// settings, e.g. TW_SERVER_SSH_PORT for server.ssh_port.
+const EnvPrefix = "TW_"
+
+// EnvVar returns the environment variable for a YAML path such as
+// "server.ssh_port".
+func EnvVar(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// EnvPaths lists the YAML paths that can be set from the environment:
+// every setting holding a string, number, boolean, duration or list of
+// strings. Lists of entries, such as client.tunnels, can't.
+func EnvPaths() []string {
+	var paths []string
+	collectEnvPaths(reflect.TypeOf(Config{}), "", &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func collectEnvPaths(t reflect.Type, prefix string, paths *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		switch {
+		case f.Type == durationType:
+			*paths = append(*paths, path)
+		case f.Type.Kind() == reflect.Struct:
+			collectEnvPaths(f.Type, path+".", paths)
+		case f.Type.Kind() == reflect.Slice:
+			if f.Type.Elem().Kind() == reflect.String {
+				*paths = append(*paths, path)
+			}
+		case f.Type.Kind() == reflect.String, f.Type.Kind() == reflect.Int, f.Type.Kind() == reflect.Bool:
+			*paths = append(*paths, path)
+		}
+	}
+}
+
+// ApplyEnv sets the settings that have an environment variable (see
+// EnvPaths) to its value, and returns the variables used. Values are read
+// like YAML scalars; lists are comma-separated.
+func ApplyEnv(cfg *Config) ([]string, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	var used []string
+	for _, path := range EnvPaths() {
+		value, ok := os.LookupEnv(EnvVar(path))
+		if !ok {
+			continue
+		}
+		used = append(used, EnvVar(path))
+		setEnvNode(root, strings.Split(path, "."), value, isListPath(path))
+	}
+	if len(used) == 0 {
+		return nil, nil
+	}
+	if err := root.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("applying %s: %w", strings.Join(used, ", "), err)
+	}
+	return used, nil
+}
+
+// isListPath reports whether the setting at path is a list of strings.
+func isListPath(path string) bool {
+	t := reflect.TypeOf(Config{})
+	for _, name := range strings.Split(path, ".") {
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if tag == name {
+				t = t.Field(i).Type
+				break
+			}
+		}
+	}
+	return t.Kind() == reflect.Slice
+}
+
+// setEnvNode adds value under keys to the mapping node m.
+func setEnvNode(m *yaml.Node, keys []string, value string, list bool) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == keys[0] && len(keys) > 1 {
+			setEnvNode(m.Content[i+1], keys[1:], value, list)
+			return
+		}
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: keys[0]}
+	if len(keys) > 1 {
+		child := &yaml.Node{Kind: yaml.MappingNode}
+		m.Content = append(m.Content, key, child)
+		setEnvNode(child, keys[1:], value, list)
+		return
+	}
+	val := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if list {
+		val = &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				val.Content = append(val.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+			}
+		}
+	}
+	m.Content = append(m.Content, key, val)
+}
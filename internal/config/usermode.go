@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// userMode is set by --user (or TW_USER_MODE): tw runs as an ordinary user,
+// keeping its files in the user's own config directory.
+var userMode bool
+
+// UserModeEnv turns user mode on like --user, for services and scripts.
+const UserModeEnv = "TW_USER_MODE"
+
+// MinUserPort is the lowest port a user without root may listen on.
+const MinUserPort = 1024
+
+// SetUserMode switches Dir, and with it the users, relay and every other
+// file tw keeps, to the per-user config directory:
+//
+//	Linux:   $XDG_CONFIG_HOME/tw, or ~/.config/tw
+//	macOS:   ~/Library/Application Support/tw
+//	Windows: %AppData%\tw
+//
+// Validate then also rejects ports below MinUserPort.
+func SetUserMode(on bool) {
+	userMode = on
+}
+
+// UserMode reports whether tw runs in user mode: set by SetUserMode, or
+// by UserModeEnv being "1" or "true".
+func UserMode() bool {
+	if userMode {
+		return true
+	}
+	v := os.Getenv(UserModeEnv)
+	return v == "1" || v == "true"
+}
+
+// userDir returns the per-user config directory.
+func userDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "tw")
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one thing wrong with a configuration.
+type Problem struct {
+	Field   string `json:"field,omitempty"` // YAML path, e.g. "server.api_port"; empty for the file as a whole
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// Parse decodes YAML over the defaults, the way Load reads config.yaml.
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	return cfg, nil
+}
+
+// sections maps the Go types in yaml.v3 errors to their place in the file.
+var sections = map[string]string{
+	"config.Config":            "",
+	"config.XrayConfig":        "xray",
+	"config.TLSConfig":         "xray.tls",
+	"config.ServerConfig":      "server",
+	"config.ReverseForward":    "server.reverse_forwards[]",
+	"config.MappingTemplate":   "server.templates[]",
+	"config.RelayFirewall":     "server.relay_firewall",
+	"config.FirewallPort":      "server.relay_firewall.ports[]",
+	"config.MaintenanceConfig": "server.relay_maintenance",
+	"config.ClientConfig":      "client",
+	"config.Tunnel":            "client.tunnels[]",
+	"config.NetworkConfig":     "network",
+	"config.ProxyRule":         "proxy_rules[]",
+	"config.DashboardConfig":   "dashboard",
+	"config.RateLimitConfig":   "rate_limit",
+	"config.GeoIPConfig":       "geoip",
+}
+
+var (
+	unknownFieldRe = regexp.MustCompile(`^line (\d+): field (\S+) not found in type (\S+)$`)
+	countryCodeRe  = regexp.MustCompile(`^[A-Za-z]{2}$`)
+)
+
+// UnknownKeys decodes data strictly and reports keys that aren't settings,
+// which are usually typos, and values of the wrong type.
+func UnknownKeys(data []byte) []Problem {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(Default())
+	if err == nil {
+		return nil
+	}
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		if errors.Is(err, io.EOF) {
+			return nil // empty file
+		}
+		return []Problem{{Message: err.Error()}}
+	}
+	var problems []Problem
+	for _, e := range te.Errors {
+		m := unknownFieldRe.FindStringSubmatch(e)
+		if m == nil {
+			problems = append(problems, Problem{Message: e})
+			continue
+		}
+		field := m[2]
+		if s := sections[m[3]]; s != "" {
+			field = s + "." + field
+		}
+		problems = append(problems, Problem{Field: field, Message: "unknown key on line " + m[1] + " (misspelled or misplaced?)"})
+	}
+	return problems
+}
+
+// Validate checks the settings that can be judged from the config alone:
+// allowed values, port ranges, ports that would collide, and settings that
+// only work together. Checks owned by other packages (transport, TLS, proxy
+// rules) are added by ops.ValidateConfig.
+func (c *Config) Validate() []Problem {
+	var v validator
+
+	v.oneOf("mode", c.Mode, "", "server", "client")
+	v.oneOf("log_level", c.LogLevel, "", "debug", "info", "warn", "error")
+	v.oneOf("proxy_mode", c.ProxyMode, "", "off", "manual", "auto")
+	v.oneOf("credential_store", c.CredentialStore, "", "file", "keychain", "auto")
+	// A secret: reference is resolved and checked by ops.ValidateConfig.
+	if c.Proxy != "" && !strings.HasPrefix(c.Proxy, "secret:") {
+		// A literal backslash is allowed in ntlm://DOMAIN\user URLs.
+		u, err := url.Parse(strings.Replace(c.Proxy, `\`, `%5C`, 1))
+		switch {
+		case err != nil:
+			v.add("proxy", "not a valid URL: %v", err)
+		case u.Scheme != "socks5" && u.Scheme != "http" && u.Scheme != "ntlm":
+			v.add("proxy", "unsupported scheme %q (use socks5://, http:// or ntlm://)", u.Scheme)
+		case u.Hostname() == "":
+			v.add("proxy", "must include a host")
+		}
+	}
+
+	x := c.Xray
+	if x.UUID != "" {
+		if _, err := uuid.Parse(x.UUID); err != nil {
+			v.add("xray.uuid", "not a valid UUID")
+		}
+	}
+	switch {
+	case x.UUID != "" && x.RelayHost == "":
+		v.add("xray.relay_host", "required when xray.uuid is set — provision a relay or set the relay's domain")
+	case x.UUID == "" && x.RelayHost != "":
+		v.add("xray.uuid", "required when xray.relay_host is set — provision the relay again or copy the UUID from the relay's Xray config")
+	case x.UUID == "" && c.Mode == "client":
+		v.add("xray", "client mode needs xray.uuid and xray.relay_host — import the user's config bundle")
+	}
+	v.port("xray.relay_port", x.RelayPort)
+	if !strings.HasPrefix(x.Path, "/") {
+		v.add("xray.path", "must start with / (e.g. /tw)")
+	}
+	if x.OriginIP != "" && net.ParseIP(x.OriginIP) == nil {
+		v.add("xray.origin_ip", "must be an IP address")
+	}
+	v.oneOf("xray.log_level", x.LogLevel, "", "debug", "info", "warning", "error", "none")
+
+	if c.Mode != "client" {
+		s := c.Server
+		v.port("server.ssh_port", s.SSHPort)
+		v.port("server.api_port", s.APIPort)
+		v.port("server.dashboard_port", s.DashboardPort)
+		v.port("server.relay_ssh_port", s.RelaySSHPort)
+		v.port("server.remote_port", s.RemotePort)
+		v.oneOf("server.relay_backend", s.RelayBackend, "", "terraform", "sdk")
+		v.distinct([]listener{
+			{"server.ssh_port", "server.ssh_port", s.SSHPort},
+			{"server.ssh_port", "the Xray listener on server.ssh_port + 1", s.SSHPort + 1},
+			{"server.api_port", "server.api_port", s.APIPort},
+			{"server.dashboard_port", "server.dashboard_port", s.DashboardPort},
+		})
+		v.unprivileged("server.ssh_port", s.SSHPort)
+		v.unprivileged("server.api_port", s.APIPort)
+		v.unprivileged("server.dashboard_port", s.DashboardPort)
+		remote := map[int]string{s.RemotePort: "server.remote_port"}
+		for i, rf := range s.ReverseForwards {
+			field := fmt.Sprintf("server.reverse_forwards[%d]", i)
+			v.port(field+".remote_port", rf.RemotePort)
+			if other, ok := remote[rf.RemotePort]; ok && rf.RemotePort != 0 {
+				v.add(field+".remote_port", "%d is already used by %s", rf.RemotePort, other)
+			}
+			remote[rf.RemotePort] = field
+			if _, port, err := net.SplitHostPort(rf.LocalAddr); err != nil || port == "" {
+				v.add(field+".local_addr", "must be host:port, e.g. 127.0.0.1:8080")
+			}
+			if rf.PublicPort != 0 {
+				v.port(field+".public_port", rf.PublicPort)
+			}
+		}
+		names := map[string]bool{}
+		for i, t := range s.Templates {
+			field := fmt.Sprintf("server.templates[%d]", i)
+			if t.Name == "" {
+				v.add(field+".name", "is required")
+			} else if names[t.Name] {
+				v.add(field+".name", "duplicate template %q", t.Name)
+			}
+			names[t.Name] = true
+		}
+		fw := s.RelayFirewall
+		open := map[string]string{}
+		for i, p := range fw.Ports {
+			field := fmt.Sprintf("server.relay_firewall.ports[%d]", i)
+			v.port(field+".port", p.Port)
+			v.oneOf(field+".protocol", p.Protocol, "", "tcp", "udp")
+			switch p.Port {
+			case 80, 443:
+				v.add(field+".port", "%d is always open", p.Port)
+			case 22:
+				v.add(field+".port", "use server.relay_firewall.ssh_sources for SSH")
+			}
+			key := fmt.Sprintf("%d/%s", p.Port, p.Proto())
+			if other, ok := open[key]; ok {
+				v.add(field+".port", "%s is already opened by %s", key, other)
+			}
+			open[key] = field
+			v.cidrs(field+".sources", p.Sources)
+		}
+		v.cidrs("server.relay_firewall.ssh_sources", fw.SSHSources)
+		if _, _, err := s.RelayMaintenance.window(); err != nil {
+			v.add("server.relay_maintenance.window", "%v", err)
+		}
+		for i, d := range s.RelayMaintenance.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				v.add(fmt.Sprintf("server.relay_maintenance.days[%d]", i), "%q is not a day (mon, tue, wed, thu, fri, sat, sun)", d)
+			}
+		}
+		// Syslog addresses are checked by ops.ValidateConfig.
+		for i, es := range s.EventSinks {
+			field := fmt.Sprintf("server.event_sinks[%d]", i)
+			switch es.Type {
+			case "syslog":
+				continue
+			case "http":
+			default:
+				v.add(field+".type", "%q is not one of syslog, http", es.Type)
+				continue
+			}
+			if u, err := url.Parse(es.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.add(field+".url", "must be an http:// or https:// URL")
+			}
+		}
+	}
+
+	if c.Mode != "server" {
+		cl := c.Client
+		v.port("client.server_ssh_port", cl.ServerSSHPort)
+		local := map[int]string{}
+		names := map[string]string{}
+		for i, t := range cl.Tunnels {
+			field := fmt.Sprintf("client.tunnels[%d]", i)
+			if t.Name != "" {
+				if _, err := strconv.Atoi(t.Name); err == nil {
+					v.add(field+".name", "%q must not be a number, which would be taken for a local port", t.Name)
+				} else if other, ok := names[t.Name]; ok {
+					v.add(field+".name", "%q is already used by %s", t.Name, other)
+				}
+				names[t.Name] = field
+			}
+			v.port(field+".local_port", t.LocalPort)
+			v.unprivileged(field+".local_port", t.LocalPort)
+			v.port(field+".remote_port", t.RemotePort)
+			if t.RemoteHost == "" {
+				v.add(field+".remote_host", "is required (e.g. 127.0.0.1)")
+			}
+			if h := t.ListenHost; h != "" && h != "localhost" && net.ParseIP(h) == nil {
+				v.add(field+".listen_host", "%q is not an IP address (e.g. 127.0.0.1, or 0.0.0.0 for all interfaces)", h)
+			}
+			if other, ok := local[t.LocalPort]; ok && t.LocalPort != 0 {
+				v.add(field+".local_port", "%d is already used by %s", t.LocalPort, other)
+			}
+			local[t.LocalPort] = field
+		}
+	}
+
+	d := c.Dashboard
+	if (d.CertFile == "") != (d.KeyFile == "") {
+		v.add("dashboard.cert_file", "cert_file and key_file must be set together")
+	}
+	if !d.TLS && (d.CertFile != "" || d.ClientCA != "") {
+		v.add("dashboard.tls", "must be true to use cert_file or client_ca")
+	}
+	if d.TerminalKeepalive < 0 {
+		v.add("dashboard.terminal_keepalive", "must not be negative")
+	}
+	if len(d.HostCommands) > 0 && !d.HostTerminal {
+		v.add("dashboard.host_terminal", "must be true to use host_commands")
+	}
+	for i, cmd := range d.HostCommands {
+		if strings.TrimSpace(cmd) == "" {
+			v.add(fmt.Sprintf("dashboard.host_commands[%d]", i), "must not be empty")
+		}
+	}
+
+	n := c.Network
+	v.positive("network.keepalive_interval", n.KeepaliveInterval > 0)
+	v.positive("network.dial_timeout", n.DialTimeout > 0)
+	v.positive("network.max_backoff", n.MaxBackoff > 0)
+	v.positive("network.handshake_retries", n.HandshakeRetries > 0)
+
+	rl := c.RateLimit
+	v.positive("rate_limit.max_failures", rl.MaxFailures > 0)
+	v.positive("rate_limit.window", rl.Window > 0)
+	v.positive("rate_limit.ban_time", rl.BanTime > 0)
+	if rl.MaxBanTime < rl.BanTime {
+		v.add("rate_limit.max_ban_time", "must not be shorter than ban_time")
+	}
+
+	for _, list := range []struct {
+		field string
+		codes []string
+	}{{"geoip.allow", c.GeoIP.Allow}, {"geoip.deny", c.GeoIP.Deny}} {
+		for i, code := range list.codes {
+			if !countryCodeRe.MatchString(code) {
+				v.add(fmt.Sprintf("%s[%d]", list.field, i), "%q is not a two-letter country code (e.g. DE)", code)
+			}
+		}
+	}
+
+	return v.problems
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "%q is not one of %s", value, strings.Join(allowed[1:], ", "))
+}
+
+func (v *validator) port(field string, p int) {
+	if p < 1 || p > 65535 {
+		v.add(field, "%d is not a valid port (1-65535)", p)
+	}
+}
+
+// unprivileged reports a port only root may listen on, in user mode.
+func (v *validator) unprivileged(field string, p int) {
+	if UserMode() && p > 0 && p < MinUserPort {
+		v.add(field, "%d needs root to listen on; in user mode use a port from %d up", p, MinUserPort)
+	}
+}
+
+func (v *validator) cidrs(field string, cidrs []string) {
+	for i, c := range cidrs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "%q is not a CIDR (e.g. 203.0.113.0/24 or 198.51.100.7/32)", c)
+		}
+	}
+}
+
+func (v *validator) positive(field string, ok bool) {
+	if !ok {
+		v.add(field, "must be greater than zero")
+	}
+}
+
+// listener is a local port the server listens on.
+type listener struct {
+	field string
+	desc  string
+	port  int
+}
+
+// distinct reports listeners that share a port, on the later field.
+func (v *validator) distinct(ls []listener) {
+	seen := map[int]string{}
+	for _, l := range ls {
+		if l.port < 1 || l.port > 65535 {
+			continue // already reported by port
+		}
+		if other, dup := seen[l.port]; dup {
+			v.add(l.field, "port %d conflicts with %s", l.port, other)
+			continue
+		}
+		seen[l.port] = l.desc
+	}
+}
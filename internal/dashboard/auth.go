@@ -0,0 +1,161 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/auth"
+)
+
+// sessionCookie holds the API token of a browser signed in at /login.
+const sessionCookie = "tw_session"
+
+type tokenKey struct{}
+
+// handle registers a handler that requires the given role once access
+// control is on. Requests other than GET and HEAD always require admin.
+// An empty need leaves the route open, for the login page and static files.
+func (s *Server) handle(pattern string, need auth.Role, h http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if need == "" || !auth.Enabled() {
+			h(w, r)
+			return
+		}
+		tok, err := auth.Authenticate(requestToken(r))
+		if err != nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				jsonError(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		role := need
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			role = auth.RoleAdmin
+		}
+		if !tok.Role.Allows(role) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				jsonError(w, "this action requires the "+string(role)+" role", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "This page requires the "+string(role)+" role.", http.StatusForbidden)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok)))
+	})
+}
+
+// requestToken returns the API token sent with a request, from an
+// "Authorization: Bearer" header or the session cookie.
+func requestToken(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// requestRole returns the role of the token a request was authorized with,
+// or "" while access control is off.
+func requestRole(r *http.Request) auth.Role {
+	tok, _ := r.Context().Value(tokenKey{}).(auth.Token)
+	return tok.Role
+}
+
+// remoteIP returns the address a request came from, without the port.
+// X-Forwarded-For is ignored: anyone could set it to dodge a ban.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setSession signs the browser in with an API token.
+func (s *Server) setSession(w http.ResponseWriter, secret string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.ops.Config().Dashboard.TLS,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// handleLogin shows the sign-in form and, on POST, checks the token and
+// sets the session cookie.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	if !auth.Enabled() {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	data := struct {
+		pageData
+		Next  string
+		Error string
+	}{
+		pageData: pageData{Title: "Sign In", Active: "login"},
+		Next:     next,
+	}
+	if r.Method == http.MethodPost {
+		// Repeated failures ban the source address (rate_limit).
+		bans := s.ops.LoginLimiter()
+		source := remoteIP(r)
+		if until, banned := bans.Banned(source); banned {
+			data.Error = "Too many failed sign-ins. Try again after " + until.Format("15:04:05") + "."
+			w.WriteHeader(http.StatusTooManyRequests)
+			s.renderPage(w, "login", data)
+			return
+		}
+
+		secret := strings.TrimSpace(r.FormValue("token"))
+		if _, err := auth.Authenticate(secret); err != nil {
+			data.Error = "Invalid or expired token."
+			if !errors.Is(err, auth.ErrUnauthorized) {
+				data.Error = err.Error()
+			}
+			if until, banned := bans.Fail(source); banned {
+				slog.Warn("dashboard sign-in banned after repeated failures", "source", source, "until", until.Format(time.RFC3339))
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			s.renderPage(w, "login", data)
+			return
+		}
+		bans.Success(source)
+		s.setSession(w, secret)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	s.renderPage(w, "login", data)
+}
+
+// handleLogout clears the session cookie.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.ops.Config().Dashboard.TLS,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -1,15 +1,24 @@
 package dashboard
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/tunnelwhisperer/tw/internal/api"
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/logging"
 	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/version"
 )
 
 func jsonOK(w http.ResponseWriter, v interface{}) {
@@ -26,6 +35,11 @@ func jsonError(w http.ResponseWriter, msg string, code int) {
 // ── Read-only endpoints ─────────────────────────────────────────────────────
 
 func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
+	jsonOK(w, s.statusSnapshot())
+}
+
+// statusSnapshot is the body of /api/status, also sent by /api/ws.
+func (s *Server) statusSnapshot() map[string]interface{} {
 	mode := s.ops.Mode()
 	relay := s.ops.GetRelayStatus()
 	users, _ := s.ops.ListUsers()
@@ -39,11 +53,14 @@ func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := map[string]interface{}{
-		"mode":           mode,
-		"version":        "0.1.0-dev",
-		"relay":          relay,
-		"user_count":     registeredCount,
-		"config_changed": s.ops.ConfigChanged(),
+		"mode":            mode,
+		"version":         version.Version,
+		"commit":          version.Commit,
+		"build_date":      version.Date,
+		"relay":           relay,
+		"user_count":      registeredCount,
+		"config_changed":  s.ops.ConfigChanged(),
+		"key_fingerprint": ops.KeyFingerprint(),
 	}
 
 	if mode == "server" {
@@ -59,13 +76,18 @@ func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
 	if mode == "client" {
 		resp["client"] = s.ops.ClientStatus()
 	}
-
-	jsonOK(w, resp)
+	return resp
 }
 
 func (s *Server) apiConfig(w http.ResponseWriter, r *http.Request) {
-	cfg := s.ops.Config()
-	jsonOK(w, cfg)
+	switch r.Method {
+	case http.MethodGet:
+		jsonOK(w, s.ops.Config())
+	case http.MethodPut:
+		s.apiSaveConfig(w, r)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
 func (s *Server) apiProviders(w http.ResponseWriter, r *http.Request) {
@@ -76,8 +98,56 @@ func (s *Server) apiRelay(w http.ResponseWriter, r *http.Request) {
 	jsonOK(w, s.ops.GetRelayStatus())
 }
 
+// apiRelayStats returns the relay's Xray traffic counters, read-only, for
+// monitoring tools.
+func (s *Server) apiRelayStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	stats, err := s.ops.GetRelayStats(r.Context(), q.Get("pattern"), q.Get("sys") == "1")
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, stats)
+}
+
 // ── Mode ─────────────────────────────────────────────────────────────────────
 
+// apiSetupState serves the first-run setup state on GET. POST
+// {"step": name} completes a step with no page of its own and returns the
+// new state, except "verify", which runs the connectivity test and
+// returns an SSE session_id.
+func (s *Server) apiSetupState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jsonOK(w, s.ops.SetupState())
+	case http.MethodPost:
+		var req struct {
+			Step string `json:"step"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Step == ops.SetupVerify {
+			sessionID, progress := s.sse.create()
+			go s.ops.VerifySetup(progress)
+			jsonOK(w, map[string]string{"session_id": sessionID})
+			return
+		}
+		if err := s.ops.CompleteSetupStep(req.Step); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonOK(w, s.ops.SetupState())
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) apiSetMode(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -206,6 +276,57 @@ func (s *Server) apiClientReconnect(w http.ResponseWriter, r *http.Request) {
 	jsonOK(w, map[string]string{"session_id": sessionID})
 }
 
+func (s *Server) apiClientTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+
+	go func() {
+		s.ops.TestConnection(progress)
+	}()
+
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
+// apiClientTunnelAction handles POST /api/client/tunnels/{port}/reconnect
+// and /api/client/tunnels/{name or port}/enable or /disable.
+func (s *Server) apiClientTunnelAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/client/tunnels/")
+	ref, action, _ := strings.Cut(rest, "/")
+
+	switch action {
+	case "reconnect":
+		port, err := strconv.Atoi(ref)
+		if err != nil {
+			jsonError(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err := s.ops.ReconnectTunnel(port); err != nil {
+			jsonError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "reconnected"})
+
+	case "enable", "disable":
+		if err := s.ops.SetTunnelEnabled(ref, action == "enable"); err != nil {
+			jsonError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		jsonOK(w, map[string]string{"status": action + "d"})
+
+	default:
+		jsonError(w, "not found", http.StatusNotFound)
+	}
+}
+
 func (s *Server) apiClientUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -239,6 +360,30 @@ func (s *Server) apiClientUpload(w http.ResponseWriter, r *http.Request) {
 	jsonOK(w, map[string]string{"status": "ok"})
 }
 
+// apiClientClaim claims an invitation with the claim code in the body,
+// making this client's key, and streams the progress.
+func (s *Server) apiClientClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		jsonError(w, "claim code required", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+	go func() {
+		if err := s.ops.ClaimInvitation(req.Code, progress); err != nil {
+			slog.Error("invitation claim failed", "error", err)
+		}
+	}()
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
 // ── Relay endpoints ──────────────────────────────────────────────────────────
 
 func (s *Server) apiTestCreds(w http.ResponseWriter, r *http.Request) {
@@ -325,6 +470,89 @@ func (s *Server) apiTestRelay(w http.ResponseWriter, r *http.Request) {
 	jsonOK(w, map[string]string{"session_id": sessionID})
 }
 
+// apiRelayAction runs a relay action (reboot, restart-xray, restart-caddy,
+// poweroff) and returns the session whose SSE stream reports its progress.
+func (s *Server) apiRelayAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	known := false
+	for _, a := range ops.RelayActions() {
+		known = known || a == req.Action
+	}
+	if !known {
+		jsonError(w, fmt.Sprintf("unknown relay action %q", req.Action), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+
+	go func() {
+		if err := s.ops.RelayAction(context.Background(), req.Action, progress); err != nil {
+			slog.Error("relay action failed", "action", req.Action, "error", err)
+		}
+	}()
+
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
+// apiRelayRecordings lists the recorded relay shell sessions.
+func (s *Server) apiRelayRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recs, err := s.ops.ListRecordings()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recs == nil {
+		recs = []ops.RecordingInfo{}
+	}
+	jsonOK(w, map[string]interface{}{"recordings": recs})
+}
+
+func (s *Server) apiRelayRecordingAction(w http.ResponseWriter, r *http.Request) {
+	// Routes: GET /api/relay/recordings/{name} (?download=1 as attachment)
+	//         DELETE /api/relay/recordings/{name}
+	name := strings.TrimPrefix(r.URL.Path, "/api/relay/recordings/")
+	path, err := s.ops.RecordingPath(name)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		if r.URL.Query().Get("download") == "1" {
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+		}
+		http.ServeFile(w, r, path)
+
+	case http.MethodDelete:
+		if err := s.ops.DeleteRecording(name); err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("relay shell recording deleted", "name", name)
+		jsonOK(w, map[string]string{"status": "deleted"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) apiGenerateScript(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -332,14 +560,16 @@ func (s *Server) apiGenerateScript(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Domain string `json:"domain"`
+		Domain  string      `json:"domain"`
+		ACMEDNS ops.ACMEDNS `json:"acme_dns"`
+		CDN     bool        `json:"cdn"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	script, err := s.ops.GenerateManualInstallScript(req.Domain)
+	script, err := s.ops.GenerateManualInstallScript(req.Domain, req.ACMEDNS, req.CDN)
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -378,19 +608,81 @@ func (s *Server) apiSaveManualRelay(w http.ResponseWriter, r *http.Request) {
 
 // ── User endpoints ───────────────────────────────────────────────────────────
 
+const (
+	// maxUsersBody caps a POST /api/users body, like the import's form.
+	maxUsersBody = 10 << 20
+	// maxUserBatch caps how many users one POST /api/users array creates.
+	maxUserBatch = 1000
+)
+
 func (s *Server) apiUsers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		users, err := s.ops.ListUsers()
+		// Without query parameters, return the plain list for compatibility.
+		if r.URL.RawQuery == "" {
+			users, err := s.ops.ListUsers()
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonOK(w, users)
+			return
+		}
+
+		page, err := s.ops.QueryUsers(userQueryFromRequest(r))
 		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+			jsonError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		jsonOK(w, users)
+		jsonOK(w, page)
 
 	case http.MethodPost:
+		// An array of requests creates the users in one batch, over a
+		// single relay update, and answers once they are done with the
+		// outcome for each, so the caller knows which users exist.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUsersBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				jsonError(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			var reqs []ops.CreateUserRequest
+			if err := json.Unmarshal(trimmed, &reqs); err != nil {
+				jsonError(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			if len(reqs) > maxUserBatch {
+				jsonError(w, fmt.Sprintf("at most %d users can be created at once", maxUserBatch), http.StatusBadRequest)
+				return
+			}
+			var results []api.CreateUserResult
+			progress := func(e ops.ProgressEvent) {
+				if e.Step < 2 || e.Status == "running" {
+					return
+				}
+				results = append(results, api.CreateUserResult{Name: e.Label, Status: e.Status, Message: e.Message, Error: e.Error})
+			}
+			err := s.ops.CreateUsers(r.Context(), reqs, progress)
+			if err != nil && len(results) == 0 {
+				jsonError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp := api.CreateUsersResponse{Message: fmt.Sprintf("created %d users", len(reqs)), Results: results}
+			if err != nil {
+				slog.Error("user creation failed", "error", err)
+				resp.Message = err.Error()
+			}
+			jsonOK(w, resp)
+			return
+		}
+
 		var req ops.CreateUserRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := json.Unmarshal(body, &req); err != nil {
 			jsonError(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
@@ -410,109 +702,647 @@ func (s *Server) apiUsers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) apiUserAction(w http.ResponseWriter, r *http.Request) {
-	// Routes: DELETE /api/users/{name}, GET /api/users/{name}/download
-	path := strings.TrimPrefix(r.URL.Path, "/api/users/")
-	parts := strings.SplitN(path, "/", 2)
-	name := parts[0]
-
-	if name == "" {
-		jsonError(w, "user name required", http.StatusBadRequest)
+func (s *Server) apiImportUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if len(parts) == 2 && parts[1] == "download" {
-		s.apiUserDownload(w, r, name)
+	// Accept multipart form with a "file" field holding CSV or YAML.
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
+		jsonError(w, "invalid multipart form", http.StatusBadRequest)
 		return
 	}
 
-	switch r.Method {
-	case http.MethodDelete:
-		if err := s.ops.DeleteUser(name); err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		jsonOK(w, map[string]string{"status": "deleted"})
-
-	default:
-		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		jsonError(w, "missing 'file' field", http.StatusBadRequest)
+		return
 	}
-}
+	defer file.Close()
 
-func (s *Server) apiApplyUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		jsonError(w, "reading uploaded file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var req struct {
-		Names []string `json:"names"`
+	format := r.FormValue("format")
+	if format == "" {
+		format = ops.ImportFormat(header.Filename)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, "invalid request body", http.StatusBadRequest)
+	reqs, err := ops.ParseUserImport(data, format)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	sessionID, progress := s.sse.create()
 
 	go func() {
-		if err := s.ops.ApplyUsers(context.Background(), req.Names, progress); err != nil {
-			slog.Error("apply users failed", "error", err)
+		if err := s.ops.CreateUsers(context.Background(), reqs, progress); err != nil {
+			slog.Error("user import failed", "error", err)
 		}
 	}()
 
 	jsonOK(w, map[string]string{"session_id": sessionID})
 }
 
-func (s *Server) apiUnregisterUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+func (s *Server) apiUserAction(w http.ResponseWriter, r *http.Request) {
+	// Routes: PUT/DELETE /api/users/{name}, GET /api/users/{name}/download,
+	// POST /api/users/{name}/disable, POST /api/users/{name}/enable,
+	// POST /api/users/{name}/sftp, POST /api/users/{name}/totp,
+	// GET /api/users/{name}/totp.png, GET/POST /api/users/{name}/invite,
+	// GET/POST /api/users/{name}/links
+	path := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	parts := strings.SplitN(path, "/", 2)
+	name := parts[0]
+
+	if name == "" {
+		jsonError(w, "user name required", http.StatusBadRequest)
 		return
 	}
 
-	var req struct {
-		Names []string `json:"names"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, "invalid request body", http.StatusBadRequest)
+	if len(parts) == 2 && parts[1] == "download" {
+		s.apiUserDownload(w, r, name)
 		return
 	}
 
-	sessionID, progress := s.sse.create()
-
-	go func() {
-		if err := s.ops.UnregisterUsers(context.Background(), req.Names, progress); err != nil {
-			slog.Error("unregister users failed", "error", err)
-		}
-	}()
-
-	jsonOK(w, map[string]string{"session_id": sessionID})
-}
-
-func (s *Server) apiOnlineUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	if len(parts) == 2 && (parts[1] == "disable" || parts[1] == "enable") {
+		s.apiUserSetDisabled(w, r, name, parts[1] == "disable")
 		return
 	}
 
-	online := s.ops.GetOnlineUsers()
-	uuids := make([]string, 0, len(online))
-	for uuid := range online {
-		uuids = append(uuids, uuid)
+	if len(parts) == 2 && parts[1] == "sftp" {
+		if r.Method != http.MethodPost {
+			jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.ops.SetUserSFTP(name, req.Enabled); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonOK(w, map[string]bool{"sftp": req.Enabled})
+		return
 	}
-	jsonOK(w, map[string]interface{}{"online": uuids})
-}
 
-func (s *Server) apiUserDownload(w http.ResponseWriter, r *http.Request, name string) {
-	data, err := s.ops.GetUserConfigBundle(name)
+	if len(parts) == 2 && parts[1] == "totp" {
+		if r.Method != http.MethodPost {
+			jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		e, err := s.ops.SetUserTOTP(name, req.Enabled)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonOK(w, map[string]any{"totp": req.Enabled, "enrollment": e})
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "invite" {
+		// GET shows the claim code; POST renews it.
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		code, expires, err := s.ops.InviteCode(name, r.Method == http.MethodPost)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		jsonOK(w, map[string]any{"code": code, "expires": expires})
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "links" {
+		s.apiUserBundleLinks(w, r, name)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "totp.png" {
+		s.apiUserTOTPQR(w, name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req ops.UpdateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = name
+		if err := s.ops.UpdateUser(req); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newName := name
+		if req.NewName != "" {
+			newName = req.NewName
+		}
+		jsonOK(w, map[string]string{"status": "updated", "name": newName})
+
+	case http.MethodDelete:
+		if err := s.ops.DeleteUser(name); err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "deleted"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiUserTOTPQR serves a user's TOTP enrollment QR code as a PNG.
+func (s *Server) apiUserTOTPQR(w http.ResponseWriter, name string) {
+	e, err := s.ops.UserTOTP(name)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	code, err := e.QR()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code.Scale = 6
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+func (s *Server) apiUserSetDisabled(w http.ResponseWriter, r *http.Request, name string, disabled bool) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+
+	go func() {
+		var err error
+		if disabled {
+			err = s.ops.DisableUser(context.Background(), name, progress)
+		} else {
+			err = s.ops.EnableUser(context.Background(), name, progress)
+		}
+		if err != nil {
+			slog.Error("user state change failed", "user", name, "disabled", disabled, "error", err)
+		}
+	}()
+
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
+// ── Template endpoints ───────────────────────────────────────────────────────
+
+func (s *Server) apiTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := s.ops.ListTemplates()
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, templates)
+
+	case http.MethodPost:
+		var req struct {
+			config.MappingTemplate
+			Propagate bool `json:"propagate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if !req.Propagate {
+			if err := s.ops.SaveTemplate(context.Background(), req.MappingTemplate, false, nil); err != nil {
+				jsonError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			jsonOK(w, map[string]string{"status": "ok"})
+			return
+		}
+
+		sessionID, progress := s.sse.create()
+
+		go func() {
+			if err := s.ops.SaveTemplate(context.Background(), req.MappingTemplate, true, progress); err != nil {
+				slog.Error("template update failed", "error", err)
+			}
+		}()
+
+		jsonOK(w, map[string]string{"session_id": sessionID})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiTemplateAction(w http.ResponseWriter, r *http.Request) {
+	// Routes: DELETE /api/templates/{name}
+	name := strings.TrimPrefix(r.URL.Path, "/api/templates/")
+	if name == "" {
+		jsonError(w, "template name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		if err := s.ops.DeleteTemplate(name); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "deleted"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ── API token endpoints ──────────────────────────────────────────────────────
+
+func (s *Server) apiTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := auth.ListTokens()
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, tokens)
+
+	case http.MethodPost:
+		var req struct {
+			Name        string `json:"name"`
+			Role        string `json:"role"`
+			ExpiresDays int    `json:"expires_days"` // 0 never expires
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		role, err := auth.ParseRole(req.Role)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresDays < 0 {
+			jsonError(w, "expires_days must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		// The first token turns access control on. It has to be an admin
+		// token, and this browser is signed in with it so it isn't locked
+		// out.
+		first := !auth.Enabled()
+		if first && role != auth.RoleAdmin {
+			jsonError(w, "the first token must have the admin role", http.StatusBadRequest)
+			return
+		}
+		secret, tok, err := auth.CreateToken(req.Name, role, time.Duration(req.ExpiresDays)*24*time.Hour)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if first {
+			s.setSession(w, secret)
+		}
+		slog.Info("API token created", "name", tok.Name, "role", tok.Role)
+		jsonOK(w, map[string]interface{}{"token": tok, "secret": secret})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiTokenAction(w http.ResponseWriter, r *http.Request) {
+	// Routes: DELETE /api/tokens/{name}
+	name := strings.TrimPrefix(r.URL.Path, "/api/tokens/")
+	if name == "" {
+		jsonError(w, "token name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		if err := auth.RevokeToken(name); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		slog.Info("API token revoked", "name", name)
+		jsonOK(w, map[string]string{"status": "revoked"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ── Bundle links ────────────────────────────────────────────────────────────
+
+func (s *Server) apiBundleLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		links, err := s.ops.ListBundleLinks("")
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, map[string]any{"enabled": s.ops.BundleLinksEnabled(), "links": links})
+
+	case http.MethodPost:
+		// Turns bundle links on or off on the relay, with progress.
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		sessionID, progress := s.sse.create()
+		go func() {
+			var err error
+			if req.Enabled {
+				err = s.ops.EnableBundleLinks(context.Background(), progress)
+			} else {
+				err = s.ops.DisableBundleLinks(context.Background(), progress)
+			}
+			if err != nil {
+				slog.Error("updating bundle links failed", "error", err)
+			}
+		}()
+		jsonOK(w, map[string]string{"session_id": sessionID})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiBundleLinkAction(w http.ResponseWriter, r *http.Request) {
+	// Routes: DELETE /api/bundle-links/{id}
+	id := strings.TrimPrefix(r.URL.Path, "/api/bundle-links/")
+	if id == "" {
+		jsonError(w, "link id required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		if err := s.ops.RevokeBundleLink(id); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "revoked"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiUserBundleLinks lists (GET) or makes (POST) the bundle links of the
+// user name. A new link's URL is in the response only.
+func (s *Server) apiUserBundleLinks(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		links, err := s.ops.ListBundleLinks(name)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, map[string]any{"enabled": s.ops.BundleLinksEnabled(), "links": links})
+
+	case http.MethodPost:
+		var req struct {
+			ExpiresHours int `json:"expires_hours"` // 0: 24 hours
+			MaxUses      int `json:"max_uses"`      // 0: any number until it expires
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		link, url, err := s.ops.CreateBundleLink(name, time.Duration(req.ExpiresHours)*time.Hour, req.MaxUses)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonOK(w, map[string]any{"link": link, "url": url})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ── Ban list ────────────────────────────────────────────────────────────────
+
+func (s *Server) apiBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bans := s.ops.Bans()
+		if bans == nil {
+			bans = []ops.SourceBan{}
+		}
+		jsonOK(w, bans)
+
+	case http.MethodDelete:
+		// DELETE /api/bans clears every ban; ?service=ssh&key=203.0.113.7
+		// lifts one.
+		service, key := r.URL.Query().Get("service"), r.URL.Query().Get("key")
+		if err := s.ops.ClearBan(service, key); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		slog.Info("ban cleared", "service", service, "source", key)
+		jsonOK(w, map[string]string{"status": "cleared"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiNotifications serves the notification center: GET lists the
+// notifications, POST {"ids": [...]} acknowledges them and DELETE dismisses
+// them (?id=N for one). Without IDs, POST and DELETE apply to all.
+func (s *Server) apiNotifications(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, unread := s.ops.Notifications()
+		jsonOK(w, map[string]interface{}{"notifications": list, "unread": unread})
+
+	case http.MethodPost:
+		var req struct {
+			IDs []int64 `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.ops.AckNotifications(req.IDs); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "acknowledged"})
+
+	case http.MethodDelete:
+		var ids []int64
+		if v := r.URL.Query().Get("id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				jsonError(w, "invalid notification id", http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+		if err := s.ops.DismissNotifications(ids); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		jsonOK(w, map[string]string{"status": "dismissed"})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiApplyUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Names []string `json:"names"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+
+	go func() {
+		if err := s.ops.ApplyUsers(context.Background(), req.Names, progress); err != nil {
+			slog.Error("apply users failed", "error", err)
+		}
+	}()
+
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
+func (s *Server) apiUnregisterUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Names []string `json:"names"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, progress := s.sse.create()
+
+	go func() {
+		if err := s.ops.UnregisterUsers(context.Background(), req.Names, progress); err != nil {
+			slog.Error("unregister users failed", "error", err)
+		}
+	}()
+
+	jsonOK(w, map[string]string{"session_id": sessionID})
+}
+
+// apiRepair compares the relay, authorized_keys and users/: GET reports
+// the discrepancies, POST {"ids": [...]} fixes them (all without IDs) and
+// returns a progress session.
+func (s *Server) apiRepair(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		report, err := s.ops.CheckConsistency()
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, report)
+
+	case http.MethodPost:
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		sessionID, progress := s.sse.create()
+
+		go func() {
+			if err := s.ops.RepairConsistency(context.Background(), req.IDs, progress); err != nil {
+				slog.Error("repair failed", "error", err)
+			}
+		}()
+
+		jsonOK(w, map[string]string{"session_id": sessionID})
+
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiOnlineUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	online := s.ops.GetOnlineUsers()
+	uuids := make([]string, 0, len(online))
+	for uuid := range online {
+		uuids = append(uuids, uuid)
+	}
+	jsonOK(w, map[string]interface{}{"online": uuids})
+}
+
+func (s *Server) apiUserDownload(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := s.ops.GetUserConfigBundle(name)
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	filename, out, err := ops.ExportBundle(name, data, r.URL.Query().Get("format"))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"-tw-config.zip\"")
-	w.Write(data)
+	contentType := "application/zip"
+	switch {
+	case strings.HasSuffix(filename, ".json"):
+		contentType = "application/json"
+	case strings.HasSuffix(filename, ".ppk"):
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Write(out)
 }
 
 // ── Proxy ────────────────────────────────────────────────────────────────────
@@ -525,12 +1355,19 @@ func (s *Server) apiSetProxy(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Proxy string `json:"proxy"`
+		Mode  string `json:"mode"` // optional: off, manual, auto
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	if req.Mode != "" {
+		if err := s.ops.SetProxyMode(req.Mode); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	if err := s.ops.SetProxy(req.Proxy); err != nil {
 		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -539,6 +1376,111 @@ func (s *Server) apiSetProxy(w http.ResponseWriter, r *http.Request) {
 	jsonOK(w, map[string]string{"status": "ok", "proxy": req.Proxy})
 }
 
+// ── Config validation ────────────────────────────────────────────────────────
+
+// apiValidateConfig checks a config.yaml document without saving it, or the
+// file on disk when the body has no yaml.
+func (s *Server) apiValidateConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		YAML *string `json:"yaml"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var problems []config.Problem
+	if req.YAML != nil {
+		problems = ops.ValidateConfigYAML([]byte(*req.YAML))
+	} else {
+		var err error
+		if problems, err = ops.ValidateConfigFile(); err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if problems == nil {
+		problems = []config.Problem{}
+	}
+	jsonOK(w, map[string]interface{}{"valid": len(problems) == 0, "problems": problems})
+}
+
+// ── Config editing ───────────────────────────────────────────────────────────
+
+// apiConfigDocument returns config.yaml as text, for the editor.
+func (s *Server) apiConfigDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.ops.ConfigDocument()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, doc)
+}
+
+// apiPreviewConfig validates an edited config.yaml and diffs it against the
+// running config, without saving.
+func (s *Server) apiPreviewConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		YAML string `json:"yaml"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	jsonOK(w, s.ops.PreviewConfig([]byte(req.YAML)))
+}
+
+// apiSaveConfig handles PUT /api/config: replace config.yaml, keeping the
+// previous file for rollback. An invalid config is rejected with 422 and
+// the list of problems.
+func (s *Server) apiSaveConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		YAML string `json:"yaml"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ops.SaveConfigYAML([]byte(req.YAML)); err != nil {
+		var invalid *ops.ConfigInvalidError
+		if errors.As(err, &invalid) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "config has problems", "problems": invalid.Problems})
+			return
+		}
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("config saved from dashboard")
+	jsonOK(w, map[string]interface{}{"status": "ok", "config_changed": s.ops.ConfigChanged()})
+}
+
+// apiRollbackConfig restores config.yaml from before the last edit.
+func (s *Server) apiRollbackConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.ops.RollbackConfig(); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("config rolled back from dashboard")
+	jsonOK(w, map[string]interface{}{"status": "ok", "config_changed": s.ops.ConfigChanged()})
+}
+
 // ── Log level ────────────────────────────────────────────────────────────────
 
 func (s *Server) apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
@@ -548,7 +1490,8 @@ func (s *Server) apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		LogLevel string `json:"log_level"`
+		LogLevel     string  `json:"log_level"`
+		XrayLogLevel *string `json:"xray_log_level"` // optional; "" follows log_level
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -559,32 +1502,143 @@ func (s *Server) apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.XrayLogLevel != nil {
+		if err := s.ops.SetXrayLogLevel(*req.XrayLogLevel); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	jsonOK(w, map[string]string{"status": "ok", "log_level": req.LogLevel})
+	jsonOK(w, map[string]string{"status": "ok", "log_level": req.LogLevel, "xray_log_level": s.ops.Config().Xray.LogLevel})
 }
 
 // ── Log streaming ───────────────────────────────────────────────────────────
 
+// maxRelayLogLines caps the history a relay log request may ask for.
+const maxRelayLogLines = 5000
+
+// apiRelayLogs streams a relay log (?source=xray|caddy|cloud-init) as SSE:
+// a {"line": ...} message per line, then an "end" event, or an "error"
+// event when the log can't be read. ?lines= sets the history, ?follow=1
+// keeps streaming new lines, ?filter= keeps only lines containing it.
+func (s *Server) apiRelayLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("source")
+	if name == "" {
+		name = "xray"
+	}
+	lines, _ := strconv.Atoi(q.Get("lines"))
+	if lines > maxRelayLogLines {
+		lines = maxRelayLogLines
+	}
+	opts := ops.RelayLogOptions{Lines: lines, Follow: q.Get("follow") == "1", Filter: q.Get("filter")}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	send := func(event string, v interface{}) {
+		data, _ := json.Marshal(v)
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	err := s.ops.StreamRelayLog(r.Context(), name, opts, func(line string) {
+		send("", map[string]string{"line": line})
+	})
+	if err != nil {
+		send("error", map[string]string{"error": err.Error()})
+		return
+	}
+	send("end", struct{}{})
+}
+
+// statusHeartbeat is how often apiStatusStream writes a comment, so that
+// proxies keep an idle stream open and a dead one is noticed.
+const statusHeartbeat = 25 * time.Second
+
+// apiStatusStream pushes server and client status events (ops.StatusEvent)
+// as they happen, so the dashboard need not wait for its next poll.
+func (s *Server) apiStatusStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch, unsub := s.ops.SubscribeStatus()
+	defer unsub()
+
+	// An initial comment tells the browser the stream is open.
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(statusHeartbeat)
+	defer heartbeat.Stop()
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// apiLogs streams the console: the buffered entries, then new ones as they
+// are logged, keeping those that match the query's level, component and
+// filter (see logging.ParseFilter).
 func (s *Server) apiLogs(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming not supported", http.StatusInternalServerError)
 		return
 	}
+	filter, err := logging.ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
 	// Send buffered history first.
-	for _, entry := range s.logs.snapshot() {
+	for _, entry := range s.logs.Snapshot() {
+		if !filter.Match(entry) {
+			continue
+		}
 		data, _ := json.Marshal(entry)
 		fmt.Fprintf(w, "data: %s\n\n", data)
 	}
 	flusher.Flush()
 
 	// Stream new entries.
-	ch, unsub := s.logs.subscribe()
+	ch, unsub := s.logs.Subscribe()
 	defer unsub()
 
 	ctx := r.Context()
@@ -596,9 +1650,29 @@ func (s *Server) apiLogs(w http.ResponseWriter, r *http.Request) {
 			if !ok {
 				return
 			}
+			if !filter.Match(entry) {
+				continue
+			}
 			data, _ := json.Marshal(entry)
 			fmt.Fprintf(w, "data: %s\n\n", data)
 			flusher.Flush()
 		}
 	}
 }
+
+// apiLogsDownload returns the console's buffered entries that match the
+// query, as in apiLogs, as a text file.
+func (s *Server) apiLogsDownload(w http.ResponseWriter, r *http.Request) {
+	filter, err := logging.ParseFilter(r.URL.Query())
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tw-%s.log"`, time.Now().Format("20060102-150405")))
+	for _, entry := range s.logs.Snapshot() {
+		if filter.Match(entry) {
+			io.WriteString(w, entry.Line())
+		}
+	}
+}
@@ -2,12 +2,16 @@ package dashboard
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/tunnelwhisperer/tw/internal/auth"
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/ops"
 	"gopkg.in/yaml.v3"
@@ -42,13 +46,9 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 	mode := s.ops.Mode()
 
-	// No mode chosen yet — show mode selection.
+	// No mode chosen yet — start the setup wizard.
 	if mode == "" {
-		s.renderPage(w, "setup", struct {
-			pageData
-		}{
-			pageData: pageData{Title: "Setup", Active: "index", Mode: mode},
-		})
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
 		return
 	}
 
@@ -92,8 +92,10 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		ServerStatus  ops.ServerStatus
 		ClientStatus  ops.ClientStatus
 		ConfigChanged bool
+		Setup         ops.SetupState
+		Fingerprint   string
 	}{
-		pageData:      pageData{Title: "Status", Active: "index", Mode: mode},
+		pageData:      pageData{Title: "Status", Active: "index", Mode: mode, Role: requestRole(r)},
 		Config:        cfg,
 		ConfigPath:    config.FilePath(),
 		Relay:         relay,
@@ -103,20 +105,37 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		ServerStatus:  srvStatus,
 		ClientStatus:  cliStatus,
 		ConfigChanged: s.ops.ConfigChanged(),
+		Setup:         s.ops.SetupState(),
+		Fingerprint:   ops.KeyFingerprint(),
 	}
 	s.renderPage(w, "index", data)
 }
 
+// handleSetup serves the first-run setup wizard, which resumes at the
+// first step not done (see ops.SetupState).
+func (s *Server) handleSetup(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, "setup", struct {
+		pageData
+	}{
+		pageData: pageData{Title: "Setup", Active: "index", Mode: s.ops.Mode(), Role: requestRole(r)},
+	})
+}
+
 func (s *Server) handleRelay(w http.ResponseWriter, r *http.Request) {
 	relay := s.ops.GetRelayStatus()
 	mode := s.ops.Mode()
+	dash := s.ops.Config().Dashboard
 
 	data := struct {
 		pageData
-		Relay ops.RelayStatus
+		Relay        ops.RelayStatus
+		HostTerminal bool
+		HostCommands []string
 	}{
-		pageData: pageData{Title: "Relay", Active: "relay", Mode: mode},
-		Relay:    relay,
+		pageData:     pageData{Title: "Relay", Active: "relay", Mode: mode, Role: requestRole(r)},
+		Relay:        relay,
+		HostTerminal: dash.HostTerminal,
+		HostCommands: dash.HostCommands,
 	}
 	s.renderPage(w, "relay", data)
 }
@@ -126,15 +145,23 @@ func (s *Server) handleRelayWizard(w http.ResponseWriter, r *http.Request) {
 	providers := ops.CloudProviders()
 	providersJSON, _ := json.Marshal(providers)
 	mode := s.ops.Mode()
+	// Where to go once the relay is up: back to the setup wizard when it
+	// sent us here.
+	next := "/relay"
+	if r.URL.Query().Get("next") == "setup" {
+		next = "/setup"
+	}
 
 	data := struct {
 		pageData
 		Config        *config.Config
 		ProvidersJSON template.JS
+		Next          string
 	}{
-		pageData:      pageData{Title: "Provision Relay", Active: "relay", Mode: mode},
+		pageData:      pageData{Title: "Provision Relay", Active: "relay", Mode: mode, Role: requestRole(r)},
 		Config:        cfg,
 		ProvidersJSON: template.JS(providersJSON),
+		Next:          next,
 	}
 	s.renderPage(w, "relay_wizard", data)
 }
@@ -152,7 +179,7 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 	online := s.ops.GetOnlineUsers()
 	var inactiveCount int
 	for i := range users {
-		if !users[i].Active {
+		if !users[i].Active && !users[i].Disabled {
 			inactiveCount++
 		}
 		if users[i].UUID != "" && online[users[i].UUID] {
@@ -160,50 +187,169 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Sort: online first, then registered before unregistered, then alphabetical.
-	sort.Slice(users, func(i, j int) bool {
-		if users[i].Online != users[j].Online {
-			return users[i].Online
-		}
-		if users[i].Active != users[j].Active {
-			return users[i].Active
-		}
-		return users[i].Name < users[j].Name
-	})
+	// Invalid parameters fall back to defaults rather than erroring.
+	q := userQueryFromRequest(r)
+	if q.Validate() != nil {
+		q = ops.UserQuery{Search: q.Search}
+	}
+	page := ops.FilterUsers(users, q)
+	templates, _ := s.ops.ListTemplates()
 
 	data := struct {
 		pageData
 		Users         []ops.UserInfo
+		TotalUsers    int
+		Query         ops.UserQuery
+		Page          ops.UserPage
+		Templates     []ops.TemplateInfo
+		SortLinks     map[string]sortLink
+		PageLinks     []pageLink
+		PrevURL       string
+		NextURL       string
 		RelayReady    bool
 		ServerRunning bool
 		InactiveCount int
 	}{
-		pageData:      pageData{Title: "Users", Active: "users", Mode: mode},
-		Users:         users,
+		pageData:      pageData{Title: "Users", Active: "users", Mode: mode, Role: requestRole(r)},
+		Users:         page.Users,
+		TotalUsers:    len(users),
+		Query:         q,
+		Page:          page,
+		Templates:     templates,
+		SortLinks:     sortLinks(r.URL.Query(), q),
 		RelayReady:    relay.Provisioned,
 		ServerRunning: string(srvStatus.State) == "running",
 		InactiveCount: inactiveCount,
 	}
+	data.PageLinks, data.PrevURL, data.NextURL = pageLinks(r.URL.Query(), page)
 	s.renderPage(w, "users", data)
 }
 
+// sortLink is a column header link on the users page.
+type sortLink struct {
+	URL   string
+	Class string // CSS classes for the active sort column
+}
+
+// pageLink is one numbered pagination link on the users page.
+type pageLink struct {
+	N       int
+	URL     string
+	Current bool
+}
+
+// sortLinks builds header links that sort by each column, toggling the
+// order when the column is already active. Sorting resets to page 1.
+func sortLinks(params url.Values, q ops.UserQuery) map[string]sortLink {
+	current := q.Sort
+	if current == "" {
+		current = "status"
+	}
+	links := make(map[string]sortLink, 3)
+	for _, col := range []string{"name", "tunnels", "status"} {
+		v := cloneValues(params)
+		v.Del("page")
+		v.Set("sort", col)
+		v.Del("order")
+		link := sortLink{}
+		if col == current {
+			if q.Order == "desc" {
+				link.Class = "active sort-desc"
+			} else {
+				link.Class = "active sort-asc"
+				v.Set("order", "desc")
+			}
+		}
+		link.URL = "?" + v.Encode()
+		links[col] = link
+	}
+	return links
+}
+
+// pageLinks builds numbered pagination links plus prev/next URLs (empty
+// when there is no previous or next page).
+func pageLinks(params url.Values, page ops.UserPage) (links []pageLink, prev, next string) {
+	if page.Pages <= 1 {
+		return nil, "", ""
+	}
+	pageURL := func(n int) string {
+		v := cloneValues(params)
+		v.Set("page", strconv.Itoa(n))
+		return "?" + v.Encode()
+	}
+	for n := 1; n <= page.Pages; n++ {
+		links = append(links, pageLink{N: n, URL: pageURL(n), Current: n == page.Page})
+	}
+	if page.Page > 1 {
+		prev = pageURL(page.Page - 1)
+	}
+	if page.Page < page.Pages {
+		next = pageURL(page.Page + 1)
+	}
+	return links, prev, next
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, vals := range v {
+		c[k] = append([]string(nil), vals...)
+	}
+	return c
+}
+
+// userQueryFromRequest reads user filtering, sorting, and paging
+// parameters from the URL query string.
+func userQueryFromRequest(r *http.Request) ops.UserQuery {
+	v := r.URL.Query()
+	q := ops.UserQuery{
+		Search:   v.Get("q"),
+		Status:   v.Get("status"),
+		Template: v.Get("template"),
+		Sort:     v.Get("sort"),
+		Order:    v.Get("order"),
+	}
+	q.Page, _ = strconv.Atoi(v.Get("page"))
+	q.PerPage, _ = strconv.Atoi(v.Get("per_page"))
+	return q
+}
+
 func (s *Server) handleUserNew(w http.ResponseWriter, r *http.Request) {
 	mode := s.ops.Mode()
 	relay := s.ops.GetRelayStatus()
 	srvStatus := s.ops.ServerStatus()
 
+	templates, _ := s.ops.ListTemplates()
+
 	data := struct {
 		pageData
 		RelayReady    bool
 		ServerRunning bool
+		Templates     []ops.TemplateInfo
 	}{
-		pageData:      pageData{Title: "Create User", Active: "users", Mode: mode},
+		pageData:      pageData{Title: "Create User", Active: "users", Mode: mode, Role: requestRole(r)},
 		RelayReady:    relay.Provisioned,
 		ServerRunning: string(srvStatus.State) == "running",
+		Templates:     templates,
 	}
 	s.renderPage(w, "user_new", data)
 }
 
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.ops.ListTemplates()
+	if err != nil {
+		slog.Warn("listing templates", "error", err)
+	}
+
+	data := struct {
+		pageData
+		Templates []ops.TemplateInfo
+	}{
+		pageData:  pageData{Title: "Templates", Active: "users", Mode: s.ops.Mode(), Role: requestRole(r)},
+		Templates: templates,
+	}
+	s.renderPage(w, "templates", data)
+}
+
 func (s *Server) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimPrefix(r.URL.Path, "/users/")
 	if name == "" || name == "new" {
@@ -231,17 +377,60 @@ func (s *Server) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 		found.Online = online[found.UUID]
 	}
 
+	presence := s.ops.UserPresence(name)
+	links, _ := s.ops.ListBundleLinks(name)
+
 	mode := s.ops.Mode()
 	data := struct {
 		pageData
-		User ops.UserInfo
+		User        ops.UserInfo
+		Presence    ops.UserPresence
+		SessionTime string
+		Sparkline   string
+		RelayHost   string
+		LinksOn     bool
+		Links       []ops.BundleLink
 	}{
-		pageData: pageData{Title: "User: " + name, Active: "users", Mode: mode},
-		User:     *found,
+		pageData:    pageData{Title: "User: " + name, Active: "users", Mode: mode, Role: requestRole(r)},
+		User:        *found,
+		Presence:    presence,
+		SessionTime: formatSessionTime(presence.SessionSeconds),
+		Sparkline:   sparklinePoints(presence.Availability, sparklineHeight),
+		RelayHost:   s.ops.Config().Xray.RelayHost,
+		LinksOn:     s.ops.BundleLinksEnabled(),
+		Links:       links,
 	}
 	s.renderPage(w, "user_detail", data)
 }
 
+// sparklineHeight is the height of the availability sparkline's viewBox;
+// its width is one unit per value.
+const sparklineHeight = 24
+
+// sparklinePoints renders values between 0 and 1 as the points of an SVG
+// polyline, one step per value.
+func sparklinePoints(values []float64, height int) string {
+	var b strings.Builder
+	for i, v := range values {
+		y := float64(height) * (1 - v)
+		fmt.Fprintf(&b, "%d,%.1f %d,%.1f ", i, y, i+1, y)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// formatSessionTime renders a total connected time, e.g. "3h 12m".
+func formatSessionTime(secs int64) string {
+	switch {
+	case secs < 60:
+		return "less than a minute"
+	case secs < 3600:
+		return fmt.Sprintf("%dm", secs/60)
+	case secs < 86400:
+		return fmt.Sprintf("%dh %dm", secs/3600, secs%3600/60)
+	}
+	return fmt.Sprintf("%dd %dh", secs/86400, secs%86400/3600)
+}
+
 func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	// Read from disk so we always show the actual file contents,
 	// even if it was edited outside the dashboard.
@@ -249,8 +438,15 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		cfg = s.ops.Config()
 	}
-	cfgYAML, _ := yaml.Marshal(cfg)
+	// The editor gets the file as written, comments included; before the
+	// first save there is no file, so show the defaults.
+	doc, _ := s.ops.ConfigDocument()
+	if doc.YAML == "" {
+		cfgYAML, _ := yaml.Marshal(cfg)
+		doc.YAML = string(cfgYAML)
+	}
 	mode := s.ops.Mode()
+	problems, _ := ops.ValidateConfigFile()
 
 	running := string(s.ops.ServerStatus().State) == "running" ||
 		string(s.ops.ClientStatus().State) == "running"
@@ -264,16 +460,44 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		pageData
 		ConfigPath string
 		ConfigYAML string
+		HasBackup  bool
 		LogLevel   string
+		XrayLevel  string
 		Proxy      string
+		ProxyMode  string
+		Problems   []config.Problem
 		Running    bool
+		Bans       []ops.SourceBan
 	}{
-		pageData:   pageData{Title: "Config", Active: "config", Mode: mode},
+		pageData:   pageData{Title: "Config", Active: "config", Mode: mode, Role: requestRole(r)},
 		ConfigPath: config.FilePath(),
-		ConfigYAML: string(cfgYAML),
+		ConfigYAML: doc.YAML,
+		HasBackup:  doc.HasBackup,
 		LogLevel:   logLevel,
+		XrayLevel:  cfg.Xray.LogLevel,
 		Proxy:      cfg.Proxy,
+		ProxyMode:  cfg.ProxyMode,
+		Problems:   problems,
 		Running:    running,
+		Bans:       s.ops.Bans(),
 	}
 	s.renderPage(w, "config", data)
 }
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := auth.ListTokens()
+	if err != nil {
+		slog.Warn("listing API tokens", "error", err)
+	}
+
+	data := struct {
+		pageData
+		Tokens  []auth.Token
+		Enabled bool
+	}{
+		pageData: pageData{Title: "API Tokens", Active: "tokens", Mode: s.ops.Mode(), Role: requestRole(r)},
+		Tokens:   tokens,
+		Enabled:  auth.Enabled(),
+	}
+	s.renderPage(w, "tokens", data)
+}
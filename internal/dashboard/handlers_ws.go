@@ -2,19 +2,40 @@ package dashboard
 
 import (
 	"encoding/json"
-	"io"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"sync"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
-	gossh "golang.org/x/crypto/ssh"
 )
 
 var wsUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// termUpgrader upgrades terminal connections. They run shells, so only
+// pages served by the dashboard itself may open one: another site the
+// operator visits could otherwise reach ws://localhost through the browser.
+var termUpgrader = websocket.Upgrader{
+	CheckOrigin: sameOrigin,
+}
+
+// sameOrigin reports whether a request's Origin names the host it was sent
+// to. Requests without one come from outside a browser and are let through.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
 // wsControl is a JSON control message sent from the browser.
 type wsControl struct {
 	Type string `json:"type"`
@@ -22,119 +43,289 @@ type wsControl struct {
 	Rows int    `json:"rows"`
 }
 
-// apiRelaySSH upgrades to a WebSocket and bridges it to an interactive SSH
-// session on the relay server.
+// apiRelaySSH upgrades to a WebSocket and attaches it to a relay shell,
+// a new one recorded when ?record=1 is set (see serveTerm).
 func (s *Server) apiRelaySSH(w http.ResponseWriter, r *http.Request) {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	record := r.URL.Query().Get("record") == "1"
+	s.serveTerm(w, r, termRelay, "connecting to relay...", func() (*termSession, error) {
+		return s.startRelayTerm(record)
+	})
+}
+
+// apiHostTerminal upgrades to a WebSocket and attaches it to a terminal on
+// this host: a shell, or host command ?command=N (see serveTerm).
+func (s *Server) apiHostTerminal(w http.ResponseWriter, r *http.Request) {
+	command, _ := strconv.Atoi(r.URL.Query().Get("command"))
+	s.serveTerm(w, r, termHost, "starting...", func() (*termSession, error) {
+		return s.startHostTerm(command)
+	})
+}
+
+// serveTerm upgrades to a WebSocket and attaches it to a terminal of
+// target: the one whose reconnect token is ?session=, or a new one from
+// start. Closing the WebSocket detaches it and leaves the shell running;
+// a {"type":"close"} message ends the shell.
+func (s *Server) serveTerm(w http.ResponseWriter, r *http.Request, target, starting string, start func() (*termSession, error)) {
+	token := r.URL.Query().Get("session")
+	conn, err := termUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("websocket upgrade failed", "error", err)
 		return
 	}
 	defer conn.Close()
 
-	// Send a connecting status so the frontend can show feedback.
-	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"status","msg":"connecting to relay..."}`))
+	var ts *termSession
+	if token != "" {
+		if ts = s.terms.get(token); ts == nil || ts.target != target {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","msg":"session expired"}`))
+			return
+		}
+	} else {
+		// Send a connecting status so the frontend can show feedback.
+		status, _ := json.Marshal(map[string]string{"type": "status", "msg": starting})
+		conn.WriteMessage(websocket.TextMessage, status)
+		if ts, err = start(); err != nil {
+			slog.Error("terminal failed", "target", target, "error", err)
+			msg, _ := json.Marshal(map[string]string{"type": "error", "msg": err.Error()})
+			conn.WriteMessage(websocket.TextMessage, msg)
+			return
+		}
+	}
+
+	connected, _ := json.Marshal(map[string]string{
+		"type": "status", "msg": "connected", "session": ts.id, "recording": ts.rec.Name(),
+	})
+	ts.attach(conn, connected)
+	defer ts.detach(conn)
 
-	err = s.ops.RelaySSH(func(client *gossh.Client) error {
-		session, err := client.NewSession()
-		if err != nil {
-			return err
+	// WebSocket → shell stdin + control messages.
+	detached := make(chan struct{})
+	go func() {
+		defer close(detached)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msgType {
+			case websocket.BinaryMessage:
+				if _, err := ts.stdin.Write(data); err != nil {
+					return
+				}
+			case websocket.TextMessage:
+				var ctrl wsControl
+				if json.Unmarshal(data, &ctrl) != nil {
+					continue
+				}
+				switch ctrl.Type {
+				case "resize":
+					ts.resize(ctrl.Cols, ctrl.Rows)
+					ts.rec.Resize(ctrl.Cols, ctrl.Rows)
+				case "close":
+					ts.end()
+				}
+			}
 		}
-		defer session.Close()
-
-		// Request a PTY matching the CLI behavior.
-		if err := session.RequestPty("xterm-256color", 24, 80, gossh.TerminalModes{
-			gossh.ECHO:          1,
-			gossh.TTY_OP_ISPEED: 14400,
-			gossh.TTY_OP_OSPEED: 14400,
-		}); err != nil {
-			return err
+	}()
+
+	// Wait for the shell to end or the WebSocket to go away.
+	select {
+	case <-ts.done:
+		ts.writeText(conn, []byte(`{"type":"status","msg":"closed"}`))
+	case <-detached:
+	}
+}
+
+// ── Status WebSocket ────────────────────────────────────────────────────────
+
+// wsTopics are the message types /api/ws can send. Clients pick theirs
+// with ?topics= or a subscribe message; all but log are on by default.
+var wsTopics = []string{"status", "event", "traffic", "log"}
+
+// Status WebSocket timing: snapshots are sent every wsStatusInterval unless
+// ?interval= says otherwise, and a ping every wsPingInterval finds dead
+// connections.
+const (
+	wsStatusInterval = 5 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// wsMessage is a message sent on /api/ws. Data is the /api/status body for
+// "status", an ops.StatusEvent for "event", the forward or tunnel counters
+// for "traffic", and a logging.Entry for "log".
+type wsMessage struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// wsSubscribe is a message from a /api/ws client replacing its topics.
+type wsSubscribe struct {
+	Type   string   `json:"type"` // "subscribe"
+	Topics []string `json:"topics"`
+}
+
+// wsTopicSet parses a list of topics, rejecting unknown ones.
+func wsTopicSet(list []string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, t := range list {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !slices.Contains(wsTopics, t) {
+			return nil, fmt.Errorf("unknown topic %q (want %s)", t, strings.Join(wsTopics, ", "))
 		}
+		set[t] = true
+	}
+	return set, nil
+}
 
-		stdin, err := session.StdinPipe()
+// apiStatusWS streams typed status, event, traffic and log messages over a
+// WebSocket, for dashboards other than this one. A status and a traffic
+// snapshot are sent on connect, after every event, and every interval.
+func (s *Server) apiStatusWS(w http.ResponseWriter, r *http.Request) {
+	topics := map[string]bool{"status": true, "event": true, "traffic": true}
+	if v := r.URL.Query().Get("topics"); v != "" {
+		set, err := wsTopicSet(strings.Split(v, ","))
 		if err != nil {
-			return err
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		topics = set
+	}
+	interval := wsStatusInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			jsonError(w, "interval must be a number of seconds, at least 1", http.StatusBadRequest)
+			return
+		}
+		interval = time.Duration(n) * time.Second
+	}
 
-		stdout, err := session.StdoutPipe()
-		if err != nil {
-			return err
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	events, unsubEvents := s.ops.SubscribeStatus()
+	defer unsubEvents()
+	logs, unsubLogs := s.logs.Subscribe()
+	defer unsubLogs()
+
+	// Read subscribe messages, and the pongs that keep the read deadline
+	// ahead. The reader ends when the connection does.
+	quit := make(chan struct{})
+	defer close(quit)
+	subs := make(chan wsSubscribe)
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg wsSubscribe
+			if json.Unmarshal(data, &msg) != nil || msg.Type != "subscribe" {
+				continue
+			}
+			select {
+			case subs <- msg:
+			case <-quit:
+				return
+			}
 		}
+	}()
 
-		if err := session.Shell(); err != nil {
-			return err
+	send := func(typ string, data interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(wsMessage{Type: typ, Time: time.Now(), Data: data}) == nil
+	}
+	snapshot := func() bool {
+		if topics["status"] && !send("status", s.statusSnapshot()) {
+			return false
 		}
+		return !topics["traffic"] || send("traffic", s.trafficSnapshot())
+	}
 
-		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"status","msg":"connected"}`))
-
-		var wg sync.WaitGroup
-		done := make(chan struct{})
-
-		// SSH stdout → WebSocket.
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			buf := make([]byte, 4096)
-			for {
-				n, err := stdout.Read(buf)
-				if n > 0 {
-					if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
-						return
-					}
-				}
-				if err != nil {
-					return
-				}
+	if !snapshot() {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-s.closing:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "dashboard shutting down"),
+				time.Now().Add(wsWriteTimeout))
+			return
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+				return
+			}
+		case <-tick.C:
+			if !snapshot() {
+				return
 			}
-		}()
-
-		// WebSocket → SSH stdin + control messages.
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer close(done)
-			for {
-				msgType, data, err := conn.ReadMessage()
-				if err != nil {
+		case msg := <-subs:
+			set, err := wsTopicSet(msg.Topics)
+			if err != nil {
+				if !send("error", map[string]string{"error": err.Error()}) {
 					return
 				}
-				switch msgType {
-				case websocket.BinaryMessage:
-					if _, err := stdin.Write(data); err != nil {
-						return
-					}
-				case websocket.TextMessage:
-					var ctrl wsControl
-					if json.Unmarshal(data, &ctrl) == nil && ctrl.Type == "resize" {
-						session.WindowChange(ctrl.Rows, ctrl.Cols)
-					}
-				}
+				continue
 			}
-		}()
-
-		// Wait for the SSH session to finish or the WebSocket to close.
-		sessionDone := make(chan error, 1)
-		go func() {
-			sessionDone <- session.Wait()
-		}()
-
-		select {
-		case <-done:
-			// WebSocket closed — signal SSH to exit.
-			stdin.Close()
-		case err := <-sessionDone:
-			// SSH session ended.
-			if err != nil && err != io.EOF {
-				slog.Debug("relay SSH session ended", "error", err)
+			topics = set
+			if !snapshot() {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if topics["event"] && !send("event", e) {
+				return
+			}
+			if !snapshot() {
+				return
+			}
+		case entry, ok := <-logs:
+			if !ok {
+				return
+			}
+			if topics["log"] && !send("log", entry) {
+				return
 			}
 		}
+	}
+}
 
-		wg.Wait()
-		return nil
-	})
-
-	if err != nil {
-		slog.Error("relay SSH failed", "error", err)
-		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","msg":"`+err.Error()+`"}`))
+// trafficSnapshot returns the connection and byte counters of the server's
+// reverse forwards and direct-tcpip destinations, or the client's tunnels.
+func (s *Server) trafficSnapshot() map[string]interface{} {
+	mode := s.ops.Mode()
+	resp := map[string]interface{}{"mode": mode}
+	switch mode {
+	case "server":
+		st := s.ops.ServerStatus()
+		resp["forwards"] = st.Forwards
+		resp["direct"] = st.Direct
+	case "client":
+		resp["tunnels"] = s.ops.ClientStatus().Tunnels
 	}
+	return resp
 }
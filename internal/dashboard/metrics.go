@@ -0,0 +1,118 @@
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handleMetrics serves the forwarding counters in the Prometheus text
+// exposition format. Scrapers authenticate with a viewer API token as a
+// bearer token.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	switch s.ops.Mode() {
+	case "server":
+		st := s.ops.ServerStatus()
+
+		m := newMetricWriter(w)
+		m.family("tw_reverse_forward_active_connections", "gauge", "Connections open through a reverse forward.")
+		for _, f := range st.Forwards {
+			m.sample(int64(f.ActiveConns), "remote_port", strconv.Itoa(f.RemotePort), "local_addr", f.LocalAddr, "name", f.Name)
+		}
+		m.family("tw_reverse_forward_bytes_total", "counter", "Bytes forwarded by a reverse forward; in is from the relay to the local address.")
+		for _, f := range st.Forwards {
+			m.sample(f.BytesIn, "remote_port", strconv.Itoa(f.RemotePort), "local_addr", f.LocalAddr, "name", f.Name, "direction", "in")
+			m.sample(f.BytesOut, "remote_port", strconv.Itoa(f.RemotePort), "local_addr", f.LocalAddr, "name", f.Name, "direction", "out")
+		}
+		m.family("tw_reverse_forward_errors_total", "counter", "Connections a reverse forward could not deliver to its local address.")
+		for _, f := range st.Forwards {
+			m.sample(f.Errors, "remote_port", strconv.Itoa(f.RemotePort), "local_addr", f.LocalAddr, "name", f.Name)
+		}
+
+		m.family("tw_ssh_direct_active_connections", "gauge", "Client connections open to a destination through the SSH server.")
+		for _, d := range st.Direct {
+			m.sample(int64(d.ActiveConns), "dest", d.Dest)
+		}
+		m.family("tw_ssh_direct_connections_total", "counter", "Client connections forwarded to a destination through the SSH server.")
+		for _, d := range st.Direct {
+			m.sample(d.Conns, "dest", d.Dest)
+		}
+		m.family("tw_ssh_direct_bytes_total", "counter", "Bytes forwarded to and from a destination; in is from the client to the destination.")
+		for _, d := range st.Direct {
+			m.sample(d.BytesIn, "dest", d.Dest, "direction", "in")
+			m.sample(d.BytesOut, "dest", d.Dest, "direction", "out")
+		}
+		m.family("tw_ssh_direct_errors_total", "counter", "Client connections that could not be forwarded to a destination.")
+		for _, d := range st.Direct {
+			m.sample(d.Errors, "dest", d.Dest)
+		}
+
+	case "client":
+		tunnels := s.ops.ClientStatus().Tunnels
+
+		m := newMetricWriter(w)
+		m.family("tw_tunnel_active_connections", "gauge", "Connections open through a client tunnel.")
+		for _, t := range tunnels {
+			m.sample(int64(t.ActiveConns), "local_port", strconv.Itoa(t.LocalPort), "remote", tunnelRemote(t.RemoteHost, t.RemotePort))
+		}
+		m.family("tw_tunnel_bytes_total", "counter", "Bytes forwarded by a client tunnel; in is from the remote end to the local port.")
+		for _, t := range tunnels {
+			m.sample(t.BytesIn, "local_port", strconv.Itoa(t.LocalPort), "remote", tunnelRemote(t.RemoteHost, t.RemotePort), "direction", "in")
+			m.sample(t.BytesOut, "local_port", strconv.Itoa(t.LocalPort), "remote", tunnelRemote(t.RemoteHost, t.RemotePort), "direction", "out")
+		}
+		m.family("tw_tunnel_errors_total", "counter", "Connections a client tunnel could not open to its remote end.")
+		for _, t := range tunnels {
+			m.sample(t.Errors, "local_port", strconv.Itoa(t.LocalPort), "remote", tunnelRemote(t.RemoteHost, t.RemotePort))
+		}
+	}
+}
+
+func tunnelRemote(host string, port int) string {
+	return host + ":" + strconv.Itoa(port)
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricWriter writes metric families in the Prometheus text format.
+type metricWriter struct {
+	w    io.Writer
+	name string // family being written
+}
+
+func newMetricWriter(w io.Writer) *metricWriter {
+	return &metricWriter{w: w}
+}
+
+// family starts a metric family; the samples that follow belong to it.
+func (m *metricWriter) family(name, typ, help string) {
+	m.name = name
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample of the current family with labels given as
+// name, value pairs. Empty label values are left out.
+func (m *metricWriter) sample(v int64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(m.name)
+	sep := "{"
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i+1] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, `%s%s="%s"`, sep, labels[i], labelEscaper.Replace(labels[i+1]))
+		sep = ","
+	}
+	if sep == "," {
+		b.WriteString("}")
+	}
+	fmt.Fprintf(m.w, "%s %d\n", b.String(), v)
+}
@@ -1,15 +1,17 @@
 package dashboard
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log/slog"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/logging"
 	"github.com/tunnelwhisperer/tw/internal/ops"
 )
 
@@ -20,38 +22,46 @@ type Server struct {
 	mux   *http.ServeMux
 	pages map[string]*template.Template
 	sse   *sseHub
-	logs  *logBuffer
+	logs  *logging.Buffer
+	terms *termRegistry
+
+	httpSrv *http.Server
+	closing chan struct{} // closed by Shutdown, ends open event streams
 }
 
 // NewServer creates a dashboard server.
 func NewServer(addr string, o *ops.Ops) *Server {
 	s := &Server{
-		ops:   o,
-		addr:  addr,
-		mux:   http.NewServeMux(),
-		pages: make(map[string]*template.Template),
-		sse:   newSSEHub(),
-		logs:  newLogBuffer(500),
+		ops:     o,
+		addr:    addr,
+		mux:     http.NewServeMux(),
+		pages:   make(map[string]*template.Template),
+		sse:     newSSEHub(),
+		logs:    logging.Buffered(),
+		terms:   newTermRegistry(),
+		closing: make(chan struct{}),
 	}
-	s.installLogHandler()
+	s.httpSrv = &http.Server{Addr: addr, Handler: s.mux}
+	s.httpSrv.RegisterOnShutdown(func() { close(s.closing) })
+	go s.terms.reap(s.termKeepalive, s.closing)
 	s.parseTemplates()
 	s.routes()
 	return s
 }
 
-// installLogHandler wraps the current slog handler with a tee that also
-// writes to the dashboard's log buffer for real-time console streaming.
-func (s *Server) installLogHandler() {
-	current := slog.Default().Handler()
-	if current == nil {
-		current = slog.NewTextHandler(os.Stderr, nil)
+// brand returns the title shown in the navbar and the browser tab.
+func (s *Server) brand() string {
+	if title := s.ops.Config().Dashboard.Title; title != "" {
+		return title
 	}
-	slog.SetDefault(slog.New(newTeeHandler(current, s.logs)))
+	return "Tunnel Whisperer"
 }
 
 func (s *Server) parseTemplates() {
 	// Parse the base templates (layout + partials) once.
-	base := template.Must(template.New("").ParseFS(templateFS,
+	base := template.Must(template.New("").Funcs(template.FuncMap{
+		"brand": s.brand,
+	}).ParseFS(templateFS,
 		"templates/layout.html",
 		"templates/partials/*.html",
 	))
@@ -73,64 +83,142 @@ func (s *Server) parseTemplates() {
 	}
 }
 
+// routes registers the dashboard's handlers with the role each needs once
+// API tokens exist. Viewers can read status, users, relay details, and
+// logs; config, the relay shell, user bundles (which hold keys), and every
+// change are for admins.
 func (s *Server) routes() {
-	// Static files.
+	// Static files and sign-in.
 	staticSub, _ := fs.Sub(staticFS, "static")
 	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticSub))))
+	s.handle("/login", "", s.handleLogin)
+	s.handle("/logout", "", s.handleLogout)
 
 	// Pages.
-	s.mux.HandleFunc("/", s.handleIndex)
-	s.mux.HandleFunc("/relay", s.handleRelay)
-	s.mux.HandleFunc("/relay/wizard", s.handleRelayWizard)
-	s.mux.HandleFunc("/users", s.handleUsers)
-	s.mux.HandleFunc("/users/new", s.handleUserNew)
-	s.mux.HandleFunc("/users/", s.handleUserDetail) // /users/{name}
-	s.mux.HandleFunc("/config", s.handleConfig)
+	s.handle("/", auth.RoleViewer, s.handleIndex)
+	s.handle("/setup", auth.RoleAdmin, s.handleSetup)
+	s.handle("/relay", auth.RoleViewer, s.handleRelay)
+	s.handle("/relay/wizard", auth.RoleAdmin, s.handleRelayWizard)
+	s.handle("/users", auth.RoleViewer, s.handleUsers)
+	s.handle("/users/new", auth.RoleAdmin, s.handleUserNew)
+	s.handle("/users/templates", auth.RoleAdmin, s.handleTemplates)
+	s.handle("/users/", auth.RoleViewer, s.handleUserDetail) // /users/{name}
+	s.handle("/config", auth.RoleAdmin, s.handleConfig)
+	s.handle("/tokens", auth.RoleAdmin, s.handleTokens)
 
 	// REST API — read-only.
-	s.mux.HandleFunc("/api/status", s.apiStatus)
-	s.mux.HandleFunc("/api/config", s.apiConfig)
-	s.mux.HandleFunc("/api/providers", s.apiProviders)
-	s.mux.HandleFunc("/api/relay", s.apiRelay)
+	s.handle("/api/status", auth.RoleViewer, s.apiStatus)
+	s.handle("/api/status/stream", auth.RoleViewer, s.apiStatusStream)
+	s.handle("/api/ws", auth.RoleViewer, s.apiStatusWS)
+	s.handle("/metrics", auth.RoleViewer, s.handleMetrics)
+	s.handle("/api/config", auth.RoleAdmin, s.apiConfig) // GET; PUT saves config.yaml
+	s.handle("/api/providers", auth.RoleViewer, s.apiProviders)
+	s.handle("/api/relay", auth.RoleViewer, s.apiRelay)
+	s.handle("/api/relay/stats", auth.RoleViewer, s.apiRelayStats) // ?pattern=&sys=1
 
 	// REST API — write.
-	s.mux.HandleFunc("/api/mode", s.apiSetMode)
-	s.mux.HandleFunc("/api/proxy", s.apiSetProxy)
-	s.mux.HandleFunc("/api/log-level", s.apiSetLogLevel)
-	s.mux.HandleFunc("/api/relay/test-creds", s.apiTestCreds)
-	s.mux.HandleFunc("/api/relay/provision", s.apiProvisionRelay)
-	s.mux.HandleFunc("/api/relay/destroy", s.apiDestroyRelay)
-	s.mux.HandleFunc("/api/relay/test", s.apiTestRelay)
-	s.mux.HandleFunc("/api/relay/ssh", s.apiRelaySSH)
-	s.mux.HandleFunc("/api/relay/generate-script", s.apiGenerateScript)
-	s.mux.HandleFunc("/api/relay/save-manual", s.apiSaveManualRelay)
-	s.mux.HandleFunc("/api/server/start", s.apiServerStart)
-	s.mux.HandleFunc("/api/server/stop", s.apiServerStop)
-	s.mux.HandleFunc("/api/server/restart", s.apiServerRestart)
-	s.mux.HandleFunc("/api/client/start", s.apiClientStart)
-	s.mux.HandleFunc("/api/client/stop", s.apiClientStop)
-	s.mux.HandleFunc("/api/client/reconnect", s.apiClientReconnect)
-	s.mux.HandleFunc("/api/client/upload", s.apiClientUpload)
-	s.mux.HandleFunc("/api/users", s.apiUsers)
-	s.mux.HandleFunc("/api/users/apply", s.apiApplyUsers)
-	s.mux.HandleFunc("/api/users/unregister", s.apiUnregisterUsers)
-	s.mux.HandleFunc("/api/users/online", s.apiOnlineUsers)
-	s.mux.HandleFunc("/api/users/", s.apiUserAction) // delete, download
+	s.handle("/api/mode", auth.RoleAdmin, s.apiSetMode)
+	s.handle("/api/setup/state", auth.RoleAdmin, s.apiSetupState) // GET; POST completes a step
+	s.handle("/api/proxy", auth.RoleAdmin, s.apiSetProxy)
+	s.handle("/api/log-level", auth.RoleAdmin, s.apiSetLogLevel)
+	s.handle("/api/config/validate", auth.RoleAdmin, s.apiValidateConfig)
+	s.handle("/api/config/document", auth.RoleAdmin, s.apiConfigDocument)
+	s.handle("/api/config/preview", auth.RoleAdmin, s.apiPreviewConfig)
+	s.handle("/api/config/rollback", auth.RoleAdmin, s.apiRollbackConfig)
+	s.handle("/api/relay/test-creds", auth.RoleAdmin, s.apiTestCreds)
+	s.handle("/api/relay/provision", auth.RoleAdmin, s.apiProvisionRelay)
+	s.handle("/api/relay/destroy", auth.RoleAdmin, s.apiDestroyRelay)
+	s.handle("/api/relay/test", auth.RoleAdmin, s.apiTestRelay)
+	s.handle("/api/relay/ssh", auth.RoleAdmin, s.apiRelaySSH)         // ?record=1 records the session
+	s.handle("/api/host/terminal", auth.RoleAdmin, s.apiHostTerminal) // ?command=N runs a host command
+	s.handle("/api/relay/recordings", auth.RoleAdmin, s.apiRelayRecordings)
+	s.handle("/api/relay/recordings/", auth.RoleAdmin, s.apiRelayRecordingAction) // {name}: GET plays, DELETE
+	s.handle("/api/relay/action", auth.RoleAdmin, s.apiRelayAction)
+	s.handle("/api/relay/logs", auth.RoleViewer, s.apiRelayLogs)
+	s.handle("/api/relay/generate-script", auth.RoleAdmin, s.apiGenerateScript)
+	s.handle("/api/relay/save-manual", auth.RoleAdmin, s.apiSaveManualRelay)
+	s.handle("/api/server/start", auth.RoleAdmin, s.apiServerStart)
+	s.handle("/api/server/stop", auth.RoleAdmin, s.apiServerStop)
+	s.handle("/api/server/restart", auth.RoleAdmin, s.apiServerRestart)
+	s.handle("/api/client/start", auth.RoleAdmin, s.apiClientStart)
+	s.handle("/api/client/stop", auth.RoleAdmin, s.apiClientStop)
+	s.handle("/api/client/reconnect", auth.RoleAdmin, s.apiClientReconnect)
+	s.handle("/api/client/upload", auth.RoleAdmin, s.apiClientUpload)
+	s.handle("/api/client/claim", auth.RoleAdmin, s.apiClientClaim)
+	s.handle("/api/client/test", auth.RoleAdmin, s.apiClientTest)
+	s.handle("/api/client/tunnels/", auth.RoleAdmin, s.apiClientTunnelAction) // {port}/reconnect
+	s.handle("/api/users", auth.RoleViewer, s.apiUsers)                       // GET lists; POST creates
+	s.handle("/api/users/apply", auth.RoleAdmin, s.apiApplyUsers)
+	s.handle("/api/users/import", auth.RoleAdmin, s.apiImportUsers)
+	s.handle("/api/users/unregister", auth.RoleAdmin, s.apiUnregisterUsers)
+	s.handle("/api/users/online", auth.RoleViewer, s.apiOnlineUsers)
+	s.handle("/api/users/repair", auth.RoleViewer, s.apiRepair) // GET checks; POST repairs
+	s.handle("/api/users/", auth.RoleAdmin, s.apiUserAction)    // delete, download
+	s.handle("/api/templates", auth.RoleAdmin, s.apiTemplates)
+	s.handle("/api/templates/", auth.RoleAdmin, s.apiTemplateAction) // delete
+	s.handle("/api/tokens", auth.RoleAdmin, s.apiTokens)
+	s.handle("/api/tokens/", auth.RoleAdmin, s.apiTokenAction)            // delete
+	s.handle("/api/bans", auth.RoleAdmin, s.apiBans)                      // GET; DELETE lifts bans
+	s.handle("/api/bundle-links", auth.RoleAdmin, s.apiBundleLinks)       // GET lists; POST turns on or off
+	s.handle("/api/bundle-links/", auth.RoleAdmin, s.apiBundleLinkAction) // DELETE revokes
+	s.handle("/api/notifications", auth.RoleViewer, s.apiNotifications)   // GET; POST acknowledges; DELETE dismisses
 
 	// SSE.
-	s.mux.HandleFunc("/api/events/", s.apiEvents)
-	s.mux.HandleFunc("/api/logs", s.apiLogs)
+	s.handle("/api/events/", auth.RoleViewer, s.apiEvents)
+	s.handle("/api/logs", auth.RoleViewer, s.apiLogs) // ?level=&component=&filter=
+	s.handle("/api/logs/download", auth.RoleViewer, s.apiLogsDownload)
 }
 
-// Run starts the HTTP server (blocking).
+// Run starts the HTTP server (blocking). With dashboard.tls set it serves
+// HTTPS, requiring client certificates when dashboard.client_ca is set.
 func (s *Server) Run() error {
-	slog.Info("dashboard listening", "addr", s.addr)
-	return http.ListenAndServe(s.addr, s.mux)
+	d := s.ops.Config().Dashboard
+	if !d.TLS {
+		slog.Info("dashboard listening", "addr", s.addr)
+		return s.httpSrv.ListenAndServe()
+	}
+
+	tc, err := tlsConfig(d)
+	if err != nil {
+		return err
+	}
+	var handler http.Handler = s.mux
+	if d.CertFile != "" {
+		handler = withHSTS(s.mux)
+	}
+	s.httpSrv.Handler, s.httpSrv.TLSConfig = handler, tc
+	slog.Info("dashboard listening", "addr", s.addr, "tls", true,
+		"client_certs", d.ClientCA != "", "fingerprint", certFingerprint(tc.Certificates[0]))
+	return s.httpSrv.ListenAndServeTLS("", "")
+}
+
+// Shutdown stops accepting requests and waits for those in flight until
+// ctx is done. Run then returns http.ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpSrv.Shutdown(ctx)
+}
+
+// URL returns the dashboard's address on this machine, for display.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.ops.Config().Dashboard.TLS {
+		scheme = "https"
+	}
+	return scheme + "://localhost" + s.addr
+}
+
+// withHSTS tells browsers to use HTTPS for the dashboard from now on.
+func withHSTS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", hstsHeader)
+		h.ServeHTTP(w, r)
+	})
 }
 
 // pageData is the common data passed to all page templates.
 type pageData struct {
 	Title  string
-	Active string // nav highlight
-	Mode   string // "server", "client", or ""
+	Active string    // nav highlight
+	Mode   string    // "server", "client", or ""
+	Role   auth.Role // role of the signed-in token; "" while access control is off
 }
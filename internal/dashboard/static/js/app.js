@@ -20,6 +20,19 @@ const api = {
     return resp.json();
   },
 
+  async put(url, body) {
+    const resp = await fetch(url, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    });
+    if (!resp.ok) {
+      const text = await resp.text();
+      throw new Error(text || `PUT ${url}: ${resp.status}`);
+    }
+    return resp.json();
+  },
+
   async del(url) {
     const resp = await fetch(url, { method: 'DELETE' });
     if (!resp.ok) {
@@ -65,7 +78,11 @@ function renderProgressEvent(container, event) {
       el.className = 'progress-step';
       el.dataset.step = event.step;
       el.innerHTML = `<span class="step-num">[${event.step}/${event.total}]</span><span class="step-label"></span><span class="step-msg"></span>`;
-      container.appendChild(el);
+      // Steps can start out of order when they run concurrently; keep the
+      // list in step order.
+      const later = [...container.querySelectorAll('[data-step]')]
+        .find(s => parseInt(s.dataset.step) > event.step);
+      container.insertBefore(el, later || null);
     }
     el.className = `progress-step ${event.status}`;
     el.querySelector('.step-label').textContent = event.label;
@@ -82,6 +99,196 @@ function renderProgressEvent(container, event) {
   container.scrollTop = container.scrollHeight;
 }
 
+// ── Theme ───────────────────────────────────────────────────────────────────
+
+// The theme is dark, light, or auto (following the system), kept per
+// browser. The layout applies it before the page paints; these switch it.
+
+const THEME_KEY = 'tw.theme';
+const THEMES = ['auto', 'dark', 'light'];
+const lightQuery = matchMedia('(prefers-color-scheme: light)');
+
+function themePreference() {
+  try { return localStorage.getItem(THEME_KEY) || 'auto'; } catch (_) { return 'auto'; }
+}
+
+function themeApply(pref) {
+  let theme = pref;
+  if (pref === 'auto') theme = lightQuery.matches ? 'light' : 'dark';
+  document.documentElement.dataset.theme = theme;
+  const btn = $('#theme-toggle');
+  if (btn) btn.textContent = pref;
+}
+
+// themeCycle switches to the next theme: auto, dark, light.
+function themeCycle() {
+  const pref = THEMES[(THEMES.indexOf(themePreference()) + 1) % THEMES.length];
+  try { localStorage.setItem(THEME_KEY, pref); } catch (_) {}
+  themeApply(pref);
+}
+
+lightQuery.addEventListener('change', () => themeApply(themePreference()));
+themeApply(themePreference());
+
+// ── Keyboard navigation ─────────────────────────────────────────────────────
+
+// Shortcuts: "g" then a nav link's data-key goes to that page, "/" focuses
+// the page's search or filter, "?" lists the shortcuts, Esc closes the
+// list or leaves a field. Elements clicked with onclick that aren't
+// buttons or links can be focused and pressed with Enter or Space.
+
+let keyPrefix = false;
+
+function shortcutsToggle(show) {
+  const el = $('#shortcuts');
+  if (!el) return;
+  el.classList.toggle('hidden', !show);
+  if (show) el.querySelector('button').focus();
+}
+
+function shortcutsInit() {
+  const list = $('#shortcuts-list');
+  if (!list) return;
+  for (const a of $$('.navbar-links a[data-key]')) {
+    list.insertAdjacentHTML('beforeend',
+      `<span class="kv-label"><kbd>g</kbd><kbd>${a.dataset.key}</kbd></span><span>Go to ${a.textContent}</span>`);
+  }
+  for (const el of $$('[onclick]')) {
+    if (el.matches('a, button, input, select, textarea') || el.hasAttribute('tabindex')) continue;
+    el.tabIndex = 0;
+    el.setAttribute('role', 'button');
+  }
+}
+
+document.addEventListener('keydown', (e) => {
+  if (e.ctrlKey || e.metaKey || e.altKey) return;
+  const t = e.target;
+  const typing = t.matches && t.matches('input, select, textarea, [contenteditable], .xterm *');
+
+  if (e.key === 'Escape') {
+    shortcutsToggle(false);
+    notifyToggle(false);
+    if (typing && !t.closest('.xterm')) t.blur();
+    return;
+  }
+  if (typing) return;
+
+  if ((e.key === 'Enter' || e.key === ' ') && t.getAttribute('role') === 'button' && t.hasAttribute('onclick')) {
+    e.preventDefault();
+    t.click();
+    return;
+  }
+  if (keyPrefix) {
+    keyPrefix = false;
+    const link = $(`.navbar-links a[data-key="${CSS.escape(e.key)}"]`);
+    if (link) {
+      e.preventDefault();
+      location.href = link.href;
+    }
+    return;
+  }
+  if (e.key === 'g') {
+    keyPrefix = true;
+    setTimeout(() => { keyPrefix = false; }, 1500);
+  } else if (e.key === '/') {
+    const search = $('main input[type="search"], main .search-bar input, main input[id$="-filter"], main input[id$="-search"]');
+    if (search) {
+      e.preventDefault();
+      search.focus();
+    }
+  } else if (e.key === '?') {
+    shortcutsToggle($('#shortcuts').classList.contains('hidden'));
+  }
+});
+
+shortcutsInit();
+
+// ── Notifications ───────────────────────────────────────────────────────────
+
+// The bell in the navbar shows the unread count from /api/notifications,
+// polled on every page; the status page also reloads it when the status
+// stream reports a new one. Admins acknowledge and dismiss them.
+
+const NOTIFY_POLL = 30000;
+
+async function notifyLoad() {
+  const bell = $('#notify-bell');
+  if (!bell) return;
+  let data;
+  try {
+    data = await api.get('/api/notifications');
+  } catch (_) {
+    return;
+  }
+  const count = $('#notify-count');
+  count.textContent = data.unread > 99 ? '99+' : data.unread;
+  count.classList.toggle('hidden', data.unread === 0);
+  bell.title = data.unread ? `${data.unread} unread notification${data.unread === 1 ? '' : 's'}` : 'Notifications';
+
+  const list = $('#notify-list');
+  list.textContent = '';
+  $('#notify-empty').classList.toggle('hidden', data.notifications.length > 0);
+  for (const n of data.notifications) {
+    const item = document.createElement('div');
+    item.className = `notify-item ${n.level}${n.read ? '' : ' unread'}`;
+    item.innerHTML =
+      '<div class="notify-head"><span class="notify-title"></span><span class="notify-time"></span></div>' +
+      '<div class="notify-msg"></div>' +
+      '<div class="notify-actions admin-only"></div>';
+    item.querySelector('.notify-title').textContent = n.title + (n.count > 1 ? ` (×${n.count})` : '');
+    item.querySelector('.notify-time').textContent = new Date(n.time).toLocaleString();
+    item.querySelector('.notify-msg').textContent = n.message || '';
+    const actions = item.querySelector('.notify-actions');
+    if (!n.read) {
+      const ack = document.createElement('button');
+      ack.className = 'btn btn-sm';
+      ack.textContent = 'Acknowledge';
+      ack.onclick = () => notifyAck([n.id]);
+      actions.appendChild(ack);
+    }
+    const dismiss = document.createElement('button');
+    dismiss.className = 'btn btn-sm';
+    dismiss.textContent = 'Dismiss';
+    dismiss.onclick = () => notifyDismiss(n.id);
+    actions.appendChild(dismiss);
+    list.appendChild(item);
+  }
+}
+
+function notifyToggle(show) {
+  const panel = $('#notify-panel');
+  if (!panel) return;
+  if (show === undefined) show = panel.classList.contains('hidden');
+  panel.classList.toggle('hidden', !show);
+  if (show) notifyLoad();
+}
+
+// notifyAck acknowledges the notifications with the given IDs, or all of
+// them without any.
+async function notifyAck(ids) {
+  try {
+    await api.post('/api/notifications', { ids: ids || [] });
+  } catch (err) {
+    alert('Acknowledging failed: ' + err.message);
+  }
+  notifyLoad();
+}
+
+// notifyDismiss removes the notification with the given ID, or all of them
+// without one.
+async function notifyDismiss(id) {
+  if (id === undefined && !confirm('Dismiss every notification?')) return;
+  try {
+    await api.del('/api/notifications' + (id === undefined ? '' : '?id=' + id));
+  } catch (err) {
+    alert('Dismissing failed: ' + err.message);
+  }
+  notifyLoad();
+}
+
+notifyLoad();
+setInterval(notifyLoad, NOTIFY_POLL);
+
 // ── Utility ─────────────────────────────────────────────────────────────────
 
 function $(sel, ctx) { return (ctx || document).querySelector(sel); }
@@ -7,11 +7,8 @@ async function saveLogLevel() {
   btn.disabled = true;
 
   try {
-    await api.post('/api/log-level', { log_level: level });
-    const action = typeof serviceMode !== 'undefined' && serviceMode === 'client' ? 'Reconnect' : 'Restart';
-    const restart = typeof serviceRunning !== 'undefined' && serviceRunning
-      ? ' ' + action + ' to apply.' : '';
-    showLogLevelSuccess('Log level saved.' + restart);
+    await api.post('/api/log-level', { log_level: level, xray_log_level: $('#xray-log-level-select').value });
+    showLogLevelSuccess('Log level saved and applied.');
     updateLogLevelBadge(level);
     reloadConfigYAML();
   } catch (err) {
@@ -59,12 +56,13 @@ function showLogLevelSuccess(msg) {
 async function saveProxy() {
   const input = $('#proxy-url');
   const url = input.value.trim();
+  const mode = $('#proxy-mode').value;
 
   const btn = $('#btn-proxy-save');
   btn.disabled = true;
 
   try {
-    await api.post('/api/proxy', { proxy: url });
+    await api.post('/api/proxy', { proxy: url, mode: mode });
     const action = typeof serviceMode !== 'undefined' && serviceMode === 'client' ? 'Reconnect' : 'Restart';
     const restart = typeof serviceRunning !== 'undefined' && serviceRunning
       ? ' ' + action + ' to apply.' : '';
@@ -73,7 +71,7 @@ async function saveProxy() {
     } else {
       showProxySuccess('Proxy cleared.' + restart);
     }
-    updateProxyBadge(url);
+    updateProxyBadge(url, mode);
     reloadConfigYAML();
   } catch (err) {
     showProxyError(err.message);
@@ -90,19 +88,25 @@ async function clearProxy() {
     const restart = typeof serviceRunning !== 'undefined' && serviceRunning
       ? ' ' + action + ' to apply.' : '';
     showProxySuccess('Proxy cleared.' + restart);
-    updateProxyBadge('');
+    updateProxyBadge('', $('#proxy-mode').value);
     reloadConfigYAML();
   } catch (err) {
     showProxyError(err.message);
   }
 }
 
-function updateProxyBadge(url) {
+function updateProxyBadge(url, mode) {
   const badge = $('#proxy-badge');
   if (!badge) return;
-  if (url) {
+  if (mode === 'off') {
+    badge.textContent = 'off';
+    badge.className = 'badge badge-dim';
+  } else if (url) {
     badge.textContent = 'configured';
     badge.className = 'badge badge-green';
+  } else if (mode === 'auto') {
+    badge.textContent = 'auto';
+    badge.className = 'badge badge-green';
   } else {
     badge.textContent = 'none';
     badge.className = 'badge badge-dim';
@@ -129,16 +133,198 @@ function showProxySuccess(msg) {
   }
 }
 
-// Reload the config YAML block without a full page refresh.
+// Reload the config editor and its validation problems without a full page
+// refresh. Unsaved edits in the editor are left alone.
 async function reloadConfigYAML() {
   try {
     const resp = await fetch('/config');
     const html = await resp.text();
     const doc = new DOMParser().parseFromString(html, 'text/html');
-    const fresh = doc.querySelector('pre');
-    const current = $('pre');
-    if (fresh && current) current.textContent = fresh.textContent;
+    const fresh = doc.querySelector('#config-editor');
+    const editor = $('#config-editor');
+    if (fresh && editor && editor.value === savedConfigYAML) {
+      savedConfigYAML = fresh.textContent;
+      editor.value = savedConfigYAML;
+      configEdited();
+    }
+    const freshProblems = doc.querySelector('#config-problems');
+    const problems = $('#config-problems');
+    if (freshProblems && problems) {
+      problems.innerHTML = freshProblems.innerHTML;
+      problems.className = freshProblems.className;
+    }
+    const freshRollback = doc.querySelector('#btn-config-rollback');
+    const rollback = $('#btn-config-rollback');
+    if (freshRollback && rollback) rollback.className = freshRollback.className;
   } catch (_) {
     // ignore — non-critical
   }
 }
+
+// ── config.yaml editor ──────────────────────────────────────────────────────
+
+// The editor text as last loaded or saved, to tell whether it has edits.
+let savedConfigYAML = $('#config-editor') ? $('#config-editor').value : '';
+
+function configEdited() {
+  const dirty = $('#config-editor').value !== savedConfigYAML;
+  $('#btn-config-review').disabled = !dirty;
+  $('#btn-config-discard').disabled = !dirty;
+  // Any edit invalidates the last review.
+  $('#btn-config-save').classList.add('hidden');
+  $('#config-review').classList.add('hidden');
+  $('#config-error').classList.add('hidden');
+}
+
+async function reviewConfig() {
+  const btn = $('#btn-config-review');
+  btn.disabled = true;
+  $('#config-success').classList.add('hidden');
+
+  try {
+    const preview = await api.post('/api/config/preview', { yaml: $('#config-editor').value });
+    $('#config-review-base').textContent = preview.base === 'running'
+      ? 'Changes against the config the running ' + (serviceMode === 'client' ? 'client' : 'server') + ' started with:'
+      : 'Changes against the saved config:';
+    renderDiff($('#config-diff'), preview.diff || '');
+    $('#config-review').classList.remove('hidden');
+
+    configWarnings = preview.warnings || [];
+    const warnings = $('#config-warnings');
+    warnings.textContent = configWarnings.join(' ');
+    warnings.classList.toggle('hidden', configWarnings.length === 0);
+
+    if (preview.valid) {
+      $('#config-error').classList.add('hidden');
+      $('#btn-config-save').classList.remove('hidden');
+    } else {
+      showConfigProblems(preview.problems);
+      $('#btn-config-save').classList.add('hidden');
+    }
+  } catch (err) {
+    showConfigError(err.message);
+  } finally {
+    btn.disabled = false;
+  }
+}
+
+// configWarnings holds the warnings of the last review, confirmed before
+// saving.
+let configWarnings = [];
+
+async function saveConfig() {
+  if (configWarnings.length > 0 &&
+      !confirm(configWarnings.join('\n\n') + '\n\nSave anyway?')) return;
+  const btn = $('#btn-config-save');
+  btn.disabled = true;
+  const yaml = $('#config-editor').value;
+
+  try {
+    await api.put('/api/config', { yaml: yaml });
+    savedConfigYAML = yaml;
+    configEdited();
+    const action = serviceMode === 'client' ? 'Reconnect' : 'Restart';
+    showConfigSuccess('Config saved.' + (serviceRunning ? ' ' + action + ' to apply.' : ''));
+    $('#btn-config-rollback').classList.remove('hidden');
+    reloadConfigYAML();
+  } catch (err) {
+    const body = parseErrorBody(err.message);
+    if (body && body.problems) {
+      showConfigProblems(body.problems);
+    } else {
+      showConfigError(body && body.error ? body.error : err.message);
+    }
+  } finally {
+    btn.disabled = false;
+  }
+}
+
+function discardConfig() {
+  $('#config-editor').value = savedConfigYAML;
+  configEdited();
+}
+
+async function rollbackConfig() {
+  if (!confirm('Restore config.yaml from before the last save? The current file is kept as the backup, so this can be undone the same way.')) return;
+
+  try {
+    await api.post('/api/config/rollback', {});
+    const action = serviceMode === 'client' ? 'Reconnect' : 'Restart';
+    // Take the restored file even if the editor had unsaved edits.
+    $('#config-editor').value = savedConfigYAML;
+    showConfigSuccess('Previous config restored.' + (serviceRunning ? ' ' + action + ' to apply.' : ''));
+    await reloadConfigYAML();
+  } catch (err) {
+    const body = parseErrorBody(err.message);
+    showConfigError(body && body.error ? body.error : err.message);
+  }
+}
+
+// renderDiff shows a unified diff with added and removed lines colored.
+function renderDiff(el, diff) {
+  el.textContent = '';
+  if (!diff) {
+    el.textContent = 'No changes.';
+    return;
+  }
+  for (const line of diff.replace(/\n$/, '').split('\n')) {
+    const span = document.createElement('span');
+    if (line.startsWith('@@')) span.className = 'diff-hunk';
+    else if (line.startsWith('+')) span.className = 'diff-add';
+    else if (line.startsWith('-')) span.className = 'diff-del';
+    span.textContent = line + '\n';
+    el.appendChild(span);
+  }
+}
+
+function parseErrorBody(text) {
+  try {
+    return JSON.parse(text);
+  } catch (_) {
+    return null;
+  }
+}
+
+function showConfigProblems(problems) {
+  const el = $('#config-error');
+  $('#config-success').classList.add('hidden');
+  el.textContent = '';
+  const title = document.createElement('strong');
+  title.textContent = 'Fix these before saving:';
+  const list = document.createElement('ul');
+  for (const p of problems) {
+    const li = document.createElement('li');
+    li.textContent = p.field ? p.field + ': ' + p.message : p.message;
+    list.appendChild(li);
+  }
+  el.append(title, list);
+  el.classList.remove('hidden');
+}
+
+function showConfigError(msg) {
+  const el = $('#config-error');
+  $('#config-success').classList.add('hidden');
+  el.textContent = msg;
+  el.classList.remove('hidden');
+}
+
+function showConfigSuccess(msg) {
+  const el = $('#config-success');
+  $('#config-error').classList.add('hidden');
+  el.textContent = msg;
+  el.classList.remove('hidden');
+}
+
+// ── Ban list ────────────────────────────────────────────────────────────────
+
+async function clearBan(service, key) {
+  const what = key ? `Unban ${key} from ${service}?` : 'Lift every ban?';
+  if (!confirm(what)) return;
+  try {
+    const params = new URLSearchParams({ service, key });
+    await api.del(`/api/bans?${params}`);
+    window.location.reload();
+  } catch (err) {
+    alert('Unban failed: ' + err.message);
+  }
+}
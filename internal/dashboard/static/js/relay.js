@@ -19,14 +19,33 @@ let wizardState = {
   awsSecretKey: '',
   region: '',
   regionName: '',
+  instanceType: '',
+  instanceName: '',
+  acmeDNS: { provider: '', token: '' },
+  cdn: false,
 };
 
+// cdnToggled preselects the Cloudflare DNS challenge for CDN mode: behind
+// the proxy the HTTP challenge depends on Cloudflare's redirect settings.
+function cdnToggled(on) {
+  const sel = $('#acme-dns-provider');
+  if (on && !sel.value) {
+    sel.value = 'cloudflare';
+    $('#acme-dns-token-group').classList.remove('hidden');
+  }
+}
+
 function wizardNext(step) {
   // Validate current step before advancing.
   if (step === 2) {
     const domain = $('#domain').value.trim();
     if (!domain) { alert('Domain is required'); return; }
     wizardState.domain = domain;
+    const acmeProvider = $('#acme-dns-provider').value;
+    const acmeToken = $('#acme-dns-token').value.trim();
+    if (acmeProvider && !acmeToken) { alert('DNS provider API token is required'); return; }
+    wizardState.acmeDNS = { provider: acmeProvider, token: acmeProvider ? acmeToken : '' };
+    wizardState.cdn = $('#relay-cdn').checked;
   }
   if (step === 4) {
     // Populate confirmation.
@@ -38,6 +57,8 @@ function wizardNext(step) {
         <span class="kv-label">Domain</span><span class="kv-value">${wizardState.domain}</span>
         <span class="kv-label">Provider</span><span class="kv-value">Manual Install</span>
         <span class="kv-label">Firewall</span><span class="kv-value">ports 80, 443 only</span>
+        <span class="kv-label">TLS</span><span class="kv-value">${wizardState.acmeDNS.provider ? 'DNS challenge via ' + wizardState.acmeDNS.provider : 'HTTP challenge'}</span>
+        <span class="kv-label">CDN</span><span class="kv-value">${wizardState.cdn ? 'Cloudflare (WebSocket transport)' : 'none'}</span>
         <span class="kv-label">Software</span><span class="kv-value">Caddy + Xray + SSH (localhost-only)</span>
       `;
       btn.textContent = 'Generate Script';
@@ -47,8 +68,10 @@ function wizardNext(step) {
         <span class="kv-label">Domain</span><span class="kv-value">${wizardState.domain}</span>
         <span class="kv-label">Provider</span><span class="kv-value">${wizardState.providerName}</span>
         <span class="kv-label">Region</span><span class="kv-value">${wizardState.regionName || wizardState.region || '(default)'}</span>
-        <span class="kv-label">Instance</span><span class="kv-value">Ubuntu 24.04 (smallest tier)</span>
+        <span class="kv-label">Instance</span><span class="kv-value">${wizardState.instanceName || '(default)'}, Ubuntu 24.04</span>
         <span class="kv-label">Firewall</span><span class="kv-value">ports 80, 443 only</span>
+        <span class="kv-label">TLS</span><span class="kv-value">${wizardState.acmeDNS.provider ? 'DNS challenge via ' + wizardState.acmeDNS.provider : 'HTTP challenge'}</span>
+        <span class="kv-label">CDN</span><span class="kv-value">${wizardState.cdn ? 'Cloudflare (WebSocket transport)' : 'none'}</span>
         <span class="kv-label">Software</span><span class="kv-value">Caddy + Xray + SSH (localhost-only)</span>
       `;
       btn.textContent = 'Provision';
@@ -153,6 +176,26 @@ function buildCredFields(provider) {
       </div>
     `;
   }
+
+  // Instance type selector; ARM types carry a cost or availability note.
+  if (provider.instance_types && provider.instance_types.length > 0) {
+    let opts = provider.instance_types.map(t => `<option value="${t.key}">${t.name}</option>`).join('');
+    fields.innerHTML += `
+      <div class="form-group">
+        <label>Instance type</label>
+        <select id="cred-instance" onchange="instanceTypeChanged()">${opts}</select>
+        <div class="text-dim" id="cred-instance-note"></div>
+      </div>
+    `;
+    wizardState.instanceTypes = provider.instance_types;
+    instanceTypeChanged();
+  }
+}
+
+function instanceTypeChanged() {
+  const sel = $('#cred-instance');
+  const t = (wizardState.instanceTypes || []).find(t => t.key === sel.value);
+  $('#cred-instance-note').textContent = t && t.note ? t.note : '';
 }
 
 // ── Credential test ─────────────────────────────────────────────────────────
@@ -171,6 +214,11 @@ async function testCreds() {
     wizardState.region = regionEl.value;
     wizardState.regionName = regionEl.options[regionEl.selectedIndex].text;
   }
+  const instanceEl = $('#cred-instance');
+  if (instanceEl) {
+    wizardState.instanceType = instanceEl.value;
+    wizardState.instanceName = instanceEl.options[instanceEl.selectedIndex].text;
+  }
 
   try {
     await api.post('/api/relay/test-creds', {
@@ -203,11 +251,15 @@ async function startProvision() {
       token: wizardState.token,
       aws_secret_key: wizardState.awsSecretKey,
       region: wizardState.region,
+      instance_type: wizardState.instanceType,
+      acme_dns: wizardState.acmeDNS,
+      cdn: wizardState.cdn,
     });
 
     const log = $('#provision-progress');
+    const bar = $('#provision-tf-progress');
     connectSSE(resp.session_id, (event) => {
-      renderProgressEvent(log, event);
+      if (!renderTerraformProgress(bar, event)) renderProgressEvent(log, event);
 
       // Show DNS setup card when step 7 completes (relay IP known).
       if (event.step === 7 && event.status === 'completed' && event.data) {
@@ -241,13 +293,35 @@ async function startProvision() {
   }
 }
 
+// Terraform message types only shown on the progress bar, not in the log.
+const TF_BAR_ONLY = ['version', 'planned_change', 'apply_progress', 'refresh_start', 'refresh_complete'];
+
+// renderTerraformProgress moves the bar for a Terraform event and reports
+// whether the event should stay out of the progress log.
+function renderTerraformProgress(bar, event) {
+  const p = event.data;
+  if (!bar || !p || typeof p !== 'object' || !p.phase) return false;
+  bar.classList.remove('hidden');
+  bar.classList.toggle('done', p.phase === 'done');
+  bar.querySelector('.tf-progress-fill').style.width = p.percent + '%';
+  let label = 'Planning...';
+  if (p.phase === 'done') {
+    label = `Done — ${p.total} resource change${p.total === 1 ? '' : 's'}`;
+  } else if (p.phase === 'applying') {
+    label = `${p.done}/${p.total}` + (p.resource ? ` · ${p.action} ${p.resource}` : '');
+  }
+  bar.querySelector('.tf-progress-label').textContent = label;
+  return TF_BAR_ONLY.includes(p.type);
+}
+
 // ── Destroy ─────────────────────────────────────────────────────────────────
 
 function showDestroyPrompt() {
   if (!confirm('Are you sure you want to destroy the relay? This cannot be undone.')) return;
 
-  // AWS needs credentials re-entered; other providers have them in terraform.tfvars.
-  if (typeof relayProvider !== 'undefined' && relayProvider === 'AWS') {
+  // AWS credentials are re-entered unless they were kept in the secrets store
+  // at provisioning; other providers' tokens always are.
+  if (typeof relayProvider !== 'undefined' && relayProvider === 'AWS' && !relayCredentialsStored) {
     const panel = $('#destroy-creds');
     if (panel) panel.classList.remove('hidden');
     const btn = $('#btn-destroy');
@@ -289,8 +363,9 @@ async function destroyRelay() {
 
   try {
     const resp = await api.post('/api/relay/destroy', { creds });
+    const bar = $('#destroy-tf-progress');
     connectSSE(resp.session_id, (event) => {
-      renderProgressEvent(log, event);
+      if (!renderTerraformProgress(bar, event)) renderProgressEvent(log, event);
     }, (err) => {
       relayOpInProgress = false;
       if (err) {
@@ -315,6 +390,8 @@ function showDNSSetupCard(domain, ip) {
   const ipEl = $('#dns-ip');
   if (domainEl) domainEl.textContent = domain;
   if (ipEl) ipEl.textContent = ip;
+  const proxyRow = $('#dns-proxy-row');
+  if (proxyRow) proxyRow.classList.toggle('hidden', !wizardState.cdn);
   card.classList.remove('hidden');
 }
 
@@ -362,6 +439,33 @@ async function testRelay() {
   }
 }
 
+// ── Relay actions ───────────────────────────────────────────────────────────
+
+async function relayAction(action, prompt) {
+  if (!confirm(prompt)) return;
+  const buttons = document.querySelectorAll('.relay-action');
+  const result = $('#relay-action-result');
+  buttons.forEach((b) => { b.disabled = true; });
+  result.innerHTML = '';
+  result.className = 'progress-log mt-16';
+
+  const done = () => { buttons.forEach((b) => { b.disabled = false; }); };
+  try {
+    const { session_id } = await api.post('/api/relay/action', { action });
+    connectSSE(session_id, (ev) => {
+      renderProgressEvent(result, ev);
+    }, (err) => {
+      if (err) {
+        result.innerHTML += `<div class="progress-step failed"><span class="step-label">${err.message}</span></div>`;
+      }
+      done();
+    });
+  } catch (err) {
+    result.innerHTML = `<div class="alert alert-error">${err.message}</div>`;
+    done();
+  }
+}
+
 // ── Manual install ──────────────────────────────────────────────────────────
 
 async function generateManualScript() {
@@ -372,6 +476,8 @@ async function generateManualScript() {
   try {
     const resp = await api.post('/api/relay/generate-script', {
       domain: wizardState.domain,
+      acme_dns: wizardState.acmeDNS,
+      cdn: wizardState.cdn,
     });
 
     $('#provision-progress').classList.add('hidden');
@@ -379,6 +485,7 @@ async function generateManualScript() {
     result.classList.remove('hidden');
     $('#manual-script').textContent = resp.script;
     $('#manual-domain').textContent = wizardState.domain;
+    $('#manual-proxied').classList.toggle('hidden', !wizardState.cdn);
     window._manualScript = resp.script;
   } catch (err) {
     $('#provision-error-msg').textContent = err.message;
@@ -421,34 +528,66 @@ async function saveManualRelay() {
       domain: wizardState.domain,
       ip: ip,
     });
-    window.location.href = '/relay';
+    window.location.href = typeof relayWizardNext === 'string' ? relayWizardNext : '/relay';
   } catch (err) {
     errEl.textContent = err.message;
     errEl.classList.remove('hidden');
   }
 }
 
-// ── SSH Terminal ──────────────────────────────────────────────────────────────
+// ── Terminal ──────────────────────────────────────────────────────────────────
 
-let sshSocket = null;
-let sshTerm = null;
-let sshFit = null;
+// Each tab is a shell of its own, on the relay or, when the host terminal
+// is enabled, on this host. The server keeps a shell running when its
+// WebSocket drops, so a tab reattaches with the shell's reconnect token,
+// and the tokens kept in sessionStorage bring the tabs back after a reload.
+
+const SSH_TABS_KEY = 'tw.relay.ssh.tabs';
+const sshTabs = [];
+let sshActive = null;
+let sshTabCount = 0;
 let sshResizeObserver = null;
 
+// sshTarget returns the terminal target picked: "relay", "host", or
+// "host:N" for host command N.
+function sshTarget() {
+  const select = $('#ssh-target');
+  return select ? select.value : 'relay';
+}
+
+// sshTargetChanged offers recording only for relay shells.
+function sshTargetChanged() {
+  const label = $('#ssh-record-label');
+  if (label) label.classList.toggle('hidden', sshTarget() !== 'relay');
+}
+
 function sshConnect() {
-  const container = $('#ssh-terminal');
-  const badge = $('#ssh-badge');
-  const btnConnect = $('#btn-ssh-connect');
-  const btnDisconnect = $('#btn-ssh-disconnect');
+  const target = sshTarget();
+  sshOpenTab({ target, record: target === 'relay' && $('#ssh-record').checked });
+}
+
+// sshOpenTab adds a tab, attached to the opts.target shell with opts.token
+// or a new one, recorded when opts.record is set.
+function sshOpenTab(opts) {
+  const container = $('#ssh-terminals');
   if (!container) return;
 
-  btnConnect.disabled = true;
-  btnConnect.textContent = 'Connecting...';
-  badge.textContent = 'connecting';
-  badge.className = 'badge badge-yellow';
+  const el = document.createElement('div');
+  el.className = 'ssh-terminal hidden';
+  container.appendChild(el);
+
+  const tabEl = document.createElement('button');
+  tabEl.className = 'btn btn-sm';
+  const host = opts.target.startsWith('host');
+  tabEl.textContent = (host ? 'Host ' : 'Relay ') + (++sshTabCount);
+  if (host) {
+    const option = $(`#ssh-target option[value="${opts.target}"]`);
+    if (option) tabEl.title = option.textContent;
+  }
+  $('#ssh-tabs').appendChild(tabEl);
 
   // Initialize xterm.js terminal.
-  sshTerm = new Terminal({
+  const term = new Terminal({
     cursorBlink: true,
     fontSize: 14,
     fontFamily: '"Fira Code", "Cascadia Code", "JetBrains Mono", monospace',
@@ -459,120 +598,467 @@ function sshConnect() {
       selectionBackground: '#264f78',
     },
   });
-  sshFit = new FitAddon.FitAddon();
-  sshTerm.loadAddon(sshFit);
+  const fit = new FitAddon.FitAddon();
+  term.loadAddon(fit);
+
+  const tab = {
+    el, tabEl, term, fit,
+    target: opts.target,
+    token: opts.token || '',
+    record: !!opts.record,
+    socket: null,
+    status: 'connecting',
+    recording: '',
+    retries: 0,
+    ended: false,
+  };
+  sshTabs.push(tab);
+  tabEl.onclick = () => sshSelect(tab);
 
-  container.classList.remove('hidden');
-  sshTerm.open(container);
-  sshFit.fit();
+  // Terminal input → WebSocket.
+  term.onData((data) => {
+    if (tab.socket && tab.socket.readyState === WebSocket.OPEN) {
+      tab.socket.send(new TextEncoder().encode(data));
+    }
+  });
 
-  // WebSocket URL — same host, ws:// or wss:// matching current protocol.
-  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
-  sshSocket = new WebSocket(`${proto}//${location.host}/api/relay/ssh`);
-  sshSocket.binaryType = 'arraybuffer';
+  sshSelect(tab);
+  term.open(el);
+  fit.fit();
+  sshAttach(tab);
 
-  sshSocket.onopen = () => {
-    // Send initial size.
-    sshSocket.send(JSON.stringify({
-      type: 'resize',
-      cols: sshTerm.cols,
-      rows: sshTerm.rows,
-    }));
-  };
+  // Handle resize.
+  if (!sshResizeObserver) {
+    sshResizeObserver = new ResizeObserver(() => {
+      if (sshActive) {
+        sshActive.fit.fit();
+        sshSendSize(sshActive);
+      }
+    });
+    sshResizeObserver.observe(container);
+  }
+}
 
-  sshSocket.onmessage = (e) => {
-    if (typeof e.data === 'string') {
-      // Control message from server.
-      try {
-        const msg = JSON.parse(e.data);
-        if (msg.type === 'status' && msg.msg === 'connected') {
-          badge.textContent = 'connected';
-          badge.className = 'badge badge-green';
-          btnConnect.classList.add('hidden');
-          btnDisconnect.classList.remove('hidden');
-          sshTerm.focus();
-        } else if (msg.type === 'error') {
-          sshTerm.writeln('\r\n\x1b[31mError: ' + msg.msg + '\x1b[0m');
-          sshCleanup();
-        }
-      } catch (_) {}
-    } else {
+// sshAttach opens the tab's WebSocket, reattaching to its shell when it
+// has one, and reconnects with backoff when the connection drops.
+function sshAttach(tab) {
+  // WebSocket URL — same host, ws:// or wss:// matching current protocol.
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const [target, command] = tab.target.split(':');
+  const path = target === 'host' ? '/api/host/terminal' : '/api/relay/ssh';
+  let query = '';
+  if (tab.token) query = '?session=' + encodeURIComponent(tab.token);
+  else if (tab.record) query = '?record=1';
+  else if (command !== undefined) query = '?command=' + command;
+  const socket = new WebSocket(`${proto}//${location.host}${path}${query}`);
+  socket.binaryType = 'arraybuffer';
+  tab.socket = socket;
+
+  socket.onopen = () => sshSendSize(tab);
+
+  socket.onmessage = (e) => {
+    if (typeof e.data !== 'string') {
       // Binary terminal data.
-      sshTerm.write(new Uint8Array(e.data));
+      tab.term.write(new Uint8Array(e.data));
+      return;
     }
+    // Control message from server.
+    let msg;
+    try { msg = JSON.parse(e.data); } catch (_) { return; }
+    if (msg.type === 'status' && msg.msg === 'connected') {
+      // The server replays the shell's recent output after this.
+      if (tab.token) tab.term.reset();
+      tab.token = msg.session;
+      tab.recording = msg.recording || '';
+      tab.status = 'connected';
+      tab.retries = 0;
+      sshSave();
+      if (tab === sshActive) tab.term.focus();
+    } else if (msg.type === 'status' && msg.msg === 'detached') {
+      tab.term.writeln('\r\n\x1b[2m--- attached in another window ---\x1b[0m');
+      sshEnd(tab);
+    } else if (msg.type === 'status' && msg.msg === 'closed') {
+      tab.term.writeln('\r\n\x1b[2m--- session closed ---\x1b[0m');
+      sshEnd(tab);
+    } else if (msg.type === 'error') {
+      tab.term.writeln('\r\n\x1b[31mError: ' + msg.msg + '\x1b[0m');
+      sshEnd(tab);
+    }
+    sshUpdate();
   };
 
-  sshSocket.onclose = () => {
-    sshTerm.writeln('\r\n\x1b[2m--- session closed ---\x1b[0m');
-    sshCleanup();
-  };
-
-  sshSocket.onerror = () => {
-    sshCleanup();
+  socket.onclose = () => {
+    if (tab.socket !== socket || tab.ended) return;
+    tab.socket = null;
+    if (!tab.token) {
+      tab.term.writeln('\r\n\x1b[2m--- session closed ---\x1b[0m');
+      sshEnd(tab);
+      sshUpdate();
+      return;
+    }
+    // The shell is still running on the server: reattach.
+    tab.status = 'reconnecting';
+    sshUpdate();
+    const delay = Math.min(1000 * 2 ** tab.retries, 15000);
+    tab.retries++;
+    setTimeout(() => { if (!tab.ended) sshAttach(tab); }, delay);
   };
+}
 
-  // Terminal input → WebSocket.
-  sshTerm.onData((data) => {
-    if (sshSocket && sshSocket.readyState === WebSocket.OPEN) {
-      const encoder = new TextEncoder();
-      sshSocket.send(encoder.encode(data));
-    }
-  });
+// sshSendSize tells the tab's shell the terminal size.
+function sshSendSize(tab) {
+  if (tab.socket && tab.socket.readyState === WebSocket.OPEN) {
+    tab.socket.send(JSON.stringify({
+      type: 'resize',
+      cols: tab.term.cols,
+      rows: tab.term.rows,
+    }));
+  }
+}
 
-  // Handle resize.
-  sshResizeObserver = new ResizeObserver(() => {
-    if (sshFit) {
-      sshFit.fit();
-      if (sshSocket && sshSocket.readyState === WebSocket.OPEN) {
-        sshSocket.send(JSON.stringify({
-          type: 'resize',
-          cols: sshTerm.cols,
-          rows: sshTerm.rows,
-        }));
-      }
-    }
-  });
-  sshResizeObserver.observe(container);
+// sshEnd marks the tab's shell as gone, keeping the tab so its last
+// output stays readable until it is closed.
+function sshEnd(tab) {
+  tab.ended = true;
+  tab.status = 'closed';
+  tab.token = '';
+  if (tab.socket) {
+    tab.socket.close();
+    tab.socket = null;
+  }
+  sshSave();
+  if (tab.recording) recordingsLoad();
 }
 
+// sshDisconnect ends the shell in the current tab and closes the tab.
 function sshDisconnect() {
-  if (sshSocket) {
-    sshSocket.close();
-    sshSocket = null;
+  const tab = sshActive;
+  if (!tab) return;
+  if (tab.socket && tab.socket.readyState === WebSocket.OPEN && !tab.ended) {
+    tab.socket.send(JSON.stringify({ type: 'close' }));
+  }
+  const recorded = tab.recording && !tab.ended;
+  tab.ended = true;
+  if (tab.socket) tab.socket.close();
+  tab.term.dispose();
+  tab.el.remove();
+  tab.tabEl.remove();
+  sshTabs.splice(sshTabs.indexOf(tab), 1);
+  sshSave();
+  if (recorded) setTimeout(recordingsLoad, 500);
+  sshSelect(sshTabs[sshTabs.length - 1] || null);
+}
+
+// sshSelect shows tab's terminal and hides the others.
+function sshSelect(tab) {
+  sshActive = tab;
+  for (const t of sshTabs) {
+    t.el.classList.toggle('hidden', t !== tab);
+    t.tabEl.classList.toggle('active', t === tab);
   }
+  if (tab && tab.term.element) {
+    tab.fit.fit();
+    sshSendSize(tab);
+    tab.term.focus();
+  }
+  if (!tab && sshResizeObserver) {
+    sshResizeObserver.disconnect();
+    sshResizeObserver = null;
+  }
+  sshUpdate();
 }
 
-function sshCleanup() {
+// sshUpdate shows the current tab's state in the badge and buttons.
+function sshUpdate() {
   const badge = $('#ssh-badge');
   const btnConnect = $('#btn-ssh-connect');
   const btnDisconnect = $('#btn-ssh-disconnect');
+  if (!badge) return;
+
+  const tab = sshActive;
+  $('#ssh-tabs').classList.toggle('hidden', sshTabs.length === 0);
+  btnConnect.textContent = sshTabs.length ? 'New Tab' : 'Connect';
+  btnDisconnect.classList.toggle('hidden', !tab);
+  btnDisconnect.textContent = tab && tab.ended ? 'Close Tab' : 'Disconnect';
 
-  if (badge) {
+  if (!tab) {
     badge.textContent = 'disconnected';
     badge.className = 'badge badge-dim';
+  } else if (tab.status === 'connected') {
+    badge.textContent = tab.recording ? 'connected · recording' : 'connected';
+    badge.className = tab.recording ? 'badge badge-red' : 'badge badge-green';
+  } else if (tab.status === 'closed') {
+    badge.textContent = 'disconnected';
+    badge.className = 'badge badge-dim';
+  } else {
+    badge.textContent = tab.status;
+    badge.className = 'badge badge-yellow';
   }
-  if (btnConnect) {
-    btnConnect.classList.remove('hidden');
-    btnConnect.disabled = false;
-    btnConnect.textContent = 'Connect';
+}
+
+// sshSave keeps the targets and reconnect tokens of the open shells for
+// this browser tab, so they come back after a reload.
+function sshSave() {
+  const open = sshTabs.filter((t) => t.token && !t.ended).map((t) => ({ target: t.target, token: t.token }));
+  try { sessionStorage.setItem(SSH_TABS_KEY, JSON.stringify(open)); } catch (_) {}
+}
+
+// sshRestore reattaches to the shells open before the page was reloaded.
+function sshRestore() {
+  if (!$('#ssh-terminals') || document.body.classList.contains('read-only')) return;
+  sshTargetChanged();
+  let open = [];
+  try { open = JSON.parse(sessionStorage.getItem(SSH_TABS_KEY) || '[]'); } catch (_) {}
+  for (const { target, token } of open) sshOpenTab({ target, token });
+}
+
+// ── Session recordings ──────────────────────────────────────────────────────
+
+// recordingsLoad lists the recorded relay shell sessions in the
+// recordings card.
+async function recordingsLoad() {
+  const body = $('#recordings-body');
+  if (!body || document.body.classList.contains('read-only')) return;
+  try {
+    const { recordings } = await api.get('/api/relay/recordings');
+    body.innerHTML = '';
+    if (recordings.length === 0) {
+      body.innerHTML = '<tr><td colspan="4" class="text-dim">No recorded sessions.</td></tr>';
+      return;
+    }
+    for (const r of recordings) {
+      const row = document.createElement('tr');
+      const cell = (text) => {
+        const td = document.createElement('td');
+        td.textContent = text;
+        row.appendChild(td);
+        return td;
+      };
+      cell(new Date(r.started).toLocaleString());
+      cell(r.title || r.name).title = r.name;
+      cell(recordingSize(r.size));
+      const actions = cell('');
+      actions.className = 'flex gap-8';
+      const url = '/api/relay/recordings/' + encodeURIComponent(r.name);
+      actions.innerHTML =
+        `<button class="btn btn-sm">Play</button>` +
+        `<a class="btn btn-sm" href="${url}?download=1">Download</a>` +
+        `<button class="btn btn-sm btn-danger">Delete</button>`;
+      const [play, del] = actions.querySelectorAll('button');
+      play.onclick = () => recordingPlay(r.name, r.title || r.name);
+      del.onclick = () => recordingDelete(r.name);
+      body.appendChild(row);
+    }
+  } catch (err) {
+    body.innerHTML = '<tr><td colspan="4" class="text-dim"></td></tr>';
+    body.querySelector('td').textContent = 'Could not list recordings: ' + err.message;
   }
-  if (btnDisconnect) {
-    btnDisconnect.classList.add('hidden');
+}
+
+function recordingSize(n) {
+  if (n < 1024) return n + ' B';
+  if (n < 1024 * 1024) return (n / 1024).toFixed(1) + ' KB';
+  return (n / 1024 / 1024).toFixed(1) + ' MB';
+}
+
+async function recordingDelete(name) {
+  if (!confirm(`Delete the recording ${name}?`)) return;
+  try {
+    await api.del('/api/relay/recordings/' + encodeURIComponent(name));
+    recordingsLoad();
+  } catch (err) {
+    alert('Delete failed: ' + err.message);
   }
+}
 
-  if (sshResizeObserver) {
-    sshResizeObserver.disconnect();
-    sshResizeObserver = null;
+// The player replays a recording's output events into a terminal at their
+// recorded times, scaled by the speed. Pauses longer than playerMaxIdle
+// seconds are shortened so idle stretches don't stall the replay.
+const playerMaxIdle = 2;
+let player = null;
+
+async function recordingPlay(name, title) {
+  playerClose();
+  let text;
+  try {
+    const resp = await fetch('/api/relay/recordings/' + encodeURIComponent(name));
+    if (!resp.ok) throw new Error(await resp.text());
+    text = await resp.text();
+  } catch (err) {
+    alert('Could not load the recording: ' + err.message);
+    return;
+  }
+  const lines = text.split('\n').filter((l) => l.trim() !== '');
+  let header;
+  try {
+    header = JSON.parse(lines[0]);
+  } catch (_) {
+    alert('Not an asciicast recording.');
+    return;
   }
 
-  sshSocket = null;
-  // Keep terminal visible so user can see last output.
-  // It will be disposed on next connect.
-  if (sshTerm) {
-    sshTerm.dispose();
-    sshTerm = null;
-    sshFit = null;
-    const container = $('#ssh-terminal');
-    if (container) container.classList.add('hidden');
+  // Rebase event times, capping idle gaps.
+  const events = [];
+  let last = 0;
+  let at = 0;
+  for (const line of lines.slice(1)) {
+    let ev;
+    try { ev = JSON.parse(line); } catch (_) { continue; }
+    at += Math.min(ev[0] - last, playerMaxIdle);
+    last = ev[0];
+    events.push({ at, code: ev[1], data: ev[2] });
   }
+
+  const container = $('#player-terminal');
+  container.innerHTML = '';
+  $('#recording-player').classList.remove('hidden');
+  $('#player-title').textContent = title;
+  const term = new Terminal({
+    cols: header.width || 80,
+    rows: header.height || 24,
+    fontSize: 14,
+    fontFamily: '"Fira Code", "Cascadia Code", "JetBrains Mono", monospace',
+    theme: { background: '#0d1117', foreground: '#c9d1d9', cursor: '#58a6ff' },
+    disableStdin: true,
+  });
+  term.open(container);
+
+  player = {
+    term, events, next: 0, clock: 0, timer: null, paused: false,
+    speed: parseFloat($('#player-speed').value) || 1,
+    total: events.length ? events[events.length - 1].at : 0,
+  };
+  $('#btn-player-pause').textContent = 'Pause';
+  playerStep();
+}
+
+// playerStep plays the events that are due and schedules the next one.
+function playerStep() {
+  const p = player;
+  if (!p || p.paused) return;
+  while (p.next < p.events.length && p.events[p.next].at <= p.clock) {
+    const ev = p.events[p.next++];
+    if (ev.code === 'o') {
+      p.term.write(ev.data);
+    } else if (ev.code === 'r') {
+      const [cols, rows] = ev.data.split('x').map(Number);
+      if (cols && rows) p.term.resize(cols, rows);
+    }
+  }
+  $('#player-time').textContent = `${Math.floor(p.clock)}s / ${Math.ceil(p.total)}s`;
+  if (p.next >= p.events.length) {
+    $('#btn-player-pause').textContent = 'Replay';
+    p.paused = true;
+    p.next = p.events.length;
+    return;
+  }
+  const wait = p.events[p.next].at - p.clock;
+  p.timer = setTimeout(() => {
+    p.clock += wait;
+    playerStep();
+  }, (wait * 1000) / p.speed);
+}
+
+function playerTogglePause() {
+  const p = player;
+  if (!p) return;
+  if (p.next >= p.events.length) {
+    // Finished: play again from the start.
+    p.term.reset();
+    p.next = 0;
+    p.clock = 0;
+  } else if (!p.paused) {
+    clearTimeout(p.timer);
+    p.paused = true;
+    $('#btn-player-pause').textContent = 'Resume';
+    return;
+  }
+  p.paused = false;
+  $('#btn-player-pause').textContent = 'Pause';
+  playerStep();
+}
+
+function playerSetSpeed(v) {
+  if (player) player.speed = parseFloat(v) || 1;
+}
+
+function playerClose() {
+  if (player) {
+    clearTimeout(player.timer);
+    player.term.dispose();
+    player = null;
+  }
+  const el = $('#recording-player');
+  if (el) el.classList.add('hidden');
+}
+
+recordingsLoad();
+sshRestore();
+
+// ── Relay logs ──────────────────────────────────────────────────────────────
+
+let relayLogSource = null;
+
+// relayLogsShow streams the selected relay log from /api/relay/logs into
+// the log card, replacing what was shown before.
+function relayLogsShow() {
+  relayLogsStop();
+  const el = $('#relay-log');
+  el.innerHTML = '';
+  el.classList.remove('hidden');
+
+  const follow = $('#logs-follow').checked;
+  const params = new URLSearchParams({
+    source: $('#logs-source').value,
+    filter: $('#logs-filter').value.trim(),
+    lines: '200',
+    follow: follow ? '1' : '0',
+  });
+  relayLogSource = new EventSource('/api/relay/logs?' + params);
+  relayLogsState(follow ? 'following' : 'loading', 'badge-yellow');
+
+  relayLogSource.onmessage = (e) => {
+    relayLogsAppend(el, JSON.parse(e.data).line);
+  };
+  relayLogSource.addEventListener('end', () => {
+    relayLogsStop();
+  });
+  relayLogSource.addEventListener('error', (e) => {
+    // Named "error" events carry a message; connection errors don't.
+    if (e.data) relayLogsAppend(el, JSON.parse(e.data).error, 'ERROR');
+    relayLogsStop();
+  });
+}
+
+function relayLogsAppend(el, text, level) {
+  const line = document.createElement('div');
+  line.className = 'log-line';
+  if (level) {
+    const lvl = document.createElement('span');
+    lvl.className = 'log-level log-level-' + level;
+    lvl.textContent = level;
+    line.appendChild(lvl);
+  }
+  const msg = document.createElement('span');
+  msg.className = 'log-msg';
+  msg.textContent = text;
+  line.appendChild(msg);
+  el.appendChild(line);
+  el.scrollTop = el.scrollHeight;
+}
+
+function relayLogsStop() {
+  if (relayLogSource) {
+    relayLogSource.close();
+    relayLogSource = null;
+  }
+  relayLogsState('idle', 'badge-dim');
+}
+
+function relayLogsState(text, cls) {
+  const badge = $('#logs-badge');
+  badge.textContent = text;
+  badge.className = 'badge ' + cls;
+  $('#btn-logs-show').classList.toggle('hidden', text !== 'idle');
+  $('#btn-logs-stop').classList.toggle('hidden', text === 'idle');
 }
@@ -0,0 +1,167 @@
+// ── First-run setup wizard ──────────────────────────────────────────────────
+
+// The server works out which steps are done (GET /api/setup/state), so the
+// wizard always opens at the first one left, whichever browser it is in.
+// Steps that are done can be opened again from the step bar.
+
+let setupState = null;
+
+async function setupLoad(show) {
+  try {
+    setupState = await api.get('/api/setup/state');
+  } catch (err) {
+    setupError(err.message);
+    return;
+  }
+  setupRender(show || setupState.current || 'done');
+}
+
+function setupRender(show) {
+  const bar = $('#setup-steps');
+  bar.innerHTML = '';
+  for (const step of setupState.steps) {
+    const el = document.createElement('div');
+    el.className = 'wizard-step' + (step.done ? ' done' : '') + (step.name === show ? ' active' : '');
+    el.textContent = step.title;
+    if (step.detail) el.title = step.detail;
+    if (step.done || step.name === setupState.current) {
+      el.tabIndex = 0;
+      el.setAttribute('role', 'button');
+      el.onclick = () => setupRender(step.name);
+      el.onkeydown = (e) => { if (e.key === 'Enter') setupRender(step.name); };
+      el.style.cursor = 'pointer';
+    }
+    bar.appendChild(el);
+  }
+  // Before a mode is chosen, the rest of the steps aren't known yet.
+  bar.classList.toggle('hidden', !setupState.mode);
+
+  for (const panel of $$('.wizard-panel')) {
+    panel.classList.toggle('active', panel.dataset.panel === show);
+  }
+  $('#setup-verify-text').textContent = setupState.mode === 'client'
+    ? 'Checks each layer in turn: config, DNS, TLS to the relay, the tunnel, SSH to the server, and each mapped port.'
+    : 'Checks that the relay resolves, answers HTTPS, and lets this server in through Xray and SSH.';
+  $('#setup-skip').classList.toggle('hidden', !setupState.mode || setupState.complete);
+  $('#setup-progress').classList.add('hidden');
+  $('#setup-error').classList.add('hidden');
+}
+
+function setupError(msg) {
+  const el = $('#setup-error');
+  el.textContent = msg;
+  el.classList.remove('hidden');
+}
+
+async function selectMode(mode) {
+  try {
+    await api.post('/api/mode', { mode });
+    setupLoad();
+  } catch (err) {
+    setupError(err.message);
+  }
+}
+
+// setupComplete completes a step the server does on its own (keys).
+async function setupComplete(step, btn) {
+  btn.disabled = true;
+  try {
+    setupState = await api.post('/api/setup/state', { step });
+    setupRender(setupState.current || 'done');
+  } catch (err) {
+    setupError(err.message);
+  }
+  btn.disabled = false;
+}
+
+// setupProgress POSTs to an endpoint answering with an SSE session and
+// shows its progress, moving on (after onDone, if given) when it succeeds.
+async function setupProgress(url, btn, body, onDone) {
+  const log = $('#setup-progress');
+  log.innerHTML = '';
+  log.classList.remove('hidden');
+  $('#setup-error').classList.add('hidden');
+  btn.disabled = true;
+  try {
+    const resp = await api.post(url, body || {});
+    connectSSE(resp.session_id, (event) => renderProgressEvent(log, event), async (err) => {
+      btn.disabled = false;
+      if (err) {
+        setupError(err.message);
+        return;
+      }
+      if (onDone) onDone();
+      const before = setupState.current;
+      await setupLoad();
+      // Keep the log in view when the step didn't get done.
+      if (setupState.current === before) log.classList.remove('hidden');
+    });
+  } catch (err) {
+    btn.disabled = false;
+    setupError(err.message);
+  }
+}
+
+async function setupCreateUser(btn) {
+  const name = $('#setup-user-name').value.trim();
+  const clientPort = parseInt($('#setup-client-port').value, 10);
+  const serverPort = parseInt($('#setup-server-port').value, 10);
+  if (!name || !clientPort || !serverPort) {
+    setupError('Enter a username and both ports.');
+    return;
+  }
+  setupProgress('/api/users', btn, {
+    name,
+    mappings: [{ client_port: clientPort, server_port: serverPort }],
+  }, () => {
+    $('#setup-user-download').href = `/api/users/${encodeURIComponent(name)}/download`;
+    $('#setup-user-created').classList.remove('hidden');
+  });
+}
+
+async function setupUpload(btn) {
+  const input = $('#setup-bundle');
+  if (input.files.length === 0) {
+    setupError('Choose the bundle .zip first.');
+    return;
+  }
+  const fd = new FormData();
+  fd.append('config', input.files[0]);
+  btn.disabled = true;
+  try {
+    const resp = await fetch('/api/client/upload', { method: 'POST', body: fd });
+    if (!resp.ok) {
+      const data = await resp.json();
+      throw new Error(data.error || 'Upload failed');
+    }
+    setupLoad();
+  } catch (err) {
+    setupError(err.message);
+  }
+  btn.disabled = false;
+}
+
+function setupClaim(btn) {
+  const code = $('#setup-claim-code').value.trim();
+  if (!code) {
+    setupError('Paste the claim code first.');
+    return;
+  }
+  setupProgress('/api/client/claim', btn, { code });
+}
+
+function setupVerify(btn) {
+  setupProgress('/api/setup/state', btn, { step: 'verify' });
+}
+
+// setupFinish closes the wizard; the status page stops offering it.
+async function setupFinish() {
+  try {
+    await api.post('/api/setup/state', { step: 'finish' });
+    window.location.href = '/';
+  } catch (err) {
+    setupError(err.message);
+  }
+}
+
+setupLoad();
@@ -63,6 +63,18 @@ async function serverRestart() {
   }
 }
 
+// ── Setup ────────────────────────────────────────────────────────────────────
+
+// setupDismiss closes the setup wizard, so this page stops offering it.
+async function setupDismiss() {
+  try {
+    await api.post('/api/setup/state', { step: 'finish' });
+    $('#setup-banner').remove();
+  } catch (err) {
+    alert('Error: ' + err.message);
+  }
+}
+
 // ── Client start/stop ────────────────────────────────────────────────────────
 
 async function clientStart() {
@@ -128,6 +140,203 @@ async function clientReconnect() {
   }
 }
 
+async function testConnection() {
+  const btn = $('#btn-test-connection');
+  const result = $('#connection-test-result');
+  if (!btn || !result) return;
+
+  btn.disabled = true;
+  btn.textContent = 'Testing...';
+  result.classList.remove('hidden');
+  result.innerHTML = '';
+
+  try {
+    const { session_id } = await api.post('/api/client/test', {});
+    connectSSE(session_id, (ev) => renderProgressEvent(result, ev), (err) => {
+      if (err) {
+        result.innerHTML += `<div class="progress-step failed"><span class="step-label">${err.message}</span></div>`;
+      }
+      btn.disabled = false;
+      btn.textContent = 'Test Connection';
+    });
+  } catch (e) {
+    result.innerHTML = `<div class="alert alert-error">${e.message}</div>`;
+    btn.disabled = false;
+    btn.textContent = 'Test Connection';
+  }
+}
+
+async function reconnectTunnel(port, btn) {
+  btn.disabled = true;
+  try {
+    await api.post(`/api/client/tunnels/${port}/reconnect`, {});
+  } catch (e) {
+    alert(e.message);
+  }
+  btn.disabled = false;
+}
+
+// setTunnelEnabled starts or stops one tunnel and saves the choice, then
+// reloads so the page shows the saved config.
+async function setTunnelEnabled(port, enabled, btn) {
+  btn.disabled = true;
+  try {
+    await api.post(`/api/client/tunnels/${port}/${enabled ? 'enable' : 'disable'}`, {});
+  } catch (e) {
+    alert(e.message);
+  }
+  location.reload();
+}
+
+function formatBytes(n) {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + ' ' + units[i];
+}
+
+function formatAgo(ts) {
+  if (!ts || ts.startsWith('0001-')) return '—';
+  const secs = Math.max(0, Math.round((Date.now() - new Date(ts).getTime()) / 1000));
+  if (secs < 60) return secs + 's ago';
+  if (secs < 3600) return Math.floor(secs / 60) + 'm ago';
+  if (secs < 86400) return Math.floor(secs / 3600) + 'h ago';
+  return Math.floor(secs / 86400) + 'd ago';
+}
+
+// updateTunnelTable fills the per-tunnel rows from ClientStatus.tunnels.
+// Tunnels missing from the status (client stopped) show as stopped, and
+// disabled ones as disabled.
+function updateTunnelTable(tunnels) {
+  const table = document.getElementById('tunnel-table');
+  if (!table) return;
+  const byPort = new Map(tunnels.map(t => [String(t.local_port), t]));
+
+  table.querySelectorAll('tbody tr[data-port]').forEach(row => {
+    const t = byPort.get(row.dataset.port);
+    const field = (name) => row.querySelector(`[data-field="${name}"]`);
+    const state = field('state');
+
+    if (!t) {
+      state.textContent = row.dataset.enabled === 'false' ? 'disabled' : 'stopped';
+      state.className = 'badge badge-dim';
+      state.title = '';
+      field('conns').textContent = '—';
+      field('bytes').textContent = '—';
+      field('errors').textContent = '—';
+      field('activity').textContent = '—';
+      field('reconnect').classList.add('hidden');
+      return;
+    }
+
+    if (t.error) {
+      state.textContent = 'error';
+      state.className = 'badge badge-red';
+    } else if (t.listening) {
+      state.textContent = 'listening';
+      state.className = 'badge badge-green';
+    } else {
+      state.textContent = 'down';
+      state.className = 'badge badge-yellow';
+    }
+    state.title = t.error || '';
+    const listen = field('listen');
+    listen.textContent = t.listen_addr;
+    listen.title = t.bound_port ? `Port ${t.local_port} was taken, so the tunnel listens on ${t.bound_port}` : '';
+    field('conns').textContent = t.active_conns;
+    field('bytes').textContent = formatBytes(t.bytes_in) + ' / ' + formatBytes(t.bytes_out);
+    field('errors').textContent = t.errors;
+    field('activity').textContent = formatAgo(t.last_activity);
+    field('reconnect').classList.remove('hidden');
+  });
+}
+
+// updateForwardTable fills the reverse forward rows from
+// ServerStatus.forwards, matched by relay port.
+function updateForwardTable(forwards) {
+  const table = document.getElementById('forward-table');
+  if (!table) return;
+  const byPort = new Map(forwards.map(f => [String(f.remote_port), f]));
+
+  table.querySelectorAll('tbody tr[data-port]').forEach(row => {
+    const f = byPort.get(row.dataset.port);
+    const field = (name) => row.querySelector(`[data-field="${name}"]`);
+    const state = field('state');
+
+    if (!f) {
+      state.textContent = 'stopped';
+      state.className = 'badge badge-dim';
+      state.title = '';
+      field('conns').textContent = '—';
+      field('bytes').textContent = '—';
+      field('errors').textContent = '—';
+      return;
+    }
+
+    if (f.listening) {
+      state.textContent = 'listening';
+      state.className = 'badge badge-green';
+    } else if (f.error) {
+      state.textContent = 'error';
+      state.className = 'badge badge-red';
+    } else {
+      state.textContent = 'down';
+      state.className = 'badge badge-yellow';
+    }
+    state.title = f.error || '';
+    field('conns').textContent = f.active_conns;
+    field('bytes').textContent = formatBytes(f.bytes_in) + ' / ' + formatBytes(f.bytes_out);
+    field('errors').textContent = f.errors;
+  });
+}
+
+// updateRelayCerts shows the relay domain's certificate expiry and a warning
+// for any relay certificate that is expiring or failing, from
+// /api/status → server.certs.
+function updateRelayCerts(certs) {
+  const cell = document.querySelector('[data-bind="relay-cert"]');
+  const warning = document.querySelector('[data-bind="relay-cert-warning"]');
+  if (!cell || !warning) return;
+
+  const main = certs[0];
+  if (!main) {
+    cell.textContent = '—';
+    cell.className = 'kv-value';
+  } else if (!main.not_after) {
+    cell.textContent = 'unavailable';
+    cell.className = 'kv-value status-error';
+  } else {
+    cell.textContent = `${main.not_after.slice(0, 10)} (${main.days_left} days)`;
+    cell.className = 'kv-value ' + (main.expiring || main.error ? 'status-error' : 'status-up');
+  }
+  cell.title = main ? (main.error || main.issuer || '') : '';
+
+  const problems = certs.filter(c => c.expiring || c.error).map(c => {
+    if (c.expiring) return `${c.host}: certificate expires in ${c.days_left} days — renewal appears stuck.`;
+    return `${c.host}: ${c.error}`;
+  });
+  warning.textContent = problems.join(' ');
+  warning.classList.toggle('hidden', problems.length === 0);
+}
+
+// updateRelayReboot notes a relay reboot pending for OS updates, from
+// /api/status → server.relay_reboot.
+function updateRelayReboot(r) {
+  const el = document.querySelector('[data-bind="relay-reboot"]');
+  if (!el) return;
+  if (!r || !r.pending) {
+    el.classList.add('hidden');
+    return;
+  }
+  let text = 'The relay needs a reboot to finish OS updates';
+  if (r.packages && r.packages.length) text += ` (${r.packages.join(', ')})`;
+  text += r.scheduled_at
+    ? `; scheduled for ${new Date(r.scheduled_at).toLocaleString()}.`
+    : '; server.relay_maintenance.auto_reboot is off.';
+  el.textContent = text;
+  el.classList.remove('hidden');
+}
+
 // ── Status polling ──────────────────────────────────────────────────────────
 
 (function() {
@@ -139,6 +348,28 @@ async function clientReconnect() {
     if (cls) el.classList.add(cls);
   }
 
+  // componentTitles puts each component's uptime, restarts and last error
+  // in the tooltip of its status value.
+  function componentTitles(prefix, components) {
+    const binds = { 'SSH server': 'ssh', 'Xray': 'xray', 'Reverse tunnel': 'tunnel', 'Port forwarding': 'tunnel', 'gRPC API': 'api' };
+    for (const c of components) {
+      const el = binds[c.name] && document.querySelector(`[data-bind="${prefix}-${binds[c.name]}"]`);
+      if (!el) continue;
+      const parts = [];
+      if (c.running && c.uptime_seconds !== undefined) parts.push('up ' + formatUptime(c.uptime_seconds));
+      if (c.restarts) parts.push(`${c.restarts} restart${c.restarts === 1 ? '' : 's'}`);
+      if (c.error) parts.push(c.error);
+      el.title = parts.join(' · ');
+    }
+  }
+
+  function formatUptime(s) {
+    if (s < 60) return `${s}s`;
+    if (s < 3600) return `${Math.floor(s / 60)}m`;
+    if (s < 86400) return `${Math.floor(s / 3600)}h ${Math.floor(s % 3600 / 60)}m`;
+    return `${Math.floor(s / 86400)}d ${Math.floor(s % 86400 / 3600)}h`;
+  }
+
   function setError(bind, text) {
     const el = document.querySelector(`[data-bind="${bind}"]`);
     if (!el) return;
@@ -169,11 +400,17 @@ async function clientReconnect() {
         setBadge('server-badge', s.server.state);
         setStatus('srv-ssh', s.server.ssh ? 'up' : 'down', s.server.ssh ? 'status-up' : 'status-down');
         setStatus('srv-xray', s.server.xray ? 'up' : 'down', s.server.xray ? 'status-up' : 'status-down');
+        setStatus('srv-api', s.server.api ? 'up' : 'down', s.server.api ? 'status-up' : 'status-down');
         const tunText = s.server.tunnel ? 'up' : s.server.tunnel_error ? 'error' : 'down';
         const tunCls = s.server.tunnel ? 'status-up' : 'status-down';
         setStatus('srv-tunnel', tunText, s.server.tunnel_error ? 'status-error' : tunCls);
         setError('srv-tunnel-error', s.server.tunnel_error || '');
         setError('srv-error', s.server.error || '');
+        componentTitles('srv', s.server.components || []);
+
+        updateForwardTable(s.server.forwards || []);
+        updateRelayCerts(s.server.certs || []);
+        updateRelayReboot(s.server.relay_reboot);
       }
 
       if (s.client) {
@@ -184,6 +421,9 @@ async function clientReconnect() {
         setStatus('cli-tunnel', tunText, s.client.tunnel_error ? 'status-error' : tunCls);
         setError('cli-tunnel-error', s.client.tunnel_error || '');
         setError('cli-error', s.client.error || '');
+        componentTitles('cli', s.client.components || []);
+
+        updateTunnelTable(s.client.tunnels || []);
 
         const tunBadge = document.querySelector('[data-bind="tunnel-badge"]');
         if (tunBadge) {
@@ -251,31 +491,119 @@ async function clientReconnect() {
     } catch (_) {}
   }
 
-  setInterval(poll, 3000);
+  // Status events (tunnel up/down, reconnects, users coming and going)
+  // trigger a poll right away; while the stream is open the regular poll
+  // only catches what events don't cover, such as uptimes.
+  let interval = 3000;
+  let timer = setInterval(poll, interval);
+  function setPollInterval(ms) {
+    if (ms === interval) return;
+    interval = ms;
+    clearInterval(timer);
+    timer = setInterval(poll, interval);
+  }
+
+  let pending = null;
+  const events = new EventSource('/api/status/stream');
+  events.onopen = () => setPollInterval(15000);
+  events.onmessage = (e) => {
+    if (JSON.parse(e.data).type === 'notification') notifyLoad();
+    // Coalesce bursts, e.g. tunnel_down followed by tunnel_reconnecting.
+    if (pending) return;
+    pending = setTimeout(() => { pending = null; poll(); }, 200);
+  };
+  events.onerror = () => {
+    // Reconnects automatically via EventSource; poll as usual meanwhile.
+    setPollInterval(3000);
+  };
+
   poll();
 })();
 
 // ── Console log streaming ───────────────────────────────────────────────────
 
-(function() {
+// The console streams /api/logs with the chosen level, component and
+// filter applied on the server; changing them reconnects. While paused,
+// new lines are held and shown on resume.
+
+let consoleSource = null;
+let consolePaused = false;
+let consoleHeld = [];
+const CONSOLE_MAX = 2000; // lines kept in the page
+
+function consoleQuery() {
+  const params = new URLSearchParams();
+  for (const [key, id] of [['level', '#console-level'], ['component', '#console-component'], ['filter', '#console-filter']]) {
+    const v = $(id).value.trim();
+    if (v) params.set(key, v);
+  }
+  return params.toString();
+}
+
+function consoleAppend(entry) {
   const el = $('#console-log');
-  if (!el) return;
+  const line = document.createElement('div');
+  line.className = 'log-line';
+  line.innerHTML =
+    `<span class="log-time">${entry.time}</span>` +
+    `<span class="log-level log-level-${entry.level}">${entry.level}</span>` +
+    (entry.component ? `<span class="log-component">${escapeHtml(entry.component)}</span>` : '') +
+    `<span class="log-msg">${escapeHtml(entry.msg)}</span>`;
+  el.appendChild(line);
+  while (el.childElementCount > CONSOLE_MAX) el.firstElementChild.remove();
+  el.scrollTop = el.scrollHeight;
+}
 
-  const source = new EventSource('/api/logs');
-  source.onmessage = (e) => {
+function consoleConnect() {
+  if (!$('#console-log')) return;
+  if (consoleSource) consoleSource.close();
+  clearConsole();
+  consoleHeld = [];
+  consoleUpdateHeld();
+  const query = consoleQuery();
+  $('#console-download').href = '/api/logs/download' + (query ? '?' + query : '');
+  consoleSource = new EventSource('/api/logs' + (query ? '?' + query : ''));
+  consoleSource.onmessage = (e) => {
     const entry = JSON.parse(e.data);
-    const line = document.createElement('div');
-    line.className = 'log-line';
-    line.innerHTML =
-      `<span class="log-time">${entry.time}</span>` +
-      `<span class="log-level log-level-${entry.level}">${entry.level}</span>` +
-      `<span class="log-msg">${escapeHtml(entry.msg)}</span>`;
-    el.appendChild(line);
-    el.scrollTop = el.scrollHeight;
+    if (consolePaused) {
+      consoleHeld.push(entry);
+      if (consoleHeld.length > CONSOLE_MAX) consoleHeld.shift();
+      consoleUpdateHeld();
+      return;
+    }
+    consoleAppend(entry);
   };
-  source.onerror = () => {
+  consoleSource.onerror = () => {
     // Reconnects automatically via EventSource.
   };
+}
+
+// consolePause pauses the console, or resumes it showing the held lines.
+function consolePause() {
+  consolePaused = !consolePaused;
+  $('#btn-console-pause').textContent = consolePaused ? 'Resume' : 'Pause';
+  if (!consolePaused) {
+    consoleHeld.forEach(consoleAppend);
+    consoleHeld = [];
+  }
+  consoleUpdateHeld();
+}
+
+function consoleUpdateHeld() {
+  const badge = $('#console-held');
+  badge.textContent = `paused, ${consoleHeld.length} new`;
+  badge.classList.toggle('hidden', !consolePaused);
+}
+
+(function() {
+  const filter = $('#console-filter');
+  if (!filter) return;
+  let timer = null;
+  filter.addEventListener('input', () => {
+    clearTimeout(timer);
+    timer = setTimeout(consoleConnect, 400);
+  });
+  consoleConnect();
 })();
 
 function clearConsole() {
@@ -358,3 +686,42 @@ function copyText(text, el) {
     }
   });
 })();
+
+// ── Invitation claim ─────────────────────────────────────────────────────────
+
+(function() {
+  const form = $('#claim-form');
+  if (!form) return;
+
+  const btn = $('#btn-claim');
+  const log = $('#claim-progress');
+  const errorEl = $('#upload-error');
+
+  form.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const code = $('#claim-code').value.trim();
+    if (!code) return;
+    errorEl.classList.add('hidden');
+    btn.disabled = true;
+    log.innerHTML = '';
+    log.classList.remove('hidden');
+
+    const fail = (err) => {
+      errorEl.textContent = err.message;
+      errorEl.classList.remove('hidden');
+      btn.disabled = false;
+    };
+    try {
+      const resp = await api.post('/api/client/claim', { code });
+      connectSSE(resp.session_id, (event) => renderProgressEvent(log, event), (err) => {
+        if (err) {
+          fail(err);
+          return;
+        }
+        window.location.reload();
+      });
+    } catch (err) {
+      fail(err);
+    }
+  });
+})();
@@ -0,0 +1,34 @@
+// ── API tokens ──────────────────────────────────────────────────────────────
+
+async function createToken() {
+  const name = $('#token-name').value.trim();
+  const role = $('#token-role').value;
+  const expiresDays = parseInt($('#token-expires').value) || 0;
+  const errorEl = $('#token-error');
+  errorEl.classList.add('hidden');
+
+  if (!name) { alert('Token name is required'); return; }
+
+  const btn = $('#btn-create-token');
+  btn.disabled = true;
+
+  try {
+    const resp = await api.post('/api/tokens', { name, role, expires_days: expiresDays });
+    $('#token-secret').textContent = resp.secret;
+    $('#token-created').classList.remove('hidden');
+  } catch (err) {
+    errorEl.textContent = err.message;
+    errorEl.classList.remove('hidden');
+    btn.disabled = false;
+  }
+}
+
+async function revokeToken(name) {
+  if (!confirm(`Revoke token "${name}"? Anything using it loses access immediately.`)) return;
+  try {
+    await api.del(`/api/tokens/${name}`);
+    window.location.reload();
+  } catch (err) {
+    alert('Revoke failed: ' + err.message);
+  }
+}
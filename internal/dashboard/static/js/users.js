@@ -7,6 +7,7 @@ function addMapping() {
   row.innerHTML = `
     <input type="number" class="client-port" placeholder="Client port" min="1" max="65535">
     <span class="arrow">-></span>
+    <input type="text" class="server-host" placeholder="127.0.0.1">
     <input type="number" class="server-port" placeholder="Server port" min="1" max="65535">
     <button class="btn btn-sm btn-danger" onclick="removeMapping(this)">x</button>
   `;
@@ -31,19 +32,36 @@ function getMappings() {
   return $$('.mapping-row').map(row => {
     const cp = row.querySelector('.client-port').value.trim();
     const sp = row.querySelector('.server-port').value.trim();
+    const host = row.querySelector('.server-host').value.trim();
     if (!cp || !sp) return null;
-    return { client_port: parseInt(cp), server_port: parseInt(sp) };
+    const m = { client_port: parseInt(cp), server_port: parseInt(sp) };
+    if (host && host !== '127.0.0.1') m.server_host = host;
+    return m;
   }).filter(Boolean);
 }
 
 // ── Create user ─────────────────────────────────────────────────────────────
 
+function onTemplateChange() {
+  const select = $('#user-template');
+  const hint = $('#template-hint');
+  if (!select || !hint) return;
+  hint.classList.toggle('hidden', !select.value);
+}
+
 async function createUser() {
   const name = $('#user-name').value.trim();
   if (!name) { alert('Username is required'); return; }
 
+  const select = $('#user-template');
+  const template = select ? select.value : '';
   const mappings = getMappings();
-  if (mappings.length === 0) { alert('At least one port mapping is required'); return; }
+  if (mappings.length === 0 && !template) { alert('At least one port mapping is required'); return; }
+
+  const inviteInput = $('#user-invite');
+  const invite = inviteInput ? inviteInput.checked : false;
+  const pubkeyInput = $('#user-pubkey');
+  const public_key = pubkeyInput && !invite ? pubkeyInput.value.trim() : '';
 
   const btn = $('#btn-create-user');
   btn.disabled = true;
@@ -52,7 +70,7 @@ async function createUser() {
   $('#user-progress').classList.remove('hidden');
 
   try {
-    const resp = await api.post('/api/users', { name, mappings });
+    const resp = await api.post('/api/users', { name, mappings, template, public_key, invite });
     const log = $('#create-progress');
 
     connectSSE(resp.session_id, (event) => {
@@ -63,7 +81,9 @@ async function createUser() {
         $('#create-error').classList.remove('hidden');
       } else {
         $('#download-link').href = `/api/users/${name}/download`;
+        $('#download-link').classList.toggle('hidden', invite);
         $('#create-done').classList.remove('hidden');
+        if (invite) fillInviteCode(name, false, $('#create-invite'));
       }
     });
   } catch (err) {
@@ -72,49 +92,152 @@ async function createUser() {
   }
 }
 
-// ── Delete user ─────────────────────────────────────────────────────────────
+// onInviteChange disables the public key field for an invited user, who
+// makes their key themselves.
+function onInviteChange() {
+  const invite = $('#user-invite').checked;
+  $('#user-pubkey').disabled = invite;
+}
 
-async function deleteUser(name) {
-  if (!confirm(`Delete user "${name}"? This removes their keys, config, and authorized_keys entry.`)) return;
+// fillInviteCode shows the claim code of the invited user name in box,
+// renewing it first with renew.
+async function fillInviteCode(name, renew, box) {
+  const url = `/api/users/${encodeURIComponent(name)}/invite`;
+  try {
+    const inv = renew ? await api.post(url, {}) : await api.get(url);
+    $('#invite-code-text').textContent = inv.code;
+    $('#invite-expires').textContent = new Date(inv.expires).toLocaleString();
+    box.classList.remove('hidden');
+  } catch (e) {
+    alert(e.message);
+  }
+}
 
-  const btn = $('#btn-delete');
-  btn.disabled = true;
+function copyInviteCode(btn) {
+  navigator.clipboard.writeText($('#invite-code-text').textContent).then(() => {
+    const orig = btn.textContent;
+    btn.textContent = 'Copied!';
+    setTimeout(() => { btn.textContent = orig; }, 1000);
+  });
+}
+
+function showUserInvite(name, renew) {
+  if (renew && !confirm(`Replace ${name}'s claim code with a new one? The old one stops working.`)) return;
+  fillInviteCode(name, renew, $('#invite-code'));
+}
+
+// ── Bundle links ────────────────────────────────────────────────────────────
+
+async function setBundleLinks(enabled) {
+  if (!enabled && !confirm('Turn bundle links off? Every link stops working.')) return;
+  const container = $('#links-progress-container');
+  const log = $('#links-progress');
+  container.classList.remove('hidden');
+  log.innerHTML = '';
 
   try {
-    await api.del(`/api/users/${name}`);
-    window.location.href = '/users';
+    const resp = await api.post('/api/bundle-links', { enabled });
+    connectSSE(resp.session_id, (event) => {
+      renderProgressEvent(log, event);
+    }, (err) => {
+      if (err) {
+        log.innerHTML += `<div class="progress-step failed"><span class="step-label">Error: ${err.message}</span></div>`;
+      } else {
+        setTimeout(() => { window.location.reload(); }, 1000);
+      }
+    });
   } catch (err) {
-    alert('Delete failed: ' + err.message);
-    btn.disabled = false;
+    log.innerHTML = `<div class="alert alert-error">${err.message}</div>`;
   }
 }
 
-// ── Register / unregister users on relay ─────────────────────────────────────
+// createBundleLink makes a link for the user name, shows its URL, which
+// can't be fetched again, and lists it.
+async function createBundleLink(name) {
+  const body = {
+    expires_hours: parseInt($('#link-expires').value, 10),
+    max_uses: parseInt($('#link-uses').value, 10),
+  };
+  try {
+    const resp = await api.post(`/api/users/${encodeURIComponent(name)}/links`, body);
+    $('#link-url-text').textContent = resp.url;
+    $('#link-url').classList.remove('hidden');
+
+    const l = resp.link;
+    const row = document.createElement('tr');
+    row.dataset.link = l.id;
+    const cells = [
+      l.id,
+      new Date(l.expires).toLocaleString(),
+      l.max_uses ? `0 of ${l.max_uses}` : '0',
+      'never',
+    ];
+    for (const text of cells) {
+      const td = document.createElement('td');
+      td.textContent = text;
+      row.appendChild(td);
+    }
+    row.firstChild.className = 'text-mono';
+    const td = document.createElement('td');
+    const btn = document.createElement('button');
+    btn.className = 'btn btn-sm btn-danger';
+    btn.textContent = 'Revoke';
+    btn.onclick = () => revokeBundleLink(l.id);
+    td.appendChild(btn);
+    row.appendChild(td);
+    $('#links-table tbody').prepend(row);
+    $('#links-table').classList.remove('hidden');
+  } catch (e) {
+    alert(e.message);
+  }
+}
 
-async function applyUser(name) {
-  await relayUsersRequest('/api/users/apply', { names: [name] });
+function copyLinkURL(btn) {
+  navigator.clipboard.writeText($('#link-url-text').textContent).then(() => {
+    const orig = btn.textContent;
+    btn.textContent = 'Copied!';
+    setTimeout(() => { btn.textContent = orig; }, 1000);
+  });
 }
 
-async function applyAllUsers() {
-  await relayUsersRequest('/api/users/apply', { names: [] });
+async function revokeBundleLink(id) {
+  if (!confirm(`Revoke bundle link ${id}? It stops working at once.`)) return;
+  try {
+    await api.del(`/api/bundle-links/${encodeURIComponent(id)}`);
+    const row = document.querySelector(`tr[data-link="${id}"]`);
+    if (row) row.remove();
+  } catch (e) {
+    alert(e.message);
+  }
 }
 
-async function unregisterUser(name) {
-  if (!confirm(`Unregister "${name}" from the relay? They will lose tunnel access until re-registered.`)) return;
-  await relayUsersRequest('/api/users/unregister', { names: [name] });
+// loadPublicKey fills the public key field from a chosen .pub file.
+async function loadPublicKey(input) {
+  if (input.files.length === 0) return;
+  $('#user-pubkey').value = (await input.files[0].text()).trim();
+  input.value = '';
 }
 
-async function relayUsersRequest(endpoint, body) {
+// ── Import users ────────────────────────────────────────────────────────────
+
+async function importUsers(input) {
+  if (input.files.length === 0) return;
   const container = $('#apply-progress-container');
   const log = $('#apply-progress');
-  if (!container || !log) return;
 
   container.classList.remove('hidden');
   log.innerHTML = '';
 
+  const fd = new FormData();
+  fd.append('file', input.files[0]);
+  input.value = '';
+
   try {
-    const resp = await api.post(endpoint, body);
-    connectSSE(resp.session_id, (event) => {
+    const resp = await fetch('/api/users/import', { method: 'POST', body: fd });
+    const data = await resp.json();
+    if (!resp.ok) throw new Error(data.error || 'Import failed');
+
+    connectSSE(data.session_id, (event) => {
       renderProgressEvent(log, event);
     }, (err) => {
       if (err) {
@@ -128,134 +251,267 @@ async function relayUsersRequest(endpoint, body) {
   }
 }
 
-// ── Search, Sort & Pagination ────────────────────────────────────────────────
-
-(function() {
-  const PAGE_SIZE = 10;
-  let currentPage = 1;
-  let sortCol = 'status';
-  let sortDir = 'asc'; // asc = online/registered first
+// ── Mapping templates ───────────────────────────────────────────────────────
 
-  const searchInput = $('#user-search');
-  const table = $('#users-table');
-  if (!searchInput || !table) return;
+function editTemplate(name) {
+  const row = document.querySelector(`tr[data-template="${name}"]`);
+  if (!row) return;
+  $('#template-name').value = name;
+  $('#template-ports').value = row.dataset.ports;
+  $('#template-form-title').textContent = `Edit Template: ${name}`;
+  $('#template-name').focus();
+}
 
-  const tbody = table.querySelector('tbody');
-  const allRows = Array.from(tbody.querySelectorAll('tr'));
-  const headers = table.querySelectorAll('th.sortable');
+async function saveTemplate() {
+  const name = $('#template-name').value.trim();
+  const ports = $('#template-ports').value.split(',').map(p => p.trim()).filter(Boolean);
+  const errorEl = $('#template-error');
+  errorEl.classList.add('hidden');
+
+  if (!name) { alert('Template name is required'); return; }
+  if (ports.length === 0) { alert('At least one port is required'); return; }
+
+  // Offer to propagate the change to existing members.
+  let propagate = false;
+  const row = document.querySelector(`tr[data-template="${name}"]`);
+  const members = row ? parseInt(row.dataset.members) || 0 : 0;
+  if (members > 0) {
+    propagate = confirm(`Update the mappings of ${members} user${members === 1 ? '' : 's'} created from "${name}" too?`);
+  }
 
-  // ── Sort helpers ──
+  const btn = $('#btn-save-template');
+  btn.disabled = true;
 
-  function getSortValue(row, col) {
-    switch (col) {
-      case 'name':    return row.dataset.user.toLowerCase();
-      case 'tunnels': return parseInt(row.dataset.tunnels) || 0;
-      case 'status':  return parseInt(row.dataset.status) || 0;
-      default:        return '';
+  try {
+    const resp = await api.post('/api/templates', { name, ports, propagate });
+    if (!resp.session_id) {
+      window.location.reload();
+      return;
     }
-  }
-
-  function sortRows() {
-    allRows.sort((a, b) => {
-      const va = getSortValue(a, sortCol);
-      const vb = getSortValue(b, sortCol);
-      let cmp = 0;
-      if (typeof va === 'number') {
-        cmp = va - vb;
+    const container = $('#template-progress-container');
+    const log = $('#template-progress');
+    container.classList.remove('hidden');
+    log.innerHTML = '';
+    connectSSE(resp.session_id, (event) => {
+      renderProgressEvent(log, event);
+    }, (err) => {
+      if (err) {
+        log.innerHTML += `<div class="progress-step failed"><span class="step-label">Error: ${err.message}</span></div>`;
+        btn.disabled = false;
       } else {
-        cmp = va.localeCompare(vb);
+        setTimeout(() => { window.location.reload(); }, 1000);
       }
-      return sortDir === 'asc' ? cmp : -cmp;
     });
-    // Re-append in sorted order.
-    allRows.forEach(row => tbody.appendChild(row));
+  } catch (err) {
+    errorEl.textContent = err.message;
+    errorEl.classList.remove('hidden');
+    btn.disabled = false;
   }
+}
 
-  function updateHeaders() {
-    headers.forEach(th => {
-      th.classList.remove('active', 'sort-asc', 'sort-desc');
-      if (th.dataset.sort === sortCol) {
-        th.classList.add('active', sortDir === 'asc' ? 'sort-asc' : 'sort-desc');
-      }
-    });
+async function deleteTemplate(name) {
+  if (!confirm(`Delete template "${name}"? Its users keep their current mappings.`)) return;
+  try {
+    await api.del(`/api/templates/${name}`);
+    window.location.reload();
+  } catch (err) {
+    alert('Delete failed: ' + err.message);
   }
+}
 
-  headers.forEach(th => {
-    th.addEventListener('click', () => {
-      const col = th.dataset.sort;
-      if (sortCol === col) {
-        sortDir = sortDir === 'asc' ? 'desc' : 'asc';
-      } else {
-        sortCol = col;
-        sortDir = 'asc';
-      }
-      updateHeaders();
-      sortRows();
-      currentPage = 1;
-      filterAndPaginate();
-    });
-  });
+// ── Edit user ───────────────────────────────────────────────────────────────
 
-  // ── Filter & paginate ──
+let originalMappings = null;
 
-  function filterAndPaginate() {
-    const query = searchInput.value.toLowerCase().trim();
+function toggleEditUser() {
+  $('#user-edit').classList.toggle('hidden');
+  if (originalMappings === null) originalMappings = JSON.stringify(getMappings());
+  if ($$('.mapping-row').length === 0) addMapping();
+  updateRemoveButtons();
+}
 
-    const matching = [];
-    allRows.forEach(row => {
-      const name = row.querySelector('td').textContent.toLowerCase();
-      if (name.includes(query)) {
-        matching.push(row);
-      }
-    });
+async function updateUser(name) {
+  const newName = $('#edit-name').value.trim();
+  if (!newName) { alert('Username is required'); return; }
 
-    const totalPages = Math.max(1, Math.ceil(matching.length / PAGE_SIZE));
-    if (currentPage > totalPages) currentPage = totalPages;
+  const mappings = getMappings();
+  if (mappings.length === 0) { alert('At least one port mapping is required'); return; }
 
-    const start = (currentPage - 1) * PAGE_SIZE;
-    const end = start + PAGE_SIZE;
+  const errorEl = $('#edit-error');
+  errorEl.classList.add('hidden');
+  const btn = $('#btn-save-user');
+  btn.disabled = true;
 
-    allRows.forEach(row => row.style.display = 'none');
-    matching.forEach((row, i) => {
-      row.style.display = (i >= start && i < end) ? '' : 'none';
-    });
+  try {
+    // Only send mappings when they changed, so a plain rename keeps the
+    // user attached to their template.
+    const body = {};
+    if (JSON.stringify(mappings) !== originalMappings) body.mappings = mappings;
+    if (newName !== name) body.new_name = newName;
+    const resp = await api.put(`/api/users/${name}`, body);
+    window.location.href = `/users/${resp.name}`;
+  } catch (err) {
+    errorEl.textContent = err.message;
+    errorEl.classList.remove('hidden');
+    btn.disabled = false;
+  }
+}
+
+// ── Download bundle ─────────────────────────────────────────────────────────
 
-    renderPagination(matching.length, totalPages);
+function downloadUser(name) {
+  const format = $('#download-format').value;
+  window.location.href = `/api/users/${name}/download` + (format === 'zip' ? '' : `?format=${format}`);
+}
+
+// ── Delete user ─────────────────────────────────────────────────────────────
+
+async function deleteUser(name) {
+  if (!confirm(`Delete user "${name}"? This removes their keys, config, and authorized_keys entry.`)) return;
+
+  const btn = $('#btn-delete');
+  btn.disabled = true;
+
+  try {
+    await api.del(`/api/users/${name}`);
+    window.location.href = '/users';
+  } catch (err) {
+    alert('Delete failed: ' + err.message);
+    btn.disabled = false;
   }
+}
 
-  function renderPagination(total, totalPages) {
-    const el = $('#pagination');
-    if (!el) return;
+// ── Register / unregister users on relay ─────────────────────────────────────
 
-    if (totalPages <= 1) {
-      el.innerHTML = '';
-      return;
-    }
+async function applyUser(name) {
+  await relayUsersRequest('/api/users/apply', { names: [name] });
+}
 
-    let html = '';
-    html += `<button class="btn btn-sm" ${currentPage <= 1 ? 'disabled' : ''} onclick="goToPage(${currentPage - 1})">&laquo; Prev</button>`;
-    for (let i = 1; i <= totalPages; i++) {
-      html += `<button class="btn btn-sm${i === currentPage ? ' active' : ''}" onclick="goToPage(${i})">${i}</button>`;
-    }
-    html += `<button class="btn btn-sm" ${currentPage >= totalPages ? 'disabled' : ''} onclick="goToPage(${currentPage + 1})">Next &raquo;</button>`;
-    el.innerHTML = html;
+async function applyAllUsers() {
+  await relayUsersRequest('/api/users/apply', { names: [] });
+}
+
+async function unregisterUser(name) {
+  if (!confirm(`Unregister "${name}" from the relay? They will lose tunnel access until re-registered.`)) return;
+  await relayUsersRequest('/api/users/unregister', { names: [name] });
+}
+
+async function setUserDisabled(name, disabled) {
+  if (disabled && !confirm(`Disable "${name}"? Their UUID is removed from the relay and their SSH key is suspended until re-enabled.`)) return;
+  await relayUsersRequest(`/api/users/${name}/${disabled ? 'disable' : 'enable'}`, {});
+}
+
+async function setUserSFTP(name, enabled, btn) {
+  btn.disabled = true;
+  try {
+    await api.post(`/api/users/${name}/sftp`, { enabled });
+  } catch (e) {
+    alert(e.message);
   }
+  window.location.reload();
+}
 
-  window.goToPage = function(n) {
-    currentPage = n;
-    filterAndPaginate();
-  };
+async function setUserTOTP(name, enabled, btn) {
+  if (enabled && !confirm(`Require a TOTP code from ${name}? Their client won't connect until they enroll the QR code shown next.`)) return;
+  btn.disabled = true;
+  try {
+    await api.post(`/api/users/${name}/totp`, { enabled });
+  } catch (e) {
+    alert(e.message);
+    window.location.reload();
+    return;
+  }
+  if (enabled) {
+    window.location.hash = 'totp';
+  }
+  window.location.reload();
+}
 
-  searchInput.addEventListener('input', () => {
-    currentPage = 1;
-    filterAndPaginate();
+function showUserTOTP(name) {
+  $('#totp-qr-img').src = `/api/users/${name}/totp.png?t=${Date.now()}`;
+  $('#totp-qr').classList.remove('hidden');
+}
+
+if (window.location.hash === '#totp') {
+  document.addEventListener('DOMContentLoaded', () => {
+    const card = document.querySelector('[data-user]');
+    if (card) showUserTOTP(card.dataset.user);
+    history.replaceState(null, '', window.location.pathname);
   });
+}
+
+async function relayUsersRequest(endpoint, body) {
+  const container = $('#apply-progress-container');
+  const log = $('#apply-progress');
+  if (!container || !log) return;
+
+  container.classList.remove('hidden');
+  log.innerHTML = '';
+
+  try {
+    const resp = await api.post(endpoint, body);
+    connectSSE(resp.session_id, (event) => {
+      renderProgressEvent(log, event);
+    }, (err) => {
+      if (err) {
+        log.innerHTML += `<div class="progress-step failed"><span class="step-label">Error: ${err.message}</span></div>`;
+      } else {
+        setTimeout(() => { window.location.reload(); }, 1000);
+      }
+    });
+  } catch (err) {
+    log.innerHTML = `<div class="alert alert-error">${err.message}</div>`;
+  }
+}
+
+// ── Consistency check ───────────────────────────────────────────────────────
 
-  // Initial sort & render.
-  updateHeaders();
-  sortRows();
-  filterAndPaginate();
-})();
+async function repairCheck() {
+  const btn = $('#btn-repair-check');
+  const card = $('#repair-card');
+  const summary = $('#repair-summary');
+  btn.disabled = true;
+  card.classList.remove('hidden');
+  summary.textContent = 'Checking the relay, authorized_keys and users...';
+  $('#repair-table').classList.add('hidden');
+  $('#btn-repair-all').classList.add('hidden');
+  try {
+    const report = await api.get('/api/users/repair');
+    const n = report.problems.length;
+    let text = n ? `${n} discrepanc${n === 1 ? 'y' : 'ies'} found.` : 'Everything agrees.';
+    if (!report.relay_checked) text += ` Relay not compared: ${report.relay_error}.`;
+    summary.textContent = text;
+
+    const list = $('#repair-list');
+    list.textContent = '';
+    for (const d of report.problems) {
+      const row = document.createElement('tr');
+      row.innerHTML = '<td class="text-mono"></td><td></td><td class="text-dim"></td><td></td>';
+      const cells = row.querySelectorAll('td');
+      cells[0].textContent = d.user || (d.uuid ? d.uuid : d.key.slice(0, 16) + '...');
+      cells[1].textContent = d.detail;
+      cells[2].textContent = d.fix;
+      const fix = document.createElement('button');
+      fix.className = 'btn btn-sm admin-only';
+      fix.textContent = 'Fix';
+      fix.onclick = () => repairFix([d.id]);
+      cells[3].appendChild(fix);
+      list.appendChild(row);
+    }
+    $('#repair-table').classList.toggle('hidden', n === 0);
+    $('#btn-repair-all').classList.toggle('hidden', n === 0);
+  } catch (err) {
+    summary.textContent = 'Check failed: ' + err.message;
+  }
+  btn.disabled = false;
+}
+
+async function repairFix(ids) {
+  const what = ids.length ? 'this discrepancy' : 'all discrepancies';
+  if (!confirm(`Fix ${what}? UUIDs and authorized_keys entries that belong to no user are removed.`)) return;
+  $('#repair-card').classList.add('hidden');
+  await relayUsersRequest('/api/users/repair', { ids });
+}
 
 // ── Online status polling ───────────────────────────────────────────────────
 
@@ -0,0 +1,356 @@
+package dashboard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+	"github.com/tunnelwhisperer/tw/internal/pty"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Terminals opened from the dashboard, relay shells and, when enabled,
+// terminals on this host, outlive the WebSocket showing them, like a tmux
+// session: when the browser drops the connection the shell keeps running,
+// detached, for the terminal keepalive, and the browser can attach again
+// with the session's reconnect token. Each terminal tab is a session of
+// its own.
+
+// Terminal session limits: the most shells open at once, the output kept
+// for replay on attach, and how often detached sessions are reaped.
+const (
+	maxTermSessions      = 8
+	termScrollback       = 64 << 10
+	termReapInterval     = 30 * time.Second
+	defaultTermKeepalive = 10 * time.Minute
+)
+
+// Terminal targets.
+const (
+	termRelay = "relay" // a shell on the relay, over SSH
+	termHost  = "host"  // a shell or command on this host
+)
+
+// termSession is a terminal and the WebSocket currently attached to it.
+type termSession struct {
+	id     string // reconnect token
+	target string
+	stdin  io.Writer
+	resize func(cols, rows int)
+	end    func() // ends the shell
+	rec    *ops.Recorder
+	done   chan struct{} // closed when the shell has ended
+
+	mu         sync.Mutex
+	conn       *websocket.Conn // nil while detached
+	detached   time.Time
+	scrollback []byte
+}
+
+// termRegistry holds the open terminal sessions by reconnect token.
+type termRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*termSession
+}
+
+func newTermRegistry() *termRegistry {
+	return &termRegistry{sessions: make(map[string]*termSession)}
+}
+
+func (t *termRegistry) get(id string) *termSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessions[id]
+}
+
+func (t *termRegistry) add(ts *termSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions[ts.id] = ts
+}
+
+// remove unregisters ts once its shell has ended.
+func (t *termRegistry) remove(ts *termSession) {
+	t.mu.Lock()
+	delete(t.sessions, ts.id)
+	t.mu.Unlock()
+	close(ts.done)
+}
+
+// count returns how many sessions are open.
+func (t *termRegistry) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
+
+// reap ends sessions detached for longer than keepalive, every
+// termReapInterval, and all of them once closing is closed.
+func (t *termRegistry) reap(keepalive func() time.Duration, closing <-chan struct{}) {
+	tick := time.NewTicker(termReapInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-closing:
+			t.mu.Lock()
+			for _, ts := range t.sessions {
+				ts.end()
+			}
+			t.mu.Unlock()
+			return
+		case <-tick.C:
+		}
+		t.mu.Lock()
+		for _, ts := range t.sessions {
+			ts.mu.Lock()
+			expired := ts.conn == nil && time.Since(ts.detached) > keepalive()
+			ts.mu.Unlock()
+			if expired {
+				slog.Info("closing detached terminal", "target", ts.target, "idle", keepalive())
+				ts.end()
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// termKeepalive returns how long a detached terminal is kept.
+func (s *Server) termKeepalive() time.Duration {
+	if d := s.ops.Config().Dashboard.TerminalKeepalive; d > 0 {
+		return d
+	}
+	return defaultTermKeepalive
+}
+
+// newTermSession returns an unregistered session with a fresh reconnect
+// token, or an error when too many terminals are open.
+func (s *Server) newTermSession(target string) (*termSession, error) {
+	if s.terms.count() >= maxTermSessions {
+		return nil, errors.New("too many terminals open; close one first")
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &termSession{
+		id:       hex.EncodeToString(id),
+		target:   target,
+		done:     make(chan struct{}),
+		detached: time.Now(),
+	}, nil
+}
+
+// startRelayTerm opens a relay shell in the background and registers it,
+// recording it when record is set. It returns once the shell runs.
+func (s *Server) startRelayTerm(record bool) (*termSession, error) {
+	ts, err := s.newTermSession(termRelay)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan *termSession)
+	failed := make(chan error, 1)
+	go func() {
+		err := s.ops.RelaySSH(func(client *gossh.Client) error {
+			session, err := client.NewSession()
+			if err != nil {
+				return err
+			}
+			defer session.Close()
+
+			if err := session.RequestPty("xterm-256color", 24, 80, gossh.TerminalModes{
+				gossh.ECHO:          1,
+				gossh.TTY_OP_ISPEED: 14400,
+				gossh.TTY_OP_OSPEED: 14400,
+			}); err != nil {
+				return err
+			}
+			stdin, err := session.StdinPipe()
+			if err != nil {
+				return err
+			}
+			stdout, err := session.StdoutPipe()
+			if err != nil {
+				return err
+			}
+			var rec *ops.Recorder
+			if record {
+				rec, err = s.ops.StartRecording("dashboard relay shell "+s.ops.GetRelayStatus().Domain, 80, 24)
+				if err != nil {
+					return err
+				}
+				defer rec.Close()
+			}
+			if err := session.Shell(); err != nil {
+				return err
+			}
+
+			ts.stdin, ts.rec = stdin, rec
+			ts.resize = func(cols, rows int) { session.WindowChange(rows, cols) }
+			ts.end = func() {
+				stdin.Close()
+				session.Close()
+			}
+			s.terms.add(ts)
+			defer s.terms.remove(ts)
+			ready <- ts
+
+			ts.pump(stdout)
+			if err := session.Wait(); err != nil && err != io.EOF {
+				slog.Debug("relay SSH session ended", "error", err)
+			}
+			return nil
+		})
+		if err == nil {
+			err = errors.New("relay shell ended before it started")
+		}
+		failed <- err
+	}()
+
+	select {
+	case ts := <-ready:
+		return ts, nil
+	case err := <-failed:
+		return nil, err
+	}
+}
+
+// startHostTerm starts a terminal on this host in the background and
+// registers it: the shell, or host command number command when the
+// dashboard's host_commands are set.
+func (s *Server) startHostTerm(command int) (*termSession, error) {
+	d := s.ops.Config().Dashboard
+	if !d.HostTerminal {
+		return nil, errors.New("host terminal is disabled (dashboard.host_terminal)")
+	}
+	// Without tokens the dashboard is open to anyone who can reach it.
+	if !auth.Enabled() {
+		return nil, errors.New("host terminal needs access control: create an API token first with tw token create")
+	}
+	argv := hostShell()
+	if len(d.HostCommands) > 0 {
+		if command < 0 || command >= len(d.HostCommands) {
+			return nil, fmt.Errorf("no host command %d", command)
+		}
+		argv = hostCommand(d.HostCommands[command])
+	}
+	ts, err := s.newTermSession(termHost)
+	if err != nil {
+		return nil, err
+	}
+	p, err := pty.Start(argv, 80, 24)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("host terminal opened", "command", strings.Join(argv, " "))
+
+	ts.stdin = p
+	ts.resize = func(cols, rows int) { p.Resize(cols, rows) }
+	ts.end = func() { p.Close() }
+	s.terms.add(ts)
+	go func() {
+		defer s.terms.remove(ts)
+		ts.pump(p)
+		if err := p.Wait(); err != nil {
+			slog.Debug("host terminal ended", "error", err)
+		}
+		p.Close()
+	}()
+	return ts, nil
+}
+
+// hostShell returns the shell a host terminal runs.
+func hostShell() []string {
+	if runtime.GOOS == "windows" {
+		if comspec := os.Getenv("ComSpec"); comspec != "" {
+			return []string{comspec}
+		}
+		return []string{"cmd.exe"}
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return []string{shell, "-l"}
+	}
+	return []string{"/bin/sh", "-l"}
+}
+
+// hostCommand returns the command line cmd run through the system shell.
+func hostCommand(cmd string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd.exe", "/c", cmd}
+	}
+	return []string{"/bin/sh", "-c", cmd}
+}
+
+// pump sends the shell's output to the attached WebSocket, keeping the
+// last of it for the next one to attach, until the shell ends.
+func (ts *termSession) pump(stdout io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			ts.rec.Write(buf[:n])
+			ts.mu.Lock()
+			ts.scrollback = append(ts.scrollback, buf[:n]...)
+			if over := len(ts.scrollback) - termScrollback; over > 0 {
+				ts.scrollback = append(ts.scrollback[:0], ts.scrollback[over:]...)
+			}
+			if ts.conn != nil {
+				ts.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if ts.conn.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+					ts.conn.Close()
+					ts.conn, ts.detached = nil, time.Now()
+				}
+			}
+			ts.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// attach makes conn the session's WebSocket, replaying the scrollback.
+// A WebSocket attached before is told and closed.
+func (ts *termSession) attach(conn *websocket.Conn, status []byte) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.conn != nil {
+		ts.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"status","msg":"detached"}`))
+		ts.conn.Close()
+	}
+	ts.conn = conn
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	conn.WriteMessage(websocket.TextMessage, status)
+	if len(ts.scrollback) > 0 {
+		conn.WriteMessage(websocket.BinaryMessage, ts.scrollback)
+	}
+}
+
+// detach leaves the session running without conn, if conn is still the
+// attached WebSocket.
+func (ts *termSession) detach(conn *websocket.Conn) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.conn == conn {
+		ts.conn, ts.detached = nil, time.Now()
+	}
+}
+
+// writeText sends a control message to conn, if it is still attached.
+func (ts *termSession) writeText(conn *websocket.Conn, msg []byte) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.conn == conn {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		conn.WriteMessage(websocket.TextMessage, msg)
+	}
+}
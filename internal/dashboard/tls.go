@@ -0,0 +1,139 @@
+package dashboard
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// hstsHeader is sent over HTTPS with a user-supplied certificate. It is
+// left out with the self-signed one: browsers refuse to let users click
+// through certificate warnings for HSTS hosts.
+const hstsHeader = "max-age=31536000"
+
+// selfSignedCertPath and selfSignedKeyPath hold the certificate generated
+// when dashboard.tls is on without cert_file and key_file.
+func selfSignedCertPath() string { return filepath.Join(config.Dir(), "dashboard.crt") }
+func selfSignedKeyPath() string  { return filepath.Join(config.Dir(), "dashboard.key") }
+
+// tlsConfig builds the dashboard's TLS settings: the configured or
+// self-signed certificate and, with client_ca, required client
+// certificates.
+func tlsConfig(d config.DashboardConfig) (*tls.Config, error) {
+	certFile, keyFile := d.CertFile, d.KeyFile
+	if certFile == "" {
+		if err := ensureSelfSignedCert(); err != nil {
+			return nil, err
+		}
+		certFile, keyFile = selfSignedCertPath(), selfSignedKeyPath()
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading dashboard certificate: %w", err)
+	}
+
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if d.ClientCA != "" {
+		data, err := os.ReadFile(d.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading dashboard client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", d.ClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a certificate, as
+// browsers show it, so the self-signed one can be checked before trusting
+// it.
+func certFingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// ensureSelfSignedCert generates the self-signed certificate unless a
+// valid one exists. It is renewed 30 days before it expires.
+func ensureSelfSignedCert() error {
+	if data, err := os.ReadFile(selfSignedCertPath()); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			if c, err := x509.ParseCertificate(block.Bytes); err == nil && time.Until(c.NotAfter) > 30*24*time.Hour {
+				return nil
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating dashboard key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("generating serial number: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tunnel Whisperer dashboard"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipnet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("creating dashboard certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encoding dashboard key: %w", err)
+	}
+
+	if err := os.MkdirAll(config.Dir(), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(selfSignedKeyPath(), keyPEM, 0600); err != nil {
+		return fmt.Errorf("writing dashboard key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(selfSignedCertPath(), certPEM, 0644); err != nil {
+		return fmt.Errorf("writing dashboard certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package eventsink delivers the forwards made through the embedded SSH
+// server to collectors outside tw, such as a SIEM or an IDS. Sinks queue
+// events and deliver them in the background, so a slow or unreachable
+// collector never holds up a tunnel; events that don't fit the queue are
+// dropped and counted.
+package eventsink
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Forward actions.
+const (
+	ActionAccepted = "accepted" // the forward was permitted and connected
+	ActionDenied   = "denied"   // permitopen refused the destination
+	ActionFailed   = "failed"   // the destination could not be reached
+	ActionClosed   = "closed"   // an accepted forward ended, with its traffic
+)
+
+// Event is one step of a forward (ssh -L) through the embedded SSH server.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	User       string    `json:"user"`                // tw user the key was issued to
+	Source     string    `json:"source"`              // address the SSH connection came from
+	Origin     string    `json:"origin,omitempty"`    // originator the client reported
+	Dest       string    `json:"dest"`                // host:port
+	BytesIn    int64     `json:"bytes_in,omitempty"`  // client → destination
+	BytesOut   int64     `json:"bytes_out,omitempty"` // destination → client
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Sink receives events. Send must not block; Close delivers what is still
+// queued, waiting a few seconds at most.
+type Sink interface {
+	Send(Event)
+	Close() error
+}
+
+// multi sends every event to each of its sinks.
+type multi []Sink
+
+func (m multi) Send(e Event) {
+	for _, s := range m {
+		s.Send(e)
+	}
+}
+
+func (m multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Multi returns a sink sending to all of sinks, or nil when there are none.
+func Multi(sinks ...Sink) Sink {
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0]
+	}
+	return multi(sinks)
+}
+
+const (
+	queueSize    = 1024            // events waiting for delivery
+	maxBatch     = 100             // events delivered at once
+	flushTimeout = 5 * time.Second // how long Close waits for delivery
+)
+
+// queue delivers events in the background, in batches of what has
+// accumulated while the previous batch was delivered.
+type queue struct {
+	name    string
+	deliver func([]Event) error
+	cleanup func() // called once the queue has stopped; may be nil
+
+	events  chan Event
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+func newQueue(name string, deliver func([]Event) error, cleanup func()) *queue {
+	q := &queue{
+		name:    name,
+		deliver: deliver,
+		cleanup: cleanup,
+		events:  make(chan Event, queueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *queue) Send(e Event) {
+	select {
+	case q.events <- e:
+	default:
+		if n := q.dropped.Add(1); n == 1 || n%queueSize == 0 {
+			slog.Warn("event sink is falling behind, dropping events", "sink", q.name, "dropped", n)
+		}
+	}
+}
+
+// Close stops the queue once the events already in it are delivered.
+func (q *queue) Close() error {
+	q.once.Do(func() { close(q.stop) })
+	select {
+	case <-q.done:
+		return nil
+	case <-time.After(flushTimeout):
+		return errors.New(q.name + ": timed out delivering queued events")
+	}
+}
+
+func (q *queue) run() {
+	defer close(q.done)
+	if q.cleanup != nil {
+		defer q.cleanup()
+	}
+	failing := false
+	for {
+		var batch []Event
+		select {
+		case e := <-q.events:
+			batch = append(batch, e)
+		case <-q.stop:
+			// Deliver what is left, then stop.
+			for len(q.events) > 0 {
+				batch = append(batch, <-q.events)
+				if len(batch) == maxBatch {
+					q.deliver(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				q.deliver(batch)
+			}
+			return
+		}
+		for len(batch) < maxBatch && len(q.events) > 0 {
+			batch = append(batch, <-q.events)
+		}
+		// Log the first failure and the recovery, not every batch.
+		if err := q.deliver(batch); err != nil {
+			if !failing {
+				slog.Warn("event sink delivery failed, dropping events", "sink", q.name, "events", len(batch), "error", err)
+			}
+			failing = true
+		} else if failing {
+			slog.Info("event sink delivering again", "sink", q.name)
+			failing = false
+		}
+	}
+}
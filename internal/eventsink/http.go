@@ -0,0 +1,45 @@
+package eventsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// httpTimeout bounds each POST to an HTTP collector.
+const httpTimeout = 10 * time.Second
+
+// NewHTTP returns a sink that POSTs events to url as a JSON array, with
+// headers (e.g. Authorization) added to each request. A batch the
+// collector doesn't answer with 2xx is dropped, not retried.
+func NewHTTP(url string, headers map[string]string) Sink {
+	client := &http.Client{Timeout: httpTimeout}
+	return newQueue("http "+url, func(events []Event) error {
+		body, err := json.Marshal(events)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "tw-event-sink")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("collector answered %s", resp.Status)
+		}
+		return nil
+	}, nil)
+}
@@ -0,0 +1,149 @@
+package eventsink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Syslog priorities: facility authpriv, where security messages go.
+const (
+	syslogFacility = 10 << 3
+	syslogWarning  = 4
+	syslogInfo     = 6
+)
+
+// syslogSockets are where the local syslog daemon listens.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogDialTimeout bounds connecting to a remote syslog server.
+const syslogDialTimeout = 10 * time.Second
+
+// ParseSyslogAddress splits a syslog server address, udp://host:port or
+// tcp://host:port, into a network and an address; the port defaults
+// to 514. An empty address is the local syslog daemon, returned as an
+// empty network.
+func ParseSyslogAddress(address string) (network, addr string, err error) {
+	if address == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" {
+		return "", "", fmt.Errorf("%q is not udp://host:port or tcp://host:port", address)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "514"
+	}
+	return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// NewSyslog returns a sink that writes each event as a JSON message tagged
+// tag to the syslog server at address (see ParseSyslogAddress). Denied
+// forwards are logged as warnings, the rest as info. Only an invalid
+// address is an error.
+func NewSyslog(address, tag string) (Sink, error) {
+	network, addr, err := ParseSyslogAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	name := "syslog " + address
+	if address == "" {
+		name = "syslog"
+	}
+	// A collector that is down doesn't keep the server from starting;
+	// each batch tries to connect again.
+	w := &syslogWriter{network: network, addr: addr, tag: tag}
+	if err := w.connect(); err != nil {
+		slog.Warn("event sink not reachable, retrying with the next event", "sink", name, "error", err)
+	}
+	return newQueue(name, w.write, w.close), nil
+}
+
+// syslogWriter writes messages to a syslog server in the format of the
+// standard library's log/syslog, which isn't available on Windows.
+type syslogWriter struct {
+	network, addr, tag string
+	hostname           string
+	conn               net.Conn
+}
+
+// connect dials the syslog server, or the local daemon's socket.
+func (w *syslogWriter) connect() error {
+	if w.network != "" {
+		conn, err := net.DialTimeout(w.network, w.addr, syslogDialTimeout)
+		if err != nil {
+			return fmt.Errorf("connecting to syslog at %s: %w", w.addr, err)
+		}
+		w.conn = conn
+		w.hostname, _ = os.Hostname()
+		return nil
+	}
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				w.conn = conn
+				return nil
+			}
+		}
+	}
+	return errors.New("no local syslog daemon; set a syslog server address")
+}
+
+func (w *syslogWriter) write(events []Event) error {
+	for _, e := range events {
+		msg, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line := w.format(e, string(msg))
+		// Reconnect once when the server went away, e.g. restarted.
+		if w.conn == nil || w.send(line) != nil {
+			if w.conn != nil {
+				w.conn.Close()
+				w.conn = nil
+			}
+			if err := w.connect(); err != nil {
+				return err
+			}
+			if err := w.send(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *syslogWriter) close() {
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *syslogWriter) send(line string) error {
+	w.conn.SetWriteDeadline(time.Now().Add(syslogDialTimeout))
+	_, err := w.conn.Write([]byte(line))
+	return err
+}
+
+// format renders a message the way log/syslog does: RFC 3164 with a
+// hostname and an RFC 3339 time for remote servers, without a hostname
+// for the local daemon, each ending in a newline.
+func (w *syslogWriter) format(e Event, msg string) string {
+	pri := syslogFacility | syslogInfo
+	if e.Action == ActionDenied {
+		pri = syslogFacility | syslogWarning
+	}
+	var line string
+	if w.network == "" {
+		line = fmt.Sprintf("<%d>%s %s[%d]: %s", pri, e.Time.Format(time.Stamp), w.tag, os.Getpid(), msg)
+	} else {
+		line = fmt.Sprintf("<%d>%s %s %s[%d]: %s", pri, e.Time.Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), msg)
+	}
+	return line + "\n"
+}
@@ -0,0 +1,121 @@
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// lockPoll is how often a held lock is tried again.
+	lockPoll = 50 * time.Millisecond
+
+	// staleLockAge is how long a lock may be held before it is taken
+	// over even though its holder seems alive: locks guard single file
+	// changes, never long operations, so one this old was left behind by
+	// a process whose PID has since been reused.
+	staleLockAge = 2 * time.Minute
+
+	// unreadableLockAge is how long a lock file without a PID is given
+	// for its holder to finish writing one.
+	unreadableLockAge = 5 * time.Second
+)
+
+// lockMu serializes the goroutines of this process: the lock file itself
+// only tells processes apart.
+var lockMu sync.Mutex
+
+// Lock takes the lock file at path, waiting up to timeout for another
+// process to release it, and returns the function that releases it. The
+// file holds the PID of its holder; a lock whose holder has exited, or
+// that is older than staleLockAge, is stale and taken over. Locks are not
+// reentrant: hold one only around a single read-modify-write.
+func Lock(path string, timeout time.Duration) (func(), error) {
+	lockMu.Lock()
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, werr := f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			cerr := f.Close()
+			if werr != nil || cerr != nil {
+				os.Remove(path)
+				lockMu.Unlock()
+				return nil, fmt.Errorf("writing lock %s: %w", path, firstErr(werr, cerr))
+			}
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					os.Remove(path)
+					lockMu.Unlock()
+				})
+			}, nil
+		}
+		if !os.IsExist(err) {
+			lockMu.Unlock()
+			return nil, fmt.Errorf("taking lock %s: %w", path, err)
+		}
+
+		pid, stale := lockHolder(path)
+		if stale {
+			breakLock(path, pid)
+			continue
+		}
+		if time.Now().After(deadline) {
+			lockMu.Unlock()
+			return nil, fmt.Errorf("%s is held by another tw process (pid %d); if none is running, remove the file", path, pid)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// lockHolder reads the PID in the lock file at path and tells whether the
+// lock is stale.
+func lockHolder(path string) (int, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		// Released meanwhile; try again.
+		return 0, os.IsNotExist(err)
+	}
+	age := time.Since(info.ModTime())
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, age > unreadableLockAge
+	}
+	// With lockMu held, a lock with our own PID was left by an earlier
+	// process that had it.
+	stale := pid == os.Getpid() || !ProcessAlive(pid) || age > staleLockAge
+	return pid, stale
+}
+
+// breakLock removes the stale lock at path held by pid. It is moved aside
+// first, and put back if another process took the lock over meanwhile and
+// what was moved is its fresh one.
+func breakLock(path string, pid int) {
+	aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	data, _ := os.ReadFile(aside)
+	if holder, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && holder != pid {
+		os.Rename(aside, path)
+		return
+	}
+	os.Remove(aside)
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build geoip
+
+package geoip
+
+import _ "embed"
+
+// builtin is the database compiled in with -tags geoip. Put a geoip.dat
+// (from Xray or github.com/v2fly/geoip) in this directory before building.
+//
+//go:embed geoip.dat
+var builtin []byte
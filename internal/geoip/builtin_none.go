@@ -0,0 +1,6 @@
+//go:build !geoip
+
+package geoip
+
+// builtin is empty without -tags geoip: geoip.database must name a file.
+var builtin []byte
@@ -0,0 +1,170 @@
+// Package geoip finds the country of an IP address and filters connections
+// by country. It reads geoip.dat, the database format Xray uses for its
+// geoip: routing rules, so the server and the relay agree on countries.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNoDatabase is returned by Open when no path is given and tw was built
+// without a database.
+var ErrNoDatabase = errors.New("no GeoIP database: set geoip.database to a geoip.dat file, or build tw with -tags geoip")
+
+// DB maps IP addresses to ISO 3166 country codes.
+type DB struct {
+	ranges []ipRange // sorted by start
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// HasBuiltin reports whether tw was built with a GeoIP database.
+func HasBuiltin() bool {
+	return len(builtin) > 0
+}
+
+// Open loads the geoip.dat file at path, or the database built into tw
+// when path is empty.
+func Open(path string) (*DB, error) {
+	if path == "" {
+		if len(builtin) == 0 {
+			return nil, ErrNoDatabase
+		}
+		return Parse(builtin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading GeoIP database: %w", err)
+	}
+	db, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Parse reads a geoip.dat database. Lists that aren't countries, such as
+// "private" or "cloudflare", are skipped.
+func Parse(data []byte) (*DB, error) {
+	var list router.GeoIPList
+	if err := proto.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing GeoIP database: %w", err)
+	}
+	db := &DB{}
+	for _, e := range list.Entry {
+		code := strings.ToUpper(e.CountryCode)
+		if !IsCountryCode(code) {
+			continue
+		}
+		for _, c := range e.Cidr {
+			addr, ok := netip.AddrFromSlice(c.Ip)
+			if !ok {
+				continue
+			}
+			p, err := addr.Prefix(int(c.Prefix))
+			if err != nil {
+				continue
+			}
+			db.ranges = append(db.ranges, ipRange{start: p.Addr(), end: lastAddr(p), country: code})
+		}
+	}
+	if len(db.ranges) == 0 {
+		return nil, errors.New("GeoIP database lists no countries")
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	return db, nil
+}
+
+// Country returns the country code for ip, or "" when it isn't listed.
+func (db *DB) Country(ip net.IP) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ""
+	}
+	addr = addr.Unmap()
+	i := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].start) })
+	if i == 0 {
+		return ""
+	}
+	if r := db.ranges[i-1]; addr.Compare(r.end) <= 0 {
+		return r.country
+	}
+	return ""
+}
+
+// IsCountryCode reports whether s is a two-letter country code.
+func IsCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// lastAddr returns the highest address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Filter decides which countries may connect. Deny wins over Allow; with
+// an Allow list, addresses in no listed country are refused too.
+type Filter struct {
+	db    *DB
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// NewFilter creates a Filter. With no allow or deny codes it refuses
+// nothing and only looks up countries.
+func NewFilter(db *DB, allow, deny []string) *Filter {
+	return &Filter{db: db, allow: codeSet(allow), deny: codeSet(deny)}
+}
+
+func codeSet(codes []string) map[string]bool {
+	if len(codes) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+// Check returns the country of ip ("" when unknown) and whether it may
+// connect. Loopback, private, and link-local addresses have no country and
+// are always let through.
+func (f *Filter) Check(ip net.IP) (country string, ok bool) {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return "", true
+	}
+	country = f.db.Country(ip)
+	if f.deny[country] {
+		return country, false
+	}
+	if f.allow != nil && !f.allow[country] {
+		return country, false
+	}
+	return country, true
+}
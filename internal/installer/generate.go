@@ -0,0 +1,145 @@
+// Package installer renders single-file client installers that embed a
+// user's config bundle and the tw binary.
+package installer
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+//go:embed install.sh.tmpl
+var installShTmpl string
+
+//go:embed install.ps1.tmpl
+var installPs1Tmpl string
+
+// Platforms lists the supported installer targets.
+var Platforms = []string{"linux", "windows"}
+
+// bundleFiles are the config bundle entries copied to the client, with the
+// Unix permissions they are written with.
+var bundleFiles = []struct {
+	Name string
+	Mode string
+}{
+	{"config.yaml", "644"},
+	{"id_ed25519", "600"},
+	{"id_ed25519.pub", "644"},
+}
+
+// Config holds the inputs for an installer.
+type Config struct {
+	User     string // user name, shown in the script header
+	Platform string // "linux" or "windows"
+	Bundle   []byte // config bundle zip, as returned by GetUserConfigBundle
+	Binary   []byte // tw executable built for Platform
+}
+
+type embeddedFile struct {
+	Name    string
+	Mode    string
+	Private bool
+	Data    string // base64, wrapped at 76 columns
+}
+
+type templateData struct {
+	User     string
+	FileName string
+	Files    []embeddedFile
+	Binary   string
+}
+
+// FileName returns the installer file name for a user and platform.
+func FileName(user, platform string) string {
+	if platform == "windows" {
+		return "install-tw-" + user + ".ps1"
+	}
+	return "install-tw-" + user + ".sh"
+}
+
+// Generate renders the installer script for cfg.Platform.
+func Generate(cfg Config) ([]byte, error) {
+	var tmpl string
+	switch cfg.Platform {
+	case "linux":
+		tmpl = installShTmpl
+	case "windows":
+		tmpl = installPs1Tmpl
+	default:
+		return nil, fmt.Errorf("unsupported installer platform %q (use %s)", cfg.Platform, strings.Join(Platforms, " or "))
+	}
+	if len(cfg.Binary) == 0 {
+		return nil, fmt.Errorf("tw binary is required")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(cfg.Bundle), int64(len(cfg.Bundle)))
+	if err != nil {
+		return nil, fmt.Errorf("reading config bundle: %w", err)
+	}
+	entries := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from bundle: %w", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from bundle: %w", f.Name, err)
+		}
+		entries[f.Name] = data
+	}
+	if _, ok := entries["id_ed25519_sk"]; ok {
+		// The installed service has no SSH agent to sign with the key.
+		return nil, fmt.Errorf("user's key is on a security key; send the config bundle instead of an installer")
+	}
+
+	data := templateData{
+		User:     cfg.User,
+		FileName: FileName(cfg.User, cfg.Platform),
+		Binary:   encode(cfg.Binary),
+	}
+	for _, bf := range bundleFiles {
+		content, ok := entries[bf.Name]
+		if !ok {
+			return nil, fmt.Errorf("config bundle is missing %s", bf.Name)
+		}
+		data.Files = append(data.Files, embeddedFile{
+			Name:    bf.Name,
+			Mode:    bf.Mode,
+			Private: bf.Mode == "600",
+			Data:    encode(content),
+		})
+	}
+
+	t, err := template.New(data.FileName).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode base64-encodes data and wraps it at 76 columns so the scripts stay
+// friendly to editors and line-based transfer tools.
+func encode(data []byte) string {
+	s := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/76 + 1)
+	for len(s) > 76 {
+		b.WriteString(s[:76])
+		b.WriteByte('\n')
+		s = s[76:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
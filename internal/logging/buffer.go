@@ -0,0 +1,251 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single log line, as the dashboard console and tw logs show
+// it.
+type Entry struct {
+	Time      string    `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component,omitempty"` // see logComponent
+	Message   string    `json:"msg"`
+	At        time.Time `json:"at"`
+
+	level slog.Level
+	seq   uint64 // order added to the Buffer
+}
+
+// Line formats e for a log file.
+func (e Entry) Line() string {
+	if e.Component == "" {
+		return fmt.Sprintf("%s %-5s %s\n", e.At.Format(time.RFC3339), e.Level, e.Message)
+	}
+	return fmt.Sprintf("%s %-5s [%s] %s\n", e.At.Format(time.RFC3339), e.Level, e.Component, e.Message)
+}
+
+// Log components the console can be filtered by. A record's "component"
+// attribute names it; otherwise the tee tells it from the message, in
+// this order, so "SSH tunnel" is a tunnel line.
+var logComponents = []struct {
+	name  string
+	words []string
+}{
+	{"terraform", []string{"terraform"}},
+	{"xray", []string{"xray"}},
+	{"tunnel", []string{"tunnel", "forward"}},
+	{"ssh", []string{"ssh", "sftp", "handshake"}},
+}
+
+// logComponent returns the component msg is about, or "".
+func logComponent(msg string) string {
+	msg = strings.ToLower(msg)
+	for _, c := range logComponents {
+		for _, w := range c.words {
+			if strings.Contains(msg, w) {
+				return c.name
+			}
+		}
+	}
+	return ""
+}
+
+// Filter selects log entries: at least Level, of one of Components (any
+// when nil), containing Text (ignoring case), logged at Since or later
+// (any time when zero).
+type Filter struct {
+	Level      slog.Level
+	Components map[string]bool
+	Text       string
+	Since      time.Time
+}
+
+// ParseFilter reads a Filter from the query parameters level (debug, info,
+// warn or error), component (comma-separated) and filter.
+func ParseFilter(q url.Values) (Filter, error) {
+	return NewFilter(q.Get("level"), q.Get("component"), q.Get("filter"))
+}
+
+// NewFilter returns the Filter for a level name, comma-separated
+// components and text, each of which may be empty.
+func NewFilter(lvl, components, text string) (Filter, error) {
+	f := Filter{Level: slog.LevelDebug, Text: strings.ToLower(text)}
+	if lvl != "" {
+		if err := f.Level.UnmarshalText([]byte(lvl)); err != nil {
+			return f, fmt.Errorf("invalid level %q", lvl)
+		}
+	}
+	if components != "" {
+		f.Components = make(map[string]bool)
+		for _, c := range strings.Split(components, ",") {
+			f.Components[strings.TrimSpace(c)] = true
+		}
+	}
+	return f, nil
+}
+
+// After reports whether e was added to the buffer after prev.
+func (e Entry) After(prev Entry) bool {
+	return e.seq > prev.seq
+}
+
+// Match reports whether f selects e.
+func (f Filter) Match(e Entry) bool {
+	if e.level < f.Level || e.At.Before(f.Since) {
+		return false
+	}
+	if f.Components != nil && !f.Components[e.Component] {
+		return false
+	}
+	return f.Text == "" || strings.Contains(strings.ToLower(e.Message), f.Text)
+}
+
+// bufferSize is how many entries the process's buffer keeps.
+const bufferSize = 500
+
+var (
+	bufferOnce sync.Once
+	buffer     *Buffer
+)
+
+// Buffered tees the default logger into a buffer of the process's last
+// entries, the first time it is called, and returns that buffer. The
+// dashboard console and the API's StreamLogs both read it.
+func Buffered() *Buffer {
+	bufferOnce.Do(func() {
+		buffer = newBuffer(bufferSize)
+		current := slog.Default().Handler()
+		if current == nil {
+			current = slog.NewTextHandler(os.Stderr, nil)
+		}
+		slog.SetDefault(slog.New(newTeeHandler(current, buffer)))
+	})
+	return buffer
+}
+
+// Buffer is a fixed-size ring buffer of log entries with subscriber support.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	max     int
+	subs    map[int]chan Entry
+	nextID  int
+	seq     uint64
+}
+
+func newBuffer(max int) *Buffer {
+	return &Buffer{
+		entries: make([]Entry, 0, max),
+		max:     max,
+		subs:    make(map[int]chan Entry),
+	}
+}
+
+func (b *Buffer) add(e Entry) {
+	b.mu.Lock()
+	b.seq++
+	e.seq = b.seq
+	if len(b.entries) >= b.max {
+		b.entries = b.entries[1:]
+	}
+	b.entries = append(b.entries, e)
+	for _, ch := range b.subs {
+		select {
+		case ch <- e:
+		default: // drop if subscriber is slow
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Snapshot returns a copy of all buffered entries.
+func (b *Buffer) Snapshot() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]Entry, len(b.entries))
+	copy(out, b.entries)
+	return out
+}
+
+// Subscribe returns a channel that receives new log entries and an unsubscribe func.
+func (b *Buffer) Subscribe() (<-chan Entry, func()) {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Entry, 64)
+	b.subs[id] = ch
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		close(ch)
+		b.mu.Unlock()
+	}
+}
+
+// teeHandler is a slog.Handler that forwards records to an inner handler
+// and also writes them to a Buffer for streaming, tagged with their
+// component.
+type teeHandler struct {
+	inner     slog.Handler
+	buf       *Buffer
+	component string // from WithAttrs
+}
+
+func newTeeHandler(inner slog.Handler, buf *Buffer) *teeHandler {
+	return &teeHandler{inner: inner, buf: buf}
+}
+
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	msg := r.Message
+	component := h.component
+	// Append key=value attrs.
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "component" {
+			component = a.Value.String()
+			return true
+		}
+		msg += fmt.Sprintf(" %s=%s", a.Key, a.Value.String())
+		return true
+	})
+	if component == "" {
+		component = logComponent(r.Message)
+	}
+
+	h.buf.add(Entry{
+		Time:      r.Time.Format(time.TimeOnly),
+		Level:     r.Level.String(),
+		Component: component,
+		Message:   msg,
+		At:        r.Time,
+		level:     r.Level,
+	})
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, a := range attrs {
+		if a.Key == "component" {
+			component = a.Value.String()
+		}
+	}
+	return &teeHandler{inner: h.inner.WithAttrs(attrs), buf: h.buf, component: component}
+}
+
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	return &teeHandler{inner: h.inner.WithGroup(name), buf: h.buf, component: h.component}
+}
@@ -4,17 +4,23 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 )
 
-// XrayLevel holds the Xray-compatible log level string (e.g. "debug", "warning").
-// Set by Setup()/SetLevel() and read by the xray package when building configs.
-var XrayLevel = "warning"
-
 // level is a dynamic level variable shared by all handlers in the chain.
 // Changing it via SetLevel() takes effect immediately without replacing
 // the handler (important for the dashboard's tee handler wrapper).
 var level slog.LevelVar
 
+// Xray's own log level, in Xray's terms: "debug", "info", "warning",
+// "error" or "none". xrayFollow is the one that goes with the log level;
+// xrayOverride, when set, is xray.log_level from the config.
+var (
+	xrayMu       sync.Mutex
+	xrayFollow   = "warning"
+	xrayOverride string
+)
+
 // Setup initializes the default slog logger at the given level.
 // Valid levels: "debug", "info", "warn", "error". Defaults to "info".
 func Setup(lvl string) {
@@ -29,19 +35,40 @@ func SetLevel(lvl string) {
 	applyLevel(lvl)
 }
 
+// SetXrayLevel sets Xray's log level ("debug", "info", "warning", "error"
+// or "none"), taking effect at once. Empty makes it follow the log level:
+// debug with debug, error with error, warning otherwise.
+func SetXrayLevel(lvl string) {
+	xrayMu.Lock()
+	defer xrayMu.Unlock()
+	xrayOverride = strings.ToLower(lvl)
+}
+
+// XrayLevel returns Xray's log level.
+func XrayLevel() string {
+	xrayMu.Lock()
+	defer xrayMu.Unlock()
+	if xrayOverride != "" {
+		return xrayOverride
+	}
+	return xrayFollow
+}
+
 func applyLevel(lvl string) {
+	follow := "warning"
 	switch strings.ToLower(lvl) {
 	case "debug":
 		level.Set(slog.LevelDebug)
-		XrayLevel = "debug"
+		follow = "debug"
 	case "warn", "warning":
 		level.Set(slog.LevelWarn)
-		XrayLevel = "warning"
 	case "error":
 		level.Set(slog.LevelError)
-		XrayLevel = "error"
+		follow = "error"
 	default:
 		level.Set(slog.LevelInfo)
-		XrayLevel = "warning"
 	}
+	xrayMu.Lock()
+	xrayFollow = follow
+	xrayMu.Unlock()
 }
@@ -0,0 +1,79 @@
+package ops
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/ratelimit"
+)
+
+// Services whose failed authentications are rate limited.
+const (
+	BanServiceSSH       = "ssh"       // handshakes on the embedded SSH server
+	BanServiceDashboard = "dashboard" // dashboard sign-ins
+)
+
+// SourceBan is a source banned from a service after repeated failures.
+type SourceBan struct {
+	Service string `json:"service"`
+	ratelimit.Ban
+}
+
+// rateLimitOptions converts the rate_limit settings.
+func rateLimitOptions(rl config.RateLimitConfig) ratelimit.Options {
+	return ratelimit.Options{
+		MaxFailures: rl.MaxFailures,
+		Window:      rl.Window,
+		BanTime:     rl.BanTime,
+		MaxBanTime:  rl.MaxBanTime,
+	}
+}
+
+// limiter returns the ban list for a service.
+func (o *Ops) limiter(service string) (*ratelimit.Limiter, error) {
+	switch service {
+	case BanServiceSSH:
+		return o.sshBans, nil
+	case BanServiceDashboard:
+		return o.loginBans, nil
+	}
+	return nil, fmt.Errorf("unknown service %q", service)
+}
+
+// LoginLimiter tracks failed dashboard sign-ins.
+func (o *Ops) LoginLimiter() *ratelimit.Limiter {
+	return o.loginBans
+}
+
+// Bans returns the sources banned now from the SSH server and the
+// dashboard.
+func (o *Ops) Bans() []SourceBan {
+	var bans []SourceBan
+	for _, service := range []string{BanServiceSSH, BanServiceDashboard} {
+		l, _ := o.limiter(service)
+		for _, b := range l.Bans() {
+			bans = append(bans, SourceBan{Service: service, Ban: b})
+		}
+	}
+	sort.SliceStable(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// ClearBan lifts a source's ban from a service and forgets its failures.
+// An empty service and key clear every ban.
+func (o *Ops) ClearBan(service, key string) error {
+	if service == "" && key == "" {
+		o.sshBans.ClearAll()
+		o.loginBans.ClearAll()
+		return nil
+	}
+	l, err := o.limiter(service)
+	if err != nil {
+		return err
+	}
+	if !l.Clear(key) {
+		return fmt.Errorf("%s is not banned from %s", key, service)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package ops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"filippo.io/age"
+)
+
+// A compact bundle is a user's zip bundle as one line of text, to paste
+// through chat systems that mangle attachments:
+//
+//	tw1:<base64url zip>:<checksum>
+//	tw1-age:<base64url age ciphertext>:<checksum>
+//
+// The checksum is the first 8 bytes of the SHA-256 of the decoded data, in
+// hex, so a string cut short or altered in transit is caught before it is
+// imported. The tw1-age form is encrypted with a passphrase (age's scrypt
+// recipient), which must be sent another way.
+
+const (
+	compactPrefix    = "tw1:"
+	compactAgePrefix = "tw1-age:"
+)
+
+// ErrPassphraseRequired is returned by DecodeCompactBundle for an
+// encrypted string given no passphrase.
+var ErrPassphraseRequired = errors.New("the bundle string is encrypted; a passphrase is required")
+
+// EncodeCompactBundle returns the zip bundle data as a compact string,
+// encrypted with passphrase unless it is empty.
+func EncodeCompactBundle(data []byte, passphrase string) (string, error) {
+	prefix := compactPrefix
+	if passphrase != "" {
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, r)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write(data); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		prefix, data = compactAgePrefix, buf.Bytes()
+	}
+	return compactString(prefix, data), nil
+}
+
+// CompactBundleEncrypted reports whether s is an encrypted compact string.
+func CompactBundleEncrypted(s string) bool {
+	return strings.HasPrefix(stripCompact(s), compactAgePrefix)
+}
+
+// DecodeCompactBundle verifies the compact string s and returns the zip
+// bundle it holds, decrypting it with passphrase. Whitespace a chat system
+// added, such as line breaks, is ignored.
+func DecodeCompactBundle(s, passphrase string) ([]byte, error) {
+	encrypted := CompactBundleEncrypted(s)
+	prefix := compactPrefix
+	if encrypted {
+		prefix = compactAgePrefix
+	} else if !strings.HasPrefix(stripCompact(s), compactPrefix) {
+		return nil, errors.New("not a tw bundle string (it should start with tw1: or tw1-age:)")
+	}
+	data, err := parseCompact(s, prefix, "bundle string")
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.Decrypt(bytes.NewReader(data), id)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting the bundle string (wrong passphrase?): %w", err)
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("decrypting the bundle string: %w", err)
+		}
+	}
+	if _, err := ParseBundle(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// compactString returns data as a compact string starting with prefix.
+func compactString(prefix string, data []byte) string {
+	return prefix + base64.RawURLEncoding.EncodeToString(data) + ":" + compactChecksum(data)
+}
+
+// parseCompact verifies the compact string s, starting with prefix, and
+// returns its data. what names the string in errors.
+func parseCompact(s, prefix, what string) ([]byte, error) {
+	s, ok := strings.CutPrefix(stripCompact(s), prefix)
+	if !ok {
+		return nil, fmt.Errorf("not a tw %s (it should start with %s)", what, prefix)
+	}
+	payload, sum, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("the %s has no checksum; copy it again in full", what)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("the %s is damaged (%v); copy it again in full", what, err)
+	}
+	if compactChecksum(data) != strings.ToLower(sum) {
+		return nil, fmt.Errorf("the %s is damaged (checksum mismatch); copy it again in full", what)
+	}
+	return data, nil
+}
+
+// compactChecksum returns the checksum of a compact string's data.
+func compactChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// stripCompact removes whitespace, and the backticks or quotes a chat
+// message may wrap a pasted string in.
+func stripCompact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '`' || r == '"' || r == '\'' {
+			return -1
+		}
+		return r
+	}, s)
+}
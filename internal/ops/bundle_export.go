@@ -0,0 +1,399 @@
+package ops
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// A user's bundle can also be exported for clients that won't run tw:
+// an OpenSSH config and key layout, a PuTTY private key, or a JSON
+// descriptor. All are made from the zip bundle, so they work the same
+// from a local export and from the daemon's. Whatever runs the SSH
+// connection still needs the relay tunnel: an Xray client forwarding
+// 127.0.0.1:ClientListenPort through the relay, as tw connect runs. The
+// xray format adds one, for proxy apps such as v2rayN, to the OpenSSH
+// layout.
+
+// Export formats.
+const (
+	ExportZip     = "zip"     // the bundle tw connect reads
+	ExportOpenSSH = "openssh" // ssh_config, known_hosts and key, for ~/.ssh/tw
+	ExportPuTTY   = "putty"   // the private key as a PuTTY .ppk file
+	ExportJSON    = "json"    // a machine-readable descriptor
+	ExportXray    = "xray"    // Xray client config and VLESS link, with the openssh files
+)
+
+// ExportFormats lists the formats ExportBundle takes.
+var ExportFormats = []string{ExportZip, ExportOpenSSH, ExportPuTTY, ExportJSON, ExportXray}
+
+// Bundle is a user's config bundle, read back from its zip.
+type Bundle struct {
+	Xray       config.XrayConfig
+	Client     config.ClientConfig
+	PrivateKey []byte // id_ed25519; nil for a security key or the user's own key
+	KeyHandle  []byte // id_ed25519_sk, for a security key
+	PublicKey  []byte // id_ed25519.pub
+}
+
+// ParseBundle reads a bundle made by GetUserConfigBundle.
+func ParseBundle(data []byte) (*Bundle, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	b := &Bundle{}
+	var cfgData []byte
+	for _, f := range zr.File {
+		var dst *[]byte
+		switch path.Base(f.Name) {
+		case "config.yaml":
+			dst = &cfgData
+		case "id_ed25519":
+			dst = &b.PrivateKey
+		case securityKeyFile:
+			dst = &b.KeyHandle
+		case "id_ed25519.pub":
+			dst = &b.PublicKey
+		default:
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening %s in bundle: %w", f.Name, err)
+		}
+		*dst, err = io.ReadAll(io.LimitReader(rc, 1<<20))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from bundle: %w", f.Name, err)
+		}
+	}
+	if cfgData == nil {
+		return nil, errors.New("invalid bundle: no config.yaml")
+	}
+	var cfg struct {
+		Xray   config.XrayConfig   `yaml:"xray"`
+		Client config.ClientConfig `yaml:"client"`
+	}
+	if err := yaml.Unmarshal(cfgData, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid bundle config.yaml: %w", err)
+	}
+	b.Xray, b.Client = cfg.Xray, cfg.Client
+	return b, nil
+}
+
+// ExportBundle converts the zip bundle data of user name to format, and
+// returns the file name to save it under and its content.
+func ExportBundle(name string, data []byte, format string) (string, []byte, error) {
+	if format == "" || format == ExportZip {
+		return name + "-tw-config.zip", data, nil
+	}
+	b, err := ParseBundle(data)
+	if err != nil {
+		return "", nil, err
+	}
+	switch format {
+	case ExportOpenSSH:
+		out, err := b.OpenSSH(name)
+		return name + "-tw-ssh.zip", out, err
+	case ExportPuTTY:
+		out, err := b.PPK(name + "@tw")
+		return name + "-tw.ppk", out, err
+	case ExportJSON:
+		out, err := b.JSON(name)
+		return name + "-tw.json", out, err
+	case ExportXray:
+		out, err := b.XrayClient(name)
+		return name + "-tw-xray.zip", out, err
+	}
+	return "", nil, fmt.Errorf("unknown export format %q (use %s)", format, strings.Join(ExportFormats, ", "))
+}
+
+// hostKeyAlias is the name the server's host key is known by to OpenSSH,
+// as in PlainSSHCommand.
+func (b *Bundle) hostKeyAlias() string {
+	return "tw-" + b.Xray.RelayHost
+}
+
+// exportFile is a file of an exported zip.
+type exportFile struct {
+	name string
+	data []byte
+	mode os.FileMode
+}
+
+// OpenSSH returns a zip to unpack into ~/.ssh, holding tw/config (an
+// ssh_config Host block for the user, to Include from ~/.ssh/config),
+// tw/known_hosts with the server's host key, and the user's key.
+func (b *Bundle) OpenSSH(name string) ([]byte, error) {
+	return zipFiles(b.sshFiles(name, "tw/"))
+}
+
+// sshFiles returns the files of the OpenSSH layout, their names under dir.
+func (b *Bundle) sshFiles(name, dir string) []exportFile {
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# Tunnel Whisperer: %s via %s\n", name, b.Xray.RelayHost)
+	fmt.Fprintf(&conf, "# Add \"Include tw/config\" to the top of ~/.ssh/config, start the relay\n")
+	fmt.Fprintf(&conf, "# tunnel on 127.0.0.1:%d, then run: ssh -N tw-%s\n", twxray.ClientListenPort, name)
+	fmt.Fprintf(&conf, "Host tw-%s\n", name)
+	fmt.Fprintf(&conf, "  HostName 127.0.0.1\n")
+	fmt.Fprintf(&conf, "  Port %d\n", twxray.ClientListenPort)
+	fmt.Fprintf(&conf, "  User %s\n", b.Client.SSHUser)
+	fmt.Fprintf(&conf, "  HostKeyAlias %s\n", b.hostKeyAlias())
+	fmt.Fprintf(&conf, "  UserKnownHostsFile ~/.ssh/tw/known_hosts\n")
+	switch {
+	case b.PrivateKey != nil:
+		fmt.Fprintf(&conf, "  IdentityFile ~/.ssh/tw/%s\n", name)
+		fmt.Fprintf(&conf, "  IdentitiesOnly yes\n")
+	case b.KeyHandle != nil:
+		fmt.Fprintf(&conf, "  IdentityFile ~/.ssh/tw/%s\n", name)
+	default:
+		fmt.Fprintf(&conf, "  # Point IdentityFile at your own private key, or leave it to ssh-agent.\n")
+	}
+	fmt.Fprintf(&conf, "  ServerAliveInterval 15\n")
+	fmt.Fprintf(&conf, "  ExitOnForwardFailure yes\n")
+	for _, t := range b.Client.EnabledTunnels() {
+		fmt.Fprintf(&conf, "  LocalForward %s %s:%d\n", t.ListenAddr(), t.RemoteHost, t.RemotePort)
+	}
+
+	files := []exportFile{{dir + "config", []byte(conf.String()), 0644}}
+	if b.Client.ServerHostKey != "" {
+		files = append(files, exportFile{dir + "known_hosts", []byte(b.hostKeyAlias() + " " + b.Client.ServerHostKey + "\n"), 0644})
+	}
+	key := b.PrivateKey
+	if key == nil {
+		key = b.KeyHandle
+	}
+	if key != nil {
+		files = append(files, exportFile{dir + name, key, 0600})
+	}
+	if b.PublicKey != nil {
+		files = append(files, exportFile{dir + name + ".pub", b.PublicKey, 0644})
+	}
+	return files
+}
+
+// XrayClient returns a zip for a user who runs a proxy app such as v2rayN,
+// v2rayNG or Nekoray: xray.json, a config to import that forwards
+// 127.0.0.1:ClientListenPort to the server's SSH through the relay;
+// vless.txt, the relay connection as a VLESS share link; and under ssh/tw
+// the OpenSSH layout, which connects through the former.
+func (b *Bundle) XrayClient(name string) ([]byte, error) {
+	xc, err := twxray.ExportClientConfig(b.Xray, b.Client)
+	if err != nil {
+		return nil, err
+	}
+	link, err := twxray.ShareLink(b.Xray, "tw-"+name)
+	if err != nil {
+		return nil, err
+	}
+	readme := fmt.Sprintf(xrayExportReadme, twxray.ClientListenPort, name, b.Client.ServerSSHPort)
+	files := []exportFile{
+		{"README.txt", []byte(readme), 0644},
+		{"xray.json", append(xc, '\n'), 0600},
+		{"vless.txt", []byte(link + "\n"), 0600},
+	}
+	return zipFiles(append(files, b.sshFiles(name, "ssh/tw/")...))
+}
+
+// xrayExportReadme goes into the zip of XrayClient.
+const xrayExportReadme = `Connecting with your own proxy app
+==================================
+
+1. Import xray.json into v2rayN, v2rayNG, Nekoray or another Xray client
+   as a custom config, and start it. It listens on 127.0.0.1:%[1]d and
+   forwards that port to the server's SSH through the relay.
+
+2. Copy the ssh/tw folder to ~/.ssh/tw, add "Include tw/config" to the
+   top of ~/.ssh/config, and run:
+
+     ssh -N tw-%[2]s
+
+   which opens your port forwards. PuTTY users: export the putty format
+   too, and connect to 127.0.0.1 port %[1]d.
+
+vless.txt holds the same relay connection as a VLESS share link, for
+apps that import links rather than configs. With it, the app's SOCKS
+port (10808 in v2rayN) reaches the server's SSH as 127.0.0.1:%[3]d, so
+in ~/.ssh/tw/config replace the HostName and Port lines with:
+
+  ProxyCommand nc -X 5 -x 127.0.0.1:10808 127.0.0.1 %[3]d
+
+and make sure the app's routing sends 127.0.0.1 through the proxy
+rather than directly, which many apps do for private addresses.
+
+These files hold your relay credentials and SSH key: keep them private.
+`
+
+// zipFiles returns a zip of files.
+func zipFiles(files []exportFile) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		hdr.SetMode(f.mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PPK returns the user's private key as an unencrypted PuTTY key file,
+// version 2, which PuTTY 0.68 and later, WinSCP and plink read.
+func (b *Bundle) PPK(comment string) ([]byte, error) {
+	if b.PrivateKey == nil {
+		return nil, errors.New("the bundle holds no private key to convert (a security key or the user's own key)")
+	}
+	raw, err := gossh.ParseRawPrivateKey(b.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	var priv ed25519.PrivateKey
+	switch k := raw.(type) {
+	case ed25519.PrivateKey:
+		priv = k
+	case *ed25519.PrivateKey:
+		priv = *k
+	default:
+		return nil, fmt.Errorf("cannot convert a %T key to PuTTY format", raw)
+	}
+	pub, err := gossh.NewPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	const algo, encryption = gossh.KeyAlgoED25519, "none"
+	pubBlob := pub.Marshal()
+	privBlob := sshString(priv.Seed())
+
+	macKey := sha1.Sum([]byte("putty-private-key-file-mac-key"))
+	mac := hmac.New(sha1.New, macKey[:])
+	for _, s := range [][]byte{[]byte(algo), []byte(encryption), []byte(comment), pubBlob, privBlob} {
+		mac.Write(sshString(s))
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "PuTTY-User-Key-File-2: %s\r\n", algo)
+	fmt.Fprintf(&out, "Encryption: %s\r\n", encryption)
+	fmt.Fprintf(&out, "Comment: %s\r\n", comment)
+	writePPKLines(&out, "Public-Lines", pubBlob)
+	writePPKLines(&out, "Private-Lines", privBlob)
+	fmt.Fprintf(&out, "Private-MAC: %s\r\n", hex.EncodeToString(mac.Sum(nil)))
+	return out.Bytes(), nil
+}
+
+// writePPKLines writes data base64-encoded in 64-character lines, after a
+// count of them.
+func writePPKLines(w *bytes.Buffer, label string, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	n := (len(enc) + 63) / 64
+	fmt.Fprintf(w, "%s: %d\r\n", label, n)
+	for i := 0; i < len(enc); i += 64 {
+		fmt.Fprintf(w, "%s\r\n", enc[i:min(i+64, len(enc))])
+	}
+}
+
+// sshString encodes s as an SSH wire-format string.
+func sshString(s []byte) []byte {
+	out := make([]byte, 4+len(s))
+	binary.BigEndian.PutUint32(out, uint32(len(s)))
+	copy(out[4:], s)
+	return out
+}
+
+// BundleDescriptor is the JSON export of a bundle.
+type BundleDescriptor struct {
+	User  string `json:"user"`
+	Relay struct {
+		Host      string `json:"host"`
+		Port      int    `json:"port"`
+		Path      string `json:"path"`
+		Transport string `json:"transport"`
+		UUID      string `json:"uuid"`
+		// TLSFingerprint and ALPN shape the TLS handshake, as
+		// xray.tls in config.yaml.
+		TLSFingerprint string   `json:"tls_fingerprint,omitempty"`
+		ALPN           []string `json:"alpn,omitempty"`
+		ShareLink      string   `json:"share_link,omitempty"` // as VLESS share link
+	} `json:"relay"`
+	SSH struct {
+		Host         string `json:"host"` // where the relay tunnel listens
+		Port         int    `json:"port"`
+		User         string `json:"user"`
+		HostKeyAlias string `json:"host_key_alias"`
+		HostKey      string `json:"host_key,omitempty"`
+		PublicKey    string `json:"public_key,omitempty"`
+		Fingerprint  string `json:"fingerprint,omitempty"`
+		PrivateKey   string `json:"private_key,omitempty"` // OpenSSH PEM
+		SecurityKey  bool   `json:"security_key,omitempty"`
+		BundleFormat int    `json:"bundle_format"`
+	} `json:"ssh"`
+	Tunnels []BundleTunnel `json:"tunnels"`
+}
+
+// BundleTunnel is one port forward of a BundleDescriptor.
+type BundleTunnel struct {
+	Name       string `json:"name,omitempty"`
+	Listen     string `json:"listen"`
+	RemoteHost string `json:"remote_host"`
+	RemotePort int    `json:"remote_port"`
+}
+
+// JSON returns the bundle as an indented BundleDescriptor.
+func (b *Bundle) JSON(name string) ([]byte, error) {
+	d := BundleDescriptor{User: name, Tunnels: []BundleTunnel{}}
+	d.Relay.Host = b.Xray.RelayHost
+	d.Relay.Port = b.Xray.RelayPort
+	d.Relay.Path = b.Xray.Path
+	d.Relay.Transport = b.Xray.Transport
+	if d.Relay.Transport == "" {
+		d.Relay.Transport = "splithttp"
+	}
+	d.Relay.UUID = b.Xray.UUID
+	d.Relay.TLSFingerprint = b.Xray.TLS.Fingerprint
+	d.Relay.ALPN = b.Xray.TLS.ALPN
+	d.Relay.ShareLink, _ = twxray.ShareLink(b.Xray, "tw-"+name)
+	d.SSH.Host = "127.0.0.1"
+	d.SSH.Port = twxray.ClientListenPort
+	d.SSH.User = b.Client.SSHUser
+	d.SSH.HostKeyAlias = b.hostKeyAlias()
+	d.SSH.HostKey = b.Client.ServerHostKey
+	d.SSH.PublicKey = strings.TrimSpace(string(b.PublicKey))
+	d.SSH.Fingerprint = keyFingerprint(b.PublicKey)
+	d.SSH.PrivateKey = string(b.PrivateKey)
+	d.SSH.SecurityKey = b.KeyHandle != nil
+	d.SSH.BundleFormat = b.Client.BundleFormat
+	for _, t := range b.Client.EnabledTunnels() {
+		d.Tunnels = append(d.Tunnels, BundleTunnel{Name: t.Name, Listen: t.ListenAddr(), RemoteHost: t.RemoteHost, RemotePort: t.RemotePort})
+	}
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
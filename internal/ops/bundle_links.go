@@ -0,0 +1,561 @@
+package ops
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+	"github.com/tunnelwhisperer/tw/internal/relay/caddy"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A bundle link lets a user fetch their config bundle from
+// https://<relay domain>/claim/<token>, from any network, instead of
+// having it sent by email or chat. Caddy on the relay proxies /claim/ to a
+// relay loopback port, the reverse tunnel forwards that to the bundle link
+// server on the server's loopback, and the server answers with the bundle
+// of the token's user. Only the SHA-256 of a token is kept, in
+// bundleLinksFile; the link is shown once, when it is made.
+
+// bundleLinksFile holds the bundle links, in the config directory.
+const bundleLinksFile = "bundle_links.json"
+
+// bundleLinkPath is the relay path bundle links live under.
+const bundleLinkPath = "/claim/"
+
+// bundleLinkRoute names the relay's Caddy route file for bundle links.
+const bundleLinkRoute = "claim"
+
+// DefaultBundleLinkTTL is how long a bundle link works unless told
+// otherwise, and MaxBundleLinkTTL the longest it may.
+const (
+	DefaultBundleLinkTTL = 24 * time.Hour
+	MaxBundleLinkTTL     = 30 * 24 * time.Hour
+)
+
+// BundleLink is a link a user's config bundle can be fetched from.
+type BundleLink struct {
+	ID       string     `json:"id"`
+	User     string     `json:"user"`
+	Hash     string     `json:"hash,omitempty"` // hex SHA-256 of the token; left out of listings
+	Created  time.Time  `json:"created"`
+	Expires  time.Time  `json:"expires"`
+	MaxUses  int        `json:"max_uses,omitempty"` // 0: any number until it expires
+	Uses     int        `json:"uses"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// live reports whether the link still works at now.
+func (l BundleLink) live(now time.Time) bool {
+	return now.Before(l.Expires) && (l.MaxUses == 0 || l.Uses < l.MaxUses)
+}
+
+// bundleLinksMu serializes changes to bundleLinksFile within a process.
+var bundleLinksMu sync.Mutex
+
+func bundleLinksPath() string {
+	return filepath.Join(config.Dir(), bundleLinksFile)
+}
+
+// loadBundleLinks reads the links that still work. bundleLinksMu must be
+// held.
+func loadBundleLinks() ([]BundleLink, error) {
+	data, err := os.ReadFile(bundleLinksPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle links: %w", err)
+	}
+	var links []BundleLink
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, fmt.Errorf("reading bundle links: %w", err)
+	}
+	now := time.Now()
+	live := links[:0]
+	for _, l := range links {
+		if l.live(now) {
+			live = append(live, l)
+		}
+	}
+	return live, nil
+}
+
+// saveBundleLinks writes links. bundleLinksMu must be held.
+func saveBundleLinks(links []BundleLink) error {
+	if links == nil {
+		links = []BundleLink{}
+	}
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(bundleLinksPath(), append(data, '\n'), 0600)
+}
+
+// bundleLinkHash returns the hash a token is kept as.
+func bundleLinkHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BundleLinksEnabled reports whether the relay serves bundle links.
+func (o *Ops) BundleLinksEnabled() bool {
+	return o.Config().Server.BundleLinks != nil
+}
+
+// CreateBundleLink makes a link the user name can fetch their config
+// bundle from for ttl (DefaultBundleLinkTTL when 0), at most maxUses times
+// unless maxUses is 0. It returns the link and its URL, which is not kept
+// and can't be shown again.
+func (o *Ops) CreateBundleLink(name string, ttl time.Duration, maxUses int) (*BundleLink, string, error) {
+	if err := validateName(name); err != nil {
+		return nil, "", fmt.Errorf("user name %w", err)
+	}
+	cfg := o.Config()
+	if cfg.Server.BundleLinks == nil {
+		return nil, "", errors.New("bundle links are off; turn them on first (tw link enable)")
+	}
+	if ttl == 0 {
+		ttl = DefaultBundleLinkTTL
+	}
+	if ttl < time.Hour || ttl > MaxBundleLinkTTL {
+		return nil, "", fmt.Errorf("a bundle link must work for between an hour and %d days", int(MaxBundleLinkTTL.Hours()/24))
+	}
+	if maxUses < 0 {
+		return nil, "", fmt.Errorf("invalid number of uses %d", maxUses)
+	}
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); err != nil {
+		return nil, "", fmt.Errorf("user %q not found", name)
+	}
+	if _, ok := readInvite(userDir); ok {
+		return nil, "", fmt.Errorf("user %q has not claimed their invitation yet; send them the claim code instead", name)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now().UTC().Truncate(time.Second)
+	link := BundleLink{
+		ID:      bundleLinkHash(token)[:8],
+		User:    name,
+		Hash:    bundleLinkHash(token),
+		Created: now,
+		Expires: now.Add(ttl),
+		MaxUses: maxUses,
+	}
+
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := saveBundleLinks(append(links, link)); err != nil {
+		return nil, "", fmt.Errorf("saving bundle link: %w", err)
+	}
+	slog.Info("bundle link created", "user", name, "id", link.ID, "expires", link.Expires)
+
+	link.Hash = ""
+	return &link, "https://" + cfg.Xray.RelayHost + bundleLinkPath + token, nil
+}
+
+// ListBundleLinks returns the links that still work, of the user name or
+// of everyone when name is empty.
+func (o *Ops) ListBundleLinks(name string) ([]BundleLink, error) {
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil {
+		return nil, err
+	}
+	out := []BundleLink{}
+	for _, l := range links {
+		if name == "" || l.User == name {
+			l.Hash = ""
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// RevokeBundleLink stops the link id from working.
+func (o *Ops) RevokeBundleLink(id string) error {
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil {
+		return err
+	}
+	for i, l := range links {
+		if l.ID == id {
+			if err := saveBundleLinks(append(links[:i:i], links[i+1:]...)); err != nil {
+				return err
+			}
+			slog.Info("bundle link revoked", "user", l.User, "id", id)
+			return nil
+		}
+	}
+	return fmt.Errorf("no bundle link %q", id)
+}
+
+// updateUserBundleLinks hands the links of the user name to newName, or
+// revokes them when newName is empty, as the user is renamed or deleted.
+func updateUserBundleLinks(name, newName string) {
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil || len(links) == 0 {
+		return
+	}
+	kept := links[:0]
+	for _, l := range links {
+		if l.User == name {
+			if newName == "" {
+				continue
+			}
+			l.User = newName
+		}
+		kept = append(kept, l)
+	}
+	if err := saveBundleLinks(kept); err != nil {
+		slog.Warn("could not update bundle links", "user", name, "error", err)
+	}
+}
+
+// redeemBundleLink counts a use of the link of token and returns the link
+// as it was before, for unredeemBundleLink. The use is counted up front so
+// two requests can't both take the last one.
+func redeemBundleLink(token string) (BundleLink, bool) {
+	hash := bundleLinkHash(token)
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil {
+		slog.Warn("reading bundle links", "error", err)
+		return BundleLink{}, false
+	}
+	for i := range links {
+		if subtle.ConstantTimeCompare([]byte(links[i].Hash), []byte(hash)) == 1 {
+			prev := links[i]
+			now := time.Now().UTC().Truncate(time.Second)
+			links[i].Uses++
+			links[i].LastUsed = &now
+			if err := saveBundleLinks(links); err != nil {
+				slog.Warn("could not count a bundle link use", "error", err)
+			}
+			return prev, true
+		}
+	}
+	return BundleLink{}, false
+}
+
+// unredeemBundleLink gives back the use redeemBundleLink counted on the
+// link prev when its bundle could not be served, so a failure doesn't
+// burn a single-use link.
+func unredeemBundleLink(prev BundleLink) {
+	bundleLinksMu.Lock()
+	defer bundleLinksMu.Unlock()
+	links, err := loadBundleLinks()
+	if err != nil {
+		slog.Warn("could not give back a bundle link use", "error", err)
+		return
+	}
+	i := slices.IndexFunc(links, func(l BundleLink) bool { return l.Hash == prev.Hash })
+	switch {
+	case i >= 0:
+		links[i].Uses--
+		if links[i].Uses == prev.Uses {
+			links[i].LastUsed = prev.LastUsed
+		}
+	case prev.MaxUses != 0 && prev.Uses+1 == prev.MaxUses && prev.live(time.Now()):
+		// The use used it up, so it was dropped; a link that is gone
+		// otherwise was revoked and stays gone.
+		links = append(links, prev)
+	default:
+		return
+	}
+	if err := saveBundleLinks(links); err != nil {
+		slog.Warn("could not give back a bundle link use", "error", err)
+	}
+}
+
+// bundleLinkHandler serves GET /claim/<token>, with GetUserConfigBundle's
+// bundle for the token's user, converted by ?format= as ExportBundle does.
+func (o *Ops) bundleLinkHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := r.Header.Get("X-Forwarded-For")
+		if remote == "" {
+			remote = r.RemoteAddr
+		}
+		token, ok := strings.CutPrefix(r.URL.Path, bundleLinkPath)
+		if !ok || r.Method != http.MethodGet || token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		link, ok := redeemBundleLink(token)
+		if !ok {
+			slog.Warn("unknown or expired bundle link requested", "remote", remote)
+			http.Error(w, "This link is unknown, used up or expired. Ask for a new one.", http.StatusNotFound)
+			return
+		}
+		name := link.User
+		data, err := o.GetUserConfigBundle(name)
+		if err == nil {
+			var filename string
+			if filename, data, err = ExportBundle(name, data, r.URL.Query().Get("format")); err == nil {
+				contentType := "application/zip"
+				switch {
+				case strings.HasSuffix(filename, ".json"):
+					contentType = "application/json"
+				case strings.HasSuffix(filename, ".ppk"):
+					contentType = "application/octet-stream"
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+				w.Header().Set("Cache-Control", "no-store")
+				w.Write(data)
+				slog.Info("bundle fetched through a bundle link", "user", name, "remote", remote)
+				return
+			}
+		}
+		slog.Warn("bundle link could not be served", "user", name, "error", err)
+		unredeemBundleLink(link)
+		http.Error(w, "The bundle could not be made: "+err.Error(), http.StatusInternalServerError)
+	})
+}
+
+// IsBundleLink reports whether s is a bundle link URL rather than a
+// compact bundle string.
+func IsBundleLink(s string) bool {
+	return strings.HasPrefix(s, "https://") && strings.Contains(s, bundleLinkPath)
+}
+
+// FetchBundleLink downloads the zip bundle from the bundle link url, using
+// up one of its uses.
+func FetchBundleLink(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle link: %w", err)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching bundle: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetching bundle: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching bundle: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// EnableBundleLinks makes the relay serve bundle links: it adds a Caddy
+// route for /claim/ to the relay domain, proxying to a relay loopback
+// port, and a reverse forward from that port to the bundle link server,
+// which starts with the server.
+func (o *Ops) EnableBundleLinks(ctx context.Context, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	if o.cfg.Xray.RelayHost == "" {
+		return fmt.Errorf("no relay configured — provision one before turning on bundle links")
+	}
+	if o.cfg.Server.BundleLinks != nil {
+		return fmt.Errorf("bundle links are already on")
+	}
+	// A free loopback port for the link server, kept in the config.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("finding a port for the bundle link server: %w", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	links := &config.BundleLinksConfig{RelayPort: nextPublishRelayPort(o.usedRelayPorts()), Port: port}
+
+	const total = 2
+	step := publishStep(progress, total)
+
+	err = withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		return step(1, "Relay route", func() (string, error) {
+			return addRelayCaddyRoute(client, caddy.RouteConfig{
+				Name:     bundleLinkRoute,
+				Path:     bundleLinkPath,
+				Upstream: fmt.Sprintf("127.0.0.1:%d", links.RelayPort),
+			})
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("updating relay: %w", err)
+	}
+
+	return step(2, "Reverse forward", func() (string, error) {
+		o.cfg.Server.BundleLinks = links
+		if err := config.Save(o.cfg); err != nil {
+			return "", err
+		}
+		msg := fmt.Sprintf("https://%s%s → 127.0.0.1:%d", o.cfg.Xray.RelayHost, bundleLinkPath, links.Port)
+		live := o.updateServerForwards(func(rt *twssh.ReverseTunnel) error {
+			return rt.AddForward(bundleLinkForward(links))
+		})
+		if !live {
+			return msg + " (starts with the server)", nil
+		}
+		if err := o.srv.startBundleLinks(o, links.Port); err != nil {
+			return "", err
+		}
+		return msg, nil
+	})
+}
+
+// DisableBundleLinks removes the relay's /claim/ route and the reverse
+// forward, and revokes every bundle link.
+func (o *Ops) DisableBundleLinks(ctx context.Context, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	links := o.cfg.Server.BundleLinks
+	if links == nil {
+		return fmt.Errorf("bundle links are off")
+	}
+
+	const total = 2
+	step := publishStep(progress, total)
+
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		return step(1, "Relay route", func() (string, error) {
+			if err := removeRelayCaddyRoute(client, bundleLinkRoute); err != nil {
+				return "", err
+			}
+			return bundleLinkPath + " route removed", nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("updating relay: %w", err)
+	}
+
+	return step(2, "Reverse forward", func() (string, error) {
+		o.cfg.Server.BundleLinks = nil
+		if err := config.Save(o.cfg); err != nil {
+			return "", err
+		}
+		o.updateServerForwards(func(rt *twssh.ReverseTunnel) error {
+			return rt.RemoveForward(links.RelayPort)
+		})
+		o.srv.stopBundleLinks()
+		bundleLinksMu.Lock()
+		defer bundleLinksMu.Unlock()
+		if err := os.Remove(bundleLinksPath()); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return "removed; all bundle links revoked", nil
+	})
+}
+
+// bundleLinkForward is the reverse forward of the bundle link server.
+func bundleLinkForward(links *config.BundleLinksConfig) twssh.ReverseForward {
+	return twssh.ReverseForward{
+		Name:       "bundle-links",
+		RemotePort: links.RelayPort,
+		LocalAddr:  fmt.Sprintf("127.0.0.1:%d", links.Port),
+	}
+}
+
+// startBundleLinks starts the bundle link server on the loopback port, if
+// it isn't running.
+func (m *serverManager) startBundleLinks(o *Ops, port int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.links != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("starting the bundle link server: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           o.bundleLinkHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	m.links = srv
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("bundle link server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// stopBundleLinks stops the bundle link server.
+func (m *serverManager) stopBundleLinks() {
+	m.mu.Lock()
+	srv := m.links
+	m.links = nil
+	m.mu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+}
+
+// addRelayCaddyRoute writes the route block for route.Name on the relay
+// and reloads Caddy. Relays provisioned before routes existed get the
+// routes import added to the relay domain's site block first, above its
+// tunnel proxy. A route Caddy rejects is removed again.
+func addRelayCaddyRoute(client *gossh.Client, route caddy.RouteConfig) (string, error) {
+	block, err := caddy.RenderRoute(route)
+	if err != nil {
+		return "", fmt.Errorf("rendering route: %w", err)
+	}
+	setup := fmt.Sprintf("sudo mkdir -p %[1]s && (grep -qF '%[2]s' /etc/caddy/Caddyfile || sudo sed -i 's#^\\([[:space:]]*\\)reverse_proxy .* 127\\.0\\.0\\.1:10000$#\\1%[2]s\\n&#' /etc/caddy/Caddyfile) && grep -qF '%[2]s' /etc/caddy/Caddyfile",
+		caddy.RoutesDir, caddy.RoutesImport)
+	if err := runRelayCommand(client, setup); err != nil {
+		return "", fmt.Errorf("adding the routes import to the relay's Caddyfile: %w", err)
+	}
+
+	path := caddy.RoutePath(route.Name)
+	if err := writeRelayFile(client, path, block); err != nil {
+		return "", err
+	}
+	if err := runRelayCommand(client, "sudo systemctl reload caddy"); err != nil {
+		runRelayCommand(client, "sudo rm -f "+path+" && sudo systemctl reload caddy")
+		return "", fmt.Errorf("caddy rejected the route: %w", err)
+	}
+	return fmt.Sprintf("%s → %s", route.Path, route.Upstream), nil
+}
+
+// removeRelayCaddyRoute deletes the route block name and reloads Caddy.
+func removeRelayCaddyRoute(client *gossh.Client, name string) error {
+	return runRelayCommand(client, "sudo rm -f "+caddy.RoutePath(name)+" && sudo systemctl reload caddy")
+}
@@ -0,0 +1,211 @@
+package ops
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/proxyauth"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// certWarnBefore is how close to expiry a relay certificate has to be
+	// before it is flagged. Caddy renews Let's Encrypt certificates with
+	// about 30 days left, so a certificate inside this window means renewal
+	// has been failing for weeks.
+	certWarnBefore = 14 * 24 * time.Hour
+
+	// certCheckInterval is how often the running server re-checks the
+	// relay certificates. The first check runs certFirstCheck after start.
+	certCheckInterval = 6 * time.Hour
+	certFirstCheck    = time.Minute
+
+	// certReloadCooldown limits automatic Caddy reloads to one per host per
+	// day, so a renewal that keeps failing doesn't hammer the relay or the
+	// CA's rate limits.
+	certReloadCooldown = 24 * time.Hour
+)
+
+// RelayCert describes the TLS certificate the relay serves for one host:
+// the relay domain, or a hostname published with `tw publish --host`.
+type RelayCert struct {
+	Host       string     `json:"host"`
+	Issuer     string     `json:"issuer,omitempty"`
+	NotBefore  *time.Time `json:"not_before,omitempty"` // nil when no certificate was fetched
+	NotAfter   *time.Time `json:"not_after,omitempty"`
+	DaysLeft   int        `json:"days_left"`
+	Expiring   bool       `json:"expiring,omitempty"` // less than certWarnBefore left
+	Error      string     `json:"error,omitempty"`    // unreachable or untrusted
+	CheckedAt  time.Time  `json:"checked_at"`
+	ReloadedAt *time.Time `json:"reloaded_at,omitempty"` // last automatic Caddy reload
+}
+
+// CheckRelayCerts fetches the certificate served for the relay domain and
+// for every hostname published on the relay. When the relay is behind a CDN
+// the relay domain is checked at xray.origin_ip, since the CDN presents its
+// own certificate and Caddy's is the one that has to be renewed.
+func (o *Ops) CheckRelayCerts() []RelayCert {
+	cfg := o.Config()
+	if cfg.Xray.RelayHost == "" {
+		return nil
+	}
+	addr := cfg.Xray.RelayHost
+	if cfg.Xray.OriginIP != "" {
+		addr = cfg.Xray.OriginIP
+	}
+	certs := []RelayCert{inspectRelayCert(cfg, cfg.Xray.RelayHost, addr, cfg.Xray.RelayPort)}
+	for _, f := range cfg.Server.ReverseForwards {
+		if f.Host != "" {
+			certs = append(certs, inspectRelayCert(cfg, f.Host, f.Host, 443))
+		}
+	}
+	return certs
+}
+
+// ReloadRelayCaddy reloads Caddy on the relay. Caddy re-runs certificate
+// maintenance on reload, which retries a renewal that is stuck.
+func (o *Ops) ReloadRelayCaddy() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		return runRelayCommand(client, "sudo systemctl reload caddy")
+	})
+}
+
+// inspectRelayCert makes an HTTPS request for host to addr:port, through the
+// proxy if the proxy rules send addr there, and records the leaf certificate. Verification
+// is done separately so the dates are known even for an untrusted or expired
+// certificate.
+func inspectRelayCert(cfg *config.Config, host, addr string, port int) RelayCert {
+	rc := RelayCert{Host: host, CheckedAt: time.Now()}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: host, InsecureSkipVerify: true},
+	}
+	proxyURL, _ := relayProxy(cfg, addr)
+	if proxy := twxray.ProxyFor(proxyURL, cfg.ProxyRules, addr); proxy != "" {
+		u, stop, err := proxyauth.ForHTTP(proxy)
+		if err != nil {
+			rc.Error = err.Error()
+			return rc
+		}
+		defer stop()
+		transport.Proxy = http.ProxyURL(u)
+	}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport, Timeout: 15 * time.Second}
+
+	req, err := http.NewRequest(http.MethodHead, "https://"+net.JoinHostPort(addr, strconv.Itoa(port))+"/", nil)
+	if err != nil {
+		rc.Error = err.Error()
+		return rc
+	}
+	req.Host = host
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		rc.Error = err.Error()
+		return rc
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		rc.Error = "no certificate presented"
+		return rc
+	}
+
+	leaf := resp.TLS.PeerCertificates[0]
+	rc.Issuer = leaf.Issuer.CommonName
+	rc.NotBefore = &leaf.NotBefore
+	rc.NotAfter = &leaf.NotAfter
+	left := time.Until(leaf.NotAfter)
+	rc.DaysLeft = int(left.Hours() / 24)
+	rc.Expiring = left < certWarnBefore
+
+	intermediates := x509.NewCertPool()
+	for _, c := range resp.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates}); err != nil {
+		rc.Error = fmt.Sprintf("certificate not trusted: %v", err)
+	}
+	return rc
+}
+
+// runCertMonitor checks the relay certificates periodically until stop is
+// closed, logging a warning for each one that is expiring or failing. When
+// server.cert_auto_reload is set, an expiring certificate — one Caddy
+// should long since have renewed — triggers a Caddy reload on the relay.
+func (o *Ops) runCertMonitor(stop <-chan struct{}) {
+	timer := time.NewTimer(certFirstCheck)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		o.checkCertsOnce()
+		timer.Reset(certCheckInterval)
+	}
+}
+
+// checkCertsOnce runs one round of the certificate monitor and stores the
+// results for ServerStatus.
+func (o *Ops) checkCertsOnce() {
+	certs := o.CheckRelayCerts()
+
+	o.srv.mu.Lock()
+	prev := make(map[string]*time.Time, len(o.srv.certs))
+	for _, c := range o.srv.certs {
+		prev[c.Host] = c.ReloadedAt
+	}
+	o.srv.mu.Unlock()
+
+	reload := false
+	for i := range certs {
+		c := &certs[i]
+		c.ReloadedAt = prev[c.Host]
+		switch {
+		case c.NotAfter == nil:
+			slog.Warn("could not check relay TLS certificate", "host", c.Host, "error", c.Error)
+			o.notify(NotifyCertError, "error", c.Host, "Relay certificate can't be checked", c.Host+": "+c.Error)
+		case c.Expiring:
+			slog.Warn("relay TLS certificate expires soon, renewal appears stuck",
+				"host", c.Host, "not_after", c.NotAfter.Format(time.RFC3339), "days_left", c.DaysLeft)
+			o.notify(NotifyCertExpiring, "warning", c.Host, "Relay certificate expires soon",
+				fmt.Sprintf("%s expires in %d days; renewal appears stuck", c.Host, c.DaysLeft))
+			if c.ReloadedAt == nil || time.Since(*c.ReloadedAt) > certReloadCooldown {
+				reload = true
+			}
+		case c.Error != "":
+			slog.Warn("relay TLS certificate problem", "host", c.Host, "error", c.Error)
+		default:
+			slog.Debug("relay TLS certificate ok", "host", c.Host, "days_left", c.DaysLeft)
+		}
+	}
+
+	if reload && o.Config().Server.CertAutoReload {
+		slog.Info("reloading Caddy on the relay to retry certificate renewal")
+		if err := o.ReloadRelayCaddy(); err != nil {
+			slog.Warn("reloading relay Caddy failed", "error", err)
+		} else {
+			now := time.Now()
+			for i := range certs {
+				if certs[i].Expiring {
+					certs[i].ReloadedAt = &now
+				}
+			}
+		}
+	}
+
+	o.srv.mu.Lock()
+	o.srv.certs = certs
+	o.srv.mu.Unlock()
+}
@@ -3,7 +3,9 @@ package ops
 import (
 	"fmt"
 	"log/slog"
-	"path/filepath"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -19,6 +21,19 @@ type ClientStatus struct {
 	Tunnel      bool        `json:"tunnel"`
 	Error       string      `json:"error,omitempty"`
 	TunnelError string      `json:"tunnel_error,omitempty"`
+
+	// Components lists the client's components, as in ServerStatus.
+	Components []ComponentStatus `json:"components,omitempty"`
+
+	Tunnels []twssh.MappingStats `json:"tunnels,omitempty"` // per-mapping state
+
+	// PlainSSH is the ssh command to run when the port forwarding is left
+	// to OpenSSH (client.plain_ssh).
+	PlainSSH string `json:"plain_ssh,omitempty"`
+
+	// Compat compares this tw and its bundle with the server's, once
+	// connected to a server that advertises them.
+	Compat *Compat `json:"compat,omitempty"`
 }
 
 // clientManager controls the lifecycle of client components.
@@ -27,19 +42,41 @@ type clientManager struct {
 	state    ServerState
 	lastErr  string
 	cfgHash  string // config hash at startup, for change detection
+	cfgData  []byte // config file at startup, the base for edit previews
 	xrayInst *twxray.Instance
 	tunnel   *twssh.ForwardTunnel
+	plainSSH string // ssh command, when the forwarding is left to OpenSSH
+	compat   *Compat
+
+	stop       chan struct{} // closed by Stop, ends supervision
+	xrayComp   *component
+	tunnelComp *component
+	probes     probeCache
+
+	events *statusHub
+}
+
+// setState changes the lifecycle state and publishes the change. Callers
+// hold m.mu and set lastErr first.
+func (m *clientManager) setState(s ServerState) {
+	if m.state == s {
+		return
+	}
+	m.state = s
+	m.events.publish(StatusEvent{Type: "state", Source: "client", State: s, Error: m.lastErr})
 }
 
 // Start launches the client connection (Xray client + forward tunnel).
-func (m *clientManager) Start(o *Ops, progress ProgressFunc) error {
+// With plain, or client.plain_ssh set, it starts only the Xray client and
+// reports the ssh command that forwards the tunnels instead.
+func (m *clientManager) Start(o *Ops, plain bool, progress ProgressFunc) error {
 	m.mu.Lock()
 	if m.state == StateRunning || m.state == StateStarting {
 		m.mu.Unlock()
 		return fmt.Errorf("client already %s", m.state)
 	}
-	m.state = StateStarting
 	m.lastErr = ""
+	m.setState(StateStarting)
 	m.mu.Unlock()
 
 	if progress == nil {
@@ -50,12 +87,13 @@ func (m *clientManager) Start(o *Ops, progress ProgressFunc) error {
 
 	m.mu.Lock()
 	m.cfgHash = config.FileHash()
+	m.cfgData, _ = os.ReadFile(config.FilePath())
 	m.mu.Unlock()
 
 	fail := func(step int, label string, err error) error {
 		m.mu.Lock()
-		m.state = StateError
 		m.lastErr = err.Error()
+		m.setState(StateError)
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: 3, Label: label, Status: "failed", Error: err.Error()})
 		return err
@@ -68,6 +106,17 @@ func (m *clientManager) Start(o *Ops, progress ProgressFunc) error {
 	if len(cfg.Client.Tunnels) == 0 {
 		return fail(1, "Config validation", fmt.Errorf("no tunnels defined in client.tunnels"))
 	}
+	tunnels := cfg.Client.EnabledTunnels()
+	if len(tunnels) == 0 {
+		return fail(1, "Config validation", fmt.Errorf("every tunnel in client.tunnels is disabled"))
+	}
+	var ports []ListenPort
+	for _, t := range tunnels {
+		ports = append(ports, ListenPort{"the local_port of tunnel " + t.Label(), t.LocalPort})
+	}
+	if err := CheckReservedPorts(ports...); err != nil {
+		return fail(1, "Config validation", err)
+	}
 
 	// Auto-generate UUID if missing.
 	if cfg.Xray.UUID == "" {
@@ -77,12 +126,22 @@ func (m *clientManager) Start(o *Ops, progress ProgressFunc) error {
 		}
 	}
 
-	// Step 1: Ensure keys.
+	// Step 1: Find the key. A client has none of its own to generate: the
+	// server only accepts the one in its bundle, or one in the SSH agent.
 	progress(ProgressEvent{Step: 1, Total: 3, Label: "SSH keys", Status: "running"})
-	if err := o.EnsureKeys(); err != nil {
+	key, err := clientKeyOrAgent(cfg)
+	if err != nil {
+		return fail(1, "SSH keys", fmt.Errorf("reading client key: %w", err))
+	}
+	hostKey, err := serverHostKey(cfg)
+	if err != nil {
 		return fail(1, "SSH keys", err)
 	}
-	progress(ProgressEvent{Step: 1, Total: 3, Label: "SSH keys", Status: "completed"})
+	keyMsg := ""
+	if key == nil {
+		keyMsg = "using the SSH agent"
+	}
+	progress(ProgressEvent{Step: 1, Total: 3, Label: "SSH keys", Status: "completed", Message: keyMsg})
 
 	// Step 2: Start Xray client.
 	progress(ProgressEvent{Step: 2, Total: 3, Label: "Xray tunnel", Status: "running"})
@@ -90,49 +149,78 @@ func (m *clientManager) Start(o *Ops, progress ProgressFunc) error {
 	if err != nil {
 		return fail(2, "Xray tunnel", err)
 	}
-	if err := xrayInstance.StartClient(cfg.Client, cfg.Proxy); err != nil {
+	proxyURL, source := relayProxy(cfg, cfg.Xray.RelayHost)
+	if source != "" && source != proxySourceConfig {
+		slog.Info("using detected proxy", "source", source, "proxy", proxyURL)
+	}
+	if err := xrayInstance.StartClient(cfg.Client, proxyURL, cfg.ProxyRules); err != nil {
 		return fail(2, "Xray tunnel", err)
 	}
 	m.mu.Lock()
 	m.xrayInst = xrayInstance
+	m.xrayComp = newComponent("Xray")
 	m.mu.Unlock()
+	m.probes.reset()
 	progress(ProgressEvent{Step: 2, Total: 3, Label: "Xray tunnel", Status: "completed", Message: fmt.Sprintf("%s:%d%s", cfg.Xray.RelayHost, cfg.Xray.RelayPort, cfg.Xray.Path)})
 
 	// Step 3: Start forward tunnel.
 	progress(ProgressEvent{Step: 3, Total: 3, Label: "Port forwarding", Status: "running"})
-	mappings := make([]twssh.Mapping, len(cfg.Client.Tunnels))
-	for i, t := range cfg.Client.Tunnels {
-		mappings[i] = twssh.Mapping{
-			LocalPort:  t.LocalPort,
-			RemoteHost: t.RemoteHost,
-			RemotePort: t.RemotePort,
-		}
+	if plain || cfg.Client.PlainSSH {
+		cmd := PlainSSHCommand(cfg, key == nil)
+		progress(ProgressEvent{Step: 3, Total: 3, Label: "Port forwarding", Status: "completed", Message: "left to OpenSSH: " + cmd})
+		m.mu.Lock()
+		m.plainSSH = cmd
+		m.setState(StateRunning)
+		m.mu.Unlock()
+		return nil
+	}
+	mappings := make([]twssh.Mapping, len(tunnels))
+	for i, t := range tunnels {
+		mappings[i] = tunnelMapping(t)
 	}
 
-	privPath := filepath.Join(config.Dir(), "id_ed25519")
 	ft := &twssh.ForwardTunnel{
 		RemoteAddr: fmt.Sprintf("127.0.0.1:%d", twxray.ClientListenPort),
 		User:       cfg.Client.SSHUser,
-		KeyPath:    privPath,
+		Key:        key,
+		UseAgent:   key == nil,
 		Mappings:   mappings,
+		Network:    networkOptions(cfg.Network),
+
+		RemapBusyPorts: cfg.Client.RemapBusyPorts,
+		TOTPCode:       clientTOTPCode(),
+		HostKey:        hostKey,
 	}
-	go func() {
-		if err := ft.Run(); err != nil {
-			slog.Error("forward tunnel error", "error", err)
+	stop := make(chan struct{})
+	refresh := make(chan struct{}, 1)
+	tunnelEvents := m.events.tunnelEvents("client")
+	ft.OnEvent = func(e twssh.TunnelEvent) {
+		tunnelEvents(e)
+		if e.Kind == "up" {
+			m.updateCompat(cfg, ft.ServerVersion())
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
 		}
-	}()
+	}
 	m.mu.Lock()
 	m.tunnel = ft
+	m.stop = stop
+	m.tunnelComp = supervise("Port forwarding", m.stop, restartable(ft.Stop, ft.Run))
 	m.mu.Unlock()
+	if every := cfg.Client.RefreshInterval(); every > 0 && cfg.Client.ServerHostKey != "" {
+		go m.refreshLoop(o, ft, refresh, stop, every)
+	}
 
 	var desc []string
-	for _, t := range cfg.Client.Tunnels {
-		desc = append(desc, fmt.Sprintf("localhost:%d → %s:%d", t.LocalPort, t.RemoteHost, t.RemotePort))
+	for _, t := range tunnels {
+		desc = append(desc, fmt.Sprintf("%s → %s:%d", t.ListenAddr(), t.RemoteHost, t.RemotePort))
 	}
 	progress(ProgressEvent{Step: 3, Total: 3, Label: "Port forwarding", Status: "completed", Message: fmt.Sprintf("%d tunnel(s) active", len(mappings))})
 
 	m.mu.Lock()
-	m.state = StateRunning
+	m.setState(StateRunning)
 	m.mu.Unlock()
 
 	return nil
@@ -145,7 +233,7 @@ func (m *clientManager) Stop(progress ProgressFunc) error {
 		m.mu.Unlock()
 		return fmt.Errorf("client not running (state: %s)", m.state)
 	}
-	m.state = StateStopping
+	m.setState(StateStopping)
 	m.mu.Unlock()
 
 	if progress == nil {
@@ -154,6 +242,10 @@ func (m *clientManager) Stop(progress ProgressFunc) error {
 
 	progress(ProgressEvent{Step: 1, Total: 2, Label: "Port forwarding", Status: "running"})
 	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
 	if m.tunnel != nil {
 		m.mu.Unlock()
 		m.tunnel.Stop()
@@ -175,31 +267,160 @@ func (m *clientManager) Stop(progress ProgressFunc) error {
 	progress(ProgressEvent{Step: 2, Total: 2, Label: "Xray tunnel", Status: "completed"})
 
 	m.mu.Lock()
-	m.state = StateStopped
 	m.lastErr = ""
+	m.setState(StateStopped)
+	m.xrayComp, m.tunnelComp = nil, nil
+	m.plainSSH = ""
+	m.compat = nil
 	m.mu.Unlock()
 
 	return nil
 }
 
-// Status returns the current client state with real health checks.
+// Status returns the current client state with real health checks: the
+// Xray inbound must accept a connection and the forward tunnel be
+// connected. As for the server, probe results are cached.
 func (m *clientManager) Status() ClientStatus {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	s := ClientStatus{
-		State: m.state,
-		Error: m.lastErr,
+		State:    m.state,
+		Error:    m.lastErr,
+		PlainSSH: m.plainSSH,
+		Compat:   m.compat,
 	}
+	xrayComp, tunnelComp := m.xrayComp, m.tunnelComp
+	xrayInst, tunnel := m.xrayInst, m.tunnel
+	m.mu.Unlock()
 
-	if m.xrayInst != nil {
-		s.Xray = m.xrayInst.Running()
+	if xrayComp != nil {
+		var probeErr error
+		if !xrayInst.Running() {
+			probeErr = fmt.Errorf("instance not running")
+		} else {
+			probeErr = m.probes.run("xray", func() error { return probeTCP(twxray.ClientListenPort) })
+		}
+		cs := xrayComp.status(probeErr)
+		s.Xray = cs.Running
+		s.Components = append(s.Components, cs)
+		// The tunnel reports its own errors in TunnelError.
+		if s.Error == "" && s.State == StateRunning {
+			s.Error = componentsError(s.Components)
+		}
+	}
+	if tunnelComp != nil {
+		s.TunnelError = tunnel.LastError()
+		s.Tunnels = tunnel.Stats()
+		var probeErr error
+		if !tunnel.Connected() {
+			probeErr = fmt.Errorf("not connected")
+		}
+		cs := tunnelComp.status(probeErr)
+		s.Tunnel = cs.Running
+		s.Components = append(s.Components, cs)
+	}
+	return s
+}
+
+// updateCompat compares cfg's bundle and this tw with what the server
+// advertised on connecting, and warns of each difference, in the log and
+// as a compat_warning event.
+func (m *clientManager) updateCompat(cfg *config.Config, sshVersion string) {
+	c := checkCompat(cfg, sshVersion)
+	m.mu.Lock()
+	m.compat = c
+	m.mu.Unlock()
+	if c == nil {
+		return
 	}
+	for _, w := range c.Warnings {
+		slog.Warn("compatibility: "+w, "server_version", c.ServerVersion)
+		m.events.publish(StatusEvent{Type: "compat_warning", Source: "client", Message: w})
+	}
+}
 
-	if m.tunnel != nil {
-		s.Tunnel = m.tunnel.Connected()
-		s.TunnelError = m.tunnel.LastError()
+// PlainSSHCommand returns the OpenSSH command that forwards cfg's enabled
+// tunnels through the local Xray inbound, for client.plain_ssh. With
+// agent, or when the key is in the OS keychain, the key is left to the
+// SSH agent. The host key is remembered
+// under the relay's name, since the inbound's address is the same for
+// every relay.
+func PlainSSHCommand(cfg *config.Config, agent bool) string {
+	args := []string{"ssh", "-N", "-p", strconv.Itoa(twxray.ClientListenPort),
+		"-o", "HostKeyAlias=tw-" + cfg.Xray.RelayHost,
+		"-o", "ServerAliveInterval=15", "-o", "ExitOnForwardFailure=yes"}
+	// A key kept in the OS keychain has no file for ssh to read.
+	if _, err := os.Stat(clientKeyPath()); !agent && err == nil {
+		args = append(args, "-i", shellQuote(clientKeyPath()))
+	}
+	for _, t := range cfg.Client.EnabledTunnels() {
+		args = append(args, "-L", fmt.Sprintf("%s:%s:%d", t.ListenAddr(), t.RemoteHost, t.RemotePort))
 	}
+	args = append(args, cfg.Client.SSHUser+"@127.0.0.1")
+	return strings.Join(args, " ")
+}
 
-	return s
+// shellQuote quotes s for a POSIX shell when it needs quoting.
+func shellQuote(s string) string {
+	if !strings.ContainsAny(s, " '\"$`\\") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// tunnelMapping returns the port mapping for t, warning when it listens
+// beyond this machine.
+func tunnelMapping(t config.Tunnel) twssh.Mapping {
+	if t.Exposed() {
+		slog.Warn("tunnel listens beyond this machine; other hosts on the network can use it", "listen", t.ListenAddr(), "remote", fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort))
+	}
+	return twssh.Mapping{
+		Name:       t.Name,
+		LocalPort:  t.LocalPort,
+		RemoteHost: t.RemoteHost,
+		RemotePort: t.RemotePort,
+		ListenHost: t.ListenHost,
+	}
+}
+
+// SetTunnelEnabled starts or stops forwarding t while the client runs; it
+// does nothing otherwise.
+func (m *clientManager) SetTunnelEnabled(t config.Tunnel, enabled bool) error {
+	m.mu.Lock()
+	ft := m.tunnel
+	state := m.state
+	m.mu.Unlock()
+
+	if state != StateRunning || ft == nil {
+		return nil
+	}
+	if enabled {
+		return ft.AddMapping(tunnelMapping(t))
+	}
+	return ft.RemoveMapping(t.LocalPort)
+}
+
+// configSaved takes the saved config as the one the client started with,
+// when it had started with the one saved before, whose hash is before: a
+// tunnel toggled at runtime needs no restart to take effect.
+func (m *clientManager) configSaved(before string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateRunning && m.cfgHash == before {
+		m.cfgHash = config.FileHash()
+		m.cfgData, _ = os.ReadFile(config.FilePath())
+	}
+}
+
+// RestartTunnel restarts the listener for one local port, dropping its
+// active connections.
+func (m *clientManager) RestartTunnel(localPort int) error {
+	m.mu.Lock()
+	ft := m.tunnel
+	state := m.state
+	m.mu.Unlock()
+
+	if state != StateRunning || ft == nil {
+		return fmt.Errorf("client not running (state: %s)", state)
+	}
+	return ft.RestartMapping(localPort)
 }
@@ -0,0 +1,286 @@
+package ops
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/proxyauth"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// checkListenPort is the local port of the temporary Xray instance used by
+// TestConnection. It differs from twxray.ClientListenPort so the check can
+// run while the client is connected.
+const checkListenPort = 59010
+
+// TestConnection runs the client-side connectivity checks one layer at a
+// time: config, DNS, TLS to the relay, the VLESS tunnel (through a temporary
+// Xray instance), SSH authentication to the server, and each mapped port.
+// It stops at the first failing layer, since every later check depends on
+// it. Per-port results are streamed as message events under the last step.
+func (o *Ops) TestConnection(progress ProgressFunc) {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	cfg := o.Config()
+	proxyURL, _ := relayProxy(cfg, cfg.Xray.RelayHost)
+	const total = 6
+
+	step := func(n int, label string, fn func() (string, error)) bool {
+		progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "running"})
+		msg, err := fn()
+		if err != nil {
+			progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "failed", Error: err.Error()})
+			return false
+		}
+		progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "completed", Message: msg})
+		return true
+	}
+
+	// 1. Config.
+	var (
+		auth      gossh.AuthMethod
+		agentConn io.Closer = io.NopCloser(nil)
+	)
+	defer func() { agentConn.Close() }()
+	ok := step(1, "Config", func() (string, error) {
+		if cfg.Xray.RelayHost == "" {
+			return "", fmt.Errorf("xray.relay_host is not set — upload the config bundle from the server admin")
+		}
+		if cfg.Xray.UUID == "" {
+			return "", fmt.Errorf("xray.uuid is not set — the config bundle is incomplete")
+		}
+		if len(cfg.Client.Tunnels) == 0 {
+			return "", fmt.Errorf("no tunnels defined in client.tunnels")
+		}
+		if len(cfg.Client.EnabledTunnels()) == 0 {
+			return "", fmt.Errorf("every tunnel in client.tunnels is disabled")
+		}
+		key, err := clientKeyOrAgent(cfg)
+		if err != nil {
+			return "", fmt.Errorf("reading client key: %w", err)
+		}
+		auth, agentConn, err = twssh.PublicKeyAuth(key, "", key == nil)
+		if err != nil {
+			return "", fmt.Errorf("client key: %w", err)
+		}
+		msg := fmt.Sprintf("user %s, %d tunnel(s)", cfg.Client.SSHUser, len(cfg.Client.EnabledTunnels()))
+		if key == nil {
+			msg += ", key in the SSH agent"
+		}
+		return msg, nil
+	})
+	if !ok {
+		return
+	}
+
+	// 2. DNS.
+	ok = step(2, "DNS", func() (string, error) {
+		addrs, err := net.LookupHost(cfg.Xray.RelayHost)
+		if err != nil {
+			if twxray.ProxyFor(proxyURL, cfg.ProxyRules, cfg.Xray.RelayHost) != "" {
+				// Behind a proxy the relay may only resolve on the proxy's side.
+				return "not resolvable locally, continuing via proxy", nil
+			}
+			return "", fmt.Errorf("cannot resolve %s: %w", cfg.Xray.RelayHost, err)
+		}
+		return strings.Join(addrs, ", "), nil
+	})
+	if !ok {
+		return
+	}
+
+	// 3. TLS handshake with the relay (through the proxy, if any).
+	ok = step(3, "TLS", func() (string, error) {
+		return checkRelayTLS(cfg, proxyURL)
+	})
+	if !ok {
+		return
+	}
+
+	// 4. VLESS through a temporary Xray instance: a server SSH banner means
+	// the relay accepted the UUID and the server's reverse tunnel is up.
+	xrayInstance, err := twxray.NewClient(cfg.Xray)
+	if err == nil {
+		err = xrayInstance.StartClientOn(checkListenPort, cfg.Client, proxyURL, cfg.ProxyRules)
+	}
+	if err != nil {
+		progress(ProgressEvent{Step: 4, Total: total, Label: "Xray (VLESS)", Status: "failed", Error: err.Error()})
+		return
+	}
+	defer xrayInstance.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", checkListenPort)
+
+	var banner string
+	ok = step(4, "Xray (VLESS)", func() (string, error) {
+		banner, err = readSSHBanner(addr, handshakeRetries(cfg.Network))
+		if err != nil {
+			return "", fmt.Errorf("no response from the server through the relay (%v) — the relay may not know this client's UUID (ask the admin to register the user), or the server is not connected to the relay", err)
+		}
+		return "tunnel up, server answered: " + banner, nil
+	})
+	if !ok {
+		return
+	}
+	if c := checkCompat(cfg, banner); c != nil {
+		for _, w := range c.Warnings {
+			progress(ProgressEvent{Message: "⚠ " + w})
+		}
+	}
+
+	// 5. SSH authentication.
+	var client *gossh.Client
+	ok = step(5, "SSH auth", func() (string, error) {
+		var revoked string
+		totpAsked := false
+		code := clientTOTPCode()
+		totpAuth := twssh.TOTPAuth(func() (string, error) {
+			totpAsked = true
+			if code == nil {
+				return "", twssh.ErrTOTPRequired
+			}
+			return code()
+		})
+		hostKey, err := serverHostKey(cfg)
+		if err != nil {
+			return "", err
+		}
+		client, err = gossh.Dial("tcp", addr, &gossh.ClientConfig{
+			User:            cfg.Client.SSHUser,
+			Auth:            []gossh.AuthMethod{auth, totpAuth},
+			HostKeyCallback: twssh.ServerHostKeyCallback(hostKey),
+			BannerCallback:  twssh.CatchRevoked(&revoked),
+			Timeout:         15 * time.Second,
+		})
+		if err != nil {
+			if revoked != "" {
+				return "", &twssh.RevokedError{Reason: revoked}
+			}
+			if totpAsked && strings.Contains(err.Error(), "unable to authenticate") {
+				return "", fmt.Errorf("server accepted the key but refused the TOTP code — check the code, and the clock of this machine or the authenticator app's")
+			}
+			if strings.Contains(err.Error(), "host key mismatch") {
+				return "", fmt.Errorf("the server's host key is not the one in client.server_host_key — something other than the server answered, or the server's host key was replaced and the bundle must be re-issued")
+			}
+			if strings.Contains(err.Error(), "unable to authenticate") {
+				return "", fmt.Errorf("server rejected the key for user %q — the user may have been disabled, renamed, or deleted", cfg.Client.SSHUser)
+			}
+			return "", err
+		}
+		return "authenticated as " + cfg.Client.SSHUser, nil
+	})
+	if !ok {
+		return
+	}
+	defer client.Close()
+
+	// 6. Mapped ports.
+	progress(ProgressEvent{Step: 6, Total: total, Label: "Mapped ports", Status: "running"})
+	var failed []string
+	tunnels := cfg.Client.EnabledTunnels()
+	for _, t := range tunnels {
+		target := net.JoinHostPort(t.RemoteHost, fmt.Sprint(t.RemotePort))
+		line := fmt.Sprintf("%s → %s", t.ListenAddr(), target)
+		if err := checkForwardedPort(client, target); err != nil {
+			failed = append(failed, line)
+			progress(ProgressEvent{Message: fmt.Sprintf("✗ %s: %v", line, err)})
+			continue
+		}
+		progress(ProgressEvent{Message: "✓ " + line})
+	}
+	if len(failed) > 0 {
+		progress(ProgressEvent{Step: 6, Total: total, Label: "Mapped ports", Status: "failed",
+			Error: fmt.Sprintf("%d of %d unreachable: %s", len(failed), len(tunnels), strings.Join(failed, "; "))})
+		return
+	}
+	progress(ProgressEvent{Step: 6, Total: total, Label: "Mapped ports", Status: "completed",
+		Message: fmt.Sprintf("%d of %d reachable", len(tunnels), len(tunnels))})
+}
+
+// checkRelayTLS makes an HTTPS request to the relay and describes the
+// negotiated TLS session.
+func checkRelayTLS(cfg *config.Config, proxyURL string) (string, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: cfg.Xray.RelayHost},
+	}
+	if proxy := twxray.ProxyFor(proxyURL, cfg.ProxyRules, cfg.Xray.RelayHost); proxy != "" {
+		u, stop, err := proxyauth.ForHTTP(proxy)
+		if err != nil {
+			return "", err
+		}
+		defer stop()
+		transport.Proxy = http.ProxyURL(u)
+	}
+	httpClient := &http.Client{Transport: transport, Timeout: 15 * time.Second}
+
+	target := fmt.Sprintf("https://%s:%d/", cfg.Xray.RelayHost, cfg.Xray.RelayPort)
+	resp, err := httpClient.Get(target)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return "", fmt.Errorf("relay certificate is not trusted: %w — a TLS-inspecting proxy or an unfinished certificate issuance on the relay can cause this", err)
+		}
+		return "", fmt.Errorf("HTTPS to %s failed: %w", target, err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return fmt.Sprintf("HTTP %d", resp.StatusCode), nil
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	return fmt.Sprintf("%s, certificate valid until %s", tls.VersionName(resp.TLS.Version), cert.NotAfter.Format("2006-01-02")), nil
+}
+
+// readSSHBanner connects to addr and returns the SSH version line. Xray
+// starts asynchronously, so the connection is attempted up to retries
+// times, one second apart.
+func readSSHBanner(addr string, retries int) (string, error) {
+	var lastErr error
+	for i := 0; i < retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "SSH-") {
+			return "", fmt.Errorf("unexpected response %q", line)
+		}
+		return line, nil
+	}
+	return "", lastErr
+}
+
+// checkForwardedPort opens a direct-tcpip channel to target, as a local
+// forward would. The server's permitopen rules and the target service both
+// have to accept it.
+func checkForwardedPort(client *gossh.Client, target string) error {
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		if strings.Contains(err.Error(), "administratively prohibited") {
+			return fmt.Errorf("not permitted for this user on the server")
+		}
+		return fmt.Errorf("server could not connect: %w", err)
+	}
+	conn.Close()
+	return nil
+}
@@ -12,6 +12,14 @@ type CloudRegion struct {
 	Name string `json:"name"` // display label
 }
 
+// CloudInstanceType is a selectable relay instance type for a cloud provider.
+type CloudInstanceType struct {
+	Key  string `json:"key"`            // terraform value (e.g. "cax11")
+	Name string `json:"name"`           // display label
+	Arch string `json:"arch"`           // "amd64" or "arm64"
+	Note string `json:"note,omitempty"` // cost or availability hint
+}
+
 // CloudProvider describes one supported cloud provider.
 type CloudProvider struct {
 	Name      string        `json:"name"`
@@ -20,6 +28,19 @@ type CloudProvider struct {
 	TokenLink string        `json:"token_link"` // URL where the user creates the token
 	VarName   string        `json:"var_name"`   // Terraform variable name (empty for AWS)
 	Regions   []CloudRegion `json:"regions"`    // available regions
+
+	// InstanceTypes are the relay sizes offered; the first is the default.
+	InstanceTypes []CloudInstanceType `json:"instance_types"`
+}
+
+// InstanceType returns the provider's instance type key.
+func (p CloudProvider) InstanceType(key string) (CloudInstanceType, bool) {
+	for _, t := range p.InstanceTypes {
+		if t.Key == key {
+			return t, true
+		}
+	}
+	return CloudInstanceType{}, false
 }
 
 // CloudProviders returns the list of supported cloud providers.
@@ -39,6 +60,11 @@ func CloudProviders() []CloudProvider {
 				{"hil", "Hillsboro (US West)"},
 				{"sin", "Singapore (Asia)"},
 			},
+			InstanceTypes: []CloudInstanceType{
+				{"cx22", "CX22 — 2 vCPU, 4 GB (x86)", "amd64", ""},
+				{"cax11", "CAX11 — 2 vCPU, 4 GB (ARM)", "arm64", "Ampere ARM, more performance for the price than CX; nbg1, fsn1 and hel1 only"},
+				{"cax21", "CAX21 — 4 vCPU, 8 GB (ARM)", "arm64", "nbg1, fsn1 and hel1 only"},
+			},
 		},
 		{
 			Name:      "DigitalOcean",
@@ -56,6 +82,11 @@ func CloudProviders() []CloudProvider {
 				{"blr1", "Bangalore 1"},
 				{"syd1", "Sydney 1"},
 			},
+			// DigitalOcean has no ARM droplets.
+			InstanceTypes: []CloudInstanceType{
+				{"s-1vcpu-1gb", "Basic — 1 vCPU, 1 GB", "amd64", ""},
+				{"s-1vcpu-2gb", "Basic — 1 vCPU, 2 GB", "amd64", ""},
+			},
 		},
 		{
 			Name:      "AWS",
@@ -74,6 +105,11 @@ func CloudProviders() []CloudProvider {
 				{"ap-south-1", "Asia Pacific (Mumbai)"},
 				{"sa-east-1", "South America (São Paulo)"},
 			},
+			InstanceTypes: []CloudInstanceType{
+				{"t3.micro", "t3.micro — 2 vCPU, 1 GB (x86)", "amd64", ""},
+				{"t4g.micro", "t4g.micro — 2 vCPU, 1 GB (Graviton ARM)", "arm64", "about 20% cheaper than t3.micro"},
+				{"t4g.small", "t4g.small — 2 vCPU, 2 GB (Graviton ARM)", "arm64", "about 20% cheaper than t3.small"},
+			},
 		},
 	}
 }
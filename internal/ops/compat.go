@@ -0,0 +1,101 @@
+package ops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/version"
+)
+
+// The server advertises its tw version and the oldest bundle format it
+// works with in its SSH version string, which a client reads in the
+// handshake before it authenticates:
+//
+//	SSH-2.0-TunnelWhisperer tw=v1.2.0 bundle=1
+//
+// The software version field may hold no spaces or minus signs, so the
+// fields follow as comments.
+
+const sshVersionPrefix = "SSH-2.0-TunnelWhisperer"
+
+// ServerSSHVersion returns the SSH version string the server advertises.
+func ServerSSHVersion() string {
+	return fmt.Sprintf("%s tw=%s bundle=%d", sshVersionPrefix, version.Version, config.MinBundleFormat)
+}
+
+// parseServerSSHVersion returns the tw version and minimum bundle format
+// in a server's SSH version string; ok is false when it is not a tw
+// server's, or one from before they were advertised.
+func parseServerSSHVersion(s string) (twVersion string, minBundle int, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || fields[0] != sshVersionPrefix {
+		return "", 0, false
+	}
+	for _, f := range fields[1:] {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "tw":
+			twVersion = v
+		case "bundle":
+			minBundle, _ = strconv.Atoi(v)
+		}
+	}
+	return twVersion, minBundle, twVersion != ""
+}
+
+// Compat is how a client's tw and bundle compare with the server's.
+type Compat struct {
+	ServerVersion string   `json:"server_version,omitempty"`
+	Bundle        int      `json:"bundle_format"`     // this client's
+	MinBundle     int      `json:"min_bundle_format"` // the server's oldest
+	Warnings      []string `json:"warnings,omitempty"`
+}
+
+// checkCompat compares cfg's bundle and this tw with what the server
+// advertised in sshVersion. It returns nil for a server that advertises
+// nothing.
+func checkCompat(cfg *config.Config, sshVersion string) *Compat {
+	serverVersion, minBundle, ok := parseServerSSHVersion(sshVersion)
+	if !ok {
+		return nil
+	}
+	c := &Compat{ServerVersion: serverVersion, Bundle: cfg.Client.BundleFormat, MinBundle: minBundle}
+	if c.Bundle < c.MinBundle {
+		c.Warnings = append(c.Warnings, fmt.Sprintf(
+			"this config bundle is format %d, older than the server expects (%d) — ask its admin for a new bundle",
+			c.Bundle, c.MinBundle))
+	}
+	switch compareReleases(serverVersion, version.Version) {
+	case 1:
+		c.Warnings = append(c.Warnings, fmt.Sprintf(
+			"the server runs tw %s, newer than this tw %s — update with tw self-update", serverVersion, version.Version))
+	case -1:
+		c.Warnings = append(c.Warnings, fmt.Sprintf(
+			"the server runs tw %s, older than this tw %s — some features may not work until it is updated", serverVersion, version.Version))
+	}
+	return c
+}
+
+// compareReleases compares two release versions by major and minor
+// version, as patch releases stay compatible: -1, 0 or 1 as a is older,
+// the same or newer. Dev builds and unparsable versions compare as 0.
+func compareReleases(a, b string) int {
+	va, err1 := goversion.NewVersion(a)
+	vb, err2 := goversion.NewVersion(b)
+	if err1 != nil || err2 != nil || a == "dev" || b == "dev" {
+		return 0
+	}
+	sa, sb := va.Segments(), vb.Segments()
+	for i := 0; i < 2; i++ {
+		switch {
+		case sa[i] > sb[i]:
+			return 1
+		case sa[i] < sb[i]:
+			return -1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,289 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// ConfigDocument is config.yaml as text, for the editor.
+type ConfigDocument struct {
+	YAML       string     `json:"yaml"`
+	HasBackup  bool       `json:"has_backup"`
+	BackupTime *time.Time `json:"backup_time,omitempty"` // when the previous version was replaced
+}
+
+// ConfigPreview is what saving an edited config would change.
+type ConfigPreview struct {
+	Base     string           `json:"base"` // "running" (the file the server or client started with) or "saved"
+	Diff     string           `json:"diff"` // unified diff from Base to the edit; empty when identical
+	Valid    bool             `json:"valid"`
+	Problems []config.Problem `json:"problems"`
+
+	// Warnings are valid settings that deserve a second look before
+	// saving, such as tunnels that other machines can reach.
+	Warnings []string `json:"warnings"`
+}
+
+// ConfigInvalidError is returned by SaveConfigYAML when the edit doesn't
+// pass validation. Nothing is written.
+type ConfigInvalidError struct {
+	Problems []config.Problem
+}
+
+func (e *ConfigInvalidError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// ConfigDocument returns the config file as written, comments included,
+// and whether a backup to roll back to exists.
+func (o *Ops) ConfigDocument() (ConfigDocument, error) {
+	data, err := os.ReadFile(config.FilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return ConfigDocument{}, fmt.Errorf("reading config: %w", err)
+	}
+	doc := ConfigDocument{YAML: string(data)}
+	if fi, err := os.Stat(config.BackupPath()); err == nil {
+		t := fi.ModTime()
+		doc.HasBackup = true
+		doc.BackupTime = &t
+	}
+	return doc, nil
+}
+
+// PreviewConfig validates an edited config and diffs it against the file
+// the running server or client started with, or against the saved file
+// when nothing is running.
+func (o *Ops) PreviewConfig(data []byte) ConfigPreview {
+	base, name := o.runningConfigData()
+	if name == "" {
+		base, _ = os.ReadFile(config.FilePath())
+		name = "saved"
+	}
+	problems := ValidateConfigYAML(data)
+	if problems == nil {
+		problems = []config.Problem{}
+	}
+	warnings := []string{}
+	if cfg, err := config.Parse(data); err == nil {
+		warnings = append(warnings, exposedTunnelWarnings(cfg)...)
+	}
+	return ConfigPreview{
+		Base:     name,
+		Diff:     unifiedDiff(name, "edited", string(base), string(data)),
+		Valid:    len(problems) == 0,
+		Problems: problems,
+		Warnings: warnings,
+	}
+}
+
+// exposedTunnelWarnings describes the client tunnels of cfg that listen
+// beyond the loopback interface.
+func exposedTunnelWarnings(cfg *config.Config) []string {
+	if cfg.Mode == "server" {
+		return nil
+	}
+	var warnings []string
+	for i, t := range cfg.Client.Tunnels {
+		if t.Exposed() {
+			warnings = append(warnings, fmt.Sprintf(
+				"client.tunnels[%d] listens on %s: any machine that can reach this one can use the tunnel to %s:%d, without SSH credentials",
+				i, t.ListenAddr(), t.RemoteHost, t.RemotePort))
+		}
+	}
+	return warnings
+}
+
+// runningConfigData returns the config file the running server or client
+// started with, and "running"; or "" when neither is running.
+func (o *Ops) runningConfigData() ([]byte, string) {
+	o.srv.mu.Lock()
+	if o.srv.state == StateRunning && o.srv.cfgData != nil {
+		data := o.srv.cfgData
+		o.srv.mu.Unlock()
+		return data, "running"
+	}
+	o.srv.mu.Unlock()
+
+	o.cli.mu.Lock()
+	defer o.cli.mu.Unlock()
+	if o.cli.state == StateRunning && o.cli.cfgData != nil {
+		return o.cli.cfgData, "running"
+	}
+	return nil, ""
+}
+
+// SaveConfigYAML replaces config.yaml with data after validating it. The
+// previous file is kept as config.yaml.bak for RollbackConfig. Like other
+// config changes, it takes effect on the next server start or client
+// reconnect.
+func (o *Ops) SaveConfigYAML(data []byte) error {
+	if problems := ValidateConfigYAML(data); len(problems) > 0 {
+		return &ConfigInvalidError{Problems: problems}
+	}
+
+	unlock, err := config.Lock()
+	if err != nil {
+		return err
+	}
+	if old, err := os.ReadFile(config.FilePath()); err == nil {
+		if err := fileutil.WriteFile(config.BackupPath(), old, 0600); err != nil {
+			unlock()
+			return fmt.Errorf("backing up config: %w", err)
+		}
+	}
+	err = fileutil.WriteFile(config.FilePath(), data, 0600)
+	unlock()
+	if err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return o.ReloadConfig()
+}
+
+// RollbackConfig restores the config file from before the last edit. The
+// replaced file becomes the new backup, so a rollback can be undone the
+// same way.
+func (o *Ops) RollbackConfig() error {
+	if err := o.rollbackConfig(); err != nil {
+		return err
+	}
+	return o.ReloadConfig()
+}
+
+// rollbackConfig swaps config.yaml and its backup under the config lock.
+func (o *Ops) rollbackConfig() error {
+	unlock, err := config.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	backup, err := os.ReadFile(config.BackupPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no config backup to roll back to")
+		}
+		return fmt.Errorf("reading config backup: %w", err)
+	}
+	current, err := os.ReadFile(config.FilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	if err := fileutil.WriteFile(config.FilePath(), backup, 0600); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if current != nil {
+		if err := fileutil.WriteFile(config.BackupPath(), current, 0600); err != nil {
+			return fmt.Errorf("backing up config: %w", err)
+		}
+	} else {
+		os.Remove(config.BackupPath())
+	}
+	return nil
+}
+
+// unifiedDiff returns a unified diff of two texts with three lines of
+// context, or "" when they are equal. Config files are small, so a plain
+// LCS table is fine.
+func unifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	a, b := splitLines(from), splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-', '+'
+		text string
+		ai   int // line index in a (for ' ' and '-')
+		bi   int // line index in b (for ' ' and '+')
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// Hunk: from context lines before this change to context lines
+		// after the last change that is within 2*context of the next.
+		start := max(k-context, 0)
+		end := k
+		for n := k; n < len(edits); n++ {
+			if edits[n].op != ' ' {
+				end = n
+			} else if n-end > 2*context {
+				break
+			}
+		}
+		end = min(end+context+1, len(edits))
+
+		var aCount, bCount int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", edits[start].ai+1, aCount, edits[start].bi+1, bCount)
+		for _, e := range edits[start:end] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.text)
+			sb.WriteByte('\n')
+		}
+		k = end
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,105 @@
+package ops
+
+import (
+	"sync"
+	"time"
+
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+)
+
+// StatusEvent is a state change of the server or client, pushed to
+// subscribers as it happens (see SubscribeStatus). Type is one of:
+//
+//	state                the server or client lifecycle State changed
+//	tunnel_up            the reverse or forward tunnel connected
+//	tunnel_down          it lost its connection, with Error
+//	tunnel_reconnecting  the next attempt follows after BackoffMs
+//	tunnel_listen_failed a client tunnel could not listen, with Error
+//	                     (e.g. its port is taken); it is retried
+//	tunnel_remapped      a client tunnel's port was taken, so it listens
+//	                     on another, described in Message
+//	compat_warning       the server's tw or bundle format differs from
+//	                     the client's in a way that matters, in Message
+//	config_refreshed     the client took changed settings from the
+//	                     server's config for it, listed in Message
+//	user_connected       User opened an SSH session to the server
+//	user_disconnected    User's session ended
+//	notification         a notification was added, titled Message
+//	                     (see Notifications)
+type StatusEvent struct {
+	Time      time.Time   `json:"time"`
+	Type      string      `json:"type"`
+	Source    string      `json:"source"` // "server" or "client"
+	State     ServerState `json:"state,omitempty"`
+	User      string      `json:"user,omitempty"`
+	Attempt   int         `json:"attempt,omitempty"`
+	BackoffMs int64       `json:"backoff_ms,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Message   string      `json:"message,omitempty"`
+}
+
+// statusHub fans StatusEvents out to subscribers. Slow subscribers miss
+// events rather than holding up the tunnels.
+type statusHub struct {
+	mu   sync.Mutex
+	subs map[int]chan StatusEvent
+	next int
+}
+
+func (h *statusHub) publish(e StatusEvent) {
+	if h == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (h *statusHub) subscribe() (<-chan StatusEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[int]chan StatusEvent)
+	}
+	id := h.next
+	h.next++
+	ch := make(chan StatusEvent, 64)
+	h.subs[id] = ch
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[id]; ok {
+			delete(h.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// tunnelEvents returns an OnEvent callback for a tunnel of source that
+// publishes its transitions.
+func (h *statusHub) tunnelEvents(source string) func(twssh.TunnelEvent) {
+	return func(e twssh.TunnelEvent) {
+		h.publish(StatusEvent{
+			Type:      "tunnel_" + e.Kind,
+			Source:    source,
+			Attempt:   e.Attempt,
+			BackoffMs: e.Backoff.Milliseconds(),
+			Error:     e.Error,
+			Message:   e.Message,
+		})
+	}
+}
+
+// SubscribeStatus returns a channel of server and client state changes
+// and a function that ends the subscription and closes the channel.
+func (o *Ops) SubscribeStatus() (<-chan StatusEvent, func()) {
+	return o.events.subscribe()
+}
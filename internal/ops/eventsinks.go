@@ -0,0 +1,48 @@
+package ops
+
+import (
+	"fmt"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/eventsink"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+)
+
+// eventSinks opens the configured forward event sinks, or returns nil when
+// there are none.
+func eventSinks(cfgs []config.EventSinkConfig) (eventsink.Sink, error) {
+	var sinks []eventsink.Sink
+	fail := func(err error) (eventsink.Sink, error) {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return nil, err
+	}
+	for i, c := range cfgs {
+		switch c.Type {
+		case "syslog":
+			tag := c.Tag
+			if tag == "" {
+				tag = "tw"
+			}
+			s, err := eventsink.NewSyslog(c.Address, tag)
+			if err != nil {
+				return fail(fmt.Errorf("event sink %d: %w", i+1, err))
+			}
+			sinks = append(sinks, s)
+		case "http":
+			headers := make(map[string]string, len(c.Headers))
+			for k, v := range c.Headers {
+				resolved, err := secrets.Resolve(v)
+				if err != nil {
+					return fail(fmt.Errorf("event sink %d: header %s: %w", i+1, k, err))
+				}
+				headers[k] = resolved
+			}
+			sinks = append(sinks, eventsink.NewHTTP(c.URL, headers))
+		default:
+			return fail(fmt.Errorf("event sink %d: unknown type %q", i+1, c.Type))
+		}
+	}
+	return eventsink.Multi(sinks...), nil
+}
@@ -0,0 +1,130 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/geoip"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayGeoIPTag prefixes the ruleTag of the routing rules tw adds to the
+// relay's Xray config for geoip, so they can be found and replaced.
+const relayGeoIPTag = "tw-geoip"
+
+// geoFilter loads the GeoIP database for the SSH server. It returns nil
+// when no country rule or database is configured.
+func geoFilter(g config.GeoIPConfig) (*geoip.Filter, error) {
+	if !g.Enabled() && g.Database == "" {
+		return nil, nil
+	}
+	db, err := geoip.Open(g.Database)
+	if err != nil {
+		return nil, err
+	}
+	return geoip.NewFilter(db, g.Allow, g.Deny), nil
+}
+
+// relayCountries turns country codes into the lower-case form Xray's
+// geoip: rules use.
+func relayCountries(codes []string) []string {
+	out := make([]string, len(codes))
+	for i, c := range codes {
+		out[i] = strings.ToLower(c)
+	}
+	return out
+}
+
+// relayGeoIPRules returns the relay routing rules for the country rules:
+// denied countries and, with an allow list, every other source go to the
+// "block" outbound. Private addresses stay allowed, as on the server. The
+// provisioning templates render the same rules.
+func relayGeoIPRules(g config.GeoIPConfig) []interface{} {
+	sources := func(codes ...string) []interface{} {
+		out := make([]interface{}, len(codes))
+		for i, c := range codes {
+			out[i] = "geoip:" + c
+		}
+		return out
+	}
+	rule := func(tag, outbound string, source []interface{}) map[string]interface{} {
+		r := map[string]interface{}{
+			"type":        "field",
+			"ruleTag":     relayGeoIPTag + "-" + tag,
+			"inboundTag":  []interface{}{"vless-in"},
+			"outboundTag": outbound,
+		}
+		if source != nil {
+			r["source"] = source
+		}
+		return r
+	}
+
+	var rules []interface{}
+	if len(g.Deny) > 0 {
+		rules = append(rules, rule("deny", "block", sources(relayCountries(g.Deny)...)))
+	}
+	if len(g.Allow) > 0 {
+		allowed := sources(append([]string{"private"}, relayCountries(g.Allow)...)...)
+		rules = append(rules, rule("allow", "freedom", allowed), rule("other", "block", nil))
+	}
+	return rules
+}
+
+// ensureRelayGeoIP replaces the geoip routing rules in the relay's Xray
+// config with the ones for g, adding the "block" outbound they need. It
+// writes the config but doesn't restart Xray, and reports whether anything
+// changed.
+func ensureRelayGeoIP(client *gossh.Client, g config.GeoIPConfig) (bool, error) {
+	xrayConf, err := readRelayXrayConfig(client)
+	if err != nil {
+		return false, err
+	}
+
+	routing, _ := xrayConf["routing"].(map[string]interface{})
+	if routing == nil {
+		routing = map[string]interface{}{}
+	}
+	existing, _ := routing["rules"].([]interface{})
+	var kept, old []interface{}
+	for _, r := range existing {
+		if m, ok := r.(map[string]interface{}); ok {
+			if tag, _ := m["ruleTag"].(string); strings.HasPrefix(tag, relayGeoIPTag) {
+				old = append(old, r)
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	want := relayGeoIPRules(g)
+	oldJSON, _ := json.Marshal(old)
+	wantJSON, _ := json.Marshal(want)
+	if string(oldJSON) == string(wantJSON) {
+		return false, nil
+	}
+
+	routing["rules"] = append(kept, want...)
+	xrayConf["routing"] = routing
+	if len(want) > 0 {
+		outbounds, _ := xrayConf["outbounds"].([]interface{})
+		hasBlock := false
+		for _, ob := range outbounds {
+			if m, ok := ob.(map[string]interface{}); ok && m["tag"] == "block" {
+				hasBlock = true
+				break
+			}
+		}
+		if !hasBlock {
+			xrayConf["outbounds"] = append(outbounds, map[string]interface{}{"tag": "block", "protocol": "blackhole"})
+		}
+	}
+
+	if err := writeRelayXrayConfig(client, xrayConf); err != nil {
+		return false, fmt.Errorf("writing relay GeoIP rules: %w", err)
+	}
+	slog.Info("relay GeoIP rules updated", "allow", g.Allow, "deny", g.Deny)
+	return true, nil
+}
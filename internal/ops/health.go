@@ -0,0 +1,85 @@
+package ops
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health probes dial the components' local listeners. Their results are
+// reused for probeTTL, since the dashboard polls the status every few
+// seconds and a dial to the Xray inbound opens a connection to the relay.
+const (
+	probeTTL     = 15 * time.Second
+	probeTimeout = time.Second
+)
+
+// probeCache remembers the latest result of each named probe.
+type probeCache struct {
+	mu      sync.Mutex
+	checked map[string]time.Time
+	results map[string]error
+}
+
+// run returns the result of probe, calling it again when the cached one
+// is older than probeTTL.
+func (p *probeCache) run(name string, probe func() error) error {
+	p.mu.Lock()
+	if t, ok := p.checked[name]; ok && time.Since(t) < probeTTL {
+		err := p.results[name]
+		p.mu.Unlock()
+		return err
+	}
+	p.mu.Unlock()
+
+	err := probe()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.checked == nil {
+		p.checked = make(map[string]time.Time)
+		p.results = make(map[string]error)
+	}
+	p.checked[name] = time.Now()
+	p.results[name] = err
+	return err
+}
+
+// reset forgets all results, so that a restarted server or client is
+// probed afresh.
+func (p *probeCache) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checked, p.results = nil, nil
+}
+
+// probeTCP checks that something accepts connections on the local port.
+func probeTCP(port int) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), probeTimeout)
+	if err != nil {
+		return fmt.Errorf("not accepting connections on port %d", port)
+	}
+	return conn.Close()
+}
+
+// probeSSH checks that the SSH server on the local port accepts
+// connections and sends its version banner.
+func probeSSH(port int) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), probeTimeout)
+	if err != nil {
+		return fmt.Errorf("not accepting connections on port %d", port)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no SSH banner on port %d: %w", port, err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("port %d does not answer as an SSH server", port)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package ops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Hook events. The scripts for an event are the executable files in
+// config.HooksDir()/<event>/, run in name order.
+const (
+	// HookPostProvision runs on the relay, as root over SSH, once a relay
+	// is provisioned or adopted.
+	HookPostProvision = "post-provision"
+	// HookPostUserCreate runs locally after a user is created.
+	HookPostUserCreate = "post-user-create"
+	// HookPreDestroy runs locally before the relay is destroyed; a failing
+	// script aborts the destroy.
+	HookPreDestroy = "pre-destroy"
+)
+
+// hookTimeout bounds each hook script.
+const hookTimeout = 5 * time.Minute
+
+// relayHookPath is where a post-provision script is copied on the relay
+// while it runs.
+const relayHookPath = "/root/.tw-hook"
+
+// hookScripts returns the scripts for event in name order. Dotfiles,
+// editor backups (name~) and, except on Windows, files without an
+// executable bit are skipped.
+func hookScripts(event string) []string {
+	dir := filepath.Join(config.HooksDir(), event)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("reading hooks directory", "dir", dir, "error", err)
+		}
+		return nil
+	}
+	var scripts []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if runtime.GOOS != "windows" && info.Mode()&0111 == 0 {
+			continue
+		}
+		scripts = append(scripts, path)
+	}
+	return scripts
+}
+
+// hookEnv returns the environment of a hook script: vars plus TW_EVENT and
+// TW_HOOK, sorted.
+func hookEnv(event, script string, vars map[string]string) []string {
+	env := []string{"TW_EVENT=" + event, "TW_HOOK=" + filepath.Base(script)}
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// runLocalHooks runs scripts on this machine with vars in their
+// environment, in the config directory. Output is streamed as progress
+// events. The first failing script stops the run.
+func runLocalHooks(ctx context.Context, event string, scripts []string, vars map[string]string, progress ProgressFunc) error {
+	for _, script := range scripts {
+		if err := runLocalHook(ctx, event, script, vars, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLocalHook(ctx context.Context, event, script string, vars map[string]string, progress ProgressFunc) error {
+	name := filepath.Base(script)
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = config.Dir()
+	cmd.Env = append(os.Environ(), "TW_CONFIG_DIR="+config.Dir())
+	cmd.Env = append(cmd.Env, hookEnv(event, script, vars)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	tail := streamHookOutput(stdout, name, progress)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("hook %s: %w\n%s", name, err, tail)
+	}
+	return nil
+}
+
+// runRelayHooks copies each of scripts to the relay and runs it there as
+// root with vars in its environment. Output is streamed as progress
+// events. The first failing script stops the run.
+func runRelayHooks(client *gossh.Client, event string, scripts []string, vars map[string]string, progress ProgressFunc) error {
+	for _, script := range scripts {
+		if err := runRelayHook(client, event, script, vars, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runRelayHook(client *gossh.Client, event, script string, vars map[string]string, progress ProgressFunc) error {
+	name := filepath.Base(script)
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	if err := writeRelayFile(client, relayHookPath, string(data)); err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+
+	env := hookEnv(event, script, vars)
+	for i, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[i] = k + "='" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+	}
+	cmd := fmt.Sprintf("sudo chmod 700 %[1]s && sudo timeout %[2]d env %[3]s %[1]s 2>&1; rc=$?; sudo rm -f %[1]s; exit $rc",
+		relayHookPath, int(hookTimeout.Seconds()), strings.Join(env, " "))
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	tail := streamHookOutput(stdout, name, progress)
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("hook %s: %w\n%s", name, err, tail)
+	}
+	return nil
+}
+
+// streamHookOutput sends each line of r as a progress event and returns
+// the last lines, for error context.
+func streamHookOutput(r io.Reader, name string, progress ProgressFunc) string {
+	var last []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		last = append(last, line)
+		if len(last) > 20 {
+			last = last[1:]
+		}
+		progress(ProgressEvent{Label: "hook " + name, Status: "running", Message: line})
+	}
+	return strings.Join(last, "\n")
+}
+
+// relayHookVars are the environment variables describing the relay for
+// post-provision and pre-destroy hooks.
+func relayHookVars(cfg *config.Config, ip, providerKey string) map[string]string {
+	transport := cfg.Xray.Transport
+	if transport == "" {
+		transport = twxray.TransportSplitHTTP
+	}
+	return map[string]string{
+		"TW_RELAY_DOMAIN":    cfg.Xray.RelayHost,
+		"TW_RELAY_IP":        ip,
+		"TW_RELAY_PROVIDER":  providerKey,
+		"TW_RELAY_TRANSPORT": transport,
+	}
+}
+
+// hookSummary describes a successful run of scripts.
+func hookSummary(scripts []string) string {
+	names := make([]string, len(scripts))
+	for i, s := range scripts {
+		names[i] = filepath.Base(s)
+	}
+	return "ran " + strings.Join(names, ", ")
+}
@@ -0,0 +1,118 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// The instance file records the tw process that runs the server or client
+// for this config directory — tw serve, tw dashboard, tw run or tw
+// connect — so a second one doesn't start the same components and bind
+// the same ports, and CLI commands find the running one's API.
+
+const instanceFile = "tw.pid"
+
+// Instance describes the running tw daemon.
+type Instance struct {
+	PID          int       `json:"pid"`
+	Command      string    `json:"command"` // serve, dashboard, run or connect
+	Mode         string    `json:"mode"`
+	APIPort      int       `json:"api_port,omitempty"` // 0 when it serves no API
+	DashboardURL string    `json:"dashboard_url,omitempty"`
+	Started      time.Time `json:"started"`
+}
+
+// InstanceRunningError is returned by ClaimInstance when another tw
+// process already runs for this config directory.
+type InstanceRunningError struct {
+	Instance Instance
+}
+
+func (e *InstanceRunningError) Error() string {
+	in := e.Instance
+	msg := fmt.Sprintf("tw %s is already running (pid %d", in.Command, in.PID)
+	if in.DashboardURL != "" {
+		msg += ", dashboard " + in.DashboardURL
+	}
+	if in.APIPort > 0 {
+		msg += fmt.Sprintf(", API localhost:%d", in.APIPort)
+	}
+	return msg + ") — use it, or stop it first"
+}
+
+// InstancePath returns the path of the instance file.
+func InstancePath() string {
+	return filepath.Join(config.Dir(), instanceFile)
+}
+
+// RunningInstance returns the tw daemon running for this config directory,
+// if any. An instance file whose process has exited is ignored.
+func RunningInstance() (Instance, bool) {
+	in, ok := readInstance()
+	if !ok || in.PID == os.Getpid() || !fileutil.ProcessAlive(in.PID) {
+		return Instance{}, false
+	}
+	return in, true
+}
+
+func readInstance() (Instance, bool) {
+	var in Instance
+	data, err := os.ReadFile(InstancePath())
+	if err != nil {
+		return in, false
+	}
+	if err := json.Unmarshal(data, &in); err != nil || in.PID == 0 {
+		return in, false
+	}
+	return in, true
+}
+
+// ClaimInstance records this process as the tw daemon for the config
+// directory, or returns an *InstanceRunningError naming the one already
+// running. A file left by a process that has exited is taken over. The
+// returned function removes the file again; call it on exit.
+func ClaimInstance(in Instance) (func(), error) {
+	unlock, err := config.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if running, ok := RunningInstance(); ok {
+		return nil, &InstanceRunningError{Instance: running}
+	}
+	in.PID = os.Getpid()
+	in.Started = time.Now().UTC()
+	data, _ := json.MarshalIndent(in, "", "  ")
+	if err := fileutil.WriteFile(InstancePath(), append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("writing instance file: %w", err)
+	}
+	return releaseInstance, nil
+}
+
+// releaseInstance removes the instance file if it is still this process's.
+func releaseInstance() {
+	unlock, err := config.Lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if in, ok := readInstance(); ok && in.PID == os.Getpid() {
+		os.Remove(InstancePath())
+	}
+}
+
+// APIAddr returns the address CLI commands dial for the daemon's API: the
+// running instance's port, which may differ from cfg's when it was started
+// with TW_SERVER_API_PORT, or else cfg's.
+func APIAddr(cfg *config.Config) string {
+	if in, ok := RunningInstance(); ok && in.APIPort > 0 {
+		return fmt.Sprintf("localhost:%d", in.APIPort)
+	}
+	return fmt.Sprintf("localhost:%d", cfg.Server.APIPort)
+}
@@ -0,0 +1,304 @@
+package ops
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// An invited user (CreateUserRequest.Invite) is created with their UUID
+// registered on the relay and their mappings, but no key. The claim code
+// handed to them holds their relay connection, the server's host key and
+// a secret. Their tw makes a key, reaches the server through the relay,
+// and claims the invitation with the secret over SSH (see twssh.Server's
+// Claim), which registers the key and sends back their config: the
+// private key never leaves their machine.
+
+// inviteFile holds, in an invited user's directory, their invitation
+// until it is claimed.
+const inviteFile = ".invite"
+
+// InviteTTL is how long a claim code stays valid.
+const InviteTTL = 7 * 24 * time.Hour
+
+// claimCodePrefix starts a claim code, a compact string (see
+// compactString) of an invitation.
+const claimCodePrefix = "twinv1:"
+
+// inviteRecord is the content of inviteFile.
+type inviteRecord struct {
+	Secret  string    `json:"secret"`
+	Expires time.Time `json:"expires"`
+}
+
+// invitation is what a claim code holds: enough of the user's config to
+// reach the server through the relay and check its host key.
+type invitation struct {
+	User    string              `yaml:"user"`
+	Secret  string              `yaml:"secret"`
+	Expires time.Time           `yaml:"expires"`
+	Xray    config.XrayConfig   `yaml:"xray"`
+	Client  config.ClientConfig `yaml:"client"`
+}
+
+// writeInvite starts a new invitation for the user in userDir, replacing
+// any earlier one.
+func writeInvite(userDir string) error {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	rec := inviteRecord{
+		Secret:  base64.RawURLEncoding.EncodeToString(secret),
+		Expires: time.Now().Add(InviteTTL).UTC().Truncate(time.Second),
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteFile(filepath.Join(userDir, inviteFile), data, 0600); err != nil {
+		return fmt.Errorf("writing invitation: %w", err)
+	}
+	return nil
+}
+
+// readInvite returns the invitation of the user in userDir.
+func readInvite(userDir string) (inviteRecord, bool) {
+	var rec inviteRecord
+	data, err := os.ReadFile(filepath.Join(userDir, inviteFile))
+	if err != nil || json.Unmarshal(data, &rec) != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+// userInvited reports whether name has an unexpired invitation waiting to
+// be claimed.
+func userInvited(name string) bool {
+	if validateName(name) != nil {
+		return false
+	}
+	rec, ok := readInvite(filepath.Join(config.UsersDir(), name))
+	return ok && time.Now().Before(rec.Expires)
+}
+
+// InviteCode returns the claim code of the invited user name. With renew,
+// a new code replaces theirs, valid for InviteTTL from now.
+func (o *Ops) InviteCode(name string, renew bool) (string, time.Time, error) {
+	if err := validateName(name); err != nil {
+		return "", time.Time{}, fmt.Errorf("user name %w", err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); err != nil {
+		return "", time.Time{}, fmt.Errorf("user %q not found", name)
+	}
+	if _, ok := readInvite(userDir); !ok {
+		return "", time.Time{}, fmt.Errorf("user %q has no invitation waiting to be claimed", name)
+	}
+	if renew {
+		if err := writeInvite(userDir); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	rec, _ := readInvite(userDir)
+
+	data, err := os.ReadFile(filepath.Join(userDir, "config.yaml"))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("reading user config: %w", err)
+	}
+	var userCfg struct {
+		Xray   config.XrayConfig   `yaml:"xray"`
+		Client config.ClientConfig `yaml:"client"`
+	}
+	if err := yaml.Unmarshal(data, &userCfg); err != nil {
+		return "", time.Time{}, fmt.Errorf("reading user config: %w", err)
+	}
+	inv := invitation{
+		User:    name,
+		Secret:  rec.Secret,
+		Expires: rec.Expires,
+		Xray:    userCfg.Xray,
+		Client: config.ClientConfig{
+			SSHUser:       userCfg.Client.SSHUser,
+			ServerSSHPort: userCfg.Client.ServerSSHPort,
+			ServerHostKey: userCfg.Client.ServerHostKey,
+		},
+	}
+	if inv.Client.ServerHostKey == "" {
+		return "", time.Time{}, errors.New("the server's SSH host key is missing from the user's config; run tw serve once")
+	}
+	payload, err := yaml.Marshal(inv)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return compactString(claimCodePrefix, payload), rec.Expires, nil
+}
+
+// claimInvite claims the invitation of name with secret, registering key
+// as their key, and returns their client config. It is twssh.Server's
+// Claim.
+func (o *Ops) claimInvite(name, secret string, key gossh.PublicKey) ([]byte, error) {
+	if err := validateName(name); err != nil {
+		return nil, fmt.Errorf("user name %w", err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	rec, ok := readInvite(userDir)
+	if !ok {
+		return nil, fmt.Errorf("no invitation for %q", name)
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(rec.Secret)) != 1 {
+		return nil, errors.New("wrong claim code")
+	}
+	if time.Now().After(rec.Expires) {
+		return nil, fmt.Errorf("the invitation expired on %s", rec.Expires.Local().Format("2006-01-02 15:04"))
+	}
+	pubKey, err := parseUserPublicKey(string(gossh.MarshalAuthorizedKey(key)))
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := userMappings(userDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileutil.WriteFile(filepath.Join(userDir, "id_ed25519.pub"), pubKey, 0644); err != nil {
+		return nil, fmt.Errorf("writing client public key: %w", err)
+	}
+	if err := appendAuthorizedKey(pubKey, name, permitOpens(mappings, userPermits(userDir))); err != nil {
+		os.Remove(filepath.Join(userDir, "id_ed25519.pub"))
+		return nil, fmt.Errorf("updating authorized_keys: %w", err)
+	}
+	unrevokeCredential(pubKey)
+	if err := os.Remove(filepath.Join(userDir, inviteFile)); err != nil {
+		slog.Warn("could not remove the claimed invitation", "user", name, "error", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(userDir, "config.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("reading user config: %w", err)
+	}
+	// The client now holds the bundle of this config and key.
+	if err := fileutil.WriteFile(filepath.Join(userDir, bundleMarker), []byte(bundleHash(userDir)+"\n"), 0644); err != nil {
+		slog.Warn("could not record the issued bundle", "user", name, "error", err)
+	}
+	return data, nil
+}
+
+// parseClaimCode verifies the claim code s and returns its invitation.
+func parseClaimCode(s string) (*invitation, error) {
+	data, err := parseCompact(s, claimCodePrefix, "claim code")
+	if err != nil {
+		return nil, err
+	}
+	var inv invitation
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("invalid claim code: %w", err)
+	}
+	if inv.User == "" || inv.Secret == "" || inv.Xray.UUID == "" || inv.Client.ServerHostKey == "" {
+		return nil, errors.New("invalid claim code: it is incomplete")
+	}
+	if !inv.Expires.IsZero() && time.Now().After(inv.Expires) {
+		return nil, fmt.Errorf("the claim code expired on %s; ask for a new one", inv.Expires.Local().Format("2006-01-02 15:04"))
+	}
+	return &inv, nil
+}
+
+// claimListenPort is the local port of the temporary Xray instance of
+// ClaimInvitation, next to TestConnection's.
+const claimListenPort = checkListenPort + 1
+
+// ClaimInvitation claims, as this client, the invitation of the claim code
+// code: it generates an SSH key pair here, registers the public key with
+// the server through the relay, and installs the config the server sends
+// back together with the key, as UploadClientConfig does.
+func (o *Ops) ClaimInvitation(code string, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	const total = 3
+	fail := func(step int, label string, err error) error {
+		progress(ProgressEvent{Step: step, Total: total, Label: label, Status: "failed", Error: err.Error()})
+		return err
+	}
+	cfg := o.Config()
+	if cfg.Mode == "server" {
+		return errors.New("this tw is set up as a server; claim the invitation on the client")
+	}
+	inv, err := parseClaimCode(code)
+	if err != nil {
+		return err
+	}
+	hostKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(inv.Client.ServerHostKey))
+	if err != nil {
+		return fmt.Errorf("invalid claim code: server host key: %w", err)
+	}
+
+	// Step 1: Generate the key pair.
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Generating SSH key", Status: "running"})
+	privPEM, pubKey, err := twssh.GenerateKeyPair()
+	if err != nil {
+		return fail(1, "Generating SSH key", fmt.Errorf("generating SSH key pair: %w", err))
+	}
+	signer, err := gossh.ParsePrivateKey(privPEM)
+	if err != nil {
+		return fail(1, "Generating SSH key", err)
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Generating SSH key", Status: "completed", Message: gossh.FingerprintSHA256(signer.PublicKey())})
+
+	// Step 2: Claim the invitation through the relay.
+	progress(ProgressEvent{Step: 2, Total: total, Label: "Claiming invitation", Status: "running", Message: "Connecting through " + inv.Xray.RelayHost})
+	proxyURL, _ := relayProxy(cfg, inv.Xray.RelayHost)
+	xrayInstance, err := twxray.NewClient(inv.Xray)
+	if err == nil {
+		err = xrayInstance.StartClientOn(claimListenPort, inv.Client, proxyURL, cfg.ProxyRules)
+	}
+	if err != nil {
+		return fail(2, "Claiming invitation", err)
+	}
+	defer xrayInstance.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", claimListenPort)
+	if _, err := readSSHBanner(addr, handshakeRetries(cfg.Network)); err != nil {
+		return fail(2, "Claiming invitation", fmt.Errorf("no response from the server through the relay (%v) — the server may not be connected to the relay", err))
+	}
+	data, err := twssh.ClaimInvite(addr, inv.Client.SSHUser, signer, inv.Secret, hostKey)
+	if err != nil {
+		return fail(2, "Claiming invitation", err)
+	}
+	progress(ProgressEvent{Step: 2, Total: total, Label: "Claiming invitation", Status: "completed", Message: "Key registered for " + inv.User})
+
+	// Step 3: Install the config and key.
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Saving configuration", Status: "running"})
+	bundle, err := zipFiles([]exportFile{
+		{"config.yaml", data, 0600},
+		{"id_ed25519", privPEM, 0600},
+		{"id_ed25519.pub", pubKey, 0644},
+	})
+	if err == nil {
+		err = o.UploadClientConfig(bundle)
+	}
+	if err != nil {
+		return fail(3, "Saving configuration", err)
+	}
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Saving configuration", Status: "completed", Message: config.Dir()})
+	return nil
+}
@@ -0,0 +1,320 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// The journal is an intent log for operations that change the relay and
+// local state together. An entry is written before the first change and
+// removed after the last, with the steps done in between recorded as they
+// complete, so an entry left behind by a tw that crashed or was killed
+// says what was half done. New replays those entries: user changes are
+// finished or rolled back from where they stopped, and relay provisioning
+// or destruction, which is too long to redo unasked, is finished where it
+// can be and reported as a notification otherwise.
+
+const journalFile = "journal.json"
+
+// Journaled operations.
+const (
+	journalUserCreate     = "user_create"
+	journalUserDelete     = "user_delete"
+	journalUserDisable    = "user_disable"
+	journalUserEnable     = "user_enable"
+	journalRelayProvision = "relay_provision"
+	journalRelayDestroy   = "relay_destroy"
+)
+
+// Journal steps. Each operation records the ones that apply to it.
+const (
+	stepRelay   = "relay"     // the UUID was added to or removed from the relay
+	stepFiles   = "files"     // the user directory was written or removed
+	stepKeys    = "keys"      // authorized_keys was updated
+	stepApplied = "applied"   // the relay was created in the cloud
+	stepDestroy = "destroyed" // the relay was destroyed in the cloud
+)
+
+// JournalEntry is one operation in progress.
+type JournalEntry struct {
+	ID       int64     `json:"id"`
+	Op       string    `json:"op"`
+	Target   string    `json:"target"` // the user name, or the relay domain
+	UUID     string    `json:"uuid,omitempty"`
+	PubKey   string    `json:"pub_key,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	Done     []string  `json:"done,omitempty"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+}
+
+func (e JournalEntry) done(step string) bool {
+	return slices.Contains(e.Done, step)
+}
+
+func journalPath() string {
+	return filepath.Join(config.Dir(), journalFile)
+}
+
+// readJournal returns the entries in journalFile.
+func readJournal() []JournalEntry {
+	data, err := os.ReadFile(journalPath())
+	if err != nil {
+		return nil
+	}
+	var entries []JournalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		slog.Warn("could not read the operation journal", "error", err)
+		return nil
+	}
+	return entries
+}
+
+// writeJournal replaces journalFile atomically, removing it when there are
+// no entries. Callers hold the config lock.
+func writeJournal(entries []JournalEntry) error {
+	path := journalPath()
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, _ := json.MarshalIndent(entries, "", "  ")
+	return fileutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// journalBegin records the start of an operation and returns its ID for
+// journalStep and journalEnd. Failing to write the journal only costs
+// recovery, so it is logged rather than returned.
+func journalBegin(e JournalEntry) int64 {
+	e.ID = time.Now().UnixNano()
+	unlock, err := config.Lock()
+	if err != nil {
+		slog.Warn("could not write the operation journal", "op", e.Op, "target", e.Target, "error", err)
+		return e.ID
+	}
+	defer unlock()
+	entries := readJournal()
+	for _, x := range entries {
+		e.ID = max(e.ID, x.ID+1)
+	}
+	e.PID = os.Getpid()
+	e.Started = time.Now().UTC()
+	if err := writeJournal(append(entries, e)); err != nil {
+		slog.Warn("could not write the operation journal", "op", e.Op, "target", e.Target, "error", err)
+	}
+	return e.ID
+}
+
+// journalStep records that a step of the operation id is done.
+func journalStep(id int64, step string) {
+	journalUpdate(id, func(e *JournalEntry) bool {
+		if !e.done(step) {
+			e.Done = append(e.Done, step)
+		}
+		return true
+	})
+}
+
+// journalEnd removes the operation id from the journal.
+func journalEnd(id int64) {
+	journalUpdate(id, func(*JournalEntry) bool { return false })
+}
+
+// journalUpdate applies fn to the entry id, dropping it when fn returns
+// false.
+func journalUpdate(id int64, fn func(*JournalEntry) bool) {
+	unlock, err := config.Lock()
+	if err != nil {
+		slog.Warn("could not write the operation journal", "error", err)
+		return
+	}
+	defer unlock()
+	entries := readJournal()
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.ID == id && !fn(&e) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if err := writeJournal(kept); err != nil {
+		slog.Warn("could not write the operation journal", "error", err)
+	}
+}
+
+// recoverJournal finishes or rolls back the operations in the journal
+// whose process is gone, and notifies about each. Entries of a tw that is
+// still running (tw serve while a CLI command starts, say) are left to it.
+func (o *Ops) recoverJournal() {
+	var stale []JournalEntry
+	for _, e := range readJournal() {
+		if e.PID != os.Getpid() && fileutil.ProcessAlive(e.PID) {
+			continue
+		}
+		stale = append(stale, e)
+	}
+
+	for _, e := range stale {
+		slog.Info("recovering an interrupted operation", "op", e.Op, "target", e.Target, "started", e.Started)
+		level, title, msg, err := o.recoverEntry(e)
+		key := e.Op + ":" + e.Target
+		if err != nil {
+			// Keep the entry so the next start tries again.
+			slog.Warn("could not recover an interrupted operation", "op", e.Op, "target", e.Target, "error", err)
+			o.notify(NotifyRecovered, "error", key, "Interrupted operation not recovered",
+				fmt.Sprintf("%s %s was interrupted at %s; recovering it failed and is retried on the next start: %v",
+					e.Op, e.Target, e.Started.Local().Format("2006-01-02 15:04"), err))
+			continue
+		}
+		slog.Info(title, "op", e.Op, "target", e.Target)
+		o.notify(NotifyRecovered, level, key, title, msg)
+		journalEnd(e.ID)
+	}
+}
+
+// recoverEntry repairs what the operation e left half done, describing
+// the outcome for the notification.
+func (o *Ops) recoverEntry(e JournalEntry) (level, title, msg string, err error) {
+	cfg := o.Config()
+	userDir := filepath.Join(config.UsersDir(), e.Target)
+	since := "interrupted at " + e.Started.Local().Format("2006-01-02 15:04")
+
+	switch e.Op {
+	case journalUserCreate:
+		if e.done(stepFiles) {
+			// The user exists; finish what was left.
+			if !e.done(stepKeys) && e.PubKey != "" && !authorizedKeyPresent([]byte(e.PubKey)) {
+				mappings, err := userMappings(userDir)
+				if err != nil {
+					return "", "", "", err
+				}
+				dests := permitOpens(mappings, userPermits(userDir))
+				if err := appendAuthorizedKey([]byte(e.PubKey), e.Target, dests); err != nil {
+					return "", "", "", fmt.Errorf("updating authorized_keys: %w", err)
+				}
+			}
+			if e.done(stepRelay) {
+				_ = os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+			}
+			return "warning", "Finished creating user " + e.Target,
+				"Creating user " + e.Target + " was " + since + "; the missing steps were completed", nil
+		}
+		// The user was never saved: take back what was done.
+		if e.UUID != "" && cfg.Xray.RelayHost != "" {
+			if err := removeUUIDFromRelay(cfg, e.UUID); err != nil {
+				return "", "", "", fmt.Errorf("removing UUID from relay: %w", err)
+			}
+		}
+		if userUUID(userDir) == "" || userUUID(userDir) == e.UUID {
+			os.RemoveAll(userDir)
+		}
+		if e.PubKey != "" && authorizedKeyPresent([]byte(e.PubKey)) {
+			_ = removeAuthorizedKey([]byte(e.PubKey))
+		}
+		return "warning", "Rolled back creating user " + e.Target,
+			"Creating user " + e.Target + " was " + since + " before the user was saved; the partial user was removed", nil
+
+	case journalUserDelete:
+		if !e.done(stepRelay) && e.UUID != "" && cfg.Xray.RelayHost != "" {
+			if err := removeUUIDFromRelay(cfg, e.UUID); err != nil {
+				return "", "", "", fmt.Errorf("removing UUID from relay: %w", err)
+			}
+		}
+		if err := os.RemoveAll(userDir); err != nil {
+			return "", "", "", fmt.Errorf("removing user directory: %w", err)
+		}
+		if e.PubKey != "" && authorizedKeyPresent([]byte(e.PubKey)) {
+			if err := removeAuthorizedKey([]byte(e.PubKey)); err != nil {
+				return "", "", "", fmt.Errorf("updating authorized_keys: %w", err)
+			}
+		}
+		return "warning", "Finished deleting user " + e.Target,
+			"Deleting user " + e.Target + " was " + since + "; the remaining steps were completed", nil
+
+	case journalUserDisable:
+		// Disabling is finished rather than undone: access was meant to end.
+		if e.PubKey != "" && authorizedKeyPresent([]byte(e.PubKey)) {
+			if err := setAuthorizedKeyDisabled([]byte(e.PubKey), true); err != nil {
+				return "", "", "", fmt.Errorf("updating authorized_keys: %w", err)
+			}
+		}
+		_ = os.WriteFile(filepath.Join(userDir, ".disabled"), nil, 0644)
+		if !e.done(stepRelay) && e.UUID != "" && cfg.Xray.RelayHost != "" {
+			if err := removeUUIDFromRelay(cfg, e.UUID); err != nil {
+				return "", "", "", fmt.Errorf("removing UUID from relay: %w", err)
+			}
+			os.Remove(filepath.Join(userDir, ".applied"))
+		}
+		return "warning", "Finished disabling user " + e.Target,
+			"Disabling user " + e.Target + " was " + since + "; the remaining steps were completed", nil
+
+	case journalUserEnable:
+		if !e.done(stepRelay) && e.UUID != "" && cfg.Xray.RelayHost != "" {
+			if err := addUUIDToRelay(cfg, e.UUID); err != nil {
+				return "", "", "", fmt.Errorf("adding UUID to relay: %w", err)
+			}
+			_ = os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+		}
+		if e.PubKey != "" && authorizedKeyPresent([]byte(e.PubKey)) {
+			if err := setAuthorizedKeyDisabled([]byte(e.PubKey), false); err != nil {
+				return "", "", "", fmt.Errorf("updating authorized_keys: %w", err)
+			}
+		}
+		os.Remove(filepath.Join(userDir, ".disabled"))
+		return "warning", "Finished enabling user " + e.Target,
+			"Enabling user " + e.Target + " was " + since + "; the remaining steps were completed", nil
+
+	case journalRelayProvision:
+		if e.done(stepApplied) {
+			return "warning", "Relay provisioning interrupted",
+				"Provisioning the relay " + e.Target + " was " + since + " after the relay was created. " +
+					"Run Test Connectivity on the Relay page; DNS and the TLS certificate may still need time", nil
+		}
+		return "error", "Relay provisioning interrupted",
+			"Provisioning the relay " + e.Target + " was " + since + " while it was being created, so it may " +
+				"exist only in part in the cloud. Provision it again, or destroy it to remove what was created", nil
+
+	case journalRelayDestroy:
+		if !e.done(stepDestroy) {
+			return "error", "Relay destruction interrupted",
+				"Destroying the relay " + e.Target + " was " + since + " before the cloud confirmed it. " +
+					"Run tw destroy relay-server again so nothing is left running", nil
+		}
+		if err := os.RemoveAll(config.RelayDir()); err != nil {
+			return "", "", "", fmt.Errorf("removing relay directory: %w", err)
+		}
+		deactivateAllUsers()
+		if e.Provider != "" {
+			deleteRelayCredentials(e.Provider)
+		}
+		return "warning", "Finished destroying the relay",
+			"Destroying the relay " + e.Target + " was " + since + " after the relay was removed; the local clean-up was completed", nil
+	}
+	return "warning", "Unknown interrupted operation",
+		fmt.Sprintf("The journal listed %q for %s, which this version of tw does not know; it was dropped", e.Op, e.Target), nil
+}
+
+// authorizedKeyPresent reports whether authorized_keys has a line, active
+// or disabled, for the given public key.
+func authorizedKeyPresent(pubKey []byte) bool {
+	parts := strings.Fields(strings.TrimSpace(string(pubKey)))
+	if len(parts) < 2 {
+		return false
+	}
+	data, err := os.ReadFile(config.AuthorizedKeysPath())
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), parts[1])
+}
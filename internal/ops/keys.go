@@ -7,9 +7,21 @@ import (
 	"path/filepath"
 
 	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
 	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
 )
 
+// KeyFingerprint returns the SHA256 fingerprint of this machine's SSH
+// public key: the server's, or the client's from its bundle. It returns ""
+// when there is none.
+func KeyFingerprint() string {
+	pub, err := os.ReadFile(filepath.Join(config.Dir(), "id_ed25519.pub"))
+	if err != nil {
+		return ""
+	}
+	return keyFingerprint(pub)
+}
+
 // EnsureKeys generates ed25519 SSH keys, seeds authorized_keys, and writes a
 // default config if none of these exist yet.
 func (o *Ops) EnsureKeys() error {
@@ -23,6 +35,9 @@ func (o *Ops) EnsureKeys() error {
 	if _, err := os.Stat(privPath); err == nil {
 		return nil // keys already exist
 	}
+	if _, err := secrets.Get(secrets.NameSSHKey); err == nil {
+		return nil // a client's key, kept in the OS keychain
+	}
 
 	slog.Info("generating ed25519 SSH key pair")
 	privPEM, pubAuthorized, err := twssh.GenerateKeyPair()
@@ -38,12 +53,15 @@ func (o *Ops) EnsureKeys() error {
 	slog.Info("SSH keys written", "dir", config.Dir())
 
 	// Seed authorized_keys with the generated public key.
-	akPath := config.AuthorizedKeysPath()
-	if _, err := os.Stat(akPath); os.IsNotExist(err) {
-		if err := os.WriteFile(akPath, pubAuthorized, 0600); err != nil {
-			return fmt.Errorf("writing authorized_keys: %w", err)
+	err = updateAuthorizedKeys(false, func(data []byte) ([]byte, error) {
+		if data != nil {
+			return data, nil
 		}
-		slog.Info("authorized_keys seeded", "path", akPath)
+		slog.Info("authorized_keys seeded", "path", config.AuthorizedKeysPath())
+		return pubAuthorized, nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing authorized_keys: %w", err)
 	}
 
 	// Save default config if none exists.
@@ -0,0 +1,229 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// Notifications are the significant events an admin should see even when
+// nobody was watching: a tunnel going down, the config file drifting from
+// what is running, a relay certificate close to expiry. They are kept in
+// notificationsFile until dismissed, so they survive restarts of tw and
+// reloads of the dashboard. One that repeats while still listed is counted
+// on the existing entry rather than added again, and is unread again if it
+// had been acknowledged.
+
+const (
+	notificationsFile = "notifications.json"
+
+	// maxNotifications is how many are kept; the oldest go first.
+	maxNotifications = 100
+
+	// driftCheckInterval is how often the config file is compared with
+	// the config the running server or client started with.
+	driftCheckInterval = time.Minute
+)
+
+// Notification kinds.
+const (
+	NotifyTunnelDown   = "tunnel_down"          // the reverse or client tunnel lost its connection
+	NotifyListenFailed = "tunnel_listen_failed" // a client tunnel could not listen on its port
+	NotifyConfigDrift  = "config_drift"         // config.yaml changed since the server or client started
+	NotifyCertExpiring = "cert_expiring"        // a relay certificate has less than certWarnBefore left
+	NotifyCertError    = "cert_error"           // a relay certificate could not be checked
+	NotifyRecovered    = "recovered"            // an operation interrupted by a crash was found at startup (see journalFile)
+)
+
+// Notification is one entry of the dashboard's notification center.
+type Notification struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Level   string    `json:"level"` // "warning" or "error"
+	Title   string    `json:"title"`
+	Message string    `json:"message,omitempty"`
+	Key     string    `json:"key"`   // what it is about; a repeat has the same kind and key
+	First   time.Time `json:"first"` // first occurrence
+	Time    time.Time `json:"time"`  // latest occurrence
+	Count   int       `json:"count"`
+	Read    bool      `json:"read"` // acknowledged
+}
+
+// notifyStore holds the notifications, oldest first, loaded from
+// notificationsFile on first use.
+type notifyStore struct {
+	mu     sync.Mutex
+	loaded bool
+	items  []Notification
+	next   int64
+}
+
+func notificationsPath() string {
+	return filepath.Join(config.Dir(), notificationsFile)
+}
+
+// load reads notificationsFile once. Callers hold n.mu.
+func (n *notifyStore) load() {
+	if n.loaded {
+		return
+	}
+	n.loaded = true
+	data, err := os.ReadFile(notificationsPath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &n.items); err != nil {
+		slog.Warn("could not read notifications", "error", err)
+		n.items = nil
+	}
+	for _, it := range n.items {
+		n.next = max(n.next, it.ID)
+	}
+}
+
+// save writes notificationsFile. Callers hold n.mu.
+func (n *notifyStore) save() error {
+	data, _ := json.MarshalIndent(n.items, "", "  ")
+	if err := os.MkdirAll(config.Dir(), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	return fileutil.WriteFile(notificationsPath(), append(data, '\n'), 0644)
+}
+
+// notify adds a notification, or counts a repeat on the listed one of the
+// same kind and key, and tells status subscribers.
+func (o *Ops) notify(kind, level, key, title, message string) {
+	n := &o.notes
+	now := time.Now().UTC()
+	n.mu.Lock()
+	n.load()
+	found := false
+	for i := range n.items {
+		it := &n.items[i]
+		if it.Kind == kind && it.Key == key {
+			it.Level, it.Title, it.Message = level, title, message
+			it.Time, it.Read = now, false
+			it.Count++
+			found = true
+			break
+		}
+	}
+	if !found {
+		n.next++
+		n.items = append(n.items, Notification{
+			ID: n.next, Kind: kind, Level: level, Title: title, Message: message,
+			Key: key, First: now, Time: now, Count: 1,
+		})
+		if over := len(n.items) - maxNotifications; over > 0 {
+			n.items = append(n.items[:0], n.items[over:]...)
+		}
+	}
+	err := n.save()
+	n.mu.Unlock()
+	if err != nil {
+		slog.Warn("could not save notifications", "error", err)
+	}
+	o.events.publish(StatusEvent{Type: "notification", Message: title})
+}
+
+// Notifications returns the notifications, latest first, and how many of
+// them are unread.
+func (o *Ops) Notifications() ([]Notification, int) {
+	n := &o.notes
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.load()
+	list := make([]Notification, len(n.items))
+	copy(list, n.items)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	unread := 0
+	for _, it := range list {
+		if !it.Read {
+			unread++
+		}
+	}
+	return list, unread
+}
+
+// AckNotifications marks the notifications with the given IDs read, or
+// all of them when ids is empty.
+func (o *Ops) AckNotifications(ids []int64) error {
+	return o.updateNotifications(ids, func(it *Notification) bool {
+		it.Read = true
+		return true
+	})
+}
+
+// DismissNotifications removes the notifications with the given IDs, or
+// all of them when ids is empty.
+func (o *Ops) DismissNotifications(ids []int64) error {
+	return o.updateNotifications(ids, func(*Notification) bool { return false })
+}
+
+// updateNotifications applies fn to the notifications with the given IDs,
+// or all when ids is empty, dropping those it returns false for.
+func (o *Ops) updateNotifications(ids []int64, fn func(*Notification) bool) error {
+	want := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	n := &o.notes
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.load()
+	kept := n.items[:0]
+	matched := 0
+	for _, it := range n.items {
+		if len(ids) == 0 || want[it.ID] {
+			matched++
+			if !fn(&it) {
+				continue
+			}
+		}
+		kept = append(kept, it)
+	}
+	n.items = kept
+	if len(ids) > 0 && matched == 0 {
+		return fmt.Errorf("no notification %d", ids[0])
+	}
+	return n.save()
+}
+
+// watchNotifications turns status events and config drift into
+// notifications, for as long as the process runs.
+func (o *Ops) watchNotifications() {
+	events, _ := o.events.subscribe()
+	drift := time.NewTicker(driftCheckInterval)
+	defer drift.Stop()
+	drifted := false
+	for {
+		select {
+		case e := <-events:
+			switch e.Type {
+			case "tunnel_down":
+				title := "Tunnel to the server down"
+				if e.Source == "server" {
+					title = "Reverse tunnel to the relay down"
+				}
+				o.notify(NotifyTunnelDown, "error", e.Source, title, e.Error)
+			case "tunnel_listen_failed":
+				o.notify(NotifyListenFailed, "warning", e.Error, "A tunnel could not listen", e.Error)
+			}
+		case <-drift.C:
+			changed := o.ConfigChanged()
+			if changed && !drifted {
+				o.notify(NotifyConfigDrift, "warning", "config.yaml", "Config changed since start",
+					"config.yaml differs from the config the running "+o.Mode()+" started with; restart to apply it")
+			}
+			drifted = changed
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ops
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	statsCmd "github.com/xtls/xray-core/app/stats/command"
+	gossh "golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+)
+
+// The online poller queries the relay every onlinePollInterval plus up to
+// onlinePollJitter, so that many servers behind one relay don't poll in
+// step. The first poll waits for the server's Xray tunnel to come up.
+const (
+	onlineFirstPoll    = 3 * time.Second
+	onlinePollInterval = 20 * time.Second
+	onlinePollJitter   = 5 * time.Second
+)
+
+// relayStatsConn is the online poller's connection to the relay's Xray
+// API, kept open between polls.
+type relayStatsConn struct {
+	ssh  *gossh.Client
+	grpc *grpc.ClientConn
+}
+
+func (c *relayStatsConn) close() {
+	if c == nil {
+		return
+	}
+	c.grpc.Close()
+	c.ssh.Close()
+}
+
+// runOnlinePoller keeps the online cache that GetOnlineUsers returns
+// warm until stop is closed, polling at intervals and whenever
+// InvalidateOnlineCache asks. The cache is cleared when it returns.
+func (o *Ops) runOnlinePoller(stop <-chan struct{}) {
+	var conn *relayStatsConn
+	defer func() {
+		conn.close()
+		o.setOnline(nil)
+	}()
+
+	// A kick left from before the server started must not skip the wait.
+	select {
+	case <-o.onlineKick:
+	default:
+	}
+	timer := time.NewTimer(onlineFirstPoll)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-o.onlineKick:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+		}
+		conn = o.pollOnline(conn)
+		timer.Reset(onlinePollInterval + rand.N(onlinePollJitter))
+	}
+}
+
+// pollOnline refreshes the online cache over conn, dialing the relay when
+// conn is nil or no longer works. It returns the connection to reuse.
+func (o *Ops) pollOnline(conn *relayStatsConn) *relayStatsConn {
+	cfg := o.Config()
+	if cfg.Xray.RelayHost == "" {
+		conn.close()
+		return nil
+	}
+
+	// A kept connection may have died with the relay or the tunnel; retry
+	// once over a new one before giving up until the next poll.
+	for attempt := 0; attempt < 2; attempt++ {
+		if conn == nil {
+			client, err := dialServerTunnel(cfg)
+			if err != nil {
+				slog.Debug("online status refresh failed", "error", err)
+				break
+			}
+			gc, err := dialRelayGRPC(client)
+			if err != nil {
+				client.Close()
+				slog.Debug("online status refresh failed", "error", err)
+				break
+			}
+			conn = &relayStatsConn{ssh: client, grpc: gc}
+		}
+		result, err := o.queryOnlineUsers(cfg, statsCmd.NewStatsServiceClient(conn.grpc))
+		if err == nil {
+			slog.Debug("online status refreshed", "online_count", len(result))
+			o.setOnline(result)
+			if len(result) > 0 {
+				o.markSeen(result)
+			}
+			return conn
+		}
+		slog.Debug("online status refresh failed", "error", err)
+		conn.close()
+		conn = nil
+	}
+
+	// Unknown counts as offline, as before the first poll.
+	o.setOnline(map[string]bool{})
+	return nil
+}
+
+func (o *Ops) setOnline(online map[string]bool) {
+	o.onlineMu.Lock()
+	o.onlineCache = online
+	o.onlineMu.Unlock()
+}
@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"log/slog"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/logging"
+	"github.com/tunnelwhisperer/tw/internal/proxyauth"
+	"github.com/tunnelwhisperer/tw/internal/ratelimit"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+	"github.com/tunnelwhisperer/tw/internal/sysproxy"
 )
 
 // ProgressEvent describes one step in a long-running operation.
@@ -44,24 +50,57 @@ type Ops struct {
 	srv serverManager
 	cli clientManager
 
-	onlineMu      sync.RWMutex
-	onlineCache   map[string]bool
-	onlinePoll    time.Time
-	onlineRefresh sync.Mutex // prevents concurrent refreshes
-	trafficReset  bool       // true after first traffic stats reset
+	onlineMu     sync.RWMutex
+	onlineCache  map[string]bool // kept by the online poller
+	onlineKick   chan struct{}   // asks the online poller to poll now
+	trafficReset bool            // true after first traffic stats reset
+	presence     presenceTracker
+
+	// Failed SSH handshakes and dashboard sign-ins per source. They outlive
+	// server restarts.
+	sshBans   *ratelimit.Limiter
+	loginBans *ratelimit.Limiter
+
+	events *statusHub  // see SubscribeStatus
+	notes  notifyStore // see Notifications
+
+	// The gRPC API, when the process serves it (see SuperviseAPI).
+	apiMu     sync.Mutex
+	api       *component
+	apiPort   int
+	apiProbes probeCache
 }
 
-// New loads the configuration and returns a ready Ops instance.
+// New loads the configuration and returns a ready Ops instance. Problems
+// found in the config file are logged as warnings, and plaintext
+// credentials are moved into the secrets store (see MigrateSecrets).
 func New() (*Ops, error) {
 	cfg, err := config.Load()
 	if err != nil {
 		return nil, err
 	}
-	return &Ops{
-		cfg: cfg,
-		srv: serverManager{state: StateStopped},
-		cli: clientManager{state: StateStopped},
-	}, nil
+	warnConfigProblems()
+	configureSecrets(cfg)
+	events := &statusHub{}
+	o := &Ops{
+		cfg:        cfg,
+		events:     events,
+		onlineKick: make(chan struct{}, 1),
+		srv:        serverManager{state: StateStopped, events: events},
+		cli:        clientManager{state: StateStopped, events: events},
+		sshBans:    ratelimit.New(rateLimitOptions(cfg.RateLimit)),
+		loginBans:  ratelimit.New(rateLimitOptions(cfg.RateLimit)),
+	}
+	changes, err := o.MigrateSecrets()
+	for _, c := range changes {
+		slog.Info("secrets migration: " + c)
+	}
+	if err != nil {
+		slog.Warn("secrets migration failed", "error", err)
+	}
+	o.recoverJournal()
+	go o.watchNotifications()
+	return o, nil
 }
 
 // Config returns the current configuration (read-only snapshot).
@@ -72,12 +111,18 @@ func (o *Ops) Config() *config.Config {
 	return &c
 }
 
-// ReloadConfig re-reads the config file from disk.
+// ReloadConfig re-reads the config file from disk, logging any problems
+// found in it.
 func (o *Ops) ReloadConfig() error {
 	cfg, err := config.Load()
 	if err != nil {
 		return err
 	}
+	warnConfigProblems()
+	configureSecrets(cfg)
+	o.sshBans.SetOptions(rateLimitOptions(cfg.RateLimit))
+	o.loginBans.SetOptions(rateLimitOptions(cfg.RateLimit))
+	logging.SetXrayLevel(cfg.Xray.LogLevel)
 	o.mu.Lock()
 	o.cfg = cfg
 	o.mu.Unlock()
@@ -105,28 +150,154 @@ func (o *Ops) SetMode(mode string) error {
 
 // SetProxy validates and persists the proxy URL to config.
 // An empty string clears the proxy. Takes effect on next server/client start.
+// A URL with a password is kept in the secrets store and config.yaml refers
+// to it as secret:proxy.
 func (o *Ops) SetProxy(proxyURL string) error {
-	if proxyURL != "" {
+	value := proxyURL
+	if secrets.IsRef(proxyURL) {
+		resolved, err := secrets.Resolve(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxyURL = resolved
+	}
+	if proxyauth.IsNTLM(proxyURL) {
+		if err := proxyauth.Validate(proxyURL); err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+	} else if proxyURL != "" {
 		u, err := url.Parse(proxyURL)
 		if err != nil {
 			return fmt.Errorf("invalid proxy URL: %w", err)
 		}
 		if u.Scheme != "socks5" && u.Scheme != "http" {
-			return fmt.Errorf("unsupported proxy scheme %q (use socks5:// or http://)", u.Scheme)
+			return fmt.Errorf("unsupported proxy scheme %q (use socks5://, http:// or ntlm://)", u.Scheme)
 		}
 		if u.Hostname() == "" {
 			return fmt.Errorf("proxy URL must include a host")
 		}
 	}
+
+	switch {
+	case secrets.IsRef(value):
+	case proxyHasPassword(proxyURL):
+		if err := secrets.Set(secrets.NameProxy, proxyURL); err != nil {
+			return fmt.Errorf("storing proxy credentials: %w", err)
+		}
+		value = secrets.Ref(secrets.NameProxy)
+	default:
+		if err := secrets.Delete(secrets.NameProxy); err != nil {
+			slog.Warn("could not remove stored proxy credentials", "error", err)
+		}
+	}
+
 	o.mu.Lock()
-	o.cfg.Proxy = proxyURL
+	o.cfg.Proxy = value
 	cfg := o.cfg
 	o.mu.Unlock()
 	return config.Save(cfg)
 }
 
-// SetLogLevel validates and persists the log level to config.
-// Takes effect on next server/client restart.
+// proxyHasPassword reports whether a proxy URL carries a password.
+func proxyHasPassword(proxyURL string) bool {
+	u, err := url.Parse(strings.Replace(proxyURL, `\`, `%5C`, 1))
+	if err != nil || u.User == nil {
+		return false
+	}
+	_, ok := u.User.Password()
+	return ok
+}
+
+// redactProxy returns a proxy setting fit for display: a secret reference
+// is resolved and the password masked.
+func redactProxy(value string) string {
+	if value == "" {
+		return ""
+	}
+	resolved, err := secrets.Resolve(value)
+	if err != nil {
+		return value + " (missing)"
+	}
+	u, err := url.Parse(strings.Replace(resolved, `\`, `%5C`, 1))
+	if err != nil {
+		return value
+	}
+	return strings.Replace(u.Redacted(), `%5C`, `\`, 1)
+}
+
+// Values for proxy_mode. An empty mode means ProxyModeManual.
+const (
+	ProxyModeOff    = "off"
+	ProxyModeManual = "manual"
+	ProxyModeAuto   = "auto"
+)
+
+// SetProxyMode validates and persists proxy_mode. Takes effect on next
+// server/client start.
+func (o *Ops) SetProxyMode(mode string) error {
+	switch mode {
+	case ProxyModeOff, ProxyModeManual, ProxyModeAuto:
+	default:
+		return fmt.Errorf("invalid proxy mode %q (must be off, manual, or auto)", mode)
+	}
+	o.mu.Lock()
+	o.cfg.ProxyMode = mode
+	cfg := o.cfg
+	o.mu.Unlock()
+	return config.Save(cfg)
+}
+
+// ProxyInfo describes the proxy the relay connection would use now.
+type ProxyInfo struct {
+	Mode       string             `json:"mode"`
+	Configured string             `json:"configured,omitempty"` // the proxy setting
+	Effective  string             `json:"effective,omitempty"`  // what the relay connection uses; empty is direct
+	Source     string             `json:"source,omitempty"`     // config, environment, or system
+	Rules      []config.ProxyRule `json:"rules,omitempty"`
+}
+
+// ProxyInfo resolves the proxy for the configured relay under proxy_mode.
+func (o *Ops) ProxyInfo() ProxyInfo {
+	cfg := o.Config()
+	effective, source := relayProxy(cfg, cfg.Xray.RelayHost)
+	mode := cfg.ProxyMode
+	if mode == "" {
+		mode = ProxyModeManual
+	}
+	return ProxyInfo{Mode: mode, Configured: redactProxy(cfg.Proxy), Effective: redactProxy(effective), Source: source, Rules: cfg.ProxyRules}
+}
+
+// relayProxy returns the proxy URL for connections to the relay at host and
+// where it came from, following proxy_mode. In auto mode a configured proxy
+// still wins; otherwise the environment and system settings are checked.
+func relayProxy(cfg *config.Config, host string) (proxyURL, source string) {
+	switch cfg.ProxyMode {
+	case ProxyModeOff:
+		return "", ""
+	case ProxyModeAuto:
+		if cfg.Proxy == "" {
+			return sysproxy.Detect(host)
+		}
+	}
+	if cfg.Proxy == "" {
+		return "", ""
+	}
+	proxyURL, err := secrets.Resolve(cfg.Proxy)
+	if err != nil {
+		slog.Error("proxy unavailable", "error", err)
+		return "", ""
+	}
+	return proxyURL, proxySourceConfig
+}
+
+// proxySourceConfig marks a proxy taken from the proxy setting, as opposed
+// to one sysproxy detected.
+const proxySourceConfig = "config"
+
+// SetLogLevel validates and persists the log level to config and applies
+// it at once. Every component logs through the same handler chain, so the
+// SSH server, tunnels, Terraform output, the dashboard console and, unless
+// xray.log_level is set, Xray all follow without a restart.
 func (o *Ops) SetLogLevel(level string) error {
 	switch level {
 	case "debug", "info", "warn", "error":
@@ -137,7 +308,38 @@ func (o *Ops) SetLogLevel(level string) error {
 	o.cfg.LogLevel = level
 	cfg := o.cfg
 	o.mu.Unlock()
-	return config.Save(cfg)
+	before := config.FileHash()
+	if err := config.Save(cfg); err != nil {
+		return err
+	}
+	o.srv.configSaved(before)
+	o.cli.configSaved(before)
+	logging.SetLevel(level)
+	slog.Info("log level changed", "level", level, "xray", logging.XrayLevel())
+	return nil
+}
+
+// SetXrayLogLevel validates and persists Xray's log level (see
+// config.XrayConfig.LogLevel) and applies it at once: Xray's messages are
+// filtered as they are logged, so no restart is needed.
+func (o *Ops) SetXrayLogLevel(level string) error {
+	switch level {
+	case "", "debug", "info", "warning", "error", "none":
+	default:
+		return fmt.Errorf("invalid Xray log level: %q (must be debug, info, warning, error, none, or empty to follow the log level)", level)
+	}
+	o.mu.Lock()
+	o.cfg.Xray.LogLevel = level
+	cfg := o.cfg
+	o.mu.Unlock()
+	before := config.FileHash()
+	if err := config.Save(cfg); err != nil {
+		return err
+	}
+	o.srv.configSaved(before)
+	o.cli.configSaved(before)
+	logging.SetXrayLevel(level)
+	return nil
 }
 
 // StartServer starts all server components.
@@ -174,14 +376,14 @@ func (o *Ops) RestartServer(progress ProgressFunc) error {
 	// Keep state as "stopping" during the delay so the UI shows a disabled
 	// button instead of an active Start button.
 	o.srv.mu.Lock()
-	o.srv.state = StateStopping
+	o.srv.setState(StateStopping)
 	o.srv.mu.Unlock()
 
 	progress(ProgressEvent{Label: "Waiting for relay to release port", Status: "running"})
 	time.Sleep(3 * time.Second)
 
 	o.srv.mu.Lock()
-	o.srv.state = StateStopped
+	o.srv.setState(StateStopped)
 	o.srv.mu.Unlock()
 
 	o.ReloadConfig()
@@ -196,14 +398,44 @@ func (o *Ops) RestartServer(progress ProgressFunc) error {
 	return o.srv.Start(o, progress)
 }
 
-// ServerStatus returns the server lifecycle state.
+// ServerStatus returns the server lifecycle state, with the gRPC API's
+// health when this process serves it.
 func (o *Ops) ServerStatus() ServerStatus {
-	return o.srv.Status()
+	s := o.srv.Status()
+	o.apiMu.Lock()
+	api, port := o.api, o.apiPort
+	o.apiMu.Unlock()
+	if api != nil {
+		var probeErr error
+		if api.isRunning() {
+			probeErr = o.apiProbes.run("api", func() error { return probeTCP(port) })
+		}
+		cs := api.status(probeErr)
+		s.API = cs.Running
+		s.Components = append(s.Components, cs)
+	}
+	return s
+}
+
+// SuperviseAPI runs the gRPC API server's run function under supervision
+// until stop is closed, restarting it when it fails, and reports it in
+// ServerStatus by probing port.
+func (o *Ops) SuperviseAPI(port int, stop <-chan struct{}, run func() error) {
+	c := supervise("gRPC API", stop, run)
+	o.apiMu.Lock()
+	o.api, o.apiPort = c, port
+	o.apiMu.Unlock()
 }
 
 // StartClient starts the client connection.
 func (o *Ops) StartClient(progress ProgressFunc) error {
-	return o.cli.Start(o, progress)
+	return o.cli.Start(o, false, progress)
+}
+
+// StartClientPlainSSH starts only the client's Xray tunnel, leaving the
+// port forwarding to OpenSSH as for client.plain_ssh.
+func (o *Ops) StartClientPlainSSH(progress ProgressFunc) error {
+	return o.cli.Start(o, true, progress)
 }
 
 // StopClient stops the client connection.
@@ -236,7 +468,58 @@ func (o *Ops) ReconnectClient(progress ProgressFunc) error {
 		logging.SetLevel(cfg.LogLevel)
 	}
 
-	return o.cli.Start(o, progress)
+	return o.cli.Start(o, false, progress)
+}
+
+// ReconnectTunnel restarts a single client port mapping, identified by its
+// local port, without touching the others.
+func (o *Ops) ReconnectTunnel(localPort int) error {
+	return o.cli.RestartTunnel(localPort)
+}
+
+// SetTunnelEnabled enables or disables the client tunnel named ref, or
+// on local port ref, and saves the choice to config.yaml. A running client
+// starts or stops just that tunnel. When an enabled tunnel can't listen
+// yet, the choice is saved and the reason returned.
+func (o *Ops) SetTunnelEnabled(ref string, enabled bool) error {
+	o.mu.Lock()
+	i := o.cfg.Client.FindTunnel(ref)
+	if i < 0 {
+		o.mu.Unlock()
+		return fmt.Errorf("no tunnel named or on local port %q", ref)
+	}
+	t := o.cfg.Client.Tunnels[i]
+	o.mu.Unlock()
+	if t.IsEnabled() == enabled {
+		return nil
+	}
+
+	var listenErr error
+	if err := o.cli.SetTunnelEnabled(t, enabled); err != nil {
+		if !enabled {
+			return err
+		}
+		listenErr = err
+	}
+
+	o.mu.Lock()
+	// Config() hands out copies sharing the slice, so replace it.
+	tunnels := slices.Clone(o.cfg.Client.Tunnels)
+	if enabled {
+		tunnels[i].Enabled = nil
+	} else {
+		tunnels[i].Enabled = &enabled
+	}
+	o.cfg.Client.Tunnels = tunnels
+	cfg := o.cfg
+	o.mu.Unlock()
+	before := config.FileHash()
+	if err := config.Save(cfg); err != nil {
+		return err
+	}
+	o.cli.configSaved(before)
+	slog.Info("tunnel toggled", "tunnel", t.Label(), "enabled", enabled)
+	return listenErr
 }
 
 // ClientStatus returns the client lifecycle state.
@@ -0,0 +1,44 @@
+package ops
+
+import (
+	"fmt"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// ListenPort is a TCP port tw is about to listen on, with the setting it
+// comes from.
+type ListenPort struct {
+	Setting string
+	Port    int
+}
+
+// CheckReservedPorts returns an error for the first port tw can't listen
+// on: in user mode one below config.MinUserPort, which needs root, and on
+// Windows one reserved for Hyper-V, WSL or Docker (an excluded port range),
+// where listening fails with a bare "access denied".
+func CheckReservedPorts(ports ...ListenPort) error {
+	var ranges [][2]int
+	loaded := false
+	for _, p := range ports {
+		if p.Port <= 0 {
+			continue
+		}
+		if config.UserMode() && p.Port < config.MinUserPort {
+			return fmt.Errorf("%s %d needs root to listen on — in user mode, set %s to a port from %d up",
+				p.Setting, p.Port, p.Setting, config.MinUserPort)
+		}
+		if !loaded {
+			ranges, loaded = reservedPortRanges(), true
+		}
+		for _, r := range ranges {
+			if p.Port >= r[0] && p.Port <= r[1] {
+				return fmt.Errorf("%s %d is in the range %d-%d that Windows reserves for Hyper-V, WSL or Docker — "+
+					"set %s to a port outside it, or reserve the port for tw from an elevated prompt: "+
+					"net stop winnat && netsh int ipv4 add excludedportrange protocol=tcp startport=%d numberofports=1 && net start winnat",
+					p.Setting, p.Port, r[0], r[1], p.Setting, p.Port)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package ops
+
+// reservedPortRanges returns nothing: only Windows reserves port ranges.
+func reservedPortRanges() [][2]int {
+	return nil
+}
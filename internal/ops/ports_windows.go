@@ -0,0 +1,37 @@
+//go:build windows
+
+package ops
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// reservedPortRanges returns the TCP port ranges Windows excludes, from
+// netsh. Hyper-V and WinNAT reserve these at boot, often over ports like
+// 2222 or 8080. Ranges an administrator added (marked *) are left out:
+// they keep the others off those ports, and applications may listen there.
+func reservedPortRanges() [][2]int {
+	cmd := exec.Command("netsh", "interface", "ipv4", "show", "excludedportrange", "protocol=tcp")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var ranges [][2]int
+	for _, line := range strings.Split(string(out), "\n") {
+		// "     50000       50059     *"
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (len(fields) > 2 && fields[2] == "*") {
+			continue
+		}
+		lo, err1 := strconv.Atoi(fields[0])
+		hi, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			ranges = append(ranges, [2]int{lo, hi})
+		}
+	}
+	return ranges
+}
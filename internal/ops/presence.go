@@ -0,0 +1,218 @@
+package ops
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// presenceWindow is how far back the hourly availability of a user is
+// kept, and the span of the sparkline on the user's page.
+const presenceWindow = 7 * 24 * time.Hour
+
+// UserPresence is a user's connection history: when they were last seen
+// on the server or the relay, and how long their SSH sessions lasted.
+type UserPresence struct {
+	Connected      bool       `json:"connected"` // has an SSH session now
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	Sessions       int        `json:"sessions"`
+	SessionSeconds int64      `json:"session_seconds"` // total, the current session included
+
+	// Availability holds, for each hour of the last presenceWindow (oldest
+	// first), the fraction of it the user had a session.
+	Availability []float64 `json:"availability"`
+}
+
+// presenceRecord is the .presence file in a user's directory.
+type presenceRecord struct {
+	LastSeen       time.Time       `json:"last_seen"`
+	Sessions       int             `json:"sessions"`
+	SessionSeconds int64           `json:"session_seconds"`
+	Hours          map[int64]int64 `json:"hours,omitempty"` // hour (Unix) -> seconds connected
+}
+
+// presenceTracker records SSH sessions per user. A user can hold several
+// connections at once; their session lasts from the first to the last.
+type presenceTracker struct {
+	mu   sync.Mutex
+	open map[string]*openSession
+}
+
+type openSession struct {
+	conns int
+	since time.Time
+}
+
+func presencePath(user string) string {
+	return filepath.Join(config.UsersDir(), user, ".presence")
+}
+
+// connected records a new SSH connection of user.
+func (p *presenceTracker) connected(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.open == nil {
+		p.open = make(map[string]*openSession)
+	}
+	now := time.Now()
+	s := p.open[user]
+	if s == nil {
+		s = &openSession{since: now}
+		p.open[user] = s
+	}
+	s.conns++
+	if s.conns > 1 {
+		return
+	}
+	updatePresence(user, func(r *presenceRecord) {
+		r.LastSeen = now
+		r.Sessions++
+	})
+}
+
+// disconnected records the end of an SSH connection of user, and of the
+// session with its last one.
+func (p *presenceTracker) disconnected(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.open[user]
+	if s == nil {
+		return
+	}
+	if s.conns--; s.conns > 0 {
+		return
+	}
+	delete(p.open, user)
+	now := time.Now()
+	updatePresence(user, func(r *presenceRecord) {
+		r.LastSeen = now
+		r.SessionSeconds += int64(now.Sub(s.since).Seconds())
+		addPresenceHours(r, s.since, now)
+	})
+}
+
+// seen marks users as seen now, e.g. online on the relay.
+func (p *presenceTracker) seen(users []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, user := range users {
+		updatePresence(user, func(r *presenceRecord) { r.LastSeen = now })
+	}
+}
+
+// presence returns user's history, counting a session in progress.
+func (p *presenceTracker) presence(user string) UserPresence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := readPresence(user)
+	now := time.Now()
+	if s := p.open[user]; s != nil {
+		r.LastSeen = now
+		r.SessionSeconds += int64(now.Sub(s.since).Seconds())
+		addPresenceHours(&r, s.since, now)
+	}
+
+	up := UserPresence{
+		Connected:      p.open[user] != nil,
+		Sessions:       r.Sessions,
+		SessionSeconds: r.SessionSeconds,
+	}
+	if !r.LastSeen.IsZero() {
+		last := r.LastSeen
+		up.LastSeen = &last
+	}
+	hours := int(presenceWindow / time.Hour)
+	first := now.Truncate(time.Hour).Add(-presenceWindow + time.Hour)
+	up.Availability = make([]float64, hours)
+	for i := range up.Availability {
+		secs := r.Hours[first.Add(time.Duration(i)*time.Hour).Unix()]
+		up.Availability[i] = min(float64(secs)/3600, 1)
+	}
+	return up
+}
+
+// addPresenceHours spreads the session from start to end over the hourly
+// buckets of r, dropping those older than presenceWindow.
+func addPresenceHours(r *presenceRecord, start, end time.Time) {
+	if r.Hours == nil {
+		r.Hours = make(map[int64]int64)
+	}
+	cutoff := end.Add(-presenceWindow).Truncate(time.Hour)
+	if start.Before(cutoff) {
+		start = cutoff
+	}
+	for t := start; t.Before(end); {
+		hour := t.Truncate(time.Hour)
+		next := hour.Add(time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		r.Hours[hour.Unix()] += int64(next.Sub(t).Seconds())
+		t = next
+	}
+	for h := range r.Hours {
+		if h < cutoff.Unix() {
+			delete(r.Hours, h)
+		}
+	}
+}
+
+func readPresence(user string) presenceRecord {
+	var r presenceRecord
+	if data, err := os.ReadFile(presencePath(user)); err == nil {
+		if err := json.Unmarshal(data, &r); err != nil {
+			slog.Warn("ignoring unreadable presence history", "user", user, "error", err)
+		}
+	}
+	return r
+}
+
+// updatePresence applies change to user's .presence file. Names that are
+// not a user's, such as an SSH login name no user has, or a user deleted
+// while connected, are skipped.
+func updatePresence(user string, change func(*presenceRecord)) {
+	if validateName(user) != nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Dir(presencePath(user))); err != nil {
+		return
+	}
+	r := readPresence(user)
+	change(&r)
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(presencePath(user), data, 0644); err != nil {
+		slog.Warn("could not save presence history", "user", user, "error", err)
+	}
+}
+
+// markSeen marks the users whose UUIDs are online on the relay as seen.
+func (o *Ops) markSeen(online map[string]bool) {
+	users, err := o.ListUsers()
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, u := range users {
+		if u.UUID != "" && online[u.UUID] {
+			names = append(names, u.Name)
+		}
+	}
+	o.presence.seen(names)
+}
+
+// UserPresence returns the connection history of user.
+func (o *Ops) UserPresence(user string) UserPresence {
+	if validateName(user) != nil {
+		return UserPresence{}
+	}
+	return o.presence.presence(user)
+}
@@ -0,0 +1,614 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/caddy"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	proxymanCmd "github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/infra/conf"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// publishRelayPortBase is the first relay loopback port handed out to
+// published services. The reverse tunnel listens there, and a
+// dokodemo-door inbound on the public port forwards to it.
+const publishRelayPortBase = 41000
+
+// reservedRelayPorts are relay ports that cannot be published: Caddy owns
+// 80 and 443, and Xray's VLESS and API inbounds use 10000 and 10085.
+var reservedRelayPorts = map[int]string{
+	80:    "Caddy (HTTP)",
+	443:   "Caddy (HTTPS)",
+	10000: "the Xray VLESS inbound",
+	10085: "the Xray API",
+}
+
+// publishHostRe matches a lowercase DNS name with at least two labels.
+var publishHostRe = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Publication is a server-side service exposed on a public relay port, or
+// on relay port 443 under its own hostname.
+type Publication struct {
+	Name        string `json:"name,omitempty"`
+	PublicPort  int    `json:"public_port,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Target      string `json:"target"`     // host:port reachable from the server
+	RelayPort   int    `json:"relay_port"` // relay loopback port of the reverse forward
+	UpstreamTLS bool   `json:"upstream_tls,omitempty"`
+}
+
+// ParsePublishSpec parses PUBLIC_PORT:HOST:PORT, e.g. "8443:app.internal:8443".
+func ParsePublishSpec(spec string) (publicPort int, target string, err error) {
+	port, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid publish spec %q (want PUBLIC_PORT:HOST:PORT)", spec)
+	}
+	publicPort, err = strconv.Atoi(port)
+	if err != nil || publicPort < 1 || publicPort > 65535 {
+		return 0, "", fmt.Errorf("invalid public port %q", port)
+	}
+	host, targetPort, err := net.SplitHostPort(rest)
+	if err != nil || host == "" {
+		return 0, "", fmt.Errorf("invalid target %q (want HOST:PORT)", rest)
+	}
+	if n, err := strconv.Atoi(targetPort); err != nil || n < 1 || n > 65535 {
+		return 0, "", fmt.Errorf("invalid target port %q", targetPort)
+	}
+	return publicPort, rest, nil
+}
+
+// ListPublished returns the services published on the relay.
+func (o *Ops) ListPublished() []Publication {
+	cfg := o.Config()
+	var out []Publication
+	for _, f := range cfg.Server.ReverseForwards {
+		if f.PublicPort == 0 && f.Host == "" {
+			continue
+		}
+		out = append(out, Publication{
+			Name:        f.Name,
+			PublicPort:  f.PublicPort,
+			Host:        f.Host,
+			Target:      f.LocalAddr,
+			RelayPort:   f.RemotePort,
+			UpstreamTLS: f.UpstreamTLS,
+		})
+	}
+	return out
+}
+
+// Publish exposes target (host:port, as reached from the server) on the
+// relay's publicPort. The relay gets a dokodemo-door inbound on publicPort
+// and a firewall rule; the server adds a reverse forward from a relay
+// loopback port to target. If the server is running, the forward starts
+// immediately. A cancelled ctx or a failed step takes back what was added
+// to the relay.
+func (o *Ops) Publish(ctx context.Context, name string, publicPort int, target string, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	if o.cfg.Xray.RelayHost == "" {
+		return fmt.Errorf("no relay configured — provision one before publishing services")
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if what, ok := reservedRelayPorts[publicPort]; ok {
+		return fmt.Errorf("relay port %d is used by %s", publicPort, what)
+	}
+	used := o.usedRelayPorts()
+	if what, ok := used[publicPort]; ok {
+		return fmt.Errorf("relay port %d is already used by %s", publicPort, what)
+	}
+	used[publicPort] = "the public port"
+	relayPort := nextPublishRelayPort(used)
+	if name == "" {
+		name = fmt.Sprintf("publish-%d", publicPort)
+	}
+
+	const total = 3
+	step := publishStep(progress, total)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		if err := step(1, "Relay inbound", func() (string, error) {
+			return addRelayPublishInbound(client, publicPort, relayPort)
+		}); err != nil {
+			return err
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = step(2, "Relay firewall", func() (string, error) {
+				if err := runRelayCommand(client, fmt.Sprintf("sudo ufw allow %d/tcp", publicPort)); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("allowed %d/tcp", publicPort), nil
+			})
+		}
+		if err != nil {
+			if rerr := removeRelayPublishInbound(client, publicPort); rerr != nil {
+				slog.Warn("could not remove relay inbound", "port", publicPort, "error", rerr)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating relay: %w", err)
+	}
+
+	err = ctx.Err()
+	if err == nil {
+		err = step(3, "Reverse forward", func() (string, error) {
+			return o.addPublishedForward(config.ReverseForward{
+				Name:       name,
+				RemotePort: relayPort,
+				LocalAddr:  target,
+				PublicPort: publicPort,
+			}, fmt.Sprintf("relay :%d → %s", publicPort, target))
+		})
+	}
+	if err != nil {
+		// Don't leave a public port open with nothing behind it.
+		if rerr := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+			if err := removeRelayPublishInbound(client, publicPort); err != nil {
+				return err
+			}
+			return runRelayCommand(client, fmt.Sprintf("sudo ufw delete allow %d/tcp", publicPort))
+		}); rerr != nil {
+			slog.Warn("could not undo relay changes", "port", publicPort, "error", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// PublishHost exposes target (host:port, as reached from the server) as
+// https://host on the relay. Caddy on the relay gets a site block for host,
+// obtains a certificate for it, and proxies requests to a relay loopback
+// port; the server adds a reverse forward from that port to target. Set
+// upstreamTLS when target itself serves HTTPS. host must resolve to the
+// relay for the certificate request to succeed. A cancelled ctx or a
+// failed step takes the site back off the relay.
+func (o *Ops) PublishHost(ctx context.Context, name, host, target string, upstreamTLS bool, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	if o.cfg.Xray.RelayHost == "" {
+		return fmt.Errorf("no relay configured — provision one before publishing services")
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !publishHostRe.MatchString(host) {
+		return fmt.Errorf("invalid hostname %q", host)
+	}
+	if host == strings.ToLower(o.cfg.Xray.RelayHost) {
+		return fmt.Errorf("%s is the relay's own domain and serves the tunnel", host)
+	}
+	for _, f := range o.cfg.Server.ReverseForwards {
+		if f.Host == host {
+			return fmt.Errorf("%s is already published (→ %s)", host, f.LocalAddr)
+		}
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	relayPort := nextPublishRelayPort(o.usedRelayPorts())
+	if name == "" {
+		name = host
+	}
+
+	const total = 2
+	step := publishStep(progress, total)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		return step(1, "Relay site", func() (string, error) {
+			return addRelayCaddySite(client, caddy.SiteConfig{
+				Host:        host,
+				Upstream:    fmt.Sprintf("127.0.0.1:%d", relayPort),
+				UpstreamTLS: upstreamTLS,
+			})
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("updating relay: %w", err)
+	}
+
+	err = ctx.Err()
+	if err == nil {
+		err = step(2, "Reverse forward", func() (string, error) {
+			return o.addPublishedForward(config.ReverseForward{
+				Name:        name,
+				RemotePort:  relayPort,
+				LocalAddr:   target,
+				Host:        host,
+				UpstreamTLS: upstreamTLS,
+			}, fmt.Sprintf("https://%s → %s", host, target))
+		})
+	}
+	if err != nil {
+		if rerr := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+			return removeRelayCaddySite(client, host)
+		}); rerr != nil {
+			slog.Warn("could not undo relay changes", "host", host, "error", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unpublish removes the service published on publicPort from the relay and
+// stops its reverse forward.
+func (o *Ops) Unpublish(ctx context.Context, publicPort int, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	idx := -1
+	for i, f := range o.cfg.Server.ReverseForwards {
+		if f.PublicPort == publicPort {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("nothing is published on relay port %d", publicPort)
+	}
+
+	const total = 2
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "running"})
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		if err := removeRelayPublishInbound(client, publicPort); err != nil {
+			return err
+		}
+		return runRelayCommand(client, fmt.Sprintf("sudo ufw delete allow %d/tcp", publicPort))
+	})
+	if err != nil {
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("updating relay: %w", err)
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "completed", Message: fmt.Sprintf("inbound and firewall rule for %d removed", publicPort)})
+
+	return o.removePublishedForward(idx, 2, total, progress)
+}
+
+// UnpublishHost removes the relay site for host and stops its reverse
+// forward.
+func (o *Ops) UnpublishHost(ctx context.Context, host string, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	idx := -1
+	for i, f := range o.cfg.Server.ReverseForwards {
+		if f.Host != "" && f.Host == host {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("%s is not published", host)
+	}
+
+	const total = 2
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "running"})
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		return removeRelayCaddySite(client, host)
+	})
+	if err != nil {
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("updating relay: %w", err)
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "completed", Message: "site " + host + " removed"})
+
+	return o.removePublishedForward(idx, 2, total, progress)
+}
+
+// addPublishedForward saves fwd to the config and starts it on the running
+// server. msg describes the forward in the progress output. o.mu must be
+// held.
+func (o *Ops) addPublishedForward(fwd config.ReverseForward, msg string) (string, error) {
+	forwards := o.cfg.Server.ReverseForwards
+	o.cfg.Server.ReverseForwards = append(forwards, fwd)
+	if err := config.Save(o.cfg); err != nil {
+		o.cfg.Server.ReverseForwards = forwards
+		return "", err
+	}
+	live := o.updateServerForwards(func(rt *twssh.ReverseTunnel) error {
+		return rt.AddForward(twssh.ReverseForward{Name: fwd.Name, RemotePort: fwd.RemotePort, LocalAddr: fwd.LocalAddr})
+	})
+	if !live {
+		msg += " (starts with the server)"
+	}
+	return msg, nil
+}
+
+// removePublishedForward drops reverse forward idx from the config and the
+// running server, reporting it as the given progress step. o.mu must be
+// held.
+func (o *Ops) removePublishedForward(idx, step, total int, progress ProgressFunc) error {
+	fwd := o.cfg.Server.ReverseForwards[idx]
+	progress(ProgressEvent{Step: step, Total: total, Label: "Reverse forward", Status: "running"})
+	o.cfg.Server.ReverseForwards = append(o.cfg.Server.ReverseForwards[:idx:idx], o.cfg.Server.ReverseForwards[idx+1:]...)
+	if err := config.Save(o.cfg); err != nil {
+		progress(ProgressEvent{Step: step, Total: total, Label: "Reverse forward", Status: "failed", Error: err.Error()})
+		return err
+	}
+	o.updateServerForwards(func(rt *twssh.ReverseTunnel) error {
+		return rt.RemoveForward(fwd.RemotePort)
+	})
+	progress(ProgressEvent{Step: step, Total: total, Label: "Reverse forward", Status: "completed", Message: "removed " + fwd.LocalAddr})
+	return nil
+}
+
+// publishStep returns a helper that runs fn as progress step n of total.
+func publishStep(progress ProgressFunc, total int) func(n int, label string, fn func() (string, error)) error {
+	return func(n int, label string, fn func() (string, error)) error {
+		progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "running"})
+		msg, err := fn()
+		if err != nil {
+			progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "failed", Error: err.Error()})
+			return err
+		}
+		progress(ProgressEvent{Step: n, Total: total, Label: label, Status: "completed", Message: msg})
+		return nil
+	}
+}
+
+// nextPublishRelayPort returns the first free relay loopback port at or
+// above publishRelayPortBase.
+func nextPublishRelayPort(used map[int]string) int {
+	port := publishRelayPortBase
+	for used[port] != "" || reservedRelayPorts[port] != "" {
+		port++
+	}
+	return port
+}
+
+// usedRelayPorts maps relay ports taken by the SSH forward and the
+// configured reverse forwards to a description. o.mu must be held.
+func (o *Ops) usedRelayPorts() map[int]string {
+	used := map[int]string{
+		o.cfg.Server.RemotePort:   "the SSH reverse forward",
+		o.cfg.Server.RelaySSHPort: "the relay's SSH daemon",
+	}
+	for _, f := range o.cfg.Server.ReverseForwards {
+		label := f.Name
+		if label == "" {
+			label = f.LocalAddr
+		}
+		if f.Host != "" {
+			label += " (" + f.Host + ")"
+		}
+		used[f.RemotePort] = "reverse forward " + label
+		if f.PublicPort != 0 {
+			used[f.PublicPort] = "published service " + label
+		}
+	}
+	if links := o.cfg.Server.BundleLinks; links != nil {
+		used[links.RelayPort] = "bundle links"
+	}
+	return used
+}
+
+// updateServerForwards applies fn to the running reverse tunnel. It reports
+// false when the server is not running; the change then takes effect on the
+// next start, since it was already saved to the config.
+func (o *Ops) updateServerForwards(fn func(rt *twssh.ReverseTunnel) error) bool {
+	o.srv.mu.Lock()
+	rt := o.srv.tunnel
+	o.srv.mu.Unlock()
+	if rt == nil {
+		return false
+	}
+	if err := fn(rt); err != nil {
+		slog.Warn("updating reverse forwards", "error", err)
+	}
+	return true
+}
+
+// publishInboundTag is the relay Xray inbound tag for a published port.
+func publishInboundTag(publicPort int) string {
+	return fmt.Sprintf("publish-%d", publicPort)
+}
+
+// addRelayPublishInbound persists a dokodemo-door inbound from publicPort to
+// the relay loopback relayPort and hot-adds it to the running Xray via the
+// API, restarting Xray if the API is unavailable.
+func addRelayPublishInbound(client *gossh.Client, publicPort, relayPort int) (string, error) {
+	tag := publishInboundTag(publicPort)
+	inbound := map[string]interface{}{
+		"tag":      tag,
+		"listen":   "0.0.0.0",
+		"port":     publicPort,
+		"protocol": "dokodemo-door",
+		"settings": map[string]interface{}{
+			"address": "127.0.0.1",
+			"port":    relayPort,
+			"network": "tcp",
+		},
+	}
+
+	xrayConf, err := readRelayXrayConfig(client)
+	if err != nil {
+		return "", err
+	}
+	inbounds, _ := xrayConf["inbounds"].([]interface{})
+	xrayConf["inbounds"] = append(withoutInbound(inbounds, tag), inbound)
+	if err := writeRelayXrayConfig(client, xrayConf); err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(inbound)
+	if err != nil {
+		return "", err
+	}
+	if err := xrayAPIAddInbound(client, raw); err != nil {
+		slog.Warn("xray API add inbound failed, restarting relay Xray", "tag", tag, "error", err)
+		restartRelayXray(client)
+		return fmt.Sprintf("%s added (Xray restarted)", tag), nil
+	}
+	return tag + " added", nil
+}
+
+// removeRelayPublishInbound removes the inbound for publicPort from the
+// relay config and the running Xray.
+func removeRelayPublishInbound(client *gossh.Client, publicPort int) error {
+	tag := publishInboundTag(publicPort)
+	xrayConf, err := readRelayXrayConfig(client)
+	if err != nil {
+		return err
+	}
+	inbounds, _ := xrayConf["inbounds"].([]interface{})
+	xrayConf["inbounds"] = withoutInbound(inbounds, tag)
+	if err := writeRelayXrayConfig(client, xrayConf); err != nil {
+		return err
+	}
+
+	if err := xrayAPIRemoveInbound(client, tag); err != nil {
+		slog.Warn("xray API remove inbound failed, restarting relay Xray", "tag", tag, "error", err)
+		restartRelayXray(client)
+	}
+	return nil
+}
+
+// withoutInbound returns inbounds minus the one tagged tag.
+func withoutInbound(inbounds []interface{}, tag string) []interface{} {
+	out := make([]interface{}, 0, len(inbounds))
+	for _, ib := range inbounds {
+		if m, ok := ib.(map[string]interface{}); ok && m["tag"] == tag {
+			continue
+		}
+		out = append(out, ib)
+	}
+	return out
+}
+
+// xrayAPIAddInbound hot-adds an inbound, given as Xray JSON config, to the
+// running relay Xray.
+func xrayAPIAddInbound(client *gossh.Client, raw []byte) error {
+	var detour conf.InboundDetourConfig
+	if err := json.Unmarshal(raw, &detour); err != nil {
+		return fmt.Errorf("parsing inbound: %w", err)
+	}
+	handler, err := detour.Build()
+	if err != nil {
+		return fmt.Errorf("building inbound: %w", err)
+	}
+
+	conn, err := dialRelayGRPC(client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = proxymanCmd.NewHandlerServiceClient(conn).AddInbound(ctx, &proxymanCmd.AddInboundRequest{Inbound: handler})
+	return err
+}
+
+// xrayAPIRemoveInbound removes the inbound tagged tag from the running relay
+// Xray.
+func xrayAPIRemoveInbound(client *gossh.Client, tag string) error {
+	conn, err := dialRelayGRPC(client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = proxymanCmd.NewHandlerServiceClient(conn).RemoveInbound(ctx, &proxymanCmd.RemoveInboundRequest{Tag: tag})
+	return err
+}
+
+// addRelayCaddySite writes the Caddy site block for site.Host on the relay
+// and reloads Caddy, which then requests a certificate for the host. Relays
+// provisioned before site publishing existed get the sites import added to
+// their Caddyfile first. A site Caddy rejects is removed again.
+func addRelayCaddySite(client *gossh.Client, site caddy.SiteConfig) (string, error) {
+	block, err := caddy.RenderSite(site)
+	if err != nil {
+		return "", fmt.Errorf("rendering site: %w", err)
+	}
+	setup := fmt.Sprintf("sudo mkdir -p %[1]s && (grep -qxF '%[2]s' /etc/caddy/Caddyfile || printf '\\n%[2]s\\n' | sudo tee -a /etc/caddy/Caddyfile >/dev/null)",
+		caddy.SitesDir, caddy.SitesImport)
+	if err := runRelayCommand(client, setup); err != nil {
+		return "", err
+	}
+
+	path := caddy.SitePath(site.Host)
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	session.Stdin = strings.NewReader(block)
+	out, err := session.CombinedOutput(fmt.Sprintf("sudo tee %s >/dev/null", path))
+	session.Close()
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %w: %s", path, err, strings.TrimSpace(string(out)))
+	}
+
+	if err := runRelayCommand(client, "sudo systemctl reload caddy"); err != nil {
+		runRelayCommand(client, "sudo rm -f "+path+" && sudo systemctl reload caddy")
+		return "", fmt.Errorf("caddy rejected the site: %w", err)
+	}
+	return fmt.Sprintf("%s → %s (certificate requested)", site.Host, site.Upstream), nil
+}
+
+// removeRelayCaddySite deletes the site block for host and reloads Caddy.
+func removeRelayCaddySite(client *gossh.Client, host string) error {
+	return runRelayCommand(client, "sudo rm -f "+caddy.SitePath(host)+" && sudo systemctl reload caddy")
+}
+
+// runRelayCommand runs cmd on the relay and includes its output in errors.
+func runRelayCommand(client *gossh.Client, cmd string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if out, err := session.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// writeRelayFile writes content to path on the relay as root.
+func writeRelayFile(client *gossh.Client, path, content string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(content)
+	if out, err := session.CombinedOutput(fmt.Sprintf("sudo tee %s >/dev/null", path)); err != nil {
+		return fmt.Errorf("writing %s: %w: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
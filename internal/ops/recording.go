@@ -0,0 +1,237 @@
+package ops
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// Relay shell sessions can be recorded as asciicast v2 files, which
+// `asciinema play` and the dashboard's player replay. A file holds a JSON
+// header line, then one [seconds, code, data] line per event: "o" for
+// terminal output and "r" for a resize to "COLSxROWS". Only output is
+// recorded: what the admin types shows up as the shell echoes it, and
+// passwords typed at prompts don't.
+
+// recordingExt is the extension of recording files.
+const recordingExt = ".cast"
+
+// RecordingInfo describes a recorded relay shell session.
+type RecordingInfo struct {
+	Name    string    `json:"name"` // file name in config.RecordingsDir()
+	Title   string    `json:"title"`
+	Started time.Time `json:"started"`
+	Size    int64     `json:"size"`
+}
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes a terminal session to a recording file. It is safe for
+// concurrent use, and a nil Recorder records nothing, so callers can pass
+// one along whether or not the session is recorded.
+type Recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	name    string
+	pending []byte // start of a UTF-8 sequence split across writes
+}
+
+// StartRecording creates a recording of a terminal of cols×rows titled
+// title, named after the current time.
+func (o *Ops) StartRecording(title string, cols, rows int) (*Recorder, error) {
+	dir := config.RecordingsDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating recordings directory: %w", err)
+	}
+	start := time.Now()
+	base := "relay-" + start.Format("20060102-150405")
+	var f *os.File
+	var err error
+	for i := 1; ; i++ {
+		name := base + recordingExt
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d%s", base, i, recordingExt)
+		}
+		// Output may include secrets the shell printed.
+		f, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) || i == 100 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+
+	rec := &Recorder{f: f, w: bufio.NewWriter(f), start: start, name: filepath.Base(f.Name())}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	rec.w.Write(append(header, '\n'))
+	slog.Info("recording relay shell session", "file", f.Name())
+	return rec, nil
+}
+
+// Name returns the recording's file name in config.RecordingsDir(), or ""
+// for a nil Recorder.
+func (r *Recorder) Name() string {
+	if r == nil {
+		return ""
+	}
+	return r.name
+}
+
+// Write records p as terminal output. It never fails, so the recorder can
+// sit in an io.MultiWriter next to the terminal.
+func (r *Recorder) Write(p []byte) (int, error) {
+	if r == nil {
+		return len(p), nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.pending, p...)
+	// Keep an incomplete trailing UTF-8 sequence for the next write, so
+	// characters split between reads aren't recorded as garbage.
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event("o", string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// Resize records the terminal changing to cols×rows.
+func (r *Recorder) Resize(cols, rows int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event writes one event line. Callers hold r.mu.
+func (r *Recorder) event(code, data string) {
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), code, data})
+	r.w.Write(append(line, '\n'))
+}
+
+// Close finishes the recording.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	slog.Info("relay shell session recorded", "file", r.f.Name(), "duration", time.Since(r.start).Round(time.Second))
+	return r.f.Close()
+}
+
+// ListRecordings returns the recorded relay shell sessions, newest first.
+func (o *Ops) ListRecordings() ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(config.RecordingsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var recs []RecordingInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), recordingExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		rec := RecordingInfo{Name: e.Name(), Started: info.ModTime(), Size: info.Size()}
+		if h, err := readCastHeader(filepath.Join(config.RecordingsDir(), e.Name())); err == nil {
+			rec.Title = h.Title
+			rec.Started = time.Unix(h.Timestamp, 0)
+		}
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Started.After(recs[j].Started) })
+	return recs, nil
+}
+
+// readCastHeader reads the header line of a recording.
+func readCastHeader(path string) (castHeader, error) {
+	var h castHeader
+	f, err := os.Open(path)
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return h, err
+	}
+	return h, json.Unmarshal(line, &h)
+}
+
+// RecordingPath returns the path of the recording named name, refusing
+// names that aren't recording files in the recordings directory.
+func (o *Ops) RecordingPath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, recordingExt) {
+		return "", fmt.Errorf("invalid recording name %q", name)
+	}
+	path := filepath.Join(config.RecordingsDir(), name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("recording %q not found", name)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// DeleteRecording deletes the recording named name.
+func (o *Ops) DeleteRecording(name string) error {
+	path, err := o.RecordingPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
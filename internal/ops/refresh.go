@@ -0,0 +1,260 @@
+package ops
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// A connected client refreshes its config from the server each time its
+// tunnel comes up and every client.config_refresh while it stays up. The
+// server answers with the user's config.yaml — what a new bundle would
+// hold — signed by its host key, which the client checks against the key
+// its bundle pinned in client.server_host_key. Changed tunnels are applied
+// in place; changed relay settings reconnect the client.
+//
+// A client can only refresh while it still reaches the server: moving to
+// a new relay needs the client connected once after the change is made on
+// the server and before the old relay goes away.
+
+// userClientConfig returns the client config of the tw user name, for the
+// SSH server to serve to config refreshes. Disabled users get none.
+func userClientConfig(name string) ([]byte, error) {
+	if err := validateName(name); err != nil {
+		return nil, fmt.Errorf("user name %w", err)
+	}
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(filepath.Join(userDir, ".disabled")); err == nil {
+		return nil, fmt.Errorf("user %q is disabled", name)
+	}
+	return os.ReadFile(filepath.Join(userDir, "config.yaml"))
+}
+
+// refreshLoop refreshes the client's config on each signal on up and every
+// every, until stop is closed.
+func (m *clientManager) refreshLoop(o *Ops, ft *twssh.ForwardTunnel, up <-chan struct{}, stop <-chan struct{}, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-up:
+		case <-ticker.C:
+		}
+		if ft.Connected() {
+			o.refreshClientConfig(ft)
+		}
+	}
+}
+
+// serverHostKey parses client.server_host_key, which the tunnel's SSH
+// connection is pinned to. It is nil for bundles from before it was
+// recorded.
+func serverHostKey(cfg *config.Config) (gossh.PublicKey, error) {
+	if cfg.Client.ServerHostKey == "" {
+		return nil, nil
+	}
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(cfg.Client.ServerHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("client.server_host_key is malformed: %w", err)
+	}
+	return key, nil
+}
+
+// refreshClientConfig fetches the client's config from the server through
+// ft and applies what changed.
+func (o *Ops) refreshClientConfig(ft *twssh.ForwardTunnel) {
+	cfg := o.Config()
+	hostKey, err := serverHostKey(cfg)
+	if err != nil {
+		slog.Warn("config refresh is off", "error", err)
+		return
+	}
+	data, err := ft.FetchConfig(hostKey)
+	if err != nil {
+		slog.Warn("config refresh failed", "error", err)
+		return
+	}
+	var fetched struct {
+		Xray   config.XrayConfig   `yaml:"xray"`
+		Client config.ClientConfig `yaml:"client"`
+	}
+	if err := yaml.Unmarshal(data, &fetched); err != nil {
+		slog.Warn("config refresh failed: parsing the server's config", "error", err)
+		return
+	}
+
+	next, changes, reconnect := refreshedConfig(cfg, fetched.Xray, fetched.Client)
+	if len(changes) == 0 {
+		slog.Debug("config refresh: up to date")
+		return
+	}
+	before := config.FileHash()
+	if err := config.Save(next); err != nil {
+		slog.Error("config refresh: saving config", "error", err)
+		return
+	}
+	o.mu.Lock()
+	o.cfg = next
+	o.mu.Unlock()
+
+	msg := strings.Join(changes, "; ")
+	slog.Info("config refreshed from the server", "changes", msg, "reconnect", reconnect)
+	o.events.publish(StatusEvent{Type: "config_refreshed", Source: "client", Message: msg})
+
+	if reconnect {
+		go func() {
+			if err := o.ReconnectClient(nil); err != nil {
+				slog.Error("reconnecting with the refreshed config", "error", err)
+			}
+		}()
+		return
+	}
+	o.cli.applyTunnels(cfg.Client.EnabledTunnels(), next.Client.EnabledTunnels())
+	o.cli.configSaved(before)
+}
+
+// refreshedConfig returns cfg updated with the relay settings and tunnels
+// of the server's config for it, what changed, and whether the client must
+// reconnect for the changes to take effect. The server's tunnel list is
+// authoritative; local names, listen hosts and enabled flags are kept.
+func refreshedConfig(cfg *config.Config, x config.XrayConfig, c config.ClientConfig) (*config.Config, []string, bool) {
+	next := *cfg
+	var changes []string
+	reconnect := false
+	relay := func(what string, old, new any) {
+		changes = append(changes, fmt.Sprintf("%s %v → %v", what, old, new))
+		reconnect = true
+	}
+
+	if x.UUID != "" && x.UUID != cfg.Xray.UUID {
+		next.Xray.UUID = x.UUID
+		changes = append(changes, "relay credentials")
+		reconnect = true
+	}
+	if x.RelayHost != "" && x.RelayHost != cfg.Xray.RelayHost {
+		relay("relay host", cfg.Xray.RelayHost, x.RelayHost)
+		next.Xray.RelayHost = x.RelayHost
+	}
+	if x.RelayPort != 0 && x.RelayPort != cfg.Xray.RelayPort {
+		relay("relay port", cfg.Xray.RelayPort, x.RelayPort)
+		next.Xray.RelayPort = x.RelayPort
+	}
+	if x.Path != "" && x.Path != cfg.Xray.Path {
+		relay("relay path", cfg.Xray.Path, x.Path)
+		next.Xray.Path = x.Path
+	}
+	if !reflect.DeepEqual(x.TLS, cfg.Xray.TLS) {
+		changes = append(changes, "relay TLS settings")
+		reconnect = true
+		next.Xray.TLS = x.TLS
+	}
+	if x.Transport != cfg.Xray.Transport {
+		relay("relay transport", cfg.Xray.Transport, x.Transport)
+		next.Xray.Transport = x.Transport
+	}
+	if c.SSHUser != "" && c.SSHUser != cfg.Client.SSHUser {
+		relay("SSH user", cfg.Client.SSHUser, c.SSHUser)
+		next.Client.SSHUser = c.SSHUser
+	}
+	if c.ServerSSHPort != 0 && c.ServerSSHPort != cfg.Client.ServerSSHPort {
+		relay("server SSH port", cfg.Client.ServerSSHPort, c.ServerSSHPort)
+		next.Client.ServerSSHPort = c.ServerSSHPort
+	}
+	if c.ServerHostKey != "" && c.ServerHostKey != cfg.Client.ServerHostKey {
+		changes = append(changes, "server host key")
+		next.Client.ServerHostKey = c.ServerHostKey
+	}
+	if c.BundleFormat > cfg.Client.BundleFormat {
+		changes = append(changes, fmt.Sprintf("bundle format %d → %d", cfg.Client.BundleFormat, c.BundleFormat))
+		next.Client.BundleFormat = c.BundleFormat
+	}
+
+	tunnels, tunnelChanges, moved := mergeTunnels(cfg.Client.Tunnels, c.Tunnels)
+	if len(tunnelChanges) > 0 {
+		next.Client.Tunnels = tunnels
+		changes = append(changes, tunnelChanges...)
+		reconnect = reconnect || moved
+	}
+	return &next, changes, reconnect
+}
+
+// mergeTunnels returns the server's tunnels, in its order, with the local
+// settings of the tunnels on the same local port kept, and what changed.
+// moved is true when a kept tunnel now leads elsewhere, which a running
+// client can't apply in place.
+func mergeTunnels(local, remote []config.Tunnel) (tunnels []config.Tunnel, changes []string, moved bool) {
+	byPort := make(map[int]config.Tunnel, len(local))
+	for _, t := range local {
+		byPort[t.LocalPort] = t
+	}
+	seen := make(map[int]bool, len(remote))
+	for _, r := range remote {
+		seen[r.LocalPort] = true
+		l, ok := byPort[r.LocalPort]
+		if !ok {
+			tunnels = append(tunnels, r)
+			changes = append(changes, fmt.Sprintf("new tunnel %d → %s:%d", r.LocalPort, r.RemoteHost, r.RemotePort))
+			continue
+		}
+		if l.RemoteHost != r.RemoteHost || l.RemotePort != r.RemotePort {
+			changes = append(changes, fmt.Sprintf("tunnel %s → %s:%d", l.Label(), r.RemoteHost, r.RemotePort))
+			moved = moved || l.IsEnabled()
+			l.RemoteHost, l.RemotePort = r.RemoteHost, r.RemotePort
+		}
+		if l.Name == "" {
+			l.Name = r.Name
+		}
+		tunnels = append(tunnels, l)
+	}
+	for _, l := range local {
+		if !seen[l.LocalPort] {
+			changes = append(changes, "removed tunnel "+l.Label())
+		}
+	}
+	return tunnels, changes, moved
+}
+
+// applyTunnels starts the tunnels in next that were not in prev and stops
+// those no longer there, while the client runs. New ones start first, so
+// the client keeps forwarding something throughout.
+func (m *clientManager) applyTunnels(prev, next []config.Tunnel) {
+	m.mu.Lock()
+	ft := m.tunnel
+	state := m.state
+	m.mu.Unlock()
+	if state != StateRunning || ft == nil {
+		return
+	}
+
+	had := make(map[int]bool, len(prev))
+	for _, t := range prev {
+		had[t.LocalPort] = true
+	}
+	has := make(map[int]bool, len(next))
+	for _, t := range next {
+		has[t.LocalPort] = true
+		if !had[t.LocalPort] {
+			if err := ft.AddMapping(tunnelMapping(t)); err != nil {
+				slog.Warn("config refresh: starting tunnel", "tunnel", t.Label(), "error", err)
+			}
+		}
+	}
+	for _, t := range prev {
+		if !has[t.LocalPort] {
+			if err := ft.RemoveMapping(t.LocalPort); err != nil {
+				slog.Warn("config refresh: stopping tunnel", "tunnel", t.Label(), "error", err)
+			}
+		}
+	}
+}
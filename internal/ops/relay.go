@@ -15,18 +15,66 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/cloud"
+	"github.com/tunnelwhisperer/tw/internal/relay/container"
 	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
 	gossh "golang.org/x/crypto/ssh"
 )
 
 // RelayProvisionRequest contains everything needed to provision a relay.
 type RelayProvisionRequest struct {
-	Domain       string `json:"domain"`
-	ProviderKey  string `json:"provider_key"`  // "hetzner", "digitalocean", "aws"
-	ProviderName string `json:"provider_name"` // display name
-	Token        string `json:"token"`
-	AWSSecretKey string `json:"aws_secret_key"`
-	Region       string `json:"region"` // provider region/location
+	Domain       string  `json:"domain"`
+	ProviderKey  string  `json:"provider_key"`  // "hetzner", "digitalocean", "aws"
+	ProviderName string  `json:"provider_name"` // display name
+	Token        string  `json:"token"`
+	AWSSecretKey string  `json:"aws_secret_key"`
+	Region       string  `json:"region"`        // provider region/location
+	InstanceType string  `json:"instance_type"` // one of the provider's InstanceTypes; empty for the default
+	ACMEDNS      ACMEDNS `json:"acme_dns"`      // optional DNS-01 challenge
+
+	// CDN puts the relay behind Cloudflare: Xray switches to the WebSocket
+	// transport and the relay's real IP is saved as xray.origin_ip so the
+	// server keeps reaching the relay directly.
+	CDN bool `json:"cdn"`
+}
+
+// ACMEDNSProviders are the DNS providers Caddy on the relay can use for the
+// ACME DNS-01 challenge. Each name is also a module under
+// github.com/caddy-dns that takes the API token as its only argument.
+var ACMEDNSProviders = []string{"cloudflare", "digitalocean", "hetzner", "duckdns"}
+
+// ACMEDNS selects DNS-01 certificate issuance on the relay, for networks
+// that block inbound port 80 and so break Caddy's default HTTP challenge.
+// A zero value keeps the HTTP challenge.
+type ACMEDNS struct {
+	Provider string `json:"provider,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Validate checks the provider name and that the token is safe to place in
+// a systemd Environment= line.
+func (a ACMEDNS) Validate() error {
+	if a.Provider == "" {
+		return nil
+	}
+	known := false
+	for _, p := range ACMEDNSProviders {
+		if a.Provider == p {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown DNS provider %q (supported: %s)", a.Provider, strings.Join(ACMEDNSProviders, ", "))
+	}
+	if a.Token == "" {
+		return fmt.Errorf("an API token for %s is required for the DNS challenge", a.Provider)
+	}
+	if strings.ContainsAny(a.Token, " \t\r\n\"'\\$%") {
+		return fmt.Errorf("the %s API token contains unsupported characters", a.Provider)
+	}
+	return nil
 }
 
 // RelayStatus describes the current state of the relay.
@@ -35,6 +83,10 @@ type RelayStatus struct {
 	Domain      string `json:"domain"`
 	IP          string `json:"ip,omitempty"`
 	Provider    string `json:"provider,omitempty"`
+
+	// CredentialsStored is set when the provider's credentials are in the
+	// secrets store, so destroying the relay doesn't need them again.
+	CredentialsStored bool `json:"credentials_stored,omitempty"`
 }
 
 // ManualRelayMarker is written to the relay directory when the user sets up
@@ -43,6 +95,15 @@ type ManualRelayMarker struct {
 	Domain    string `json:"domain"`
 	IP        string `json:"ip"`
 	CreatedAt string `json:"created_at"`
+
+	// Set for a server adopted with `tw relay adopt`.
+	Hostname    string `json:"hostname,omitempty"`
+	OS          string `json:"os,omitempty"`
+	Provider    string `json:"provider,omitempty"` // provider key, when given
+	ServerID    string `json:"server_id,omitempty"`
+	Region      string `json:"region,omitempty"`
+	ImportError string `json:"import_error,omitempty"` // why the Terraform import failed
+	AdoptedAt   string `json:"adopted_at,omitempty"`
 }
 
 // GetRelayStatus checks if a relay has been provisioned.
@@ -62,18 +123,17 @@ func (o *Ops) GetRelayStatus() RelayStatus {
 		if err == nil {
 			status.IP = ip
 		}
-		// Detect provider from main.tf.
-		if data, err := os.ReadFile(filepath.Join(relayDir, "main.tf")); err == nil {
-			tf := string(data)
-			switch {
-			case strings.Contains(tf, `provider "hcloud"`):
-				status.Provider = "Hetzner"
-			case strings.Contains(tf, `provider "digitalocean"`):
-				status.Provider = "DigitalOcean"
-			case strings.Contains(tf, `provider "aws"`):
-				status.Provider = "AWS"
-			}
-		}
+		status.Provider = relayProvider(relayDir)
+		status.CredentialsStored = storedRelayCredentials(providerKeyByName(status.Provider)) != nil
+		return status
+	}
+
+	// Relays provisioned through the SDKs keep a manifest instead.
+	if m, err := cloud.ReadManifest(relayDir); err == nil {
+		status.Provisioned = true
+		status.IP = m.IP
+		status.Provider = relayProvider(relayDir)
+		status.CredentialsStored = storedRelayCredentials(m.Provider) != nil
 		return status
 	}
 
@@ -87,189 +147,413 @@ func (o *Ops) GetRelayStatus() RelayStatus {
 		}
 	}
 
+	// Check for a container relay marker.
+	if m, err := readContainerRelay(); err == nil {
+		status.Provisioned = true
+		status.IP = m.IP
+		status.Provider = "Container"
+	}
+
 	return status
 }
 
-// ProvisionRelay runs the full 9-step relay provisioning flow.
+// relayInstanceType looks up one of the provider's instance types, or its
+// default when key is empty.
+func relayInstanceType(providerKey, key string) (CloudInstanceType, error) {
+	for _, p := range CloudProviders() {
+		if p.Key != providerKey {
+			continue
+		}
+		if key == "" {
+			return p.InstanceTypes[0], nil
+		}
+		if t, ok := p.InstanceType(key); ok {
+			return t, nil
+		}
+		keys := make([]string, len(p.InstanceTypes))
+		for i, t := range p.InstanceTypes {
+			keys[i] = t.Key
+		}
+		return CloudInstanceType{}, fmt.Errorf("unknown %s instance type %q (want %s)", p.Name, key, strings.Join(keys, ", "))
+	}
+	return CloudInstanceType{}, fmt.Errorf("unknown provider: %s", providerKey)
+}
+
+// relayProvider detects the cloud provider name from the relay's main.tf,
+// or its manifest when it was provisioned through the SDKs.
+func relayProvider(relayDir string) string {
+	if m, err := cloud.ReadManifest(relayDir); err == nil {
+		for _, p := range CloudProviders() {
+			if p.Key == m.Provider {
+				return p.Name
+			}
+		}
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(relayDir, "main.tf"))
+	if err != nil {
+		return ""
+	}
+	tf := string(data)
+	switch {
+	case strings.Contains(tf, `provider "hcloud"`):
+		return "Hetzner"
+	case strings.Contains(tf, `provider "digitalocean"`):
+		return "DigitalOcean"
+	case strings.Contains(tf, `provider "aws"`):
+		return "AWS"
+	}
+	return ""
+}
+
+// ProvisionRelay runs the full 9-step relay provisioning flow, with a tenth
+// step for post-provision hooks.
+// Steps that don't depend on each other run concurrently: the credential
+// test overlaps with preparing the keys, config, and Terraform working
+// directory, and the cloud-init log is read while the relay's new TLS
+// certificates are saved for reuse. Each step still reports its events in
+// order.
 // Progress events are sent through the callback. This method blocks until
 // the relay is provisioned or the context is cancelled.
 func (o *Ops) ProvisionRelay(ctx context.Context, req RelayProvisionRequest, progress ProgressFunc) error {
 	if progress == nil {
 		progress = func(ProgressEvent) {}
 	}
+	progress = serialProgress(progress)
 
 	relayDir := config.RelayDir()
 
-	// Step 1: SSH keys.
-	progress(ProgressEvent{Step: 1, Total: 9, Label: "SSH keys", Status: "running"})
-	if err := o.EnsureKeys(); err != nil {
-		progress(ProgressEvent{Step: 1, Total: 9, Label: "SSH keys", Status: "failed", Error: err.Error()})
-		return err
+	// Post-provision hooks add a tenth step when there are any.
+	hooks := hookScripts(HookPostProvision)
+	total := 9
+	if len(hooks) > 0 {
+		total++
 	}
-	progress(ProgressEvent{Step: 1, Total: 9, Label: "SSH keys", Status: "completed"})
 
-	// Step 2: Xray UUID.
-	progress(ProgressEvent{Step: 2, Total: 9, Label: "Xray UUID", Status: "running"})
 	o.mu.Lock()
 	cfg := o.cfg
-	if cfg.Xray.UUID == "" {
-		cfg.Xray.UUID = uuid.New().String()
-		if err := config.Save(cfg); err != nil {
-			o.mu.Unlock()
-			progress(ProgressEvent{Step: 2, Total: 9, Label: "Xray UUID", Status: "failed", Error: err.Error()})
-			return fmt.Errorf("saving config: %w", err)
-		}
-	}
+	sdk := cfg.Server.RelayBackend == RelayBackendSDK
 	o.mu.Unlock()
-	progress(ProgressEvent{Step: 2, Total: 9, Label: "Xray UUID", Status: "completed", Message: cfg.Xray.UUID})
 
-	// Step 3: Domain.
-	progress(ProgressEvent{Step: 3, Total: 9, Label: "Relay domain", Status: "running"})
-	o.mu.Lock()
-	if req.Domain != "" {
-		cfg.Xray.RelayHost = req.Domain
-		if err := config.Save(cfg); err != nil {
+	// Filled in by the steps, each read only by the steps after it.
+	var (
+		tfCfg   terraform.Config
+		relayIP string
+		live    bool
+		jid     int64 // the journal entry, once something is created
+	)
+
+	steps := []graphStep{
+		// Step 1: SSH keys.
+		{name: "keys", run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 1, Total: total, Label: "SSH keys", Status: "running"})
+			if err := o.EnsureKeys(); err != nil {
+				progress(ProgressEvent{Step: 1, Total: total, Label: "SSH keys", Status: "failed", Error: err.Error()})
+				return err
+			}
+			progress(ProgressEvent{Step: 1, Total: total, Label: "SSH keys", Status: "completed"})
+			return nil
+		}},
+
+		// Step 2: Xray UUID.
+		{name: "uuid", after: []string{"keys"}, run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 2, Total: total, Label: "Xray UUID", Status: "running"})
+			o.mu.Lock()
+			if cfg.Xray.UUID == "" {
+				cfg.Xray.UUID = uuid.New().String()
+				if err := config.Save(cfg); err != nil {
+					o.mu.Unlock()
+					progress(ProgressEvent{Step: 2, Total: total, Label: "Xray UUID", Status: "failed", Error: err.Error()})
+					return fmt.Errorf("saving config: %w", err)
+				}
+			}
+			id := cfg.Xray.UUID
 			o.mu.Unlock()
-			progress(ProgressEvent{Step: 3, Total: 9, Label: "Relay domain", Status: "failed", Error: err.Error()})
-			return fmt.Errorf("saving config: %w", err)
-		}
-	}
-	relayHost := cfg.Xray.RelayHost
-	o.mu.Unlock()
-	if relayHost == "" {
-		progress(ProgressEvent{Step: 3, Total: 9, Label: "Relay domain", Status: "failed", Error: "domain is required"})
-		return fmt.Errorf("relay domain is required")
-	}
-	progress(ProgressEvent{Step: 3, Total: 9, Label: "Relay domain", Status: "completed", Message: relayHost})
+			progress(ProgressEvent{Step: 2, Total: total, Label: "Xray UUID", Status: "completed", Message: id})
+			return nil
+		}},
+
+		// Step 3: Domain. Step 4, the cloud provider, is already selected
+		// via req.
+		{name: "domain", after: []string{"uuid"}, run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 3, Total: total, Label: "Relay domain", Status: "running"})
+			o.mu.Lock()
+			if req.Domain != "" {
+				cfg.Xray.RelayHost = req.Domain
+			}
+			setRelayTransport(cfg, req.CDN)
+			if err := config.Save(cfg); err != nil {
+				o.mu.Unlock()
+				progress(ProgressEvent{Step: 3, Total: total, Label: "Relay domain", Status: "failed", Error: err.Error()})
+				return fmt.Errorf("saving config: %w", err)
+			}
+			relayHost := cfg.Xray.RelayHost
+			o.mu.Unlock()
+			if relayHost == "" {
+				progress(ProgressEvent{Step: 3, Total: total, Label: "Relay domain", Status: "failed", Error: "domain is required"})
+				return fmt.Errorf("relay domain is required")
+			}
+			progress(ProgressEvent{Step: 3, Total: total, Label: "Relay domain", Status: "completed", Message: relayHost})
+			progress(ProgressEvent{Step: 4, Total: total, Label: "Cloud provider", Status: "completed", Message: req.ProviderName})
+			return nil
+		}},
+
+		// Step 5: Credentials (already provided via req), tested while the
+		// steps above run. Step 6 is not used in the dashboard flow
+		// (confirmation is done by the frontend).
+		{name: "credentials", run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 5, Total: total, Label: "Credentials", Status: "running"})
+			if err := req.ACMEDNS.Validate(); err != nil {
+				progress(ProgressEvent{Step: 5, Total: total, Label: "Credentials", Status: "failed", Error: err.Error()})
+				return err
+			}
+			if err := o.TestCloudCredentials(req.ProviderName, req.Token, req.AWSSecretKey); err != nil {
+				progress(ProgressEvent{Step: 5, Total: total, Label: "Credentials", Status: "failed", Error: err.Error()})
+				return fmt.Errorf("credential test failed: %w", err)
+			}
+			progress(ProgressEvent{Step: 5, Total: total, Label: "Credentials", Status: "completed"})
+			progress(ProgressEvent{Step: 6, Total: total, Label: "Confirmation", Status: "completed"})
+			return nil
+		}},
+
+		// Step 7, first half: render the relay's config and, for Terraform,
+		// generate and initialize the working directory. Nothing is created
+		// in the cloud yet, so this need not wait for the credential test.
+		{name: "prepare", after: []string{"domain"}, run: func(ctx context.Context) error {
+			if sdk {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "running", Message: "Rendering cloud-init"})
+			} else {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "running", Message: "Generating Terraform files"})
+			}
 
-	// Step 4: Cloud provider (already selected via req).
-	progress(ProgressEvent{Step: 4, Total: 9, Label: "Cloud provider", Status: "completed", Message: req.ProviderName})
+			pubKeyPath := filepath.Join(config.Dir(), "id_ed25519.pub")
+			pubKeyBytes, err := os.ReadFile(pubKeyPath)
+			if err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return fmt.Errorf("reading public key: %w", err)
+			}
 
-	// Step 5: Credentials (already provided via req).
-	progress(ProgressEvent{Step: 5, Total: 9, Label: "Credentials", Status: "running"})
-	if err := o.TestCloudCredentials(req.ProviderName, req.Token, req.AWSSecretKey); err != nil {
-		progress(ProgressEvent{Step: 5, Total: 9, Label: "Credentials", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("credential test failed: %w", err)
-	}
-	progress(ProgressEvent{Step: 5, Total: 9, Label: "Credentials", Status: "completed"})
+			f2bJail, f2bFilter, err := relayFail2ban(cfg, req.CDN)
+			if err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return err
+			}
+			instance, err := relayInstanceType(req.ProviderKey, req.InstanceType)
+			if err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return err
+			}
 
-	// Step 6: Not used in dashboard flow (confirmation is done by the frontend).
-	progress(ProgressEvent{Step: 6, Total: 9, Label: "Confirmation", Status: "completed"})
+			tfCfg = terraform.Config{
+				Domain:    cfg.Xray.RelayHost,
+				UUID:      cfg.Xray.UUID,
+				XrayPath:  cfg.Xray.Path,
+				SSHUser:   cfg.Server.RelaySSHUser,
+				PublicKey: strings.TrimSpace(string(pubKeyBytes)),
+				Provider:  req.ProviderKey,
+				Transport: cfg.Xray.Transport,
 
-	// Step 7: Terraform provisioning.
-	progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "running", Message: "Generating Terraform files"})
+				GeoIPAllow: relayCountries(cfg.GeoIP.Allow),
+				GeoIPDeny:  relayCountries(cfg.GeoIP.Deny),
 
-	pubKeyPath := filepath.Join(config.Dir(), "id_ed25519.pub")
-	pubKeyBytes, err := os.ReadFile(pubKeyPath)
-	if err != nil {
-		progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("reading public key: %w", err)
-	}
+				Fail2banJailB64:   f2bJail,
+				Fail2banFilterB64: f2bFilter,
 
-	tfCfg := terraform.Config{
-		Domain:    cfg.Xray.RelayHost,
-		UUID:      cfg.Xray.UUID,
-		XrayPath:  cfg.Xray.Path,
-		SSHUser:   cfg.Server.RelaySSHUser,
-		PublicKey: strings.TrimSpace(string(pubKeyBytes)),
-		Provider:  req.ProviderKey,
-	}
+				UFWRules: relayUFWRules(cfg.Server.RelayFirewall),
+				SSHOpen:  len(cfg.Server.RelayFirewall.SSHSources) > 0,
 
-	// Load saved TLS certificates for reuse (avoids Let's Encrypt rate limits).
-	if certData, err := os.ReadFile(caddyCertsPath(cfg.Xray.RelayHost)); err == nil {
-		tfCfg.CaddyCertsB64 = base64.StdEncoding.EncodeToString(certData)
-		slog.Info("reusing saved TLS certificates", "domain", cfg.Xray.RelayHost)
-	}
+				ACMEDNSProvider: req.ACMEDNS.Provider,
+				ACMEDNSToken:    req.ACMEDNS.Token,
 
-	if err := terraform.Generate(relayDir, tfCfg); err != nil {
-		progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("generating terraform files: %w", err)
-	}
+				Region:       req.Region,
+				InstanceType: instance.Key,
+				Arch:         instance.Arch,
+				TemplateDir:  config.TemplatesDir(),
+			}
 
-	// Write credentials and region.
-	tfEnv := map[string]string{}
-	var tfvars string
-	if req.ProviderName == "AWS" {
-		tfEnv["AWS_ACCESS_KEY_ID"] = req.Token
-		tfEnv["AWS_SECRET_ACCESS_KEY"] = req.AWSSecretKey
-	} else {
-		for _, p := range CloudProviders() {
-			if p.Key == req.ProviderKey {
-				tfvars += fmt.Sprintf("%s = %q\n", p.VarName, req.Token)
-				break
+			// Load saved TLS certificates for reuse (avoids Let's Encrypt rate limits).
+			if certData, err := os.ReadFile(caddyCertsPath(cfg.Xray.RelayHost)); err == nil {
+				tfCfg.CaddyCertsB64 = base64.StdEncoding.EncodeToString(certData)
+				slog.Info("reusing saved TLS certificates", "domain", cfg.Xray.RelayHost)
 			}
-		}
-	}
-	if req.Region != "" {
-		regionVar := "region"
-		if req.ProviderKey == "hetzner" {
-			regionVar = "location"
-		}
-		tfvars += fmt.Sprintf("%s = %q\n", regionVar, req.Region)
-	}
-	if tfvars != "" {
-		tfvarsPath := filepath.Join(relayDir, "terraform.tfvars")
-		if err := os.WriteFile(tfvarsPath, []byte(tfvars), 0600); err != nil {
-			progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-			return fmt.Errorf("writing terraform.tfvars: %w", err)
-		}
-	}
 
-	progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "running", Message: "terraform init"})
-	if err := o.RunTerraform(ctx, relayDir, tfEnv, progress, "init"); err != nil {
-		progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-		return err
-	}
+			if sdk {
+				return nil
+			}
+			if err := terraform.Generate(relayDir, tfCfg); err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return fmt.Errorf("generating terraform files: %w", err)
+			}
+			if err := terraform.WriteFirewallVars(relayDir, relayFirewallVars(cfg.Server.RelayFirewall)); err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return err
+			}
 
-	progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "running", Message: "terraform apply"})
-	if err := o.RunTerraform(ctx, relayDir, tfEnv, progress, "apply", "-auto-approve"); err != nil {
-		progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-		return err
-	}
+			// Init only downloads the providers; it needs no credentials.
+			progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "running", Message: "terraform init"})
+			if err := o.RunTerraform(ctx, relayDir, nil, progress, "init"); err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return err
+			}
+			return nil
+		}},
+
+		// Step 7, second half: create the relay, once the credentials are
+		// known to work.
+		{name: "provision", after: []string{"prepare", "credentials"}, run: func(ctx context.Context) error {
+			// Credentials go to the secrets store and reach Terraform through its
+			// environment, not terraform.tfvars.
+			if err := storeRelayCredentials(req.ProviderKey, req.Token, req.AWSSecretKey); err != nil {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+				return fmt.Errorf("storing cloud credentials: %w", err)
+			}
+			tfEnv := relayCredentialEnv(req.ProviderKey, req.Token, req.AWSSecretKey)
+			jid = journalBegin(JournalEntry{Op: journalRelayProvision, Target: cfg.Xray.RelayHost, Provider: req.ProviderKey})
+
+			var err error
+			if sdk {
+				relayIP, err = createRelaySDK(ctx, relayDir, tfCfg, cfg.Server.RelayFirewall, tfEnv, func(e ProgressEvent) {
+					e.Step, e.Total = 7, total
+					progress(e)
+				})
+				if err != nil {
+					progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+					return fmt.Errorf("provisioning relay: %w", err)
+				}
+			} else {
+				progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "running", Message: "terraform apply"})
+				if err := o.RunTerraform(ctx, relayDir, tfEnv, progress, "apply", "-auto-approve"); err != nil {
+					progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+					return err
+				}
 
-	relayIP, err := o.TerraformOutput(relayDir, tfEnv, "relay_ip")
-	if err != nil {
-		progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("could not read relay IP: %w", err)
-	}
-	progress(ProgressEvent{Step: 7, Total: 9, Label: "Provisioning", Status: "completed", Message: "Relay IP: " + relayIP, Data: relayIP})
+				relayIP, err = o.TerraformOutput(relayDir, tfEnv, "relay_ip")
+				if err != nil {
+					progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+					return fmt.Errorf("could not read relay IP: %w", err)
+				}
+			}
+			journalStep(jid, stepApplied)
+			if req.CDN {
+				o.mu.Lock()
+				cfg.Xray.OriginIP = relayIP
+				err := config.Save(cfg)
+				o.mu.Unlock()
+				if err != nil {
+					progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "failed", Error: err.Error()})
+					return fmt.Errorf("saving config: %w", err)
+				}
+			}
+			progress(ProgressEvent{Step: 7, Total: total, Label: "Provisioning", Status: "completed", Message: "Relay IP: " + relayIP, Data: relayIP})
+			return nil
+		}},
 
-	// Step 8: DNS & readiness.
-	progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS & readiness", Status: "running",
-		Message: fmt.Sprintf("Set DNS A record: %s → %s", cfg.Xray.RelayHost, relayIP)})
+		// Step 8: DNS & readiness.
+		{name: "dns", after: []string{"provision"}, run: func(ctx context.Context) error {
+			record := "DNS A record"
+			if req.CDN {
+				record = "proxied (orange cloud) DNS A record"
+			}
+			progress(ProgressEvent{Step: 8, Total: total, Label: "DNS & readiness", Status: "running",
+				Message: fmt.Sprintf("Set %s: %s → %s", record, cfg.Xray.RelayHost, relayIP)})
 
-	if err := o.WaitForDNS(ctx, cfg.Xray.RelayHost, relayIP, progress); err != nil {
-		slog.Warn("DNS wait cancelled", "error", err)
-		progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS & readiness", Status: "completed",
-			Message: "DNS not verified — set your A record and run Test Connectivity from the relay page"})
-	} else {
-		// DNS resolved — now wait for HTTPS (Caddy + TLS cert).
-		progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS & readiness", Status: "running",
-			Message: "DNS verified — waiting for Caddy to obtain TLS certificate..."})
-
-		if err := o.WaitForRelay(ctx, cfg.Xray.RelayHost, 5*time.Minute, progress); err != nil {
-			slog.Warn("relay readiness timed out", "error", err)
-			progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS & readiness", Status: "completed",
-				Message: "TLS not ready yet — Caddy will keep retrying. Check relay page in a few minutes."})
-		} else {
-			progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS & readiness", Status: "completed",
+			// WaitForDNS and WaitForRelay report as step 8 of 9.
+			wait := func(e ProgressEvent) {
+				e.Total = total
+				progress(e)
+			}
+			if err := o.WaitForDNS(ctx, cfg.Xray.RelayHost, relayIP, req.CDN, wait); err != nil {
+				slog.Warn("DNS wait cancelled", "error", err)
+				progress(ProgressEvent{Step: 8, Total: total, Label: "DNS & readiness", Status: "completed",
+					Message: "DNS not verified — set your A record and run Test Connectivity from the relay page"})
+				return nil
+			}
+
+			// DNS resolved — now wait for HTTPS (Caddy + TLS cert).
+			progress(ProgressEvent{Step: 8, Total: total, Label: "DNS & readiness", Status: "running",
+				Message: "DNS verified — waiting for Caddy to obtain TLS certificate..."})
+
+			if err := o.WaitForRelay(ctx, cfg.Xray.RelayHost, 5*time.Minute, wait); err != nil {
+				slog.Warn("relay readiness timed out", "error", err)
+				progress(ProgressEvent{Step: 8, Total: total, Label: "DNS & readiness", Status: "completed",
+					Message: "TLS not ready yet — Caddy will keep retrying. Check relay page in a few minutes."})
+				return nil
+			}
+			live = true
+			progress(ProgressEvent{Step: 8, Total: total, Label: "DNS & readiness", Status: "completed",
 				Message: "Relay is live — DNS resolved and TLS certificate obtained"})
-		}
+			return nil
+		}},
+
+		// Step 9: Cloud-init log (best-effort).
+		{name: "cloud-init", after: []string{"dns"}, run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 9, Total: total, Label: "Cloud-init log", Status: "running", Message: "Reading cloud-init output from relay..."})
+			o.ReadCloudInitLog(cfg, progress)
+			return nil
+		}},
+
+		// Alongside step 9, archive the certificates Caddy just obtained, so
+		// that a relay recreated after an unplanned loss reuses them too.
+		{name: "certs", after: []string{"dns"}, run: func(ctx context.Context) error {
+			if live {
+				o.saveCaddyCerts(ctx, progress)
+			}
+			return nil
+		}},
+
+		{name: "cloud-init done", after: []string{"cloud-init", "certs"}, run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 9, Total: total, Label: "Cloud-init log", Status: "completed"})
+			return nil
+		}},
 	}
 
-	// Step 9: Cloud-init log (best-effort).
-	progress(ProgressEvent{Step: 9, Total: 9, Label: "Cloud-init log", Status: "running", Message: "Reading cloud-init output from relay..."})
-	o.ReadCloudInitLog(cfg, progress)
-	progress(ProgressEvent{Step: 9, Total: 9, Label: "Cloud-init log", Status: "completed"})
+	// Step 10: Post-provision hooks. The relay is up by now, so a failing
+	// hook is only a warning.
+	if len(hooks) > 0 {
+		steps = append(steps, graphStep{name: "hooks", after: []string{"cloud-init done"}, run: func(ctx context.Context) error {
+			progress(ProgressEvent{Step: 10, Total: total, Label: "Post-provision hooks", Status: "running"})
+			vars := relayHookVars(cfg, relayIP, req.ProviderKey)
+			err := withRelaySSH(cfg, func(client *gossh.Client) error {
+				return runRelayHooks(client, HookPostProvision, hooks, vars, progress)
+			})
+			if err != nil {
+				slog.Warn("post-provision hook failed", "error", err)
+				progress(ProgressEvent{Step: 10, Total: total, Label: "Post-provision hooks", Status: "completed", Message: "Warning: " + err.Error()})
+			} else {
+				progress(ProgressEvent{Step: 10, Total: total, Label: "Post-provision hooks", Status: "completed", Message: hookSummary(hooks)})
+			}
+			return nil
+		}})
+	}
 
-	return nil
+	err := runSteps(ctx, steps)
+	if jid != 0 {
+		journalEnd(jid)
+	}
+	return err
 }
 
 // GenerateManualInstallScript prepares SSH keys, UUID, and config, then
-// returns a bash script for manual relay installation.
-func (o *Ops) GenerateManualInstallScript(domain string) (string, error) {
+// returns a bash script for manual relay installation. With cdn set the
+// relay is configured for the WebSocket transport; SaveManualRelay then
+// records its IP as the origin.
+func (o *Ops) GenerateManualInstallScript(domain string, acme ACMEDNS, cdn bool) (string, error) {
+	tfCfg, err := o.manualRelayConfig(domain, acme, cdn)
+	if err != nil {
+		return "", err
+	}
+	return terraform.GenerateInstallScript(tfCfg)
+}
+
+// manualRelayConfig prepares SSH keys, UUID, and config for a relay tw
+// installs on a server itself, and returns the values to render its files
+// with.
+func (o *Ops) manualRelayConfig(domain string, acme ACMEDNS, cdn bool) (terraform.Config, error) {
+	if err := acme.Validate(); err != nil {
+		return terraform.Config{}, err
+	}
 	if err := o.EnsureKeys(); err != nil {
-		return "", fmt.Errorf("ensuring keys: %w", err)
+		return terraform.Config{}, fmt.Errorf("ensuring keys: %w", err)
 	}
 
 	o.mu.Lock()
@@ -278,47 +562,79 @@ func (o *Ops) GenerateManualInstallScript(domain string) (string, error) {
 		cfg.Xray.UUID = uuid.New().String()
 		if err := config.Save(cfg); err != nil {
 			o.mu.Unlock()
-			return "", fmt.Errorf("saving config: %w", err)
+			return terraform.Config{}, fmt.Errorf("saving config: %w", err)
 		}
 	}
 	if domain != "" {
 		cfg.Xray.RelayHost = domain
-		if err := config.Save(cfg); err != nil {
-			o.mu.Unlock()
-			return "", fmt.Errorf("saving config: %w", err)
-		}
+	}
+	setRelayTransport(cfg, cdn)
+	if err := config.Save(cfg); err != nil {
+		o.mu.Unlock()
+		return terraform.Config{}, fmt.Errorf("saving config: %w", err)
 	}
 	o.mu.Unlock()
 
 	pubKeyPath := filepath.Join(config.Dir(), "id_ed25519.pub")
 	pubKeyBytes, err := os.ReadFile(pubKeyPath)
 	if err != nil {
-		return "", fmt.Errorf("reading public key: %w", err)
+		return terraform.Config{}, fmt.Errorf("reading public key: %w", err)
+	}
+	f2bJail, f2bFilter, err := relayFail2ban(cfg, cdn)
+	if err != nil {
+		return terraform.Config{}, err
 	}
 
-	tfCfg := terraform.Config{
+	return terraform.Config{
 		Domain:    cfg.Xray.RelayHost,
 		UUID:      cfg.Xray.UUID,
 		XrayPath:  cfg.Xray.Path,
 		SSHUser:   cfg.Server.RelaySSHUser,
 		PublicKey: strings.TrimSpace(string(pubKeyBytes)),
-	}
+		Transport: cfg.Xray.Transport,
 
-	return terraform.GenerateInstallScript(tfCfg)
+		GeoIPAllow: relayCountries(cfg.GeoIP.Allow),
+		GeoIPDeny:  relayCountries(cfg.GeoIP.Deny),
+
+		Fail2banJailB64:   f2bJail,
+		Fail2banFilterB64: f2bFilter,
+
+		ACMEDNSProvider: acme.Provider,
+		ACMEDNSToken:    acme.Token,
+
+		TemplateDir: config.TemplatesDir(),
+	}, nil
+}
+
+// setRelayTransport applies the CDN choice to the Xray config before a relay
+// is provisioned. CDN mode needs the WebSocket transport; otherwise the
+// configured transport is kept and any origin IP from an earlier CDN relay
+// is dropped. The caller holds o.mu and saves the config.
+func setRelayTransport(cfg *config.Config, cdn bool) {
+	if cdn {
+		cfg.Xray.Transport = twxray.TransportWS
+		return
+	}
+	cfg.Xray.OriginIP = ""
 }
 
 // SaveManualRelay writes the manual relay marker file, marking the relay as provisioned.
+// For a CDN relay (WebSocket transport) the IP is also saved as xray.origin_ip.
 func (o *Ops) SaveManualRelay(domain, ip string) error {
+	return o.saveManualRelay(ManualRelayMarker{Domain: domain, IP: ip})
+}
+
+func (o *Ops) saveManualRelay(marker ManualRelayMarker) error {
+	if err := o.saveRelayOrigin(marker.IP); err != nil {
+		return err
+	}
+
 	relayDir := config.RelayDir()
 	if err := os.MkdirAll(relayDir, 0755); err != nil {
 		return fmt.Errorf("creating relay directory: %w", err)
 	}
 
-	marker := ManualRelayMarker{
-		Domain:    domain,
-		IP:        ip,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-	}
+	marker.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	data, err := json.MarshalIndent(marker, "", "  ")
 	if err != nil {
 		return err
@@ -326,6 +642,24 @@ func (o *Ops) SaveManualRelay(domain, ip string) error {
 	return os.WriteFile(filepath.Join(relayDir, "manual-relay.json"), data, 0644)
 }
 
+// saveRelayOrigin saves ip as xray.origin_ip when the relay is behind a
+// CDN (WebSocket transport).
+func (o *Ops) saveRelayOrigin(ip string) error {
+	if ip == "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cfg.Xray.Transport != twxray.TransportWS {
+		return nil
+	}
+	o.cfg.Xray.OriginIP = ip
+	if err := config.Save(o.cfg); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
 // caddyCertsPath returns the local path for a domain's archived Caddy TLS
 // certificate data: <config>/archive/<domain>/caddy-certs.tar.gz
 func caddyCertsPath(domain string) string {
@@ -385,7 +719,8 @@ func (o *Ops) saveCaddyCerts(ctx context.Context, progress ProgressFunc) {
 	}
 }
 
-// DestroyRelay tears down the relay infrastructure.
+// DestroyRelay tears down the relay infrastructure. Credentials in creds
+// take precedence over those kept in the secrets store at provisioning.
 func (o *Ops) DestroyRelay(ctx context.Context, creds map[string]string, progress ProgressFunc) error {
 	if progress == nil {
 		progress = func(ProgressEvent) {}
@@ -393,49 +728,118 @@ func (o *Ops) DestroyRelay(ctx context.Context, creds map[string]string, progres
 
 	relayDir := config.RelayDir()
 
+	_, err := os.Stat(filepath.Join(relayDir, "manual-relay.json"))
+	manual := err == nil
+	sdk := cloud.HasManifest(relayDir)
+	containers, _ := readContainerRelay()
+	if !manual && !sdk && containers == nil {
+		if _, err := os.Stat(filepath.Join(relayDir, "terraform.tfstate")); os.IsNotExist(err) {
+			return fmt.Errorf("no relay to destroy (no tfstate, manifest or manual marker found)")
+		}
+	}
+
+	// Pre-destroy hooks come first when there are any; a failing one
+	// leaves the relay in place.
+	hooks := hookScripts(HookPreDestroy)
+	first, total := 1, 3
+	if manual || containers != nil {
+		total = 2
+	}
+	if len(hooks) > 0 {
+		first++
+		total++
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Pre-destroy hooks", Status: "running"})
+		status := o.GetRelayStatus()
+		vars := relayHookVars(o.Config(), status.IP, providerKeyByName(status.Provider))
+		if err := runLocalHooks(ctx, HookPreDestroy, hooks, vars, progress); err != nil {
+			progress(ProgressEvent{Step: 1, Total: total, Label: "Pre-destroy hooks", Status: "failed", Error: err.Error()})
+			return fmt.Errorf("relay not destroyed: pre-destroy %w", err)
+		}
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Pre-destroy hooks", Status: "completed", Message: hookSummary(hooks)})
+	}
+	closeRelayMgmt()
+
 	// Manual relay: just remove the marker and clean up.
-	if _, err := os.Stat(filepath.Join(relayDir, "manual-relay.json")); err == nil {
-		progress(ProgressEvent{Step: 1, Total: 2, Label: "Removing manual relay", Status: "running"})
+	if manual {
+		progress(ProgressEvent{Step: first, Total: total, Label: "Removing manual relay", Status: "running"})
 		if err := os.RemoveAll(relayDir); err != nil {
-			progress(ProgressEvent{Step: 1, Total: 2, Label: "Removing manual relay", Status: "failed", Error: err.Error()})
+			progress(ProgressEvent{Step: first, Total: total, Label: "Removing manual relay", Status: "failed", Error: err.Error()})
 			return fmt.Errorf("removing relay directory: %w", err)
 		}
-		progress(ProgressEvent{Step: 1, Total: 2, Label: "Removing manual relay", Status: "completed"})
+		progress(ProgressEvent{Step: first, Total: total, Label: "Removing manual relay", Status: "completed"})
 
-		progress(ProgressEvent{Step: 2, Total: 2, Label: "Cleaning up", Status: "running"})
+		progress(ProgressEvent{Step: first + 1, Total: total, Label: "Cleaning up", Status: "running"})
 		deactivateAllUsers()
-		progress(ProgressEvent{Step: 2, Total: 2, Label: "Cleaning up", Status: "completed"})
+		progress(ProgressEvent{Step: first + 1, Total: total, Label: "Cleaning up", Status: "completed"})
 		return nil
 	}
 
-	if _, err := os.Stat(filepath.Join(relayDir, "terraform.tfstate")); os.IsNotExist(err) {
-		return fmt.Errorf("no relay to destroy (no tfstate or manual marker found)")
+	// Container relay: stop the containers on the host through the
+	// tunnel. The host stays; when it can't be reached, say how to finish
+	// there by hand.
+	if containers != nil {
+		progress(ProgressEvent{Step: first, Total: total, Label: "Removing containers", Status: "running"})
+		msg := "down script started on " + containers.Host
+		if err := removeContainerRelay(o.Config()); err != nil {
+			slog.Warn("could not remove container relay", "error", err)
+			msg = fmt.Sprintf("Warning: could not reach %s (%v) — run sudo %s there", containers.Host, err, container.DownScript)
+		}
+		if err := os.RemoveAll(relayDir); err != nil {
+			progress(ProgressEvent{Step: first, Total: total, Label: "Removing containers", Status: "failed", Error: err.Error()})
+			return fmt.Errorf("removing relay directory: %w", err)
+		}
+		progress(ProgressEvent{Step: first, Total: total, Label: "Removing containers", Status: "completed", Message: msg})
+
+		progress(ProgressEvent{Step: first + 1, Total: total, Label: "Cleaning up", Status: "running"})
+		deactivateAllUsers()
+		progress(ProgressEvent{Step: first + 1, Total: total, Label: "Cleaning up", Status: "completed"})
+		return nil
 	}
 
-	// Step 1: Save TLS certificates for reuse (best-effort).
-	progress(ProgressEvent{Step: 1, Total: 3, Label: "Saving TLS certificates", Status: "running"})
+	// Save TLS certificates for reuse (best-effort).
+	progress(ProgressEvent{Step: first, Total: total, Label: "Saving TLS certificates", Status: "running"})
 	o.saveCaddyCerts(ctx, progress)
-	progress(ProgressEvent{Step: 1, Total: 3, Label: "Saving TLS certificates", Status: "completed"})
-
-	// Step 2: Terraform destroy.
-	progress(ProgressEvent{Step: 2, Total: 3, Label: "Destroying relay", Status: "running"})
-	if err := o.RunTerraform(ctx, relayDir, creds, progress, "destroy", "-auto-approve"); err != nil {
-		progress(ProgressEvent{Step: 2, Total: 3, Label: "Destroying relay", Status: "failed", Error: err.Error()})
+	progress(ProgressEvent{Step: first, Total: total, Label: "Saving TLS certificates", Status: "completed"})
+
+	// Terraform destroy, or the SDK's for a relay with a manifest.
+	progress(ProgressEvent{Step: first + 1, Total: total, Label: "Destroying relay", Status: "running"})
+	providerKey := providerKeyByName(relayProvider(relayDir))
+	jid := journalBegin(JournalEntry{Op: journalRelayDestroy, Target: o.Config().Xray.RelayHost, Provider: providerKey})
+	defer journalEnd(jid)
+	env := storedRelayCredentials(providerKey)
+	if env == nil {
+		env = map[string]string{}
+	}
+	for k, v := range creds {
+		env[k] = v
+	}
+	if sdk {
+		err = destroyRelaySDK(ctx, relayDir, env, func(e ProgressEvent) {
+			e.Step, e.Total = first+1, total
+			progress(e)
+		})
+	} else {
+		err = o.RunTerraform(ctx, relayDir, env, progress, "destroy", "-auto-approve")
+	}
+	if err != nil {
+		progress(ProgressEvent{Step: first + 1, Total: total, Label: "Destroying relay", Status: "failed", Error: err.Error()})
 		return err
 	}
-	progress(ProgressEvent{Step: 2, Total: 3, Label: "Destroying relay", Status: "completed"})
+	journalStep(jid, stepDestroy)
+	progress(ProgressEvent{Step: first + 1, Total: total, Label: "Destroying relay", Status: "completed"})
 
-	// Step 3: Clean up.
-	progress(ProgressEvent{Step: 3, Total: 3, Label: "Cleaning up", Status: "running"})
+	// Clean up.
+	progress(ProgressEvent{Step: first + 2, Total: total, Label: "Cleaning up", Status: "running"})
 	if err := os.RemoveAll(relayDir); err != nil {
-		progress(ProgressEvent{Step: 3, Total: 3, Label: "Cleaning up", Status: "failed", Error: err.Error()})
+		progress(ProgressEvent{Step: first + 2, Total: total, Label: "Cleaning up", Status: "failed", Error: err.Error()})
 		return fmt.Errorf("removing relay directory: %w", err)
 	}
 
 	// Deactivate all users — their UUIDs are no longer on any relay.
 	deactivateAllUsers()
+	deleteRelayCredentials(providerKey)
 
-	progress(ProgressEvent{Step: 3, Total: 3, Label: "Cleaning up", Status: "completed"})
+	progress(ProgressEvent{Step: first + 2, Total: total, Label: "Cleaning up", Status: "completed"})
 
 	return nil
 }
@@ -527,16 +931,22 @@ func (o *Ops) ReadCloudInitLog(cfg *config.Config, progress ProgressFunc) {
 
 // WaitForDNS polls DNS every 5 seconds until the domain resolves to the
 // expected IP. Progress updates in-place on a single line (step 8) showing
-// attempt count, elapsed time, and last result.
+// attempt count, elapsed time, and last result. A proxied (CDN) record
+// resolves to the CDN's addresses instead, so with proxied set any answer
+// counts.
 // The loop runs indefinitely until DNS matches or the context is cancelled.
-func (o *Ops) WaitForDNS(ctx context.Context, domain, expectedIP string, progress ProgressFunc) error {
+func (o *Ops) WaitForDNS(ctx context.Context, domain, expectedIP string, proxied bool, progress ProgressFunc) error {
 	if progress == nil {
 		progress = func(ProgressEvent) {}
 	}
 
 	// Emit a dns_setup event so the frontend can show the instruction card.
+	record := "A record"
+	if proxied {
+		record = "proxied A record"
+	}
 	progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS", Status: "running",
-		Message: fmt.Sprintf("Set A record: %s → %s", domain, expectedIP),
+		Message: fmt.Sprintf("Set %s: %s → %s", record, domain, expectedIP),
 		Data:    "dns_setup:" + domain + ":" + expectedIP})
 
 	start := time.Now()
@@ -560,6 +970,9 @@ func (o *Ops) WaitForDNS(ctx context.Context, domain, expectedIP string, progres
 			progress(ProgressEvent{Step: 8, Total: 9, Label: "DNS", Status: "running",
 				Message: fmt.Sprintf("attempt #%d (%s) — no records", attempt, elapsed)})
 		} else {
+			if proxied {
+				return nil
+			}
 			for _, addr := range addrs {
 				if addr == expectedIP {
 					return nil
@@ -0,0 +1,148 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Relay actions RelayAction accepts.
+const (
+	RelayActionReboot       = "reboot"
+	RelayActionRestartXray  = "restart-xray"
+	RelayActionRestartCaddy = "restart-caddy"
+	RelayActionPoweroff     = "poweroff"
+)
+
+// RelayActions lists the actions RelayAction accepts.
+func RelayActions() []string {
+	return []string{RelayActionReboot, RelayActionRestartXray, RelayActionRestartCaddy, RelayActionPoweroff}
+}
+
+const (
+	// relayServiceTimeout is how long RelayAction waits for a restarted
+	// service to come back.
+	relayServiceTimeout = 2 * time.Minute
+	relayServicePoll    = 3 * time.Second
+)
+
+// RelayAction runs action on the relay: reboot it, restart its Xray or
+// Caddy service, or power it off. Reboots and restarts wait until the
+// relay answers again and reconnect the server's reverse tunnel; a powered
+// off relay has to be started again from the provider's console.
+func (o *Ops) RelayAction(ctx context.Context, action string, progress ProgressFunc) error {
+	switch action {
+	case RelayActionReboot:
+		return o.RebootRelay(ctx, progress)
+	case RelayActionRestartXray:
+		return o.restartRelayService(ctx, "xray", progress)
+	case RelayActionRestartCaddy:
+		return o.restartRelayService(ctx, "caddy", progress)
+	case RelayActionPoweroff:
+		return o.powerOffRelay(progress)
+	}
+	return fmt.Errorf("unknown relay action %q (want %s)", action, strings.Join(RelayActions(), ", "))
+}
+
+// restartRelayService restarts unit on the relay and waits until it runs
+// again and the relay can be reached through it.
+func (o *Ops) restartRelayService(ctx context.Context, unit string, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	const total = 3
+	step := publishStep(progress, total)
+
+	// The SSH session runs through both Xray and Caddy, so the restart is
+	// delayed until the session has ended.
+	var invocation string
+	if err := step(1, "Restart "+unit, func() (string, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return "restart scheduled", withRelaySSH(o.cfg, func(client *gossh.Client) error {
+			out, err := relayOutput(client, "systemctl show -p InvocationID --value "+unit)
+			if err != nil {
+				return err
+			}
+			invocation = strings.TrimSpace(out)
+			return runRelayCommand(client, fmt.Sprintf("sudo systemd-run --on-active=2 --collect --unit=tw-restart-%s systemctl restart %s", unit, unit))
+		})
+	}); err != nil {
+		return fmt.Errorf("restarting relay %s: %w", unit, err)
+	}
+
+	if err := step(2, "Waiting for "+unit, func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, relayServiceTimeout)
+		defer cancel()
+		start := time.Now()
+		var last error
+		for {
+			select {
+			case <-ctx.Done():
+				if last != nil {
+					return "", fmt.Errorf("%s not back after %s: %w", unit, relayServiceTimeout, last)
+				}
+				return "", fmt.Errorf("%s not back after %s", unit, relayServiceTimeout)
+			case <-time.After(relayServicePoll):
+			}
+			// Reaching the relay at all shows the Caddy and Xray path works.
+			var restarted bool
+			o.mu.Lock()
+			last = withRelaySSH(o.cfg, func(client *gossh.Client) error {
+				out, err := relayOutput(client, "systemctl show -p InvocationID --value "+unit)
+				if err != nil {
+					return err
+				}
+				if runRelayCommand(client, "systemctl is-active --quiet "+unit) != nil {
+					return fmt.Errorf("%s is not active", unit)
+				}
+				restarted = strings.TrimSpace(out) != invocation
+				return nil
+			})
+			o.mu.Unlock()
+			if last == nil && restarted {
+				return fmt.Sprintf("running again after %s", time.Since(start).Round(time.Second)), nil
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	return step(3, "Reverse tunnel", o.reconnectRelayTunnel)
+}
+
+// powerOffRelay shuts the relay down. It stays off, and its tunnels down,
+// until it is started from the provider's console.
+func (o *Ops) powerOffRelay(progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	step := publishStep(progress, 1)
+	return step(1, "Power off", func() (string, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+			return runRelayCommand(client, "sudo systemd-run --on-active=5 --collect --unit=tw-poweroff systemctl poweroff")
+		})
+		if err != nil {
+			return "", fmt.Errorf("powering off relay: %w", err)
+		}
+		return "relay powering off; start it again from the provider's console", nil
+	})
+}
+
+// reconnectRelayTunnel makes the server's reverse tunnel reconnect now
+// instead of waiting out its backoff.
+func (o *Ops) reconnectRelayTunnel() (string, error) {
+	o.srv.mu.Lock()
+	tunnel := o.srv.tunnel
+	o.srv.mu.Unlock()
+	if tunnel == nil {
+		return "server not running here; a running server reconnects on its own", nil
+	}
+	tunnel.Reconnect()
+	return "reconnecting", nil
+}
@@ -0,0 +1,335 @@
+package ops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayServerResources is the server resource in each provider's main.tf,
+// which an adopted server is imported as.
+var relayServerResources = map[string]string{
+	"aws":          "aws_instance.relay",
+	"hetzner":      "hcloud_server.relay",
+	"digitalocean": "digitalocean_droplet.relay",
+}
+
+// relayAdoptDNSTimeout bounds how long AdoptRelay waits for the relay
+// domain to resolve and serve HTTPS before leaving it to Test Connectivity.
+const relayAdoptDNSTimeout = 10 * time.Minute
+
+// AdoptRelayRequest describes an existing server to turn into the relay.
+type AdoptRelayRequest struct {
+	Host    string // address of the server
+	SSHPort int    // 22 when 0
+	SSHUser string // root when empty; other users need passwordless sudo
+	SSHKey  []byte // private key the server accepts for SSHUser
+
+	Domain  string
+	CDN     bool
+	ACMEDNS ACMEDNS
+
+	// ProviderKey and ServerID ("hetzner" and the server ID, "aws" and the
+	// instance ID, ...) bring the server under Terraform with the
+	// provider's credentials, like a provisioned relay. Without them, or
+	// when the import fails, the relay is recorded as a manual one.
+	ProviderKey  string
+	ServerID     string
+	Token        string
+	AWSSecretKey string
+	Region       string
+}
+
+// AdoptRelay turns an existing server into the relay: it runs the install
+// script on it over SSH, imports it into Terraform state when a provider
+// and server ID are given (or records it as a manual relay), and waits for
+// the relay domain to serve HTTPS.
+func (o *Ops) AdoptRelay(ctx context.Context, req AdoptRelayRequest, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	if req.Host == "" || req.Domain == "" {
+		return fmt.Errorf("the server address and the relay domain are required")
+	}
+	if req.SSHPort == 0 {
+		req.SSHPort = 22
+	}
+	if req.SSHUser == "" {
+		req.SSHUser = "root"
+	}
+	if err := req.ACMEDNS.Validate(); err != nil {
+		return err
+	}
+	var providerName string
+	if req.ProviderKey != "" {
+		for _, p := range CloudProviders() {
+			if p.Key == req.ProviderKey {
+				providerName = p.Name
+			}
+		}
+		switch {
+		case providerName == "":
+			return fmt.Errorf("unknown provider %q", req.ProviderKey)
+		case req.ServerID == "":
+			return fmt.Errorf("importing into Terraform needs the %s server ID", providerName)
+		case req.ProviderKey == "aws" && req.Region == "":
+			return fmt.Errorf("importing an AWS instance needs its region")
+		}
+	}
+	if status := o.GetRelayStatus(); status.Provisioned {
+		return fmt.Errorf("a relay is already set up (%s) — destroy it first", status.Domain)
+	}
+	signer, err := gossh.ParsePrivateKey(req.SSHKey)
+	if err != nil {
+		return fmt.Errorf("parsing SSH key: %w", err)
+	}
+
+	// Post-provision hooks add a sixth step when there are any.
+	hooks := hookScripts(HookPostProvision)
+	total := 5
+	if len(hooks) > 0 {
+		total++
+	}
+	step := publishStep(progress, total)
+	marker := ManualRelayMarker{Domain: req.Domain}
+
+	var client *gossh.Client
+	if err := step(1, "Connecting", func() (string, error) {
+		c, fingerprint, err := dialRelayHost(req.Host, req.SSHPort, req.SSHUser, signer)
+		if err != nil {
+			return "", err
+		}
+		client = c
+		marker.IP = c.RemoteAddr().(*net.TCPAddr).IP.String()
+
+		out, err := relayOutput(client, `. /etc/os-release; echo "$ID $ID_LIKE"; echo "$PRETTY_NAME"; hostname`)
+		if err != nil {
+			return "", err
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) < 3 {
+			return "", fmt.Errorf("unexpected /etc/os-release output: %q", out)
+		}
+		if ids := " " + lines[0] + " "; !strings.Contains(ids, " ubuntu ") && !strings.Contains(ids, " debian ") {
+			return "", fmt.Errorf("%s is not Ubuntu or Debian, which the relay install script needs", lines[1])
+		}
+		marker.OS, marker.Hostname = lines[1], lines[2]
+		if req.SSHUser != "root" {
+			if err := runRelayCommand(client, "sudo -n true"); err != nil {
+				return "", fmt.Errorf("%s needs passwordless sudo: %w", req.SSHUser, err)
+			}
+		}
+		return fmt.Sprintf("%s on %s (%s), host key %s", marker.OS, marker.Hostname, marker.IP, fingerprint), nil
+	}); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := step(2, "Installing relay", func() (string, error) {
+		script, err := o.GenerateManualInstallScript(req.Domain, req.ACMEDNS, req.CDN)
+		if err != nil {
+			return "", err
+		}
+		return "relay installed", runInstallScript(client, req.SSHUser, script, func(line string) {
+			progress(ProgressEvent{Step: 2, Total: total, Label: "Installing relay", Status: "running", Message: line})
+		})
+	}); err != nil {
+		return fmt.Errorf("installing relay: %w", err)
+	}
+
+	imported := false
+	if err := step(3, "Terraform import", func() (string, error) {
+		if req.ProviderKey == "" {
+			return "skipped without a provider — recorded as a manual relay", nil
+		}
+		err := o.importAdoptedRelay(ctx, req, providerName, progress)
+		if err == nil {
+			imported = true
+			return fmt.Sprintf("%s server %s is managed by Terraform", providerName, req.ServerID), nil
+		}
+		if _, ok := err.(adoptApplyError); ok {
+			return "", err
+		}
+		// Nothing was changed in the cloud; start over as a manual relay.
+		os.RemoveAll(config.RelayDir())
+		deleteRelayCredentials(req.ProviderKey)
+		marker.ImportError = err.Error()
+		return "import failed, recorded as a manual relay: " + err.Error(), nil
+	}); err != nil {
+		return err
+	}
+
+	if err := step(4, "Registering relay", func() (string, error) {
+		marker.Provider = req.ProviderKey
+		marker.ServerID = req.ServerID
+		marker.Region = req.Region
+		marker.AdoptedAt = time.Now().UTC().Format(time.RFC3339)
+		if imported {
+			return "cloud relay", o.saveRelayOrigin(marker.IP)
+		}
+		return "manual relay", o.saveManualRelay(marker)
+	}); err != nil {
+		return err
+	}
+
+	if err := step(5, "DNS & readiness", func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, relayAdoptDNSTimeout)
+		defer cancel()
+		// WaitForDNS and WaitForRelay report as step 8 of provisioning.
+		wait := func(e ProgressEvent) {
+			e.Step, e.Total = 5, total
+			progress(e)
+		}
+		if err := o.WaitForDNS(ctx, req.Domain, marker.IP, req.CDN, wait); err != nil {
+			return "DNS not verified — set the A record and run Test Connectivity from the relay page", nil
+		}
+		if err := o.WaitForRelay(ctx, req.Domain, 5*time.Minute, wait); err != nil {
+			return "TLS not ready yet — Caddy will keep retrying", nil
+		}
+		return "relay is live — DNS resolved and TLS certificate obtained", nil
+	}); err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	// The relay is installed by now, so a failing hook is only a warning.
+	return step(6, "Post-provision hooks", func() (string, error) {
+		vars := relayHookVars(o.Config(), marker.IP, req.ProviderKey)
+		if err := runRelayHooks(client, HookPostProvision, hooks, vars, progress); err != nil {
+			slog.Warn("post-provision hook failed", "error", err)
+			return "Warning: " + err.Error(), nil
+		}
+		return hookSummary(hooks), nil
+	})
+}
+
+// dialRelayHost connects to a server tw installs the relay on, directly
+// rather than through the tunnel, and returns the client and the server's
+// host key fingerprint. The host key is shown, not checked: the server is
+// new to tw.
+func dialRelayHost(host string, port int, user string, signer gossh.Signer) (*gossh.Client, string, error) {
+	var fingerprint string
+	c, err := gossh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)), &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+			fingerprint = gossh.FingerprintSHA256(key)
+			return nil
+		},
+		Timeout: 15 * time.Second,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("SSH to %s: %w", host, err)
+	}
+	return c, fingerprint, nil
+}
+
+// runInstallScript runs the relay install script on client as user,
+// calling fn with each of its step lines.
+func runInstallScript(client *gossh.Client, user, script string, fn func(line string)) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(script)
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd := "bash -s 2>&1"
+	if user != "root" {
+		cmd = "sudo -n " + cmd
+	}
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	var last []string
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		line := sc.Text()
+		last = append(last, line)
+		if len(last) > 20 {
+			last = last[1:]
+		}
+		if strings.HasPrefix(line, "[") {
+			fn(line)
+		}
+	}
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("install script: %w\n%s", err, strings.Join(last, "\n"))
+	}
+	return nil
+}
+
+// adoptApplyError is a failed terraform apply after the server was
+// imported: the state now holds the server, so it is kept.
+type adoptApplyError struct{ err error }
+
+func (e adoptApplyError) Error() string {
+	return fmt.Sprintf("%v\nthe server is in the Terraform state; fix the cause and run `terraform apply` in %s", e.err, config.RelayDir())
+}
+
+// importAdoptedRelay writes the Terraform files for req's provider and
+// imports the server into its state, then applies the cloud firewall. The
+// adopt override keeps Terraform from replacing the server.
+func (o *Ops) importAdoptedRelay(ctx context.Context, req AdoptRelayRequest, providerName string, progress ProgressFunc) error {
+	if err := o.TestCloudCredentials(providerName, req.Token, req.AWSSecretKey); err != nil {
+		return fmt.Errorf("credential test failed: %w", err)
+	}
+	pubKey, err := os.ReadFile(filepath.Join(config.Dir(), "id_ed25519.pub"))
+	if err != nil {
+		return fmt.Errorf("reading public key: %w", err)
+	}
+
+	cfg := o.Config()
+	relayDir := config.RelayDir()
+	// cloud-init.yaml is only rendered because main.tf reads it; the
+	// override ignores the user data of an adopted server.
+	tfCfg := terraform.Config{
+		Domain:      cfg.Xray.RelayHost,
+		UUID:        cfg.Xray.UUID,
+		XrayPath:    cfg.Xray.Path,
+		SSHUser:     cfg.Server.RelaySSHUser,
+		PublicKey:   strings.TrimSpace(string(pubKey)),
+		Provider:    req.ProviderKey,
+		Transport:   cfg.Xray.Transport,
+		Region:      req.Region,
+		TemplateDir: config.TemplatesDir(),
+	}
+	if err := terraform.Generate(relayDir, tfCfg); err != nil {
+		return fmt.Errorf("generating terraform files: %w", err)
+	}
+	if err := terraform.WriteAdoptOverride(relayDir, req.ProviderKey); err != nil {
+		return err
+	}
+	if err := terraform.WriteFirewallVars(relayDir, relayFirewallVars(cfg.Server.RelayFirewall)); err != nil {
+		return err
+	}
+	if err := storeRelayCredentials(req.ProviderKey, req.Token, req.AWSSecretKey); err != nil {
+		return fmt.Errorf("storing cloud credentials: %w", err)
+	}
+
+	env := relayCredentialEnv(req.ProviderKey, req.Token, req.AWSSecretKey)
+	if err := o.RunTerraform(ctx, relayDir, env, progress, "init", "-input=false"); err != nil {
+		return err
+	}
+	if err := o.RunTerraform(ctx, relayDir, env, progress, "import", "-input=false", relayServerResources[req.ProviderKey], req.ServerID); err != nil {
+		return err
+	}
+	if err := o.RunTerraform(ctx, relayDir, env, progress, "apply", "-auto-approve", "-input=false"); err != nil {
+		return adoptApplyError{err}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package ops
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/fail2ban"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayFail2ban renders the relay's fail2ban jail and filter from the
+// rate_limit settings, base64-encoded for the provisioning templates.
+// Behind a CDN every request comes from the CDN's addresses, so such a
+// relay gets no jail and both are "".
+func relayFail2ban(cfg *config.Config, cdn bool) (jail, filter string, err error) {
+	if cdn {
+		return "", "", nil
+	}
+	f2b := fail2ban.Config{
+		XrayPath:   cfg.Xray.Path,
+		MaxRetry:   cfg.RateLimit.MaxFailures,
+		FindTime:   cfg.RateLimit.Window,
+		BanTime:    cfg.RateLimit.BanTime,
+		MaxBanTime: cfg.RateLimit.MaxBanTime,
+	}
+	j, err := fail2ban.RenderJail(f2b)
+	if err != nil {
+		return "", "", fmt.Errorf("rendering fail2ban jail: %w", err)
+	}
+	f, err := fail2ban.RenderFilter(f2b)
+	if err != nil {
+		return "", "", fmt.Errorf("rendering fail2ban filter: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(j)), base64.StdEncoding.EncodeToString([]byte(f)), nil
+}
+
+// RelayBans lists the sources fail2ban has banned from the relay's public
+// ports.
+func (o *Ops) RelayBans() ([]fail2ban.Ban, error) {
+	var bans []fail2ban.Ban
+	err := withRelaySSH(o.Config(), func(client *gossh.Client) error {
+		out, err := relayFail2banCommand(client, fail2ban.ListCommand)
+		if err != nil {
+			return err
+		}
+		bans = fail2ban.ParseBans(out)
+		return nil
+	})
+	return bans, err
+}
+
+// ClearRelayBans lifts the relay's bans on ips, or all of them when ips is
+// empty.
+func (o *Ops) ClearRelayBans(ips []string) error {
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("%q is not an IP address", ip)
+		}
+	}
+	return withRelaySSH(o.Config(), func(client *gossh.Client) error {
+		_, err := relayFail2banCommand(client, fail2ban.UnbanCommand(ips))
+		return err
+	})
+}
+
+// relayFail2banCommand runs a fail2ban-client command on the relay and
+// returns its output, explaining the usual failure: a relay without the
+// jail.
+func relayFail2banCommand(client *gossh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(cmd)
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist") {
+			return "", fmt.Errorf("fail2ban is not set up on the relay; relays behind a CDN or provisioned by older versions don't have it")
+		}
+		return "", fmt.Errorf("%s: %w: %s", cmd, err, msg)
+	}
+	return string(out), nil
+}
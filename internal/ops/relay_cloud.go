@@ -0,0 +1,124 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/cloud"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+)
+
+// RelayBackendSDK selects provisioning through the providers' Go SDKs
+// (server.relay_backend), with the relay's resources recorded in
+// cloud.ManifestFile instead of a Terraform state.
+const RelayBackendSDK = "sdk"
+
+// relayCloudRules returns the relay's cloud firewall rules: ports 80 and
+// 443 for everyone, then the relay firewall settings, like the dynamic
+// blocks in main.tf.
+func relayCloudRules(fw config.RelayFirewall) []cloud.Rule {
+	everyone := []string{"0.0.0.0/0", "::/0"}
+	rules := []cloud.Rule{
+		{Port: 80, Protocol: "tcp", Sources: everyone},
+		{Port: 443, Protocol: "tcp", Sources: everyone},
+	}
+	vars := relayFirewallVars(fw)
+	for _, p := range vars.OpenPorts {
+		rules = append(rules, cloud.Rule{Port: p.Port, Protocol: p.Protocol, Sources: p.Sources})
+	}
+	if len(vars.SSHSources) > 0 {
+		rules = append(rules, cloud.Rule{Port: 22, Protocol: "tcp", Sources: vars.SSHSources})
+	}
+	return rules
+}
+
+// relayCloudCredentials picks a provider's credentials out of the
+// Terraform environment relayCredentialEnv builds.
+func relayCloudCredentials(providerKey string, env map[string]string) cloud.Credentials {
+	if providerKey == "aws" {
+		return cloud.Credentials{Token: env["AWS_ACCESS_KEY_ID"], AWSSecretKey: env["AWS_SECRET_ACCESS_KEY"]}
+	}
+	for _, p := range CloudProviders() {
+		if p.Key == providerKey && p.VarName != "" {
+			return cloud.Credentials{Token: env["TF_VAR_"+p.VarName]}
+		}
+	}
+	return cloud.Credentials{}
+}
+
+// createRelaySDK creates the relay through the provider's SDK and returns
+// its IP. The manifest is written even when creation fails halfway, so
+// DestroyRelay can remove what was created.
+func createRelaySDK(ctx context.Context, relayDir string, tfCfg terraform.Config, fw config.RelayFirewall, env map[string]string, progress ProgressFunc) (string, error) {
+	p, err := cloud.New(tfCfg.Provider, relayCloudCredentials(tfCfg.Provider, env))
+	if err != nil {
+		return "", err
+	}
+	userData, err := terraform.CloudInit(tfCfg)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(relayDir, 0755); err != nil {
+		return "", fmt.Errorf("creating relay directory: %w", err)
+	}
+	// Kept for reference, as with Terraform; only the manifest is read back.
+	if err := os.WriteFile(filepath.Join(relayDir, "cloud-init.yaml"), []byte(userData), 0600); err != nil {
+		return "", fmt.Errorf("writing cloud-init.yaml: %w", err)
+	}
+
+	log := func(msg string) {
+		progress(ProgressEvent{Label: "Provisioning", Status: "running", Message: msg})
+	}
+	m, err := p.Create(ctx, cloud.Spec{
+		Region:       tfCfg.Region,
+		InstanceType: tfCfg.InstanceType,
+		Arch:         tfCfg.Arch,
+		UserData:     userData,
+		Rules:        relayCloudRules(fw),
+	}, log)
+	if m != nil && (m.ServerID != "" || m.FirewallID != "") {
+		if werr := cloud.WriteManifest(relayDir, m); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return m.IP, nil
+}
+
+// destroyRelaySDK deletes the resources in the relay's manifest.
+func destroyRelaySDK(ctx context.Context, relayDir string, env map[string]string, progress ProgressFunc) error {
+	m, err := cloud.ReadManifest(relayDir)
+	if err != nil {
+		return err
+	}
+	p, err := cloud.New(m.Provider, relayCloudCredentials(m.Provider, env))
+	if err != nil {
+		return err
+	}
+	return p.Destroy(ctx, m, func(msg string) {
+		progress(ProgressEvent{Label: "Destroying relay", Status: "running", Message: msg})
+	})
+}
+
+// setRelayFirewallSDK replaces the rules of the cloud firewall in the
+// relay's manifest.
+func setRelayFirewallSDK(ctx context.Context, relayDir string, fw config.RelayFirewall) error {
+	m, err := cloud.ReadManifest(relayDir)
+	if err != nil {
+		return err
+	}
+	env := storedRelayCredentials(m.Provider)
+	if env == nil {
+		return fmt.Errorf("no stored %s credentials — provision the relay again to store them", m.Provider)
+	}
+	p, err := cloud.New(m.Provider, relayCloudCredentials(m.Provider, env))
+	if err != nil {
+		return err
+	}
+	return p.SetFirewall(ctx, m, relayCloudRules(fw))
+}
@@ -0,0 +1,236 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/container"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ContainerRelayMarker is written to the relay directory for a relay
+// deployed as containers with DeployContainerRelay.
+type ContainerRelayMarker struct {
+	Domain    string `json:"domain"`
+	IP        string `json:"ip"`
+	Host      string `json:"host"` // address deployed to
+	Hostname  string `json:"hostname,omitempty"`
+	Runtime   string `json:"runtime,omitempty"` // compose command used, e.g. "docker compose"
+	CreatedAt string `json:"created_at"`
+}
+
+// containerRelayFile is the marker's name in the relay directory; the
+// bundle deployed is kept next to it in containerBundleDir.
+const (
+	containerRelayFile = "container-relay.json"
+	containerBundleDir = "container"
+)
+
+// ContainerRelayRequest describes a host running Docker or Podman to
+// deploy the relay on.
+type ContainerRelayRequest struct {
+	Host    string // address of the host
+	SSHPort int    // 22 when 0
+	SSHUser string // root when empty; other users need passwordless sudo
+	SSHKey  []byte // private key the host accepts for SSHUser
+
+	Domain  string
+	CDN     bool
+	ACMEDNS ACMEDNS
+}
+
+// readContainerRelay reads the container relay marker, if there is one.
+func readContainerRelay() (*ContainerRelayMarker, error) {
+	data, err := os.ReadFile(filepath.Join(config.RelayDir(), containerRelayFile))
+	if err != nil {
+		return nil, err
+	}
+	var m ContainerRelayMarker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", containerRelayFile, err)
+	}
+	return &m, nil
+}
+
+// DeployContainerRelay makes a host that already runs Docker or Podman the
+// relay: it copies a docker-compose bundle of Caddy and Xray to the host
+// over SSH and starts it, then waits for the relay domain to serve HTTPS.
+// Unlike AdoptRelay it leaves the host's sshd and firewall alone. The
+// bundle is also written to the relay directory.
+func (o *Ops) DeployContainerRelay(ctx context.Context, req ContainerRelayRequest, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	if req.Host == "" || req.Domain == "" {
+		return fmt.Errorf("the host address and the relay domain are required")
+	}
+	if req.SSHPort == 0 {
+		req.SSHPort = 22
+	}
+	if req.SSHUser == "" {
+		req.SSHUser = "root"
+	}
+	if err := req.ACMEDNS.Validate(); err != nil {
+		return err
+	}
+	if status := o.GetRelayStatus(); status.Provisioned {
+		return fmt.Errorf("a relay is already set up (%s) — destroy it first", status.Domain)
+	}
+	signer, err := gossh.ParsePrivateKey(req.SSHKey)
+	if err != nil {
+		return fmt.Errorf("parsing SSH key: %w", err)
+	}
+
+	// Post-provision hooks add a sixth step when there are any.
+	hooks := hookScripts(HookPostProvision)
+	total := 5
+	if len(hooks) > 0 {
+		total++
+	}
+	step := publishStep(progress, total)
+	marker := ContainerRelayMarker{Domain: req.Domain, Host: req.Host}
+
+	var client *gossh.Client
+	if err := step(1, "Connecting", func() (string, error) {
+		c, fingerprint, err := dialRelayHost(req.Host, req.SSHPort, req.SSHUser, signer)
+		if err != nil {
+			return "", err
+		}
+		client = c
+		marker.IP = c.RemoteAddr().(*net.TCPAddr).IP.String()
+		if req.SSHUser != "root" {
+			if err := runRelayCommand(client, "sudo -n true"); err != nil {
+				return "", fmt.Errorf("%s needs passwordless sudo: %w", req.SSHUser, err)
+			}
+		}
+		out, err := relayOutput(client, "hostname; docker compose version >/dev/null 2>&1 && echo 'docker compose' || command -v docker-compose podman-compose | head -n 1 | xargs -r basename")
+		if err != nil {
+			return "", err
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		marker.Hostname = lines[0]
+		if len(lines) < 2 {
+			return "", fmt.Errorf("%s has none of docker compose, docker-compose or podman-compose", req.Host)
+		}
+		marker.Runtime = lines[1]
+		return fmt.Sprintf("%s (%s) with %s, host key %s", marker.Hostname, marker.IP, marker.Runtime, fingerprint), nil
+	}); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var files []container.File
+	if err := step(2, "Bundle", func() (string, error) {
+		tfCfg, err := o.manualRelayConfig(req.Domain, req.ACMEDNS, req.CDN)
+		if err != nil {
+			return "", err
+		}
+		if files, err = container.Bundle(tfCfg); err != nil {
+			return "", err
+		}
+		dir := filepath.Join(config.RelayDir(), containerBundleDir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("creating relay directory: %w", err)
+		}
+		for _, f := range files {
+			if err := os.WriteFile(filepath.Join(dir, f.Name), []byte(f.Content), 0600); err != nil {
+				return "", fmt.Errorf("writing %s: %w", f.Name, err)
+			}
+		}
+		script, err := container.DeployScript(tfCfg, files)
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "deploy.sh"), []byte(script), 0600); err != nil {
+			return "", fmt.Errorf("writing deploy.sh: %w", err)
+		}
+		return fmt.Sprintf("%d files in %s", len(files), dir), nil
+	}); err != nil {
+		return err
+	}
+
+	if err := step(3, "Deploying containers", func() (string, error) {
+		script, err := os.ReadFile(filepath.Join(config.RelayDir(), containerBundleDir, "deploy.sh"))
+		if err != nil {
+			return "", err
+		}
+		err = runInstallScript(client, req.SSHUser, string(script), func(line string) {
+			progress(ProgressEvent{Step: 3, Total: total, Label: "Deploying containers", Status: "running", Message: line})
+		})
+		if err != nil {
+			return "", err
+		}
+		return "Caddy and Xray running in " + container.Dir, nil
+	}); err != nil {
+		return fmt.Errorf("deploying relay: %w", err)
+	}
+
+	if err := step(4, "Registering relay", func() (string, error) {
+		if err := o.saveRelayOrigin(marker.IP); err != nil {
+			return "", err
+		}
+		marker.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		data, err := json.MarshalIndent(marker, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return "container relay", os.WriteFile(filepath.Join(config.RelayDir(), containerRelayFile), data, 0644)
+	}); err != nil {
+		return err
+	}
+
+	if err := step(5, "DNS & readiness", func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, relayAdoptDNSTimeout)
+		defer cancel()
+		// WaitForDNS and WaitForRelay report as step 8 of provisioning.
+		wait := func(e ProgressEvent) {
+			e.Step, e.Total = 5, total
+			progress(e)
+		}
+		if err := o.WaitForDNS(ctx, req.Domain, marker.IP, req.CDN, wait); err != nil {
+			return "DNS not verified — set the A record and run Test Connectivity from the relay page", nil
+		}
+		if err := o.WaitForRelay(ctx, req.Domain, 5*time.Minute, wait); err != nil {
+			return "TLS not ready yet — Caddy will keep retrying", nil
+		}
+		return "relay is live — DNS resolved and TLS certificate obtained", nil
+	}); err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	// The relay is running by now, so a failing hook is only a warning.
+	return step(6, "Post-provision hooks", func() (string, error) {
+		vars := relayHookVars(o.Config(), marker.IP, "container")
+		if err := runRelayHooks(client, HookPostProvision, hooks, vars, progress); err != nil {
+			slog.Warn("post-provision hook failed", "error", err)
+			return "Warning: " + err.Error(), nil
+		}
+		return hookSummary(hooks), nil
+	})
+}
+
+// removeContainerRelay runs the bundle's down script on the relay host
+// through the tunnel. Stopping Xray ends the tunnel, so the script runs
+// detached and is not waited for.
+func removeContainerRelay(cfg *config.Config) error {
+	return withRelaySSH(cfg, func(client *gossh.Client) error {
+		session, err := client.NewSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		cmd := fmt.Sprintf("sudo -n systemd-run --collect --unit=tw-relay-down %s >/dev/null 2>&1 || sudo -n nohup %s >/dev/null 2>&1 &", container.DownScript, container.DownScript)
+		if out, err := session.CombinedOutput(cmd); err != nil {
+			return fmt.Errorf("running %s: %w: %s", container.DownScript, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,303 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/cloud"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayFirewallResources is the cloud firewall resource in each provider's
+// main.tf. Firewall changes apply only to it, so that an apply never
+// replaces the relay itself (e.g. for a newer AWS image).
+var relayFirewallResources = map[string]string{
+	"aws":          "aws_security_group.relay",
+	"hetzner":      "hcloud_firewall.relay",
+	"digitalocean": "digitalocean_firewall.relay",
+}
+
+// relaySSHDConfig is the sshd drop-in the provisioning templates write.
+const relaySSHDConfig = "/etc/ssh/sshd_config.d/99-tw-localhost.conf"
+
+// relayFirewallVars turns the relay firewall settings into the Terraform
+// variables of the relay's main.tf.
+func relayFirewallVars(fw config.RelayFirewall) terraform.FirewallVars {
+	vars := terraform.FirewallVars{SSHSources: fw.SSHSources}
+	for _, p := range fw.Ports {
+		sources := p.Sources
+		if len(sources) == 0 {
+			sources = []string{"0.0.0.0/0", "::/0"}
+		}
+		vars.OpenPorts = append(vars.OpenPorts, terraform.FirewallPort{Port: p.Port, Protocol: p.Proto(), Sources: sources})
+	}
+	return vars
+}
+
+// relayUFWRules returns the "ufw allow" arguments for the relay firewall
+// settings, so the relay's own firewall matches the cloud one.
+func relayUFWRules(fw config.RelayFirewall) []string {
+	var rules []string
+	for _, p := range fw.Ports {
+		rules = append(rules, ufwRules(p.Port, p.Proto(), p.Sources)...)
+	}
+	if len(fw.SSHSources) > 0 {
+		rules = append(rules, ufwRules(22, "tcp", fw.SSHSources)...)
+	}
+	return rules
+}
+
+func ufwRules(port int, proto string, sources []string) []string {
+	if len(sources) == 0 {
+		return []string{fmt.Sprintf("%d/%s", port, proto)}
+	}
+	rules := make([]string, len(sources))
+	for i, s := range sources {
+		rules[i] = fmt.Sprintf("proto %s from %s to any port %d", proto, s, port)
+	}
+	return rules
+}
+
+// relaySSHDDropIn returns the sshd drop-in for the relay: loopback only,
+// which the tunnel needs, or all addresses when SSH sources are allowed.
+func relaySSHDDropIn(open bool) string {
+	listen := "ListenAddress 127.0.0.1\n"
+	if open {
+		listen = "ListenAddress 0.0.0.0\nListenAddress ::\n"
+	}
+	return listen + "PasswordAuthentication no\n"
+}
+
+// normalizeCIDRs checks sources and turns plain addresses into
+// single-address CIDRs.
+func normalizeCIDRs(sources []string) ([]string, error) {
+	out := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if ip := net.ParseIP(s); ip != nil {
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR", s)
+		}
+		out = append(out, ipnet.String())
+	}
+	return out, nil
+}
+
+// RelayFirewall returns the relay's extra firewall rules.
+func (o *Ops) RelayFirewall() config.RelayFirewall {
+	return o.Config().Server.RelayFirewall
+}
+
+// OpenRelayPort opens port to sources (everyone when empty) in the relay's
+// cloud firewall and ufw. Opening a port again replaces its sources.
+func (o *Ops) OpenRelayPort(ctx context.Context, port int, protocol string, sources []string, progress ProgressFunc) error {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		return fmt.Errorf("protocol must be tcp or udp, not %q", protocol)
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%d is not a valid port (1-65535)", port)
+	}
+	switch port {
+	case 80, 443:
+		return fmt.Errorf("relay port %d is always open", port)
+	case 22:
+		return fmt.Errorf("use `tw relay firewall ssh` to reach the relay's SSH port directly")
+	}
+	if what, ok := reservedRelayPorts[port]; ok {
+		return fmt.Errorf("relay port %d is used by %s", port, what)
+	}
+	sources, err := normalizeCIDRs(sources)
+	if err != nil {
+		return err
+	}
+
+	return o.updateRelayFirewall(ctx, progress, func(fw *config.RelayFirewall) error {
+		p := config.FirewallPort{Port: port, Protocol: protocol, Sources: sources}
+		if protocol == "tcp" {
+			p.Protocol = ""
+		}
+		for i, existing := range fw.Ports {
+			if existing.Port == port && existing.Proto() == protocol {
+				fw.Ports[i] = p
+				return nil
+			}
+		}
+		fw.Ports = append(fw.Ports, p)
+		return nil
+	})
+}
+
+// CloseRelayPort removes port from the relay's cloud firewall and ufw.
+func (o *Ops) CloseRelayPort(ctx context.Context, port int, protocol string, progress ProgressFunc) error {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return o.updateRelayFirewall(ctx, progress, func(fw *config.RelayFirewall) error {
+		for i, p := range fw.Ports {
+			if p.Port == port && p.Proto() == protocol {
+				fw.Ports = append(fw.Ports[:i], fw.Ports[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("relay port %d/%s is not open", port, protocol)
+	})
+}
+
+// SetRelaySSHSources lets sources reach the relay's SSH port directly.
+// With no sources, SSH is reachable only through the tunnel again.
+func (o *Ops) SetRelaySSHSources(ctx context.Context, sources []string, progress ProgressFunc) error {
+	sources, err := normalizeCIDRs(sources)
+	if err != nil {
+		return err
+	}
+	return o.updateRelayFirewall(ctx, progress, func(fw *config.RelayFirewall) error {
+		fw.SSHSources = sources
+		return nil
+	})
+}
+
+// ApplyRelayFirewall applies server.relay_firewall as configured, e.g.
+// after editing config.yaml or when an earlier change failed halfway.
+func (o *Ops) ApplyRelayFirewall(ctx context.Context, progress ProgressFunc) error {
+	return o.updateRelayFirewall(ctx, progress, nil)
+}
+
+// updateRelayFirewall applies change to the relay firewall settings: the
+// cloud firewall through Terraform first, then ufw and sshd on the relay.
+// The config is saved once the cloud firewall is updated.
+func (o *Ops) updateRelayFirewall(ctx context.Context, progress ProgressFunc, change func(fw *config.RelayFirewall) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	relayDir := config.RelayDir()
+	sdk := cloud.HasManifest(relayDir)
+	var resource string
+	if !sdk {
+		if _, err := os.Stat(filepath.Join(relayDir, "terraform.tfstate")); err != nil {
+			return fmt.Errorf("no cloud relay — the relay firewall is managed through Terraform, so manual relays are configured on their provider's console")
+		}
+		if !terraform.HasFirewallVars(relayDir) {
+			return fmt.Errorf("the relay was provisioned by an older version without firewall variables — re-provision it to manage its firewall")
+		}
+		var ok bool
+		resource, ok = relayFirewallResources[providerKeyByName(relayProvider(relayDir))]
+		if !ok {
+			return fmt.Errorf("unknown relay provider in %s", filepath.Join(relayDir, "main.tf"))
+		}
+	}
+
+	old := o.cfg.Server.RelayFirewall
+	fw := config.RelayFirewall{
+		Ports:      append([]config.FirewallPort(nil), old.Ports...),
+		SSHSources: old.SSHSources,
+	}
+	if change != nil {
+		if err := change(&fw); err != nil {
+			return err
+		}
+	}
+
+	const total = 2
+	step := publishStep(progress, total)
+
+	if err := step(1, "Cloud firewall", func() (string, error) {
+		if sdk {
+			if err := setRelayFirewallSDK(ctx, relayDir, fw); err != nil {
+				return "", err
+			}
+			return "firewall rules replaced", nil
+		}
+		if err := terraform.WriteFirewallVars(relayDir, relayFirewallVars(fw)); err != nil {
+			return "", err
+		}
+		env := storedRelayCredentials(providerKeyByName(relayProvider(relayDir)))
+		if err := o.RunTerraform(ctx, relayDir, env, progress, "apply", "-auto-approve", "-input=false", "-target="+resource); err != nil {
+			// Keep the variables in line with what the cloud has.
+			terraform.WriteFirewallVars(relayDir, relayFirewallVars(old))
+			return "", err
+		}
+		return resource + " updated", nil
+	}); err != nil {
+		return fmt.Errorf("updating cloud firewall: %w", err)
+	}
+
+	o.cfg.Server.RelayFirewall = fw
+	if err := config.Save(o.cfg); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	published := map[string]bool{}
+	for _, f := range o.cfg.Server.ReverseForwards {
+		if f.PublicPort != 0 {
+			published[fmt.Sprintf("%d/tcp", f.PublicPort)] = true
+		}
+	}
+	err := step(2, "Relay firewall", func() (string, error) {
+		return syncRelayFirewall(o.cfg, old, fw, published)
+	})
+	if err != nil {
+		return fmt.Errorf("updating relay (retry with `tw relay firewall apply`): %w", err)
+	}
+	return nil
+}
+
+// syncRelayFirewall brings ufw and sshd on the relay from old to fw. ufw
+// rules for published ports stay, as `tw unpublish` removes them.
+func syncRelayFirewall(cfg *config.Config, old, fw config.RelayFirewall, published map[string]bool) (string, error) {
+	want := map[string]bool{}
+	for _, r := range relayUFWRules(fw) {
+		want[r] = true
+	}
+	var added, removed int
+	err := withRelaySSH(cfg, func(client *gossh.Client) error {
+		for _, r := range relayUFWRules(old) {
+			if want[r] || published[r] {
+				continue
+			}
+			if err := runRelayCommand(client, "sudo ufw delete allow "+r); err != nil {
+				return err
+			}
+			removed++
+		}
+		for _, r := range relayUFWRules(fw) {
+			if err := runRelayCommand(client, "sudo ufw allow "+r); err != nil {
+				return err
+			}
+			added++
+		}
+
+		// sshd is rewritten every time, so an apply also repairs it.
+		if err := writeRelayFile(client, relaySSHDConfig, relaySSHDDropIn(len(fw.SSHSources) > 0)); err != nil {
+			return err
+		}
+		// Ubuntu 24.04 starts sshd from ssh.socket, whose listen addresses
+		// are generated from sshd_config. Open sessions survive the restart.
+		return runRelayCommand(client, "sudo sh -c 'systemctl daemon-reload; if systemctl is-enabled --quiet ssh.socket 2>/dev/null; then systemctl restart ssh.socket; fi; systemctl restart ssh'")
+	})
+	if err != nil {
+		return "", err
+	}
+	ssh := "SSH through the tunnel only"
+	if len(fw.SSHSources) > 0 {
+		ssh = "SSH from " + strings.Join(fw.SSHSources, ", ")
+	}
+	return fmt.Sprintf("%d ufw rules allowed, %d removed; %s", added, removed, ssh), nil
+}
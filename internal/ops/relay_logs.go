@@ -0,0 +1,117 @@
+package ops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayLogs maps the log names `tw relay logs` accepts to the systemd unit
+// or file each one is read from.
+var relayLogs = map[string]struct{ unit, file string }{
+	"xray":       {unit: "xray"},
+	"caddy":      {unit: "caddy"},
+	"cloud-init": {file: "/var/log/cloud-init-output.log"},
+}
+
+// RelayLogNames lists the relay logs StreamRelayLog can read.
+func RelayLogNames() []string {
+	return []string{"xray", "caddy", "cloud-init"}
+}
+
+// RelayLogOptions selects what StreamRelayLog sends: the last Lines lines
+// (100 when 0), then new ones as they are written with Follow. Filter
+// keeps only lines containing it, ignoring case.
+type RelayLogOptions struct {
+	Lines  int
+	Follow bool
+	Filter string
+}
+
+// relayLogCommand returns the relay command that prints log name.
+func relayLogCommand(name string, opts RelayLogOptions) (string, error) {
+	src, ok := relayLogs[name]
+	if !ok {
+		return "", fmt.Errorf("unknown relay log %q (want %s)", name, strings.Join(RelayLogNames(), ", "))
+	}
+	lines := opts.Lines
+	if lines <= 0 {
+		lines = 100
+	}
+	if src.unit != "" {
+		cmd := fmt.Sprintf("sudo journalctl -u %s --no-pager -o short-iso -n %d", src.unit, lines)
+		if opts.Follow {
+			cmd += " -f"
+		}
+		return cmd, nil
+	}
+	cmd := fmt.Sprintf("sudo tail -n %d", lines)
+	if opts.Follow {
+		cmd += " -F"
+	}
+	return cmd + " " + src.file, nil
+}
+
+// StreamRelayLog reads log name on the relay and calls fn for each line,
+// until the log ends or, with opts.Follow, until ctx is cancelled. It uses
+// the running server's tunnel when there is one.
+func (o *Ops) StreamRelayLog(ctx context.Context, name string, opts RelayLogOptions, fn func(line string)) error {
+	cmd, err := relayLogCommand(name, opts)
+	if err != nil {
+		return err
+	}
+	cfg := o.Config()
+	if cfg.Xray.RelayHost == "" {
+		return fmt.Errorf("no relay configured")
+	}
+	filter := strings.ToLower(opts.Filter)
+
+	stream := func(client *gossh.Client) error {
+		session, err := client.NewSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := session.Start(cmd); err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		// Closing the session ends the scan below.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				session.Close()
+			case <-done:
+			}
+		}()
+
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			if filter == "" || strings.Contains(strings.ToLower(line), filter) {
+				fn(line)
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := session.Wait(); err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		return nil
+	}
+
+	if o.ServerStatus().Xray {
+		return o.sshThroughServerTunnel(cfg, stream)
+	}
+	return withRelaySSH(cfg, stream)
+}
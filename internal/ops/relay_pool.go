@@ -0,0 +1,278 @@
+package ops
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// relayPoolIdle is how long the management connection to the relay stays
+// open after its last use, and relayPoolCheck how long a liveness check of
+// a kept connection may take before it is replaced.
+const (
+	relayPoolIdle  = 2 * time.Minute
+	relayPoolCheck = 5 * time.Second
+)
+
+// relayMgmt is the management connection shared by withRelaySSH calls.
+var relayMgmt relayPool
+
+// relayPool keeps one SSH connection to the relay for management. It goes
+// through the server's running Xray tunnel when there is one, else through
+// a temporary Xray instance that lives as long as the connection.
+// Concurrent operations share the connection, each on its own sessions.
+type relayPool struct {
+	mu  sync.Mutex
+	cur *relayConn
+}
+
+// relayConn is one management connection and the operations using it.
+type relayConn struct {
+	key     string // relayPoolKey of the config it was opened with
+	client  *gossh.Client
+	xray    *twxray.Instance // nil through the server's tunnel
+	users   int
+	retired bool // replaced; closed when the last user is done
+	idle    *time.Timer
+}
+
+func (c *relayConn) close() {
+	c.client.Close()
+	if c.xray != nil {
+		c.xray.Close()
+	}
+}
+
+// relayPoolKey identifies the settings a management connection depends on;
+// a connection opened with other settings is not reused.
+func relayPoolKey(cfg *config.Config) string {
+	proxyURL, _ := relayProxy(cfg, twxray.ServerRelayAddress(cfg.Xray))
+	return fmt.Sprintf("%s|%d|%s|%s|%s|%d|%d|%s",
+		cfg.Xray.RelayHost, cfg.Xray.RelayPort, cfg.Xray.Path, cfg.Xray.UUID,
+		cfg.Server.RelaySSHUser, cfg.Server.RelaySSHPort, cfg.Server.SSHPort, proxyURL)
+}
+
+// acquire returns a working management connection for cfg, opening one
+// when there is none, it was opened with other settings, or it died. The
+// caller must call release when done with it. The dial happens without
+// p.mu held, so a slow or dead relay doesn't hold up release and the idle
+// timers.
+func (p *relayPool) acquire(cfg *config.Config) (*relayConn, error) {
+	key := relayPoolKey(cfg)
+	if c := p.reuse(key); c != nil {
+		return c, nil
+	}
+
+	c, err := dialRelayMgmt(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.key = key
+	c.users = 1
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cur := p.cur; cur != nil {
+		if cur.key == key {
+			// Another caller opened one meanwhile: share theirs.
+			p.useLocked(cur)
+			c.close()
+			return cur, nil
+		}
+		p.retireLocked(cur)
+	}
+	p.cur = c
+	return c, nil
+}
+
+// reuse returns the pool's connection with one more use when it was
+// opened for key and still answers, else retires it and returns nil. The
+// liveness check runs without p.mu held; the use taken for it keeps the
+// idle timer from closing the connection meanwhile.
+func (p *relayPool) reuse(key string) *relayConn {
+	p.mu.Lock()
+	c := p.cur
+	if c == nil {
+		p.mu.Unlock()
+		return nil
+	}
+	if c.key != key {
+		slog.Debug("replacing relay management connection", "settings_changed", true)
+		p.retireLocked(c)
+		p.mu.Unlock()
+		return nil
+	}
+	p.useLocked(c)
+	p.mu.Unlock()
+
+	if relayAlive(c.client) {
+		return c
+	}
+	slog.Debug("replacing relay management connection", "settings_changed", false)
+	p.mu.Lock()
+	p.retireLocked(c)
+	p.mu.Unlock()
+	p.release(c)
+	return nil
+}
+
+// useLocked adds a use of c, stopping its idle timer.
+func (p *relayPool) useLocked(c *relayConn) {
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+	c.users++
+}
+
+// release ends one use of c, closing it once unused if it was replaced,
+// else after relayPoolIdle.
+func (p *relayPool) release(c *relayConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.users--; c.users > 0 {
+		return
+	}
+	if c.retired {
+		c.close()
+		return
+	}
+	c.idle = time.AfterFunc(relayPoolIdle, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.cur == c && c.users == 0 {
+			p.cur = nil
+			c.close()
+		}
+	})
+}
+
+// retireLocked takes c out of the pool, closing it unless it is in use.
+func (p *relayPool) retireLocked(c *relayConn) {
+	if p.cur == c {
+		p.cur = nil
+	}
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+	c.retired = true
+	if c.users == 0 {
+		c.close()
+	}
+}
+
+// relayAlive reports whether client still answers a keepalive.
+func relayAlive(client *gossh.Client) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-time.After(relayPoolCheck):
+		return false
+	}
+}
+
+// dialRelayMgmt opens a management connection, through the server's Xray
+// tunnel when it is up (in this process or another on the machine), else
+// through a temporary Xray instance.
+func dialRelayMgmt(cfg *config.Config) (*relayConn, error) {
+	if conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Server.SSHPort+1), time.Second); err == nil {
+		conn.Close()
+		client, err := dialServerTunnel(cfg)
+		if err == nil {
+			slog.Debug("relay management connection through the server tunnel")
+			return &relayConn{client: client}, nil
+		}
+		slog.Debug("server tunnel unusable for relay management", "error", err)
+	}
+
+	xrayInstance, err := twxray.New(cfg.Xray)
+	if err != nil {
+		return nil, fmt.Errorf("initializing Xray: %w", err)
+	}
+	// Xray listens on the port after the one it is given.
+	tempPort, err := freeLoopbackPort()
+	if err != nil {
+		return nil, err
+	}
+	tempPort--
+	proxyURL, _ := relayProxy(cfg, twxray.ServerRelayAddress(cfg.Xray))
+	if err := xrayInstance.Start(tempPort, cfg.Server.RelaySSHPort, proxyURL, cfg.ProxyRules); err != nil {
+		return nil, fmt.Errorf("starting Xray: %w", err)
+	}
+
+	auth, agentConn, err := relayAuth(cfg)
+	if err != nil {
+		xrayInstance.Close()
+		return nil, err
+	}
+	defer agentConn.Close()
+
+	sshCfg := &gossh.ClientConfig{
+		User:            cfg.Server.RelaySSHUser,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         15 * time.Second,
+	}
+
+	xrayAddr := fmt.Sprintf("127.0.0.1:%d", tempPort+1)
+
+	var client *gossh.Client
+	for i := 0; i < handshakeRetries(cfg.Network); i++ {
+		client, err = gossh.Dial("tcp", xrayAddr, sshCfg)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		xrayInstance.Close()
+		return nil, fmt.Errorf("SSH to relay: %w", err)
+	}
+	return &relayConn{client: client, xray: xrayInstance}, nil
+}
+
+// relayAuth returns the authentication for SSH to the relay: the server
+// key, or with server.use_agent the SSH agent's keys. The closer must be
+// called once the handshake is done.
+func relayAuth(cfg *config.Config) (gossh.AuthMethod, io.Closer, error) {
+	auth, closer, err := twssh.PublicKeyAuth(nil, filepath.Join(config.Dir(), "id_ed25519"), cfg.Server.UseAgent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("server key: %w", err)
+	}
+	return auth, closer, nil
+}
+
+// freeLoopbackPort returns a port that nothing listens on at the moment.
+func freeLoopbackPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("finding a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// closeRelayMgmt closes the management connection when unused, e.g. after
+// the relay was destroyed.
+func closeRelayMgmt() {
+	relayMgmt.mu.Lock()
+	defer relayMgmt.mu.Unlock()
+	if c := relayMgmt.cur; c != nil {
+		relayMgmt.retireLocked(c)
+	}
+}
@@ -0,0 +1,235 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// relayRebootCheckInterval is how often the running server asks the
+	// relay whether it needs a reboot. The first check runs
+	// relayRebootFirstCheck after start.
+	relayRebootCheckInterval = time.Hour
+	relayRebootFirstCheck    = 2 * time.Minute
+
+	// relayRebootTimeout is how long RebootRelay waits for the relay to
+	// come back.
+	relayRebootTimeout = 10 * time.Minute
+	relayRebootPoll    = 15 * time.Second
+)
+
+// relayRebootCheck prints the relay's boot ID, then "pending" and the
+// packages asking for the reboot when unattended-upgrades installed updates
+// that need one.
+const relayRebootCheck = "cat /proc/sys/kernel/random/boot_id; " +
+	"if [ -f /var/run/reboot-required ]; then echo pending; cat /var/run/reboot-required.pkgs 2>/dev/null; fi"
+
+// RelayReboot tells whether the relay needs a reboot to finish installing
+// OS updates, and when it gets one.
+type RelayReboot struct {
+	Pending     bool       `json:"pending"`
+	Packages    []string   `json:"packages,omitempty"`     // from /var/run/reboot-required.pkgs
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"` // the maintenance window, with auto_reboot
+	RebootedAt  *time.Time `json:"rebooted_at,omitempty"`  // last reboot by the server
+	Error       string     `json:"error,omitempty"`
+	CheckedAt   time.Time  `json:"checked_at"`
+
+	bootID string
+}
+
+// CheckRelayReboot asks the relay whether it needs a reboot.
+func (o *Ops) CheckRelayReboot() RelayReboot {
+	r := RelayReboot{CheckedAt: time.Now()}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := withRelaySSH(o.cfg, func(client *gossh.Client) error {
+		out, err := relayOutput(client, relayRebootCheck)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		r.bootID = strings.TrimSpace(lines[0])
+		if len(lines) > 1 && lines[1] == "pending" {
+			r.Pending = true
+			for _, p := range lines[2:] {
+				if p = strings.TrimSpace(p); p != "" {
+					r.Packages = append(r.Packages, p)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// RebootRelay reboots the relay, waits until it is back, and reconnects the
+// server's reverse tunnel straight away instead of waiting out its backoff.
+// Clients reconnect on their own.
+func (o *Ops) RebootRelay(ctx context.Context, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	const total = 3
+	step := publishStep(progress, total)
+
+	var bootID string
+	if err := step(1, "Reboot", func() (string, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return "reboot scheduled", withRelaySSH(o.cfg, func(client *gossh.Client) error {
+			out, err := relayOutput(client, "cat /proc/sys/kernel/random/boot_id")
+			if err != nil {
+				return err
+			}
+			bootID = strings.TrimSpace(out)
+			// Delayed, so this session ends cleanly before sshd goes down.
+			return runRelayCommand(client, "sudo systemd-run --on-active=5 --unit=tw-reboot systemctl reboot")
+		})
+	}); err != nil {
+		return fmt.Errorf("rebooting relay: %w", err)
+	}
+
+	if err := step(2, "Waiting for relay", func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, relayRebootTimeout)
+		defer cancel()
+		start := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return "", fmt.Errorf("relay not back after %s", relayRebootTimeout)
+			case <-time.After(relayRebootPoll):
+			}
+			if r := o.CheckRelayReboot(); r.Error == "" && r.bootID != bootID {
+				return fmt.Sprintf("back after %s", time.Since(start).Round(time.Second)), nil
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	return step(3, "Reverse tunnel", o.reconnectRelayTunnel)
+}
+
+// runRelayRebootMonitor checks periodically whether the relay needs a
+// reboot until stop is closed, and with server.relay_maintenance.auto_reboot
+// reboots it inside the maintenance window.
+func (o *Ops) runRelayRebootMonitor(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	timer := time.NewTimer(relayRebootFirstCheck)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(o.checkRelayRebootOnce(ctx))
+	}
+}
+
+// checkRelayRebootOnce runs one round of the reboot monitor, stores the
+// result for ServerStatus, and returns when to check next: at the latest
+// when the maintenance window opens.
+func (o *Ops) checkRelayRebootOnce(ctx context.Context) time.Duration {
+	r := o.CheckRelayReboot()
+	o.srv.mu.Lock()
+	if o.srv.reboot != nil {
+		r.RebootedAt = o.srv.reboot.RebootedAt
+	}
+	o.srv.mu.Unlock()
+
+	next := relayRebootCheckInterval
+	m := o.Config().Server.RelayMaintenance
+	switch {
+	case r.Error != "":
+		slog.Warn("could not check whether the relay needs a reboot", "error", r.Error)
+	case !r.Pending:
+		slog.Debug("relay needs no reboot")
+	case !m.AutoReboot:
+		slog.Warn("relay needs a reboot to finish OS updates; server.relay_maintenance.auto_reboot is off", "packages", r.Packages)
+	case m.Contains(time.Now()):
+		slog.Info("rebooting relay in the maintenance window to finish OS updates", "packages", r.Packages)
+		if err := o.RebootRelay(ctx, nil); err != nil {
+			slog.Warn("relay reboot failed", "error", err)
+			r.Error = err.Error()
+			break
+		}
+		now := time.Now()
+		after := o.CheckRelayReboot()
+		after.RebootedAt = &now
+		r = after
+		slog.Info("relay rebooted, reverse tunnel reconnecting")
+	default:
+		at := m.Next(time.Now())
+		r.ScheduledAt = &at
+		if until := time.Until(at); until < next {
+			next = until + 30*time.Second
+		}
+		slog.Info("relay needs a reboot to finish OS updates", "packages", r.Packages, "scheduled", at.Format(time.RFC3339))
+	}
+
+	o.srv.mu.Lock()
+	o.srv.reboot = &r
+	o.srv.mu.Unlock()
+	return next
+}
+
+// relayAptConfig is the unattended-upgrades setup the provisioning
+// templates write, keyed by path: daily security updates, with the reboot
+// left to the server.
+var relayAptConfig = map[string]string{
+	"/etc/apt/apt.conf.d/20auto-upgrades": `APT::Periodic::Update-Package-Lists "1";
+APT::Periodic::Unattended-Upgrade "1";
+`,
+	"/etc/apt/apt.conf.d/52tw-unattended-upgrades": `// Security updates install by themselves; the tw server reboots the
+// relay in server.relay_maintenance when one needs it.
+Unattended-Upgrade::Automatic-Reboot "false";
+Unattended-Upgrade::Remove-Unused-Kernel-Packages "true";
+`,
+}
+
+// ensureRelayUnattendedUpgrades sets up automatic security updates on a
+// relay provisioned before they were part of the templates.
+func ensureRelayUnattendedUpgrades(client *gossh.Client) error {
+	if runRelayCommand(client, "test -f /etc/apt/apt.conf.d/52tw-unattended-upgrades") == nil {
+		return nil
+	}
+	if err := runRelayCommand(client, "sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -qq unattended-upgrades"); err != nil {
+		return err
+	}
+	for path, content := range relayAptConfig {
+		if err := writeRelayFile(client, path, content); err != nil {
+			return err
+		}
+	}
+	slog.Info("automatic security updates set up on the relay")
+	return nil
+}
+
+// relayOutput runs cmd on the relay and returns its standard output.
+func relayOutput(client *gossh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd, err)
+	}
+	return string(out), nil
+}
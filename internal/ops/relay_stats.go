@@ -0,0 +1,156 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	statsCmd "github.com/xtls/xray-core/app/stats/command"
+)
+
+// GetRelayStats passes a read-only view of the relay's Xray StatsService
+// through tw's API, so monitoring tools get its traffic counters without
+// SSH access to the relay. Counters are never reset, and only the
+// inbound, outbound and user ones are returned.
+
+// maxRelayStatsPattern bounds the pattern a caller may filter by.
+const maxRelayStatsPattern = 128
+
+// relayStatKinds are the counter name prefixes GetRelayStats returns.
+var relayStatKinds = []string{"inbound>>>", "outbound>>>", "user>>>"}
+
+// RelayStat is one of the relay's Xray counters.
+type RelayStat struct {
+	// Name is Xray's name for it, e.g. "inbound>>>vless-in>>>traffic>>>uplink".
+	Name string `json:"name"`
+	// User is the tw user a user>>> counter belongs to: "server" for the
+	// server's own tunnel, or the UUID when no user has it.
+	User  string `json:"user,omitempty"`
+	Value int64  `json:"value"`
+}
+
+// RelaySysStats is the relay's Xray process state.
+type RelaySysStats struct {
+	Uptime       uint32 `json:"uptime_seconds"`
+	NumGoroutine uint32 `json:"goroutines"`
+	NumGC        uint32 `json:"gc_count"`
+	Alloc        uint64 `json:"alloc_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+}
+
+// RelayStats is what GetRelayStats returns.
+type RelayStats struct {
+	Stats []RelayStat    `json:"stats"`
+	Sys   *RelaySysStats `json:"sys,omitempty"`
+	Time  time.Time      `json:"time"`
+}
+
+// validRelayStatsPattern reports whether pattern is safe to pass to the
+// relay: Xray matches it as a substring of counter names.
+func validRelayStatsPattern(pattern string) error {
+	if len(pattern) > maxRelayStatsPattern {
+		return fmt.Errorf("pattern longer than %d characters", maxRelayStatsPattern)
+	}
+	for _, r := range pattern {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(">-_.@", r):
+		default:
+			return fmt.Errorf("pattern may not contain %q", r)
+		}
+	}
+	return nil
+}
+
+// GetRelayStats returns the relay's Xray counters whose names contain
+// pattern (all of them when it's empty) and, when sys is set, its process
+// state. It queries the relay over the server's running Xray tunnel.
+func (o *Ops) GetRelayStats(ctx context.Context, pattern string, sys bool) (*RelayStats, error) {
+	cfg := o.Config()
+	if cfg.Xray.RelayHost == "" {
+		return nil, errors.New("no relay configured")
+	}
+	if !o.srv.Status().Xray {
+		return nil, errors.New("the server's Xray tunnel is not running — start the server first")
+	}
+	if err := validRelayStatsPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	client, err := dialServerTunnel(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	conn, err := dialRelayGRPC(client)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	sc := statsCmd.NewStatsServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := sc.QueryStats(ctx, &statsCmd.QueryStatsRequest{Pattern: pattern})
+	if err != nil {
+		return nil, fmt.Errorf("QueryStats: %w", err)
+	}
+
+	names := o.relayUserNames(cfg.Xray.UUID)
+	out := &RelayStats{Stats: []RelayStat{}, Time: time.Now().UTC()}
+	for _, s := range resp.GetStat() {
+		if !relayStatAllowed(s.GetName()) {
+			continue
+		}
+		st := RelayStat{Name: s.GetName(), Value: s.GetValue()}
+		if parts := strings.Split(st.Name, ">>>"); parts[0] == "user" && len(parts) > 1 {
+			st.User = parts[1]
+			if name, ok := names[parts[1]]; ok {
+				st.User = name
+			}
+		}
+		out.Stats = append(out.Stats, st)
+	}
+
+	if sys {
+		s, err := sc.GetSysStats(ctx, &statsCmd.SysStatsRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("GetSysStats: %w", err)
+		}
+		out.Sys = &RelaySysStats{
+			Uptime:       s.GetUptime(),
+			NumGoroutine: s.GetNumGoroutine(),
+			NumGC:        s.GetNumGC(),
+			Alloc:        s.GetAlloc(),
+			Sys:          s.GetSys(),
+		}
+	}
+	return out, nil
+}
+
+func relayStatAllowed(name string) bool {
+	for _, k := range relayStatKinds {
+		if strings.HasPrefix(name, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// relayUserNames maps the UUIDs the relay knows users by to tw user
+// names, serverUUID to "server".
+func (o *Ops) relayUserNames(serverUUID string) map[string]string {
+	names := make(map[string]string)
+	users, _ := o.ListUsers()
+	for _, u := range users {
+		if u.UUID != "" {
+			names[u.UUID] = u.Name
+		}
+	}
+	if serverUUID != "" {
+		names[serverUUID] = "server"
+	}
+	return names
+}
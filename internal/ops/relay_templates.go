@@ -0,0 +1,70 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+)
+
+// RelayTemplate is one of the relay templates and its override, if any.
+type RelayTemplate struct {
+	Name     string `json:"name"`
+	Override string `json:"override,omitempty"` // path of the override file
+	Error    string `json:"error,omitempty"`    // why the override can't be used
+}
+
+// RelayTemplates lists the relay templates and checks the overrides in
+// config.TemplatesDir().
+func RelayTemplates() []RelayTemplate {
+	var templates []RelayTemplate
+	for _, name := range terraform.Templates() {
+		t := RelayTemplate{Name: name}
+		path := filepath.Join(config.TemplatesDir(), name)
+		if data, err := os.ReadFile(path); err == nil {
+			t.Override = path
+			if err := terraform.CheckTemplate(name, string(data)); err != nil {
+				t.Error = err.Error()
+			}
+		} else if !os.IsNotExist(err) {
+			t.Override = path
+			t.Error = err.Error()
+		}
+		templates = append(templates, t)
+	}
+	return templates
+}
+
+// ExportRelayTemplates copies the embedded templates called names, or all
+// of them, to config.TemplatesDir() to start overrides from. Existing files
+// are kept unless force is set. It returns the paths written.
+func ExportRelayTemplates(names []string, force bool) ([]string, error) {
+	if len(names) == 0 {
+		names = terraform.Templates()
+	}
+	for _, name := range names {
+		if _, ok := terraform.EmbeddedTemplate(name); !ok {
+			return nil, fmt.Errorf("unknown template %q", name)
+		}
+	}
+	dir := config.TemplatesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating templates directory: %w", err)
+	}
+
+	var written []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil && !force {
+			continue
+		}
+		content, _ := terraform.EmbeddedTemplate(name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return written, fmt.Errorf("writing %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,299 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Kinds of discrepancy between the relay, authorized_keys and users/.
+const (
+	DriftRelayOrphan     = "relay_orphan"      // a UUID on the relay belongs to no user
+	DriftUnregistered    = "unregistered"      // an enabled user's UUID is not on the relay
+	DriftDisabledOnRelay = "disabled_on_relay" // a disabled user's UUID is still on the relay
+	DriftAppliedMarker   = "applied_marker"    // .applied says otherwise than the relay
+	DriftKeyMissing      = "key_missing"       // a user has no authorized_keys entry
+	DriftKeyState        = "key_state"         // an entry is active for a disabled user, or the other way round
+	DriftKeyOrphan       = "key_orphan"        // an authorized_keys entry belongs to no user
+)
+
+// Discrepancy is one way the relay, authorized_keys and users/ disagree.
+type Discrepancy struct {
+	ID     string `json:"id"` // kind:subject, to pick it for RepairConsistency
+	Kind   string `json:"kind"`
+	User   string `json:"user,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	Key    string `json:"key,omitempty"` // an orphaned authorized_keys entry's key
+	Detail string `json:"detail"`
+	Fix    string `json:"fix"`
+}
+
+// ConsistencyReport is the result of CheckConsistency. The relay is only
+// compared when one is configured and reachable; RelayError says why it
+// wasn't.
+type ConsistencyReport struct {
+	RelayChecked bool          `json:"relay_checked"`
+	RelayError   string        `json:"relay_error,omitempty"`
+	Problems     []Discrepancy `json:"problems"`
+}
+
+// CheckConsistency compares the relay's Xray clients with the users' UUIDs
+// and .applied markers, and authorized_keys with the users' keys and
+// .disabled markers.
+func (o *Ops) CheckConsistency() (ConsistencyReport, error) {
+	return o.checkConsistency(o.Config())
+}
+
+func (o *Ops) checkConsistency(cfg *config.Config) (ConsistencyReport, error) {
+	report := ConsistencyReport{Problems: []Discrepancy{}}
+	users, err := o.ListUsers()
+	if err != nil {
+		return report, fmt.Errorf("listing users: %w", err)
+	}
+	add := func(d Discrepancy) {
+		subject := d.User
+		if subject == "" {
+			subject = d.UUID + d.Key
+		}
+		d.ID = d.Kind + ":" + subject
+		report.Problems = append(report.Problems, d)
+	}
+
+	// The relay's clients against the users' UUIDs.
+	if cfg.Xray.RelayHost == "" {
+		report.RelayError = "no relay configured"
+	} else if onRelay, err := relayClientUUIDs(cfg); err != nil {
+		report.RelayError = err.Error()
+	} else {
+		report.RelayChecked = true
+		known := map[string]bool{cfg.Xray.UUID: true}
+		for _, u := range users {
+			if u.UUID == "" {
+				continue
+			}
+			known[u.UUID] = true
+			registered := onRelay[u.UUID]
+			switch {
+			case u.Disabled && registered:
+				add(Discrepancy{Kind: DriftDisabledOnRelay, User: u.Name, UUID: u.UUID,
+					Detail: "disabled, but the UUID is still on the relay", Fix: "remove the UUID from the relay"})
+			case !u.Disabled && !registered:
+				detail := "the UUID is not on the relay"
+				if u.Active {
+					detail += ", though the user is marked registered"
+				}
+				add(Discrepancy{Kind: DriftUnregistered, User: u.Name, UUID: u.UUID,
+					Detail: detail, Fix: "register the UUID on the relay"})
+			case registered && !u.Active:
+				add(Discrepancy{Kind: DriftAppliedMarker, User: u.Name, UUID: u.UUID,
+					Detail: "on the relay, but not marked registered", Fix: "mark the user registered"})
+			case u.Disabled && u.Active:
+				add(Discrepancy{Kind: DriftAppliedMarker, User: u.Name, UUID: u.UUID,
+					Detail: "marked registered, but not on the relay", Fix: "clear the mark"})
+			}
+		}
+		for id := range onRelay {
+			if !known[id] {
+				add(Discrepancy{Kind: DriftRelayOrphan, UUID: id,
+					Detail: "on the relay, but no user has this UUID", Fix: "remove the UUID from the relay"})
+			}
+		}
+	}
+
+	// authorized_keys against the users' keys.
+	entries := authorizedKeyEntries()
+	userKeys := make(map[string]bool, len(users)+1)
+	// The server's own key, seeded by EnsureKeys, belongs to no user.
+	if pub, err := os.ReadFile(filepath.Join(config.Dir(), "id_ed25519.pub")); err == nil {
+		userKeys[publicKeyData(pub)] = true
+	}
+	for _, u := range users {
+		pub, err := os.ReadFile(filepath.Join(u.DirPath, "id_ed25519.pub"))
+		if err != nil {
+			continue
+		}
+		key := publicKeyData(pub)
+		userKeys[key] = true
+		disabled, ok := entries[key]
+		switch {
+		case !ok:
+			add(Discrepancy{Kind: DriftKeyMissing, User: u.Name,
+				Detail: "no authorized_keys entry, so the user can't sign in", Fix: "add the authorized_keys entry"})
+		case disabled && !u.Disabled:
+			add(Discrepancy{Kind: DriftKeyState, User: u.Name,
+				Detail: "enabled, but the authorized_keys entry is suspended", Fix: "restore the entry"})
+		case !disabled && u.Disabled:
+			add(Discrepancy{Kind: DriftKeyState, User: u.Name,
+				Detail: "disabled, but the authorized_keys entry is active", Fix: "suspend the entry"})
+		}
+	}
+	for key := range entries {
+		if !userKeys[key] {
+			add(Discrepancy{Kind: DriftKeyOrphan, Key: key,
+				Detail: "an authorized_keys entry for a key no user has", Fix: "remove the entry"})
+		}
+	}
+
+	slices.SortFunc(report.Problems, func(a, b Discrepancy) int { return strings.Compare(a.ID, b.ID) })
+	return report, nil
+}
+
+// RepairConsistency fixes the discrepancies with the given IDs, or all of
+// them when ids is empty, with one progress step each. It checks afresh
+// first, so a discrepancy already gone is not touched.
+func (o *Ops) RepairConsistency(ctx context.Context, ids []string, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	cfg := o.cfg
+
+	report, err := o.checkConsistency(cfg)
+	if err != nil {
+		return err
+	}
+	var todo []Discrepancy
+	for _, d := range report.Problems {
+		if len(ids) == 0 || slices.Contains(ids, d.ID) {
+			todo = append(todo, d)
+		}
+	}
+	if len(todo) == 0 {
+		return fmt.Errorf("nothing to repair")
+	}
+
+	var failed int
+	for i, d := range todo {
+		step, label := i+1, d.ID
+		progress(ProgressEvent{Step: step, Total: len(todo), Label: label, Status: "running", Message: d.Fix})
+		if err := repairDiscrepancy(cfg, d); err != nil {
+			failed++
+			progress(ProgressEvent{Step: step, Total: len(todo), Label: label, Status: "failed", Error: err.Error()})
+			continue
+		}
+		progress(ProgressEvent{Step: step, Total: len(todo), Label: label, Status: "completed", Message: d.Fix})
+	}
+	o.InvalidateOnlineCache()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repairs failed", failed, len(todo))
+	}
+	return nil
+}
+
+// repairDiscrepancy applies the fix for d with the helpers the user
+// operations use.
+func repairDiscrepancy(cfg *config.Config, d Discrepancy) error {
+	userDir := filepath.Join(config.UsersDir(), d.User)
+	switch d.Kind {
+	case DriftRelayOrphan, DriftDisabledOnRelay:
+		if err := removeUUIDFromRelay(cfg, d.UUID); err != nil {
+			return err
+		}
+		if d.User != "" {
+			os.Remove(filepath.Join(userDir, ".applied"))
+		}
+		return nil
+	case DriftUnregistered:
+		if err := addUUIDToRelay(cfg, d.UUID); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+	case DriftAppliedMarker:
+		if _, err := os.Stat(filepath.Join(userDir, ".disabled")); err == nil {
+			return os.Remove(filepath.Join(userDir, ".applied"))
+		}
+		return os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+	case DriftKeyMissing:
+		pub, err := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+		if err != nil {
+			return fmt.Errorf("reading user public key: %w", err)
+		}
+		mappings, err := userMappings(userDir)
+		if err != nil {
+			return err
+		}
+		if err := appendAuthorizedKey(pub, d.User, permitOpens(mappings, userPermits(userDir))); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(userDir, ".disabled")); err == nil {
+			return setAuthorizedKeyDisabled(pub, true)
+		}
+		return nil
+	case DriftKeyState:
+		pub, err := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+		if err != nil {
+			return fmt.Errorf("reading user public key: %w", err)
+		}
+		_, err = os.Stat(filepath.Join(userDir, ".disabled"))
+		return setAuthorizedKeyDisabled(pub, err == nil)
+	case DriftKeyOrphan:
+		return removeAuthorizedKey([]byte("key " + d.Key))
+	}
+	return fmt.Errorf("unknown discrepancy %q", d.Kind)
+}
+
+// relayClientUUIDs returns the client UUIDs in the relay's Xray config.
+func relayClientUUIDs(cfg *config.Config) (map[string]bool, error) {
+	ids := map[string]bool{}
+	err := withRelaySSH(cfg, func(client *gossh.Client) error {
+		xrayConf, err := readRelayXrayConfig(client)
+		if err != nil {
+			return err
+		}
+		_, clients, err := relayClients(xrayConf)
+		if err != nil {
+			return err
+		}
+		for _, c := range clients {
+			if cm, ok := c.(map[string]interface{}); ok {
+				if id, _ := cm["id"].(string); id != "" {
+					ids[id] = true
+				}
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// authorizedKeyEntries returns the keys in authorized_keys, by their
+// base64 data, and whether each is suspended by DisableUser.
+func authorizedKeyEntries() map[string]bool {
+	entries := map[string]bool{}
+	data, err := os.ReadFile(config.AuthorizedKeysPath())
+	if err != nil {
+		return entries
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		disabled := strings.HasPrefix(line, disabledKeyPrefix)
+		line = strings.TrimPrefix(line, disabledKeyPrefix)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key := publicKeyData([]byte(line)); key != "" {
+			entries[key] = disabled
+		}
+	}
+	return entries
+}
+
+// publicKeyData returns the base64 key data of an authorized_keys line or
+// .pub file: the field after the key type, with any options before it.
+func publicKeyData(line []byte) string {
+	fields := strings.Fields(string(line))
+	for i, f := range fields {
+		if i+1 < len(fields) && (strings.HasPrefix(f, "ssh-") || strings.HasPrefix(f, "sk-") || strings.HasPrefix(f, "ecdsa-")) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// The revocation list records the keys of deleted and disabled users.
+// Their access is cut by authorized_keys and the relay alone; the list
+// only lets the SSH server tell a client presenting one of them — from a
+// kept or replayed bundle — that its credential was revoked, where it
+// would otherwise just fail to authenticate.
+
+const revocationsFile = "revocations.json"
+
+// Revocation is a revoked user credential.
+type Revocation struct {
+	User        string    `json:"user"`
+	Fingerprint string    `json:"fingerprint"` // SHA256 fingerprint of the SSH key
+	UUID        string    `json:"uuid,omitempty"`
+	Reason      string    `json:"reason"` // "deleted" or "disabled"
+	Time        time.Time `json:"time"`
+}
+
+func revocationsPath() string {
+	return filepath.Join(config.Dir(), revocationsFile)
+}
+
+func loadRevocations() ([]Revocation, error) {
+	data, err := os.ReadFile(revocationsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", revocationsFile, err)
+	}
+	return list, nil
+}
+
+func saveRevocations(list []Revocation) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(revocationsPath(), append(data, '\n'), 0600)
+}
+
+// keyFingerprint returns the SHA256 fingerprint of an authorized_keys
+// line, or "" if it doesn't parse.
+func keyFingerprint(pubData []byte) string {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(pubData)
+	if err != nil {
+		return ""
+	}
+	return gossh.FingerprintSHA256(pub)
+}
+
+// revokeCredential records the key pubData and UUID of user as revoked
+// for reason. Failing to is only logged: access is cut without it.
+func revokeCredential(user string, pubData []byte, uuid, reason string) {
+	fp := keyFingerprint(pubData)
+	if fp == "" {
+		return
+	}
+	list, err := loadRevocations()
+	if err == nil {
+		list = withoutRevocation(list, fp)
+		list = append(list, Revocation{User: user, Fingerprint: fp, UUID: uuid, Reason: reason, Time: time.Now().UTC()})
+		err = saveRevocations(list)
+	}
+	if err != nil {
+		slog.Warn("could not record revoked credential", "user", user, "error", err)
+	}
+}
+
+// unrevokeCredential removes the key pubData from the revocation list, as
+// EnableUser gives it access again.
+func unrevokeCredential(pubData []byte) {
+	fp := keyFingerprint(pubData)
+	list, err := loadRevocations()
+	if fp == "" || err != nil {
+		return
+	}
+	if next := withoutRevocation(list, fp); len(next) != len(list) {
+		if err := saveRevocations(next); err != nil {
+			slog.Warn("could not update the revocation list", "error", err)
+		}
+	}
+}
+
+func withoutRevocation(list []Revocation, fingerprint string) []Revocation {
+	out := list[:0:0]
+	for _, r := range list {
+		if r.Fingerprint != fingerprint {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// keyRevoked says why key was revoked, or "" if it wasn't, for the SSH
+// server.
+func keyRevoked(key gossh.PublicKey) string {
+	list, err := loadRevocations()
+	if err != nil {
+		return ""
+	}
+	fp := gossh.FingerprintSHA256(key)
+	for _, r := range list {
+		if r.Fingerprint == fp {
+			return fmt.Sprintf("user %q was %s on %s", r.User, r.Reason, r.Time.Format("2006-01-02"))
+		}
+	}
+	return ""
+}
+
+// disconnectUser closes the open SSH connections of user on the running
+// server, and returns how many there were.
+func (m *serverManager) disconnectUser(user string) int {
+	m.mu.Lock()
+	srv := m.sshSrv
+	m.mu.Unlock()
+	if srv == nil {
+		return 0
+	}
+	return srv.Disconnect(user)
+}
@@ -0,0 +1,298 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/cloud"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+)
+
+// configureSecrets points the secrets store at the backend chosen by
+// credential_store.
+func configureSecrets(cfg *config.Config) {
+	if err := secrets.Configure(cfg.CredentialStore); err != nil {
+		slog.Warn("credential store", "error", err)
+	}
+}
+
+// clientKeyPath is where a client's SSH private key is kept when it isn't
+// in the OS keychain.
+func clientKeyPath() string {
+	return filepath.Join(config.Dir(), "id_ed25519")
+}
+
+// clientKey returns a client's SSH private key, from id_ed25519 or the
+// secrets store.
+func clientKey() ([]byte, error) {
+	data, err := os.ReadFile(clientKeyPath())
+	if !os.IsNotExist(err) {
+		return data, err
+	}
+	v, serr := secrets.Get(secrets.NameSSHKey)
+	if serr != nil {
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+// clientKeyOrAgent returns a client's SSH private key, or nil when it
+// authenticates with the SSH agent instead: with client.use_agent, or
+// when it has no key.
+func clientKeyOrAgent(cfg *config.Config) ([]byte, error) {
+	if cfg.Client.UseAgent {
+		return nil, nil
+	}
+	key, err := clientKey()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// relocateClientKey moves a client's SSH private key into the OS keychain
+// when that is the store in use, and back to id_ed25519 otherwise. It
+// returns a description of the move, or "" when nothing moved.
+func relocateClientKey() (string, error) {
+	path := clientKeyPath()
+	if secrets.Backend() == secrets.StoreKeychain {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading client key: %w", err)
+		}
+		if err := secrets.Set(secrets.NameSSHKey, string(data)); err != nil {
+			return "", fmt.Errorf("storing client key: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return "", fmt.Errorf("removing client key file: %w", err)
+		}
+		return "client SSH key moved from " + path + " to the OS keychain", nil
+	}
+
+	v, err := secrets.Get(secrets.NameSSHKey)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading stored client key: %w", err)
+	}
+	// A key file written since (a new config bundle) wins over the stored one.
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.WriteFile(path, []byte(v), 0600); err != nil {
+			return "", fmt.Errorf("writing client key: %w", err)
+		}
+	}
+	if err := secrets.Delete(secrets.NameSSHKey); err != nil {
+		return "", fmt.Errorf("removing stored client key: %w", err)
+	}
+	return "client SSH key moved to " + path, nil
+}
+
+// relayCredentialEnv returns the environment Terraform needs to reach a
+// provider's API. Tokens are passed as TF_VAR_ variables so they never land
+// in terraform.tfvars.
+func relayCredentialEnv(providerKey, token, awsSecretKey string) map[string]string {
+	env := map[string]string{}
+	if providerKey == "aws" {
+		env["AWS_ACCESS_KEY_ID"] = token
+		env["AWS_SECRET_ACCESS_KEY"] = awsSecretKey
+		return env
+	}
+	for _, p := range CloudProviders() {
+		if p.Key == providerKey && p.VarName != "" {
+			env["TF_VAR_"+p.VarName] = token
+		}
+	}
+	return env
+}
+
+// storeRelayCredentials keeps a provider's credentials in the secrets store
+// so the relay can be destroyed later without asking for them again.
+func storeRelayCredentials(providerKey, token, awsSecretKey string) error {
+	if providerKey == "aws" {
+		if err := secrets.Set(secrets.NameAWSAccessKeyID, token); err != nil {
+			return err
+		}
+		return secrets.Set(secrets.NameAWSSecretKey, awsSecretKey)
+	}
+	return secrets.Set(secrets.CloudToken(providerKey), token)
+}
+
+// storedRelayCredentials returns the Terraform environment for a provider
+// from the secrets store, or nil when its credentials aren't stored.
+func storedRelayCredentials(providerKey string) map[string]string {
+	if providerKey == "aws" {
+		keyID, err := secrets.Get(secrets.NameAWSAccessKeyID)
+		if err != nil {
+			return nil
+		}
+		secret, err := secrets.Get(secrets.NameAWSSecretKey)
+		if err != nil {
+			return nil
+		}
+		return relayCredentialEnv(providerKey, keyID, secret)
+	}
+	token, err := secrets.Get(secrets.CloudToken(providerKey))
+	if err != nil {
+		return nil
+	}
+	return relayCredentialEnv(providerKey, token, "")
+}
+
+// deleteRelayCredentials removes a provider's credentials from the secrets
+// store once its relay is gone.
+func deleteRelayCredentials(providerKey string) {
+	names := []string{secrets.CloudToken(providerKey)}
+	if providerKey == "aws" {
+		names = []string{secrets.NameAWSAccessKeyID, secrets.NameAWSSecretKey}
+	}
+	for _, name := range names {
+		if err := secrets.Delete(name); err != nil {
+			slog.Warn("could not remove stored cloud credentials", "name", name, "error", err)
+		}
+	}
+}
+
+// providerKeyByName maps a provider display name ("Hetzner") to its key
+// ("hetzner").
+func providerKeyByName(name string) string {
+	for _, p := range CloudProviders() {
+		if p.Name == name {
+			return p.Key
+		}
+	}
+	return ""
+}
+
+// tokenProvider returns the provider whose API token is the Terraform
+// variable name.
+func tokenProvider(name string) (CloudProvider, bool) {
+	for _, p := range CloudProviders() {
+		if p.VarName != "" && p.VarName == name {
+			return p, true
+		}
+	}
+	return CloudProvider{}, false
+}
+
+// privateFiles are files under the config directory that hold UUIDs,
+// tokens, or keys and should be readable by their owner only.
+func privateFiles() []string {
+	files := []string{config.FilePath(), config.BackupPath()}
+	relayDir := config.RelayDir()
+	for _, name := range []string{"terraform.tfvars", "terraform.tfstate", "terraform.tfstate.backup", "cloud-init.yaml", cloud.ManifestFile} {
+		files = append(files, filepath.Join(relayDir, name))
+	}
+	userConfigs, _ := filepath.Glob(filepath.Join(config.UsersDir(), "*", "config.yaml"))
+	return append(files, userConfigs...)
+}
+
+// MigrateSecrets moves plaintext credentials left by earlier versions into
+// the secrets store: cloud tokens in terraform.tfvars and a proxy URL with
+// a password in config.yaml. After credential_store changes, it moves the
+// stored values to the new backend, and in client mode the SSH private key
+// into or out of the OS keychain. It also restricts files that hold UUIDs
+// or tokens to their owner. It returns a line per change made; running it
+// again once everything is migrated changes nothing.
+func (o *Ops) MigrateSecrets() ([]string, error) {
+	var changes []string
+
+	n, from, err := secrets.Relocate()
+	if n > 0 {
+		changes = append(changes, fmt.Sprintf("%d secret(s) moved from %s", n, from))
+	}
+	if err != nil {
+		return changes, err
+	}
+
+	if o.Mode() == "client" {
+		moved, err := relocateClientKey()
+		if moved != "" {
+			changes = append(changes, moved)
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	moved, err := migrateTFVars()
+	if err != nil {
+		return changes, err
+	}
+	changes = append(changes, moved...)
+
+	o.mu.Lock()
+	proxy := o.cfg.Proxy
+	o.mu.Unlock()
+	if !secrets.IsRef(proxy) && proxyHasPassword(proxy) {
+		if err := o.SetProxy(proxy); err != nil {
+			return changes, fmt.Errorf("moving proxy credentials: %w", err)
+		}
+		changes = append(changes, "proxy credentials moved from config.yaml to "+secrets.Ref(secrets.NameProxy))
+	}
+
+	for _, path := range privateFiles() {
+		fi, err := os.Stat(path)
+		if err != nil || fi.Mode().Perm()&0077 == 0 {
+			continue
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			return changes, fmt.Errorf("restricting %s: %w", path, err)
+		}
+		changes = append(changes, "restricted "+path+" to its owner")
+	}
+	return changes, nil
+}
+
+// migrateTFVars moves cloud tokens out of terraform.tfvars, leaving the
+// other variables (the region) in place.
+func migrateTFVars() ([]string, error) {
+	path := filepath.Join(config.RelayDir(), "terraform.tfvars")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading terraform.tfvars: %w", err)
+	}
+
+	var changes, kept []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		p, ok := tokenProvider(strings.TrimSpace(name))
+		if !ok {
+			kept = append(kept, line)
+			continue
+		}
+		token, err := strconv.Unquote(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s in terraform.tfvars: %w", p.VarName, err)
+		}
+		if err := secrets.Set(secrets.CloudToken(p.Key), token); err != nil {
+			return nil, fmt.Errorf("storing %s token: %w", p.Name, err)
+		}
+		changes = append(changes, fmt.Sprintf("%s token moved from terraform.tfvars to %s", p.Name, secrets.Ref(secrets.CloudToken(p.Key))))
+	}
+	if changes == nil {
+		return nil, nil
+	}
+
+	if len(kept) == 0 {
+		err = os.Remove(path)
+	} else {
+		err = os.WriteFile(path, []byte(strings.Join(kept, "\n")+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rewriting terraform.tfvars: %w", err)
+	}
+	return changes, nil
+}
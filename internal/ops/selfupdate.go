@@ -0,0 +1,291 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	goversion "github.com/hashicorp/go-version"
+	"github.com/tunnelwhisperer/tw/internal/version"
+)
+
+// A tw release on GitHub carries one binary per platform, named as
+// ReleaseAsset returns, checksums.txt with their SHA-256 sums in sha256sum
+// format, and checksums.txt.sig, the Ed25519 signature of checksums.txt by
+// the key release builds carry in version.ReleaseKey.
+
+const releasesAPI = "https://api.github.com/repos/tunnelwhisperer/tw/releases"
+
+const (
+	checksumsAsset = "checksums.txt"
+	signatureAsset = "checksums.txt.sig"
+)
+
+// Release is a tw release on GitHub.
+type Release struct {
+	Version   string            `json:"version"`
+	URL       string            `json:"url"`
+	Published time.Time         `json:"published"`
+	Assets    map[string]string `json:"-"` // name → download URL
+}
+
+// Newer reports whether r is newer than the running binary. A dev build is
+// older than any release.
+func (r *Release) Newer() bool {
+	if version.IsDev() {
+		return true
+	}
+	cur, err1 := goversion.NewVersion(version.Version)
+	rel, err2 := goversion.NewVersion(r.Version)
+	if err1 != nil || err2 != nil {
+		return r.Version != version.Version
+	}
+	return rel.GreaterThan(cur)
+}
+
+// ReleaseAsset returns the name of the release binary for this platform,
+// e.g. tw-linux-amd64 or tw-windows-amd64.exe.
+func ReleaseAsset() string {
+	name := "tw-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// FindRelease returns the release tagged tag, or the latest one when tag
+// is empty. Pre-releases are only found by their tag.
+func FindRelease(ctx context.Context, tag string) (*Release, error) {
+	url := releasesAPI + "/latest"
+	if tag != "" {
+		url = releasesAPI + "/tags/" + tag
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "tw/"+version.Version)
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("checking for releases: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound && tag != "":
+		return nil, fmt.Errorf("no release %s", tag)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("no releases published yet")
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("checking for releases: GitHub returned %s", resp.Status)
+	}
+
+	var body struct {
+		TagName     string    `json:"tag_name"`
+		HTMLURL     string    `json:"html_url"`
+		PublishedAt time.Time `json:"published_at"`
+		Assets      []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("reading release: %w", err)
+	}
+	r := &Release{
+		Version:   body.TagName,
+		URL:       body.HTMLURL,
+		Published: body.PublishedAt,
+		Assets:    make(map[string]string, len(body.Assets)),
+	}
+	for _, a := range body.Assets {
+		r.Assets[a.Name] = a.URL
+	}
+	return r, nil
+}
+
+// SelfUpdate replaces the running tw binary with r's for this platform. It
+// checks the signature of r's checksums when this build has a release key,
+// and the binary's checksum always; nothing is replaced unless both hold.
+// It returns the path of the binary replaced. A running tw keeps running
+// the old binary until it is restarted.
+func SelfUpdate(ctx context.Context, r *Release, progress ProgressFunc) (string, error) {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	const total = 4
+	fail := func(step int, label string, err error) (string, error) {
+		progress(ProgressEvent{Step: step, Total: total, Label: label, Status: "failed", Error: err.Error()})
+		return "", err
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return "", fmt.Errorf("finding the tw binary: %w", err)
+	}
+	asset := ReleaseAsset()
+	if r.Assets[asset] == "" {
+		return "", fmt.Errorf("release %s has no binary for %s/%s (%s)", r.Version, runtime.GOOS, runtime.GOARCH, asset)
+	}
+
+	// Step 1: the checksums, and their signature.
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Checksums", Status: "running"})
+	sums, err := download(ctx, r.Assets[checksumsAsset], 1<<20)
+	if err != nil {
+		return fail(1, "Checksums", fmt.Errorf("downloading %s: %w", checksumsAsset, err))
+	}
+	msg := "no release key in this build — checking the checksum only"
+	if version.ReleaseKey != "" {
+		sig, err := download(ctx, r.Assets[signatureAsset], 1<<10)
+		if err != nil {
+			return fail(1, "Checksums", fmt.Errorf("downloading %s: %w", signatureAsset, err))
+		}
+		if err := verifyChecksums(sums, sig); err != nil {
+			return fail(1, "Checksums", err)
+		}
+		msg = "signature verified"
+	}
+	want, ok := checksumFor(sums, asset)
+	if !ok {
+		return fail(1, "Checksums", fmt.Errorf("%s has no checksum for %s", checksumsAsset, asset))
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Checksums", Status: "completed", Message: msg})
+
+	// Step 2: the binary, into the directory it replaces so the swap is a
+	// rename.
+	progress(ProgressEvent{Step: 2, Total: total, Label: "Download", Status: "running", Message: asset})
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".tw-update-*")
+	if err != nil {
+		return fail(2, "Download", fmt.Errorf("writing next to %s: %w", exe, err))
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := downloadTo(ctx, r.Assets[asset], io.MultiWriter(tmp, h), 0)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fail(2, "Download", fmt.Errorf("downloading %s: %w", asset, err))
+	}
+	progress(ProgressEvent{Step: 2, Total: total, Label: "Download", Status: "completed", Message: fmt.Sprintf("%s, %d bytes", asset, n)})
+
+	// Step 3: the checksum.
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Verify", Status: "running"})
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fail(3, "Verify", fmt.Errorf("%s checksum mismatch: got %s, release says %s", asset, got, want))
+	}
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Verify", Status: "completed", Message: "SHA-256 matches"})
+
+	// Step 4: the swap.
+	progress(ProgressEvent{Step: 4, Total: total, Label: "Replace", Status: "running", Message: exe})
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fail(4, "Replace", err)
+	}
+	if err := replaceExecutable(exe, tmp.Name()); err != nil {
+		return fail(4, "Replace", err)
+	}
+	progress(ProgressEvent{Step: 4, Total: total, Label: "Replace", Status: "completed", Message: exe + " is " + r.Version})
+	return exe, nil
+}
+
+// replaceExecutable moves next into exe's place. The old binary is moved
+// aside first, since Windows won't overwrite a running one but lets it be
+// renamed, and removed after — which Windows refuses too, so it is left
+// as exe.old until the next update.
+func replaceExecutable(exe, next string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("moving %s aside: %w", exe, err)
+	}
+	if err := os.Rename(next, exe); err != nil {
+		os.Rename(old, exe)
+		return fmt.Errorf("replacing %s: %w", exe, err)
+	}
+	os.Remove(old)
+	return nil
+}
+
+// verifyChecksums checks sig, raw or base64, against version.ReleaseKey.
+func verifyChecksums(sums, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(version.ReleaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("this build's release key is malformed")
+	}
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("%s is malformed", signatureAsset)
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, sig) {
+		return fmt.Errorf("%s does not match %s — the release was not signed with tw's release key", signatureAsset, checksumsAsset)
+	}
+	return nil
+}
+
+// checksumFor returns the SHA-256 sum of name in sha256sum output.
+func checksumFor(sums []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// download fetches a small release asset, of at most limit bytes.
+func download(ctx context.Context, url string, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := downloadTo(ctx, url, &buf, limit+1); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return buf.Bytes(), nil
+}
+
+// downloadTo copies a release asset to w, up to limit bytes if limit > 0.
+func downloadTo(ctx context.Context, url string, w io.Writer, limit int64) (int64, error) {
+	if url == "" {
+		return 0, fmt.Errorf("not in the release")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "tw/"+version.Version)
+	resp, err := (&http.Client{Timeout: 10 * time.Minute}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(body, limit)
+	}
+	return io.Copy(w, body)
+}
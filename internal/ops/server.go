@@ -3,7 +3,11 @@ package ops
 import (
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -18,8 +22,30 @@ type ServerStatus struct {
 	SSH         bool        `json:"ssh"`
 	Xray        bool        `json:"xray"`
 	Tunnel      bool        `json:"tunnel"`
+	API         bool        `json:"api"`
 	Error       string      `json:"error,omitempty"`
 	TunnelError string      `json:"tunnel_error,omitempty"`
+
+	// Components lists the server's components: whether each is up and
+	// answering its probe, how often it was restarted, why it last
+	// stopped, and since when it runs. SSH, Xray, Tunnel and API reflect
+	// the same checks.
+	Components []ComponentStatus `json:"components,omitempty"`
+
+	// Forwards lists each reverse forward, the SSH forward first.
+	Forwards []twssh.ReverseForwardStatus `json:"forwards,omitempty"`
+
+	// Direct lists the traffic clients forwarded through the SSH server,
+	// per destination.
+	Direct []twssh.DestinationStats `json:"direct,omitempty"`
+
+	// Certs holds the latest relay certificate check, the relay domain
+	// first. Empty until the first check after start.
+	Certs []RelayCert `json:"certs,omitempty"`
+
+	// RelayReboot holds the latest check for a pending relay reboot. Nil
+	// until the first check after start.
+	RelayReboot *RelayReboot `json:"relay_reboot,omitempty"`
 }
 
 // serverManager controls the lifecycle of all server components.
@@ -28,9 +54,50 @@ type serverManager struct {
 	state    ServerState
 	lastErr  string
 	cfgHash  string // config hash at startup, for change detection
+	cfgData  []byte // config file at startup, the base for edit previews
 	sshSrv   *twssh.Server
 	xrayInst *twxray.Instance
 	tunnel   *twssh.ReverseTunnel
+	certStop chan struct{} // closes the certificate monitor
+	certs    []RelayCert
+
+	stop       chan struct{} // closed by Stop, ends supervision
+	comps      []*component
+	sshComp    *component
+	xrayComp   *component
+	tunnelComp *component
+	sshPort    int // probed by Status
+	xrayPort   int
+	probes     probeCache
+
+	rebootStop chan struct{} // closes the relay reboot monitor
+	reboot     *RelayReboot
+
+	links *http.Server // bundle link server, when bundle links are on
+
+	events *statusHub
+}
+
+// setState changes the lifecycle state and publishes the change. Callers
+// hold m.mu and set lastErr first.
+func (m *serverManager) setState(s ServerState) {
+	if m.state == s {
+		return
+	}
+	m.state = s
+	m.events.publish(StatusEvent{Type: "state", Source: "server", State: s, Error: m.lastErr})
+}
+
+// configSaved takes the saved config as the one the server started with,
+// when it had started with the one saved before, whose hash is before: a
+// setting applied at runtime needs no restart to take effect.
+func (m *serverManager) configSaved(before string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateRunning && m.cfgHash == before {
+		m.cfgHash = config.FileHash()
+		m.cfgData, _ = os.ReadFile(config.FilePath())
+	}
 }
 
 // Start launches all server components (SSH, Xray, reverse tunnel).
@@ -40,8 +107,8 @@ func (m *serverManager) Start(o *Ops, progress ProgressFunc) error {
 		m.mu.Unlock()
 		return fmt.Errorf("server already %s", m.state)
 	}
-	m.state = StateStarting
 	m.lastErr = ""
+	m.setState(StateStarting)
 	m.mu.Unlock()
 
 	if progress == nil {
@@ -52,12 +119,18 @@ func (m *serverManager) Start(o *Ops, progress ProgressFunc) error {
 
 	m.mu.Lock()
 	m.cfgHash = config.FileHash()
+	m.cfgData, _ = os.ReadFile(config.FilePath())
+	m.stop = make(chan struct{})
+	m.comps, m.sshComp, m.xrayComp, m.tunnelComp = nil, nil, nil, nil
+	m.sshPort, m.xrayPort = cfg.Server.SSHPort, cfg.Server.SSHPort+1
+	stop := m.stop
 	m.mu.Unlock()
+	m.probes.reset()
 
 	fail := func(step, total int, label string, err error) error {
 		m.mu.Lock()
-		m.state = StateError
 		m.lastErr = err.Error()
+		m.setState(StateError)
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: total, Label: label, Status: "failed", Error: err.Error()})
 		return err
@@ -77,25 +150,47 @@ func (m *serverManager) Start(o *Ops, progress ProgressFunc) error {
 
 	// Step 2: Start SSH server.
 	progress(ProgressEvent{Step: 2, Total: total, Label: "SSH server", Status: "running"})
+	if err := CheckReservedPorts(
+		ListenPort{"server.ssh_port", cfg.Server.SSHPort},
+		ListenPort{"server.ssh_port + 1 (Xray)", cfg.Server.SSHPort + 1},
+	); err != nil {
+		return fail(2, total, "SSH server", err)
+	}
 	sshServer, err := twssh.NewServer(cfg.Server.SSHPort, config.HostKeyDir(), config.AuthorizedKeysPath())
 	if err != nil {
 		return fail(2, total, "SSH server", err)
 	}
+	sshServer.Network = networkOptions(cfg.Network)
+	sshServer.Limiter = o.sshBans
+	sshServer.SFTPRoot = userSFTPRoot
+	sshServer.Version = ServerSSHVersion()
+	sshServer.ConfigFor = userClientConfig
+	sshServer.Revoked = keyRevoked
+	sshServer.TOTPSecret = userTOTPSecret
+	sshServer.Invited = userInvited
+	sshServer.Claim = o.claimInvite
+	if sshServer.GeoIP, err = geoFilter(cfg.GeoIP); err != nil {
+		return fail(2, total, "SSH server", fmt.Errorf("loading GeoIP database: %w", err))
+	}
+	if sshServer.Events, err = eventSinks(cfg.Server.EventSinks); err != nil {
+		return fail(2, total, "SSH server", err)
+	}
 	sshServer.OnConnect = func(user string) {
 		slog.Info("client connected, refreshing online status", "user", user)
 		o.InvalidateOnlineCache()
+		o.presence.connected(user)
+		m.events.publish(StatusEvent{Type: "user_connected", Source: "server", User: user})
 	}
 	sshServer.OnDisconnect = func(user string) {
 		slog.Info("client disconnected, refreshing online status", "user", user)
 		o.InvalidateOnlineCache()
+		o.presence.disconnected(user)
+		m.events.publish(StatusEvent{Type: "user_disconnected", Source: "server", User: user})
 	}
-	go func() {
-		if err := sshServer.Run(); err != nil {
-			slog.Error("SSH server error", "error", err)
-		}
-	}()
 	m.mu.Lock()
 	m.sshSrv = sshServer
+	m.sshComp = supervise("SSH server", stop, restartable(func() { sshServer.Stop() }, sshServer.Run))
+	m.comps = append(m.comps, m.sshComp)
 	m.mu.Unlock()
 	progress(ProgressEvent{Step: 2, Total: total, Label: "SSH server", Status: "completed", Message: fmt.Sprintf("listening on :%d", cfg.Server.SSHPort)})
 
@@ -115,40 +210,75 @@ func (m *serverManager) Start(o *Ops, progress ProgressFunc) error {
 		if err != nil {
 			return fail(step, total, "Xray tunnel", err)
 		}
-		if err := xrayInstance.Start(cfg.Server.SSHPort, cfg.Server.RelaySSHPort, cfg.Proxy); err != nil {
+		proxyURL, source := relayProxy(cfg, twxray.ServerRelayAddress(cfg.Xray))
+		if source != "" && source != proxySourceConfig {
+			slog.Info("using detected proxy", "source", source, "proxy", proxyURL)
+		}
+		if err := xrayInstance.Start(cfg.Server.SSHPort, cfg.Server.RelaySSHPort, proxyURL, cfg.ProxyRules); err != nil {
 			return fail(step, total, "Xray tunnel", err)
 		}
 		m.mu.Lock()
 		m.xrayInst = xrayInstance
+		m.xrayComp = newComponent("Xray")
+		m.comps = append(m.comps, m.xrayComp)
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: total, Label: "Xray tunnel", Status: "completed", Message: fmt.Sprintf("%s:%d%s", cfg.Xray.RelayHost, cfg.Xray.RelayPort, cfg.Xray.Path)})
 
 		step++
-		xrayListenPort := cfg.Server.SSHPort + 1
+		xrayListenPort := m.xrayPort
 		progress(ProgressEvent{Step: step, Total: total, Label: "Reverse tunnel", Status: "running"})
+		forwards, err := reverseForwards(cfg)
+		if err != nil {
+			return fail(step, total, "Reverse tunnel", err)
+		}
 		privPath := filepath.Join(config.Dir(), "id_ed25519")
 		rt := &twssh.ReverseTunnel{
 			RemoteAddr: fmt.Sprintf("127.0.0.1:%d", xrayListenPort),
 			User:       cfg.Server.RelaySSHUser,
 			KeyPath:    privPath,
-			RemotePort: cfg.Server.RemotePort,
-			LocalAddr:  fmt.Sprintf("127.0.0.1:%d", cfg.Server.SSHPort),
+			UseAgent:   cfg.Server.UseAgent,
+			Forwards:   forwards,
+			Network:    networkOptions(cfg.Network),
+			OnEvent:    m.events.tunnelEvents("server"),
 		}
-		go func() {
-			if err := rt.Run(); err != nil {
-				slog.Error("reverse tunnel error", "error", err)
-			}
-		}()
 		m.mu.Lock()
 		m.tunnel = rt
+		m.tunnelComp = supervise("Reverse tunnel", stop, restartable(rt.Stop, rt.Run))
+		m.comps = append(m.comps, m.tunnelComp)
 		m.mu.Unlock()
-		progress(ProgressEvent{Step: step, Total: total, Label: "Reverse tunnel", Status: "completed", Message: fmt.Sprintf("relay :%d → local :%d", cfg.Server.RemotePort, cfg.Server.SSHPort)})
+		progress(ProgressEvent{Step: step, Total: total, Label: "Reverse tunnel", Status: "completed", Message: describeForwards(forwards)})
 	}
 
 	m.mu.Lock()
-	m.state = StateRunning
+	m.setState(StateRunning)
+	m.certs = nil
+	m.reboot = nil
+	if cfg.Xray.RelayHost != "" {
+		certStop := make(chan struct{})
+		m.certStop = certStop
+		m.comps = append(m.comps, supervise("Certificate monitor", certStop, func() error {
+			o.runCertMonitor(certStop)
+			return nil
+		}))
+		rebootStop := make(chan struct{})
+		m.rebootStop = rebootStop
+		m.comps = append(m.comps, supervise("Relay reboot monitor", rebootStop, func() error {
+			o.runRelayRebootMonitor(rebootStop)
+			return nil
+		}))
+		m.comps = append(m.comps, supervise("Online poller", stop, func() error {
+			o.runOnlinePoller(stop)
+			return nil
+		}))
+	}
 	m.mu.Unlock()
 
+	if links := cfg.Server.BundleLinks; links != nil && cfg.Xray.RelayHost != "" {
+		if err := m.startBundleLinks(o, links.Port); err != nil {
+			slog.Warn("bundle links unavailable", "error", err)
+		}
+	}
+
 	// Patch relay stats config in the background if needed.
 	go o.EnsureRelayStats()
 
@@ -162,12 +292,13 @@ func (m *serverManager) Stop(progress ProgressFunc) error {
 		m.mu.Unlock()
 		return fmt.Errorf("server not running (state: %s)", m.state)
 	}
-	m.state = StateStopping
+	m.setState(StateStopping)
 	m.mu.Unlock()
 
 	if progress == nil {
 		progress = func(ProgressEvent) {}
 	}
+	m.stopBundleLinks()
 
 	step := 1
 	total := 0
@@ -188,6 +319,18 @@ func (m *serverManager) Stop(progress ProgressFunc) error {
 	}
 
 	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	if m.certStop != nil {
+		close(m.certStop)
+		m.certStop = nil
+	}
+	if m.rebootStop != nil {
+		close(m.rebootStop)
+		m.rebootStop = nil
+	}
 	if m.tunnel != nil {
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: total, Label: "Reverse tunnel", Status: "running"})
@@ -204,6 +347,7 @@ func (m *serverManager) Stop(progress ProgressFunc) error {
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: total, Label: "Xray tunnel", Status: "running"})
 		m.xrayInst.Close()
+		m.xrayComp.setRunning(false)
 		m.mu.Lock()
 		m.xrayInst = nil
 		m.mu.Unlock()
@@ -216,6 +360,11 @@ func (m *serverManager) Stop(progress ProgressFunc) error {
 		m.mu.Unlock()
 		progress(ProgressEvent{Step: step, Total: total, Label: "SSH server", Status: "running"})
 		m.sshSrv.Stop()
+		if m.sshSrv.Events != nil {
+			if err := m.sshSrv.Events.Close(); err != nil {
+				slog.Warn("closing event sinks", "error", err)
+			}
+		}
 		m.mu.Lock()
 		m.sshSrv = nil
 		m.mu.Unlock()
@@ -225,34 +374,139 @@ func (m *serverManager) Stop(progress ProgressFunc) error {
 	m.mu.Unlock()
 
 	m.mu.Lock()
-	m.state = StateStopped
 	m.lastErr = ""
+	m.setState(StateStopped)
+	m.comps, m.sshComp, m.xrayComp, m.tunnelComp = nil, nil, nil, nil
 	m.mu.Unlock()
 
 	return nil
 }
 
-// Status returns the current server state with real health checks.
+// Status returns the current server state with real health checks: the
+// SSH server must send its banner, the Xray inbound accept a connection,
+// and the reverse tunnel be connected. Probes run outside the lock and
+// their results are cached (see probeTTL).
 func (m *serverManager) Status() ServerStatus {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	s := ServerStatus{
-		State: m.state,
-		SSH:   m.sshSrv != nil,
-		Error: m.lastErr,
+		State:       m.state,
+		Error:       m.lastErr,
+		Certs:       m.certs,
+		RelayReboot: m.reboot,
 	}
+	comps := m.comps
+	sshComp, xrayComp, tunnelComp := m.sshComp, m.xrayComp, m.tunnelComp
+	sshPort, xrayPort := m.sshPort, m.xrayPort
+	xrayInst, tunnel, sshSrv := m.xrayInst, m.tunnel, m.sshSrv
+	m.mu.Unlock()
 
-	// Xray: check if the instance is actually running, not just allocated.
-	if m.xrayInst != nil {
-		s.Xray = m.xrayInst.Running()
+	if sshSrv != nil {
+		s.Direct = sshSrv.Stats()
 	}
 
-	// Tunnel: check real connection state, not just pointer existence.
-	if m.tunnel != nil {
-		s.Tunnel = m.tunnel.Connected()
-		s.TunnelError = m.tunnel.LastError()
+	probeErrs := map[*component]error{}
+	if sshComp.isRunning() {
+		probeErrs[sshComp] = m.probes.run("ssh", func() error { return probeSSH(sshPort) })
+	}
+	if xrayComp.isRunning() {
+		if !xrayInst.Running() {
+			probeErrs[xrayComp] = fmt.Errorf("instance not running")
+		} else {
+			probeErrs[xrayComp] = m.probes.run("xray", func() error { return probeTCP(xrayPort) })
+		}
+	}
+	if tunnel != nil {
+		s.TunnelError = tunnel.LastError()
+		s.Forwards = tunnel.ForwardStatus()
+		// A tunnel whose goroutine crashed may still claim to be connected.
+		if tunnelComp.isRunning() && !tunnel.Connected() {
+			probeErrs[tunnelComp] = fmt.Errorf("not connected")
+			if s.TunnelError != "" {
+				probeErrs[tunnelComp] = fmt.Errorf("not connected: %s", s.TunnelError)
+			}
+		}
 	}
 
+	// The tunnel reports its own errors in TunnelError.
+	var others []ComponentStatus
+	for _, c := range comps {
+		cs := c.status(probeErrs[c])
+		s.Components = append(s.Components, cs)
+		switch c {
+		case sshComp:
+			s.SSH = cs.Running
+		case xrayComp:
+			s.Xray = cs.Running
+		case tunnelComp:
+			s.Tunnel = cs.Running
+			continue
+		}
+		others = append(others, cs)
+	}
+	if s.Error == "" && s.State == StateRunning {
+		s.Error = componentsError(others)
+	}
 	return s
 }
+
+// reverseForwards returns the SSH forward followed by the configured
+// server.reverse_forwards, rejecting invalid or conflicting relay ports.
+func reverseForwards(cfg *config.Config) ([]twssh.ReverseForward, error) {
+	forwards := []twssh.ReverseForward{{
+		Name:       "ssh",
+		RemotePort: cfg.Server.RemotePort,
+		LocalAddr:  fmt.Sprintf("127.0.0.1:%d", cfg.Server.SSHPort),
+	}}
+	seen := map[int]bool{cfg.Server.RemotePort: true}
+	for _, f := range cfg.Server.ReverseForwards {
+		if f.RemotePort < 1 || f.RemotePort > 65535 {
+			return nil, fmt.Errorf("reverse forward %q: invalid remote_port %d", f.Name, f.RemotePort)
+		}
+		if seen[f.RemotePort] {
+			return nil, fmt.Errorf("reverse forward %q: relay port %d is already forwarded", f.Name, f.RemotePort)
+		}
+		if _, _, err := net.SplitHostPort(f.LocalAddr); err != nil {
+			return nil, fmt.Errorf("reverse forward %q: invalid local_addr %q: %w", f.Name, f.LocalAddr, err)
+		}
+		seen[f.RemotePort] = true
+		forwards = append(forwards, twssh.ReverseForward{
+			Name:       f.Name,
+			RemotePort: f.RemotePort,
+			LocalAddr:  f.LocalAddr,
+		})
+	}
+	if links := cfg.Server.BundleLinks; links != nil {
+		if seen[links.RelayPort] {
+			return nil, fmt.Errorf("bundle links: relay port %d is already forwarded", links.RelayPort)
+		}
+		forwards = append(forwards, bundleLinkForward(links))
+	}
+	return forwards, nil
+}
+
+// describeForwards renders forwards for progress messages.
+func describeForwards(forwards []twssh.ReverseForward) string {
+	parts := make([]string, len(forwards))
+	for i, f := range forwards {
+		parts[i] = fmt.Sprintf("relay :%d → %s", f.RemotePort, f.LocalAddr)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// networkOptions converts the network config section for the ssh package.
+func networkOptions(n config.NetworkConfig) twssh.NetworkOptions {
+	return twssh.NetworkOptions{
+		KeepaliveInterval: n.KeepaliveInterval,
+		DialTimeout:       n.DialTimeout,
+		MaxBackoff:        n.MaxBackoff,
+	}
+}
+
+// handshakeRetries returns how many times to try SSH through a freshly
+// started Xray instance before giving up.
+func handshakeRetries(n config.NetworkConfig) int {
+	if n.HandshakeRetries < 1 {
+		return 1
+	}
+	return n.HandshakeRetries
+}
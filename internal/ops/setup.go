@@ -3,13 +3,17 @@ package ops
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
 )
 
 // UploadClientConfig extracts a config zip (config.yaml + SSH keys) into the
@@ -77,5 +81,181 @@ func (o *Ops) UploadClientConfig(zipData []byte) error {
 		return fmt.Errorf("saving config: %w", err)
 	}
 
+	// Move the uploaded key into the OS keychain if that is where it goes.
+	changes, err := o.MigrateSecrets()
+	for _, c := range changes {
+		slog.Info("secrets migration: " + c)
+	}
+	if err != nil {
+		return fmt.Errorf("storing uploaded credentials: %w", err)
+	}
 	return nil
 }
+
+// First-run setup steps, in the order the dashboard's setup wizard walks
+// through them. A server goes mode, keys, relay, server, user, verify; a
+// client mode, bundle, tunnel, verify.
+const (
+	SetupMode   = "mode"   // server or client
+	SetupKeys   = "keys"   // the server's SSH key pair
+	SetupRelay  = "relay"  // a provisioned or manually installed relay
+	SetupServer = "server" // the server running
+	SetupUser   = "user"   // the first client user
+	SetupBundle = "bundle" // the client's config bundle
+	SetupTunnel = "tunnel" // the client running its tunnels
+	SetupVerify = "verify" // a passing connectivity test
+)
+
+// setupFile records the setup steps that leave nothing else behind, in
+// the config directory.
+const setupFile = "setup.json"
+
+// SetupStep is one step of the first-run setup.
+type SetupStep struct {
+	Name   string `json:"name"`
+	Title  string `json:"title"`
+	Done   bool   `json:"done"`
+	Detail string `json:"detail,omitempty"` // what was found, e.g. the relay's domain
+}
+
+// SetupState is how far the first-run setup has come. It is worked out
+// from the config directory each time, so the wizard resumes where it was
+// left, in any browser.
+type SetupState struct {
+	Mode     string      `json:"mode"`
+	Steps    []SetupStep `json:"steps"`
+	Current  string      `json:"current,omitempty"` // the first step not done
+	Complete bool        `json:"complete"`          // every step is done
+	Finished bool        `json:"finished"`          // the wizard was closed; the dashboard stops offering it
+}
+
+// setupRecord is the content of setupFile.
+type setupRecord struct {
+	VerifiedMode string    `json:"verified_mode,omitempty"`
+	VerifiedAt   time.Time `json:"verified_at,omitempty"`
+	FinishedAt   time.Time `json:"finished_at,omitempty"`
+}
+
+func readSetupRecord() setupRecord {
+	var rec setupRecord
+	if data, err := os.ReadFile(filepath.Join(config.Dir(), setupFile)); err == nil {
+		json.Unmarshal(data, &rec)
+	}
+	return rec
+}
+
+func writeSetupRecord(rec setupRecord) error {
+	data, _ := json.MarshalIndent(rec, "", "  ")
+	if err := os.MkdirAll(config.Dir(), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	return fileutil.WriteFile(filepath.Join(config.Dir(), setupFile), append(data, '\n'), 0644)
+}
+
+// SetupState returns how far the first-run setup has come.
+func (o *Ops) SetupState() SetupState {
+	cfg := o.Config()
+	rec := readSetupRecord()
+	st := SetupState{Mode: cfg.Mode, Finished: !rec.FinishedAt.IsZero()}
+
+	st.Steps = append(st.Steps, SetupStep{Name: SetupMode, Title: "Mode", Done: cfg.Mode != "", Detail: cfg.Mode})
+	switch cfg.Mode {
+	case "server":
+		keys := SetupStep{Name: SetupKeys, Title: "Keys"}
+		if _, err := os.Stat(filepath.Join(config.Dir(), "id_ed25519")); err == nil {
+			keys.Done, keys.Detail = true, filepath.Join(config.Dir(), "id_ed25519.pub")
+		}
+		relay := SetupStep{Name: SetupRelay, Title: "Relay"}
+		if rs := o.GetRelayStatus(); rs.Provisioned {
+			relay.Done, relay.Detail = true, rs.Domain
+		}
+		server := SetupStep{Name: SetupServer, Title: "Server"}
+		if o.ServerStatus().State == StateRunning {
+			server.Done, server.Detail = true, "running"
+		}
+		user := SetupStep{Name: SetupUser, Title: "First user"}
+		if users, _ := o.ListUsers(); len(users) > 0 {
+			user.Done = true
+			user.Detail = fmt.Sprintf("%d user(s), e.g. %s", len(users), users[0].Name)
+		}
+		st.Steps = append(st.Steps, keys, relay, server, user)
+	case "client":
+		bundle := SetupStep{Name: SetupBundle, Title: "Config bundle"}
+		if cfg.Xray.RelayHost != "" && cfg.Xray.UUID != "" {
+			bundle.Done, bundle.Detail = true, cfg.Xray.RelayHost
+		}
+		tunnel := SetupStep{Name: SetupTunnel, Title: "Tunnels"}
+		if o.ClientStatus().State == StateRunning {
+			tunnel.Done = true
+			tunnel.Detail = fmt.Sprintf("%d tunnel(s) up", len(cfg.Client.Tunnels))
+		}
+		st.Steps = append(st.Steps, bundle, tunnel)
+	}
+	if cfg.Mode != "" {
+		verify := SetupStep{Name: SetupVerify, Title: "Verify"}
+		if rec.VerifiedMode == cfg.Mode && !rec.VerifiedAt.IsZero() {
+			verify.Done, verify.Detail = true, "passed "+rec.VerifiedAt.Local().Format("2006-01-02 15:04")
+		}
+		st.Steps = append(st.Steps, verify)
+	}
+
+	for _, step := range st.Steps {
+		if !step.Done {
+			st.Current = step.Name
+			break
+		}
+	}
+	st.Complete = cfg.Mode != "" && st.Current == ""
+	return st
+}
+
+// CompleteSetupStep does the setup steps that have no page of their own:
+// SetupKeys generates the server's keys and "finish" closes the wizard.
+// SetupVerify is VerifySetup; the other steps are done by choosing the
+// mode, provisioning the relay, starting the server, creating a user,
+// uploading the bundle or starting the client.
+func (o *Ops) CompleteSetupStep(step string) error {
+	switch step {
+	case SetupKeys:
+		return o.EnsureKeys()
+	case "finish":
+		rec := readSetupRecord()
+		rec.FinishedAt = time.Now().UTC()
+		return writeSetupRecord(rec)
+	}
+	return fmt.Errorf("setup step %q can't be completed here", step)
+}
+
+// VerifySetup runs the connectivity test for the mode, TestRelay on a
+// server and TestConnection on a client, and records the SetupVerify step
+// as done when every check passes.
+func (o *Ops) VerifySetup(progress ProgressFunc) {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	mode := o.Mode()
+	failed := false
+	// Record the pass before the last event, which ends the stream, so
+	// the state the wizard fetches next already has it.
+	track := func(e ProgressEvent) {
+		switch {
+		case e.Status == "failed":
+			failed = true
+		case e.Status == "completed" && e.Total > 0 && e.Step == e.Total && !failed:
+			rec := readSetupRecord()
+			rec.VerifiedMode, rec.VerifiedAt = mode, time.Now().UTC()
+			if err := writeSetupRecord(rec); err != nil {
+				slog.Warn("could not record setup verification", "error", err)
+			}
+		}
+		progress(e)
+	}
+	switch mode {
+	case "server":
+		o.TestRelay(track)
+	case "client":
+		o.TestConnection(track)
+	default:
+		progress(ProgressEvent{Step: 1, Total: 1, Label: "Verify", Status: "failed", Error: "choose a mode first"})
+	}
+}
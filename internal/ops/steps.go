@@ -0,0 +1,88 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// graphStep is one node of a step graph run by runSteps. It starts once
+// every step named in after has succeeded.
+type graphStep struct {
+	name  string
+	after []string
+	run   func(ctx context.Context) error
+}
+
+// runSteps runs steps as a dependency graph: each step starts as soon as
+// the steps it comes after have succeeded, so independent ones run
+// concurrently. The first failure cancels the context of the steps still
+// running and skips those not yet started; runSteps waits for the running
+// ones and returns that error. The after lists must not form a cycle.
+func runSteps(ctx context.Context, steps []graphStep) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(map[string]chan struct{}, len(steps))
+	for _, s := range steps {
+		if _, ok := done[s.name]; ok {
+			return fmt.Errorf("duplicate step %q", s.name)
+		}
+		done[s.name] = make(chan struct{})
+	}
+	for _, s := range steps {
+		for _, dep := range s.after {
+			if _, ok := done[dep]; !ok {
+				return fmt.Errorf("step %q comes after unknown step %q", s.name, dep)
+			}
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for _, s := range steps {
+		wg.Add(1)
+		go func(s graphStep) {
+			defer wg.Done()
+			for _, dep := range s.after {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.run(ctx); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			close(done[s.name])
+		}(s)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// serialProgress wraps progress so that steps running concurrently don't
+// call it at the same time. Each step still reports its own events in
+// order.
+func serialProgress(progress ProgressFunc) ProgressFunc {
+	var mu sync.Mutex
+	return func(e ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress(e)
+	}
+}
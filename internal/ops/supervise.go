@@ -0,0 +1,181 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// superviseMaxBackoff caps the delay before a crashed component is
+// restarted. A component that ran for superviseResetAfter before failing
+// is restarted after the initial second again.
+const (
+	superviseMaxBackoff = 30 * time.Second
+	superviseResetAfter = time.Minute
+)
+
+// ComponentStatus reports one background component of the server or
+// client: a supervised goroutine, the Xray instance, or the gRPC API.
+type ComponentStatus struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"` // up and, where there is a probe, answering it
+	Restarts int    `json:"restarts,omitempty"`
+
+	// Error says why the probe failed while the component runs, else why
+	// it last stopped; the latter is kept after a restart.
+	Error string `json:"error,omitempty"`
+
+	// StartedAt is when the component last (re)started; Uptime counts
+	// the seconds since, while it is running.
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Uptime    int64      `json:"uptime_seconds,omitempty"`
+}
+
+// component is the live state behind ComponentStatus.
+type component struct {
+	name string
+
+	mu       sync.Mutex
+	running  bool
+	restarts int
+	lastErr  string
+	started  time.Time
+}
+
+// newComponent returns a running component started now.
+func newComponent(name string) *component {
+	return &component{name: name, running: true, started: time.Now()}
+}
+
+// status reports the component; a non-nil probeErr marks it down.
+func (c *component) status(probeErr error) ComponentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := ComponentStatus{Name: c.name, Running: c.running, Restarts: c.restarts, Error: c.lastErr}
+	if c.running && probeErr != nil {
+		s.Running = false
+		s.Error = probeErr.Error()
+	}
+	if c.running {
+		started := c.started
+		s.StartedAt = &started
+		s.Uptime = int64(time.Since(started).Seconds())
+	}
+	return s
+}
+
+// setRunning marks the component up or down, e.g. when the Xray
+// instance stops.
+func (c *component) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+// isRunning reports whether the component's goroutine is up; a nil
+// component, one that was never started, is not.
+func (c *component) isRunning() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// supervise runs run in a goroutine until stop is closed. When run panics,
+// returns an error, or returns at all before stop is closed, the reason is
+// recorded and run is called again after a backoff that doubles from one
+// second up to superviseMaxBackoff. Closing stop must also make run
+// return; supervise does not wait for it.
+func supervise(name string, stop <-chan struct{}, run func() error) *component {
+	c := newComponent(name)
+	go func() {
+		attempt := 0
+		for {
+			started := time.Now()
+			err := runRecovered(name, run)
+			select {
+			case <-stop:
+				c.setRunning(false)
+				return
+			default:
+			}
+			if err == nil {
+				err = errors.New("exited unexpectedly")
+			}
+			if time.Since(started) >= superviseResetAfter {
+				attempt = 0
+			}
+			backoff := superviseBackoff(attempt)
+			attempt++
+
+			c.mu.Lock()
+			c.running = false
+			c.lastErr = err.Error()
+			restarts := c.restarts
+			c.mu.Unlock()
+			slog.Error(name+" stopped, restarting", "error", err, "backoff", backoff, "restarts", restarts)
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			c.mu.Lock()
+			c.running = true
+			c.restarts++
+			c.started = time.Now()
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+// restartable returns run for supervise, calling cleanup before every call
+// but the first, so that a component which crashed releases its listener
+// and connections before it starts again.
+func restartable(cleanup func(), run func() error) func() error {
+	first := true
+	return func() error {
+		if !first {
+			cleanup()
+		}
+		first = false
+		return run()
+	}
+}
+
+// runRecovered calls run, turning a panic into an error. The stack is
+// logged, since the error alone rarely says where it happened.
+func runRecovered(name string, run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error(name+" panicked", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
+
+func superviseBackoff(attempt int) time.Duration {
+	d := time.Second
+	for i := 0; i < attempt && d < superviseMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, superviseMaxBackoff)
+}
+
+// componentsError describes the first component that is down, for the
+// Error of a status that has no lifecycle error of its own.
+func componentsError(comps []ComponentStatus) string {
+	for _, c := range comps {
+		if !c.Running && c.Error != "" {
+			return c.Name + ": " + c.Error
+		}
+	}
+	return ""
+}
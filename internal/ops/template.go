@@ -0,0 +1,184 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// TemplateInfo describes one mapping template and the users created from it.
+type TemplateInfo struct {
+	Name     string        `json:"name"`
+	Ports    []string      `json:"ports"`
+	Mappings []PortMapping `json:"mappings"`
+	Members  []string      `json:"members"`
+}
+
+// TemplateMappings parses a template's port list into mappings.
+func TemplateMappings(t config.MappingTemplate) ([]PortMapping, error) {
+	mappings := make([]PortMapping, 0, len(t.Ports))
+	for _, p := range t.Ports {
+		pm, err := ParsePortMapping(p)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", t.Name, err)
+		}
+		mappings = append(mappings, pm)
+	}
+	return mappings, nil
+}
+
+// ListTemplates returns all mapping templates with their member users.
+func (o *Ops) ListTemplates() ([]TemplateInfo, error) {
+	cfg := o.Config()
+
+	users, err := o.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]TemplateInfo, 0, len(cfg.Server.Templates))
+	for _, t := range cfg.Server.Templates {
+		mappings, err := TemplateMappings(t)
+		if err != nil {
+			return nil, err
+		}
+		info := TemplateInfo{Name: t.Name, Ports: t.Ports, Mappings: mappings, Members: []string{}}
+		for _, u := range users {
+			if u.Template == t.Name {
+				info.Members = append(info.Members, u.Name)
+			}
+		}
+		templates = append(templates, info)
+	}
+	return templates, nil
+}
+
+// SaveTemplate creates or replaces a mapping template. When propagate is
+// true and the template already has members, each member's config.yaml
+// and authorized_keys entry are rewritten with the new mappings; one
+// progress step is emitted per member.
+func (o *Ops) SaveTemplate(ctx context.Context, t config.MappingTemplate, propagate bool, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+
+	t.Name = strings.TrimSpace(t.Name)
+	if err := validateName(t.Name); err != nil {
+		return fmt.Errorf("template name %w", err)
+	}
+	if len(t.Ports) == 0 {
+		return fmt.Errorf("template %q must have at least one port", t.Name)
+	}
+	mappings, err := TemplateMappings(t)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	replaced := false
+	for i := range o.cfg.Server.Templates {
+		if o.cfg.Server.Templates[i].Name == t.Name {
+			o.cfg.Server.Templates[i] = t
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		o.cfg.Server.Templates = append(o.cfg.Server.Templates, t)
+	}
+	if err := config.Save(o.cfg); err != nil {
+		return err
+	}
+
+	if !propagate {
+		return nil
+	}
+
+	members := templateMembers(t.Name)
+	var failed int
+	for i, name := range members {
+		step := i + 1
+		progress(ProgressEvent{Step: step, Total: len(members), Label: name, Status: "running"})
+		if err := rewriteUserMappings(name, mappings); err != nil {
+			failed++
+			progress(ProgressEvent{Step: step, Total: len(members), Label: name, Status: "failed", Error: err.Error()})
+			continue
+		}
+		progress(ProgressEvent{Step: step, Total: len(members), Label: name, Status: "completed", Message: "mappings updated"})
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users could not be updated", failed, len(members))
+	}
+	return nil
+}
+
+// DeleteTemplate removes a mapping template. Member users keep their
+// current mappings but are no longer linked to the template.
+func (o *Ops) DeleteTemplate(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	templates := o.cfg.Server.Templates
+	kept := make([]config.MappingTemplate, 0, len(templates))
+	for _, t := range templates {
+		if t.Name != name {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(templates) {
+		return fmt.Errorf("template %q not found", name)
+	}
+	o.cfg.Server.Templates = kept
+	if err := config.Save(o.cfg); err != nil {
+		return err
+	}
+
+	for _, member := range templateMembers(name) {
+		os.Remove(filepath.Join(config.UsersDir(), member, ".template"))
+	}
+	return nil
+}
+
+// resolveTemplate expands req.Template into port mappings. The template's
+// mappings come first, followed by any explicit mappings in the request.
+func resolveTemplate(cfg *config.Config, req CreateUserRequest) (CreateUserRequest, error) {
+	if req.Template == "" {
+		return req, nil
+	}
+	for _, t := range cfg.Server.Templates {
+		if t.Name == req.Template {
+			mappings, err := TemplateMappings(t)
+			if err != nil {
+				return req, err
+			}
+			req.Mappings = append(mappings, req.Mappings...)
+			return req, nil
+		}
+	}
+	return req, fmt.Errorf("template %q not found", req.Template)
+}
+
+// templateMembers returns the names of users created from the template.
+func templateMembers(name string) []string {
+	entries, err := os.ReadDir(config.UsersDir())
+	if err != nil {
+		return nil
+	}
+	var members []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(config.UsersDir(), e.Name(), ".template"))
+		if err == nil && strings.TrimSpace(string(data)) == name {
+			members = append(members, e.Name())
+		}
+	}
+	return members
+}
@@ -1,87 +1,457 @@
 package ops
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/fs"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
 )
 
 // ansiRE strips ANSI escape sequences from terminal output.
 var ansiRE = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
 
-// RunTerraform executes a terraform command in dir with the given env vars.
+// TerraformProgress is the Data of the progress events RunTerraform sends
+// for plan, apply and destroy, which it runs with -json.
+type TerraformProgress struct {
+	Type     string `json:"type"`               // Terraform's message type, e.g. "apply_start"
+	Phase    string `json:"phase"`              // "planning", "applying" or "done"
+	Resource string `json:"resource,omitempty"` // address of the resource the message is about
+	Action   string `json:"action,omitempty"`   // "create", "update", "delete", ...
+	Done     int    `json:"done"`               // resource changes finished
+	Total    int    `json:"total"`              // resource changes planned
+	Percent  int    `json:"percent"`
+}
+
+// tfMessage is one line of Terraform's machine-readable UI output.
+type tfMessage struct {
+	Level   string `json:"@level"`
+	Message string `json:"@message"`
+	Type    string `json:"type"`
+	Hook    struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"hook"`
+	Change struct { // planned_change
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Changes struct {
+		Add       int    `json:"add"`
+		Change    int    `json:"change"`
+		Remove    int    `json:"remove"`
+		Operation string `json:"operation"`
+	} `json:"changes"`
+	Diagnostic struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+		Address  string `json:"address"`
+	} `json:"diagnostic"`
+}
+
+// TerraformError is the error RunTerraform returns when a command fails.
+// Err is terraform-exec's error, which may be one of its typed errors such
+// as *tfexec.ErrVersionMismatch.
+type TerraformError struct {
+	Command     string   // the command as passed to RunTerraform
+	Diagnostics []string // error diagnostics Terraform reported
+	Output      string   // the last lines of output, when there are no diagnostics
+	Err         error
+}
+
+func (e *TerraformError) Error() string {
+	detail := e.Output
+	if len(e.Diagnostics) > 0 {
+		detail = strings.Join(e.Diagnostics, "\n")
+	}
+	if detail == "" {
+		return fmt.Sprintf("terraform %s: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("terraform %s: %v\n%s", e.Command, e.Err, detail)
+}
+
+func (e *TerraformError) Unwrap() error { return e.Err }
+
+// tfVarFile holds the variables RunTerraform is given through env while a
+// command runs; terraform-exec doesn't pass TF_VAR_ environment variables.
+const tfVarFile = ".tw-credentials.tfvars.json"
+
+// RunTerraform runs a terraform command in dir through terraform-exec,
+// downloading Terraform first if there is none (see terraformBinary).
+// Supported commands are init, import <addr> <id>, plan, apply and destroy;
+// -target= flags are passed on, and the other flags terraform-exec sets
+// itself. TF_VAR_<name> entries in env are given to the command in a
+// temporary var file, the rest as environment variables.
+//
 // Output is streamed line-by-line as progress events so the dashboard shows
-// real-time feedback instead of blocking silently.
+// real-time feedback instead of blocking silently. plan, apply and destroy
+// run with -json; their events carry a TerraformProgress, and a
+// *TerraformError the diagnostics Terraform reported.
 func (o *Ops) RunTerraform(ctx context.Context, dir string, env map[string]string, progress ProgressFunc, args ...string) error {
-	cmd := exec.CommandContext(ctx, "terraform", args...)
-	cmd.Dir = dir
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "TF_IN_AUTOMATION=1") // suppress color and interactive prompts
-	for k, v := range env {
-		cmd.Env = append(cmd.Env, k+"="+v)
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	command := strings.Join(args, " ")
+	var positional, targets []string
+	for _, arg := range args[1:] {
+		if t, ok := strings.CutPrefix(arg, "-target="); ok {
+			targets = append(targets, t)
+		} else if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+		}
 	}
 
-	// Pipe stdout+stderr so we can stream to progress.
-	stdout, err := cmd.StdoutPipe()
+	tf, err := newTerraform(ctx, dir, env, progress)
 	if err != nil {
-		return fmt.Errorf("terraform %s: stdout pipe: %w", strings.Join(args, " "), err)
+		return &TerraformError{Command: command, Err: err}
 	}
-	cmd.Stderr = cmd.Stdout // merge stderr into stdout
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("terraform %s: %w", strings.Join(args, " "), err)
+	var varFile string
+	if args[0] != "init" {
+		if varFile, err = writeTfVarFile(dir, env); err != nil {
+			return &TerraformError{Command: command, Err: err}
+		}
+		if varFile != "" {
+			defer os.Remove(varFile)
+		}
 	}
 
-	// Stream output line-by-line, stripping any ANSI escape codes.
-	scanner := bufio.NewScanner(stdout)
-	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
-	var lastLines []string
-	for scanner.Scan() {
-		line := ansiRE.ReplaceAllString(scanner.Text(), "")
+	// Stream output line-by-line, stripping any ANSI escape codes. Lines
+	// that aren't JSON (init and import, or errors before Terraform starts
+	// its UI) pass through. terraform-exec writes stdout and stderr from
+	// separate goroutines.
+	var (
+		mu                     sync.Mutex
+		lastLines, diagnostics []string
+	)
+	state := TerraformProgress{Phase: "planning"}
+	handle := func(line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		line = ansiRE.ReplaceAllString(line, "")
+		event := ProgressEvent{Label: "terraform " + args[0], Status: "running"}
+		var msg tfMessage
+		if json.Unmarshal([]byte(line), &msg) == nil && msg.Type != "" {
+			if msg.Type == "diagnostic" && msg.Diagnostic.Severity == "error" {
+				diagnostics = append(diagnostics, formatTfDiagnostic(msg))
+			}
+			line = state.update(msg)
+			event.Data = state
+		}
 		lastLines = append(lastLines, line)
 		// Keep only last 50 lines for error context.
 		if len(lastLines) > 50 {
 			lastLines = lastLines[len(lastLines)-50:]
 		}
-		if progress != nil {
-			progress(ProgressEvent{
-				Label:   "terraform " + args[0],
-				Status:  "running",
-				Message: line,
-			})
+		event.Message = line
+		progress(event)
+	}
+	stdout, stderr := &lineWriter{fn: handle}, &lineWriter{fn: handle}
+	tf.SetStderr(stderr)
+
+	switch args[0] {
+	case "init":
+		tf.SetStdout(stdout)
+		err = tf.Init(ctx)
+	case "import":
+		if len(positional) != 2 {
+			return fmt.Errorf("terraform %s: import takes an address and an ID", command)
+		}
+		tf.SetStdout(stdout)
+		var opts []tfexec.ImportOption
+		if varFile != "" {
+			opts = append(opts, tfexec.VarFile(varFile))
+		}
+		err = tf.Import(ctx, positional[0], positional[1], opts...)
+	case "plan":
+		var opts []tfexec.PlanOption
+		for _, t := range targets {
+			opts = append(opts, tfexec.Target(t))
+		}
+		if varFile != "" {
+			opts = append(opts, tfexec.VarFile(varFile))
+		}
+		_, err = tf.PlanJSON(ctx, stdout, opts...)
+	case "apply":
+		var opts []tfexec.ApplyOption
+		for _, t := range targets {
+			opts = append(opts, tfexec.Target(t))
 		}
+		if varFile != "" {
+			opts = append(opts, tfexec.VarFile(varFile))
+		}
+		err = tf.ApplyJSON(ctx, stdout, opts...)
+	case "destroy":
+		var opts []tfexec.DestroyOption
+		for _, t := range targets {
+			opts = append(opts, tfexec.Target(t))
+		}
+		if varFile != "" {
+			opts = append(opts, tfexec.VarFile(varFile))
+		}
+		err = tf.DestroyJSON(ctx, stdout, opts...)
+	default:
+		return fmt.Errorf("terraform %s: unsupported command", command)
 	}
+	stdout.flush()
+	stderr.flush()
 
-	if err := cmd.Wait(); err != nil {
-		tail := strings.Join(lastLines, "\n")
-		return fmt.Errorf("terraform %s: %w\n%s", strings.Join(args, " "), err, tail)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		return &TerraformError{
+			Command:     command,
+			Diagnostics: diagnostics,
+			Output:      strings.Join(lastLines, "\n"),
+			Err:         err,
+		}
 	}
 	return nil
 }
 
-// TerraformOutput reads a single output value from a Terraform state.
-func (o *Ops) TerraformOutput(dir string, env map[string]string, name string) (string, error) {
-	cmd := exec.Command("terraform", "output", "-raw", name)
-	cmd.Dir = dir
-	if len(env) > 0 {
-		cmd.Env = os.Environ()
-		for k, v := range env {
-			cmd.Env = append(cmd.Env, k+"="+v)
+// newTerraform sets up terraform-exec for dir with env, minus the TF_VAR_
+// entries, added to this process's environment.
+func newTerraform(ctx context.Context, dir string, env map[string]string, progress ProgressFunc) (*tfexec.Terraform, error) {
+	execPath, err := terraformBinary(ctx, true, progress)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := tfexec.NewTerraform(dir, execPath)
+	if err != nil {
+		return nil, err
+	}
+	procEnv := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			procEnv[k] = v
+		}
+	}
+	procEnv = tfexec.CleanEnv(procEnv)
+	for k, v := range env {
+		if !strings.HasPrefix(k, "TF_VAR_") {
+			procEnv[k] = v
 		}
 	}
-	out, err := cmd.Output()
+	if err := tf.SetEnv(procEnv); err != nil {
+		return nil, err
+	}
+	// terraform-exec caches the version it checks commands against; read
+	// it now so its output stays out of the command's.
+	if _, _, err := tf.Version(ctx, false); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// writeTfVarFile writes the TF_VAR_ entries of env to an owner-only var
+// file in dir and returns its path, or "" if there are none.
+func writeTfVarFile(dir string, env map[string]string) (string, error) {
+	vars := map[string]string{}
+	for k, v := range env {
+		if name, ok := strings.CutPrefix(k, "TF_VAR_"); ok {
+			vars[name] = v
+		}
+	}
+	if len(vars) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(vars)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(out)), nil
+	path := filepath.Join(dir, tfVarFile)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("writing variables: %w", err)
+	}
+	return path, nil
+}
+
+// lineWriter calls fn with each line written to it.
+type lineWriter struct {
+	fn  func(string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush sends a last line that wasn't terminated.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// update advances p with msg and returns the line to show for it.
+func (p *TerraformProgress) update(msg tfMessage) string {
+	p.Type = msg.Type
+	p.Resource, p.Action = msg.Hook.Resource.Addr, msg.Hook.Action
+	if msg.Type == "planned_change" {
+		p.Resource, p.Action = msg.Change.Resource.Addr, msg.Change.Action
+	}
+	line := msg.Message
+	switch msg.Type {
+	case "change_summary":
+		if msg.Changes.Operation == "plan" {
+			p.Total = msg.Changes.Add + msg.Changes.Change + msg.Changes.Remove
+		} else {
+			p.Phase = "done"
+			p.Done = p.Total
+		}
+	case "apply_start", "apply_progress":
+		p.Phase = "applying"
+	case "apply_complete":
+		p.Phase = "applying"
+		p.Done++
+		if p.Total > 0 {
+			line += fmt.Sprintf(" (%d/%d)", p.Done, p.Total)
+		}
+	case "diagnostic":
+		if msg.Diagnostic.Detail != "" {
+			line += ": " + msg.Diagnostic.Detail
+		}
+	}
+	p.Percent = 0
+	if p.Total > 0 {
+		p.Percent = min(100, p.Done*100/p.Total)
+	}
+	if p.Phase == "done" {
+		p.Percent = 100
+	}
+	return line
+}
+
+// formatTfDiagnostic renders an error diagnostic like Terraform's own
+// output.
+func formatTfDiagnostic(msg tfMessage) string {
+	d := msg.Diagnostic
+	s := "Error: " + d.Summary
+	if d.Address != "" {
+		s += " (" + d.Address + ")"
+	}
+	if d.Detail != "" {
+		s += "\n  " + strings.ReplaceAll(d.Detail, "\n", "\n  ")
+	}
+	return s
+}
+
+// TerraformOutput reads a single output value from a Terraform state. It
+// doesn't download Terraform.
+func (o *Ops) TerraformOutput(dir string, env map[string]string, name string) (string, error) {
+	ctx := context.Background()
+	execPath, err := terraformBinary(ctx, false, nil)
+	if err != nil {
+		return "", err
+	}
+	tf, err := tfexec.NewTerraform(dir, execPath)
+	if err != nil {
+		return "", err
+	}
+	outputs, err := tf.Output(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, ok := outputs[name]
+	if !ok {
+		return "", fmt.Errorf("terraform output %q not found", name)
+	}
+	var value string
+	if err := json.Unmarshal(out.Value, &value); err != nil {
+		return "", fmt.Errorf("terraform output %q: %w", name, err)
+	}
+	return value, nil
+}
+
+// tfMinVersion is the oldest Terraform RunTerraform works with; apply and
+// destroy -json need 0.15.3.
+var tfMinVersion = version.MustConstraints(version.NewConstraint(">= 0.15.3"))
+
+// tfBinary caches the path terraformBinary found.
+var tfBinary struct {
+	sync.Mutex
+	path string
+}
+
+// terraformBinary returns the path of the terraform to run: one on the
+// PATH or in config.ToolsDir() if it is recent enough, else, when download
+// is set, terraform.TerraformVersion downloaded into config.ToolsDir(). The
+// download is checked against HashiCorp's signed checksums.
+func terraformBinary(ctx context.Context, download bool, progress ProgressFunc) (string, error) {
+	tfBinary.Lock()
+	defer tfBinary.Unlock()
+	if tfBinary.path != "" {
+		if _, err := os.Stat(tfBinary.path); err == nil {
+			return tfBinary.path, nil
+		}
+	}
+
+	local := &fs.Version{
+		Product:     product.Terraform,
+		Constraints: tfMinVersion,
+		ExtraPaths:  []string{config.ToolsDir()},
+	}
+	path, err := local.Find(ctx)
+	if err == nil {
+		tfBinary.path = path
+		return path, nil
+	}
+	if !download {
+		return "", fmt.Errorf("terraform %s not found in PATH or %s", tfMinVersion, config.ToolsDir())
+	}
+
+	if progress != nil {
+		progress(ProgressEvent{
+			Label:   "terraform",
+			Status:  "running",
+			Message: fmt.Sprintf("Terraform not found — downloading %s to %s", terraform.TerraformVersion, config.ToolsDir()),
+		})
+	}
+	if err := os.MkdirAll(config.ToolsDir(), 0755); err != nil {
+		return "", fmt.Errorf("creating tools directory: %w", err)
+	}
+	release := &releases.ExactVersion{
+		Product:    product.Terraform,
+		Version:    version.Must(version.NewVersion(terraform.TerraformVersion)),
+		InstallDir: config.ToolsDir(),
+	}
+	path, err = release.Install(ctx)
+	if err != nil {
+		return "", fmt.Errorf("downloading Terraform %s: %w\n  Install it yourself: https://developer.hashicorp.com/terraform/install", terraform.TerraformVersion, err)
+	}
+	tfBinary.path = path
+	return path, nil
 }
 
-// TerraformAvailable returns true if terraform is on the PATH.
+// TerraformAvailable returns true if a usable terraform is on the PATH or
+// was downloaded before. Without one, RunTerraform downloads it.
 func TerraformAvailable() bool {
-	_, err := exec.LookPath("terraform")
+	_, err := terraformBinary(context.Background(), false, nil)
 	return err == nil
 }
@@ -4,19 +4,23 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
 	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
-	twxray "github.com/tunnelwhisperer/tw/internal/xray"
 	proxymanCmd "github.com/xtls/xray-core/app/proxyman/command"
 	statsCmd "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
@@ -30,25 +34,133 @@ import (
 
 // UserInfo describes one user.
 type UserInfo struct {
-	Name    string          `json:"name"`
-	UUID    string          `json:"uuid,omitempty"`
-	Tunnels []config.Tunnel `json:"tunnels,omitempty"`
-	HasKey  bool            `json:"has_key"`
-	Active  bool            `json:"active"`
-	Online  bool            `json:"online"`
-	DirPath string          `json:"-"`
+	Name     string          `json:"name"`
+	UUID     string          `json:"uuid,omitempty"`
+	Tunnels  []config.Tunnel `json:"tunnels,omitempty"`
+	Template string          `json:"template,omitempty"`
+	HasKey   bool            `json:"has_key"`
+	KeyType  string          `json:"key_type,omitempty"` // "ed25519", or "ed25519-sk" on a security key
+	// Fingerprint is the SHA256 fingerprint of their SSH public key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// KeyImported is set when they brought their own key, so tw has only
+	// its public half.
+	KeyImported bool `json:"key_imported,omitempty"`
+	// InviteExpires is set while they have an invitation waiting to be
+	// claimed, and tells when its claim code stops working.
+	InviteExpires *time.Time `json:"invite_expires,omitempty"`
+	Disabled      bool       `json:"disabled"`
+	SFTP          bool       `json:"sftp"`             // may exchange files over SFTP
+	TOTP          bool       `json:"totp"`             // must give a TOTP code after their key
+	Permit        []string   `json:"permit,omitempty"` // extra permitopen patterns
+	Active        bool       `json:"active"`
+	Online        bool       `json:"online"`
+	// LastSeen is when the user last had an SSH session or was online on
+	// the relay (see UserPresence).
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// BundleOutdated is set when the user's config or key changed after
+	// their bundle was last downloaded, so they need a new one.
+	BundleOutdated bool   `json:"bundle_outdated,omitempty"`
+	DirPath        string `json:"-"`
 }
 
-// PortMapping defines one client-port → server-port pair.
+// PortMapping defines one client-port → server-port pair. ServerHost
+// lets the server forward to another host it can reach, acting as a jump
+// point; empty means the server itself (127.0.0.1).
 type PortMapping struct {
-	ClientPort int `json:"client_port"`
-	ServerPort int `json:"server_port"`
+	ClientPort int    `json:"client_port" yaml:"client_port"`
+	ServerHost string `json:"server_host,omitempty" yaml:"server_host,omitempty"`
+	ServerPort int    `json:"server_port" yaml:"server_port"`
+}
+
+// defaultServerHost is where mappings without a ServerHost forward to.
+const defaultServerHost = "127.0.0.1"
+
+// Host returns the host the server forwards the mapping to.
+func (m PortMapping) Host() string {
+	if m.ServerHost == "" {
+		return defaultServerHost
+	}
+	return m.ServerHost
+}
+
+// Dest returns the mapping's destination as host:port, the form of its
+// permitopen option.
+func (m PortMapping) Dest() string {
+	return net.JoinHostPort(m.Host(), strconv.Itoa(m.ServerPort))
+}
+
+// validate checks the mapping's ports and host. The host ends up inside a
+// quoted authorized_keys option, so only host names and IP addresses pass.
+func (m PortMapping) validate() error {
+	if m.ClientPort < 1 || m.ClientPort > 65535 || m.ServerPort < 1 || m.ServerPort > 65535 {
+		return fmt.Errorf("invalid port mapping %d:%d", m.ClientPort, m.ServerPort)
+	}
+	if m.ServerHost != "" && !validDestHost(m.ServerHost) {
+		return fmt.Errorf("invalid server host %q in mapping for client port %d", m.ServerHost, m.ClientPort)
+	}
+	return nil
+}
+
+// validDestHost reports whether host is an IP address or a DNS name.
+func validDestHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // CreateUserRequest holds the parameters for creating a new user.
+// When Template is set, the template's mappings are added to Mappings and
+// the user is recorded as a member of the template.
 type CreateUserRequest struct {
+	Name     string        `json:"name" yaml:"name"`
+	Mappings []PortMapping `json:"mappings" yaml:"mappings"`
+	Template string        `json:"template,omitempty" yaml:"template,omitempty"`
+	// SecurityKey creates the user's SSH key on the FIDO2 security key
+	// plugged into this machine instead of generating it in software.
+	SecurityKey bool `json:"security_key,omitempty" yaml:"security_key,omitempty"`
+	// Permit lists further destinations the user may forward to besides
+	// their mappings' own, as permitopen patterns: HOST:PORT where PORT
+	// may be *, and HOST a name, an address, a glob such as *.db.internal,
+	// or a CIDR block such as 10.0.0.0/24. They add no client tunnels; the
+	// user forwards to them with tunnels of their own or plain ssh -L.
+	Permit []string `json:"permit,omitempty" yaml:"permit,omitempty"`
+	// TOTP makes the user give a code from an authenticator app after
+	// their key, enrolled with the QR code of UserTOTP.
+	TOTP bool `json:"totp,omitempty" yaml:"totp,omitempty"`
+	// PublicKey is the user's own SSH public key, as an authorized_keys
+	// line, to register instead of generating a key pair. Their bundle
+	// then holds no private key.
+	PublicKey string `json:"public_key,omitempty" yaml:"public_key,omitempty"`
+	// Invite creates the user without a key, with an invitation: they
+	// claim it with the claim code of InviteCode, making their key on
+	// their own machine.
+	Invite bool `json:"invite,omitempty" yaml:"invite,omitempty"`
+}
+
+// UpdateUserRequest holds the changes to apply to an existing user. An
+// empty NewName keeps the current name; nil Mappings keep the current
+// tunnels.
+type UpdateUserRequest struct {
 	Name     string        `json:"name"`
-	Mappings []PortMapping `json:"mappings"`
+	NewName  string        `json:"new_name,omitempty"`
+	Mappings []PortMapping `json:"mappings,omitempty"`
+	// Permit replaces the user's extra permitopen patterns when not nil;
+	// an empty list removes them.
+	Permit []string `json:"permit"`
 }
 
 // ListUsers returns all users found in the users directory.
@@ -85,12 +197,46 @@ func (o *Ops) ListUsers() ([]UserInfo, error) {
 			}
 		}
 
+		ui.Permit = userPermits(ui.DirPath)
+		if data, err := os.ReadFile(filepath.Join(ui.DirPath, ".template")); err == nil {
+			ui.Template = strings.TrimSpace(string(data))
+		}
 		if _, err := os.Stat(filepath.Join(ui.DirPath, "id_ed25519")); err == nil {
 			ui.HasKey = true
+			ui.KeyType = "ed25519"
+		} else if _, err := os.Stat(filepath.Join(ui.DirPath, securityKeyFile)); err == nil {
+			ui.HasKey = true
+			ui.KeyType = "ed25519-sk"
+		}
+		if pub, err := os.ReadFile(filepath.Join(ui.DirPath, "id_ed25519.pub")); err == nil {
+			ui.Fingerprint = keyFingerprint(pub)
+			if !ui.HasKey {
+				ui.HasKey = true
+				ui.KeyType = publicKeyType(pub)
+				ui.KeyImported = true
+			}
+		}
+		if rec, ok := readInvite(ui.DirPath); ok {
+			ui.InviteExpires = &rec.Expires
 		}
 		if _, err := os.Stat(filepath.Join(ui.DirPath, ".applied")); err == nil {
 			ui.Active = true
 		}
+		if _, err := os.Stat(filepath.Join(ui.DirPath, ".disabled")); err == nil {
+			ui.Disabled = true
+		}
+		if _, err := os.Stat(filepath.Join(ui.DirPath, sftpMarker)); err == nil {
+			ui.SFTP = true
+		}
+		if _, err := os.Stat(filepath.Join(ui.DirPath, totpFile)); err == nil {
+			ui.TOTP = true
+		}
+		if r := readPresence(ui.Name); !r.LastSeen.IsZero() {
+			ui.LastSeen = &r.LastSeen
+		}
+		if issued, err := os.ReadFile(filepath.Join(ui.DirPath, bundleMarker)); err == nil {
+			ui.BundleOutdated = strings.TrimSpace(string(issued)) != bundleHash(ui.DirPath)
+		}
 
 		users = append(users, ui)
 	}
@@ -109,18 +255,340 @@ func (o *Ops) CreateUser(ctx context.Context, req CreateUserRequest, progress Pr
 
 	cfg := o.cfg
 
-	// Validate.
+	req, err := resolveTemplate(cfg, req)
+	if err != nil {
+		return err
+	}
+	if err := validateCreateUser(cfg, req); err != nil {
+		return err
+	}
+
+	// Post-user-create hooks add a fifth step when there are any.
+	hooks := hookScripts(HookPostUserCreate)
+	total := 4
+	if len(hooks) > 0 {
+		total++
+	}
+
+	// Step 1: Generate credentials.
+	msg := ""
+	if req.SecurityKey {
+		msg = "Touch the security key when it blinks"
+	}
+	if req.PublicKey != "" {
+		msg = "Using the user's own public key"
+	}
+	if req.Invite {
+		msg = "Inviting the user to make their own key"
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Generating credentials", Status: "running", Message: msg})
+	creds, err := newUserCredentials(req)
+	if err != nil {
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Generating credentials", Status: "failed", Error: err.Error()})
+		return err
+	}
+	if err := checkKeyUnused(creds.pubKey); err != nil {
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Generating credentials", Status: "failed", Error: err.Error()})
+		return err
+	}
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Generating credentials", Status: "completed", Message: "UUID: " + creds.uuid})
+
+	jid := journalBegin(JournalEntry{Op: journalUserCreate, Target: req.Name, UUID: creds.uuid, PubKey: string(creds.pubKey)})
+	defer journalEnd(jid)
+
+	// Step 2: Update relay.
+	progress(ProgressEvent{Step: 2, Total: total, Label: "Updating relay", Status: "running"})
+	if err := addUUIDToRelay(cfg, creds.uuid); err != nil {
+		slog.Warn("relay update failed", "error", err)
+		progress(ProgressEvent{Step: 2, Total: total, Label: "Updating relay", Status: "completed", Message: "Warning: " + err.Error()})
+	} else {
+		journalStep(jid, stepRelay)
+		progress(ProgressEvent{Step: 2, Total: total, Label: "Updating relay", Status: "completed", Message: "UUID added to relay"})
+	}
+
+	// Step 3: Save user files.
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Saving configuration", Status: "running"})
+	if err := writeUserFiles(cfg, req, creds); err != nil {
+		progress(ProgressEvent{Step: 3, Total: total, Label: "Saving configuration", Status: "failed", Error: err.Error()})
+		return err
+	}
+	journalStep(jid, stepFiles)
+	progress(ProgressEvent{Step: 3, Total: total, Label: "Saving configuration", Status: "completed"})
+
+	// Step 4: Update authorized_keys. An invited user's key is added when
+	// they claim the invitation.
+	progress(ProgressEvent{Step: 4, Total: total, Label: "Updating authorized_keys", Status: "running"})
+	if req.Invite {
+		progress(ProgressEvent{Step: 4, Total: total, Label: "Updating authorized_keys", Status: "completed", Message: "Their key is added when they claim the invitation"})
+	} else if err := appendAuthorizedKey(creds.pubKey, req.Name, permitOpens(req.Mappings, req.Permit)); err != nil {
+		progress(ProgressEvent{Step: 4, Total: total, Label: "Updating authorized_keys", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("updating authorized_keys: %w", err)
+	} else {
+		journalStep(jid, stepKeys)
+		if req.PublicKey != "" {
+			unrevokeCredential(creds.pubKey)
+		}
+		progress(ProgressEvent{Step: 4, Total: total, Label: "Updating authorized_keys", Status: "completed"})
+	}
+
+	// Mark user as applied to the current relay.
+	_ = os.WriteFile(filepath.Join(config.UsersDir(), req.Name, ".applied"), nil, 0644)
+
+	// Step 5: Post-user-create hooks. The user exists by now, so a failing
+	// hook is only a warning.
+	if len(hooks) > 0 {
+		progress(ProgressEvent{Step: 5, Total: total, Label: "Post-user-create hooks", Status: "running"})
+		if err := runLocalHooks(ctx, HookPostUserCreate, hooks, userHookVars(cfg, req.Name, creds.uuid), progress); err != nil {
+			slog.Warn("post-user-create hook failed", "user", req.Name, "error", err)
+			progress(ProgressEvent{Step: 5, Total: total, Label: "Post-user-create hooks", Status: "completed", Message: "Warning: " + err.Error()})
+		} else {
+			progress(ProgressEvent{Step: 5, Total: total, Label: "Post-user-create hooks", Status: "completed", Message: hookSummary(hooks)})
+		}
+	}
+
+	return nil
+}
+
+// userHookVars are the environment variables describing a new user for
+// post-user-create hooks.
+func userHookVars(cfg *config.Config, name, uuid string) map[string]string {
+	return map[string]string{
+		"TW_USER":         name,
+		"TW_USER_UUID":    uuid,
+		"TW_USER_DIR":     filepath.Join(config.UsersDir(), name),
+		"TW_RELAY_DOMAIN": cfg.Xray.RelayHost,
+	}
+}
+
+// CreateUsers creates many users in one batch. All requests are validated
+// up front, credentials are generated locally, and every new UUID is
+// registered on the relay over a single SSH session. Step 1 covers the
+// relay update; each user then gets its own progress step.
+func (o *Ops) CreateUsers(ctx context.Context, reqs []CreateUserRequest, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+
+	cfg := o.cfg
+
+	if len(reqs) == 0 {
+		return fmt.Errorf("no users to create")
+	}
+	reqs = append([]CreateUserRequest(nil), reqs...)
+	seen := make(map[string]bool, len(reqs))
+	for i, req := range reqs {
+		req, err := resolveTemplate(cfg, req)
+		if err != nil {
+			return fmt.Errorf("user %q: %w", req.Name, err)
+		}
+		reqs[i] = req
+		if err := validateCreateUser(cfg, req); err != nil {
+			if req.Name != "" {
+				return fmt.Errorf("user %q: %w", req.Name, err)
+			}
+			return err
+		}
+		if seen[req.Name] {
+			return fmt.Errorf("user %q is listed more than once", req.Name)
+		}
+		seen[req.Name] = true
+	}
+
+	creds := make([]userCredentials, len(reqs))
+	uuids := make([]string, len(reqs))
+	keyUsers := make(map[string]string, len(reqs)) // fingerprint -> user
+	for i, req := range reqs {
+		c, err := newUserCredentials(req)
+		if err != nil {
+			return fmt.Errorf("user %q: %w", req.Name, err)
+		}
+		if c.pubKey != nil {
+			if err := checkKeyUnused(c.pubKey); err != nil {
+				return fmt.Errorf("user %q: %w", req.Name, err)
+			}
+			fp := keyFingerprint(c.pubKey)
+			if other, ok := keyUsers[fp]; ok {
+				return fmt.Errorf("users %q and %q have the same key %s; each user needs their own", other, req.Name, fp)
+			}
+			keyUsers[fp] = req.Name
+		}
+		creds[i] = c
+		uuids[i] = c.uuid
+	}
+
+	total := len(reqs) + 1
+
+	// A user whose rollback can't reach the relay keeps their journal
+	// entry, so recovery removes their UUID on the next start.
+	jids := make([]int64, len(reqs))
+	keep := make([]bool, len(reqs))
+	for i, req := range reqs {
+		jids[i] = journalBegin(JournalEntry{Op: journalUserCreate, Target: req.Name, UUID: creds[i].uuid, PubKey: string(creds[i].pubKey)})
+	}
+	defer func() {
+		for i, jid := range jids {
+			if !keep[i] {
+				journalEnd(jid)
+			}
+		}
+	}()
+
+	// Step 1: Register all UUIDs on the relay.
+	relayOK := true
+	progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "running"})
+	if err := addMultipleUUIDsToRelay(cfg, uuids); err != nil {
+		relayOK = false
+		slog.Warn("relay update failed", "error", err)
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "completed", Message: "Warning: " + err.Error()})
+	} else {
+		for _, jid := range jids {
+			journalStep(jid, stepRelay)
+		}
+		progress(ProgressEvent{Step: 1, Total: total, Label: "Updating relay", Status: "completed",
+			Message: fmt.Sprintf("Registered %d UUIDs", len(uuids))})
+	}
+
+	// Step 2+: Save each user's files and authorized_keys entry, then run
+	// the post-user-create hooks for it.
+	// A user who can't be saved is taken back off the relay, so no UUID is
+	// left registered without a user.
+	hooks := hookScripts(HookPostUserCreate)
+	var failed int
+	fail := func(i, step int, err error) {
+		failed++
+		userDir := filepath.Join(config.UsersDir(), reqs[i].Name)
+		if id := userUUID(userDir); id == "" || id == creds[i].uuid {
+			os.RemoveAll(userDir)
+		}
+		if relayOK {
+			if rerr := removeUUIDFromRelay(cfg, creds[i].uuid); rerr != nil {
+				slog.Warn("could not remove UUID from relay", "user", reqs[i].Name, "error", rerr)
+				keep[i] = true
+				journalUpdate(jids[i], func(e *JournalEntry) bool {
+					e.Done = []string{stepRelay}
+					return true
+				})
+			}
+		}
+		progress(ProgressEvent{Step: step, Total: total, Label: reqs[i].Name, Status: "failed", Error: err.Error()})
+	}
+	for i, req := range reqs {
+		step := i + 2
+		progress(ProgressEvent{Step: step, Total: total, Label: req.Name, Status: "running"})
+
+		if err := writeUserFiles(cfg, req, creds[i]); err != nil {
+			fail(i, step, err)
+			continue
+		}
+		journalStep(jids[i], stepFiles)
+		if !req.Invite {
+			if err := appendAuthorizedKey(creds[i].pubKey, req.Name, permitOpens(req.Mappings, req.Permit)); err != nil {
+				fail(i, step, fmt.Errorf("updating authorized_keys: %w", err))
+				continue
+			}
+			journalStep(jids[i], stepKeys)
+		}
+		if req.PublicKey != "" {
+			unrevokeCredential(creds[i].pubKey)
+		}
+		if relayOK {
+			if err := fileutil.WriteFile(filepath.Join(config.UsersDir(), req.Name, ".applied"), nil, 0644); err != nil {
+				slog.Warn("could not mark user as applied", "user", req.Name, "error", err)
+			}
+		}
+		msg := "UUID: " + creds[i].uuid
+		if req.Invite {
+			msg += ", invited"
+		}
+		if err := runLocalHooks(ctx, HookPostUserCreate, hooks, userHookVars(cfg, req.Name, creds[i].uuid), progress); err != nil {
+			slog.Warn("post-user-create hook failed", "user", req.Name, "error", err)
+			msg += " — Warning: " + err.Error()
+		}
+		progress(ProgressEvent{Step: step, Total: total, Label: req.Name, Status: "completed", Message: msg})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users could not be created", failed, len(reqs))
+	}
+	return nil
+}
+
+// userCredentials holds the freshly generated identity of a new user.
+type userCredentials struct {
+	uuid        string
+	privKey     []byte // with securityKey, the key handle
+	pubKey      []byte
+	securityKey bool
+}
+
+// securityKeyFile is the key handle of a user whose key is on a security
+// key, kept instead of id_ed25519. Its public key is in id_ed25519.pub
+// all the same.
+const securityKeyFile = "id_ed25519_sk"
+
+// newUserCredentials generates a VLESS UUID and an SSH key pair, on a
+// security key when req asks for one. With req.PublicKey it takes that
+// key instead, and there is no private key; with req.Invite there is no
+// key at all until the user claims their invitation.
+func newUserCredentials(req CreateUserRequest) (userCredentials, error) {
+	if req.Invite {
+		return userCredentials{uuid: uuid.New().String()}, nil
+	}
+	if req.PublicKey != "" {
+		pub, err := parseUserPublicKey(req.PublicKey)
+		if err != nil {
+			return userCredentials{}, err
+		}
+		return userCredentials{uuid: uuid.New().String(), pubKey: pub}, nil
+	}
+	if req.SecurityKey {
+		handle, pubAuthorized, err := twssh.GenerateSecurityKeyPair(req.Name + "@tw")
+		if err != nil {
+			return userCredentials{}, fmt.Errorf("creating security key: %w", err)
+		}
+		return userCredentials{uuid: uuid.New().String(), privKey: handle, pubKey: pubAuthorized, securityKey: true}, nil
+	}
+	privPEM, pubAuthorized, err := twssh.GenerateKeyPair()
+	if err != nil {
+		return userCredentials{}, fmt.Errorf("generating SSH key pair: %w", err)
+	}
+	return userCredentials{uuid: uuid.New().String(), privKey: privPEM, pubKey: pubAuthorized}, nil
+}
+
+// validateCreateUser checks a create request against the current config
+// and the users directory.
+func validateCreateUser(cfg *config.Config, req CreateUserRequest) error {
 	if req.Name == "" {
 		return fmt.Errorf("user name is required")
 	}
-	for _, r := range req.Name {
-		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
-			return fmt.Errorf("user name must contain only letters, numbers, dashes, and underscores")
-		}
+	if err := validateName(req.Name); err != nil {
+		return fmt.Errorf("user name %w", err)
 	}
 	if len(req.Mappings) == 0 {
 		return fmt.Errorf("at least one port mapping is required")
 	}
+	for _, m := range req.Mappings {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validatePermits(req.Permit); err != nil {
+		return err
+	}
+	if req.Invite && (req.PublicKey != "" || req.SecurityKey) {
+		return fmt.Errorf("an invited user makes their own key; leave out the public key and security key")
+	}
+	if req.PublicKey != "" {
+		if req.SecurityKey {
+			return fmt.Errorf("a user brings their own public key or gets a security key, not both")
+		}
+		if _, err := parseUserPublicKey(req.PublicKey); err != nil {
+			return err
+		}
+	}
 	if cfg.Xray.RelayHost == "" {
 		return fmt.Errorf("xray.relay_host must be configured before creating users")
 	}
@@ -132,52 +600,98 @@ func (o *Ops) CreateUser(ctx context.Context, req CreateUserRequest, progress Pr
 	if _, err := os.Stat(userDir); err == nil {
 		return fmt.Errorf("user %q already exists", req.Name)
 	}
+	return nil
+}
 
-	// Step 1: Generate credentials.
-	progress(ProgressEvent{Step: 1, Total: 4, Label: "Generating credentials", Status: "running"})
-	clientUUID := uuid.New().String()
-	privPEM, pubAuthorized, err := twssh.GenerateKeyPair()
+// validateName checks that a user or template name is non-empty and
+// safe to use as a directory name.
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("is required")
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
+			return fmt.Errorf("must contain only letters, numbers, dashes, and underscores")
+		}
+	}
+	return nil
+}
+
+// permitOpens returns the permitopen patterns of a user: the
+// destinations of their mappings, then their extra patterns.
+func permitOpens(mappings []PortMapping, permits []string) []string {
+	dests := make([]string, 0, len(mappings)+len(permits))
+	for _, m := range mappings {
+		dests = append(dests, m.Dest())
+	}
+	return append(dests, permits...)
+}
+
+// permitFile holds a user's extra permitopen patterns, one per line.
+const permitFile = ".permit"
+
+// userPermits returns the extra permitopen patterns in a user directory.
+func userPermits(userDir string) []string {
+	data, err := os.ReadFile(filepath.Join(userDir, permitFile))
 	if err != nil {
-		progress(ProgressEvent{Step: 1, Total: 4, Label: "Generating credentials", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("generating SSH key pair: %w", err)
+		return nil
 	}
-	progress(ProgressEvent{Step: 1, Total: 4, Label: "Generating credentials", Status: "completed", Message: "UUID: " + clientUUID})
+	return strings.Fields(string(data))
+}
 
-	// Step 2: Update relay.
-	progress(ProgressEvent{Step: 2, Total: 4, Label: "Updating relay", Status: "running"})
-	if err := addUUIDToRelay(cfg, clientUUID); err != nil {
-		slog.Warn("relay update failed", "error", err)
-		progress(ProgressEvent{Step: 2, Total: 4, Label: "Updating relay", Status: "completed", Message: "Warning: " + err.Error()})
-	} else {
-		progress(ProgressEvent{Step: 2, Total: 4, Label: "Updating relay", Status: "completed", Message: "UUID added to relay"})
+// writeUserPermits saves a user's extra permitopen patterns, removing the
+// file when there are none.
+func writeUserPermits(userDir string, permits []string) error {
+	path := filepath.Join(userDir, permitFile)
+	if len(permits) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
 	}
+	return fileutil.WriteFile(path, []byte(strings.Join(permits, "\n")+"\n"), 0644)
+}
 
-	// Step 3: Save user files.
-	progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "running"})
+// validatePermits checks extra permitopen patterns.
+func validatePermits(permits []string) error {
+	for _, p := range permits {
+		if err := twssh.ValidPermitOpen(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// writeUserFiles creates the user directory with its key pair and client
+// config.yaml.
+func writeUserFiles(cfg *config.Config, req CreateUserRequest, creds userCredentials) error {
+	userDir := filepath.Join(config.UsersDir(), req.Name)
 	if err := os.MkdirAll(userDir, 0700); err != nil {
-		progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "failed", Error: err.Error()})
 		return fmt.Errorf("creating user directory: %w", err)
 	}
 
-	if err := os.WriteFile(filepath.Join(userDir, "id_ed25519"), privPEM, 0600); err != nil {
-		progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("writing client private key: %w", err)
+	keyFile := "id_ed25519"
+	if creds.securityKey {
+		keyFile = securityKeyFile
 	}
-	if err := os.WriteFile(filepath.Join(userDir, "id_ed25519.pub"), pubAuthorized, 0644); err != nil {
-		progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "failed", Error: err.Error()})
-		return fmt.Errorf("writing client public key: %w", err)
+	if creds.privKey != nil {
+		if err := os.WriteFile(filepath.Join(userDir, keyFile), creds.privKey, 0600); err != nil {
+			return fmt.Errorf("writing client private key: %w", err)
+		}
+	}
+	if creds.pubKey != nil {
+		if err := os.WriteFile(filepath.Join(userDir, "id_ed25519.pub"), creds.pubKey, 0644); err != nil {
+			return fmt.Errorf("writing client public key: %w", err)
+		}
 	}
 
 	tunnels := make([]config.Tunnel, len(req.Mappings))
-	serverPorts := make([]int, len(req.Mappings))
 	for i, m := range req.Mappings {
 		tunnels[i] = config.Tunnel{
 			LocalPort:  m.ClientPort,
-			RemoteHost: "127.0.0.1",
+			RemoteHost: m.Host(),
 			RemotePort: m.ServerPort,
 		}
-		serverPorts[i] = m.ServerPort
 	}
 
 	clientCfg := struct {
@@ -185,45 +699,228 @@ func (o *Ops) CreateUser(ctx context.Context, req CreateUserRequest, progress Pr
 		Client config.ClientConfig `yaml:"client"`
 	}{
 		Xray: config.XrayConfig{
-			UUID:      clientUUID,
+			UUID:      creds.uuid,
 			RelayHost: cfg.Xray.RelayHost,
 			RelayPort: cfg.Xray.RelayPort,
 			Path:      cfg.Xray.Path,
+			TLS:       cfg.Xray.TLS,
+			Transport: cfg.Xray.Transport,
 		},
 		Client: config.ClientConfig{
 			SSHUser:       req.Name,
 			ServerSSHPort: cfg.Server.RemotePort,
 			Tunnels:       tunnels,
+			// The key handle only signs through an agent that has it.
+			UseAgent: creds.securityKey,
 		},
 	}
+	stampBundle(&clientCfg.Client)
 
 	cfgData, err := yaml.Marshal(clientCfg)
 	if err != nil {
-		progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "failed", Error: err.Error()})
 		return fmt.Errorf("marshaling client config: %w", err)
 	}
-	if err := os.WriteFile(filepath.Join(userDir, "config.yaml"), cfgData, 0644); err != nil {
-		progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "failed", Error: err.Error()})
+	if err := fileutil.WriteFile(filepath.Join(userDir, "config.yaml"), cfgData, 0600); err != nil {
 		return fmt.Errorf("writing client config: %w", err)
 	}
-	progress(ProgressEvent{Step: 3, Total: 4, Label: "Saving configuration", Status: "completed"})
+	if req.Template != "" {
+		if err := os.WriteFile(filepath.Join(userDir, ".template"), []byte(req.Template+"\n"), 0644); err != nil {
+			return fmt.Errorf("writing template marker: %w", err)
+		}
+	}
+	if err := writeUserPermits(userDir, req.Permit); err != nil {
+		return fmt.Errorf("writing permitted destinations: %w", err)
+	}
+	if req.TOTP {
+		if err := writeUserTOTP(userDir); err != nil {
+			return err
+		}
+	}
+	if req.Invite {
+		if err := writeInvite(userDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteUserMappings replaces an existing user's tunnels in config.yaml and
+// rewrites their authorized_keys entry with matching permitopen options.
+// The SSH user and key comment are set to name, so this also completes a
+// rename. The UUID and key pair are left untouched. When authorized_keys
+// can't be updated, the old config.yaml is put back, so the two still
+// agree.
+func rewriteUserMappings(name string, mappings []PortMapping) error {
+	userDir := filepath.Join(config.UsersDir(), name)
+	cfgPath := filepath.Join(userDir, "config.yaml")
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return fmt.Errorf("reading user config: %w", err)
+	}
 
-	// Step 4: Update authorized_keys.
-	progress(ProgressEvent{Step: 4, Total: 4, Label: "Updating authorized_keys", Status: "running"})
-	if err := appendAuthorizedKey(pubAuthorized, req.Name, serverPorts); err != nil {
-		progress(ProgressEvent{Step: 4, Total: 4, Label: "Updating authorized_keys", Status: "failed", Error: err.Error()})
+	var clientCfg struct {
+		Xray   config.XrayConfig   `yaml:"xray"`
+		Client config.ClientConfig `yaml:"client"`
+	}
+	if err := yaml.Unmarshal(data, &clientCfg); err != nil {
+		return fmt.Errorf("parsing user config: %w", err)
+	}
+
+	tunnels := make([]config.Tunnel, len(mappings))
+	for i, m := range mappings {
+		tunnels[i] = config.Tunnel{
+			LocalPort:  m.ClientPort,
+			RemoteHost: m.Host(),
+			RemotePort: m.ServerPort,
+		}
+	}
+	clientCfg.Client.SSHUser = name
+	clientCfg.Client.Tunnels = tunnels
+	stampBundle(&clientCfg.Client)
+
+	updated, err := yaml.Marshal(clientCfg)
+	if err != nil {
+		return fmt.Errorf("marshaling user config: %w", err)
+	}
+	if err := fileutil.WriteFile(cfgPath, updated, 0600); err != nil {
+		return fmt.Errorf("writing user config: %w", err)
+	}
+
+	pubData, err := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+	if _, invited := readInvite(userDir); invited && os.IsNotExist(err) {
+		return nil // their key gets the new permitopen options when they claim
+	}
+	if err != nil {
+		return fmt.Errorf("reading user public key: %w", err)
+	}
+	_, err = os.Stat(filepath.Join(userDir, ".disabled"))
+	disabled := err == nil
+	// One rewrite, so the entry is never missing in between.
+	err = updateAuthorizedKeys(false, func(data []byte) ([]byte, error) {
+		data = withoutAuthorizedKey(data, pubData)
+		data = withAuthorizedKey(data, pubData, name, permitOpens(mappings, userPermits(userDir)))
+		if disabled {
+			return withAuthorizedKeyDisabled(data, pubData, true)
+		}
+		return data, nil
+	})
+	if err != nil {
+		if rerr := fileutil.WriteFile(cfgPath, data, 0600); rerr != nil {
+			slog.Error("could not restore user config", "user", name, "error", rerr)
+		}
 		return fmt.Errorf("updating authorized_keys: %w", err)
 	}
-	progress(ProgressEvent{Step: 4, Total: 4, Label: "Updating authorized_keys", Status: "completed"})
+	return nil
+}
 
-	// Mark user as applied to the current relay.
-	_ = os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+// UpdateUser renames a user and/or replaces their port mappings. The user's
+// config.yaml and authorized_keys entry are rewritten; the UUID and key pair
+// are kept, so the relay does not need to be touched. Setting explicit
+// mappings detaches the user from their mapping template.
+func (o *Ops) UpdateUser(req UpdateUserRequest) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	userDir := filepath.Join(config.UsersDir(), req.Name)
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return fmt.Errorf("user %q not found", req.Name)
+	}
 
+	newName := strings.TrimSpace(req.NewName)
+	if newName == "" {
+		newName = req.Name
+	}
+	if newName != req.Name {
+		if err := validateName(newName); err != nil {
+			return fmt.Errorf("user name %w", err)
+		}
+		if _, err := os.Stat(filepath.Join(config.UsersDir(), newName)); err == nil {
+			return fmt.Errorf("user %q already exists", newName)
+		}
+	}
+
+	mappings := req.Mappings
+	if mappings == nil {
+		current, err := userMappings(userDir)
+		if err != nil {
+			return err
+		}
+		mappings = current
+	}
+	if len(mappings) == 0 {
+		return fmt.Errorf("at least one port mapping is required")
+	}
+	for _, m := range mappings {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validatePermits(req.Permit); err != nil {
+		return err
+	}
+
+	// The permits go in before the rename, so nothing is left to undo
+	// when they can't be written; a failure after puts the old ones back.
+	oldPermits := userPermits(userDir)
+	if req.Permit != nil {
+		if err := writeUserPermits(userDir, req.Permit); err != nil {
+			return fmt.Errorf("writing permitted destinations: %w", err)
+		}
+	}
+	restorePermits := func() {
+		if req.Permit != nil {
+			writeUserPermits(userDir, oldPermits)
+		}
+	}
+	if newName != req.Name {
+		if err := os.Rename(userDir, filepath.Join(config.UsersDir(), newName)); err != nil {
+			restorePermits()
+			return fmt.Errorf("renaming user directory: %w", err)
+		}
+	}
+
+	if err := rewriteUserMappings(newName, mappings); err != nil {
+		if newName != req.Name {
+			os.Rename(filepath.Join(config.UsersDir(), newName), userDir)
+		}
+		restorePermits()
+		return err
+	}
+
+	if req.Mappings != nil {
+		os.Remove(filepath.Join(config.UsersDir(), newName, ".template"))
+	}
+	if newName != req.Name {
+		updateUserBundleLinks(req.Name, newName)
+	}
 	return nil
 }
 
+// userMappings reads the port mappings from a user's config.yaml.
+func userMappings(userDir string) ([]PortMapping, error) {
+	data, err := os.ReadFile(filepath.Join(userDir, "config.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("reading user config: %w", err)
+	}
+	var clientCfg struct {
+		Client config.ClientConfig `yaml:"client"`
+	}
+	if err := yaml.Unmarshal(data, &clientCfg); err != nil {
+		return nil, fmt.Errorf("parsing user config: %w", err)
+	}
+	mappings := make([]PortMapping, len(clientCfg.Client.Tunnels))
+	for i, t := range clientCfg.Client.Tunnels {
+		mappings[i] = PortMapping{ClientPort: t.LocalPort, ServerPort: t.RemotePort}
+		if t.RemoteHost != defaultServerHost {
+			mappings[i].ServerHost = t.RemoteHost
+		}
+	}
+	return mappings, nil
+}
+
 // DeleteUser removes a user's UUID from the relay, then removes the user
-// directory and their authorized_keys entry.
+// directory and their authorized_keys entry, closes their open connections
+// and records their key as revoked.
 func (o *Ops) DeleteUser(name string) error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
@@ -233,38 +930,170 @@ func (o *Ops) DeleteUser(name string) error {
 		return fmt.Errorf("user %q not found", name)
 	}
 
-	// Read the user's UUID so we can remove it from the relay.
-	cfgPath := filepath.Join(userDir, "config.yaml")
-	if data, err := os.ReadFile(cfgPath); err == nil {
-		var clientCfg struct {
-			Xray config.XrayConfig `yaml:"xray"`
-		}
-		if yaml.Unmarshal(data, &clientCfg) == nil && clientCfg.Xray.UUID != "" {
-			if err := removeUUIDFromRelay(o.cfg, clientCfg.Xray.UUID); err != nil {
-				slog.Warn("could not remove UUID from relay", "user", name, "error", err)
-			}
+	// Read the user's UUID and public key so we can remove them from the
+	// relay and authorized_keys.
+	clientUUID := userUUID(userDir)
+	pubData, _ := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+
+	jid := journalBegin(JournalEntry{Op: journalUserDelete, Target: name, UUID: clientUUID, PubKey: string(pubData)})
+	defer journalEnd(jid)
+
+	if clientUUID != "" {
+		if err := removeUUIDFromRelay(o.cfg, clientUUID); err != nil {
+			slog.Warn("could not remove UUID from relay", "user", name, "error", err)
+		} else {
+			journalStep(jid, stepRelay)
 		}
 	}
 
-	// Read the user's public key so we can remove it from authorized_keys.
-	pubPath := filepath.Join(userDir, "id_ed25519.pub")
-	pubData, _ := os.ReadFile(pubPath)
-
 	// Remove user directory.
 	if err := os.RemoveAll(userDir); err != nil {
 		return fmt.Errorf("removing user directory: %w", err)
 	}
+	journalStep(jid, stepFiles)
 
 	// Remove from authorized_keys.
 	if len(pubData) > 0 {
 		if err := removeAuthorizedKey(pubData); err != nil {
 			slog.Warn("could not remove authorized_keys entry", "user", name, "error", err)
 		}
+		revokeCredential(name, pubData, clientUUID, "deleted")
+	}
+	updateUserBundleLinks(name, "")
+	o.srv.disconnectUser(name)
+
+	return nil
+}
+
+// DisableUser suspends a user without deleting them: their authorized_keys
+// line is commented out, their open connections closed and their UUID
+// removed from the relay. Keys and config are kept so EnableUser can
+// restore access.
+func (o *Ops) DisableUser(ctx context.Context, name string, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return fmt.Errorf("user %q not found", name)
+	}
+	if _, invited := readInvite(userDir); invited {
+		return fmt.Errorf("user %q has not claimed their invitation yet; delete them to withdraw it", name)
+	}
+	pubData, err := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+	if err != nil {
+		return fmt.Errorf("reading user public key: %w", err)
+	}
+	clientUUID := userUUID(userDir)
+
+	jid := journalBegin(JournalEntry{Op: journalUserDisable, Target: name, UUID: clientUUID, PubKey: string(pubData)})
+	defer journalEnd(jid)
+
+	// Step 1: Block SSH first so access is cut even if the relay is unreachable.
+	progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating authorized_keys", Status: "running"})
+	if err := setAuthorizedKeyDisabled(pubData, true); err != nil {
+		progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating authorized_keys", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("updating authorized_keys: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(userDir, ".disabled"), nil, 0644); err != nil {
+		progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating authorized_keys", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("writing disabled marker: %w", err)
+	}
+	revokeCredential(name, pubData, clientUUID, "disabled")
+	msg := "key commented out"
+	if n := o.srv.disconnectUser(name); n > 0 {
+		msg += fmt.Sprintf(", %d connection(s) closed", n)
+	}
+	progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating authorized_keys", Status: "completed", Message: msg})
+
+	// Step 2: Remove the UUID from the relay.
+	progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating relay", Status: "running"})
+	if clientUUID == "" || o.cfg.Xray.RelayHost == "" {
+		progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating relay", Status: "completed", Message: "skipped (no relay)"})
+		return nil
+	}
+	if err := removeUUIDFromRelay(o.cfg, clientUUID); err != nil {
+		slog.Warn("could not remove UUID from relay", "user", name, "error", err)
+		progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating relay", Status: "completed", Message: "Warning: " + err.Error()})
+		return nil
+	}
+	journalStep(jid, stepRelay)
+	os.Remove(filepath.Join(userDir, ".applied"))
+	progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating relay", Status: "completed", Message: "UUID removed from relay"})
+	o.InvalidateOnlineCache()
+	return nil
+}
+
+// EnableUser restores a user suspended by DisableUser: the authorized_keys
+// line is uncommented and the UUID is registered on the relay again.
+func (o *Ops) EnableUser(ctx context.Context, name string, progress ProgressFunc) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return fmt.Errorf("user %q not found", name)
+	}
+	if _, invited := readInvite(userDir); invited {
+		return fmt.Errorf("user %q has not claimed their invitation yet; delete them to withdraw it", name)
+	}
+	pubData, err := os.ReadFile(filepath.Join(userDir, "id_ed25519.pub"))
+	if err != nil {
+		return fmt.Errorf("reading user public key: %w", err)
 	}
+	clientUUID := userUUID(userDir)
 
+	jid := journalBegin(JournalEntry{Op: journalUserEnable, Target: name, UUID: clientUUID, PubKey: string(pubData)})
+	defer journalEnd(jid)
+
+	// Step 1: Register the UUID on the relay.
+	progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating relay", Status: "running"})
+	if clientUUID == "" || o.cfg.Xray.RelayHost == "" {
+		progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating relay", Status: "completed", Message: "skipped (no relay)"})
+	} else if err := addUUIDToRelay(o.cfg, clientUUID); err != nil {
+		slog.Warn("could not add UUID to relay", "user", name, "error", err)
+		progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating relay", Status: "completed", Message: "Warning: " + err.Error()})
+	} else {
+		journalStep(jid, stepRelay)
+		_ = os.WriteFile(filepath.Join(userDir, ".applied"), nil, 0644)
+		progress(ProgressEvent{Step: 1, Total: 2, Label: "Updating relay", Status: "completed", Message: "UUID added to relay"})
+	}
+
+	// Step 2: Restore the authorized_keys line.
+	progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating authorized_keys", Status: "running"})
+	if err := setAuthorizedKeyDisabled(pubData, false); err != nil {
+		progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating authorized_keys", Status: "failed", Error: err.Error()})
+		return fmt.Errorf("updating authorized_keys: %w", err)
+	}
+	os.Remove(filepath.Join(userDir, ".disabled"))
+	unrevokeCredential(pubData)
+	progress(ProgressEvent{Step: 2, Total: 2, Label: "Updating authorized_keys", Status: "completed", Message: "key restored"})
 	return nil
 }
 
+// userUUID returns the UUID from a user's config.yaml, or "" if unreadable.
+func userUUID(userDir string) string {
+	data, err := os.ReadFile(filepath.Join(userDir, "config.yaml"))
+	if err != nil {
+		return ""
+	}
+	var clientCfg struct {
+		Xray config.XrayConfig `yaml:"xray"`
+	}
+	if yaml.Unmarshal(data, &clientCfg) != nil {
+		return ""
+	}
+	return clientCfg.Xray.UUID
+}
+
 // UnregisterUsers removes users from the current relay without deleting
 // them. Their UUIDs are removed from the relay's Xray config and the
 // .applied marker is cleared, but their local config and keys remain.
@@ -403,6 +1232,15 @@ func (o *Ops) ApplyUsers(ctx context.Context, names []string, progress ProgressF
 		}
 	}
 
+	// Disabled users stay off the relay until EnableUser is called.
+	enabled := targets[:0]
+	for _, u := range targets {
+		if !u.Disabled {
+			enabled = append(enabled, u)
+		}
+	}
+	targets = enabled
+
 	if len(targets) == 0 {
 		return fmt.Errorf("no users to apply")
 	}
@@ -445,7 +1283,7 @@ func (o *Ops) ApplyUsers(ctx context.Context, names []string, progress ProgressF
 }
 
 // syncUserConfig updates a user's config.yaml with the current relay
-// settings (domain, port, path, remote SSH port). This ensures downloaded
+// settings (domain, port, path, TLS options, transport, remote SSH port). This ensures downloaded
 // config bundles always match the active relay, even after switching to a
 // new relay with a different domain.
 func syncUserConfig(userDir string, cfg *config.Config) error {
@@ -466,13 +1304,29 @@ func syncUserConfig(userDir string, cfg *config.Config) error {
 	clientCfg.Xray.RelayHost = cfg.Xray.RelayHost
 	clientCfg.Xray.RelayPort = cfg.Xray.RelayPort
 	clientCfg.Xray.Path = cfg.Xray.Path
+	clientCfg.Xray.TLS = cfg.Xray.TLS
+	clientCfg.Xray.Transport = cfg.Xray.Transport
 	clientCfg.Client.ServerSSHPort = cfg.Server.RemotePort
+	stampBundle(&clientCfg.Client)
 
 	updated, err := yaml.Marshal(clientCfg)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(cfgPath, updated, 0644)
+	return fileutil.WriteFile(cfgPath, updated, 0600)
+}
+
+// stampBundle marks a user's client config with the bundle format it is
+// written in and the server's host key, which the client checks config
+// refreshes against. Without a host key the client just can't refresh.
+func stampBundle(c *config.ClientConfig) {
+	c.BundleFormat = config.BundleFormat
+	key, err := twssh.HostPublicKey(config.HostKeyDir())
+	if err != nil {
+		slog.Warn("could not read the SSH host key for the user's bundle; its client won't refresh its config", "error", err)
+		return
+	}
+	c.ServerHostKey = strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
 }
 
 // deactivateAllUsers removes .applied markers from all user directories.
@@ -545,17 +1399,36 @@ func addMultipleUUIDsToRelay(cfg *config.Config, uuids []string) error {
 	})
 }
 
-// GetUserConfigBundle returns the user's config files as a zip archive.
+// bundleMarker records, in a user's directory, the bundleHash of the
+// bundle last issued to them.
+const bundleMarker = ".bundle"
+
+// bundleHash identifies what a user's bundle holds: their config.yaml and
+// public key.
+func bundleHash(userDir string) string {
+	h := sha256.New()
+	for _, f := range []string{"config.yaml", "id_ed25519.pub"} {
+		data, _ := os.ReadFile(filepath.Join(userDir, f))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetUserConfigBundle returns the user's config files as a zip archive,
+// and records that they have it, for UserInfo.BundleOutdated.
 func (o *Ops) GetUserConfigBundle(name string) ([]byte, error) {
 	userDir := filepath.Join(config.UsersDir(), name)
 	if _, err := os.Stat(userDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("user %q not found", name)
 	}
+	if _, ok := readInvite(userDir); ok {
+		return nil, fmt.Errorf("user %q has not claimed their invitation yet; their bundle is made when they do", name)
+	}
 
 	var buf bytes.Buffer
 	zw := zip.NewWriter(&buf)
 
-	files := []string{"config.yaml", "id_ed25519", "id_ed25519.pub"}
+	files := []string{"config.yaml", "id_ed25519", securityKeyFile, "id_ed25519.pub"}
 	for _, f := range files {
 		data, err := os.ReadFile(filepath.Join(userDir, f))
 		if err != nil {
@@ -570,44 +1443,163 @@ func (o *Ops) GetUserConfigBundle(name string) ([]byte, error) {
 		}
 	}
 
+	readme := ""
+	if _, err := os.Stat(filepath.Join(userDir, securityKeyFile)); err == nil {
+		readme = securityKeyReadme
+	} else if _, err := os.Stat(filepath.Join(userDir, "id_ed25519")); os.IsNotExist(err) {
+		readme = importedKeyReadme
+	}
+	if readme != "" {
+		w, err := zw.Create("README.txt")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, readme); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := zw.Close(); err != nil {
 		return nil, err
 	}
+	if err := os.WriteFile(filepath.Join(userDir, bundleMarker), []byte(bundleHash(userDir)+"\n"), 0644); err != nil {
+		slog.Warn("could not record the issued bundle", "user", name, "error", err)
+	}
 	return buf.Bytes(), nil
 }
 
-// appendAuthorizedKey adds a public key to the server's authorized_keys
-// with permitopen restrictions.
-func appendAuthorizedKey(pubKey []byte, comment string, ports []int) error {
-	akPath := config.AuthorizedKeysPath()
+// securityKeyReadme goes into the bundle of a user whose key is on a
+// security key.
+const securityKeyReadme = `This bundle's SSH key is on a FIDO2 security key.
 
-	var options []string
-	for _, port := range ports {
-		options = append(options, fmt.Sprintf(`permitopen="127.0.0.1:%d"`, port))
+id_ed25519_sk is only a handle to it: it signs nothing without the
+security key plugged in. tw connect signs through the SSH agent
+(client.use_agent in config.yaml), so add the key to the agent first,
+touching the security key when it blinks:
+
+    ssh-add id_ed25519_sk
+
+This needs OpenSSH 8.2 or later. On Windows, use the OpenSSH agent
+service.
+`
+
+// updateAuthorizedKeys rewrites authorized_keys with fn under the config
+// lock, replacing the file atomically. fn gets the current content, nil
+// when the file does not exist; with mustExist that is an error instead.
+func updateAuthorizedKeys(mustExist bool, fn func(data []byte) ([]byte, error)) error {
+	unlock, err := config.Lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	keyLine := strings.TrimSpace(string(pubKey))
-	line := fmt.Sprintf("%s %s %s@tw\n", strings.Join(options, ","), keyLine, comment)
+	akPath := config.AuthorizedKeysPath()
+	data, err := os.ReadFile(akPath)
+	if err != nil && (mustExist || !os.IsNotExist(err)) {
+		return err
+	}
+	updated, err := fn(data)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(akPath, updated, 0600)
+}
+
+// appendAuthorizedKey adds a public key to the server's authorized_keys
+// with permitopen restrictions to the given host:port destinations.
+func appendAuthorizedKey(pubKey []byte, comment string, dests []string) error {
+	return updateAuthorizedKeys(false, func(data []byte) ([]byte, error) {
+		if err := keyUnusedIn(data, pubKey); err != nil {
+			return nil, err
+		}
+		return withAuthorizedKey(data, pubKey, comment, dests), nil
+	})
+}
 
-	existing, err := os.ReadFile(akPath)
+// checkKeyUnused returns an error when pubKey is already in
+// authorized_keys. Two users sharing a key couldn't be told apart, and
+// revoking one would lock out the other.
+func checkKeyUnused(pubKey []byte) error {
+	data, err := os.ReadFile(config.AuthorizedKeysPath())
 	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("reading authorized_keys: %w", err)
+		return err
 	}
-	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
-		existing = append(existing, '\n')
+	return keyUnusedIn(data, pubKey)
+}
+
+// keyUnusedIn is checkKeyUnused for authorized_keys content data.
+func keyUnusedIn(data, pubKey []byte) error {
+	owner, ok := authorizedKeyOwner(data, pubKey)
+	if !ok {
+		return nil
+	}
+	fp := keyFingerprint(pubKey)
+	if owner == "" {
+		return fmt.Errorf("key %s is already in authorized_keys; each user needs their own key", fp)
 	}
+	return fmt.Errorf("key %s is already in authorized_keys, for %q; each user needs their own key", fp, strings.TrimSuffix(owner, "@tw"))
+}
 
-	return os.WriteFile(akPath, append(existing, []byte(line)...), 0600)
+// authorizedKeyOwner returns the comment of the authorized_keys line in
+// data holding pubKey, disabled or not, and whether there is one. Keys are
+// compared by fingerprint, so options and comments don't matter.
+func authorizedKeyOwner(data, pubKey []byte) (string, bool) {
+	fp := keyFingerprint(pubKey)
+	if fp == "" {
+		return "", false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), disabledKeyPrefix)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pub, comment, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if gossh.FingerprintSHA256(pub) == fp {
+			return comment, true
+		}
+	}
+	return "", false
 }
 
 // removeAuthorizedKey removes lines containing the given public key.
 func removeAuthorizedKey(pubKey []byte) error {
-	akPath := config.AuthorizedKeysPath()
-	data, err := os.ReadFile(akPath)
-	if err != nil {
-		return err
+	return updateAuthorizedKeys(true, func(data []byte) ([]byte, error) {
+		return withoutAuthorizedKey(data, pubKey), nil
+	})
+}
+
+// setAuthorizedKeyDisabled comments out (or restores) the authorized_keys
+// lines containing the given public key.
+func setAuthorizedKeyDisabled(pubKey []byte, disabled bool) error {
+	return updateAuthorizedKeys(true, func(data []byte) ([]byte, error) {
+		return withAuthorizedKeyDisabled(data, pubKey, disabled)
+	})
+}
+
+// withAuthorizedKey returns authorized_keys content with a line added for
+// pubKey, restricted to dests with permitopen options.
+func withAuthorizedKey(data, pubKey []byte, comment string, dests []string) []byte {
+	var options []string
+	for _, dest := range dests {
+		options = append(options, fmt.Sprintf(`permitopen="%s"`, dest))
 	}
 
+	keyLine := strings.TrimSpace(string(pubKey))
+	line := fmt.Sprintf("%s %s %s@tw\n", strings.Join(options, ","), keyLine, comment)
+
+	existing := append([]byte(nil), data...)
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		existing = append(existing, '\n')
+	}
+	return append(existing, []byte(line)...)
+}
+
+// withoutAuthorizedKey returns authorized_keys content without the lines
+// containing pubKey.
+func withoutAuthorizedKey(data, pubKey []byte) []byte {
 	keyStr := strings.TrimSpace(string(pubKey))
 	// The key content (ssh-ed25519 AAAA...) may be wrapped with options;
 	// match on the base64 portion.
@@ -634,56 +1626,52 @@ func removeAuthorizedKey(pubKey []byte) error {
 	if len(kept) > 0 {
 		result += "\n"
 	}
-	return os.WriteFile(akPath, []byte(result), 0600)
+	return []byte(result)
 }
 
-// withRelaySSH opens a temporary Xray tunnel to the relay, establishes an
-// SSH connection, and passes it to fn. The tunnel and connection are torn
-// down automatically when fn returns.
-func withRelaySSH(cfg *config.Config, fn func(client *gossh.Client) error) error {
-	xrayInstance, err := twxray.New(cfg.Xray)
-	if err != nil {
-		return fmt.Errorf("initializing Xray: %w", err)
-	}
-	const tempPort = 59000
-	if err := xrayInstance.Start(tempPort, cfg.Server.RelaySSHPort, cfg.Proxy); err != nil {
-		return fmt.Errorf("starting Xray: %w", err)
-	}
-	defer xrayInstance.Close()
+// disabledKeyPrefix marks an authorized_keys line suspended by DisableUser.
+// The SSH server skips comment lines, so the key stops authenticating.
+const disabledKeyPrefix = "# disabled: "
 
-	privPath := filepath.Join(config.Dir(), "id_ed25519")
-	keyData, err := os.ReadFile(privPath)
-	if err != nil {
-		return fmt.Errorf("reading server key: %w", err)
-	}
-	signer, err := gossh.ParsePrivateKey(keyData)
-	if err != nil {
-		return fmt.Errorf("parsing server key: %w", err)
+// withAuthorizedKeyDisabled returns authorized_keys content with the lines
+// containing pubKey commented out, or restored.
+func withAuthorizedKeyDisabled(data, pubKey []byte, disabled bool) ([]byte, error) {
+	parts := strings.Fields(strings.TrimSpace(string(pubKey)))
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid public key")
 	}
+	matchStr := parts[1] // the base64 key data
 
-	sshCfg := &gossh.ClientConfig{
-		User:            cfg.Server.RelaySSHUser,
-		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
-		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-		Timeout:         15 * time.Second,
-	}
-
-	xrayAddr := fmt.Sprintf("127.0.0.1:%d", tempPort+1)
-
-	var client *gossh.Client
-	for i := 0; i < 15; i++ {
-		client, err = gossh.Dial("tcp", xrayAddr, sshCfg)
-		if err == nil {
-			break
+	found := false
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, matchStr) {
+			continue
+		}
+		found = true
+		if disabled && !strings.HasPrefix(line, disabledKeyPrefix) {
+			lines[i] = disabledKeyPrefix + line
+		} else if !disabled {
+			lines[i] = strings.TrimPrefix(line, disabledKeyPrefix)
 		}
-		time.Sleep(time.Second)
 	}
-	if err != nil {
-		return fmt.Errorf("SSH to relay: %w", err)
+	if !found {
+		return nil, fmt.Errorf("key not found in authorized_keys")
 	}
-	defer client.Close()
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
 
-	return fn(client)
+// withRelaySSH passes fn an SSH connection to the relay from the
+// management pool (see relayPool), opening one through the server's tunnel
+// or a temporary Xray instance when needed. The connection is kept for the
+// next operation; fn must not close it.
+func withRelaySSH(cfg *config.Config, fn func(client *gossh.Client) error) error {
+	conn, err := relayMgmt.acquire(cfg)
+	if err != nil {
+		return err
+	}
+	defer relayMgmt.release(conn)
+	return fn(conn.client)
 }
 
 // readRelayXrayConfig reads and parses the Xray config from the relay.
@@ -1042,44 +2030,52 @@ func ensureRelayStats(client *gossh.Client) bool {
 // This is much faster than withRelaySSH since it doesn't create a
 // temporary Xray instance.
 func (o *Ops) sshThroughServerTunnel(cfg *config.Config, fn func(*gossh.Client) error) error {
-	xrayAddr := fmt.Sprintf("127.0.0.1:%d", cfg.Server.SSHPort+1)
-
-	privPath := filepath.Join(config.Dir(), "id_ed25519")
-	keyData, err := os.ReadFile(privPath)
+	client, err := dialServerTunnel(cfg)
 	if err != nil {
-		return fmt.Errorf("reading server key: %w", err)
+		return err
 	}
-	signer, err := gossh.ParsePrivateKey(keyData)
+	defer client.Close()
+	return fn(client)
+}
+
+// dialServerTunnel opens an SSH connection to the relay through the
+// server's running Xray tunnel.
+func dialServerTunnel(cfg *config.Config) (*gossh.Client, error) {
+	xrayAddr := fmt.Sprintf("127.0.0.1:%d", cfg.Server.SSHPort+1)
+
+	auth, agentConn, err := relayAuth(cfg)
 	if err != nil {
-		return fmt.Errorf("parsing server key: %w", err)
+		return nil, err
 	}
+	defer agentConn.Close()
 
 	sshCfg := &gossh.ClientConfig{
 		User:            cfg.Server.RelaySSHUser,
-		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		Auth:            []gossh.AuthMethod{auth},
 		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
 		Timeout:         10 * time.Second,
 	}
 
 	client, err := gossh.Dial("tcp", xrayAddr, sshCfg)
 	if err != nil {
-		return fmt.Errorf("SSH to relay via server tunnel: %w", err)
+		return nil, fmt.Errorf("SSH to relay via server tunnel: %w", err)
 	}
-	defer client.Close()
-	return fn(client)
+	return client, nil
 }
 
-// InvalidateOnlineCache clears the online status cache so the next
-// GetOnlineUsers call triggers a fresh query to the relay.
+// InvalidateOnlineCache asks the online poller to query the relay now
+// rather than at its next interval, e.g. when a client connects.
 func (o *Ops) InvalidateOnlineCache() {
-	o.onlineMu.Lock()
-	o.onlinePoll = time.Time{}
-	o.onlineMu.Unlock()
+	select {
+	case o.onlineKick <- struct{}{}:
+	default:
+	}
 }
 
-// GetOnlineUsers returns a cached map of UUID → online status.
-// The cache is refreshed via the server's running Xray tunnel when stale.
-// Returns nil if no relay is configured or the server tunnel isn't running.
+// GetOnlineUsers returns a copy of the map of UUID → online status kept
+// by the online poller (see runOnlinePoller); it never waits for the
+// relay. Returns nil if no relay is configured or the server tunnel isn't
+// running.
 func (o *Ops) GetOnlineUsers() map[string]bool {
 	cfg := o.Config()
 	if cfg.Xray.RelayHost == "" {
@@ -1091,107 +2087,63 @@ func (o *Ops) GetOnlineUsers() map[string]bool {
 		return nil
 	}
 
-	// Return cache if fresh (< 20 seconds).
 	o.onlineMu.RLock()
-	if o.onlineCache != nil && time.Since(o.onlinePoll) < 20*time.Second {
-		cache := make(map[string]bool, len(o.onlineCache))
-		for k, v := range o.onlineCache {
-			cache[k] = v
-		}
-		o.onlineMu.RUnlock()
-		return cache
+	defer o.onlineMu.RUnlock()
+	cache := make(map[string]bool, len(o.onlineCache))
+	for k, v := range o.onlineCache {
+		cache[k] = v
 	}
-	o.onlineMu.RUnlock()
-
-	return o.refreshOnlineStatus(cfg)
+	return cache
 }
 
-// refreshOnlineStatus queries the relay's StatsService for online users
-// via the server's existing Xray tunnel and updates the cache.
-func (o *Ops) refreshOnlineStatus(cfg *config.Config) map[string]bool {
-	// Prevent concurrent refreshes — return stale cache instead.
-	if !o.onlineRefresh.TryLock() {
-		o.onlineMu.RLock()
-		defer o.onlineMu.RUnlock()
-		if o.onlineCache == nil {
-			return make(map[string]bool)
-		}
-		cache := make(map[string]bool, len(o.onlineCache))
-		for k, v := range o.onlineCache {
-			cache[k] = v
-		}
-		return cache
-	}
-	defer o.onlineRefresh.Unlock()
-
+// queryOnlineUsers asks the relay's StatsService which users are online.
+func (o *Ops) queryOnlineUsers(cfg *config.Config, sc statsCmd.StatsServiceClient) (map[string]bool, error) {
 	result := make(map[string]bool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	err := o.sshThroughServerTunnel(cfg, func(client *gossh.Client) error {
-		conn, err := dialRelayGRPC(client)
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
-
-		sc := statsCmd.NewStatsServiceClient(conn)
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		// Try dedicated online stats first (Xray statsUserOnline).
-		resp, err := sc.QueryStats(ctx, &statsCmd.QueryStatsRequest{Pattern: "online"})
-		if err != nil {
-			return fmt.Errorf("QueryStats: %w", err)
-		}
+	// Try dedicated online stats first (Xray statsUserOnline).
+	resp, err := sc.QueryStats(ctx, &statsCmd.QueryStatsRequest{Pattern: "online"})
+	if err != nil {
+		return nil, fmt.Errorf("QueryStats: %w", err)
+	}
 
-		for _, s := range resp.GetStat() {
-			parts := strings.Split(s.GetName(), ">>>")
-			if len(parts) >= 3 && parts[0] == "user" && parts[len(parts)-1] == "online" && s.GetValue() > 0 {
-				result[parts[1]] = true
-			}
+	for _, s := range resp.GetStat() {
+		parts := strings.Split(s.GetName(), ">>>")
+		if len(parts) >= 3 && parts[0] == "user" && parts[len(parts)-1] == "online" && s.GetValue() > 0 {
+			result[parts[1]] = true
 		}
+	}
 
-		// Fallback: if no online stats (Xray version doesn't support
-		// statsUserOnline), use traffic stats to detect recently active
-		// users. Reset counters so each poll interval only detects users
-		// with traffic since the last check.
-		if len(resp.GetStat()) == 0 {
-			trafficResp, tErr := sc.QueryStats(ctx, &statsCmd.QueryStatsRequest{
-				Pattern: "user>>>",
-				Reset_:  true,
-			})
-			if tErr == nil && o.trafficReset {
-				serverUUID := cfg.Xray.UUID
-				for _, s := range trafficResp.GetStat() {
-					parts := strings.Split(s.GetName(), ">>>")
-					// user>>>{email}>>>traffic>>>uplink/downlink
-					if len(parts) == 4 && parts[0] == "user" && parts[2] == "traffic" && s.GetValue() > 0 {
-						if parts[1] != serverUUID {
-							result[parts[1]] = true
-						}
+	// Fallback: if no online stats (Xray version doesn't support
+	// statsUserOnline), use traffic stats to detect recently active
+	// users. Reset counters so each poll interval only detects users
+	// with traffic since the last check.
+	if len(resp.GetStat()) == 0 {
+		trafficResp, tErr := sc.QueryStats(ctx, &statsCmd.QueryStatsRequest{
+			Pattern: "user>>>",
+			Reset_:  true,
+		})
+		if tErr == nil && o.trafficReset {
+			serverUUID := cfg.Xray.UUID
+			for _, s := range trafficResp.GetStat() {
+				parts := strings.Split(s.GetName(), ">>>")
+				// user>>>{email}>>>traffic>>>uplink/downlink
+				if len(parts) == 4 && parts[0] == "user" && parts[2] == "traffic" && s.GetValue() > 0 {
+					if parts[1] != serverUUID {
+						result[parts[1]] = true
 					}
 				}
 			}
-			o.trafficReset = true
 		}
-
-		slog.Debug("online status refreshed", "online_count", len(result))
-		return nil
-	})
-
-	if err != nil {
-		slog.Debug("online status refresh failed", "error", err)
+		o.trafficReset = true
 	}
-
-	o.onlineMu.Lock()
-	o.onlineCache = result
-	o.onlinePoll = time.Now()
-	o.onlineMu.Unlock()
-
-	return result
+	return result, nil
 }
 
 // EnsureRelayStats patches the relay's Xray config to enable online
-// user tracking if it's not already configured. Call once at startup.
+// user tracking if it's not already configured, and brings its GeoIP
+// routing rules in line with the geoip settings. Call once at startup.
 // Uses the server's running Xray tunnel for fast access.
 func (o *Ops) EnsureRelayStats() {
 	cfg := o.Config()
@@ -1203,11 +2155,22 @@ func (o *Ops) EnsureRelayStats() {
 	time.Sleep(3 * time.Second)
 
 	err := o.sshThroughServerTunnel(cfg, func(client *gossh.Client) error {
+		geoChanged, err := ensureRelayGeoIP(client, cfg.GeoIP)
+		if err != nil {
+			slog.Warn("could not update relay GeoIP rules", "error", err)
+		}
+		if err := ensureRelayUnattendedUpgrades(client); err != nil {
+			slog.Warn("could not set up automatic updates on the relay", "error", err)
+		}
 		patched := ensureRelayStats(client)
 		if patched {
 			slog.Info("relay stats config patched, Xray restarted")
 			return nil
 		}
+		if geoChanged {
+			restartRelayXray(client)
+			return nil
+		}
 
 		// Config looks correct — verify stats are actually working by
 		// querying the API. If no stats exist, force a restart to ensure
@@ -1240,4 +2203,3 @@ func (o *Ops) EnsureRelayStats() {
 		slog.Warn("could not ensure relay stats config", "error", err)
 	}
 }
-
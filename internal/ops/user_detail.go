@@ -0,0 +1,65 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+)
+
+// UserDetail is everything known about one user: what the dashboard's
+// user page and tw user show display.
+type UserDetail struct {
+	UserInfo
+	// BundlePath is their directory, holding the files of their bundle.
+	BundlePath string       `json:"bundle_path"`
+	Presence   UserPresence `json:"presence"`
+	// Traffic is what they sent and received through the relay, or nil
+	// when the relay couldn't be asked.
+	Traffic *UserTraffic `json:"traffic,omitempty"`
+}
+
+// UserTraffic is a user's byte counts on the relay's Xray, since it last
+// restarted.
+type UserTraffic struct {
+	Uplink   int64 `json:"uplink_bytes"`
+	Downlink int64 `json:"downlink_bytes"`
+}
+
+// GetUserDetail returns the user name's details. Their online state and
+// traffic come from the relay, and are left out when it can't be reached.
+func (o *Ops) GetUserDetail(ctx context.Context, name string) (*UserDetail, error) {
+	users, err := o.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	var d *UserDetail
+	for _, u := range users {
+		if u.Name == name {
+			d = &UserDetail{UserInfo: u, BundlePath: u.DirPath}
+			break
+		}
+	}
+	if d == nil {
+		return nil, fmt.Errorf("user %q not found", name)
+	}
+
+	d.Presence = o.UserPresence(name)
+	if d.UUID == "" {
+		return d, nil
+	}
+	d.Online = o.GetOnlineUsers()[d.UUID]
+
+	stats, err := o.GetRelayStats(ctx, "user>>>"+d.UUID+">>>traffic>>>", false)
+	if err != nil {
+		return d, nil
+	}
+	d.Traffic = &UserTraffic{}
+	for _, s := range stats.Stats {
+		switch s.Name {
+		case "user>>>" + d.UUID + ">>>traffic>>>uplink":
+			d.Traffic.Uplink = s.Value
+		case "user>>>" + d.UUID + ">>>traffic>>>downlink":
+			d.Traffic.Downlink = s.Value
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,162 @@
+package ops
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParsePortMapping parses a "CLIENT:SERVER" mapping such as "8080:80", or
+// a "CLIENT:HOST:PORT" one such as "5433:10.0.0.5:5432" that forwards to
+// another host the server reaches ("5433:[fd00::5]:5432" for IPv6).
+// A bare port such as "5432" maps the same port on both sides.
+func ParsePortMapping(s string) (PortMapping, error) {
+	clientStr, serverStr, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		serverStr = clientStr
+	}
+	host := ""
+	if strings.Contains(serverStr, ":") {
+		var err error
+		if host, serverStr, err = net.SplitHostPort(serverStr); err != nil || host == "" {
+			return PortMapping{}, fmt.Errorf("invalid server address in mapping %q", s)
+		}
+	}
+	clientPort, err := strconv.Atoi(strings.TrimSpace(clientStr))
+	if err != nil || clientPort < 1 || clientPort > 65535 {
+		return PortMapping{}, fmt.Errorf("invalid client port in mapping %q", s)
+	}
+	serverPort, err := strconv.Atoi(strings.TrimSpace(serverStr))
+	if err != nil || serverPort < 1 || serverPort > 65535 {
+		return PortMapping{}, fmt.Errorf("invalid server port in mapping %q", s)
+	}
+	pm := PortMapping{ClientPort: clientPort, ServerHost: host, ServerPort: serverPort}
+	if err := pm.validate(); err != nil {
+		return PortMapping{}, fmt.Errorf("mapping %q: %w", s, err)
+	}
+	return pm, nil
+}
+
+// ImportFormat returns the import format implied by a file name: "csv"
+// for .csv files and "yaml" for everything else.
+func ImportFormat(filename string) string {
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		return "csv"
+	}
+	return "yaml"
+}
+
+// ParseUserImport parses a bulk user file into create requests.
+//
+// YAML files hold a top-level "users" list; each entry has a name and
+// either structured "mappings" or a "map" list of CLIENT:SERVER strings:
+//
+//	users:
+//	  - name: alice
+//	    map: ["8080:80", "5433:5432"]
+//	  - name: bob
+//	    map: ["5433:5432"]
+//	    public_key: ssh-ed25519 AAAA... bob@laptop
+//
+// An entry's public_key registers the user's own key instead of
+// generating one (see CreateUserRequest.PublicKey).
+//
+// CSV files hold one user per row: the name followed by one or more
+// CLIENT:SERVER columns. Rows sharing a name are merged, a leading
+// "name" header row is skipped, and lines starting with # are ignored.
+func ParseUserImport(data []byte, format string) ([]CreateUserRequest, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return parseUserCSV(data)
+	case "yaml", "yml", "":
+		return parseUserYAML(data)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q (use csv or yaml)", format)
+	}
+}
+
+func parseUserYAML(data []byte) ([]CreateUserRequest, error) {
+	var file struct {
+		Users []struct {
+			Name      string        `yaml:"name"`
+			Mappings  []PortMapping `yaml:"mappings"`
+			Map       []string      `yaml:"map"`
+			PublicKey string        `yaml:"public_key"`
+		} `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing YAML: %w", err)
+	}
+
+	reqs := make([]CreateUserRequest, 0, len(file.Users))
+	for i, u := range file.Users {
+		req := CreateUserRequest{Name: strings.TrimSpace(u.Name), Mappings: u.Mappings, PublicKey: u.PublicKey}
+		for _, m := range u.Map {
+			pm, err := ParsePortMapping(m)
+			if err != nil {
+				return nil, fmt.Errorf("users[%d]: %w", i, err)
+			}
+			req.Mappings = append(req.Mappings, pm)
+		}
+		reqs = append(reqs, req)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no users found (expected a top-level \"users\" list)")
+	}
+	return reqs, nil
+}
+
+func parseUserCSV(data []byte) ([]CreateUserRequest, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var reqs []CreateUserRequest
+	index := make(map[string]int)
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing CSV: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		name := strings.TrimSpace(rec[0])
+		if first && strings.EqualFold(name, "name") {
+			continue
+		}
+		if name == "" {
+			return nil, fmt.Errorf("line %d: user name is required", line)
+		}
+
+		i, ok := index[name]
+		if !ok {
+			i = len(reqs)
+			index[name] = i
+			reqs = append(reqs, CreateUserRequest{Name: name})
+		}
+		for _, field := range rec[1:] {
+			if strings.TrimSpace(field) == "" {
+				continue
+			}
+			pm, err := ParsePortMapping(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			reqs[i].Mappings = append(reqs[i].Mappings, pm)
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no users found")
+	}
+	return reqs, nil
+}
@@ -0,0 +1,74 @@
+package ops
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	twssh "github.com/tunnelwhisperer/tw/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A user may bring their own SSH key and hand over only its public half
+// (CreateUserRequest.PublicKey). tw then registers that key, and their
+// bundle holds no private key: their client signs with the key file they
+// place in its config directory, or with their SSH agent.
+
+// minRSABits is the smallest RSA key a user may bring.
+const minRSABits = 2048
+
+// parseUserPublicKey checks a public key a user brought, as an
+// authorized_keys or .pub file line, and returns it as its key type and
+// data alone, options and comment dropped.
+func parseUserPublicKey(s string) ([]byte, error) {
+	pub, _, _, rest, err := gossh.ParseAuthorizedKey([]byte(strings.TrimSpace(s)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, fmt.Errorf("invalid public key: give a single key")
+	}
+	switch pub.Type() {
+	case gossh.KeyAlgoED25519, gossh.KeyAlgoSKED25519,
+		gossh.KeyAlgoECDSA256, gossh.KeyAlgoECDSA384, gossh.KeyAlgoECDSA521, gossh.KeyAlgoSKECDSA256:
+	case gossh.KeyAlgoRSA:
+		if cpk, ok := pub.(gossh.CryptoPublicKey); ok {
+			if k, ok := cpk.CryptoPublicKey().(*rsa.PublicKey); ok && k.N.BitLen() < minRSABits {
+				return nil, fmt.Errorf("RSA key of %d bits is too short (at least %d needed)", k.N.BitLen(), minRSABits)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %s (use ed25519, ecdsa or RSA)", pub.Type())
+	}
+	return gossh.MarshalAuthorizedKey(pub), nil
+}
+
+// publicKeyType returns the KeyType of UserInfo for the public key pubData.
+func publicKeyType(pubData []byte) string {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(pubData)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case pub.Type() == gossh.KeyAlgoED25519:
+		return "ed25519"
+	case pub.Type() == gossh.KeyAlgoSKED25519:
+		return "ed25519-sk"
+	case twssh.IsSecurityKey(pub):
+		return "ecdsa-sk"
+	case pub.Type() == gossh.KeyAlgoRSA:
+		return "rsa"
+	}
+	return "ecdsa"
+}
+
+// importedKeyReadme goes into the bundle of a user who brought their own
+// key.
+const importedKeyReadme = `This bundle holds no SSH private key: it was made for the public key
+you handed over, and you sign with its private key.
+
+Copy it into the tw config directory next to config.yaml, named
+id_ed25519 whatever its type, or load it into your SSH agent
+(ssh-add <file>) before running tw connect; without the file, tw uses
+the agent. A key with a passphrase must go through the agent.
+`
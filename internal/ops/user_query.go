@@ -0,0 +1,179 @@
+package ops
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Page size limits for UserQuery.
+const (
+	DefaultUsersPerPage = 25
+	MaxUsersPerPage     = 500
+)
+
+// UserQuery selects, orders, and pages a list of users.
+type UserQuery struct {
+	Search   string `json:"search,omitempty"`   // case-insensitive name substring
+	Status   string `json:"status,omitempty"`   // active, inactive, online, offline, disabled
+	Template string `json:"template,omitempty"` // mapping template name
+	Sort     string `json:"sort,omitempty"`     // name, tunnels, status (default)
+	Order    string `json:"order,omitempty"`    // asc (default) or desc
+	Page     int    `json:"page,omitempty"`     // 1-based
+	PerPage  int    `json:"per_page,omitempty"` // 0 means DefaultUsersPerPage
+}
+
+// UserPage is one page of users matching a UserQuery.
+type UserPage struct {
+	Users   []UserInfo `json:"users"`
+	Total   int        `json:"total"` // matching users across all pages
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
+	Pages   int        `json:"pages"`
+}
+
+// Validate checks the query's enumerated fields.
+func (q UserQuery) Validate() error {
+	switch q.Status {
+	case "", "active", "inactive", "online", "offline", "disabled":
+	default:
+		return fmt.Errorf("invalid status %q (use active, inactive, online, offline, or disabled)", q.Status)
+	}
+	switch q.Sort {
+	case "", "name", "tunnels", "status":
+	default:
+		return fmt.Errorf("invalid sort %q (use name, tunnels, or status)", q.Sort)
+	}
+	switch q.Order {
+	case "", "asc", "desc":
+	default:
+		return fmt.Errorf("invalid order %q (use asc or desc)", q.Order)
+	}
+	if q.Page < 0 || q.PerPage < 0 {
+		return fmt.Errorf("page and per_page must not be negative")
+	}
+	if q.PerPage > MaxUsersPerPage {
+		return fmt.Errorf("per_page must be at most %d", MaxUsersPerPage)
+	}
+	return nil
+}
+
+// QueryUsers lists users with their online status and applies q.
+func (o *Ops) QueryUsers(q UserQuery) (UserPage, error) {
+	if err := q.Validate(); err != nil {
+		return UserPage{}, err
+	}
+	users, err := o.ListUsers()
+	if err != nil {
+		return UserPage{}, err
+	}
+	online := o.GetOnlineUsers()
+	for i := range users {
+		if users[i].UUID != "" && online[users[i].UUID] {
+			users[i].Online = true
+		}
+	}
+	return FilterUsers(users, q), nil
+}
+
+// FilterUsers applies a query to an already-loaded user list. The default
+// order is online, registered, unregistered, then disabled, with ties
+// broken by name.
+func FilterUsers(users []UserInfo, q UserQuery) UserPage {
+	search := strings.ToLower(strings.TrimSpace(q.Search))
+
+	matching := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		if search != "" && !strings.Contains(strings.ToLower(u.Name), search) {
+			continue
+		}
+		if q.Template != "" && u.Template != q.Template {
+			continue
+		}
+		if !matchesStatus(u, q.Status) {
+			continue
+		}
+		matching = append(matching, u)
+	}
+
+	desc := q.Order == "desc"
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := matching[i], matching[j]
+		var cmp int
+		switch q.Sort {
+		case "name":
+			cmp = strings.Compare(a.Name, b.Name)
+		case "tunnels":
+			cmp = len(a.Tunnels) - len(b.Tunnels)
+		default:
+			cmp = statusRank(a) - statusRank(b)
+		}
+		if cmp == 0 {
+			return a.Name < b.Name
+		}
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	perPage := q.PerPage
+	if perPage == 0 {
+		perPage = DefaultUsersPerPage
+	}
+	pages := (len(matching) + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+	page := q.Page
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	return UserPage{
+		Users:   matching[start:end],
+		Total:   len(matching),
+		Page:    page,
+		PerPage: perPage,
+		Pages:   pages,
+	}
+}
+
+func matchesStatus(u UserInfo, status string) bool {
+	switch status {
+	case "active":
+		return u.Active && !u.Disabled
+	case "inactive":
+		return !u.Active && !u.Disabled
+	case "online":
+		return u.Online
+	case "offline":
+		return !u.Online
+	case "disabled":
+		return u.Disabled
+	}
+	return true
+}
+
+// statusRank orders users online → registered → unregistered → disabled.
+func statusRank(u UserInfo) int {
+	switch {
+	case u.Disabled:
+		return 3
+	case u.Online:
+		return 0
+	case u.Active:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,58 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// A user with SFTP turned on can exchange files with the server host over
+// their tunnel's SSH connection, confined to the files directory in their
+// user directory. SFTP is off unless the .sftp marker is present.
+const (
+	sftpMarker = ".sftp"
+	sftpDir    = "files"
+)
+
+// SetUserSFTP turns SFTP on or off for a user. Turning it off keeps the
+// user's files; sessions already open run until the client ends them.
+func (o *Ops) SetUserSFTP(name string, enabled bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return fmt.Errorf("user %q not found", name)
+	}
+	marker := filepath.Join(userDir, sftpMarker)
+	if !enabled {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(userDir, sftpDir), 0700); err != nil {
+		return fmt.Errorf("creating SFTP directory: %w", err)
+	}
+	return os.WriteFile(marker, nil, 0644)
+}
+
+// userSFTPRoot returns the directory name's SFTP sessions are confined to,
+// or "" when SFTP is off for them. name comes from the key's
+// authorized_keys comment, so it is checked like a user name.
+func userSFTPRoot(name string) string {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return ""
+	}
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(filepath.Join(userDir, sftpMarker)); err != nil {
+		return ""
+	}
+	root := filepath.Join(userDir, sftpDir)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return ""
+	}
+	return root
+}
@@ -0,0 +1,136 @@
+package ops
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+	"github.com/tunnelwhisperer/tw/internal/totp"
+	"golang.org/x/term"
+	"rsc.io/qr"
+)
+
+// A user with TOTP turned on must give a code from their authenticator
+// app after their key each time their client connects. The secret is kept
+// in the totp file in their user directory, and never goes into their
+// bundle: the admin hands over the enrollment QR code separately.
+const totpFile = "totp"
+
+const totpIssuer = "Tunnel Whisperer"
+
+// TOTPEnrollment is what a user's authenticator app needs.
+type TOTPEnrollment struct {
+	User   string `json:"user"`
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI, for the QR code
+}
+
+// QR returns the enrollment's QR code.
+func (e *TOTPEnrollment) QR() (*qr.Code, error) {
+	return qr.Encode(e.URI, qr.M)
+}
+
+func newTOTPEnrollment(cfg *config.Config, name, secret string) *TOTPEnrollment {
+	account := name
+	if cfg.Xray.RelayHost != "" {
+		account += "@" + cfg.Xray.RelayHost
+	}
+	return &TOTPEnrollment{User: name, Secret: secret, URI: totp.URI(secret, totpIssuer, account)}
+}
+
+// writeUserTOTP gives the user in userDir a new TOTP secret.
+func writeUserTOTP(userDir string) error {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return fmt.Errorf("generating TOTP secret: %w", err)
+	}
+	return fileutil.WriteFile(filepath.Join(userDir, totpFile), []byte(secret+"\n"), 0600)
+}
+
+// userTOTPSecret returns the TOTP secret of the tw user name, or "" when
+// they have none, for the SSH server.
+func userTOTPSecret(name string) string {
+	if validateName(name) != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(config.UsersDir(), name, totpFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// UserTOTP returns a user's TOTP enrollment, to show its QR code again.
+func (o *Ops) UserTOTP(name string) (*TOTPEnrollment, error) {
+	if _, err := os.Stat(filepath.Join(config.UsersDir(), name)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("user %q not found", name)
+	}
+	secret := userTOTPSecret(name)
+	if secret == "" {
+		return nil, fmt.Errorf("TOTP is off for %q", name)
+	}
+	return newTOTPEnrollment(o.Config(), name, secret), nil
+}
+
+// SetUserTOTP turns TOTP on or off for a user. Turning it on makes a new
+// secret, replacing any they had, and returns its enrollment; connections
+// already open stay up.
+func (o *Ops) SetUserTOTP(name string, enabled bool) (*TOTPEnrollment, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	userDir := filepath.Join(config.UsersDir(), name)
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("user %q not found", name)
+	}
+	if !enabled {
+		if err := os.Remove(filepath.Join(userDir, totpFile)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err := writeUserTOTP(userDir); err != nil {
+		return nil, err
+	}
+	return newTOTPEnrollment(o.cfg, name, userTOTPSecret(name)), nil
+}
+
+// clientTOTPCode returns how a client answers the server's TOTP prompt:
+// with a code made from the secret stored as secrets.NameTOTP, or else by
+// asking on the terminal when tw runs in one. It returns nil when it can
+// do neither.
+func clientTOTPCode() func() (string, error) {
+	stored := func() (string, bool) {
+		v, err := secrets.Get(secrets.NameTOTP)
+		if err != nil {
+			return "", false
+		}
+		secret, err := totp.ParseSecret(v)
+		return secret, err == nil
+	}
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	if _, ok := stored(); !ok && !interactive {
+		return nil
+	}
+	return func() (string, error) {
+		if secret, ok := stored(); ok {
+			return totp.Code(secret, time.Now())
+		}
+		if !interactive {
+			return "", errors.New("no TOTP secret stored")
+		}
+		fmt.Fprint(os.Stderr, "  TOTP code: ")
+		sc := bufio.NewScanner(os.Stdin)
+		if !sc.Scan() {
+			return "", errors.New("no TOTP code entered")
+		}
+		return strings.TrimSpace(sc.Text()), nil
+	}
+}
@@ -0,0 +1,111 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/tunnelwhisperer/tw/internal/auth"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/eventsink"
+	"github.com/tunnelwhisperer/tw/internal/geoip"
+	"github.com/tunnelwhisperer/tw/internal/proxyauth"
+	"github.com/tunnelwhisperer/tw/internal/secrets"
+	twxray "github.com/tunnelwhisperer/tw/internal/xray"
+)
+
+// ValidateConfig checks cfg: config.Validate plus the settings whose rules
+// live with the code that uses them.
+func ValidateConfig(cfg *config.Config) []config.Problem {
+	problems := cfg.Validate()
+	add := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, config.Problem{Field: field, Message: err.Error()})
+		}
+	}
+
+	add("xray.transport", twxray.ValidateTransport(cfg.Xray.Transport))
+	add("xray.tls", twxray.ValidateTLS(cfg.Xray.TLS))
+	add("proxy_rules", twxray.ValidateProxyRules(cfg.ProxyRules))
+	proxyURL, err := secrets.Resolve(cfg.Proxy)
+	add("proxy", err)
+	if proxyauth.IsNTLM(proxyURL) {
+		add("proxy", proxyauth.Validate(proxyURL))
+	}
+	if g := cfg.GeoIP; g.Database != "" {
+		if _, err := os.Stat(g.Database); err != nil {
+			add("geoip.database", err)
+		}
+	} else if g.Enabled() && !geoip.HasBuiltin() {
+		add("geoip.database", geoip.ErrNoDatabase)
+	}
+	for i, es := range cfg.Server.EventSinks {
+		field := fmt.Sprintf("server.event_sinks[%d]", i)
+		if es.Type == "syslog" {
+			_, _, err := eventsink.ParseSyslogAddress(es.Address)
+			add(field+".address", err)
+		}
+		for k, h := range es.Headers {
+			_, err := secrets.Resolve(h)
+			add(field+".headers."+k, err)
+		}
+	}
+	// A shell on this host must not be open to anyone who can reach the
+	// dashboard, and it is until an API token exists.
+	if cfg.Dashboard.HostTerminal && !auth.Enabled() {
+		add("dashboard.host_terminal", errors.New("needs access control: create an API token first with tw token create"))
+	}
+	if cfg.Mode != "server" {
+		for i, t := range cfg.Client.Tunnels {
+			if t.LocalPort == twxray.ClientListenPort || t.LocalPort == checkListenPort {
+				add(fmt.Sprintf("client.tunnels[%d].local_port", i),
+					fmt.Errorf("%d is reserved for tw's own Xray listener", t.LocalPort))
+			}
+		}
+	}
+	return problems
+}
+
+// ValidateConfigYAML checks a config.yaml document: keys that aren't
+// settings, then ValidateConfig on the values. A document that can't be
+// parsed is reported as a single problem.
+func ValidateConfigYAML(data []byte) []config.Problem {
+	problems := config.UnknownKeys(data)
+	cfg, err := config.Parse(data)
+	if err != nil {
+		if len(problems) > 0 {
+			return problems
+		}
+		return []config.Problem{{Message: err.Error()}}
+	}
+	return append(problems, ValidateConfig(cfg)...)
+}
+
+// ValidateConfigFile checks the config file on disk. A missing file is
+// valid: the defaults are used.
+func ValidateConfigFile() ([]config.Problem, error) {
+	data, err := os.ReadFile(config.FilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	return ValidateConfigYAML(data), nil
+}
+
+// warnConfigProblems logs what is wrong with config.yaml, so a typo or a bad
+// value shows up when the config is loaded rather than as a failure later.
+func warnConfigProblems() {
+	problems, err := ValidateConfigFile()
+	if err != nil {
+		return // Load reports read errors
+	}
+	for _, p := range problems {
+		slog.Warn("config problem", "file", config.FilePath(), "field", p.Field, "problem", p.Message)
+	}
+	if len(problems) > 0 {
+		slog.Warn("run `tw config validate` for details", "problems", len(problems))
+	}
+}
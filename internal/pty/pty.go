@@ -0,0 +1,25 @@
+// Package pty runs commands on a pseudo-terminal, so the dashboard can
+// offer a terminal on the machine tw runs on. Linux uses /dev/ptmx and
+// Windows a pseudo console (Windows 10 1809 or later); other systems are
+// not supported.
+package pty
+
+import "io"
+
+// Process is a command running on a pseudo-terminal. Reading returns what
+// it writes to the terminal, writing types into it.
+type Process interface {
+	io.ReadWriter
+	// Resize changes the terminal to cols×rows.
+	Resize(cols, rows int) error
+	// Wait waits for the command to exit.
+	Wait() error
+	// Close kills the command if it still runs and releases the terminal.
+	Close() error
+}
+
+// Start runs argv on a new cols×rows pseudo-terminal with TERM set to
+// xterm-256color.
+func Start(argv []string, cols, rows int) (Process, error) {
+	return start(argv, cols, rows)
+}
@@ -0,0 +1,104 @@
+package pty
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type process struct {
+	ptmx *os.File
+	cmd  *exec.Cmd
+	once sync.Once
+}
+
+func start(argv []string, cols, rows int) (Process, error) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening pseudo-terminal: %w", err)
+	}
+	tty, err := openTTY(ptmx)
+	if err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	defer tty.Close()
+
+	p := &process{ptmx: ptmx}
+	if err := p.Resize(cols, rows); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	// A session of its own, with the terminal (stdin) as its controlling
+	// terminal, so job control and ^C work.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	p.cmd = cmd
+	return p, nil
+}
+
+// openTTY unlocks and opens the terminal side of ptmx.
+func openTTY(ptmx *os.File) (*os.File, error) {
+	fd := int(ptmx.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return nil, fmt.Errorf("unlocking pseudo-terminal: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, fmt.Errorf("naming pseudo-terminal: %w", err)
+	}
+	tty, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening pseudo-terminal: %w", err)
+	}
+	return tty, nil
+}
+
+func (p *process) Read(b []byte) (int, error) {
+	n, err := p.ptmx.Read(b)
+	// Once the command and its children have exited, reading the
+	// terminal fails with EIO rather than returning EOF.
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	return p.ptmx.Write(b)
+}
+
+func (p *process) Resize(cols, rows int) error {
+	return unix.IoctlSetWinsize(int(p.ptmx.Fd()), unix.TIOCSWINSZ, &unix.Winsize{
+		Row: uint16(rows),
+		Col: uint16(cols),
+	})
+}
+
+func (p *process) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *process) Close() error {
+	var err error
+	p.once.Do(func() {
+		// Hang up the session, as closing a terminal window does.
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGHUP)
+		err = p.ptmx.Close()
+	})
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package pty
+
+import (
+	"errors"
+	"runtime"
+)
+
+func start(argv []string, cols, rows int) (Process, error) {
+	return nil, errors.New("host terminals are not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,146 @@
+package pty
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+type process struct {
+	console windows.Handle
+	proc    windows.Handle
+	in      *os.File // we write, the console reads
+	out     *os.File // the console writes, we read
+	once    sync.Once
+}
+
+func start(argv []string, cols, rows int) (Process, error) {
+	var consoleIn, in, out, consoleOut windows.Handle
+	if err := windows.CreatePipe(&consoleIn, &in, nil, 0); err != nil {
+		return nil, fmt.Errorf("creating pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&out, &consoleOut, nil, 0); err != nil {
+		windows.CloseHandle(consoleIn)
+		windows.CloseHandle(in)
+		return nil, fmt.Errorf("creating pipe: %w", err)
+	}
+	p := &process{
+		in:  os.NewFile(uintptr(in), "pty-in"),
+		out: os.NewFile(uintptr(out), "pty-out"),
+	}
+	// The console holds its own references to its ends of the pipes.
+	defer windows.CloseHandle(consoleIn)
+	defer windows.CloseHandle(consoleOut)
+
+	err := windows.CreatePseudoConsole(coord(cols, rows), consoleIn, consoleOut, 0, &p.console)
+	if err != nil {
+		p.in.Close()
+		p.out.Close()
+		return nil, fmt.Errorf("creating pseudo console (needs Windows 10 1809 or later): %w", err)
+	}
+	if err := p.spawn(argv); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// spawn starts argv attached to the pseudo console.
+func (p *process) spawn(argv []string) error {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return err
+	}
+	defer attrs.Delete()
+	// The attribute's value is the console handle itself, not a pointer
+	// to it.
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE,
+		*(*unsafe.Pointer)(unsafe.Pointer(&p.console)), unsafe.Sizeof(p.console)); err != nil {
+		return err
+	}
+
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	cmdLine, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(argv))
+	if err != nil {
+		return err
+	}
+	env, err := environment(append(os.Environ(), "TERM=xterm-256color"))
+	if err != nil {
+		return err
+	}
+	var pi windows.ProcessInformation
+	err = windows.CreateProcess(nil, cmdLine, nil, nil, false,
+		windows.EXTENDED_STARTUPINFO_PRESENT|windows.CREATE_UNICODE_ENVIRONMENT,
+		env, nil, &si.StartupInfo, &pi)
+	if err != nil {
+		return fmt.Errorf("starting %s: %w", argv[0], err)
+	}
+	windows.CloseHandle(pi.Thread)
+	p.proc = pi.Process
+	return nil
+}
+
+// environment encodes env as a Unicode environment block.
+func environment(env []string) (*uint16, error) {
+	var block []uint16
+	for _, kv := range env {
+		s, err := windows.UTF16FromString(kv)
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, s...)
+	}
+	block = append(block, 0)
+	return &block[0], nil
+}
+
+func coord(cols, rows int) windows.Coord {
+	return windows.Coord{X: int16(cols), Y: int16(rows)}
+}
+
+func (p *process) Read(b []byte) (int, error) {
+	return p.out.Read(b)
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+func (p *process) Resize(cols, rows int) error {
+	return windows.ResizePseudoConsole(p.console, coord(cols, rows))
+}
+
+func (p *process) Wait() error {
+	if p.proc == 0 {
+		return errors.New("process not started")
+	}
+	if _, err := windows.WaitForSingleObject(p.proc, windows.INFINITE); err != nil {
+		return err
+	}
+	var code uint32
+	if err := windows.GetExitCodeProcess(p.proc, &code); err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("exit status %d", code)
+	}
+	return nil
+}
+
+func (p *process) Close() error {
+	p.once.Do(func() {
+		if p.proc != 0 {
+			windows.TerminateProcess(p.proc, 1)
+		}
+		// Closing the console ends the output pipe, which ends Read.
+		windows.ClosePseudoConsole(p.console)
+		p.in.Close()
+		p.out.Close()
+	})
+	return nil
+}
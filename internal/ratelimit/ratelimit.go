@@ -0,0 +1,160 @@
+// Package ratelimit bans sources that keep failing to authenticate. A
+// source that fails MaxFailures times within Window is banned for BanTime;
+// each further ban doubles the duration, up to MaxBanTime.
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options tunes a Limiter.
+type Options struct {
+	MaxFailures int
+	Window      time.Duration
+	BanTime     time.Duration
+	MaxBanTime  time.Duration
+}
+
+// Ban describes a banned source.
+type Ban struct {
+	Key   string    `json:"key"`   // source address, e.g. "203.0.113.7"
+	Until time.Time `json:"until"` // when the ban lifts
+	Count int       `json:"count"` // bans so far; the duration doubles with each
+}
+
+type entry struct {
+	failures []time.Time // within the window
+	until    time.Time   // ban expiry; zero when not banned
+	count    int         // bans so far
+}
+
+// Limiter tracks failures and bans per source key. It is safe for
+// concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	opts    Options
+	entries map[string]*entry
+}
+
+// New creates a Limiter.
+func New(opts Options) *Limiter {
+	return &Limiter{opts: opts, entries: make(map[string]*entry)}
+}
+
+// SetOptions changes the limits. Existing bans keep their expiry.
+func (l *Limiter) SetOptions(opts Options) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opts = opts
+}
+
+// Banned reports whether key is banned and until when.
+func (l *Limiter) Banned(key string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok || !time.Now().Before(e.until) {
+		return time.Time{}, false
+	}
+	return e.until, true
+}
+
+// Fail records a failure for key. When it causes a ban, Fail returns the
+// time the ban lifts and true.
+func (l *Limiter) Fail(key string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	if now.Before(e.until) {
+		return e.until, false // already banned
+	}
+
+	cutoff := now.Add(-l.opts.Window)
+	kept := e.failures[:0]
+	for _, t := range e.failures {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	e.failures = append(kept, now)
+	if l.opts.MaxFailures <= 0 || len(e.failures) < l.opts.MaxFailures {
+		return time.Time{}, false
+	}
+
+	d := l.opts.BanTime
+	for i := 0; i < e.count && d < l.opts.MaxBanTime; i++ {
+		d *= 2
+	}
+	if l.opts.MaxBanTime > 0 && d > l.opts.MaxBanTime {
+		d = l.opts.MaxBanTime
+	}
+	e.count++
+	e.failures = nil
+	e.until = now.Add(d)
+	return e.until, true
+}
+
+// Success clears key's failures after it authenticates. Earlier bans still
+// count toward the next ban's duration until they are forgotten.
+func (l *Limiter) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		e.failures = nil
+	}
+}
+
+// Bans returns the sources banned now, soonest to lift first.
+func (l *Limiter) Bans() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var bans []Ban
+	for key, e := range l.entries {
+		if now.Before(e.until) {
+			bans = append(bans, Ban{Key: key, Until: e.until, Count: e.count})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// Clear lifts key's ban and forgets its history. It reports whether key
+// was known.
+func (l *Limiter) Clear(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[key]
+	delete(l.entries, key)
+	return ok
+}
+
+// ClearAll lifts every ban and forgets all history.
+func (l *Limiter) ClearAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = make(map[string]*entry)
+}
+
+// prune forgets sources with no recent failures whose last ban ended more
+// than MaxBanTime ago, so their next ban starts from BanTime again.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if now.Before(e.until) || now.Before(e.until.Add(l.opts.MaxBanTime)) {
+			continue
+		}
+		if n := len(e.failures); n > 0 && e.failures[n-1].After(now.Add(-l.opts.Window)) {
+			continue
+		}
+		delete(l.entries, key)
+	}
+}
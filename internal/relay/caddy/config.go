@@ -9,6 +9,27 @@ import (
 //go:embed Caddyfile.tmpl
 var caddyfileTmpl string
 
+//go:embed site.caddy.tmpl
+var siteTmpl string
+
+//go:embed routes.caddy.tmpl
+var routeTmpl string
+
+// SitesDir is the relay directory holding per-host site blocks. The relay
+// Caddyfile imports every *.caddy file in it.
+const SitesDir = "/etc/caddy/sites"
+
+// SitesImport is the Caddyfile line that loads the site blocks.
+const SitesImport = "import " + SitesDir + "/*.caddy"
+
+// RoutesDir is the relay directory holding route blocks for the relay's
+// own domain. Its site block imports every *.caddy file in it.
+const RoutesDir = "/etc/caddy/routes"
+
+// RoutesImport is the line in the relay domain's site block that loads
+// the route blocks.
+const RoutesImport = "import " + RoutesDir + "/*.caddy"
+
 // Config holds the values used to render a Caddyfile.
 type Config struct {
 	Domain           string
@@ -28,3 +49,55 @@ func RenderCaddyfile(cfg Config) (string, error) {
 	}
 	return buf.String(), nil
 }
+
+// SiteConfig holds the values used to render a published site block.
+type SiteConfig struct {
+	Host        string // public hostname, e.g. "wiki.example.com"
+	Upstream    string // relay address of the reverse forward, e.g. "127.0.0.1:41001"
+	UpstreamTLS bool   // the service behind the tunnel speaks HTTPS
+}
+
+// SitePath returns the relay path of the site block for host.
+func SitePath(host string) string {
+	return SitesDir + "/" + host + ".caddy"
+}
+
+// RenderSite renders a site block that proxies host to the upstream.
+func RenderSite(cfg SiteConfig) (string, error) {
+	t, err := template.New("site").Parse(siteTmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, cfg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RouteConfig holds the values used to render a route on the relay's own
+// domain.
+type RouteConfig struct {
+	Name     string // route file name, e.g. "claim"
+	Path     string // path prefix, e.g. "/claim/"
+	Upstream string // relay address of the reverse forward
+}
+
+// RoutePath returns the relay path of the route block named name.
+func RoutePath(name string) string {
+	return RoutesDir + "/" + name + ".caddy"
+}
+
+// RenderRoute renders a route block that proxies requests under cfg.Path
+// to the upstream.
+func RenderRoute(cfg RouteConfig) (string, error) {
+	t, err := template.New("route").Parse(routeTmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, cfg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
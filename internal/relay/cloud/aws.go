@@ -0,0 +1,217 @@
+package cloud
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+type awsProvider struct {
+	creds Credentials
+}
+
+func newAWS(creds Credentials) *awsProvider {
+	return &awsProvider{creds: creds}
+}
+
+// client returns an EC2 client for region.
+func (a *awsProvider) client(region string) *ec2.Client {
+	return ec2.New(ec2.Options{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider(a.creds.Token, a.creds.AWSSecretKey, ""),
+	})
+}
+
+func (a *awsProvider) Create(ctx context.Context, spec Spec, log func(string)) (*Manifest, error) {
+	m := &Manifest{Provider: "aws", Region: spec.Region, InstanceType: spec.InstanceType}
+	if m.Region == "" {
+		m.Region = "us-east-1"
+	}
+	if m.InstanceType == "" {
+		m.InstanceType = "t3.micro"
+	}
+	arch := spec.Arch
+	if arch == "" {
+		arch = "amd64"
+	}
+	client := a.client(m.Region)
+
+	// Security group names must be unique within the VPC.
+	log("Creating security group")
+	sg, err := client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(fmt.Sprintf("%s-%d", Name, time.Now().Unix())),
+		Description: aws.String("Tunnel Whisperer relay"),
+	})
+	if err != nil {
+		return m, fmt.Errorf("creating security group: %w", err)
+	}
+	m.FirewallID = aws.ToString(sg.GroupId)
+	if len(spec.Rules) > 0 {
+		_, err := client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       sg.GroupId,
+			IpPermissions: awsPermissions(spec.Rules),
+		})
+		if err != nil {
+			return m, fmt.Errorf("adding security group rules: %w", err)
+		}
+	}
+
+	ami, err := ubuntuAMI(ctx, client, arch)
+	if err != nil {
+		return m, err
+	}
+
+	log(fmt.Sprintf("Launching %s instance in %s", m.InstanceType, m.Region))
+	run, err := client.RunInstances(ctx, &ec2.RunInstancesInput{
+		ImageId:          aws.String(ami),
+		InstanceType:     types.InstanceType(m.InstanceType),
+		MinCount:         aws.Int32(1),
+		MaxCount:         aws.Int32(1),
+		SecurityGroupIds: []string{m.FirewallID},
+		UserData:         aws.String(base64.StdEncoding.EncodeToString([]byte(spec.UserData))),
+		BlockDeviceMappings: []types.BlockDeviceMapping{{
+			DeviceName: aws.String("/dev/sda1"),
+			Ebs:        &types.EbsBlockDevice{VolumeSize: aws.Int32(10), VolumeType: types.VolumeTypeGp3},
+		}},
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeInstance,
+			Tags:         []types.Tag{{Key: aws.String("Name"), Value: aws.String(Name)}},
+		}},
+	})
+	if err != nil {
+		return m, fmt.Errorf("launching instance: %w", err)
+	}
+	m.ServerID = aws.ToString(run.Instances[0].InstanceId)
+
+	log("Waiting for the instance to start")
+	describe := &ec2.DescribeInstancesInput{InstanceIds: []string{m.ServerID}}
+	if err := ec2.NewInstanceRunningWaiter(client).Wait(ctx, describe, 10*time.Minute); err != nil {
+		return m, fmt.Errorf("starting instance: %w", err)
+	}
+	out, err := client.DescribeInstances(ctx, describe)
+	if err != nil {
+		return m, fmt.Errorf("reading instance: %w", err)
+	}
+	for _, r := range out.Reservations {
+		for _, i := range r.Instances {
+			m.IP = aws.ToString(i.PublicIpAddress)
+		}
+	}
+	if m.IP == "" {
+		return m, fmt.Errorf("instance %s has no public IP — is the default subnet set to assign one?", m.ServerID)
+	}
+	return m, nil
+}
+
+func (a *awsProvider) SetFirewall(ctx context.Context, m *Manifest, rules []Rule) error {
+	client := a.client(m.Region)
+	out, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{m.FirewallID}})
+	if err != nil {
+		return err
+	}
+	if len(out.SecurityGroups) == 0 {
+		return fmt.Errorf("security group %s not found", m.FirewallID)
+	}
+	if old := out.SecurityGroups[0].IpPermissions; len(old) > 0 {
+		_, err := client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(m.FirewallID),
+			IpPermissions: old,
+		})
+		if err != nil {
+			return fmt.Errorf("removing old rules: %w", err)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	_, err = client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(m.FirewallID),
+		IpPermissions: awsPermissions(rules),
+	})
+	return err
+}
+
+func (a *awsProvider) Destroy(ctx context.Context, m *Manifest, log func(string)) error {
+	client := a.client(m.Region)
+	if m.ServerID != "" {
+		log("Terminating instance " + m.ServerID)
+		_, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: []string{m.ServerID}})
+		switch {
+		case awsNotFound(err):
+		case err != nil:
+			return fmt.Errorf("terminating instance: %w", err)
+		default:
+			// The security group can't be deleted while the instance uses it.
+			describe := &ec2.DescribeInstancesInput{InstanceIds: []string{m.ServerID}}
+			if err := ec2.NewInstanceTerminatedWaiter(client).Wait(ctx, describe, 10*time.Minute); err != nil {
+				return fmt.Errorf("terminating instance: %w", err)
+			}
+		}
+	}
+	if m.FirewallID != "" {
+		log("Deleting security group " + m.FirewallID)
+		_, err := client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(m.FirewallID)})
+		if err != nil && !awsNotFound(err) {
+			return fmt.Errorf("deleting security group: %w", err)
+		}
+	}
+	return nil
+}
+
+// ubuntuAMI returns the latest Canonical Ubuntu 24.04 image for arch.
+func ubuntuAMI(ctx context.Context, client *ec2.Client, arch string) (string, error) {
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners: []string{"099720109477"}, // Canonical
+		Filters: []types.Filter{{
+			Name:   aws.String("name"),
+			Values: []string{"ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-" + arch + "-server-*"},
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("looking up the Ubuntu image: %w", err)
+	}
+	if len(out.Images) == 0 {
+		return "", fmt.Errorf("no Ubuntu 24.04 image for %s", arch)
+	}
+	sort.Slice(out.Images, func(i, j int) bool {
+		return aws.ToString(out.Images[i].CreationDate) > aws.ToString(out.Images[j].CreationDate)
+	})
+	return aws.ToString(out.Images[0].ImageId), nil
+}
+
+// awsPermissions converts rules to security group ingress permissions,
+// which keep IPv4 and IPv6 ranges apart.
+func awsPermissions(rules []Rule) []types.IpPermission {
+	var perms []types.IpPermission
+	for _, r := range rules {
+		p := types.IpPermission{
+			IpProtocol: aws.String(r.Protocol),
+			FromPort:   aws.Int32(int32(r.Port)),
+			ToPort:     aws.Int32(int32(r.Port)),
+		}
+		for _, s := range r.Sources {
+			if strings.Contains(s, ":") {
+				p.Ipv6Ranges = append(p.Ipv6Ranges, types.Ipv6Range{CidrIpv6: aws.String(s)})
+			} else {
+				p.IpRanges = append(p.IpRanges, types.IpRange{CidrIp: aws.String(s)})
+			}
+		}
+		perms = append(perms, p)
+	}
+	return perms
+}
+
+func awsNotFound(err error) bool {
+	var e smithy.APIError
+	return errors.As(err, &e) && strings.HasSuffix(e.ErrorCode(), ".NotFound")
+}
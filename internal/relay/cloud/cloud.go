@@ -0,0 +1,138 @@
+// Package cloud provisions relay servers through the cloud providers' own
+// Go SDKs, for hosts where Terraform can't be installed. It creates the
+// same resources as the Terraform templates in package terraform — a
+// firewall and an Ubuntu 24.04 server running cloud-init.yaml — and keeps
+// what it created in a provider-agnostic JSON manifest instead of a
+// Terraform state.
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile is the manifest's name in the relay directory.
+const ManifestFile = "relay.json"
+
+// Name is given to every resource a provider creates.
+const Name = "tw-relay"
+
+// Manifest records the resources created for a relay, so they can be
+// changed and destroyed later. IDs are the provider's own, as strings.
+type Manifest struct {
+	Provider     string `json:"provider"` // "hetzner", "digitalocean" or "aws"
+	Region       string `json:"region"`
+	InstanceType string `json:"instance_type"`
+	ServerID     string `json:"server_id,omitempty"`
+	FirewallID   string `json:"firewall_id,omitempty"` // AWS: the security group
+	IP           string `json:"ip,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// Rule opens a port on the relay's cloud firewall. Sources are CIDRs and
+// must not be empty: everyone is "0.0.0.0/0" and "::/0".
+type Rule struct {
+	Port     int
+	Protocol string // "tcp" or "udp"
+	Sources  []string
+}
+
+// Spec describes the relay to create. Empty Region and InstanceType use
+// the same defaults as the Terraform templates.
+type Spec struct {
+	Region       string
+	InstanceType string
+	Arch         string // "amd64" or "arm64"; must match InstanceType
+	UserData     string // cloud-init.yaml
+	Rules        []Rule
+}
+
+// Credentials are a provider's API credentials: the token, or for AWS the
+// access key ID and secret access key.
+type Credentials struct {
+	Token        string
+	AWSSecretKey string
+}
+
+// Provider creates and destroys relays on one cloud.
+type Provider interface {
+	// Create creates the firewall and the server and waits until the
+	// server has a public IP. On failure it still returns the manifest
+	// of what was created, so Destroy can clean up.
+	Create(ctx context.Context, spec Spec, log func(string)) (*Manifest, error)
+
+	// SetFirewall replaces the inbound rules of the relay's firewall.
+	SetFirewall(ctx context.Context, m *Manifest, rules []Rule) error
+
+	// Destroy deletes the server and the firewall. Resources that are
+	// already gone are skipped.
+	Destroy(ctx context.Context, m *Manifest, log func(string)) error
+}
+
+// New returns the Provider for a provider key.
+func New(provider string, creds Credentials) (Provider, error) {
+	switch provider {
+	case "hetzner":
+		return newHetzner(creds), nil
+	case "digitalocean":
+		return newDigitalOcean(creds), nil
+	case "aws":
+		return newAWS(creds), nil
+	}
+	return nil, fmt.Errorf("unknown provider: %s", provider)
+}
+
+// ReadManifest reads the manifest in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+// WriteManifest writes m to dir, owner-only like the Terraform state.
+func WriteManifest(dir string, m *Manifest) error {
+	if m.CreatedAt == "" {
+		m.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", ManifestFile, err)
+	}
+	return nil
+}
+
+// HasManifest reports whether dir holds a relay provisioned through the
+// SDKs.
+func HasManifest(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ManifestFile))
+	return err == nil
+}
+
+// poll calls done every interval until it reports true, returns an error,
+// or ctx ends.
+func poll(ctx context.Context, interval time.Duration, done func() (bool, error)) error {
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+type digitalOcean struct {
+	client *godo.Client
+}
+
+func newDigitalOcean(creds Credentials) *digitalOcean {
+	return &digitalOcean{client: godo.NewFromToken(creds.Token)}
+}
+
+func (d *digitalOcean) Create(ctx context.Context, spec Spec, log func(string)) (*Manifest, error) {
+	m := &Manifest{Provider: "digitalocean", Region: spec.Region, InstanceType: spec.InstanceType}
+	if m.Region == "" {
+		m.Region = "fra1"
+	}
+	if m.InstanceType == "" {
+		m.InstanceType = "s-1vcpu-1gb"
+	}
+
+	log(fmt.Sprintf("Creating %s droplet in %s", m.InstanceType, m.Region))
+	droplet, _, err := d.client.Droplets.Create(ctx, &godo.DropletCreateRequest{
+		Name:     Name,
+		Region:   m.Region,
+		Size:     m.InstanceType,
+		Image:    godo.DropletCreateImage{Slug: "ubuntu-24-04-x64"},
+		UserData: spec.UserData,
+	})
+	if err != nil {
+		return m, fmt.Errorf("creating droplet: %w", err)
+	}
+	m.ServerID = strconv.Itoa(droplet.ID)
+
+	// Firewalls apply to droplets by ID, so this one comes second.
+	log("Creating firewall " + Name)
+	fw, _, err := d.client.Firewalls.Create(ctx, digitalOceanFirewall(droplet.ID, spec.Rules))
+	if err != nil {
+		return m, fmt.Errorf("creating firewall: %w", err)
+	}
+	m.FirewallID = fw.ID
+
+	log("Waiting for the droplet to start")
+	err = poll(ctx, 5*time.Second, func() (bool, error) {
+		droplet, _, err = d.client.Droplets.Get(ctx, droplet.ID)
+		if err != nil {
+			return false, err
+		}
+		return droplet.Status == "active", nil
+	})
+	if err != nil {
+		return m, fmt.Errorf("starting droplet: %w", err)
+	}
+	if m.IP, err = droplet.PublicIPv4(); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (d *digitalOcean) SetFirewall(ctx context.Context, m *Manifest, rules []Rule) error {
+	id, err := strconv.Atoi(m.ServerID)
+	if err != nil {
+		return fmt.Errorf("droplet ID %q: %w", m.ServerID, err)
+	}
+	_, _, err = d.client.Firewalls.Update(ctx, m.FirewallID, digitalOceanFirewall(id, rules))
+	return err
+}
+
+func (d *digitalOcean) Destroy(ctx context.Context, m *Manifest, log func(string)) error {
+	if m.FirewallID != "" {
+		log("Deleting firewall " + m.FirewallID)
+		if _, err := d.client.Firewalls.Delete(ctx, m.FirewallID); err != nil && !digitalOceanNotFound(err) {
+			return fmt.Errorf("deleting firewall: %w", err)
+		}
+	}
+	if m.ServerID != "" {
+		id, err := strconv.Atoi(m.ServerID)
+		if err != nil {
+			return fmt.Errorf("droplet ID %q: %w", m.ServerID, err)
+		}
+		log("Deleting droplet " + m.ServerID)
+		if _, err := d.client.Droplets.Delete(ctx, id); err != nil && !digitalOceanNotFound(err) {
+			return fmt.Errorf("deleting droplet: %w", err)
+		}
+	}
+	return nil
+}
+
+// digitalOceanFirewall returns the firewall for a droplet: rules inbound,
+// everything outbound.
+func digitalOceanFirewall(dropletID int, rules []Rule) *godo.FirewallRequest {
+	everyone := []string{"0.0.0.0/0", "::/0"}
+	req := &godo.FirewallRequest{
+		Name:       Name,
+		DropletIDs: []int{dropletID},
+		OutboundRules: []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: "1-65535", Destinations: &godo.Destinations{Addresses: everyone}},
+			{Protocol: "udp", PortRange: "1-65535", Destinations: &godo.Destinations{Addresses: everyone}},
+		},
+	}
+	for _, r := range rules {
+		req.InboundRules = append(req.InboundRules, godo.InboundRule{
+			Protocol:  r.Protocol,
+			PortRange: strconv.Itoa(r.Port),
+			Sources:   &godo.Sources{Addresses: r.Sources},
+		})
+	}
+	return req
+}
+
+func digitalOceanNotFound(err error) bool {
+	var e *godo.ErrorResponse
+	return errors.As(err, &e) && e.Response != nil && e.Response.StatusCode == http.StatusNotFound
+}
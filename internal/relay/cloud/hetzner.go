@@ -0,0 +1,142 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+type hetzner struct {
+	client *hcloud.Client
+}
+
+func newHetzner(creds Credentials) *hetzner {
+	return &hetzner{client: hcloud.NewClient(hcloud.WithToken(creds.Token), hcloud.WithApplication("tw", ""))}
+}
+
+func (h *hetzner) Create(ctx context.Context, spec Spec, log func(string)) (*Manifest, error) {
+	m := &Manifest{Provider: "hetzner", Region: spec.Region, InstanceType: spec.InstanceType}
+	if m.Region == "" {
+		m.Region = "nbg1"
+	}
+	if m.InstanceType == "" {
+		m.InstanceType = "cx22"
+	}
+	arch := hcloud.ArchitectureX86
+	if spec.Arch == "arm64" {
+		arch = hcloud.ArchitectureARM
+	}
+
+	rules, err := hetznerRules(spec.Rules)
+	if err != nil {
+		return m, err
+	}
+	log("Creating firewall " + Name)
+	fw, _, err := h.client.Firewall.Create(ctx, hcloud.FirewallCreateOpts{Name: Name, Rules: rules})
+	if err != nil {
+		return m, fmt.Errorf("creating firewall: %w", err)
+	}
+	m.FirewallID = strconv.FormatInt(fw.Firewall.ID, 10)
+
+	image, _, err := h.client.Image.GetForArchitecture(ctx, "ubuntu-24.04", arch)
+	if err != nil {
+		return m, fmt.Errorf("looking up the Ubuntu image: %w", err)
+	}
+	if image == nil {
+		return m, fmt.Errorf("no ubuntu-24.04 image for %s", arch)
+	}
+
+	log(fmt.Sprintf("Creating %s server in %s", m.InstanceType, m.Region))
+	res, _, err := h.client.Server.Create(ctx, hcloud.ServerCreateOpts{
+		Name:       Name,
+		ServerType: &hcloud.ServerType{Name: m.InstanceType},
+		Image:      image,
+		Location:   &hcloud.Location{Name: m.Region},
+		UserData:   spec.UserData,
+		Firewalls:  []*hcloud.ServerCreateFirewall{{Firewall: *fw.Firewall}},
+	})
+	if err != nil {
+		return m, fmt.Errorf("creating server: %w", err)
+	}
+	m.ServerID = strconv.FormatInt(res.Server.ID, 10)
+
+	log("Waiting for the server to start")
+	actions := append([]*hcloud.Action{res.Action}, res.NextActions...)
+	if err := h.client.Action.WaitFor(ctx, actions...); err != nil {
+		return m, fmt.Errorf("starting server: %w", err)
+	}
+	m.IP = res.Server.PublicNet.IPv4.IP.String()
+	return m, nil
+}
+
+func (h *hetzner) SetFirewall(ctx context.Context, m *Manifest, rules []Rule) error {
+	id, err := strconv.ParseInt(m.FirewallID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("firewall ID %q: %w", m.FirewallID, err)
+	}
+	hr, err := hetznerRules(rules)
+	if err != nil {
+		return err
+	}
+	actions, _, err := h.client.Firewall.SetRules(ctx, &hcloud.Firewall{ID: id}, hcloud.FirewallSetRulesOpts{Rules: hr})
+	if err != nil {
+		return err
+	}
+	return h.client.Action.WaitFor(ctx, actions...)
+}
+
+func (h *hetzner) Destroy(ctx context.Context, m *Manifest, log func(string)) error {
+	if m.ServerID != "" {
+		id, err := strconv.ParseInt(m.ServerID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("server ID %q: %w", m.ServerID, err)
+		}
+		log("Deleting server " + m.ServerID)
+		res, _, err := h.client.Server.DeleteWithResult(ctx, &hcloud.Server{ID: id})
+		switch {
+		case hcloud.IsError(err, hcloud.ErrorCodeNotFound):
+		case err != nil:
+			return fmt.Errorf("deleting server: %w", err)
+		default:
+			if err := h.client.Action.WaitFor(ctx, res.Action); err != nil {
+				return fmt.Errorf("deleting server: %w", err)
+			}
+		}
+	}
+	if m.FirewallID != "" {
+		id, err := strconv.ParseInt(m.FirewallID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("firewall ID %q: %w", m.FirewallID, err)
+		}
+		log("Deleting firewall " + m.FirewallID)
+		if _, err := h.client.Firewall.Delete(ctx, &hcloud.Firewall{ID: id}); err != nil && !hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+			return fmt.Errorf("deleting firewall: %w", err)
+		}
+	}
+	return nil
+}
+
+// hetznerRules converts rules to inbound Hetzner firewall rules.
+func hetznerRules(rules []Rule) ([]hcloud.FirewallRule, error) {
+	var out []hcloud.FirewallRule
+	for _, r := range rules {
+		var sources []net.IPNet
+		for _, s := range r.Sources {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("firewall source %q: %w", s, err)
+			}
+			sources = append(sources, *n)
+		}
+		out = append(out, hcloud.FirewallRule{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocol(r.Protocol),
+			Port:      hcloud.Ptr(strconv.Itoa(r.Port)),
+			SourceIPs: sources,
+		})
+	}
+	return out, nil
+}
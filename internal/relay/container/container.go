@@ -0,0 +1,135 @@
+// Package container renders a relay that runs Caddy and Xray in
+// containers, for a host the user already runs Docker or Podman on. The
+// bundle is a docker-compose.yml plus the Caddy and Xray config files, and
+// a deploy script that installs it over SSH.
+package container
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/tunnelwhisperer/tw/internal/relay/terraform"
+)
+
+//go:embed docker-compose.yml.tmpl
+var composeTmpl string
+
+//go:embed Dockerfile.caddy.tmpl
+var dockerfileTmpl string
+
+//go:embed Caddyfile.tmpl
+var caddyfileTmpl string
+
+//go:embed xray.json.tmpl
+var xrayTmpl string
+
+//go:embed deploy.sh.tmpl
+var deployTmpl string
+
+//go:embed down.sh.tmpl
+var downTmpl string
+
+// Images the relay runs. Xray is pinned like on provisioned relays.
+const (
+	CaddyImage        = "docker.io/library/caddy:2"
+	CaddyBuilderImage = "docker.io/library/caddy:2-builder"
+)
+
+// XrayImage returns the Xray image at terraform.XrayVersion.
+func XrayImage() string {
+	return "ghcr.io/xtls/xray-core:" + strings.TrimPrefix(terraform.XrayVersion, "v")
+}
+
+// Dir is where the bundle is installed on the host.
+const Dir = "/opt/tw-relay"
+
+// DownScript removes the relay from the host.
+const DownScript = Dir + "/down.sh"
+
+// File is one file of the bundle and where it goes on the host.
+type File struct {
+	Name    string // name in the local copy of the bundle
+	Path    string // absolute path on the host
+	Mode    string // octal, e.g. "0644"
+	Content string
+}
+
+// B64 returns the content base64-encoded, for the deploy script.
+func (f File) B64() string {
+	return base64.StdEncoding.EncodeToString([]byte(f.Content))
+}
+
+// bundleData is what the templates render from.
+type bundleData struct {
+	terraform.Config
+	CaddyImage        string
+	CaddyBuilderImage string
+	XrayImage         string
+	Files             []File
+}
+
+// Bundle renders the relay's files from cfg. Provider, Region,
+// InstanceType, the firewall and the fail2ban fields of cfg are not used:
+// the host's own firewall is left alone.
+func Bundle(cfg terraform.Config) ([]File, error) {
+	cfg.XrayVersion = terraform.XrayVersion
+	data := bundleData{
+		Config:            cfg,
+		CaddyImage:        CaddyImage,
+		CaddyBuilderImage: CaddyBuilderImage,
+		XrayImage:         XrayImage(),
+	}
+	var files []File
+	add := func(name, path, mode, tmpl string) error {
+		content, err := render(name, tmpl, data)
+		if err != nil {
+			return fmt.Errorf("rendering %s: %w", name, err)
+		}
+		files = append(files, File{Name: name, Path: path, Mode: mode, Content: content})
+		return nil
+	}
+
+	if err := add("docker-compose.yml", Dir+"/docker-compose.yml", "0644", composeTmpl); err != nil {
+		return nil, err
+	}
+	if cfg.ACMEDNSProvider != "" {
+		if err := add("Dockerfile.caddy", Dir+"/Dockerfile.caddy", "0644", dockerfileTmpl); err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: ".env", Path: Dir + "/.env", Mode: "0600",
+			Content: "TW_ACME_DNS_TOKEN=" + cfg.ACMEDNSToken + "\n"})
+	}
+	if err := add("Caddyfile", "/etc/caddy/Caddyfile", "0644", caddyfileTmpl); err != nil {
+		return nil, err
+	}
+	if err := add("xray.json", "/usr/local/etc/xray/config.json", "0600", xrayTmpl); err != nil {
+		return nil, err
+	}
+	if err := add("down.sh", DownScript, "0700", downTmpl); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// DeployScript renders the script that installs files on the host and
+// starts the containers. It prints "[n/4]" step lines like the manual
+// install script.
+func DeployScript(cfg terraform.Config, files []File) (string, error) {
+	return render("deploy.sh", deployTmpl, bundleData{Config: cfg, Files: files})
+}
+
+func render(name, tmplStr string, data bundleData) (string, error) {
+	t, err := template.New(name).Parse(tmplStr)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,115 @@
+// Package fail2ban renders the fail2ban jail that protects a relay's public
+// ports, and reads its ban list back over SSH.
+package fail2ban
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed jail.conf.tmpl
+var jailTmpl string
+
+//go:embed filter.conf.tmpl
+var filterTmpl string
+
+const (
+	// Jail is the name of the relay's fail2ban jail and filter.
+	Jail = "tw-caddy"
+	// JailPath and FilterPath are where the rendered files go on the relay.
+	JailPath   = "/etc/fail2ban/jail.d/tw.conf"
+	FilterPath = "/etc/fail2ban/filter.d/" + Jail + ".conf"
+	// AccessLog is the Caddy access log the jail watches.
+	AccessLog = "/var/log/caddy/access.log"
+)
+
+// Config holds the values used to render the jail. Failures are counted
+// over FindTime; a source with MaxRetry of them is banned for BanTime,
+// doubling with each further ban up to MaxBanTime.
+type Config struct {
+	XrayPath   string
+	MaxRetry   int
+	FindTime   time.Duration
+	BanTime    time.Duration
+	MaxBanTime time.Duration
+}
+
+// RenderJail renders the jail definition for JailPath.
+func RenderJail(cfg Config) (string, error) {
+	return render("jail", jailTmpl, map[string]interface{}{
+		"Jail":       Jail,
+		"LogPath":    AccessLog,
+		"MaxRetry":   cfg.MaxRetry,
+		"FindTime":   seconds(cfg.FindTime),
+		"BanTime":    seconds(cfg.BanTime),
+		"MaxBanTime": seconds(cfg.MaxBanTime),
+	})
+}
+
+// RenderFilter renders the filter definition for FilterPath.
+func RenderFilter(cfg Config) (string, error) {
+	return render("filter", filterTmpl, map[string]interface{}{
+		"PathRegex": regexp.QuoteMeta(cfg.XrayPath),
+	})
+}
+
+func render(name, tmplStr string, data interface{}) (string, error) {
+	t, err := template.New(name).Parse(tmplStr)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// seconds formats d for fail2ban, which takes whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// Ban is a source banned on the relay.
+type Ban struct {
+	IP    string     `json:"ip"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// ListCommand prints the jail's bans, one per line, with their expiry.
+const ListCommand = "sudo fail2ban-client get " + Jail + " banip --with-time"
+
+// UnbanCommand lifts the bans of ips, or every ban when ips is empty.
+func UnbanCommand(ips []string) string {
+	if len(ips) == 0 {
+		return "sudo fail2ban-client unban --all"
+	}
+	return "sudo fail2ban-client set " + Jail + " unbanip " + strings.Join(ips, " ")
+}
+
+// ParseBans reads the output of ListCommand. Lines look like
+// "203.0.113.7 	2026-10-16 09:00:00 + 600 = 2026-10-16 09:10:00"; the
+// times are the relay's local time, UTC on provisioned relays.
+func ParseBans(out string) []Ban {
+	var bans []Ban
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		b := Ban{IP: fields[0]}
+		if n := len(fields); n >= 4 && fields[n-3] == "=" {
+			if t, err := time.Parse("2006-01-02 15:04:05", fields[n-2]+" "+fields[n-1]); err == nil {
+				b.Until = &t
+			}
+		}
+		bans = append(bans, b)
+	}
+	return bans
+}
@@ -3,10 +3,14 @@ package terraform
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"text/template"
+	"text/template/parse"
 )
 
 //go:embed cloud-init.yaml.tmpl
@@ -26,7 +30,8 @@ var installScriptTmpl string
 
 // Pinned versions — keeps relay setup reproducible even when upstream releases new versions.
 const (
-	XrayVersion = "v1.8.24" // must stay compatible with the xray-core in go.mod
+	XrayVersion      = "v1.8.24" // must stay compatible with the xray-core in go.mod
+	TerraformVersion = "1.9.8"   // downloaded when no terraform is on the PATH
 )
 
 // Config holds all values needed to render relay files.
@@ -39,12 +44,282 @@ type Config struct {
 	Provider      string // "aws", "hetzner", or "digitalocean"
 	CaddyCertsB64 string // base64-encoded tar.gz of saved Caddy TLS certs (optional)
 	XrayVersion   string // populated automatically from the pinned constant
+
+	// ACME DNS-01 challenge (optional). When set, Caddy is rebuilt with the
+	// github.com/caddy-dns/<provider> module and issues certificates through
+	// the provider's API instead of over port 80.
+	ACMEDNSProvider string
+	ACMEDNSToken    string
+
+	// Transport is the Xray inbound transport: "splithttp" (default when
+	// empty) or "ws" for relays behind a CDN.
+	Transport string
+
+	// GeoIPAllow and GeoIPDeny are lower-case country codes for Xray
+	// routing rules on the VLESS inbound (see ops.relayGeoIPRules).
+	GeoIPAllow []string
+	GeoIPDeny  []string
+
+	// Fail2banJailB64 and Fail2banFilterB64 are the base64-encoded files
+	// from package fail2ban. When set, the relay runs fail2ban on Caddy's
+	// access log.
+	Fail2banJailB64   string
+	Fail2banFilterB64 string
+
+	// UFWRules are extra "ufw allow" arguments from the relay firewall
+	// settings. SSHOpen makes sshd listen on all addresses rather than only
+	// loopback; UFWRules then includes the port 22 rules.
+	UFWRules []string
+	SSHOpen  bool
+
+	// Region, InstanceType and Arch are written to terraform.tfvars; empty
+	// ones keep the defaults in main.tf. Arch is "amd64" or "arm64" and
+	// must match the instance type. Only AWS needs it, to pick the image;
+	// the scripts on the relay install the builds for its architecture.
+	Region       string
+	InstanceType string
+	Arch         string
+
+	// TemplateDir holds template overrides: a file there named like one of
+	// Templates replaces the embedded template. Empty uses only the
+	// embedded ones.
+	TemplateDir string
+}
+
+// tfvarNames maps each provider's region and instance type Terraform
+// variables.
+var tfvarNames = map[string]struct{ region, instanceType string }{
+	"aws":          {"region", "instance_type"},
+	"hetzner":      {"location", "server_type"},
+	"digitalocean": {"region", "size"},
+}
+
+// FirewallVarsFile holds the values of the open_ports and ssh_sources
+// variables every main.tf declares. Terraform loads *.auto.tfvars.json
+// files by itself.
+const FirewallVarsFile = "firewall.auto.tfvars.json"
+
+// FirewallVars are the relay's extra cloud firewall rules.
+type FirewallVars struct {
+	OpenPorts  []FirewallPort `json:"open_ports"`
+	SSHSources []string       `json:"ssh_sources"`
+}
+
+// FirewallPort is one entry of open_ports. Sources lists CIDRs and must not
+// be empty: everyone is "0.0.0.0/0" and "::/0".
+type FirewallPort struct {
+	Port     int      `json:"port"`
+	Protocol string   `json:"protocol"`
+	Sources  []string `json:"sources"`
+}
+
+// WriteFirewallVars writes vars to FirewallVarsFile in dir.
+func WriteFirewallVars(dir string, vars FirewallVars) error {
+	// Terraform reads null as "no value" rather than an empty list.
+	if vars.OpenPorts == nil {
+		vars.OpenPorts = []FirewallPort{}
+	}
+	if vars.SSHSources == nil {
+		vars.SSHSources = []string{}
+	}
+	data, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, FirewallVarsFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", FirewallVarsFile, err)
+	}
+	return nil
+}
+
+// HasFirewallVars reports whether the main.tf in dir declares the firewall
+// variables. Relays provisioned by older versions don't.
+func HasFirewallVars(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	return err == nil && strings.Contains(string(data), `variable "open_ports"`)
+}
+
+// AdoptOverrideFile is written next to main.tf for a relay adopted from an
+// existing server. Terraform merges *_override.tf files into main.tf, so
+// attributes the server was created with elsewhere (image, size, region,
+// user data) don't force its replacement.
+const AdoptOverrideFile = "adopt_override.tf"
+
+var adoptOverrides = map[string]string{
+	"aws": `resource "aws_instance" "relay" {
+  lifecycle {
+    ignore_changes = [ami, instance_type, user_data, root_block_device, key_name, subnet_id, associate_public_ip_address, tags]
+  }
+}
+`,
+	"hetzner": `resource "hcloud_server" "relay" {
+  lifecycle {
+    ignore_changes = [name, image, server_type, location, user_data, ssh_keys]
+  }
+}
+`,
+	"digitalocean": `resource "digitalocean_droplet" "relay" {
+  lifecycle {
+    ignore_changes = [name, image, size, region, user_data, ssh_keys]
+  }
+}
+`,
+}
+
+// WriteAdoptOverride writes AdoptOverrideFile for provider into dir.
+func WriteAdoptOverride(dir, provider string) error {
+	content, ok := adoptOverrides[provider]
+	if !ok {
+		return fmt.Errorf("unknown provider: %s", provider)
+	}
+	if err := os.WriteFile(filepath.Join(dir, AdoptOverrideFile), []byte(content), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", AdoptOverrideFile, err)
+	}
+	return nil
+}
+
+// Template names, as embedded and as looked up in Config.TemplateDir. A
+// provider's main.tf template is "<provider>.tf.tmpl"; unlike the others it
+// is plain Terraform, copied as is.
+const (
+	CloudInitTemplate     = "cloud-init.yaml.tmpl"
+	InstallScriptTemplate = "install-script.sh.tmpl"
+)
+
+var embeddedTemplates = map[string]string{
+	CloudInitTemplate:      cloudInitTmpl,
+	InstallScriptTemplate:  installScriptTmpl,
+	"aws.tf.tmpl":          awsTfTmpl,
+	"digitalocean.tf.tmpl": digitaloceanTfTmpl,
+	"hetzner.tf.tmpl":      hetznerTfTmpl,
+}
+
+// Templates returns the names of the templates that can be overridden.
+func Templates() []string {
+	return []string{CloudInitTemplate, InstallScriptTemplate, "aws.tf.tmpl", "digitalocean.tf.tmpl", "hetzner.tf.tmpl"}
 }
 
-var providerTemplates = map[string]string{
-	"aws":          awsTfTmpl,
-	"hetzner":      hetznerTfTmpl,
-	"digitalocean": digitaloceanTfTmpl,
+// EmbeddedTemplate returns the built-in template called name.
+func EmbeddedTemplate(name string) (string, bool) {
+	t, ok := embeddedTemplates[name]
+	return t, ok
+}
+
+// requiredFields are the Config fields the cloud-init and install script
+// templates must reference: without them the relay can't serve the tunnel
+// or be reached over SSH.
+var requiredFields = []string{"Domain", "UUID", "XrayPath", "SSHUser", "PublicKey"}
+
+// requiredBlocks are what each provider's main.tf must keep: tw detects
+// the provider, sets these variables, reads relay_ip, imports adopted
+// servers as the relay resource, and writes cloud-init.yaml for it.
+var requiredBlocks = map[string][]string{
+	"aws.tf.tmpl": {`provider "aws"`, `variable "region"`, `variable "instance_type"`, `variable "arch"`,
+		`variable "open_ports"`, `variable "ssh_sources"`, `resource "aws_instance" "relay"`, `output "relay_ip"`, `cloud-init.yaml`},
+	"digitalocean.tf.tmpl": {`provider "digitalocean"`, `variable "do_token"`, `variable "region"`, `variable "size"`,
+		`variable "open_ports"`, `variable "ssh_sources"`, `resource "digitalocean_droplet" "relay"`, `output "relay_ip"`, `cloud-init.yaml`},
+	"hetzner.tf.tmpl": {`provider "hcloud"`, `variable "hcloud_token"`, `variable "location"`, `variable "server_type"`,
+		`variable "open_ports"`, `variable "ssh_sources"`, `resource "hcloud_server" "relay"`, `output "relay_ip"`, `cloud-init.yaml`},
+}
+
+// CheckTemplate reports whether content can stand in for the template
+// called name: it must parse and keep the fields or Terraform blocks tw
+// relies on.
+func CheckTemplate(name, content string) error {
+	if blocks, ok := requiredBlocks[name]; ok {
+		var missing []string
+		for _, b := range blocks {
+			re := regexp.MustCompile(strings.ReplaceAll(regexp.QuoteMeta(b), " ", `\s+`))
+			if !re.MatchString(content) {
+				missing = append(missing, b)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%s is missing %s", name, strings.Join(missing, ", "))
+		}
+		return nil
+	}
+	if _, ok := embeddedTemplates[name]; !ok {
+		return fmt.Errorf("unknown template: %s", name)
+	}
+
+	t, err := template.New(name).Parse(content)
+	if err != nil {
+		return err
+	}
+	fields := map[string]bool{}
+	collectFields(t.Root, fields)
+	var missing []string
+	for _, f := range requiredFields {
+		if !fields[f] {
+			missing = append(missing, "{{."+f+"}}")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s does not reference %s", name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// collectFields adds the names of the fields referenced under node to
+// fields.
+func collectFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, c := range n.Nodes {
+				collectFields(c, fields)
+			}
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, c := range n.Cmds {
+				collectFields(c, fields)
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			collectFields(a, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.IfNode:
+		collectBranchFields(&n.BranchNode, fields)
+	case *parse.RangeNode:
+		collectBranchFields(&n.BranchNode, fields)
+	case *parse.WithNode:
+		collectBranchFields(&n.BranchNode, fields)
+	}
+}
+
+func collectBranchFields(n *parse.BranchNode, fields map[string]bool) {
+	collectFields(n.Pipe, fields)
+	collectFields(n.List, fields)
+	collectFields(n.ElseList, fields)
+}
+
+// loadTemplate returns the template called name: the override in dir when
+// there is one, after CheckTemplate, else the embedded template.
+func loadTemplate(dir, name string) (string, error) {
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			if err := CheckTemplate(name, string(data)); err != nil {
+				return "", fmt.Errorf("template override %s: %w", filepath.Join(dir, name), err)
+			}
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("reading template override: %w", err)
+		}
+	}
+	t, ok := embeddedTemplates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown template: %s", name)
+	}
+	return t, nil
 }
 
 // Generate renders cloud-init.yaml and the selected provider's main.tf into dir.
@@ -55,30 +330,71 @@ func Generate(dir string, cfg Config) error {
 	}
 
 	// cloud-init.yaml — universal across all providers.
-	content, err := render("cloud-init.yaml", cloudInitTmpl, cfg)
+	content, err := CloudInit(cfg)
 	if err != nil {
-		return fmt.Errorf("rendering cloud-init.yaml: %w", err)
+		return err
 	}
-	if err := os.WriteFile(filepath.Join(dir, "cloud-init.yaml"), []byte(content), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "cloud-init.yaml"), []byte(content), 0600); err != nil {
 		return fmt.Errorf("writing cloud-init.yaml: %w", err)
 	}
 
 	// main.tf — only the selected provider.
-	tmpl, ok := providerTemplates[cfg.Provider]
-	if !ok {
+	if _, ok := tfvarNames[cfg.Provider]; !ok {
 		return fmt.Errorf("unknown provider: %s", cfg.Provider)
 	}
+	tmpl, err := loadTemplate(cfg.TemplateDir, cfg.Provider+".tf.tmpl")
+	if err != nil {
+		return err
+	}
 	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(tmpl), 0644); err != nil {
 		return fmt.Errorf("writing main.tf: %w", err)
 	}
 
+	// terraform.tfvars — region, instance type and architecture. Provider
+	// credentials reach Terraform through its environment instead.
+	names := tfvarNames[cfg.Provider]
+	var tfvars string
+	if cfg.Region != "" {
+		tfvars += fmt.Sprintf("%s = %q\n", names.region, cfg.Region)
+	}
+	if cfg.InstanceType != "" {
+		tfvars += fmt.Sprintf("%s = %q\n", names.instanceType, cfg.InstanceType)
+	}
+	if cfg.Arch != "" && cfg.Provider == "aws" {
+		tfvars += fmt.Sprintf("arch = %q\n", cfg.Arch)
+	}
+	if tfvars != "" {
+		if err := os.WriteFile(filepath.Join(dir, "terraform.tfvars"), []byte(tfvars), 0600); err != nil {
+			return fmt.Errorf("writing terraform.tfvars: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// CloudInit renders the relay's cloud-init.yaml, which every provider
+// passes to the server as user data.
+func CloudInit(cfg Config) (string, error) {
+	cfg.XrayVersion = XrayVersion
+	tmpl, err := loadTemplate(cfg.TemplateDir, CloudInitTemplate)
+	if err != nil {
+		return "", err
+	}
+	content, err := render("cloud-init.yaml", tmpl, cfg)
+	if err != nil {
+		return "", fmt.Errorf("rendering cloud-init.yaml: %w", err)
+	}
+	return content, nil
+}
+
 // GenerateInstallScript renders the manual install bash script with the given config.
 func GenerateInstallScript(cfg Config) (string, error) {
 	cfg.XrayVersion = XrayVersion
-	return render("install-script.sh", installScriptTmpl, cfg)
+	tmpl, err := loadTemplate(cfg.TemplateDir, InstallScriptTemplate)
+	if err != nil {
+		return "", err
+	}
+	return render("install-script.sh", tmpl, cfg)
 }
 
 func render(name, tmplStr string, cfg Config) (string, error) {
@@ -0,0 +1,206 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Values for the credential_store setting.
+const (
+	StoreFile     = "file"
+	StoreKeychain = "keychain"
+	StoreAuto     = "auto"
+)
+
+// keychainService groups tw's items in the OS keychain (macOS Keychain,
+// Windows Credential Manager, or a Secret Service such as GNOME Keyring).
+// Each secret is one item; keychainIndex lists their names, since the
+// keychains can't be enumerated portably.
+const (
+	keychainService = "tunnel-whisperer"
+	keychainIndex   = ".index"
+)
+
+var (
+	backend  = StoreFile // where values are kept
+	explicit bool        // credential_store was set to "file" rather than left empty
+)
+
+// Configure selects where values are kept, from the credential_store
+// setting. "auto" uses the keychain when one is reachable, so headless
+// machines without a Secret Service keep using secrets.age. An unreachable
+// keychain with "keychain" also falls back to the file and is reported as
+// an error.
+func Configure(store string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	backend = StoreFile
+	explicit = store == StoreFile
+	switch store {
+	case "", StoreFile:
+	case StoreKeychain:
+		if err := probeKeychain(); err != nil {
+			return fmt.Errorf("OS keychain unavailable, using %s: %w", Path(), err)
+		}
+		backend = StoreKeychain
+	case StoreAuto:
+		if probeKeychain() == nil {
+			backend = StoreKeychain
+		}
+	default:
+		return fmt.Errorf("unknown credential store %q", store)
+	}
+	return nil
+}
+
+// Backend returns where values are kept: StoreFile or StoreKeychain.
+func Backend() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return backend
+}
+
+// Relocate moves values kept by the other backend into the one in use:
+// secrets.age into the keychain after switching to it, or back again when
+// credential_store is set to "file". It returns how many values moved and
+// where from.
+func Relocate() (int, string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var (
+		from    string
+		src     map[string]string
+		err     error
+		cleanup func() error
+	)
+	switch {
+	case backend == StoreKeychain:
+		if _, statErr := os.Stat(Path()); statErr != nil {
+			return 0, "", nil
+		}
+		from = Path()
+		src, err = loadFile()
+		cleanup = func() error {
+			if err := os.Remove(Path()); err != nil {
+				return err
+			}
+			if err := os.Remove(KeyPath()); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+	case explicit && probeKeychain() == nil:
+		from = "the OS keychain"
+		src, err = loadKeychain()
+		cleanup = func() error { return saveKeychain(map[string]string{}) }
+	default:
+		return 0, "", nil
+	}
+	if err != nil || len(src) == 0 {
+		return 0, from, err
+	}
+
+	m, err := load()
+	if err != nil {
+		return 0, from, err
+	}
+	for name, value := range src {
+		m[name] = value
+	}
+	if err := save(m); err != nil {
+		return 0, from, err
+	}
+	if err := cleanup(); err != nil {
+		return len(src), from, fmt.Errorf("removing secrets from %s: %w", from, err)
+	}
+	return len(src), from, nil
+}
+
+// probeKeychain checks that the OS keychain answers.
+func probeKeychain() error {
+	_, err := keyring.Get(keychainService, keychainIndex)
+	if err == nil || errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// loadKeychain reads every item listed in the keychain index.
+func loadKeychain() (map[string]string, error) {
+	names, err := keychainNames()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		v, err := keyring.Get(keychainService, name)
+		if errors.Is(err, keyring.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from the keychain: %w", name, err)
+		}
+		m[name] = v
+	}
+	return m, nil
+}
+
+// saveKeychain writes m as one item per name, removes items no longer in
+// it, and rewrites the index.
+func saveKeychain(m map[string]string) error {
+	old, err := keychainNames()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(m))
+	for name, value := range m {
+		if err := keyring.Set(keychainService, name, value); err != nil {
+			return fmt.Errorf("writing %s to the keychain: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	for _, name := range old {
+		if _, ok := m[name]; ok {
+			continue
+		}
+		if err := keyring.Delete(keychainService, name); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("removing %s from the keychain: %w", name, err)
+		}
+	}
+
+	if len(names) == 0 {
+		if err := keyring.Delete(keychainService, keychainIndex); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("writing keychain index: %w", err)
+		}
+		return nil
+	}
+	index, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("encoding keychain index: %w", err)
+	}
+	if err := keyring.Set(keychainService, keychainIndex, string(index)); err != nil {
+		return fmt.Errorf("writing keychain index: %w", err)
+	}
+	return nil
+}
+
+// keychainNames returns the names listed in the keychain index.
+func keychainNames() ([]string, error) {
+	data, err := keyring.Get(keychainService, keychainIndex)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading keychain index: %w", err)
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(data), &names); err != nil {
+		return nil, fmt.Errorf("parsing keychain index: %w", err)
+	}
+	return names, nil
+}
@@ -0,0 +1,248 @@
+// Package secrets keeps credentials out of config.yaml and the relay
+// directory: cloud API tokens, proxy passwords, dashboard passwords, and a
+// client's SSH private key. By default they are stored age-encrypted in
+// secrets.age in the config directory, and the age identity that decrypts
+// it lives in secrets.key, readable only by its owner. Configure can move
+// them into the OS keychain instead.
+//
+// Config values may refer to a secret as "secret:<name>"; Resolve returns
+// the stored value, so code that needs a credential fetches it on demand.
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/tunnelwhisperer/tw/internal/config"
+	"github.com/tunnelwhisperer/tw/internal/fileutil"
+)
+
+// Prefix marks a config value that refers to the store.
+const Prefix = "secret:"
+
+// Names of the secrets tw stores itself.
+const (
+	NameProxy             = "proxy" // proxy URL with its credentials
+	NameAWSAccessKeyID    = "cloud/aws/access_key_id"
+	NameAWSSecretKey      = "cloud/aws/secret_access_key"
+	NameDashboardPassword = "dashboard/password"
+	NameSSHKey            = "ssh/id_ed25519" // a client's private key
+	NameTOTP              = "totp"           // a client user's TOTP secret, to answer the server unattended
+)
+
+// CloudToken is the name of a cloud provider's API token, e.g.
+// "cloud/hetzner".
+func CloudToken(provider string) string {
+	return "cloud/" + provider
+}
+
+// ErrNotFound is returned by Get for a name that isn't stored.
+var ErrNotFound = errors.New("secret not found")
+
+// mu serializes reads and writes of the store within the process.
+var mu sync.Mutex
+
+// Path returns the encrypted store used by the file backend.
+func Path() string {
+	return filepath.Join(config.Dir(), "secrets.age")
+}
+
+// KeyPath returns the file holding the age identity.
+func KeyPath() string {
+	return filepath.Join(config.Dir(), "secrets.key")
+}
+
+// Ref returns the config value that refers to name.
+func Ref(name string) string {
+	return Prefix + name
+}
+
+// IsRef reports whether a config value refers to the store.
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Resolve returns the stored value for a "secret:<name>" reference, and any
+// other value unchanged.
+func Resolve(value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, Prefix)
+	v, err := Get(name)
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", name, err)
+	}
+	return v, nil
+}
+
+// Get returns the value stored under name.
+func Get(name string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	m, err := load()
+	if err != nil {
+		return "", err
+	}
+	v, ok := m[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// Set stores value under name, replacing any previous value.
+func Set(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("secret name is required")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	m, err := load()
+	if err != nil {
+		return err
+	}
+	m[name] = value
+	return save(m)
+}
+
+// Delete removes name from the store. Deleting a missing name is not an
+// error.
+func Delete(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	m, err := load()
+	if err != nil {
+		return err
+	}
+	if _, ok := m[name]; !ok {
+		return nil
+	}
+	delete(m, name)
+	return save(m)
+}
+
+// Names lists the stored secrets, sorted. Values are not returned.
+func Names() ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	m, err := load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load reads every value from the backend in use.
+func load() (map[string]string, error) {
+	if backend == StoreKeychain {
+		return loadKeychain()
+	}
+	return loadFile()
+}
+
+// save replaces every value in the backend in use.
+func save(m map[string]string) error {
+	if backend == StoreKeychain {
+		return saveKeychain(m)
+	}
+	return saveFile(m)
+}
+
+// loadFile decrypts secrets.age. A missing file is empty.
+func loadFile() (map[string]string, error) {
+	data, err := os.ReadFile(Path())
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading secrets: %w", err)
+	}
+	id, err := identity(false)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), id)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secrets: %w", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secrets: %w", err)
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal(plain, &m); err != nil {
+		return nil, fmt.Errorf("parsing secrets: %w", err)
+	}
+	return m, nil
+}
+
+// saveFile encrypts m and replaces secrets.age atomically.
+func saveFile(m map[string]string) error {
+	id, err := identity(true)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encoding secrets: %w", err)
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, id.Recipient())
+	if err != nil {
+		return fmt.Errorf("encrypting secrets: %w", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		return fmt.Errorf("encrypting secrets: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("encrypting secrets: %w", err)
+	}
+
+	if err := fileutil.WriteFile(Path(), buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("writing secrets: %w", err)
+	}
+	return nil
+}
+
+// identity reads the age identity, generating it on first use when create
+// is set.
+func identity(create bool) (*age.X25519Identity, error) {
+	data, err := os.ReadFile(KeyPath())
+	if err == nil {
+		id, err := age.ParseX25519Identity(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", KeyPath(), err)
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, fmt.Errorf("reading secrets key: %w", err)
+	}
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("generating secrets key: %w", err)
+	}
+	if err := os.MkdirAll(config.Dir(), 0755); err != nil {
+		return nil, fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := os.WriteFile(KeyPath(), []byte(id.String()+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("writing secrets key: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,88 @@
+// Package service installs tw as a system service that runs `tw run` at
+// boot, the way the client installers set up `tw connect`: a systemd unit
+// on Linux, a launch daemon on macOS and a scheduled task running as SYSTEM
+// on Windows. On Windows it also manages the firewall rule that lets the
+// ports tw listens on through.
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// unitName is the systemd unit on Linux.
+	unitName = "tw"
+
+	// TaskName is the scheduled task on Windows.
+	TaskName = "Tunnel Whisperer"
+
+	// FirewallRule names the Windows Firewall rule for tw's ports.
+	FirewallRule = "Tunnel Whisperer"
+)
+
+// Options describes the service to install.
+type Options struct {
+	Exe       string // absolute path of the tw binary
+	ConfigDir string // TW_CONFIG_DIR for the service; empty for the default
+	Ports     []int  // TCP ports to allow in through the firewall
+	Firewall  bool   // add the firewall rule (Windows)
+
+	// User installs the service for the current user, running tw run
+	// --user, in place of a system one: it needs no root, starts at login
+	// and never touches the firewall.
+	User bool
+}
+
+// args returns the arguments the service runs tw with.
+func (o Options) args() []string {
+	if o.User {
+		return []string{"run", "--user"}
+	}
+	return []string{"run"}
+}
+
+// commandLine returns the command the service runs.
+func (o Options) commandLine() string {
+	return o.Exe + " " + strings.Join(o.args(), " ")
+}
+
+// rootHint says what is needed to change a system service.
+func rootHint(o Options) string {
+	if o.User {
+		return ""
+	}
+	return " (run as root)"
+}
+
+// Executable returns the absolute path of the running tw binary, for
+// Options.Exe.
+func Executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("finding the tw binary: %w", err)
+	}
+	return exe, nil
+}
+
+// run runs a command and returns its error with its output, which is where
+// systemctl, netsh and PowerShell say what went wrong.
+func run(name string, args ...string) error {
+	out, err := command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %s", name, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func portList(ports []int) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = fmt.Sprint(p)
+	}
+	return strings.Join(s, ",")
+}
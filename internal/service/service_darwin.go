@@ -0,0 +1,134 @@
+//go:build darwin
+
+package service
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FirewallManaged tells whether Install opens the firewall here.
+const FirewallManaged = false
+
+var command = exec.Command
+
+// label is the launchd job.
+const label = "com.tunnelwhisperer.tw"
+
+// paths returns where the job's definition and its output go: a launch
+// daemon and /Library/Logs, or with opts.User a launch agent and the
+// user's ~/Library/Logs.
+func paths(opts Options) (plist, log string, err error) {
+	if !opts.User {
+		return "/Library/LaunchDaemons/" + label + ".plist", "/Library/Logs/tw.log", nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", label+".plist"),
+		filepath.Join(home, "Library", "Logs", "tw.log"), nil
+}
+
+// domain returns the launchd domain the job runs in.
+func domain(opts Options) string {
+	if opts.User {
+		return fmt.Sprintf("gui/%d", os.Getuid())
+	}
+	return "system"
+}
+
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+%s	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// Install writes the tw launch daemon (or agent) and loads it, so launchd
+// starts tw run now and at boot (or login), and again when it fails. The
+// application firewall asks about incoming connections itself, so it is
+// left alone.
+func Install(opts Options) error {
+	plistPath, logPath, err := paths(opts)
+	if err != nil {
+		return err
+	}
+	var args strings.Builder
+	for _, a := range append([]string{opts.Exe}, opts.args()...) {
+		fmt.Fprintf(&args, "\t\t<string>%s</string>\n", html.EscapeString(a))
+	}
+	env := ""
+	if opts.ConfigDir != "" {
+		env = fmt.Sprintf("\t<key>EnvironmentVariables</key>\n\t<dict>\n\t\t<key>TW_CONFIG_DIR</key>\n\t\t<string>%s</string>\n\t</dict>\n",
+			html.EscapeString(opts.ConfigDir))
+	}
+	plist := fmt.Sprintf(plistTemplate, label, args.String(), env, html.EscapeString(logPath), html.EscapeString(logPath))
+	// A job loaded before is replaced with the new definition.
+	command("launchctl", "bootout", domain(opts)+"/"+label).Run()
+	if err := os.MkdirAll(filepath.Dir(plistPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(plistPath, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("writing the launch job%s: %w", sudoHint(opts), err)
+	}
+	return run("launchctl", "bootstrap", domain(opts), plistPath)
+}
+
+// Uninstall unloads the tw launch job and removes it.
+func Uninstall(opts Options) error {
+	plistPath, _, err := paths(opts)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
+		return fmt.Errorf("the %s launch job is not installed", label)
+	}
+	if err := run("launchctl", "bootout", domain(opts)+"/"+label); err != nil {
+		return err
+	}
+	if err := os.Remove(plistPath); err != nil {
+		return fmt.Errorf("removing the launch job%s: %w", sudoHint(opts), err)
+	}
+	return nil
+}
+
+// Describe says where the service is, for tw service install to print.
+func Describe(opts Options) string {
+	plistPath, logPath, _ := paths(opts)
+	kind := "launch daemon"
+	if opts.User {
+		kind = "launch agent"
+	}
+	return fmt.Sprintf("%s %s (logs in %s)", kind, filepath.Base(plistPath), logPath)
+}
+
+func sudoHint(opts Options) string {
+	if opts.User {
+		return ""
+	}
+	return " (run with sudo)"
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// FirewallManaged tells whether Install opens the firewall here.
+const FirewallManaged = false
+
+var command = exec.Command
+
+const unitDir = "/etc/systemd/system"
+
+const unitTemplate = `[Unit]
+Description=Tunnel Whisperer
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+%sExecStart=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=%s
+`
+
+// unitPath returns where the unit goes: the system's units, or with
+// opts.User the user's own, run by the user's systemd instance.
+func unitPath(opts Options) (string, error) {
+	if !opts.User {
+		return filepath.Join(unitDir, unitName+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", unitName+".service"), nil
+}
+
+// systemctl runs systemctl on the system's or, with opts.User, the user's
+// systemd instance.
+func systemctl(opts Options, args ...string) error {
+	if opts.User {
+		args = append([]string{"--user"}, args...)
+	}
+	return run("systemctl", args...)
+}
+
+// Install writes the tw systemd unit, enables it and starts it. There is
+// no firewall to open on Linux: ports are only filtered where the admin
+// set that up.
+func Install(opts Options) error {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return fmt.Errorf("systemd is not running; start tw run from your init system instead")
+	}
+	path, err := unitPath(opts)
+	if err != nil {
+		return err
+	}
+	env := ""
+	if opts.ConfigDir != "" {
+		env = "Environment=TW_CONFIG_DIR=" + opts.ConfigDir + "\n"
+	}
+	target := "multi-user.target"
+	if opts.User {
+		target = "default.target"
+	}
+	unit := fmt.Sprintf(unitTemplate, env, opts.commandLine(), target)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("writing the unit%s: %w", rootHint(opts), err)
+	}
+	if err := systemctl(opts, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(opts, "enable", "--now", unitName)
+}
+
+// Uninstall stops and disables the tw unit and removes it.
+func Uninstall(opts Options) error {
+	path, err := unitPath(opts)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("the %s service is not installed", unitName)
+	}
+	if err := systemctl(opts, "disable", "--now", unitName); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("removing the unit%s: %w", rootHint(opts), err)
+	}
+	return systemctl(opts, "daemon-reload")
+}
+
+// Describe says where the service is, for tw service install to print.
+func Describe(opts Options) string {
+	if opts.User {
+		return fmt.Sprintf("user systemd unit %s (journalctl --user -u %s -f for its logs; "+
+			"loginctl enable-linger to start it at boot rather than at login)", unitName, unitName)
+	}
+	return fmt.Sprintf("systemd unit %s (journalctl -u %s -f for its logs)", unitName, unitName)
+}
@@ -0,0 +1,29 @@
+//go:build !linux && !windows && !darwin
+
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// FirewallManaged tells whether Install opens the firewall here.
+const FirewallManaged = false
+
+var command = exec.Command
+
+// Install is not supported here yet.
+func Install(opts Options) error {
+	return fmt.Errorf("tw service is not supported on %s; start tw run from your init system instead", runtime.GOOS)
+}
+
+// Uninstall is not supported here yet.
+func Uninstall(opts Options) error {
+	return fmt.Errorf("tw service is not supported on %s", runtime.GOOS)
+}
+
+// Describe says where the service is.
+func Describe(opts Options) string {
+	return ""
+}
@@ -0,0 +1,121 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// FirewallManaged tells whether Install opens the firewall here.
+const FirewallManaged = true
+
+// command runs a command without flashing a console window.
+func command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd
+}
+
+// taskScript registers and starts the scheduled task with the settings the
+// client installer uses: at startup, as SYSTEM, restarted every minute when
+// it exits, with no time limit. A user's task runs at their logon, as them.
+const taskScript = `$ErrorActionPreference = 'Stop'
+if (Get-ScheduledTask -TaskName '%[1]s' -ErrorAction SilentlyContinue) {
+    Stop-ScheduledTask -TaskName '%[1]s'
+}
+$action = New-ScheduledTaskAction -Execute '%[2]s' -Argument '%[4]s'
+%[5]s
+$settings = New-ScheduledTaskSettingsSet -RestartCount 999 -RestartInterval (New-TimeSpan -Minutes 1) -ExecutionTimeLimit ([TimeSpan]::Zero) -AllowStartIfOnBatteries -DontStopIfGoingOnBatteries
+Register-ScheduledTask -TaskName '%[1]s' -Action $action -Trigger $trigger -Principal $principal -Settings $settings -Force | Out-Null
+%[3]sStart-ScheduledTask -TaskName '%[1]s'
+`
+
+const untaskScript = `$ErrorActionPreference = 'Stop'
+Stop-ScheduledTask -TaskName '%[1]s'
+Unregister-ScheduledTask -TaskName '%[1]s' -Confirm:$false
+`
+
+// Install registers the tw scheduled task and starts it. With
+// opts.Firewall, it first replaces the firewall rule for tw with one that
+// allows opts.Ports in, so Windows doesn't block them silently: a service
+// gets no prompt to allow them.
+func Install(opts Options) error {
+	if opts.Firewall && !opts.User && len(opts.Ports) > 0 {
+		if err := addFirewallRule(opts.Exe, opts.Ports); err != nil {
+			return err
+		}
+	}
+	// A scheduled task has no environment of its own, so a config directory
+	// other than the default is set for the machine, or the user.
+	scope, who := "Machine", systemTask
+	if opts.User {
+		scope, who = "User", userTask
+	}
+	env := ""
+	if opts.ConfigDir != "" {
+		env = fmt.Sprintf("[Environment]::SetEnvironmentVariable('TW_CONFIG_DIR', '%s', '%s')\n", psQuote(opts.ConfigDir), scope)
+	}
+	return powershell(fmt.Sprintf(taskScript, TaskName, psQuote(opts.Exe), env, strings.Join(opts.args(), " "), who))
+}
+
+// The trigger and principal of a system task and of a user's.
+const (
+	systemTask = `$trigger = New-ScheduledTaskTrigger -AtStartup
+$principal = New-ScheduledTaskPrincipal -UserId 'SYSTEM' -LogonType ServiceAccount -RunLevel Highest`
+	userTask = `$trigger = New-ScheduledTaskTrigger -AtLogOn -User $env:USERNAME
+$principal = New-ScheduledTaskPrincipal -UserId $env:USERNAME -LogonType Interactive -RunLevel Limited`
+)
+
+// Uninstall stops and removes the scheduled task and, with opts.Firewall,
+// the firewall rule.
+func Uninstall(opts Options) error {
+	if err := powershell(fmt.Sprintf(untaskScript, TaskName)); err != nil {
+		return err
+	}
+	if opts.Firewall && !opts.User {
+		return removeFirewallRule()
+	}
+	return nil
+}
+
+// Describe says where the service is, for tw service install to print.
+func Describe(opts Options) string {
+	if opts.User {
+		return fmt.Sprintf("scheduled task %q, run at your logon (Task Scheduler)", TaskName)
+	}
+	return fmt.Sprintf("scheduled task %q (Task Scheduler)", TaskName)
+}
+
+// addFirewallRule replaces FirewallRule with an inbound rule allowing TCP
+// to ports for the tw binary.
+func addFirewallRule(exe string, ports []int) error {
+	removeFirewallRule()
+	err := run("netsh", "advfirewall", "firewall", "add", "rule",
+		"name="+FirewallRule, "dir=in", "action=allow", "enable=yes",
+		"program="+exe, "protocol=TCP", "localport="+portList(ports))
+	if err != nil {
+		return fmt.Errorf("adding the firewall rule (run from an elevated prompt): %w", err)
+	}
+	return nil
+}
+
+// removeFirewallRule deletes FirewallRule. A rule already gone is fine.
+func removeFirewallRule() error {
+	err := run("netsh", "advfirewall", "firewall", "delete", "rule", "name="+FirewallRule)
+	if err != nil && !strings.Contains(err.Error(), "No rules match") {
+		return fmt.Errorf("removing the firewall rule: %w", err)
+	}
+	return nil
+}
+
+func powershell(script string) error {
+	return run("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+}
+
+// psQuote escapes s for a single-quoted PowerShell string.
+func psQuote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
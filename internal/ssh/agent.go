@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// PublicKeyAuth returns the public key authentication for a connection:
+// with key, else the key read from keyPath, else, or when useAgent is set,
+// with the keys held by the SSH agent. The returned closer ends the agent
+// connection and must be called once the handshake is done.
+func PublicKeyAuth(key []byte, keyPath string, useAgent bool) (gossh.AuthMethod, io.Closer, error) {
+	if !useAgent {
+		if key == nil && keyPath != "" {
+			data, err := os.ReadFile(keyPath)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, nil, fmt.Errorf("reading private key: %w", err)
+			}
+			key = data
+		}
+		if key != nil {
+			signer, err := gossh.ParsePrivateKey(key)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing private key: %w", err)
+			}
+			return gossh.PublicKeys(signer), noAgent{}, nil
+		}
+	}
+
+	conn, err := dialAgent()
+	if err != nil {
+		if useAgent {
+			return nil, nil, fmt.Errorf("connecting to the SSH agent: %w", err)
+		}
+		return nil, nil, fmt.Errorf("no private key, and no SSH agent to use instead: %w", err)
+	}
+	return gossh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+// noAgent is the closer PublicKeyAuth returns when it uses a key.
+type noAgent struct{}
+
+func (noAgent) Close() error { return nil }
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+// dialAgent connects to the SSH agent at SSH_AUTH_SOCK.
+func dialAgent() (io.ReadWriteCloser, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	return net.Dial("unix", sock)
+}
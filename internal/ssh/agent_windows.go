@@ -0,0 +1,145 @@
+//go:build windows
+
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// openSSHAgentPipe is where the Windows OpenSSH agent service listens.
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// dialAgent connects to the SSH agent: the pipe in SSH_AUTH_SOCK when it
+// names one, else the Windows OpenSSH agent, else Pageant.
+func dialAgent() (io.ReadWriteCloser, error) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); strings.HasPrefix(sock, `\\.\pipe\`) {
+		return os.OpenFile(sock, os.O_RDWR, 0)
+	}
+	if f, err := os.OpenFile(openSSHAgentPipe, os.O_RDWR, 0); err == nil {
+		return f, nil
+	}
+	if pageantWindow() != 0 {
+		return &pageantConn{}, nil
+	}
+	return nil, errors.New("neither the OpenSSH agent service nor Pageant is running")
+}
+
+// Pageant takes agent requests as WM_COPYDATA messages naming a shared
+// memory mapping that holds the request, and writes its answer there.
+const (
+	pageantMaxMsg = 8192
+	pageantCopyID = 0x804e50ba
+	wmCopyData    = 0x004a
+	pageReadWrite = 0x04
+	fileMapWrite  = 0x02
+	invalidHandle = ^syscall.Handle(0)
+)
+
+var (
+	user32          = syscall.NewLazyDLL("user32.dll")
+	procFindWindow  = user32.NewProc("FindWindowW")
+	procSendMessage = user32.NewProc("SendMessageW")
+	procMoveMemory  = syscall.NewLazyDLL("kernel32.dll").NewProc("RtlMoveMemory")
+
+	pageantSeq atomic.Uint32 // makes mapping names unique within the process
+)
+
+// pageantWindow returns Pageant's window, or 0 when it isn't running.
+func pageantWindow() uintptr {
+	name, _ := syscall.UTF16PtrFromString("Pageant")
+	hwnd, _, _ := procFindWindow.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+// copyData is the COPYDATASTRUCT of a WM_COPYDATA message.
+type copyData struct {
+	data uintptr
+	size uint32
+	ptr  uintptr
+}
+
+// pageantQuery sends one agent request, length prefix included, to
+// Pageant and returns its answer in the same form.
+func pageantQuery(req []byte) ([]byte, error) {
+	if len(req) > pageantMaxMsg {
+		return nil, fmt.Errorf("agent request of %d bytes is too large for Pageant", len(req))
+	}
+	hwnd := pageantWindow()
+	if hwnd == 0 {
+		return nil, errors.New("Pageant is not running")
+	}
+
+	mapName := fmt.Sprintf("PageantRequest%08x%04x", os.Getpid(), pageantSeq.Add(1))
+	name16, _ := syscall.UTF16PtrFromString(mapName)
+	mapping, err := syscall.CreateFileMapping(invalidHandle, nil, pageReadWrite, 0, pageantMaxMsg, name16)
+	if err != nil {
+		return nil, fmt.Errorf("creating Pageant request mapping: %w", err)
+	}
+	defer syscall.CloseHandle(mapping)
+	view, err := syscall.MapViewOfFile(mapping, fileMapWrite, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mapping Pageant request: %w", err)
+	}
+	defer syscall.UnmapViewOfFile(view)
+
+	procMoveMemory.Call(view, uintptr(unsafe.Pointer(&req[0])), uintptr(len(req)))
+
+	// Pageant reads the mapping name as a NUL-terminated ANSI string.
+	nameBytes := append([]byte(mapName), 0)
+	cds := copyData{data: pageantCopyID, size: uint32(len(nameBytes)), ptr: uintptr(unsafe.Pointer(&nameBytes[0]))}
+	if ret, _, _ := procSendMessage.Call(hwnd, wmCopyData, 0, uintptr(unsafe.Pointer(&cds))); ret == 0 {
+		return nil, errors.New("Pageant refused the request")
+	}
+
+	var size [4]byte
+	procMoveMemory.Call(uintptr(unsafe.Pointer(&size[0])), view, 4)
+	n := binary.BigEndian.Uint32(size[:])
+	if n > pageantMaxMsg-4 {
+		return nil, fmt.Errorf("Pageant answered with %d bytes, more than fits", n)
+	}
+	resp := make([]byte, 4+n)
+	procMoveMemory.Call(uintptr(unsafe.Pointer(&resp[0])), view, uintptr(len(resp)))
+	return resp, nil
+}
+
+// pageantConn speaks the agent protocol to Pageant: each complete request
+// written is sent as one query, and the answer is read back.
+type pageantConn struct {
+	req, resp []byte
+}
+
+func (c *pageantConn) Write(p []byte) (int, error) {
+	c.req = append(c.req, p...)
+	for len(c.req) >= 4 {
+		n := 4 + int(binary.BigEndian.Uint32(c.req))
+		if len(c.req) < n {
+			break
+		}
+		resp, err := pageantQuery(c.req[:n])
+		c.req = c.req[n:]
+		if err != nil {
+			return 0, err
+		}
+		c.resp = append(c.resp, resp...)
+	}
+	return len(p), nil
+}
+
+func (c *pageantConn) Read(p []byte) (int, error) {
+	if len(c.resp) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.resp)
+	c.resp = c.resp[n:]
+	return n, nil
+}
+
+func (c *pageantConn) Close() error { return nil }
@@ -0,0 +1,128 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// An invited user has a tw user on the server but no key yet. Their tw
+// connects through the relay with the user's UUID, offers the key it has
+// just generated and, the key being unknown, is asked for the claim code
+// with keyboard-interactive auth. The right code registers the key
+// (Server.Claim). That connection can then only send ClaimRequest, which
+// is answered with the user's client config signed by the host key, as a
+// ConfigRequest is; the client connects anew to forward anything.
+
+// ClaimRequest is the global request type fetching a claimed config.
+const ClaimRequest = "claim@tw"
+
+// ClaimPrompt is the keyboard-interactive question asking for the claim
+// code.
+const ClaimPrompt = "Claim code: "
+
+// claimExtension names the permissions extension holding the client
+// config of a user whose invitation the connection claimed.
+const claimExtension = "tw-claim"
+
+// claimChallenge returns the auth error that asks a client offering key
+// as the invited user for their claim code, and claims the invitation
+// with it.
+func (s *Server) claimChallenge(user string, key gossh.PublicKey) error {
+	return &gossh.PartialSuccessError{
+		Next: gossh.ServerAuthCallbacks{
+			KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+				answers, err := challenge(conn.User(), "", []string{ClaimPrompt}, []bool{false})
+				if err != nil {
+					return nil, err
+				}
+				if len(answers) != 1 {
+					return nil, fmt.Errorf("no claim code from %q", user)
+				}
+				data, err := s.Claim(user, strings.TrimSpace(answers[0]), key)
+				if err != nil {
+					slog.Warn("invitation claim refused", "user", user, "remote", conn.RemoteAddr(), "error", err)
+					return nil, fmt.Errorf("claim refused for %q", user)
+				}
+				slog.Info("invitation claimed", "user", user, "remote", conn.RemoteAddr(), "key", gossh.FingerprintSHA256(key))
+				return &gossh.Permissions{Extensions: map[string]string{claimExtension: string(data)}}, nil
+			},
+		},
+	}
+}
+
+// serveClaim answers the ClaimRequest of a connection that claimed an
+// invitation, with the claimed config data, and refuses everything else.
+func (s *Server) serveClaim(chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request, data []byte) {
+	go func() {
+		for newChan := range chans {
+			newChan.Reject(gossh.Prohibited, "this connection only claims an invitation; connect again")
+		}
+	}()
+	for req := range reqs {
+		if req.Type != ClaimRequest || s.hostSigner == nil {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		sig, err := s.hostSigner.Sign(rand.Reader, data)
+		if err != nil {
+			slog.Warn("could not sign claimed config", "error", err)
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, gossh.Marshal(&signedConfig{Config: data, Signature: gossh.Marshal(sig)}))
+		return
+	}
+}
+
+// ClaimInvite claims the invitation of user with the claim code secret,
+// connecting to addr with signer, the key to register, and returns their
+// client config once its signature checks out against hostKey.
+func ClaimInvite(addr, user string, signer gossh.Signer, secret string, hostKey gossh.PublicKey) ([]byte, error) {
+	asked := false
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{
+			gossh.PublicKeys(signer),
+			gossh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				if len(questions) == 0 {
+					return nil, nil
+				}
+				if len(questions) != 1 || strings.TrimSpace(questions[0]) != strings.TrimSpace(ClaimPrompt) {
+					return nil, fmt.Errorf("the server asked %q instead of the claim code", questions)
+				}
+				asked = true
+				return []string{secret}, nil
+			}),
+		},
+		HostKeyCallback: gossh.FixedHostKey(hostKey),
+		Timeout:         15 * time.Second,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			if asked {
+				return nil, fmt.Errorf("the server refused the claim code — the invitation may have expired, been claimed already, or been renewed")
+			}
+			return nil, fmt.Errorf("the server has no invitation waiting for %q", user)
+		}
+		return nil, err
+	}
+	defer client.Close()
+
+	ok, payload, err := client.SendRequest(ClaimRequest, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the server sent no config for the claimed invitation")
+	}
+	// The connection is pinned to hostKey and the claim code works once,
+	// so the reply needs no nonce.
+	return verifySignedConfig(payload, nil, hostKey)
+}
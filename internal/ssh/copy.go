@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// copyBufferSize matches the SSH channel's maximum packet size, so one
+// read from a channel fills at most one buffer.
+const copyBufferSize = 32 << 10
+
+// copyBuffers holds the buffers forwarded connections copy through, shared
+// so that hundreds of connections don't each allocate and drop their own.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// copyConn copies src to dst until EOF or an error, like io.Copy, through a
+// pooled buffer. One side of every forwarded connection is an SSH channel,
+// so the kernel can't splice between them; the io.ReaderFrom and
+// io.WriterTo of a *net.TCPConn would then only fall back to a buffer of
+// their own, which is why both are hidden here.
+func copyConn(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// connCounters are the traffic counters of a forward, updated atomically
+// from its copy loops. In is toward the local side, out away from it.
+type connCounters struct {
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	errors       atomic.Int64
+	lastActivity atomic.Int64 // unix nanoseconds
+}
+
+// lastActive returns the time of the last write, or the zero time.
+func (c *connCounters) lastActive() time.Time {
+	if ns := c.lastActivity.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// countingWriter adds the bytes written to n and records the write time.
+type countingWriter struct {
+	w    io.Writer
+	n    *atomic.Int64
+	last *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	c.last.Store(time.Now().UnixNano())
+	return n, err
+}
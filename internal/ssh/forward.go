@@ -1,11 +1,13 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,11 +16,28 @@ import (
 
 // Mapping defines a single local-port → remote-host:port forwarding rule.
 type Mapping struct {
+	Name       string // optional, reported in MappingStats
 	LocalPort  int
 	RemoteHost string
 	RemotePort int
+	// ListenHost is the local address to listen on, 127.0.0.1 if empty.
+	ListenHost string
 }
 
+// listenAddr returns the local host:port the mapping listens on when it
+// listens on port.
+func (m Mapping) listenAddr(port int) string {
+	host := m.ListenHost
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// remapRange is how many ports above a taken local port RemapBusyPorts
+// tries.
+const remapRange = 10
+
 // ForwardTunnel connects to a remote SSH server and sets up multiple local
 // port forwards (-L) over a single SSH session.
 type ForwardTunnel struct {
@@ -28,15 +47,129 @@ type ForwardTunnel struct {
 	User string
 	// Path to the private key for authentication.
 	KeyPath string
-	// Port mappings to forward.
+	// PEM private key, used instead of KeyPath when set.
+	Key []byte
+	// UseAgent authenticates with the SSH agent's keys instead of Key or
+	// KeyPath. The agent is also used when neither holds a key.
+	UseAgent bool
+	// Port mappings to forward. Once Run has started, change them with
+	// AddMapping and RemoveMapping only.
 	Mappings []Mapping
+	// Keepalive, timeout, and backoff tuning.
+	Network NetworkOptions
+	// OnEvent, when set, is called as the connection goes up or down and
+	// before each reconnect.
+	OnEvent func(TunnelEvent)
+	// RemapBusyPorts makes a mapping whose local port is taken listen on
+	// the next free port up to remapRange above it. Otherwise the mapping
+	// waits for the port, retried on every keepalive tick, while the
+	// others run.
+	RemapBusyPorts bool
+	// TOTPCode returns the code to answer the server's TOTP prompt with,
+	// on each connect of a user who has a TOTP secret. nil can't answer.
+	TOTPCode func() (string, error)
+	// HostKey is the server's host key, pinned by the bundle. nil accepts
+	// any host key, for bundles from before it was recorded.
+	HostKey gossh.PublicKey
+
+	mu            sync.Mutex
+	client        *gossh.Client
+	serverVersion string // the server's SSH version string, once connected
+	listeners     []net.Listener
+	done          chan struct{}
+	connected     bool
+	lastErr       string
+	mappings      map[int]*mappingState // keyed by LocalPort
+	acceptWG      *sync.WaitGroup       // accept loops of the current connection
+	acceptDone    chan struct{}
+}
+
+// MappingStats reports the state and traffic of one port mapping. Byte
+// counts and last activity accumulate across reconnects.
+type MappingStats struct {
+	Name         string    `json:"name,omitempty"`
+	LocalPort    int       `json:"local_port"`
+	ListenAddr   string    `json:"listen_addr"`
+	BoundPort    int       `json:"bound_port,omitempty"` // where it listens instead, when LocalPort was taken
+	RemoteHost   string    `json:"remote_host"`
+	RemotePort   int       `json:"remote_port"`
+	Listening    bool      `json:"listening"`
+	ActiveConns  int       `json:"active_conns"`
+	BytesIn      int64     `json:"bytes_in"`  // remote → local
+	BytesOut     int64     `json:"bytes_out"` // local → remote
+	Errors       int64     `json:"errors"`    // connections that could not reach the remote end
+	LastActivity time.Time `json:"last_activity"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// mappingState is the live state behind MappingStats. listener, conns, and
+// lastErr are guarded by ForwardTunnel.mu; the counters are updated
+// atomically from the copy loops.
+type mappingState struct {
+	listener net.Listener
+	port     int  // the port listener is on
+	pending  bool // could not listen; retried on every keepalive tick
+	conns    map[*forwardedConn]struct{}
+	lastErr  string
+
+	connCounters
+}
+
+// forwardedConn is one accepted connection and its SSH channel.
+type forwardedConn struct {
+	local, remote net.Conn
+}
+
+// mappingStateLocked returns the state for a local port, creating it on
+// first use. ft.mu must be held.
+func (ft *ForwardTunnel) mappingStateLocked(localPort int) *mappingState {
+	if ft.mappings == nil {
+		ft.mappings = make(map[int]*mappingState)
+	}
+	st, ok := ft.mappings[localPort]
+	if !ok {
+		st = &mappingState{conns: make(map[*forwardedConn]struct{})}
+		ft.mappings[localPort] = st
+	}
+	return st
+}
+
+// Stats returns per-mapping state in the order of Mappings.
+func (ft *ForwardTunnel) Stats() []MappingStats {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	stats := make([]MappingStats, len(ft.Mappings))
+	for i, m := range ft.Mappings {
+		st := ft.mappingStateLocked(m.LocalPort)
+		stats[i] = MappingStats{
+			Name:         m.Name,
+			LocalPort:    m.LocalPort,
+			ListenAddr:   m.listenAddr(m.LocalPort),
+			RemoteHost:   m.RemoteHost,
+			RemotePort:   m.RemotePort,
+			Listening:    st.listener != nil,
+			ActiveConns:  len(st.conns),
+			BytesIn:      st.bytesIn.Load(),
+			BytesOut:     st.bytesOut.Load(),
+			Errors:       st.errors.Load(),
+			LastActivity: st.lastActive(),
+			Error:        st.lastErr,
+		}
+		if st.listener != nil && st.port != m.LocalPort {
+			stats[i].ListenAddr = m.listenAddr(st.port)
+			stats[i].BoundPort = st.port
+		}
+	}
+	return stats
+}
 
-	mu        sync.Mutex
-	client    *gossh.Client
-	listeners []net.Listener
-	done      chan struct{}
-	connected bool
-	lastErr   string
+// mappingList returns a copy of Mappings, which AddMapping and
+// RemoveMapping may change while the tunnel runs.
+func (ft *ForwardTunnel) mappingList() []Mapping {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return slices.Clone(ft.Mappings)
 }
 
 // Connected reports whether the tunnel currently has an active SSH connection.
@@ -46,6 +179,14 @@ func (ft *ForwardTunnel) Connected() bool {
 	return ft.connected
 }
 
+// ServerVersion returns the SSH version string of the server the tunnel
+// last connected to, or "" before it has.
+func (ft *ForwardTunnel) ServerVersion() string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.serverVersion
+}
+
 // LastError returns the most recent connection error, or "" if connected.
 func (ft *ForwardTunnel) LastError() string {
 	ft.mu.Lock()
@@ -55,10 +196,10 @@ func (ft *ForwardTunnel) LastError() string {
 
 // Run connects to the remote SSH server, starts all local listeners, and
 // forwards connections through SSH. It automatically reconnects with
-// exponential backoff on failure.
+// exponential backoff on failure, capped at
+// Network.MaxBackoff.
 func (ft *ForwardTunnel) Run() error {
 	ft.done = make(chan struct{})
-	backoff := time.Second * 2
 	attempt := 0
 
 	for {
@@ -75,33 +216,28 @@ func (ft *ForwardTunnel) Run() error {
 			ft.connected = false
 			ft.lastErr = err.Error()
 			ft.mu.Unlock()
+			emit(ft.OnEvent, TunnelEvent{Kind: "down", Error: err.Error()})
 			attempt++
 		} else {
-			backoff = time.Second * 2
 			attempt = 0
 		}
 
 		// Clean up before reconnecting.
 		ft.cleanup()
 
+		backoff := ft.Network.backoff(attempt)
+		select {
+		case <-ft.done:
+			return nil
+		default:
+		}
+		emit(ft.OnEvent, TunnelEvent{Kind: "reconnecting", Attempt: attempt, Backoff: backoff})
 		select {
 		case <-ft.done:
 			return nil
 		case <-time.After(backoff):
 			slog.Info("forward tunnel reconnecting", "backoff", backoff, "attempt", attempt)
 		}
-
-		// Gradual backoff: stay at each level for 4 attempts before escalating.
-		// 2s ×8, 4s ×4, 8s ×4, 16s ×4, then 30s forever.
-		if attempt >= 8 && backoff == 2*time.Second {
-			backoff = 4 * time.Second
-		} else if attempt >= 12 && backoff == 4*time.Second {
-			backoff = 8 * time.Second
-		} else if attempt >= 16 && backoff == 8*time.Second {
-			backoff = 16 * time.Second
-		} else if attempt >= 20 && backoff == 16*time.Second {
-			backoff = 30 * time.Second
-		}
 	}
 }
 
@@ -115,6 +251,10 @@ func (ft *ForwardTunnel) cleanup() {
 		l.Close()
 	}
 	ft.listeners = nil
+	for _, st := range ft.mappings {
+		st.listener = nil
+	}
+	ft.acceptWG = nil
 
 	if ft.client != nil {
 		ft.client.Close()
@@ -125,45 +265,45 @@ func (ft *ForwardTunnel) cleanup() {
 }
 
 func (ft *ForwardTunnel) connect() error {
-	keyData, err := os.ReadFile(ft.KeyPath)
-	if err != nil {
-		return fmt.Errorf("reading private key: %w", err)
-	}
-
-	signer, err := gossh.ParsePrivateKey(keyData)
+	auth, agentConn, err := PublicKeyAuth(ft.Key, ft.KeyPath, ft.UseAgent)
 	if err != nil {
-		return fmt.Errorf("parsing private key: %w", err)
+		return err
 	}
+	defer agentConn.Close()
 
 	sshConfig := &gossh.ClientConfig{
 		User: ft.User,
 		Auth: []gossh.AuthMethod{
-			gossh.PublicKeys(signer),
+			auth,
+			TOTPAuth(ft.TOTPCode),
 		},
-		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+		HostKeyCallback: ServerHostKeyCallback(ft.HostKey),
+		Timeout:         ft.Network.dialTimeout(),
 	}
+	var revoked string
+	sshConfig.BannerCallback = CatchRevoked(&revoked)
 
 	slog.Debug("forward tunnel connecting", "remote", ft.RemoteAddr, "user", ft.User)
 
-	conn, err := net.DialTimeout("tcp", ft.RemoteAddr, 10*time.Second)
+	conn, err := net.DialTimeout("tcp", ft.RemoteAddr, ft.Network.dialTimeout())
 	if err != nil {
 		return fmt.Errorf("dialing %s: %w", ft.RemoteAddr, err)
 	}
 
 	if tc, ok := conn.(*net.TCPConn); ok {
 		tc.SetKeepAlive(true)
-		tc.SetKeepAlivePeriod(30 * time.Second)
+		tc.SetKeepAlivePeriod(ft.Network.tcpKeepalivePeriod())
 	}
 
 	sshConn, chans, reqs, err := gossh.NewClientConn(conn, ft.RemoteAddr, sshConfig)
 	if err != nil {
 		conn.Close()
-		return fmt.Errorf("SSH handshake: %w", err)
+		return revokedHandshake(err, revoked)
 	}
 
 	ft.mu.Lock()
 	ft.client = gossh.NewClient(sshConn, chans, reqs)
+	ft.serverVersion = string(sshConn.ServerVersion())
 	ft.mu.Unlock()
 
 	// Start SSH keepalive — on failure it closes all listeners and the SSH
@@ -175,33 +315,48 @@ func (ft *ForwardTunnel) connect() error {
 	acceptDone := make(chan struct{})
 	var wg sync.WaitGroup
 
-	for _, m := range ft.Mappings {
-		listenAddr := fmt.Sprintf("127.0.0.1:%d", m.LocalPort)
-		listener, err := net.Listen("tcp", listenAddr)
+	ft.mu.Lock()
+	ft.acceptWG = &wg
+	ft.acceptDone = acceptDone
+	ft.mu.Unlock()
+
+	// A mapping that can't listen, e.g. because its port is taken, doesn't
+	// hold up the others.
+	var failures []string
+	mappings := ft.mappingList()
+	for _, m := range mappings {
+		listener, port, err := ft.listen(m)
+		ft.mu.Lock()
 		if err != nil {
-			close(acceptDone)
-			wg.Wait()
-			ft.client.Close()
-			return fmt.Errorf("listening on %s: %w", listenAddr, err)
+			st := ft.mappingStateLocked(m.LocalPort)
+			repeated := st.pending && st.lastErr == err.Error()
+			st.lastErr = err.Error()
+			st.pending = true
+			ft.mu.Unlock()
+			slog.Warn("forward tunnel could not listen", "local_port", m.LocalPort, "error", err)
+			if !repeated {
+				emit(ft.OnEvent, TunnelEvent{Kind: "listen_failed", Error: err.Error()})
+			}
+			failures = append(failures, err.Error())
+			continue
 		}
-
+		ft.startMappingLocked(m, listener, port, &wg, acceptDone)
+		ft.mu.Unlock()
+	}
+	if len(failures) == len(mappings) && len(failures) > 0 {
 		ft.mu.Lock()
-		ft.listeners = append(ft.listeners, listener)
+		ft.acceptWG = nil
 		ft.mu.Unlock()
-
-		slog.Info("forward tunnel active", "local_port", m.LocalPort, "remote", fmt.Sprintf("%s:%d", m.RemoteHost, m.RemotePort))
-
-		wg.Add(1)
-		go func(l net.Listener, m Mapping) {
-			defer wg.Done()
-			ft.acceptLoop(l, m, acceptDone)
-		}(listener, m)
+		close(acceptDone)
+		ft.client.Close()
+		return fmt.Errorf("no tunnel could listen: %s", strings.Join(failures, "; "))
 	}
 
 	ft.mu.Lock()
 	ft.connected = true
 	ft.lastErr = ""
 	ft.mu.Unlock()
+	emit(ft.OnEvent, TunnelEvent{Kind: "up"})
 
 	// Block until all accept loops finish (triggered by keepalive failure or Stop).
 	wg.Wait()
@@ -214,16 +369,118 @@ func (ft *ForwardTunnel) connect() error {
 	}
 }
 
+// listen opens the local listener of m and returns the port it is on. A
+// taken port is reported with the process holding it, if it can be found,
+// or with RemapBusyPorts moved to a free port nearby.
+func (ft *ForwardTunnel) listen(m Mapping) (net.Listener, int, error) {
+	addr := m.listenAddr(m.LocalPort)
+	l, err := net.Listen("tcp", addr)
+	if err == nil {
+		return l, m.LocalPort, nil
+	}
+	if !portInUse(err) {
+		return nil, 0, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	busy := addr + " is in use"
+	if owner := portOwner(m.LocalPort); owner != "" {
+		busy += " by " + owner
+	}
+	if !ft.RemapBusyPorts {
+		return nil, 0, errors.New(busy)
+	}
+
+	// Skip the ports of other mappings, which may just not be up yet.
+	taken := map[int]bool{}
+	for _, other := range ft.mappingList() {
+		taken[other.LocalPort] = true
+	}
+	for port := m.LocalPort + 1; port <= m.LocalPort+remapRange && port <= 65535; port++ {
+		if taken[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", m.listenAddr(port))
+		if err != nil {
+			continue
+		}
+		msg := fmt.Sprintf("%s; listening on %s instead", busy, m.listenAddr(port))
+		slog.Warn("forward tunnel port remapped", "local_port", m.LocalPort, "port", port, "reason", busy)
+		emit(ft.OnEvent, TunnelEvent{Kind: "remapped", Message: msg})
+		return l, port, nil
+	}
+	return nil, 0, fmt.Errorf("%s, and no port up to %d is free", busy, m.LocalPort+remapRange)
+}
+
+// startMappingLocked records listener as m's and starts accepting on it.
+// ft.mu must be held.
+func (ft *ForwardTunnel) startMappingLocked(m Mapping, listener net.Listener, port int, wg *sync.WaitGroup, done <-chan struct{}) {
+	ft.listeners = append(ft.listeners, listener)
+	st := ft.mappingStateLocked(m.LocalPort)
+	st.listener = listener
+	st.port = port
+	st.pending = false
+	st.lastErr = ""
+
+	slog.Info("forward tunnel active", "listen", listener.Addr().String(), "remote", fmt.Sprintf("%s:%d", m.RemoteHost, m.RemotePort))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ft.acceptLoop(listener, m, done)
+	}()
+}
+
+// retryPending listens for the mappings that could not when the
+// connection came up, e.g. because their port was taken.
+func (ft *ForwardTunnel) retryPending() {
+	ft.mu.Lock()
+	wg, done := ft.acceptWG, ft.acceptDone
+	var pending []Mapping
+	for _, m := range ft.Mappings {
+		if st := ft.mappingStateLocked(m.LocalPort); st.pending && st.listener == nil {
+			pending = append(pending, m)
+		}
+	}
+	ft.mu.Unlock()
+	if wg == nil {
+		return
+	}
+
+	for _, m := range pending {
+		listener, port, err := ft.listen(m)
+		ft.mu.Lock()
+		if err != nil {
+			ft.mappingStateLocked(m.LocalPort).lastErr = err.Error()
+			ft.mu.Unlock()
+			slog.Debug("forward tunnel still cannot listen", "local_port", m.LocalPort, "error", err)
+			continue
+		}
+		if ft.acceptWG != wg {
+			// The connection dropped meanwhile; the reconnect loop takes over.
+			ft.mu.Unlock()
+			listener.Close()
+			return
+		}
+		ft.startMappingLocked(m, listener, port, wg, done)
+		ft.mu.Unlock()
+	}
+}
+
 // acceptLoop accepts connections on a listener and forwards them through SSH.
 func (ft *ForwardTunnel) acceptLoop(listener net.Listener, m Mapping, done <-chan struct{}) {
 	for {
 		local, err := listener.Accept()
 		if err != nil {
+			ft.mu.Lock()
+			st, ok := ft.mappings[m.LocalPort]
+			replaced := !ok || st.listener != listener
+			ft.mu.Unlock()
 			select {
 			case <-ft.done:
 			case <-done:
 			default:
-				slog.Warn("forward tunnel accept error", "port", m.LocalPort, "error", err)
+				if !replaced {
+					slog.Warn("forward tunnel accept error", "port", m.LocalPort, "error", err)
+				}
 			}
 			return
 		}
@@ -236,7 +493,7 @@ func (ft *ForwardTunnel) acceptLoop(listener net.Listener, m Mapping, done <-cha
 // On failure, it closes all listeners and the SSH connection so that
 // connect() unblocks and the reconnect loop fires.
 func (ft *ForwardTunnel) keepalive(conn gossh.Conn) {
-	ticker := time.NewTicker(15 * time.Second)
+	ticker := time.NewTicker(ft.Network.keepaliveInterval())
 	defer ticker.Stop()
 
 	for {
@@ -252,10 +509,12 @@ func (ft *ForwardTunnel) keepalive(conn gossh.Conn) {
 				for _, l := range ft.listeners {
 					l.Close()
 				}
+				ft.acceptWG = nil
 				ft.mu.Unlock()
 				conn.Close()
 				return
 			}
+			ft.retryPending()
 		}
 	}
 }
@@ -281,16 +540,34 @@ func (ft *ForwardTunnel) forward(local net.Conn, m Mapping) {
 	remote, err := client.Dial("tcp", remoteAddr)
 	if err != nil {
 		slog.Error("forward tunnel dial failed", "remote", remoteAddr, "error", err)
+		ft.mu.Lock()
+		st := ft.mappingStateLocked(m.LocalPort)
+		st.lastErr = err.Error()
+		ft.mu.Unlock()
+		st.errors.Add(1)
 		return
 	}
 	defer remote.Close()
 
+	fc := &forwardedConn{local: local, remote: remote}
+	ft.mu.Lock()
+	st := ft.mappingStateLocked(m.LocalPort)
+	st.conns[fc] = struct{}{}
+	st.lastErr = ""
+	ft.mu.Unlock()
+	st.lastActivity.Store(time.Now().UnixNano())
+	defer func() {
+		ft.mu.Lock()
+		delete(st.conns, fc)
+		ft.mu.Unlock()
+	}()
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		io.Copy(remote, local)
+		copyConn(countingWriter{remote, &st.bytesOut, &st.lastActivity}, local)
 		if tc, ok := remote.(*net.TCPConn); ok {
 			tc.CloseWrite()
 		}
@@ -298,7 +575,7 @@ func (ft *ForwardTunnel) forward(local net.Conn, m Mapping) {
 
 	go func() {
 		defer wg.Done()
-		io.Copy(local, remote)
+		copyConn(countingWriter{local, &st.bytesIn, &st.lastActivity}, remote)
 		if tc, ok := local.(*net.TCPConn); ok {
 			tc.CloseWrite()
 		}
@@ -307,6 +584,151 @@ func (ft *ForwardTunnel) forward(local net.Conn, m Mapping) {
 	wg.Wait()
 }
 
+// RestartMapping closes one mapping's listener and active connections and
+// listens again over the same SSH connection. Other mappings are untouched.
+func (ft *ForwardTunnel) RestartMapping(localPort int) error {
+	var m Mapping
+	found := false
+	for _, mm := range ft.mappingList() {
+		if mm.LocalPort == localPort {
+			m, found = mm, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no tunnel on local port %d", localPort)
+	}
+
+	ft.mu.Lock()
+	wg, done := ft.acceptWG, ft.acceptDone
+	st := ft.mappingStateLocked(localPort)
+	old := st.listener
+	if wg == nil || (old == nil && !st.pending) {
+		ft.mu.Unlock()
+		return fmt.Errorf("tunnel is not connected")
+	}
+	// Hold a count so connect() does not see every accept loop exit while
+	// the listener is being replaced.
+	wg.Add(1)
+	defer wg.Done()
+	st.listener = nil
+	conns := st.conns
+	st.conns = make(map[*forwardedConn]struct{})
+	ft.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	for fc := range conns {
+		fc.local.Close()
+		fc.remote.Close()
+	}
+
+	listener, port, err := ft.listen(m)
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if err != nil {
+		st.lastErr = err.Error()
+		st.pending = true
+		return err
+	}
+	if ft.acceptWG != wg {
+		// The connection dropped meanwhile; the reconnect loop takes over.
+		listener.Close()
+		return fmt.Errorf("tunnel is reconnecting")
+	}
+	for i, l := range ft.listeners {
+		if l == old {
+			ft.listeners = append(ft.listeners[:i], ft.listeners[i+1:]...)
+			break
+		}
+	}
+	ft.startMappingLocked(m, listener, port, wg, done)
+
+	slog.Info("forward tunnel restarted", "local_port", localPort, "remote", fmt.Sprintf("%s:%d", m.RemoteHost, m.RemotePort))
+	return nil
+}
+
+// AddMapping starts forwarding m as well, over the current connection if
+// there is one, else once the connection comes up. When m can't listen
+// yet, it waits like a mapping that couldn't when the connection came up,
+// and the reason is returned.
+func (ft *ForwardTunnel) AddMapping(m Mapping) error {
+	ft.mu.Lock()
+	for _, mm := range ft.Mappings {
+		if mm.LocalPort == m.LocalPort {
+			ft.mu.Unlock()
+			return fmt.Errorf("a tunnel already uses local port %d", m.LocalPort)
+		}
+	}
+	ft.Mappings = append(slices.Clip(ft.Mappings), m)
+	wg, done := ft.acceptWG, ft.acceptDone
+	if wg == nil {
+		ft.mu.Unlock()
+		return nil
+	}
+	// As in RestartMapping, keep connect() waiting meanwhile.
+	wg.Add(1)
+	defer wg.Done()
+	ft.mu.Unlock()
+
+	listener, port, err := ft.listen(m)
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if err != nil {
+		st := ft.mappingStateLocked(m.LocalPort)
+		st.lastErr = err.Error()
+		st.pending = true
+		return err
+	}
+	if ft.acceptWG != wg {
+		// The connection dropped meanwhile; the reconnect loop listens.
+		listener.Close()
+		return nil
+	}
+	ft.startMappingLocked(m, listener, port, wg, done)
+	return nil
+}
+
+// RemoveMapping stops forwarding the mapping on localPort, closing its
+// listener and active connections, and forgets its counters. The last
+// mapping can't be removed; stop the tunnel instead.
+func (ft *ForwardTunnel) RemoveMapping(localPort int) error {
+	ft.mu.Lock()
+	i := slices.IndexFunc(ft.Mappings, func(m Mapping) bool { return m.LocalPort == localPort })
+	if i < 0 {
+		ft.mu.Unlock()
+		return fmt.Errorf("no tunnel on local port %d", localPort)
+	}
+	if len(ft.Mappings) == 1 {
+		ft.mu.Unlock()
+		return fmt.Errorf("local port %d is the last tunnel; stop the client instead", localPort)
+	}
+	ft.Mappings = slices.Delete(slices.Clone(ft.Mappings), i, i+1)
+	st := ft.mappings[localPort]
+	delete(ft.mappings, localPort)
+	var conns []*forwardedConn
+	if st != nil {
+		if st.listener != nil {
+			ft.listeners = slices.DeleteFunc(ft.listeners, func(l net.Listener) bool { return l == st.listener })
+			st.listener.Close()
+		}
+		for fc := range st.conns {
+			conns = append(conns, fc)
+		}
+	}
+	ft.mu.Unlock()
+
+	for _, fc := range conns {
+		fc.local.Close()
+		fc.remote.Close()
+	}
+	slog.Info("forward tunnel removed", "local_port", localPort)
+	return nil
+}
+
 // Stop shuts down the forward tunnel.
 func (ft *ForwardTunnel) Stop() {
 	if ft.done != nil {
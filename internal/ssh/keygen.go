@@ -4,6 +4,10 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -28,3 +32,56 @@ func GenerateKeyPair() (privateKeyPEM []byte, publicKeyAuthorized []byte, err er
 
 	return pem.EncodeToMemory(pemBlock), gossh.MarshalAuthorizedKey(sshPub), nil
 }
+
+// GenerateSecurityKeyPair creates an sk-ssh-ed25519@openssh.com key pair on
+// the FIDO2 security key plugged into this machine, by running OpenSSH's
+// ssh-keygen (8.2 or later), which asks for a touch of the key. It returns
+// the key handle, an OpenSSH private key file that only signs together
+// with that security key, and the public key in authorized_keys format.
+func GenerateSecurityKeyPair(comment string) (handle []byte, publicKeyAuthorized []byte, err error) {
+	keygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		return nil, nil, fmt.Errorf("security keys are created with OpenSSH's ssh-keygen, which is not installed: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "tw-sk-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "id_ed25519_sk")
+	cmd := exec.Command(keygen, "-t", "ed25519-sk", "-N", "", "-C", comment, "-f", path)
+	// ssh-keygen prompts for the touch and, if the key has one, the PIN.
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("ssh-keygen -t ed25519-sk: %w", err)
+	}
+
+	handle, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubData, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(pubData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing the security key's public key: %w", err)
+	}
+	if pub.Type() != gossh.KeyAlgoSKED25519 {
+		return nil, nil, fmt.Errorf("ssh-keygen made a %s key, not %s", pub.Type(), gossh.KeyAlgoSKED25519)
+	}
+	return handle, gossh.MarshalAuthorizedKey(pub), nil
+}
+
+// IsSecurityKey reports whether pub is held by a FIDO2 security key.
+func IsSecurityKey(pub gossh.PublicKey) bool {
+	switch pub.Type() {
+	case gossh.KeyAlgoSKED25519, gossh.KeyAlgoSKECDSA256:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package ssh
+
+import "time"
+
+// Defaults used when the corresponding NetworkOptions field is zero.
+const (
+	DefaultKeepaliveInterval = 15 * time.Second
+	DefaultDialTimeout       = 10 * time.Second
+	DefaultMaxBackoff        = 30 * time.Second
+
+	initialBackoff = 2 * time.Second
+)
+
+// NetworkOptions tunes keepalives, timeouts, and reconnect backoff for
+// tunnels and the embedded server. Zero fields use the defaults above.
+type NetworkOptions struct {
+	// KeepaliveInterval is the period between SSH keepalive requests. TCP
+	// keepalive probes are sent at twice this period.
+	KeepaliveInterval time.Duration
+	// DialTimeout bounds TCP dials and the SSH handshake.
+	DialTimeout time.Duration
+	// MaxBackoff caps the delay between reconnect attempts.
+	MaxBackoff time.Duration
+}
+
+func (n NetworkOptions) keepaliveInterval() time.Duration {
+	if n.KeepaliveInterval > 0 {
+		return n.KeepaliveInterval
+	}
+	return DefaultKeepaliveInterval
+}
+
+func (n NetworkOptions) tcpKeepalivePeriod() time.Duration {
+	return 2 * n.keepaliveInterval()
+}
+
+func (n NetworkOptions) dialTimeout() time.Duration {
+	if n.DialTimeout > 0 {
+		return n.DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+// TunnelEvent is a connection state change of a ReverseTunnel or
+// ForwardTunnel, reported through their OnEvent callback.
+type TunnelEvent struct {
+	Kind    string        // "up", "down", "reconnecting", "listen_failed", or "remapped"
+	Error   string        // why the connection or a listener failed ("down", "listen_failed")
+	Message string        // what changed ("remapped")
+	Attempt int           // consecutive failed attempts ("reconnecting")
+	Backoff time.Duration // delay before the next attempt ("reconnecting")
+}
+
+// emit calls fn with e when fn is set.
+func emit(fn func(TunnelEvent), e TunnelEvent) {
+	if fn != nil {
+		fn(e)
+	}
+}
+
+// backoff returns the delay before the next reconnect after the given
+// number of consecutive failed attempts. It stays at 2s for the first 8
+// attempts, then doubles every 4 attempts up to MaxBackoff:
+// 2s ×8, 4s ×4, 8s ×4, 16s ×4, then MaxBackoff forever (with the defaults).
+func (n NetworkOptions) backoff(attempt int) time.Duration {
+	limit := n.MaxBackoff
+	if limit <= 0 {
+		limit = DefaultMaxBackoff
+	}
+	d := initialBackoff
+	for threshold := 8; attempt > threshold && d < limit; threshold += 4 {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// permitRule is one permitopen pattern: HOST:PORT where PORT may be *,
+// and HOST may be an exact name or address, a glob of names such as
+// *.db.internal, or a CIDR block such as 10.0.0.0/24 ([fd00::/64] for
+// IPv6).
+type permitRule struct {
+	host   string       // lower-cased name, address or glob
+	prefix netip.Prefix // valid for CIDR rules
+	glob   bool
+	port   int // 0 for any port
+}
+
+// parsePermitOpen parses a permitopen pattern.
+func parsePermitOpen(pattern string) (permitRule, error) {
+	host, portStr, err := net.SplitHostPort(pattern)
+	if err != nil || host == "" {
+		return permitRule{}, fmt.Errorf("permitopen %q is not HOST:PORT", pattern)
+	}
+	var r permitRule
+	if portStr != "*" {
+		r.port, err = strconv.Atoi(portStr)
+		if err != nil || r.port < 1 || r.port > 65535 {
+			return permitRule{}, fmt.Errorf("permitopen %q has an invalid port", pattern)
+		}
+	}
+	r.host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, "/"):
+		if r.prefix, err = netip.ParsePrefix(host); err != nil {
+			return permitRule{}, fmt.Errorf("permitopen %q has an invalid CIDR block", pattern)
+		}
+		r.prefix = r.prefix.Masked()
+	case strings.ContainsAny(host, "*?["):
+		if _, err := path.Match(r.host, ""); err != nil {
+			return permitRule{}, fmt.Errorf("permitopen %q has an invalid host pattern", pattern)
+		}
+		if addressGlob(r.host) {
+			return permitRule{}, fmt.Errorf("permitopen %q matches addresses with a glob; use a CIDR block such as 10.0.0.0/24", pattern)
+		}
+		r.glob = true
+	}
+	return r, nil
+}
+
+// addressGlob reports whether a host glob is written as an address, like
+// 10.0.0.* or fd00::*. It would also match a name that merely starts like
+// one, 10.0.0.1.attacker.example, which can resolve anywhere.
+func addressGlob(host string) bool {
+	if strings.Contains(host, ":") {
+		return true
+	}
+	digits := false
+	for _, c := range host {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case !strings.ContainsRune(".*?[]-!^", c):
+			return false
+		}
+	}
+	return digits
+}
+
+// ValidPermitOpen checks a permitopen pattern, returning why it is
+// invalid. Patterns with characters that would break out of a quoted
+// authorized_keys option are refused too.
+func ValidPermitOpen(pattern string) error {
+	if strings.ContainsAny(pattern, "\",\\ \t\r\n") {
+		return fmt.Errorf("permitopen %q contains a forbidden character", pattern)
+	}
+	_, err := parsePermitOpen(pattern)
+	return err
+}
+
+// allows reports whether the rule permits forwarding to host:port. CIDR
+// rules only match destinations given as IP addresses: names aren't
+// resolved, so DNS can't widen what a user reaches.
+func (r permitRule) allows(host string, port uint32) bool {
+	if r.port != 0 && uint32(r.port) != port {
+		return false
+	}
+	host = strings.ToLower(host)
+	switch {
+	case r.prefix.IsValid():
+		addr, err := netip.ParseAddr(host)
+		return err == nil && r.prefix.Contains(addr.Unmap())
+	case r.glob:
+		ok, _ := path.Match(r.host, host)
+		return ok
+	default:
+		return r.host == host
+	}
+}
@@ -0,0 +1,39 @@
+package ssh
+
+import "testing"
+
+func TestPermitOpenAddressGlobRefused(t *testing.T) {
+	for _, pattern := range []string{"10.0.0.*:22", "192.168.?.1:*", "[fd00::*]:22"} {
+		if _, err := parsePermitOpen(pattern); err == nil {
+			t.Errorf("parsePermitOpen(%q) accepted an address glob", pattern)
+		}
+	}
+	for _, pattern := range []string{"*:*", "*.db.internal:*", "db-?.internal:5432"} {
+		if _, err := parsePermitOpen(pattern); err != nil {
+			t.Errorf("parsePermitOpen(%q): %v", pattern, err)
+		}
+	}
+}
+
+func TestPermitOpenDNSSuffix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"10.0.0.0/24:22", "10.0.0.1", true},
+		{"10.0.0.0/24:22", "10.0.0.1.attacker.example", false},
+		{"*.db.internal:*", "pg.db.internal", true},
+		{"*.db.internal:*", "pg.db.internal.attacker.example", false},
+		{"db.internal:22", "db.internal.attacker.example", false},
+	}
+	for _, tt := range tests {
+		r, err := parsePermitOpen(tt.pattern)
+		if err != nil {
+			t.Fatalf("parsePermitOpen(%q): %v", tt.pattern, err)
+		}
+		if got := r.allows(tt.host, 22); got != tt.want {
+			t.Errorf("%s allows %s = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// portInUse reports whether err from net.Listen means the port is taken.
+func portInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portOwner names the process listening on a local TCP port, e.g.
+// "postgres (pid 812)", or returns "" when it can't tell. It matches the
+// listening socket in /proc/net/tcp{,6} to a process's open descriptors,
+// so processes of other users are only found when running as root.
+func portOwner(port int) string {
+	inode := listenInode(port)
+	if inode == "" {
+		return ""
+	}
+	target := "socket:[" + inode + "]"
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err != nil || link != target {
+				continue
+			}
+			pid := filepath.Base(proc)
+			comm, err := os.ReadFile(filepath.Join(proc, "comm"))
+			if err != nil {
+				return "pid " + pid
+			}
+			return fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(comm)), pid)
+		}
+	}
+	return ""
+}
+
+// listenInode returns the inode of the socket listening on port.
+func listenInode(port int) string {
+	hexPort := fmt.Sprintf(":%04X", port)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Scan() // header
+		for sc.Scan() {
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 10 || fields[3] != "0A" || !strings.HasSuffix(fields[1], hexPort) {
+				continue
+			}
+			if _, err := strconv.ParseUint(fields[9], 10, 64); err == nil && fields[9] != "0" {
+				f.Close()
+				return fields[9]
+			}
+		}
+		f.Close()
+	}
+	return ""
+}
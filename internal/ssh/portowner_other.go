@@ -0,0 +1,44 @@
+//go:build !linux && !windows
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// portInUse reports whether err from net.Listen means the port is taken.
+func portInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portOwner names the process listening on a local TCP port, e.g.
+// "postgres (pid 812)", or returns "" when it can't tell. It asks lsof,
+// which macOS ships with.
+func portOwner(port int) string {
+	out, err := exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return ""
+	}
+	// One field per line: p<pid>, then c<command>.
+	var pid, name string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && pid == "":
+			pid = line[1:]
+		case strings.HasPrefix(line, "c") && name == "":
+			name = line[1:]
+		}
+	}
+	switch {
+	case pid == "":
+		return ""
+	case name == "":
+		return "pid " + pid
+	}
+	return fmt.Sprintf("%s (pid %s)", name, pid)
+}
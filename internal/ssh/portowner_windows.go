@@ -0,0 +1,53 @@
+//go:build windows
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// wsaeaddrinuse is the Winsock error for a port that is taken.
+const wsaeaddrinuse = syscall.Errno(10048)
+
+// portInUse reports whether err from net.Listen means the port is taken.
+func portInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portOwner names the process listening on a local TCP port, e.g.
+// "postgres.exe (pid 812)", or returns "" when it can't tell, from the
+// output of netstat and tasklist.
+func portOwner(port int) string {
+	cmd := exec.Command("netstat", "-ano", "-p", "TCP")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	suffix := ":" + strconv.Itoa(port)
+	for _, line := range strings.Split(string(out), "\n") {
+		// Proto Local-Address Foreign-Address State PID
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[3] != "LISTENING" || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		pid := fields[4]
+		cmd := exec.Command("tasklist", "/FI", "PID eq "+pid, "/FO", "CSV", "/NH")
+		cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+		out, err := cmd.Output()
+		if err != nil {
+			return "pid " + pid
+		}
+		name, _, ok := strings.Cut(strings.TrimSpace(string(out)), ",")
+		if !ok {
+			return "pid " + pid
+		}
+		return fmt.Sprintf("%s (pid %s)", strings.Trim(name, `"`), pid)
+	}
+	return ""
+}
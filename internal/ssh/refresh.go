@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A connected client asks for its current config with the ConfigRequest
+// global request over the tunnel's SSH connection. The server replies with
+// the config its user's bundle would hold now, signed by the server's host
+// key; the client checks the signature against the host key its bundle
+// pinned before applying anything. The client sends a fresh nonce with
+// each request and the server signs it with the config, so a reply
+// captured on the way can't be replayed later to roll the config back.
+
+// ConfigRequest is the global request type for a config refresh.
+const ConfigRequest = "config@tw"
+
+// configNonceSize is the length of a ConfigRequest's nonce.
+const configNonceSize = 32
+
+// configRequest is the payload of a ConfigRequest.
+type configRequest struct {
+	Nonce []byte
+}
+
+// signedConfig is the reply payload of a ConfigRequest.
+type signedConfig struct {
+	Config    []byte
+	Signature []byte // a gossh.Signature over the nonce and Config, in wire format
+}
+
+// configSigned returns what the signature of a config reply covers.
+func configSigned(nonce, config []byte) []byte {
+	return append(append([]byte(nil), nonce...), config...)
+}
+
+// ServerHostKeyCallback accepts only hostKey, or any host key when it is
+// nil.
+func ServerHostKeyCallback(hostKey gossh.PublicKey) gossh.HostKeyCallback {
+	if hostKey == nil {
+		return gossh.InsecureIgnoreHostKey()
+	}
+	return gossh.FixedHostKey(hostKey)
+}
+
+// replyConfig answers a ConfigRequest from the tw user user.
+func (s *Server) replyConfig(req *gossh.Request, user string) {
+	if s.ConfigFor == nil || s.hostSigner == nil || !req.WantReply {
+		req.Reply(false, nil)
+		return
+	}
+	// Clients from before nonces send none; their replies are signed over
+	// the config alone, as before.
+	var cr configRequest
+	if len(req.Payload) > 0 {
+		if err := gossh.Unmarshal(req.Payload, &cr); err != nil || len(cr.Nonce) != configNonceSize {
+			slog.Debug("config refresh refused: malformed request", "user", user)
+			req.Reply(false, nil)
+			return
+		}
+	}
+	data, err := s.ConfigFor(user)
+	if err != nil {
+		slog.Debug("config refresh refused", "user", user, "error", err)
+		req.Reply(false, nil)
+		return
+	}
+	sig, err := s.hostSigner.Sign(rand.Reader, configSigned(cr.Nonce, data))
+	if err != nil {
+		slog.Warn("could not sign config refresh", "user", user, "error", err)
+		req.Reply(false, nil)
+		return
+	}
+	req.Reply(true, gossh.Marshal(&signedConfig{Config: data, Signature: gossh.Marshal(sig)}))
+	slog.Debug("config refresh served", "user", user)
+}
+
+// FetchConfig asks the server for the client's current config over the
+// tunnel's connection, and returns it once its signature checks out
+// against hostKey.
+func (ft *ForwardTunnel) FetchConfig(hostKey gossh.PublicKey) ([]byte, error) {
+	ft.mu.Lock()
+	client := ft.client
+	ft.mu.Unlock()
+	if client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	nonce := make([]byte, configNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ok, payload, err := client.SendRequest(ConfigRequest, true, gossh.Marshal(&configRequest{Nonce: nonce}))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the server has no config for this client")
+	}
+	return verifySignedConfig(payload, nonce, hostKey)
+}
+
+// verifySignedConfig returns the config of the signedConfig payload once
+// its signature over nonce and the config checks out against hostKey.
+func verifySignedConfig(payload, nonce []byte, hostKey gossh.PublicKey) ([]byte, error) {
+	var sc signedConfig
+	if err := gossh.Unmarshal(payload, &sc); err != nil {
+		return nil, fmt.Errorf("malformed config reply: %w", err)
+	}
+	var sig gossh.Signature
+	if err := gossh.Unmarshal(sc.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("malformed config signature: %w", err)
+	}
+	if err := hostKey.Verify(configSigned(nonce, sc.Config), &sig); err != nil {
+		return nil, fmt.Errorf("config signature does not match the server's host key: %w", err)
+	}
+	return sc.Config, nil
+}
+
+// HostPublicKey returns the public half of the host key in dir, generating
+// the key as the server would if there is none yet, so the key can go into
+// bundles written before the server first ran.
+func HostPublicKey(dir string) (gossh.PublicKey, error) {
+	signer, err := loadOrGenerateHostKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
@@ -2,18 +2,42 @@ package ssh
 
 import (
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
 
-// ReverseTunnel connects to a remote SSH server and sets up a reverse
-// port forward (-R) so that remote clients can reach a local port.
+// ReverseForward is a single remote-port → local-address forward (-R).
+type ReverseForward struct {
+	// Optional label shown in status output.
+	Name string
+	// Port on the remote server to listen on.
+	RemotePort int
+	// Local address to forward to (e.g. "127.0.0.1:2222").
+	LocalAddr string
+}
+
+// ReverseForwardStatus reports the health of one reverse forward.
+// Byte counts accumulate across reconnects.
+type ReverseForwardStatus struct {
+	Name         string    `json:"name,omitempty"`
+	RemotePort   int       `json:"remote_port"`
+	LocalAddr    string    `json:"local_addr"`
+	Listening    bool      `json:"listening"`
+	ActiveConns  int       `json:"active_conns"`
+	BytesIn      int64     `json:"bytes_in"`  // relay → local
+	BytesOut     int64     `json:"bytes_out"` // local → relay
+	Errors       int64     `json:"errors"`    // connections that could not reach LocalAddr
+	LastActivity time.Time `json:"last_activity"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ReverseTunnel connects to a remote SSH server and sets up reverse port
+// forwards (-R) so that remote clients can reach local ports.
 type ReverseTunnel struct {
 	// Remote SSH server to connect to (via Xray tunnel).
 	RemoteAddr string
@@ -21,16 +45,39 @@ type ReverseTunnel struct {
 	User string
 	// Path to the private key for authentication.
 	KeyPath string
-	// Port on the remote server to listen on.
-	RemotePort int
-	// Local address to forward to (e.g. "127.0.0.1:2222").
-	LocalAddr string
+	// UseAgent authenticates with the SSH agent's keys instead of KeyPath.
+	// The agent is also used when there is no key at KeyPath.
+	UseAgent bool
+	// Forwards to request. The first is the primary forward: the tunnel
+	// counts as connected only while it is listening. The rest are
+	// best-effort and retried on every keepalive tick until they bind.
+	// Use AddForward and RemoveForward to change them while running.
+	Forwards []ReverseForward
+	// Keepalive, timeout, and backoff tuning.
+	Network NetworkOptions
+	// OnEvent, when set, is called as the connection goes up or down and
+	// before each reconnect.
+	OnEvent func(TunnelEvent)
 
 	mu        sync.Mutex
 	client    *gossh.Client
 	done      chan struct{}
+	kick      chan struct{} // skips the reconnect backoff, see Reconnect
 	connected bool
 	lastErr   string
+	forwards  []*reverseState // live forwards, created by Run
+}
+
+// reverseState is the live state behind ReverseForwardStatus. listener and
+// lastErr are guarded by ReverseTunnel.mu.
+type reverseState struct {
+	fwd      ReverseForward
+	primary  bool
+	removed  bool
+	listener net.Listener
+	lastErr  string
+	active   atomic.Int32
+	connCounters
 }
 
 // Connected reports whether the tunnel currently has an active SSH connection.
@@ -47,12 +94,99 @@ func (rt *ReverseTunnel) LastError() string {
 	return rt.lastErr
 }
 
+// ForwardStatus returns the state of each forward, the primary one first.
+func (rt *ReverseTunnel) ForwardStatus() []ReverseForwardStatus {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	out := make([]ReverseForwardStatus, len(rt.forwards))
+	for i, st := range rt.forwards {
+		out[i] = ReverseForwardStatus{
+			Name:         st.fwd.Name,
+			RemotePort:   st.fwd.RemotePort,
+			LocalAddr:    st.fwd.LocalAddr,
+			Listening:    st.listener != nil,
+			ActiveConns:  int(st.active.Load()),
+			BytesIn:      st.bytesIn.Load(),
+			BytesOut:     st.bytesOut.Load(),
+			Errors:       st.errors.Load(),
+			LastActivity: st.lastActive(),
+			Error:        st.lastErr,
+		}
+	}
+	return out
+}
+
+// initForwardsLocked creates the live forward state from Forwards on first
+// use. rt.mu must be held.
+func (rt *ReverseTunnel) initForwardsLocked() {
+	if rt.forwards != nil {
+		return
+	}
+	rt.forwards = make([]*reverseState, len(rt.Forwards))
+	for i, f := range rt.Forwards {
+		rt.forwards[i] = &reverseState{fwd: f, primary: i == 0}
+	}
+}
+
+// AddForward adds a best-effort forward. If the tunnel is connected, it is
+// requested immediately; otherwise on the next connection.
+func (rt *ReverseTunnel) AddForward(f ReverseForward) error {
+	rt.mu.Lock()
+	rt.initForwardsLocked()
+	for _, st := range rt.forwards {
+		if st.fwd.RemotePort == f.RemotePort {
+			rt.mu.Unlock()
+			return fmt.Errorf("relay port %d is already forwarded", f.RemotePort)
+		}
+	}
+	st := &reverseState{fwd: f}
+	rt.forwards = append(rt.forwards, st)
+	client := rt.client
+	connected := rt.connected
+	rt.mu.Unlock()
+
+	if connected && client != nil {
+		rt.listen(client, st)
+	}
+	return nil
+}
+
+// RemoveForward stops the non-primary forward on remotePort. Open
+// connections through it are left to finish.
+func (rt *ReverseTunnel) RemoveForward(remotePort int) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for i, st := range rt.forwards {
+		if st.fwd.RemotePort != remotePort {
+			continue
+		}
+		if st.primary {
+			return fmt.Errorf("cannot remove the primary forward on relay port %d", remotePort)
+		}
+		if st.listener != nil {
+			st.listener.Close()
+			st.listener = nil
+		}
+		st.removed = true
+		rt.forwards = append(rt.forwards[:i:i], rt.forwards[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("no forward on relay port %d", remotePort)
+}
+
 // Run connects to the remote SSH server, sets up the reverse port
-// forward, and blocks until the tunnel is closed or an error occurs.
-// It automatically reconnects with exponential backoff on failure.
+// forwards, and blocks until the tunnel is closed or an error occurs.
+// It automatically reconnects with exponential backoff on failure, capped at
+// Network.MaxBackoff.
 func (rt *ReverseTunnel) Run() error {
+	if len(rt.Forwards) == 0 {
+		return fmt.Errorf("reverse tunnel: no forwards configured")
+	}
 	rt.done = make(chan struct{})
-	backoff := time.Second * 2
+	rt.mu.Lock()
+	rt.initForwardsLocked()
+	rt.mu.Unlock()
 	attempt := 0
 
 	for {
@@ -69,64 +203,58 @@ func (rt *ReverseTunnel) Run() error {
 			rt.connected = false
 			rt.lastErr = err.Error()
 			rt.mu.Unlock()
+			emit(rt.OnEvent, TunnelEvent{Kind: "down", Error: err.Error()})
 			attempt++
 		} else {
 			// Successful connection resets backoff.
-			backoff = time.Second * 2
 			attempt = 0
 		}
 
+		backoff := rt.Network.backoff(attempt)
 		select {
 		case <-rt.done:
 			return nil
+		default:
+		}
+		emit(rt.OnEvent, TunnelEvent{Kind: "reconnecting", Attempt: attempt, Backoff: backoff})
+		select {
+		case <-rt.done:
+			return nil
+		case <-rt.kickChan():
+			attempt = 0
+			slog.Info("reverse tunnel reconnecting on request")
 		case <-time.After(backoff):
 			slog.Info("reverse tunnel reconnecting", "backoff", backoff, "attempt", attempt)
 		}
-
-		// Gradual backoff: stay at each level for 4 attempts before escalating.
-		// 2s ×8, 4s ×4, 8s ×4, 16s ×4, then 30s forever.
-		if attempt >= 8 && backoff == 2*time.Second {
-			backoff = 4 * time.Second
-		} else if attempt >= 12 && backoff == 4*time.Second {
-			backoff = 8 * time.Second
-		} else if attempt >= 16 && backoff == 8*time.Second {
-			backoff = 16 * time.Second
-		} else if attempt >= 20 && backoff == 16*time.Second {
-			backoff = 30 * time.Second
-		}
 	}
 }
 
 func (rt *ReverseTunnel) connect() error {
-	keyData, err := os.ReadFile(rt.KeyPath)
+	auth, agentConn, err := PublicKeyAuth(nil, rt.KeyPath, rt.UseAgent)
 	if err != nil {
-		return fmt.Errorf("reading private key: %w", err)
-	}
-
-	signer, err := gossh.ParsePrivateKey(keyData)
-	if err != nil {
-		return fmt.Errorf("parsing private key: %w", err)
+		return err
 	}
+	defer agentConn.Close()
 
 	config := &gossh.ClientConfig{
 		User: rt.User,
 		Auth: []gossh.AuthMethod{
-			gossh.PublicKeys(signer),
+			auth,
 		},
 		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+		Timeout:         rt.Network.dialTimeout(),
 	}
 
 	slog.Debug("reverse tunnel connecting", "remote", rt.RemoteAddr, "user", rt.User)
 
-	conn, err := net.DialTimeout("tcp", rt.RemoteAddr, 10*time.Second)
+	conn, err := net.DialTimeout("tcp", rt.RemoteAddr, rt.Network.dialTimeout())
 	if err != nil {
 		return fmt.Errorf("dialing %s: %w", rt.RemoteAddr, err)
 	}
 
 	if tc, ok := conn.(*net.TCPConn); ok {
 		tc.SetKeepAlive(true)
-		tc.SetKeepAlivePeriod(30 * time.Second)
+		tc.SetKeepAlivePeriod(rt.Network.tcpKeepalivePeriod())
 	}
 
 	sshConn, chans, reqs, err := gossh.NewClientConn(conn, rt.RemoteAddr, config)
@@ -135,25 +263,36 @@ func (rt *ReverseTunnel) connect() error {
 		return fmt.Errorf("SSH handshake: %w", err)
 	}
 
-	rt.client = gossh.NewClient(sshConn, chans, reqs)
-
-	// Start SSH keepalive in background.
-	go rt.keepalive(sshConn)
+	client := gossh.NewClient(sshConn, chans, reqs)
+	rt.mu.Lock()
+	rt.client = client
+	rt.mu.Unlock()
+	defer client.Close()
+	defer rt.closeForwards()
 
-	// Request reverse port forward.
-	listener, err := rt.client.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", rt.RemotePort))
+	// Request the primary reverse forward.
+	rt.mu.Lock()
+	states := append([]*reverseState(nil), rt.forwards...)
+	rt.mu.Unlock()
+	primary := states[0]
+	listener, err := rt.listen(client, primary)
 	if err != nil {
-		rt.client.Close()
-		return fmt.Errorf("requesting reverse forward on :%d: %w", rt.RemotePort, err)
+		return fmt.Errorf("requesting reverse forward on :%d: %w", primary.fwd.RemotePort, err)
+	}
+
+	// Additional forwards must not take the primary one down with them.
+	for _, st := range states[1:] {
+		rt.listen(client, st)
 	}
-	defer listener.Close()
 
 	rt.mu.Lock()
 	rt.connected = true
 	rt.lastErr = ""
 	rt.mu.Unlock()
+	emit(rt.OnEvent, TunnelEvent{Kind: "up"})
 
-	slog.Info("reverse tunnel active", "relay_port", rt.RemotePort, "local", rt.LocalAddr)
+	// Start SSH keepalive in background; it also retries failed forwards.
+	go rt.keepalive(client)
 
 	for {
 		remote, err := listener.Accept()
@@ -166,13 +305,85 @@ func (rt *ReverseTunnel) connect() error {
 			return fmt.Errorf("accepting reverse connection: %w", err)
 		}
 
-		go rt.forward(remote)
+		go rt.forward(remote, primary)
+	}
+}
+
+// listen requests a forward on the relay and records the result. Additional
+// forwards get their own accept loop; the primary one is served by connect.
+func (rt *ReverseTunnel) listen(client *gossh.Client, st *reverseState) (net.Listener, error) {
+	f := st.fwd
+	l, err := client.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", f.RemotePort))
+
+	rt.mu.Lock()
+	if st.removed {
+		rt.mu.Unlock()
+		if err == nil {
+			l.Close()
+		}
+		return nil, fmt.Errorf("forward on relay port %d was removed", f.RemotePort)
+	}
+	repeated := false
+	if err != nil {
+		repeated = st.lastErr == err.Error()
+		st.lastErr = err.Error()
+	} else {
+		st.listener = l
+		st.lastErr = ""
+	}
+	rt.mu.Unlock()
+
+	if err != nil {
+		// Retries of a persistently failing forward only log at debug level.
+		if !st.primary && !repeated {
+			slog.Warn("reverse forward failed", "relay_port", f.RemotePort, "local", f.LocalAddr, "error", err)
+		} else if !st.primary {
+			slog.Debug("reverse forward still failing", "relay_port", f.RemotePort, "error", err)
+		}
+		return nil, err
+	}
+
+	slog.Info("reverse tunnel active", "relay_port", f.RemotePort, "local", f.LocalAddr)
+	if !st.primary {
+		go rt.accept(l, st)
+	}
+	return l, nil
+}
+
+// accept serves an additional forward until its listener closes.
+func (rt *ReverseTunnel) accept(l net.Listener, st *reverseState) {
+	for {
+		remote, err := l.Accept()
+		if err != nil {
+			rt.mu.Lock()
+			if st.listener == l {
+				st.listener = nil
+				st.lastErr = err.Error()
+			}
+			rt.mu.Unlock()
+			return
+		}
+		go rt.forward(remote, st)
+	}
+}
+
+// closeForwards closes every forward listener after the connection ends.
+func (rt *ReverseTunnel) closeForwards() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, st := range rt.forwards {
+		if st.listener != nil {
+			st.listener.Close()
+			st.listener = nil
+		}
 	}
+	rt.connected = false
 }
 
-// keepalive sends periodic SSH keepalive requests to detect dead connections.
-func (rt *ReverseTunnel) keepalive(conn gossh.Conn) {
-	ticker := time.NewTicker(15 * time.Second)
+// keepalive sends periodic SSH keepalive requests to detect dead
+// connections, and re-requests additional forwards that are not listening.
+func (rt *ReverseTunnel) keepalive(client *gossh.Client) {
+	ticker := time.NewTicker(rt.Network.keepaliveInterval())
 	defer ticker.Stop()
 
 	for {
@@ -180,17 +391,28 @@ func (rt *ReverseTunnel) keepalive(conn gossh.Conn) {
 		case <-rt.done:
 			return
 		case <-ticker.C:
-			_, _, err := conn.SendRequest("keepalive@tw", true, nil)
+			_, _, err := client.SendRequest("keepalive@tw", true, nil)
 			if err != nil {
 				slog.Warn("reverse tunnel keepalive failed", "error", err)
-				conn.Close()
+				client.Close()
 				return
 			}
+			var down []*reverseState
+			rt.mu.Lock()
+			for _, st := range rt.forwards {
+				if !st.primary && st.listener == nil {
+					down = append(down, st)
+				}
+			}
+			rt.mu.Unlock()
+			for _, st := range down {
+				rt.listen(client, st)
+			}
 		}
 	}
 }
 
-func (rt *ReverseTunnel) forward(remote net.Conn) {
+func (rt *ReverseTunnel) forward(remote net.Conn, st *reverseState) {
 	defer func() {
 		if r := recover(); r != nil {
 			slog.Error("panic in reverse tunnel forward", "error", r)
@@ -198,9 +420,14 @@ func (rt *ReverseTunnel) forward(remote net.Conn) {
 	}()
 	defer remote.Close()
 
-	local, err := net.DialTimeout("tcp", rt.LocalAddr, 10*time.Second)
+	f := st.fwd
+	st.active.Add(1)
+	defer st.active.Add(-1)
+
+	local, err := net.DialTimeout("tcp", f.LocalAddr, rt.Network.dialTimeout())
 	if err != nil {
-		slog.Error("reverse tunnel failed to connect to local", "addr", rt.LocalAddr, "error", err)
+		slog.Error("reverse tunnel failed to connect to local", "addr", f.LocalAddr, "error", err)
+		st.errors.Add(1)
 		return
 	}
 	defer local.Close()
@@ -210,7 +437,7 @@ func (rt *ReverseTunnel) forward(remote net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		io.Copy(local, remote)
+		copyConn(countingWriter{local, &st.bytesIn, &st.lastActivity}, remote)
 		if tc, ok := local.(*net.TCPConn); ok {
 			tc.CloseWrite()
 		}
@@ -218,7 +445,7 @@ func (rt *ReverseTunnel) forward(remote net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		io.Copy(remote, local)
+		copyConn(countingWriter{remote, &st.bytesOut, &st.lastActivity}, local)
 		if tc, ok := remote.(*net.TCPConn); ok {
 			tc.CloseWrite()
 		}
@@ -227,15 +454,40 @@ func (rt *ReverseTunnel) forward(remote net.Conn) {
 	wg.Wait()
 }
 
+// Reconnect drops the current connection, if any, and dials again without
+// waiting out the backoff. Use it when the remote end is known to be back,
+// e.g. after the relay rebooted.
+func (rt *ReverseTunnel) Reconnect() {
+	kick := rt.kickChan()
+	rt.mu.Lock()
+	if rt.client != nil {
+		rt.client.Close()
+	}
+	rt.mu.Unlock()
+	select {
+	case kick <- struct{}{}:
+	default:
+	}
+}
+
+func (rt *ReverseTunnel) kickChan() chan struct{} {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.kick == nil {
+		rt.kick = make(chan struct{}, 1)
+	}
+	return rt.kick
+}
+
 // Stop shuts down the reverse tunnel.
 func (rt *ReverseTunnel) Stop() {
 	if rt.done != nil {
 		close(rt.done)
 	}
+	rt.mu.Lock()
 	if rt.client != nil {
 		rt.client.Close()
 	}
-	rt.mu.Lock()
 	rt.connected = false
 	rt.mu.Unlock()
 }
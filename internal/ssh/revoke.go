@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A client presenting a revoked key is refused like any unknown key, but
+// with an SSH banner saying why, which ForwardTunnel turns into a
+// *RevokedError instead of the library's "unable to authenticate".
+
+const revokedBannerPrefix = "tw: credential revoked: "
+
+// revokedBanner returns the banner refusing a key revoked for reason.
+func revokedBanner(reason string) string {
+	return revokedBannerPrefix + reason + "\n"
+}
+
+// RevokedError is returned when the server refused the client's key
+// because it was revoked.
+type RevokedError struct {
+	Reason string
+}
+
+func (e *RevokedError) Error() string {
+	return "credential revoked: " + e.Reason + " — ask the server's admin for a new bundle"
+}
+
+// CatchRevoked returns a BannerCallback for a client config that records
+// the reason of a revocation banner in *reason.
+func CatchRevoked(reason *string) gossh.BannerCallback {
+	return func(message string) error {
+		if r, ok := strings.CutPrefix(strings.TrimSpace(message), strings.TrimSpace(revokedBannerPrefix)); ok {
+			*reason = strings.TrimSpace(r)
+		}
+		return nil
+	}
+}
+
+// track records an open connection of the tw user user, for Disconnect.
+func (s *Server) track(user string, conn *gossh.ServerConn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[string]map[*gossh.ServerConn]bool)
+	}
+	if s.conns[user] == nil {
+		s.conns[user] = make(map[*gossh.ServerConn]bool)
+	}
+	s.conns[user][conn] = true
+}
+
+func (s *Server) untrack(user string, conn *gossh.ServerConn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	delete(s.conns[user], conn)
+	if len(s.conns[user]) == 0 {
+		delete(s.conns, user)
+	}
+}
+
+// Disconnect closes every open connection of the tw user user, with their
+// forwards and sessions, and returns how many it closed. Whether they can
+// connect again is up to authorized_keys.
+func (s *Server) Disconnect(user string) int {
+	s.connMu.Lock()
+	conns := make([]*gossh.ServerConn, 0, len(s.conns[user]))
+	for c := range s.conns[user] {
+		conns = append(conns, c)
+	}
+	s.connMu.Unlock()
+
+	for _, c := range conns {
+		if err := c.Close(); err != nil {
+			slog.Debug("closing SSH connection", "user", user, "remote", c.RemoteAddr(), "error", err)
+		}
+	}
+	if len(conns) > 0 {
+		slog.Info("SSH connections closed", "user", user, "count", len(conns))
+	}
+	return len(conns)
+}
+
+// revokedHandshake wraps a failed handshake's error in a *RevokedError
+// when the server sent a revocation banner.
+func revokedHandshake(err error, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("SSH handshake: %w", err)
+	}
+	return fmt.Errorf("SSH handshake: %w", &RevokedError{Reason: reason})
+}
@@ -4,15 +4,20 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/tunnelwhisperer/tw/internal/eventsink"
+	"github.com/tunnelwhisperer/tw/internal/geoip"
+	"github.com/tunnelwhisperer/tw/internal/ratelimit"
 	gossh "golang.org/x/crypto/ssh"
 )
 
@@ -21,10 +26,118 @@ type Server struct {
 	Port           int
 	HostKeyDir     string
 	AuthorizedKeys string
-	OnConnect      func(user string) // called after successful SSH authentication
-	OnDisconnect   func(user string) // called when an SSH connection closes
+	OnConnect      func(user string)        // called after successful SSH authentication
+	OnDisconnect   func(user string)        // called when an SSH connection closes
+	Network        NetworkOptions           // TCP keepalive and direct-tcpip dial timeout
+	Limiter        *ratelimit.Limiter       // bans sources after repeated handshake failures; nil disables
+	GeoIP          *geoip.Filter            // country rules for direct connections; nil disables
+	SFTPRoot       func(user string) string // a user's SFTP directory, "" to refuse them; nil disables SFTP
+	Events         eventsink.Sink           // receives every forward, e.g. for a SIEM; nil disables
+	Version        string                   // SSH version string to advertise; the library's if empty
 	config         *gossh.ServerConfig
 	listener       net.Listener
+	handshakes     sync.Map // remote address → SSH user, while handshaking
+
+	// ConfigFor returns a tw user's current client config, for
+	// ConfigRequest; nil refuses config refreshes.
+	ConfigFor  func(user string) ([]byte, error)
+	hostSigner gossh.Signer
+
+	// Revoked returns why key was revoked, or "" if it wasn't; it is asked
+	// about keys authorized_keys doesn't hold. nil disables the check.
+	Revoked func(key gossh.PublicKey) string
+
+	// TOTPSecret returns a tw user's TOTP secret, or "" when they have
+	// none; users with one must also give a code. nil disables TOTP.
+	TOTPSecret func(user string) string
+	totpMu     sync.Mutex
+	totpUsed   map[string]int64 // last TOTP period used, by tw user
+
+	// Invited reports whether the tw user user has an invitation waiting
+	// to be claimed, and Claim claims it with the claim code secret,
+	// registering key, and returns their client config. A nil Claim
+	// disables invitations.
+	Invited func(user string) bool
+	Claim   func(user, secret string, key gossh.PublicKey) ([]byte, error)
+
+	connMu sync.Mutex
+	conns  map[string]map[*gossh.ServerConn]bool // open connections by tw user
+
+	destMu sync.Mutex
+	dests  map[string]*destState // direct-tcpip traffic by destination
+}
+
+// keyUserExtension names the permissions extension holding the tw user a
+// key was issued to, taken from its "<name>@tw" authorized_keys comment.
+const keyUserExtension = "tw-user"
+
+// maxTrackedDests bounds how many destinations the server keeps traffic
+// counters for; forwards to further ones count under otherDests. Users
+// without permitopen options may forward anywhere.
+const (
+	maxTrackedDests = 256
+	otherDests      = "other"
+)
+
+// DestinationStats reports the direct-tcpip (-L) traffic to one
+// destination since the server started.
+type DestinationStats struct {
+	Dest         string    `json:"dest"` // host:port, or "other"
+	ActiveConns  int       `json:"active_conns"`
+	Conns        int64     `json:"conns"`     // forwarded so far
+	BytesIn      int64     `json:"bytes_in"`  // client → destination
+	BytesOut     int64     `json:"bytes_out"` // destination → client
+	Errors       int64     `json:"errors"`    // connections that could not be forwarded
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// destState is the live state behind DestinationStats.
+type destState struct {
+	active atomic.Int32
+	conns  atomic.Int64
+	connCounters
+}
+
+// destStats returns the counters for dest, creating them on first use.
+func (s *Server) destStats(dest string) *destState {
+	s.destMu.Lock()
+	defer s.destMu.Unlock()
+	if s.dests == nil {
+		s.dests = make(map[string]*destState)
+	}
+	st, ok := s.dests[dest]
+	if !ok {
+		if len(s.dests) >= maxTrackedDests {
+			dest = otherDests
+			if st, ok = s.dests[dest]; ok {
+				return st
+			}
+		}
+		st = &destState{}
+		s.dests[dest] = st
+	}
+	return st
+}
+
+// Stats returns the direct-tcpip traffic per destination, sorted by
+// destination.
+func (s *Server) Stats() []DestinationStats {
+	s.destMu.Lock()
+	defer s.destMu.Unlock()
+	out := make([]DestinationStats, 0, len(s.dests))
+	for dest, st := range s.dests {
+		out = append(out, DestinationStats{
+			Dest:         dest,
+			ActiveConns:  int(st.active.Load()),
+			Conns:        st.conns.Load(),
+			BytesIn:      st.bytesIn.Load(),
+			BytesOut:     st.bytesOut.Load(),
+			Errors:       st.errors.Load(),
+			LastActivity: st.lastActive(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Dest < out[j].Dest })
+	return out
 }
 
 func NewServer(port int, hostKeyDir, authorizedKeys string) (*Server, error) {
@@ -57,6 +170,12 @@ func (s *Server) loadAuthorizedKeys() error {
 	}
 
 	s.config.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+		s.handshakes.Store(conn.RemoteAddr().String(), conn.User())
+		if s.Limiter != nil {
+			if until, banned := s.Limiter.Banned(limitKey(conn.RemoteAddr(), conn.User())); banned {
+				return nil, fmt.Errorf("%q is banned until %s", conn.User(), until.Format(time.RFC3339))
+			}
+		}
 		return s.checkAuthorizedKey(conn, key)
 	}
 
@@ -75,7 +194,7 @@ func (s *Server) checkAuthorizedKey(conn gossh.ConnMetadata, key gossh.PublicKey
 	keyBytes := key.Marshal()
 	rest := data
 	for len(rest) > 0 {
-		pub, _, options, r, parseErr := gossh.ParseAuthorizedKey(rest)
+		pub, comment, options, r, parseErr := gossh.ParseAuthorizedKey(rest)
 		if parseErr != nil {
 			break
 		}
@@ -85,7 +204,23 @@ func (s *Server) checkAuthorizedKey(conn gossh.ConnMetadata, key gossh.PublicKey
 			continue
 		}
 
-		slog.Info("client authenticated", "user", conn.User(), "remote", conn.RemoteAddr())
+		// A security key's signature says whether the user touched the key
+		// or entered its PIN, but the SSH library doesn't pass it on, so
+		// verify-required can't be honored. Touch is enforced by the key
+		// itself, unless it was created with no-touch-required.
+		if IsSecurityKey(pub) && slices.Contains(options, "verify-required") {
+			slog.Warn("refusing security key with verify-required, which can't be checked", "user", conn.User())
+			return nil, fmt.Errorf("verify-required is not supported for %q", conn.User())
+		}
+
+		attrs := []any{"user", conn.User(), "remote", conn.RemoteAddr()}
+		if country := s.country(conn.RemoteAddr()); country != "" {
+			attrs = append(attrs, "country", country)
+		}
+		if IsSecurityKey(pub) {
+			attrs = append(attrs, "key_type", pub.Type())
+		}
+		slog.Info("client authenticated", attrs...)
 
 		perms := &gossh.Permissions{
 			Extensions: map[string]string{},
@@ -105,44 +240,72 @@ func (s *Server) checkAuthorizedKey(conn gossh.ConnMetadata, key gossh.PublicKey
 		if len(permitOpens) > 0 {
 			perms.Extensions["permitopen"] = strings.Join(permitOpens, ",")
 		}
+		if user, ok := strings.CutSuffix(comment, "@tw"); ok {
+			perms.Extensions[keyUserExtension] = user
+		}
+		if err := s.secondFactor(perms.Extensions[keyUserExtension], perms); err != nil {
+			return nil, err
+		}
 
 		return perms, nil
 	}
 
+	if s.Revoked != nil {
+		if reason := s.Revoked(key); reason != "" {
+			slog.Warn("revoked key refused", "user", conn.User(), "remote", conn.RemoteAddr(), "reason", reason)
+			return nil, &gossh.BannerError{
+				Err:     fmt.Errorf("revoked public key for %q", conn.User()),
+				Message: revokedBanner(reason),
+			}
+		}
+	}
+	if s.Claim != nil && s.Invited != nil && s.Invited(conn.User()) {
+		return nil, s.claimChallenge(conn.User(), key)
+	}
 	return nil, fmt.Errorf("unknown public key for %q", conn.User())
 }
 
 func (s *Server) loadOrGenerateHostKey() error {
-	keyPath := filepath.Join(s.HostKeyDir, "ssh_host_ed25519_key")
+	signer, err := loadOrGenerateHostKey(s.HostKeyDir)
+	if err != nil {
+		return err
+	}
+	s.hostSigner = signer
+	s.config.AddHostKey(signer)
+	return nil
+}
+
+// loadOrGenerateHostKey returns the host key in dir, generating it first
+// if there is none.
+func loadOrGenerateHostKey(dir string) (gossh.Signer, error) {
+	keyPath := filepath.Join(dir, "ssh_host_ed25519_key")
 
 	keyData, err := os.ReadFile(keyPath)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return fmt.Errorf("reading host key: %w", err)
+			return nil, fmt.Errorf("reading host key: %w", err)
 		}
 
 		slog.Info("generating SSH host key", "path", keyPath)
-		if err := os.MkdirAll(s.HostKeyDir, 0700); err != nil {
-			return fmt.Errorf("creating host key directory: %w", err)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("creating host key directory: %w", err)
 		}
 
 		privPEM, _, err := GenerateKeyPair()
 		if err != nil {
-			return fmt.Errorf("generating host key: %w", err)
+			return nil, fmt.Errorf("generating host key: %w", err)
 		}
 		if err := os.WriteFile(keyPath, privPEM, 0600); err != nil {
-			return fmt.Errorf("writing host key: %w", err)
+			return nil, fmt.Errorf("writing host key: %w", err)
 		}
 		keyData = privPEM
 	}
 
 	signer, err := gossh.ParsePrivateKey(keyData)
 	if err != nil {
-		return fmt.Errorf("parsing host key: %w", err)
+		return nil, fmt.Errorf("parsing host key: %w", err)
 	}
-
-	s.config.AddHostKey(signer)
-	return nil
+	return signer, nil
 }
 
 // Run starts the SSH server (blocking). It survives transient accept errors
@@ -154,6 +317,9 @@ func (s *Server) Run() error {
 		return fmt.Errorf("ssh-server: listen %s: %w", addr, err)
 	}
 	s.listener = lis
+	if s.Version != "" && s.config.ServerVersion != s.Version {
+		s.config.ServerVersion = s.Version
+	}
 
 	slog.Info("SSH server listening", "addr", addr)
 
@@ -174,7 +340,7 @@ func (s *Server) Run() error {
 		// Enable TCP keepalive to detect dead connections.
 		if tc, ok := conn.(*net.TCPConn); ok {
 			tc.SetKeepAlive(true)
-			tc.SetKeepAlivePeriod(30 * time.Second)
+			tc.SetKeepAlivePeriod(s.Network.tcpKeepalivePeriod())
 		}
 
 		go s.handleConnection(conn)
@@ -189,16 +355,54 @@ func (s *Server) handleConnection(conn net.Conn) {
 		}
 	}()
 
+	// Banned sources are dropped before the handshake. Clients arriving
+	// through the relay are checked per user once they authenticate.
+	addr := conn.RemoteAddr()
+	if s.Limiter != nil && !isLoopback(addr) {
+		if _, banned := s.Limiter.Banned(limitKey(addr, "")); banned {
+			slog.Debug("SSH connection from banned source dropped", "remote", addr)
+			return
+		}
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok && s.GeoIP != nil {
+		if country, allowed := s.GeoIP.Check(tcp.IP); !allowed {
+			if country == "" {
+				country = "unknown"
+			}
+			slog.Warn("SSH connection refused by GeoIP rules", "remote", addr, "country", country)
+			return
+		}
+	}
+
+	// Bound the handshake so half-open connections do not linger.
+	conn.SetDeadline(time.Now().Add(s.Network.dialTimeout()))
 	sshConn, chans, reqs, err := gossh.NewServerConn(conn, s.config)
+	pending, _ := s.handshakes.LoadAndDelete(addr.String())
 	if err != nil {
-		slog.Warn("SSH handshake failed", "error", err)
+		attempted, _ := pending.(string)
+		// Health probes connect from loopback and hang up after the banner.
+		if isLoopback(addr) && attempted == "" {
+			slog.Debug("SSH handshake failed", "remote", addr, "error", err)
+		} else {
+			slog.Warn("SSH handshake failed", "remote", addr, "error", err)
+		}
+		s.handshakeFailed(addr, attempted)
 		return
 	}
+	conn.SetDeadline(time.Time{})
 	defer sshConn.Close()
+	if s.Limiter != nil {
+		s.Limiter.Success(limitKey(addr, sshConn.User()))
+	}
 
 	user := sshConn.User()
 	slog.Debug("SSH connection established", "remote", sshConn.RemoteAddr(), "client_version", sshConn.ClientVersion(), "user", user)
 
+	if data, ok := sshConn.Permissions.Extensions[claimExtension]; ok {
+		s.serveClaim(chans, reqs, []byte(data))
+		return
+	}
+
 	if s.OnConnect != nil {
 		s.OnConnect(user)
 	}
@@ -208,12 +412,23 @@ func (s *Server) handleConnection(conn net.Conn) {
 		}
 	}()
 
-	go gossh.DiscardRequests(reqs)
+	twUser := user
+	if u := sshConn.Permissions.Extensions[keyUserExtension]; u != "" {
+		twUser = u
+	}
+	s.track(twUser, sshConn)
+	defer s.untrack(twUser, sshConn)
+	go s.handleGlobalRequests(reqs, twUser)
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepalive(sshConn, done)
 
 	for newChan := range chans {
 		switch newChan.ChannelType() {
 		case "direct-tcpip":
-			go s.handleDirectTCPIP(newChan, sshConn.Permissions)
+			go s.handleDirectTCPIP(newChan, sshConn)
+		case "session":
+			go s.handleSession(newChan, user, sshConn.Permissions)
 		default:
 			newChan.Reject(gossh.UnknownChannelType, fmt.Sprintf("unsupported channel type: %s", newChan.ChannelType()))
 		}
@@ -222,6 +437,53 @@ func (s *Server) handleConnection(conn net.Conn) {
 	slog.Debug("SSH connection closed", "remote", sshConn.RemoteAddr())
 }
 
+// handshakeFailed counts a failed handshake against its source and logs a
+// ban when it causes one. Loopback handshakes that failed before a user
+// was named aren't counted: they can't be told apart.
+func (s *Server) handshakeFailed(addr net.Addr, user string) {
+	if s.Limiter == nil || (isLoopback(addr) && user == "") {
+		return
+	}
+	key := limitKey(addr, user)
+	if until, banned := s.Limiter.Fail(key); banned {
+		slog.Warn("SSH source banned after repeated handshake failures", "source", key, "until", until.Format(time.RFC3339))
+	}
+}
+
+// limitKey identifies a source for rate limiting: its IP address. Every
+// client reaching the server through the relay arrives from loopback, so
+// those are told apart by SSH user, and one misbehaving client can't lock
+// out the rest.
+func limitKey(addr net.Addr, user string) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	if isLoopback(addr) && user != "" {
+		return host + " (" + user + ")"
+	}
+	return host
+}
+
+// country returns the country a connection comes from, or "" when it is
+// unknown or GeoIP is off. Clients reaching the server through the relay
+// arrive from loopback and have no country here; the relay filters them.
+func (s *Server) country(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || s.GeoIP == nil {
+		return ""
+	}
+	country, _ := s.GeoIP.Check(tcp.IP)
+	return country
+}
+
+func isLoopback(addr net.Addr) bool {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.IsLoopback()
+	}
+	return false
+}
+
 // directTCPIPData matches the RFC 4254 §7.2 payload for direct-tcpip channels.
 type directTCPIPData struct {
 	DestHost   string
@@ -257,7 +519,27 @@ func parseDirectTCPIP(data []byte) (directTCPIPData, error) {
 	return d, nil
 }
 
-func (s *Server) handleDirectTCPIP(newChan gossh.NewChannel, perms *gossh.Permissions) {
+// emit sends a forward event to the event sinks, if any.
+func (s *Server) emit(e eventsink.Event) {
+	if s.Events == nil {
+		return
+	}
+	e.Time = time.Now()
+	s.Events.Send(e)
+}
+
+// keyUser returns the tw user the key of conn was issued to, or the name
+// the client logged in with when the key has no user.
+func keyUser(conn *gossh.ServerConn) string {
+	if conn.Permissions != nil {
+		if user := conn.Permissions.Extensions[keyUserExtension]; user != "" {
+			return user
+		}
+	}
+	return conn.User()
+}
+
+func (s *Server) handleDirectTCPIP(newChan gossh.NewChannel, sshConn *gossh.ServerConn) {
 	defer func() {
 		if r := recover(); r != nil {
 			slog.Error("panic in direct-tcpip handler", "error", r)
@@ -271,19 +553,27 @@ func (s *Server) handleDirectTCPIP(newChan gossh.NewChannel, perms *gossh.Permis
 	}
 
 	dest := net.JoinHostPort(d.DestHost, fmt.Sprintf("%d", d.DestPort))
+	origin := net.JoinHostPort(d.OriginHost, fmt.Sprintf("%d", d.OriginPort))
+	event := eventsink.Event{User: keyUser(sshConn), Source: sshConn.RemoteAddr().String(), Origin: origin, Dest: dest}
 
 	// Check port forwarding restrictions from authorized_keys permitopen options.
-	if !isPortAllowed(perms, d.DestHost, d.DestPort) {
+	if !isPortAllowed(sshConn.Permissions, d.DestHost, d.DestPort) {
 		slog.Warn("direct-tcpip denied, not in permitopen", "origin", fmt.Sprintf("%s:%d", d.OriginHost, d.OriginPort), "dest", dest)
 		newChan.Reject(gossh.Prohibited, "port forwarding to this destination is not permitted")
+		event.Action = eventsink.ActionDenied
+		s.emit(event)
 		return
 	}
 
 	slog.Debug("direct-tcpip forwarding", "origin", fmt.Sprintf("%s:%d", d.OriginHost, d.OriginPort), "dest", dest)
 
-	conn, err := net.DialTimeout("tcp", dest, 10*time.Second)
+	st := s.destStats(dest)
+	conn, err := net.DialTimeout("tcp", dest, s.Network.dialTimeout())
 	if err != nil {
+		st.errors.Add(1)
 		newChan.Reject(gossh.ConnectionFailed, fmt.Sprintf("dial %s: %v", dest, err))
+		event.Action, event.Error = eventsink.ActionFailed, err.Error()
+		s.emit(event)
 		return
 	}
 	defer conn.Close()
@@ -291,22 +581,36 @@ func (s *Server) handleDirectTCPIP(newChan gossh.NewChannel, perms *gossh.Permis
 	// Enable TCP keepalive on the forwarded connection too.
 	if tc, ok := conn.(*net.TCPConn); ok {
 		tc.SetKeepAlive(true)
-		tc.SetKeepAlivePeriod(30 * time.Second)
+		tc.SetKeepAlivePeriod(s.Network.tcpKeepalivePeriod())
 	}
 
 	ch, _, err := newChan.Accept()
 	if err != nil {
 		slog.Warn("SSH channel accept failed", "error", err)
+		st.errors.Add(1)
+		event.Action, event.Error = eventsink.ActionFailed, err.Error()
+		s.emit(event)
 		return
 	}
 	defer ch.Close()
 
+	st.conns.Add(1)
+	st.active.Add(1)
+	defer st.active.Add(-1)
+	event.Action = eventsink.ActionAccepted
+	s.emit(event)
+
+	// The forward's own traffic, for its closed event, next to the
+	// destination's totals.
+	var fc connCounters
+	start := time.Now()
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		io.Copy(conn, ch)
+		copyConn(countingWriter{countingWriter{conn, &fc.bytesIn, &fc.lastActivity}, &st.bytesIn, &st.lastActivity}, ch)
 		// Half-close: signal the TCP side we're done writing.
 		if tc, ok := conn.(*net.TCPConn); ok {
 			tc.CloseWrite()
@@ -315,16 +619,23 @@ func (s *Server) handleDirectTCPIP(newChan gossh.NewChannel, perms *gossh.Permis
 
 	go func() {
 		defer wg.Done()
-		io.Copy(ch, conn)
+		copyConn(countingWriter{countingWriter{ch, &fc.bytesOut, &fc.lastActivity}, &st.bytesOut, &st.lastActivity}, conn)
 		ch.CloseWrite()
 	}()
 
 	wg.Wait()
+	event.Action = eventsink.ActionClosed
+	event.BytesIn, event.BytesOut = fc.bytesIn.Load(), fc.bytesOut.Load()
+	event.DurationMs = time.Since(start).Milliseconds()
+	s.emit(event)
 }
 
 // isPortAllowed checks whether a direct-tcpip destination is permitted
-// by the authorized_keys entry's permitopen options.
-// If no permitopen options are set, all destinations are allowed.
+// by the authorized_keys entry's permitopen options, which may name other
+// hosts than 127.0.0.1 and use wildcard ports, host globs and CIDR blocks
+// (see permitRule). Host names match regardless of case. Invalid options
+// permit nothing. If no permitopen options are set, all destinations are
+// allowed.
 func isPortAllowed(perms *gossh.Permissions, host string, port uint32) bool {
 	if perms == nil || perms.Extensions == nil {
 		return true
@@ -333,9 +644,8 @@ func isPortAllowed(perms *gossh.Permissions, host string, port uint32) bool {
 	if !ok {
 		return true // No restrictions — allow all.
 	}
-	target := net.JoinHostPort(host, fmt.Sprintf("%d", port))
 	for _, allowed := range strings.Split(permitted, ",") {
-		if allowed == target {
+		if rule, err := parsePermitOpen(allowed); err == nil && rule.allows(host, port) {
 			return true
 		}
 	}
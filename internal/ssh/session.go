@@ -0,0 +1,181 @@
+package ssh
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// The embedded server has no shell, but stock OpenSSH clients open a
+// session channel unless run with -N. Sessions are accepted so that
+// `ssh -L` works as is: a shell prints what the server is and holds the
+// connection until the user hangs up, and commands fail with a message
+// instead of a bare "channel open failed".
+
+// handleSession serves a session channel of user, who authenticated with
+// perms.
+func (s *Server) handleSession(newChan gossh.NewChannel, user string, perms *gossh.Permissions) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		slog.Debug("could not accept session channel", "user", user, "error", err)
+		return
+	}
+	defer ch.Close()
+
+	pty := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			pty = true
+			req.Reply(true, nil)
+		case "env", "window-change":
+			// Accepted and ignored; there is nothing to pass them to.
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			slog.Debug("OpenSSH client opened a shell", "user", user)
+			go holdShell(ch, user, pty)
+		case "exec":
+			var cmd struct{ Command string }
+			gossh.Unmarshal(req.Payload, &cmd)
+			req.Reply(true, nil)
+			slog.Info("refused command from SSH client", "user", user, "command", cmd.Command)
+			fmt.Fprintf(ch.Stderr(), "tw: this server only forwards ports and runs no commands (%q refused).\n", cmd.Command)
+			fmt.Fprintf(ch.Stderr(), "tw: use ssh -N -L LOCAL:127.0.0.1:PORT to forward without a command.\n")
+			sendExitStatus(ch, 1)
+			return
+		case "subsystem":
+			var sub struct{ Name string }
+			gossh.Unmarshal(req.Payload, &sub)
+			root := ""
+			if sub.Name == "sftp" {
+				root = s.sftpRoot(perms)
+			}
+			if root == "" {
+				slog.Info("refused subsystem from SSH client", "user", user, "subsystem", sub.Name)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go gossh.DiscardRequests(reqs)
+			keyUser := perms.Extensions[keyUserExtension]
+			slog.Info("SFTP session started", "user", keyUser)
+			if err := serveSFTP(ch, root); err != nil {
+				slog.Warn("SFTP session failed", "user", keyUser, "error", err)
+			}
+			slog.Info("SFTP session ended", "user", keyUser)
+			sendExitStatus(ch, 0)
+			return
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// sftpRoot returns the directory the SFTP session of the user holding
+// perms is confined to, or "" when SFTP is off for them. The user is the
+// one the key was issued to, whatever name the client logged in with.
+func (s *Server) sftpRoot(perms *gossh.Permissions) string {
+	if s.SFTPRoot == nil || perms == nil {
+		return ""
+	}
+	user := perms.Extensions[keyUserExtension]
+	if user == "" {
+		return ""
+	}
+	return s.SFTPRoot(user)
+}
+
+// holdShell tells the user the server only forwards ports, then keeps the
+// session, and with it the client's forwards, open until the user presses
+// Ctrl-C or Ctrl-D or closes the input.
+func holdShell(ch gossh.Channel, user string, pty bool) {
+	nl := "\n"
+	if pty {
+		nl = "\r\n"
+	}
+	msg := []string{
+		fmt.Sprintf("Connected to Tunnel Whisperer as %s.", user),
+		"This server only forwards ports; there is no shell.",
+		"Your -L forwards stay open until you press Ctrl-C or Ctrl-D.",
+		"(ssh -N connects without this session.)",
+	}
+	fmt.Fprint(ch, strings.Join(msg, nl)+nl)
+
+	buf := make([]byte, 256)
+	for {
+		n, err := ch.Read(buf)
+		if err != nil {
+			break
+		}
+		if pty && strings.ContainsAny(string(buf[:n]), "\x03\x04") {
+			break
+		}
+	}
+	sendExitStatus(ch, 0)
+	ch.Close()
+}
+
+// sendExitStatus reports a session's exit status to the client.
+func sendExitStatus(ch gossh.Channel, status uint32) {
+	ch.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{status}))
+}
+
+// handleGlobalRequests answers the connection-wide requests of user.
+// Keepalives get the failure reply OpenSSH servers send to requests they
+// don't know, which clients take as proof of life.
+func (s *Server) handleGlobalRequests(reqs <-chan *gossh.Request, user string) {
+	for req := range reqs {
+		switch req.Type {
+		case ConfigRequest:
+			s.replyConfig(req, user)
+			continue
+		case "tcpip-forward":
+			slog.Info("refused remote port forwarding (ssh -R) from SSH client", "user", user)
+		case "keepalive@openssh.com", "keepalive@tw":
+		default:
+			slog.Debug("unsupported global request", "user", user, "type", req.Type)
+		}
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// keepalive probes a client connection at the keepalive interval and
+// closes it when a probe goes unanswered for the dial timeout. Clients
+// behind the relay can vanish without the TCP connection to the local
+// Xray inbound noticing.
+func (s *Server) keepalive(conn gossh.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.Network.keepaliveInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			if err != nil {
+				return
+			}
+		case <-time.After(s.Network.dialTimeout()):
+			slog.Warn("SSH client stopped answering keepalives, closing", "user", conn.User(), "remote", conn.RemoteAddr())
+			conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
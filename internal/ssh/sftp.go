@@ -0,0 +1,632 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal SFTP version 3 server (draft-ietf-secsh-filexfer-02), enough
+// for OpenSSH's sftp and scp -s, WinSCP and FileZilla. Every path is taken
+// relative to a root directory the user can't leave; symbolic links are
+// neither created nor followed out of it.
+
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+
+	sftpStatus = 101
+	sftpHandle = 102
+	sftpData   = 103
+	sftpName   = 104
+	sftpAttrs  = 105
+
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+	sftpFailure          = 4
+	sftpBadMessage       = 5
+	sftpOpUnsupported    = 8
+
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagAppend = 0x04
+	sftpFlagCreat  = 0x08
+	sftpFlagTrunc  = 0x10
+	sftpFlagExcl   = 0x20
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+
+	sftpMaxPacket  = 256 << 10
+	sftpMaxRead    = 64 << 10
+	sftpDirBatch   = 100
+	sftpMaxHandles = 64
+)
+
+// errBadMessage is returned for packets that don't decode.
+var errBadMessage = errors.New("malformed SFTP packet")
+
+// sftpServer serves one SFTP session.
+type sftpServer struct {
+	root    string // real path of the user's directory
+	rw      io.ReadWriter
+	handles map[string]*sftpFile
+	next    int
+}
+
+// sftpFile is an open file or directory.
+type sftpFile struct {
+	f       *os.File
+	dir     bool
+	entries []os.FileInfo // directory entries not yet sent
+	listed  bool
+}
+
+// serveSFTP runs an SFTP session on rw confined to root until the client
+// hangs up.
+func serveSFTP(rw io.ReadWriter, root string) error {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	s := &sftpServer{root: real, rw: rw, handles: map[string]*sftpFile{}}
+	defer func() {
+		for _, h := range s.handles {
+			h.f.Close()
+		}
+	}()
+
+	for {
+		pkt, err := s.readPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := s.handle(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sftpServer) readPacket() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(s.rw, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 || n > sftpMaxPacket {
+		return nil, fmt.Errorf("SFTP packet of %d bytes", n)
+	}
+	pkt := make([]byte, n)
+	if _, err := io.ReadFull(s.rw, pkt); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// handle answers one packet. Only failures to write the answer end the
+// session.
+func (s *sftpServer) handle(pkt []byte) error {
+	typ := pkt[0]
+	if typ == sftpInit {
+		return s.send(sftpVersion, uint32(3))
+	}
+
+	r := &sftpReader{b: pkt[1:]}
+	id := r.uint32()
+	if r.err != nil {
+		return s.sendStatus(0, errBadMessage)
+	}
+
+	switch typ {
+	case sftpOpen:
+		p, pflags := r.string(), r.uint32()
+		attrs := r.attrs()
+		if r.err != nil {
+			return s.sendStatus(id, r.err)
+		}
+		return s.open(id, p, pflags, attrs)
+
+	case sftpClose:
+		handle := r.string()
+		h, ok := s.handles[handle]
+		if !ok {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		delete(s.handles, handle)
+		return s.sendStatus(id, h.f.Close())
+
+	case sftpRead:
+		h, off, n := s.file(r.string()), r.uint64(), r.uint32()
+		if r.err != nil || h == nil || h.dir {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		buf := make([]byte, min(n, sftpMaxRead))
+		got, err := h.f.ReadAt(buf, int64(off))
+		if got == 0 && err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.send(sftpData, id, buf[:got])
+
+	case sftpWrite:
+		h, off, data := s.file(r.string()), r.uint64(), r.bytes()
+		if r.err != nil || h == nil || h.dir {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		_, err := h.f.WriteAt(data, int64(off))
+		return s.sendStatus(id, err)
+
+	case sftpStat, sftpLstat:
+		real, err := s.resolve(r.string())
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		stat := os.Stat
+		if typ == sftpLstat {
+			stat = os.Lstat
+		}
+		fi, err := stat(real)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.send(sftpAttrs, id, fileAttrs(fi))
+
+	case sftpFstat:
+		h := s.file(r.string())
+		if h == nil {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		fi, err := h.f.Stat()
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.send(sftpAttrs, id, fileAttrs(fi))
+
+	case sftpSetstat:
+		p := r.string()
+		attrs := r.attrs()
+		real, err := s.resolve(p)
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.sendStatus(id, setAttrs(real, nil, attrs))
+
+	case sftpFsetstat:
+		h := s.file(r.string())
+		attrs := r.attrs()
+		if r.err != nil || h == nil {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		return s.sendStatus(id, setAttrs(h.f.Name(), h.f, attrs))
+
+	case sftpOpendir:
+		real, err := s.resolve(r.string())
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		f, err := os.Open(real)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+			f.Close()
+			return s.sendStatus(id, fmt.Errorf("not a directory"))
+		}
+		return s.addHandle(id, &sftpFile{f: f, dir: true})
+
+	case sftpReaddir:
+		h := s.file(r.string())
+		if h == nil || !h.dir {
+			return s.sendStatus(id, fs.ErrInvalid)
+		}
+		if !h.listed {
+			entries, err := h.f.Readdir(-1)
+			if err != nil {
+				return s.sendStatus(id, err)
+			}
+			h.entries, h.listed = entries, true
+		}
+		if len(h.entries) == 0 {
+			return s.sendStatus(id, io.EOF)
+		}
+		batch := h.entries[:min(len(h.entries), sftpDirBatch)]
+		h.entries = h.entries[len(batch):]
+		fields := []any{id, uint32(len(batch))}
+		for _, fi := range batch {
+			fields = append(fields, fi.Name(), longName(fi), fileAttrs(fi))
+		}
+		return s.send(sftpName, fields...)
+
+	case sftpRemove:
+		real, err := s.resolve(r.string())
+		if err == nil {
+			var fi os.FileInfo
+			if fi, err = os.Lstat(real); err == nil && fi.IsDir() {
+				err = fmt.Errorf("is a directory")
+			} else if err == nil {
+				err = os.Remove(real)
+			}
+		}
+		return s.sendStatus(id, err)
+
+	case sftpMkdir:
+		p := r.string()
+		attrs := r.attrs()
+		real, err := s.resolve(p)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		perm := os.FileMode(0755)
+		if attrs.flags&sftpAttrPermissions != 0 {
+			perm = os.FileMode(attrs.perm) & os.ModePerm
+		}
+		return s.sendStatus(id, os.Mkdir(real, perm))
+
+	case sftpRmdir:
+		real, err := s.resolve(r.string())
+		if err == nil {
+			if real == s.root {
+				err = fs.ErrPermission
+			} else if fi, serr := os.Lstat(real); serr != nil {
+				err = serr
+			} else if !fi.IsDir() {
+				err = fmt.Errorf("not a directory")
+			} else {
+				err = os.Remove(real)
+			}
+		}
+		return s.sendStatus(id, err)
+
+	case sftpRealpath:
+		p := r.string()
+		if r.err != nil {
+			return s.sendStatus(id, r.err)
+		}
+		return s.send(sftpName, id, uint32(1), virtualPath(p), virtualPath(p), sftpAttrsData{})
+
+	case sftpRename:
+		from, to := r.string(), r.string()
+		realFrom, err := s.resolve(from)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		realTo, err := s.resolve(to)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		if realFrom == s.root {
+			return s.sendStatus(id, fs.ErrPermission)
+		}
+		// SFTP v3 renames don't overwrite.
+		if _, err := os.Lstat(realTo); err == nil {
+			return s.sendStatus(id, fs.ErrExist)
+		}
+		return s.sendStatus(id, os.Rename(realFrom, realTo))
+
+	default:
+		return s.send(sftpStatus, id, uint32(sftpOpUnsupported), "operation not supported", "")
+	}
+}
+
+// open answers SSH_FXP_OPEN.
+func (s *sftpServer) open(id uint32, p string, pflags uint32, attrs sftpAttrsData) error {
+	real, err := s.resolve(p)
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	var flag int
+	switch {
+	case pflags&sftpFlagRead != 0 && pflags&(sftpFlagWrite|sftpFlagAppend) != 0:
+		flag = os.O_RDWR
+	case pflags&(sftpFlagWrite|sftpFlagAppend) != 0:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
+	}
+	// Appends are served as writes at the offsets the client sends, which
+	// is what clients that set the flag do anyway.
+	if pflags&sftpFlagCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if pflags&sftpFlagTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if pflags&sftpFlagExcl != 0 {
+		flag |= os.O_EXCL
+	}
+	perm := os.FileMode(0644)
+	if attrs.flags&sftpAttrPermissions != 0 {
+		perm = os.FileMode(attrs.perm) & os.ModePerm
+	}
+	f, err := os.OpenFile(real, flag, perm)
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return s.sendStatus(id, fmt.Errorf("is a directory"))
+	}
+	return s.addHandle(id, &sftpFile{f: f})
+}
+
+func (s *sftpServer) addHandle(id uint32, h *sftpFile) error {
+	if len(s.handles) >= sftpMaxHandles {
+		h.f.Close()
+		return s.sendStatus(id, fmt.Errorf("too many open files"))
+	}
+	s.next++
+	handle := strconv.Itoa(s.next)
+	s.handles[handle] = h
+	return s.send(sftpHandle, id, handle)
+}
+
+func (s *sftpServer) file(handle string) *sftpFile {
+	return s.handles[handle]
+}
+
+// virtualPath returns the absolute, clean form of a client path.
+func virtualPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// resolve maps a client path to a real path under the root. Relative
+// paths are taken from the root, and .. stops there. A path whose nearest
+// existing part is a symbolic link leading out of the root is refused.
+func (s *sftpServer) resolve(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", errBadMessage
+	}
+	real := filepath.Join(s.root, filepath.FromSlash(virtualPath(p)))
+	for check := real; ; check = filepath.Dir(check) {
+		resolved, err := filepath.EvalSymlinks(check)
+		if err == nil {
+			if resolved != s.root && !strings.HasPrefix(resolved, s.root+string(filepath.Separator)) {
+				return "", fs.ErrPermission
+			}
+			return real, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if check == s.root {
+			return real, nil
+		}
+	}
+}
+
+// sendStatus answers with SSH_FXP_STATUS for err, nil being success.
+func (s *sftpServer) sendStatus(id uint32, err error) error {
+	code, msg := uint32(sftpOK), "OK"
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		code, msg = sftpEOF, "end of file"
+	case errors.Is(err, fs.ErrNotExist):
+		code, msg = sftpNoSuchFile, "no such file"
+	case errors.Is(err, fs.ErrPermission):
+		code, msg = sftpPermissionDenied, "permission denied"
+	case errors.Is(err, errBadMessage):
+		code, msg = sftpBadMessage, err.Error()
+	default:
+		code, msg = sftpFailure, err.Error()
+		// Don't tell the client where its root is.
+		msg = strings.ReplaceAll(msg, s.root, "")
+	}
+	return s.send(sftpStatus, id, code, msg, "")
+}
+
+// send writes a packet of type typ holding fields: uint32, uint64,
+// string, []byte (as a string) and sftpAttrsData.
+func (s *sftpServer) send(typ byte, fields ...any) error {
+	b := []byte{0, 0, 0, 0, typ}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case string:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		case sftpAttrsData:
+			b = v.append(b)
+		}
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := s.rw.Write(b)
+	return err
+}
+
+// sftpAttrsData is an SFTP v3 ATTRS structure.
+type sftpAttrsData struct {
+	flags        uint32
+	size         uint64
+	perm         uint32
+	atime, mtime uint32
+}
+
+func (a sftpAttrsData) append(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, a.flags)
+	if a.flags&sftpAttrSize != 0 {
+		b = binary.BigEndian.AppendUint64(b, a.size)
+	}
+	if a.flags&sftpAttrPermissions != 0 {
+		b = binary.BigEndian.AppendUint32(b, a.perm)
+	}
+	if a.flags&sftpAttrACModTime != 0 {
+		b = binary.BigEndian.AppendUint32(b, a.atime)
+		b = binary.BigEndian.AppendUint32(b, a.mtime)
+	}
+	return b
+}
+
+// Unix file type bits of the permissions attribute.
+const (
+	sIFDIR = 0o040000
+	sIFREG = 0o100000
+	sIFLNK = 0o120000
+)
+
+// fileAttrs returns the attributes of fi.
+func fileAttrs(fi os.FileInfo) sftpAttrsData {
+	perm := uint32(fi.Mode().Perm())
+	switch {
+	case fi.IsDir():
+		perm |= sIFDIR
+	case fi.Mode()&os.ModeSymlink != 0:
+		perm |= sIFLNK
+	case fi.Mode().IsRegular():
+		perm |= sIFREG
+	}
+	mtime := uint32(fi.ModTime().Unix())
+	return sftpAttrsData{
+		flags: sftpAttrSize | sftpAttrPermissions | sftpAttrACModTime,
+		size:  uint64(fi.Size()),
+		perm:  perm,
+		atime: mtime,
+		mtime: mtime,
+	}
+}
+
+// setAttrs applies the size, permissions and times in a to the file at
+// real, through f when it is open. Owners can't be changed.
+func setAttrs(real string, f *os.File, a sftpAttrsData) error {
+	if a.flags&sftpAttrSize != 0 {
+		var err error
+		if f != nil {
+			err = f.Truncate(int64(a.size))
+		} else {
+			err = os.Truncate(real, int64(a.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&sftpAttrPermissions != 0 {
+		if err := os.Chmod(real, os.FileMode(a.perm)&os.ModePerm); err != nil {
+			return err
+		}
+	}
+	if a.flags&sftpAttrACModTime != 0 {
+		if err := os.Chtimes(real, time.Unix(int64(a.atime), 0), time.Unix(int64(a.mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// longName formats fi like a line of ls -l, which clients show as is.
+func longName(fi os.FileInfo) string {
+	mode := fi.Mode().String()
+	if fi.Mode()&os.ModeSymlink != 0 {
+		mode = "l" + mode[1:]
+	}
+	when := fi.ModTime().Format("Jan _2 15:04")
+	if time.Since(fi.ModTime()) > 180*24*time.Hour {
+		when = fi.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 tw tw %8d %s %s", mode, fi.Size(), when, fi.Name())
+}
+
+// sftpReader decodes packet fields, remembering the first error.
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) uint64() uint64 {
+	if len(r.b) < 8 {
+		r.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *sftpReader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errBadMessage
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sftpReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *sftpReader) attrs() sftpAttrsData {
+	a := sftpAttrsData{flags: r.uint32()}
+	if a.flags&sftpAttrSize != 0 {
+		a.size = r.uint64()
+	}
+	if a.flags&sftpAttrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if a.flags&sftpAttrPermissions != 0 {
+		a.perm = r.uint32()
+	}
+	if a.flags&sftpAttrACModTime != 0 {
+		a.atime = r.uint32()
+		a.mtime = r.uint32()
+	}
+	if a.flags&sftpAttrExtended != 0 {
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.string()
+		}
+	}
+	return a
+}
@@ -0,0 +1,93 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/totp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A tw user with a TOTP secret authenticates in two steps: their key
+// first, then a code from their authenticator app, asked for with
+// keyboard-interactive auth. Until both pass the connection can open no
+// channels, so no port forward starts without the code.
+
+// TOTPPrompt is the keyboard-interactive question asking for the code.
+const TOTPPrompt = "TOTP code: "
+
+// secondFactor returns the auth error that asks a client whose key
+// authenticated user for user's TOTP code, or nil when they have no
+// secret.
+func (s *Server) secondFactor(user string, perms *gossh.Permissions) error {
+	if s.TOTPSecret == nil || user == "" {
+		return nil
+	}
+	secret := s.TOTPSecret(user)
+	if secret == "" {
+		return nil
+	}
+	return &gossh.PartialSuccessError{
+		Next: gossh.ServerAuthCallbacks{
+			KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+				answers, err := challenge(conn.User(), "", []string{TOTPPrompt}, []bool{true})
+				if err != nil {
+					return nil, err
+				}
+				if len(answers) != 1 {
+					return nil, fmt.Errorf("no TOTP code from %q", user)
+				}
+				c, ok := totp.Validate(secret, answers[0], time.Now())
+				if !ok || !s.useTOTPCode(user, c) {
+					slog.Warn("TOTP code refused", "user", user, "remote", conn.RemoteAddr())
+					return nil, fmt.Errorf("wrong or reused TOTP code for %q", user)
+				}
+				slog.Info("TOTP code accepted", "user", user, "remote", conn.RemoteAddr())
+				return perms, nil
+			},
+		},
+	}
+}
+
+// useTOTPCode records that user used the code of period c, and reports
+// whether it is newer than any they used before: a code works once.
+func (s *Server) useTOTPCode(user string, c int64) bool {
+	s.totpMu.Lock()
+	defer s.totpMu.Unlock()
+	if s.totpUsed == nil {
+		s.totpUsed = make(map[string]int64)
+	}
+	if c <= s.totpUsed[user] {
+		return false
+	}
+	s.totpUsed[user] = c
+	return true
+}
+
+// TOTPAuth returns an auth method answering the server's TOTP prompt with
+// the code code returns. It fails for any other question.
+func TOTPAuth(code func() (string, error)) gossh.AuthMethod {
+	return gossh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			return nil, nil
+		}
+		if len(questions) != 1 || strings.TrimSpace(questions[0]) != strings.TrimSpace(TOTPPrompt) {
+			return nil, fmt.Errorf("the server asked %q, which tw can't answer", questions)
+		}
+		if code == nil {
+			return nil, ErrTOTPRequired
+		}
+		c, err := code()
+		if err != nil {
+			return nil, err
+		}
+		return []string{c}, nil
+	})
+}
+
+// ErrTOTPRequired is returned when the server asks for a TOTP code and
+// the client has no way to get one.
+var ErrTOTPRequired = errors.New("the server asks for a TOTP code — store this user's TOTP secret with tw secrets set totp, or run tw connect in a terminal to type it")
@@ -0,0 +1,126 @@
+// Package sysproxy finds the proxy a machine is configured to use, from the
+// standard environment variables or the operating system's settings, for
+// proxy_mode: auto.
+package sysproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Sources reported by Detect.
+const (
+	SourceEnvironment = "environment"
+	SourceSystem      = "system"
+)
+
+// Detect returns the proxy URL to use for an HTTPS connection to host and
+// where it was found. HTTPS_PROXY, HTTP_PROXY and ALL_PROXY (or their
+// lower-case forms) are checked first, honouring NO_PROXY; then the system
+// proxy on Windows and macOS with its bypass list. It returns "" when no
+// usable proxy applies to host.
+func Detect(host string) (proxyURL, source string) {
+	if raw := firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"); raw != "" {
+		cfg := httpproxy.Config{HTTPSProxy: raw, NoProxy: firstEnv("NO_PROXY", "no_proxy")}
+		u, err := cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: host})
+		if err != nil {
+			slog.Warn("ignoring proxy from environment", "error", err)
+			return "", ""
+		}
+		if u == nil {
+			return "", "" // NO_PROXY
+		}
+		return usable(u.String(), SourceEnvironment)
+	}
+
+	raw, bypass := systemProxy()
+	if raw == "" || bypassed(host, bypass) {
+		return "", ""
+	}
+	return usable(raw, SourceSystem)
+}
+
+// usable normalizes a detected proxy to the socks5:// or http:// URL the
+// Xray proxy outbound accepts, and drops anything else with a warning.
+func usable(raw, source string) (string, string) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		slog.Warn("ignoring unparseable proxy", "source", source, "proxy", raw)
+		return "", ""
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	case "socks", "socks5h":
+		u.Scheme = "socks5"
+	default:
+		slog.Warn("ignoring proxy with unsupported scheme", "source", source, "scheme", u.Scheme)
+		return "", ""
+	}
+	return u.String(), source
+}
+
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := strings.TrimSpace(os.Getenv(n)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// bypassed reports whether host matches the system bypass list. Entries are
+// host names with optional * wildcards; "<local>" matches names without a
+// dot. Address ranges in the list are ignored.
+func bypassed(host string, list []string) bool {
+	host = strings.ToLower(host)
+	for _, e := range list {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case e == "<local>":
+			if !strings.Contains(host, ".") && net.ParseIP(host) == nil {
+				return true
+			}
+		default:
+			if ok, _ := filepath.Match(e, host); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitServers picks one proxy from a per-protocol list such as Windows'
+// "http=a:80;https=b:443;socks=c:1080", preferring HTTPS, then HTTP, then
+// SOCKS. A value without "=" applies to every protocol.
+func splitServers(s string) string {
+	if !strings.Contains(s, "=") {
+		return s
+	}
+	byProto := map[string]string{}
+	for _, part := range strings.Split(s, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			byProto[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+	}
+	if v := byProto["https"]; v != "" {
+		return v
+	}
+	if v := byProto["http"]; v != "" {
+		return v
+	}
+	if v := byProto["socks"]; v != "" {
+		return fmt.Sprintf("socks5://%s", v)
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+//go:build darwin
+
+package sysproxy
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// systemProxy reads the proxy of the active network service from
+// `scutil --proxy`, preferring the secure web proxy, then the web proxy,
+// then SOCKS. Auto-config (PAC) URLs are not evaluated.
+func systemProxy() (string, []string) {
+	out, err := exec.Command("scutil", "--proxy").Output()
+	if err != nil {
+		return "", nil
+	}
+
+	values := map[string]string{}
+	var bypass []string
+	inExceptions := false
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " : ")
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "ExceptionsList"):
+			inExceptions = true
+		case strings.TrimSpace(line) == "}":
+			inExceptions = false
+		case !ok:
+		case inExceptions:
+			bypass = append(bypass, value)
+		default:
+			values[key] = value
+		}
+	}
+
+	for _, p := range []struct{ prefix, scheme string }{
+		{"HTTPS", "http://"},
+		{"HTTP", "http://"},
+		{"SOCKS", "socks5://"},
+	} {
+		if values[p.prefix+"Enable"] == "1" && values[p.prefix+"Proxy"] != "" {
+			addr := values[p.prefix+"Proxy"]
+			if port := values[p.prefix+"Port"]; port != "" {
+				addr += ":" + port
+			}
+			return p.scheme + addr, bypass
+		}
+	}
+	return "", nil
+}
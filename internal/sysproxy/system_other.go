@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package sysproxy
+
+// systemProxy is not available here; desktop Linux proxy settings are
+// exported through the environment variables Detect already reads.
+func systemProxy() (string, []string) {
+	return "", nil
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package sysproxy
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const internetSettings = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// systemProxy reads the WinINET proxy (Settings → Network → Proxy → Manual
+// proxy setup) for the current user. Auto-config scripts are not evaluated.
+func systemProxy() (string, []string) {
+	cmd := exec.Command("reg", "query", internetSettings)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil
+	}
+
+	values := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// "    ProxyServer    REG_SZ    proxy.corp:8080"
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.HasPrefix(fields[1], "REG_") {
+			values[fields[0]] = strings.Join(fields[2:], " ")
+		}
+	}
+	if values["ProxyEnable"] != "0x1" {
+		return "", nil
+	}
+	var bypass []string
+	if o := values["ProxyOverride"]; o != "" {
+		bypass = strings.Split(o, ";")
+	}
+	return splitServers(values["ProxyServer"]), bypass
+}
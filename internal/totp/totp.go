@@ -0,0 +1,125 @@
+// Package totp implements RFC 6238 time-based one-time passwords: the
+// six-digit codes, changing every 30 seconds, that authenticator apps
+// generate from a shared secret with HMAC-SHA1.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is how long a code is valid.
+	Period = 30 * time.Second
+	// Digits is the length of a code.
+	Digits = 6
+	// skew is how many periods a code may be early or late, for clocks
+	// that drift and codes typed just as they change.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random 160-bit secret, base32-encoded as
+// authenticator apps expect it.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// decode returns the key of a base32 secret, as typed: case, spaces and
+// padding are ignored.
+func decode(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("malformed TOTP secret")
+	}
+	return key, nil
+}
+
+// counter returns the period t falls in.
+func counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// code returns the code for a key and period.
+func code(key []byte, c int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(c))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1000000)
+}
+
+// Code returns the code for secret at t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate checks code against secret at t, allowing one period of clock
+// skew either way. It returns the period the code belongs to, which a
+// caller records to refuse the same code twice.
+func Validate(secret, input string, t time.Time) (int64, bool) {
+	key, err := decode(secret)
+	if err != nil {
+		return 0, false
+	}
+	input = strings.ReplaceAll(strings.TrimSpace(input), " ", "")
+	if len(input) != Digits {
+		return 0, false
+	}
+	now := counter(t)
+	for c := now - skew; c <= now+skew; c++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, c)), []byte(input)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI that enrolls secret in an authenticator
+// app, usually as a QR code, labelled issuer and account.
+func URI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	v.Set("digits", fmt.Sprint(Digits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// ParseSecret returns the secret in s, a base32 secret or an otpauth://
+// URI holding one.
+func ParseSecret(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return "", fmt.Errorf("malformed otpauth URI: %w", err)
+		}
+		s = u.Query().Get("secret")
+	}
+	if _, err := decode(s); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(strings.ReplaceAll(s, " ", "")), nil
+}
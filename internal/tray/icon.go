@@ -0,0 +1,58 @@
+package tray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"runtime"
+)
+
+const iconSize = 32
+
+// Icon colors for each connection state.
+var (
+	colorConnected    = color.RGBA{0x3f, 0xb9, 0x50, 0xff}
+	colorConnecting   = color.RGBA{0xd2, 0x99, 0x22, 0xff}
+	colorDisconnected = color.RGBA{0x8b, 0x94, 0x9e, 0xff}
+	colorError        = color.RGBA{0xf8, 0x51, 0x49, 0xff}
+)
+
+// dotIcon renders a filled circle in c, encoded the way the platform's tray
+// expects: PNG on Linux and macOS, ICO on Windows.
+func dotIcon(c color.RGBA) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, iconSize, iconSize))
+	center := float64(iconSize-1) / 2
+	radius := float64(iconSize)/2 - 2
+	for y := 0; y < iconSize; y++ {
+		for x := 0; x < iconSize; x++ {
+			dx, dy := float64(x)-center, float64(y)-center
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if runtime.GOOS == "windows" {
+		return pngToICO(buf.Bytes())
+	}
+	return buf.Bytes()
+}
+
+// pngToICO wraps a PNG in a single-image ICO container. Windows Vista and
+// later load PNG-compressed icon entries directly.
+func pngToICO(data []byte) []byte {
+	var buf bytes.Buffer
+	// ICONDIR: reserved, type (1 = icon), image count.
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, 1})
+	// ICONDIRENTRY: width, height, palette size, reserved, planes, bpp,
+	// data size, data offset.
+	buf.Write([]byte{iconSize, iconSize, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, [2]uint16{1, 32})
+	binary.Write(&buf, binary.LittleEndian, [2]uint32{uint32(len(data)), 6 + 16})
+	buf.Write(data)
+	return buf.Bytes()
+}
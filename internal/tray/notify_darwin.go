@@ -0,0 +1,15 @@
+//go:build darwin
+
+package tray
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// notify shows a Notification Center banner via osascript.
+func notify(title, message string) {
+	script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title))
+	exec.Command("osascript", "-e", script).Run()
+}
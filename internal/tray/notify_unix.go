@@ -0,0 +1,13 @@
+//go:build !windows && !darwin
+
+package tray
+
+import "os/exec"
+
+// notify shows a desktop notification via notify-send when it is installed.
+func notify(title, message string) {
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return
+	}
+	exec.Command("notify-send", "--app-name=Tunnel Whisperer", title, message).Run()
+}
@@ -0,0 +1,233 @@
+//go:build !darwin || cgo
+
+// Package tray runs the client with a system tray icon so end users can see
+// and control the connection without a terminal.
+package tray
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"fyne.io/systray"
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+// pollInterval is how often the tray refreshes the client status.
+const pollInterval = 2 * time.Second
+
+// state is the connection state shown by the tray icon.
+type state string
+
+const (
+	stateDisconnected state = "Disconnected"
+	stateConnecting   state = "Connecting..."
+	stateConnected    state = "Connected"
+	stateReconnecting state = "Reconnecting..."
+	stateError        state = "Connection failed"
+)
+
+var icons = map[state][]byte{
+	stateDisconnected: dotIcon(colorDisconnected),
+	stateConnecting:   dotIcon(colorConnecting),
+	stateConnected:    dotIcon(colorConnected),
+	stateReconnecting: dotIcon(colorConnecting),
+	stateError:        dotIcon(colorError),
+}
+
+// Run starts the client and shows its state in the system tray until the
+// user chooses Quit or the process receives SIGINT/SIGTERM. The client is
+// stopped on exit.
+func Run(o *ops.Ops) error {
+	t := &tray{ops: o}
+	systray.Run(t.onReady, t.onExit)
+	return nil
+}
+
+type tray struct {
+	ops *ops.Ops
+
+	status     *systray.MenuItem
+	lastErr    *systray.MenuItem
+	connect    *systray.MenuItem
+	disconnect *systray.MenuItem
+	quit       *systray.MenuItem
+
+	mu           sync.Mutex // guards the fields below
+	current      state
+	shownErr     string
+	wasConnected bool // connected at least once since the last connect action
+}
+
+func (t *tray) onReady() {
+	systray.SetTitle("")
+	systray.SetTooltip("Tunnel Whisperer")
+
+	t.status = systray.AddMenuItem(string(stateDisconnected), "")
+	t.status.Disable()
+	t.lastErr = systray.AddMenuItem("", "")
+	t.lastErr.Disable()
+	t.lastErr.Hide()
+	systray.AddSeparator()
+	t.connect = systray.AddMenuItem("Connect", "Connect to the relay")
+	t.disconnect = systray.AddMenuItem("Disconnect", "Close all tunnels")
+	systray.AddSeparator()
+	t.quit = systray.AddMenuItem("Quit", "Disconnect and exit")
+
+	t.mu.Lock()
+	t.render(stateDisconnected, "")
+	t.mu.Unlock()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	go t.start()
+	go t.loop(sig)
+	go t.notifyPorts()
+}
+
+// notifyPorts tells the user when a tunnel's local port is taken, since
+// that tunnel won't work, or is on another port, until they act.
+func (t *tray) notifyPorts() {
+	events, _ := t.ops.SubscribeStatus()
+	for e := range events {
+		switch e.Type {
+		case "tunnel_listen_failed":
+			go notify("Tunnel Whisperer", "A tunnel could not start: "+e.Error)
+		case "tunnel_remapped":
+			go notify("Tunnel Whisperer", "Tunnel moved: "+e.Message)
+		}
+	}
+}
+
+func (t *tray) onExit() {
+	if err := t.ops.StopClient(nil); err != nil {
+		slog.Debug("stopping client on exit", "error", err)
+	}
+}
+
+// loop handles menu clicks and polls the client status.
+func (t *tray) loop(sig <-chan os.Signal) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.connect.ClickedCh:
+			go t.start()
+		case <-t.disconnect.ClickedCh:
+			if err := t.ops.StopClient(nil); err != nil {
+				slog.Warn("disconnecting", "error", err)
+			}
+			t.refresh()
+		case <-t.quit.ClickedCh:
+			systray.Quit()
+			return
+		case <-sig:
+			systray.Quit()
+			return
+		case <-ticker.C:
+			t.refresh()
+		}
+	}
+}
+
+// start connects the client, clearing a previous failure first.
+func (t *tray) start() {
+	if t.ops.ClientStatus().State == ops.StateError {
+		t.ops.StopClient(nil)
+	}
+	t.mu.Lock()
+	t.wasConnected = false
+	t.mu.Unlock()
+	if err := t.ops.StartClient(nil); err != nil {
+		slog.Error("client connect failed", "error", err)
+	}
+	t.refresh()
+}
+
+// refresh maps the client status to a tray state and notifies the user of
+// dropped and restored connections.
+func (t *tray) refresh() {
+	st := t.ops.ClientStatus()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var next state
+	var errMsg string
+	switch st.State {
+	case ops.StateRunning:
+		switch {
+		case st.Tunnel:
+			next = stateConnected
+		case t.wasConnected:
+			next = stateReconnecting
+		default:
+			next = stateConnecting
+		}
+		errMsg = st.TunnelError
+	case ops.StateStarting:
+		next = stateConnecting
+	case ops.StateError:
+		next = stateError
+		errMsg = st.Error
+	default:
+		next = stateDisconnected
+	}
+
+	prev := t.current
+	t.render(next, errMsg)
+
+	switch {
+	case next == prev:
+	case next == stateReconnecting:
+		go notify("Tunnel Whisperer", "Connection lost. Reconnecting...")
+	case next == stateConnected && t.wasConnected:
+		go notify("Tunnel Whisperer", "Reconnected.")
+	case next == stateError:
+		go notify("Tunnel Whisperer", "Connection failed: "+errMsg)
+	}
+	if next == stateConnected {
+		t.wasConnected = true
+	}
+}
+
+// render updates the icon, tooltip, and menu for s. t.mu must be held.
+func (t *tray) render(s state, errMsg string) {
+	if errMsg != t.shownErr {
+		if errMsg != "" {
+			t.lastErr.SetTitle("Last error: " + truncate(errMsg, 80))
+			t.lastErr.SetTooltip(errMsg)
+			t.lastErr.Show()
+		} else {
+			t.lastErr.Hide()
+		}
+		t.shownErr = errMsg
+	}
+
+	if s == t.current {
+		return
+	}
+	systray.SetIcon(icons[s])
+	systray.SetTooltip("Tunnel Whisperer: " + string(s))
+	t.status.SetTitle(string(s))
+	if s == stateDisconnected || s == stateError {
+		t.connect.Enable()
+		t.disconnect.Disable()
+	} else {
+		t.connect.Disable()
+		t.disconnect.Enable()
+	}
+	t.current = s
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,15 @@
+//go:build darwin && !cgo
+
+package tray
+
+import (
+	"errors"
+
+	"github.com/tunnelwhisperer/tw/internal/ops"
+)
+
+// Run is not available: the macOS tray is built on Cocoa through cgo, and
+// this tw was built without it, as cross-compiled binaries are.
+func Run(o *ops.Ops) error {
+	return errors.New("the tray needs a tw built on macOS with cgo; run tw connect without --tray")
+}
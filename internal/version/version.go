@@ -0,0 +1,88 @@
+// Package version identifies the tw build. Release builds set the variables
+// at link time:
+//
+//	go build -ldflags "-X github.com/tunnelwhisperer/tw/internal/version.Version=v1.2.0 \
+//	  -X github.com/tunnelwhisperer/tw/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/tunnelwhisperer/tw/internal/version.Date=$(date -u +%FT%TZ)"
+//
+// `make build` does this from git. A plain go build is "dev", with the
+// commit and date Go records from the checkout, if any.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the release, e.g. v1.2.0.
+	Version = "dev"
+
+	// Commit is the git commit the binary was built from.
+	Commit = ""
+
+	// Date is when the binary was built, in RFC 3339.
+	Date = ""
+
+	// ReleaseKey is the base64 Ed25519 public key release checksums are
+	// signed with. tw self-update refuses a release whose checksums it
+	// can't verify against it; a build without it checks only the checksum.
+	ReleaseKey = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && Commit == "":
+			Commit = s.Value
+		case s.Key == "vcs.time" && Date == "":
+			Date = s.Value
+		}
+	}
+}
+
+// Info describes the build, for tw version and the status API.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build's Info.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// IsDev reports whether this is not a release build.
+func IsDev() bool {
+	return Version == "dev"
+}
+
+// String returns the version with its short commit, e.g. "v1.2.0 (3f2a9c1)".
+func String() string {
+	if Commit == "" {
+		return Version
+	}
+	return fmt.Sprintf("%s (%s)", Version, ShortCommit())
+}
+
+// ShortCommit returns the first seven characters of Commit.
+func ShortCommit() string {
+	if len(Commit) > 7 {
+		return Commit[:7]
+	}
+	return Commit
+}
@@ -0,0 +1,71 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tunnelwhisperer/tw/internal/logging"
+	xlog "github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/common"
+	clog "github.com/xtls/xray-core/common/log"
+)
+
+// Xray's error log, which Xray writes to stdout by default, goes to slog
+// instead, tagged component=xray, so it shows in tw's own log and the
+// dashboard console. Xray is always configured to pass every message on;
+// logHandler drops those below logging.XrayLevel, which can change while
+// Xray runs.
+
+// xrayConfigLevel is the loglevel every instance is started with.
+const xrayConfigLevel = "debug"
+
+func init() {
+	common.Must(xlog.RegisterHandlerCreator(xlog.LogType_Console, func(xlog.LogType, xlog.HandlerCreatorOptions) (clog.Handler, error) {
+		return logHandler{}, nil
+	}))
+}
+
+// xraySeverity ranks Xray's log levels like clog.Severity: lower is more
+// severe. "none" ranks below everything.
+var xraySeverity = map[string]clog.Severity{
+	"none":    clog.Severity_Unknown,
+	"error":   clog.Severity_Error,
+	"warning": clog.Severity_Warning,
+	"info":    clog.Severity_Info,
+	"debug":   clog.Severity_Debug,
+}
+
+// logHandler passes Xray's log messages to slog.
+type logHandler struct{}
+
+func (logHandler) Handle(msg clog.Message) {
+	m, ok := msg.(*clog.GeneralMessage)
+	if !ok {
+		return
+	}
+	max, ok := xraySeverity[logging.XrayLevel()]
+	if !ok {
+		max = clog.Severity_Warning
+	}
+	if m.Severity > max || m.Severity == clog.Severity_Unknown {
+		return
+	}
+	var level slog.Level
+	switch m.Severity {
+	case clog.Severity_Error:
+		level = slog.LevelError
+	case clog.Severity_Warning:
+		level = slog.LevelWarn
+	case clog.Severity_Info:
+		level = slog.LevelInfo
+	default:
+		level = slog.LevelDebug
+	}
+	// Handle the record directly: Xray's level, not tw's, decides what is
+	// logged.
+	r := slog.NewRecord(time.Now(), level, fmt.Sprint(m.Content), 0)
+	r.AddAttrs(slog.String("component", "xray"))
+	slog.Default().Handler().Handle(context.Background(), r)
+}
@@ -0,0 +1,176 @@
+package xray
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// Proxy rule actions for config.ProxyRule.Action.
+const (
+	ProxyActionProxy  = "proxy"
+	ProxyActionDirect = "direct"
+)
+
+// relayDialTag is a loopback outbound the relay connection is dialed
+// through when proxy rules are set. Loopback hands the connection back to
+// Xray's router with this inbound tag, so routing rules on the relay
+// address pick proxy-out or direct.
+const relayDialTag = "relay-dial"
+
+// ValidateProxyRules checks proxy_rules.
+func ValidateProxyRules(rules []config.ProxyRule) error {
+	for i, r := range rules {
+		if r.Action != ProxyActionProxy && r.Action != ProxyActionDirect {
+			return fmt.Errorf("proxy rule %d: action must be %q or %q, got %q", i+1, ProxyActionProxy, ProxyActionDirect, r.Action)
+		}
+		for _, d := range r.Domains {
+			if d == "" || strings.ContainsAny(d, " /:*") {
+				return fmt.Errorf("proxy rule %d: invalid domain %q", i+1, d)
+			}
+		}
+		for _, c := range r.CIDRs {
+			if _, err := parseCIDR(c); err != nil {
+				return fmt.Errorf("proxy rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ProxyFor applies the proxy rules to host, the way Xray's router does for
+// the relay connection, and returns the proxy URL to use or "" to connect
+// directly. It is for Go-side HTTP checks that should follow the same path
+// as the tunnel.
+func ProxyFor(proxyURL string, rules []config.ProxyRule, host string) string {
+	if proxyURL == "" {
+		return ""
+	}
+	if matchProxyRules(rules, host) == ProxyActionDirect {
+		return ""
+	}
+	return proxyURL
+}
+
+// matchProxyRules returns the action of the first rule that matches host,
+// or ProxyActionProxy when none does. CIDR rules only match when host is an
+// IP address: the loopback outbound skips DNS, so Xray never resolves the
+// relay hostname for routing either.
+func matchProxyRules(rules []config.ProxyRule, host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, r := range rules {
+		if len(r.Domains) == 0 && len(r.CIDRs) == 0 {
+			return r.Action
+		}
+		for _, d := range r.Domains {
+			d = strings.ToLower(d)
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return r.Action
+			}
+		}
+		if ip == nil {
+			continue
+		}
+		for _, c := range r.CIDRs {
+			if n, err := parseCIDR(c); err == nil && n.Contains(ip) {
+				return r.Action
+			}
+		}
+	}
+	return ProxyActionProxy
+}
+
+// parseCIDR accepts a CIDR or a single IP address.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return n, nil
+}
+
+// proxyRouting returns the extra outbounds and routing rules that apply
+// proxy rules to the relay connection, and the dialerProxy tag for the
+// VLESS outbound. Without a proxy it returns nothing; without rules the
+// relay connection is dialed straight through proxy-out.
+func proxyRouting(proxyURL string, rules []config.ProxyRule) (dialer string, outbounds []interface{}, routes []map[string]interface{}, err error) {
+	if proxyURL == "" {
+		return "", nil, nil, nil
+	}
+	po, err := proxyOutbound(proxyURL)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("proxy config: %w", err)
+	}
+	if len(rules) == 0 {
+		return "proxy-out", []interface{}{po}, nil, nil
+	}
+	if err := ValidateProxyRules(rules); err != nil {
+		return "", nil, nil, err
+	}
+
+	outbounds = []interface{}{
+		po,
+		map[string]interface{}{"tag": "direct", "protocol": "freedom"},
+		map[string]interface{}{
+			"tag":      relayDialTag,
+			"protocol": "loopback",
+			"settings": map[string]interface{}{"inboundTag": relayDialTag},
+		},
+	}
+	for _, r := range rules {
+		out := "proxy-out"
+		if r.Action == ProxyActionDirect {
+			out = "direct"
+		}
+		base := map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []string{relayDialTag},
+			"outboundTag": out,
+		}
+		if len(r.Domains) == 0 && len(r.CIDRs) == 0 {
+			routes = append(routes, base)
+			continue
+		}
+		// All conditions of an Xray routing rule must match, so a rule with
+		// both domains and CIDRs becomes two routing rules in its place.
+		if len(r.Domains) > 0 {
+			domains := make([]string, len(r.Domains))
+			for i, d := range r.Domains {
+				domains[i] = "domain:" + strings.ToLower(d)
+			}
+			rule := copyRule(base)
+			rule["domain"] = domains
+			routes = append(routes, rule)
+		}
+		if len(r.CIDRs) > 0 {
+			rule := copyRule(base)
+			rule["ip"] = r.CIDRs
+			routes = append(routes, rule)
+		}
+	}
+	// Anything the rules don't match keeps using the proxy.
+	routes = append(routes, map[string]interface{}{
+		"type":        "field",
+		"inboundTag":  []string{relayDialTag},
+		"outboundTag": "proxy-out",
+	})
+	return relayDialTag, outbounds, routes, nil
+}
+
+func copyRule(r map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(r)+1)
+	for k, v := range r {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,62 @@
+package xray
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tunnelwhisperer/tw/internal/config"
+)
+
+// ExportClientConfig returns a standalone Xray JSON config for a client
+// that runs Xray itself, as v2rayN, v2rayNG or Nekoray do with a custom
+// config: the client config tw connect runs, its dokodemo-door listening
+// on 127.0.0.1:ClientListenPort, without a proxy and logging warnings.
+func ExportClientConfig(cfg config.XrayConfig, clientCfg config.ClientConfig) ([]byte, error) {
+	xc, err := clientConfig(cfg, clientCfg, "", nil, ClientListenPort)
+	if err != nil {
+		return nil, err
+	}
+	xc.Log.LogLevel = "warning"
+	return json.MarshalIndent(xc, "", "  ")
+}
+
+// ShareLink returns the VLESS share link (vless://...) of the relay
+// connection, as proxy apps import it, named remark. Through it, the
+// server's SSH port is 127.0.0.1:server_ssh_port on the relay's side.
+func ShareLink(cfg config.XrayConfig, remark string) (string, error) {
+	if err := ValidateTransport(cfg.Transport); err != nil {
+		return "", err
+	}
+	if err := ValidateTLS(cfg.TLS); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("encryption", "none")
+	q.Set("security", "tls")
+	q.Set("sni", cfg.RelayHost)
+	if cfg.TLS.Fingerprint != "" {
+		q.Set("fp", cfg.TLS.Fingerprint)
+	}
+	if len(cfg.TLS.ALPN) > 0 {
+		q.Set("alpn", strings.Join(cfg.TLS.ALPN, ","))
+	}
+	if cfg.Transport == TransportWS {
+		q.Set("type", TransportWS)
+	} else {
+		q.Set("type", TransportSplitHTTP)
+	}
+	q.Set("host", cfg.RelayHost)
+	q.Set("path", cfg.Path)
+
+	u := url.URL{
+		Scheme:   "vless",
+		User:     url.User(cfg.UUID),
+		Host:     net.JoinHostPort(cfg.RelayHost, strconv.Itoa(cfg.RelayPort)),
+		RawQuery: q.Encode(),
+		Fragment: remark,
+	}
+	return u.String(), nil
+}
@@ -6,9 +6,11 @@ import (
 	"log/slog"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/tunnelwhisperer/tw/internal/config"
 	"github.com/tunnelwhisperer/tw/internal/logging"
+	"github.com/tunnelwhisperer/tw/internal/proxyauth"
 	"github.com/xtls/xray-core/core"
 	_ "github.com/xtls/xray-core/main/distro/all"
 )
@@ -20,6 +22,7 @@ const ClientListenPort = 54001
 type Instance struct {
 	instance *core.Instance
 	cfg      config.XrayConfig
+	shim     *proxyauth.Shim // NTLM proxy shim, when proxy is ntlm://
 }
 
 // xrayConfig mirrors the Xray JSON configuration structure.
@@ -39,16 +42,116 @@ type xrayLog struct {
 	LogLevel string `json:"loglevel"`
 }
 
+// Transports for xray.transport. splitHTTP is the default; WebSocket is the
+// transport CDNs such as Cloudflare proxy reliably.
+const (
+	TransportSplitHTTP = "splithttp"
+	TransportWS        = "ws"
+)
+
+// ValidateTransport checks xray.transport.
+func ValidateTransport(t string) error {
+	if t != "" && t != TransportSplitHTTP && t != TransportWS {
+		return fmt.Errorf("unknown transport %q (use %s or %s)", t, TransportSplitHTTP, TransportWS)
+	}
+	return nil
+}
+
+// Fingerprints are the uTLS client hellos accepted in xray.tls.fingerprint.
+var Fingerprints = []string{"chrome", "firefox", "safari", "edge", "ios", "android", "random", "randomized"}
+
+// ALPNProtocols are the values accepted in xray.tls.alpn. The relay's Caddy
+// serves the splitHTTP transport over either.
+var ALPNProtocols = []string{"h2", "http/1.1"}
+
+// ValidateTLS checks the xray.tls settings.
+func ValidateTLS(t config.TLSConfig) error {
+	if t.Fingerprint != "" && !contains(Fingerprints, t.Fingerprint) {
+		return fmt.Errorf("unknown TLS fingerprint %q (use one of: %s)", t.Fingerprint, strings.Join(Fingerprints, ", "))
+	}
+	for _, p := range t.ALPN {
+		if !contains(ALPNProtocols, p) {
+			return fmt.Errorf("unsupported ALPN protocol %q (use one of: %s)", p, strings.Join(ALPNProtocols, ", "))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsSettings returns the tlsSettings block for the relay connection.
+// allowInsecure is always written as false so certificate checks can't be
+// turned off from a hand-edited config.
+func tlsSettings(cfg config.XrayConfig) (map[string]interface{}, error) {
+	if err := ValidateTLS(cfg.TLS); err != nil {
+		return nil, err
+	}
+	ts := map[string]interface{}{
+		"serverName":    cfg.RelayHost,
+		"allowInsecure": false,
+	}
+	if cfg.TLS.Fingerprint != "" {
+		ts["fingerprint"] = cfg.TLS.Fingerprint
+	}
+	if len(cfg.TLS.ALPN) > 0 {
+		ts["alpn"] = cfg.TLS.ALPN
+	}
+	return ts, nil
+}
+
+// streamSettings returns the transport and TLS settings for the relay
+// connection. TLS always uses relay_host as SNI, and the WebSocket Host
+// header is set to it too, so a CDN routes the request correctly even when
+// the connection goes to another address.
+func streamSettings(cfg config.XrayConfig) (map[string]interface{}, error) {
+	if err := ValidateTransport(cfg.Transport); err != nil {
+		return nil, err
+	}
+	ts, err := tlsSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ss := map[string]interface{}{
+		"security":    "tls",
+		"tlsSettings": ts,
+	}
+	if cfg.Transport == TransportWS {
+		ss["network"] = TransportWS
+		ss["wsSettings"] = map[string]interface{}{
+			"path":    cfg.Path,
+			"headers": map[string]interface{}{"Host": cfg.RelayHost},
+		}
+	} else {
+		ss["network"] = TransportSplitHTTP
+		ss["splithttpSettings"] = map[string]interface{}{
+			"path": cfg.Path,
+		}
+	}
+	return ss, nil
+}
+
 // vlessOutbound returns the VLESS outbound config block (shared by server and client).
-// If proxyURL is non-empty, adds proxySettings to route through the proxy outbound.
-func vlessOutbound(cfg config.XrayConfig, proxyURL string) map[string]interface{} {
+// It connects to address, normally relay_host. If dialer is non-empty, the
+// connection is dialed through the outbound with that tag (see proxyRouting).
+func vlessOutbound(cfg config.XrayConfig, address, dialer string) (map[string]interface{}, error) {
+	ss, err := streamSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
 	out := map[string]interface{}{
 		"tag":      "to-relay",
 		"protocol": "vless",
 		"settings": map[string]interface{}{
 			"vnext": []map[string]interface{}{
 				{
-					"address": cfg.RelayHost,
+					"address": address,
 					"port":    cfg.RelayPort,
 					"users": []map[string]interface{}{
 						{
@@ -59,24 +162,14 @@ func vlessOutbound(cfg config.XrayConfig, proxyURL string) map[string]interface{
 				},
 			},
 		},
-		"streamSettings": map[string]interface{}{
-			"network":  "splithttp",
-			"security": "tls",
-			"tlsSettings": map[string]interface{}{
-				"serverName": cfg.RelayHost,
-			},
-			"splithttpSettings": map[string]interface{}{
-				"path": cfg.Path,
-			},
-		},
+		"streamSettings": ss,
 	}
-	if proxyURL != "" {
-		ss := out["streamSettings"].(map[string]interface{})
+	if dialer != "" {
 		ss["sockopt"] = map[string]interface{}{
-			"dialerProxy": "proxy-out",
+			"dialerProxy": dialer,
 		}
 	}
-	return out
+	return out, nil
 }
 
 // proxyOutbound parses a proxy URL and returns an Xray outbound config block.
@@ -130,20 +223,21 @@ func proxyOutbound(proxyURL string) (map[string]interface{}, error) {
 
 // buildServerConfig generates the server-side Xray JSON config.
 // dokodemo-door listens on sshPort+1 and forwards to the relay's SSH port.
-func buildServerConfig(cfg config.XrayConfig, sshPort, relaySSHPort int, proxyURL string) ([]byte, error) {
+func buildServerConfig(cfg config.XrayConfig, sshPort, relaySSHPort int, proxyURL string, rules []config.ProxyRule) ([]byte, error) {
 	listenPort := sshPort + 1
 
-	outbounds := []interface{}{vlessOutbound(cfg, proxyURL)}
-	if proxyURL != "" {
-		po, err := proxyOutbound(proxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("proxy config: %w", err)
-		}
-		outbounds = append(outbounds, po)
+	dialer, proxyOutbounds, proxyRoutes, err := proxyRouting(proxyURL, rules)
+	if err != nil {
+		return nil, err
 	}
+	vless, err := vlessOutbound(cfg, ServerRelayAddress(cfg), dialer)
+	if err != nil {
+		return nil, fmt.Errorf("outbound config: %w", err)
+	}
+	outbounds := append([]interface{}{vless}, proxyOutbounds...)
 
 	xc := xrayConfig{
-		Log: xrayLog{Access: "none", LogLevel: logging.XrayLevel},
+		Log: xrayLog{Access: "none", LogLevel: xrayConfigLevel},
 		Inbounds: []interface{}{
 			map[string]interface{}{
 				"tag":      "ssh-in",
@@ -159,30 +253,53 @@ func buildServerConfig(cfg config.XrayConfig, sshPort, relaySSHPort int, proxyUR
 		},
 		Outbounds: outbounds,
 	}
+	if len(proxyRoutes) > 0 {
+		xc.Routing = &xrayRouting{Rules: proxyRoutes}
+	}
 
 	return json.MarshalIndent(xc, "", "  ")
 }
 
+// ServerRelayAddress is the address the server dials for the relay:
+// origin_ip when the relay sits behind a CDN, otherwise relay_host.
+func ServerRelayAddress(cfg config.XrayConfig) string {
+	if cfg.OriginIP != "" {
+		return cfg.OriginIP
+	}
+	return cfg.RelayHost
+}
+
 // buildClientConfig generates the client-side Xray JSON config.
-// dokodemo-door listens on ClientListenPort and forwards to the server's SSH
+// dokodemo-door listens on listenPort and forwards to the server's SSH
 // port on the relay (exposed via reverse tunnel).
-func buildClientConfig(cfg config.XrayConfig, clientCfg config.ClientConfig, proxyURL string) ([]byte, error) {
-	outbounds := []interface{}{vlessOutbound(cfg, proxyURL)}
-	if proxyURL != "" {
-		po, err := proxyOutbound(proxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("proxy config: %w", err)
-		}
-		outbounds = append(outbounds, po)
+func buildClientConfig(cfg config.XrayConfig, clientCfg config.ClientConfig, proxyURL string, rules []config.ProxyRule, listenPort int) ([]byte, error) {
+	xc, err := clientConfig(cfg, clientCfg, proxyURL, rules, listenPort)
+	if err != nil {
+		return nil, err
 	}
+	return json.MarshalIndent(xc, "", "  ")
+}
+
+// clientConfig returns the client-side Xray config buildClientConfig
+// writes.
+func clientConfig(cfg config.XrayConfig, clientCfg config.ClientConfig, proxyURL string, rules []config.ProxyRule, listenPort int) (*xrayConfig, error) {
+	dialer, proxyOutbounds, proxyRoutes, err := proxyRouting(proxyURL, rules)
+	if err != nil {
+		return nil, err
+	}
+	vless, err := vlessOutbound(cfg, cfg.RelayHost, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("outbound config: %w", err)
+	}
+	outbounds := append([]interface{}{vless}, proxyOutbounds...)
 
 	xc := xrayConfig{
-		Log: xrayLog{Access: "none", LogLevel: logging.XrayLevel},
+		Log: xrayLog{Access: "none", LogLevel: xrayConfigLevel},
 		Inbounds: []interface{}{
 			map[string]interface{}{
 				"tag":      "ssh-local",
 				"listen":   "127.0.0.1",
-				"port":     ClientListenPort,
+				"port":     listenPort,
 				"protocol": "dokodemo-door",
 				"settings": map[string]interface{}{
 					"network": "tcp",
@@ -202,8 +319,10 @@ func buildClientConfig(cfg config.XrayConfig, clientCfg config.ClientConfig, pro
 			},
 		},
 	}
-
-	return json.MarshalIndent(xc, "", "  ")
+	if len(proxyRoutes) > 0 {
+		xc.Routing.Rules = append(xc.Routing.Rules, proxyRoutes...)
+	}
+	return &xc, nil
 }
 
 // New creates a new Xray instance for server mode.
@@ -219,16 +338,23 @@ func New(cfg config.XrayConfig) (*Instance, error) {
 }
 
 // Start builds the server JSON config and starts the xray-core instance.
-func (x *Instance) Start(sshPort, relaySSHPort int, proxyURL string) error {
-	configBytes, err := buildServerConfig(x.cfg, sshPort, relaySSHPort, proxyURL)
+// proxyRules decide when the relay connection uses proxyURL.
+func (x *Instance) Start(sshPort, relaySSHPort int, proxyURL string, proxyRules []config.ProxyRule) error {
+	proxyURL, err := x.startProxyShim(proxyURL)
+	if err != nil {
+		return err
+	}
+	configBytes, err := buildServerConfig(x.cfg, sshPort, relaySSHPort, proxyURL, proxyRules)
 	if err != nil {
+		x.closeProxyShim()
 		return fmt.Errorf("xray: building config: %w", err)
 	}
 
-	slog.Info("Xray starting", "relay", fmt.Sprintf("%s:%d", x.cfg.RelayHost, x.cfg.RelayPort), "path", x.cfg.Path, "proxy", proxyURL, "xray_log_level", logging.XrayLevel)
+	slog.Info("Xray starting", "relay", fmt.Sprintf("%s:%d", ServerRelayAddress(x.cfg), x.cfg.RelayPort), "transport", x.cfg.Transport, "path", x.cfg.Path, "proxy", proxyURL, "xray_log_level", logging.XrayLevel())
 
 	instance, err := core.StartInstance("json", configBytes)
 	if err != nil {
+		x.closeProxyShim()
 		return fmt.Errorf("xray: starting instance: %w", err)
 	}
 
@@ -250,16 +376,29 @@ func NewClient(cfg config.XrayConfig) (*Instance, error) {
 }
 
 // StartClient builds the client JSON config and starts the xray-core instance.
-func (x *Instance) StartClient(clientCfg config.ClientConfig, proxyURL string) error {
-	configBytes, err := buildClientConfig(x.cfg, clientCfg, proxyURL)
+// proxyRules decide when the relay connection uses proxyURL.
+func (x *Instance) StartClient(clientCfg config.ClientConfig, proxyURL string, proxyRules []config.ProxyRule) error {
+	return x.StartClientOn(ClientListenPort, clientCfg, proxyURL, proxyRules)
+}
+
+// StartClientOn is StartClient with the local SSH entry point on listenPort,
+// so a temporary instance can run next to a connected client.
+func (x *Instance) StartClientOn(listenPort int, clientCfg config.ClientConfig, proxyURL string, proxyRules []config.ProxyRule) error {
+	proxyURL, err := x.startProxyShim(proxyURL)
 	if err != nil {
+		return err
+	}
+	configBytes, err := buildClientConfig(x.cfg, clientCfg, proxyURL, proxyRules, listenPort)
+	if err != nil {
+		x.closeProxyShim()
 		return fmt.Errorf("xray: building client config: %w", err)
 	}
 
-	slog.Info("Xray client starting", "relay", fmt.Sprintf("%s:%d", x.cfg.RelayHost, x.cfg.RelayPort), "path", x.cfg.Path, "proxy", proxyURL, "xray_log_level", logging.XrayLevel)
+	slog.Info("Xray client starting", "relay", fmt.Sprintf("%s:%d", x.cfg.RelayHost, x.cfg.RelayPort), "path", x.cfg.Path, "proxy", proxyURL, "xray_log_level", logging.XrayLevel())
 
 	instance, err := core.StartInstance("json", configBytes)
 	if err != nil {
+		x.closeProxyShim()
 		return fmt.Errorf("xray: starting client instance: %w", err)
 	}
 
@@ -273,8 +412,31 @@ func (x *Instance) Running() bool {
 	return x != nil && x.instance != nil
 }
 
+// startProxyShim starts the local NTLM shim for an ntlm:// proxy and
+// returns the http:// URL Xray should chain through instead. Other proxy
+// URLs are returned unchanged.
+func (x *Instance) startProxyShim(proxyURL string) (string, error) {
+	if !proxyauth.IsNTLM(proxyURL) {
+		return proxyURL, nil
+	}
+	shim, err := proxyauth.Start(proxyURL)
+	if err != nil {
+		return "", fmt.Errorf("xray: %w", err)
+	}
+	x.shim = shim
+	return shim.URL(), nil
+}
+
+func (x *Instance) closeProxyShim() {
+	if x.shim != nil {
+		x.shim.Close()
+		x.shim = nil
+	}
+}
+
 // Close shuts down the xray-core instance.
 func (x *Instance) Close() error {
+	defer x.closeProxyShim()
 	if x.instance != nil {
 		err := x.instance.Close()
 		x.instance = nil